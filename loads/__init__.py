@@ -2,6 +2,7 @@ import pkg_resources
 
 from loads import _patch  # NOQA
 from loads.case import TestCase  # NOQA
+from loads.engines import Engine, register_engine  # NOQA
 
 
 __version__ = pkg_resources.get_distribution('loads').version
@@ -0,0 +1,462 @@
+"""The authentication of the requests, as Requests' auths: they get their
+tokens, keep them, and renew them before they expire::
+
+    def setUp(self):
+        self.session.auth = OAuth2('https://auth/token', 'loads', 'secret',
+                                   scope='read', test_case=self)
+
+The tokens are kept per virtual user, or by all the users of the process
+with *shared*. The requests to the token endpoint are not hits of the
+test, unless *record* is set: they are then reported as the hits of the
+*auth* scenario, apart from the ones of the test.
+
+:class:`SigV4` signs the requests for AWS -- API Gateway, S3 or the
+S3-compatible endpoints.
+"""
+import base64
+import datetime
+import hashlib
+import hmac
+import json
+import os
+import threading
+import time
+import urllib
+import urlparse
+
+from requests.auth import AuthBase
+from requests.sessions import Session
+
+from loads.util import parse_timestamp, total_seconds
+
+
+GRANTS = ('client_credentials', 'password')
+_DIGESTS = {'HS256': hashlib.sha256, 'HS384': hashlib.sha384,
+            'HS512': hashlib.sha512}
+
+# the tokens shared by the users of the process, and the locks that let
+# a single user fetch each of them
+_SHARED = {}
+_LOCKS = {}
+_LOCK = threading.Lock()
+
+
+class AuthError(Exception):
+    pass
+
+
+class Token(object):
+    """A token, and when it expires."""
+    def __init__(self, access_token, token_type='Bearer', expires_at=None,
+                 refresh_token=None):
+        self.access_token = access_token
+        self.token_type = token_type
+        self.expires_at = expires_at
+        self.refresh_token = refresh_token
+
+    def expires_in(self, clock=time.time):
+        if self.expires_at is None:
+            return None
+        return self.expires_at - clock()
+
+    @property
+    def header(self):
+        token_type = self.token_type or 'Bearer'
+        # some servers return "bearer"
+        if token_type.lower() == 'bearer':
+            token_type = 'Bearer'
+        return '%s %s' % (token_type, self.access_token)
+
+
+class _TokenAuth(AuthBase):
+    """Sets the token in the *header* of the requests, and gets a new one
+    when it's about to expire -- *margin* seconds before."""
+    def __init__(self, header='Authorization', margin=30., shared=False,
+                 clock=time.time):
+        self.header = header
+        self.margin = margin
+        self.shared = shared
+        self.clock = clock
+        self._token = None
+
+    def _key(self):
+        raise NotImplementedError()
+
+    def _create_token(self, token):
+        """Returns a new token -- *token* is the one that expires, or
+        None."""
+        raise NotImplementedError()
+
+    def _valid(self, token):
+        if token is None:
+            return False
+        expires_in = token.expires_in(self.clock)
+        return expires_in is None or expires_in > self.margin
+
+    @property
+    def token(self):
+        if not self.shared:
+            if not self._valid(self._token):
+                self._token = self._create_token(self._token)
+            return self._token
+
+        key = self._key()
+        token = _SHARED.get(key)
+        if self._valid(token):
+            return token
+        with _LOCK:
+            lock = _LOCKS.setdefault(key, threading.Lock())
+        with lock:
+            # another user may have renewed it meanwhile
+            token = _SHARED.get(key)
+            if not self._valid(token):
+                token = _SHARED[key] = self._create_token(token)
+        return token
+
+    def invalidate(self):
+        """Forgets the token, so the next request gets a new one."""
+        self._token = None
+        if self.shared:
+            _SHARED.pop(self._key(), None)
+
+    def _handle_401(self, response, **kwargs):
+        if response.status_code == 401:
+            self.invalidate()
+        return response
+
+    def __call__(self, request):
+        request.headers[self.header] = self.token.header
+        request.register_hook('response', self._handle_401)
+        return request
+
+
+class OAuth2(_TokenAuth):
+    """Gets its tokens from the *token_url* of an OAuth2 server, with the
+    *client_credentials* grant, or the *password* one when a *username*
+    is given. The tokens are renewed with their refresh token when they
+    have one.
+
+    :param client_auth: how the client authenticates: with *basic* auth,
+                        or with its credentials in the *body*.
+    :param record: when True, the requests to the token endpoint are hits
+                   of the *tag* scenario.
+    :param test_case: the test the tokens are for.
+    """
+    def __init__(self, token_url, client_id, client_secret=None,
+                 username=None, password=None, scope=None,
+                 client_auth='basic', record=False, tag='auth',
+                 test_case=None, session=None, timeout=30, **options):
+        super(OAuth2, self).__init__(**options)
+        if client_auth not in ('basic', 'body'):
+            raise ValueError('Unknown client authentication %r' %
+                             client_auth)
+        self.token_url = token_url
+        self.client_id = client_id
+        self.client_secret = client_secret
+        self.username = username
+        self.password = password
+        if isinstance(scope, (list, tuple)):
+            scope = ' '.join(scope)
+        self.scope = scope
+        self.client_auth = client_auth
+        self.record = record
+        self.tag = tag
+        self.test_case = test_case
+        self.timeout = timeout
+        # not the session of the test, so the requests are not measured
+        self.session = session or Session()
+
+    @property
+    def grant(self):
+        if self.username is not None:
+            return 'password'
+        return 'client_credentials'
+
+    def _key(self):
+        return (self.token_url, self.client_id, self.grant, self.username,
+                self.scope)
+
+    def _create_token(self, token):
+        if token is not None and token.refresh_token:
+            try:
+                return self._request({'grant_type': 'refresh_token',
+                                      'refresh_token': token.refresh_token})
+            except AuthError:
+                # the refresh token expired too
+                pass
+
+        data = {'grant_type': self.grant}
+        if self.grant == 'password':
+            data['username'] = self.username
+            data['password'] = self.password
+        if self.scope:
+            data['scope'] = self.scope
+        return self._request(data)
+
+    def _request(self, data):
+        auth = None
+        if self.client_auth == 'basic':
+            auth = (self.client_id, self.client_secret or '')
+        else:
+            data['client_id'] = self.client_id
+            if self.client_secret is not None:
+                data['client_secret'] = self.client_secret
+
+        started = datetime.datetime.utcnow()
+        response = self.session.post(self.token_url, data=data, auth=auth,
+                                     headers={'Accept': 'application/json'},
+                                     timeout=self.timeout)
+        self._add_hit(response, started)
+        if response.status_code != 200:
+            raise AuthError('%s returned %d: %s' % (
+                self.token_url, response.status_code, response.text[:200]))
+        try:
+            values = response.json()
+            access_token = values['access_token']
+        except (ValueError, KeyError):
+            raise AuthError('%s returned no token' % self.token_url)
+
+        expires_at = None
+        if values.get('expires_in') is not None:
+            expires_at = self.clock() + float(values['expires_in'])
+        return Token(access_token, values.get('token_type'), expires_at,
+                     values.get('refresh_token'))
+
+    def _add_hit(self, response, started):
+        if not self.record or self.test_case is None:
+            return
+        test_result = self.test_case._test_result
+        if test_result is None:
+            return
+        test_result.add_hit(elapsed=total_seconds(response.elapsed),
+                            started=started, status=response.status_code,
+                            url=self.token_url, method='POST',
+                            loads_status=self.test_case._loads_status,
+                            scenario=self.tag)
+
+
+def _encode(value):
+    return base64.urlsafe_b64encode(value).rstrip('=')
+
+
+def encode_jwt(claims, secret, algorithm='HS256', headers=None):
+    """Returns a signed JSON Web Token. The HMAC algorithms are built in,
+    the others need `PyJWT <http://pypi.python.org/pypi/PyJWT>`_."""
+    if algorithm not in _DIGESTS:
+        try:
+            import jwt
+        except ImportError:
+            raise ValueError('%s needs PyJWT' % algorithm)
+        return jwt.encode(claims, secret, algorithm=algorithm,
+                          headers=headers)
+
+    header = {'alg': algorithm, 'typ': 'JWT'}
+    header.update(headers or {})
+    segments = [_encode(json.dumps(header, separators=(',', ':'))),
+                _encode(json.dumps(claims, separators=(',', ':')))]
+    signature = hmac.new(secret, '.'.join(segments), _DIGESTS[algorithm])
+    segments.append(_encode(signature.digest()))
+    return '.'.join(segments)
+
+
+class JWT(_TokenAuth):
+    """Signs its own tokens, with the *claims* -- a dict, or a function of
+    the test case returning one. Every token is valid *lifetime* seconds,
+    with the *iat* and *exp* claims set.
+    """
+    def __init__(self, secret, claims=None, algorithm='HS256',
+                 lifetime=300, headers=None, test_case=None, **options):
+        super(JWT, self).__init__(**options)
+        self.secret = secret
+        self.claims = claims or {}
+        self.algorithm = algorithm
+        self.lifetime = lifetime
+        self.headers = headers
+        self.test_case = test_case
+
+    def _key(self):
+        return (self.secret, self.algorithm, id(self.claims))
+
+    def _create_token(self, token):
+        claims = self.claims
+        if callable(claims):
+            claims = claims(self.test_case)
+        claims = dict(claims)
+        now = self.clock()
+        claims.setdefault('iat', int(now))
+        claims.setdefault('exp', int(now + self.lifetime))
+        return Token(encode_jwt(claims, self.secret, self.algorithm,
+                                self.headers), expires_at=claims['exp'])
+
+
+class AWSCredentials(object):
+    """The credentials of AWS: the given ones, or the ones of the
+    environment, or the ones of the role of the instance -- renewed
+    before they expire."""
+    metadata_url = 'http://169.254.169.254/latest'
+
+    def __init__(self, access_key=None, secret_key=None, session_token=None,
+                 margin=300., clock=time.time, session=None, timeout=2):
+        if access_key is None:
+            access_key = os.environ.get('AWS_ACCESS_KEY_ID')
+            secret_key = os.environ.get('AWS_SECRET_ACCESS_KEY')
+            session_token = os.environ.get('AWS_SESSION_TOKEN')
+        self.access_key = access_key
+        self.secret_key = secret_key
+        self.session_token = session_token
+        self.margin = margin
+        self.clock = clock
+        self.session = session or Session()
+        self.timeout = timeout
+        self.expires_at = None
+        self.from_role = access_key is None
+        self._lock = threading.Lock()
+
+    def get(self):
+        """Returns the access key, the secret key and the session token."""
+        if self.from_role:
+            with self._lock:
+                if (self.expires_at is None or
+                        self.expires_at - self.clock() < self.margin):
+                    self._load_role()
+        return self.access_key, self.secret_key, self.session_token
+
+    def _metadata(self, path, token):
+        response = self.session.get(
+            '%s/meta-data/iam/security-credentials/%s' % (self.metadata_url,
+                                                         path),
+            headers={'X-aws-ec2-metadata-token': token},
+            timeout=self.timeout)
+        if response.status_code != 200:
+            raise AuthError('No role for the instance: %d' %
+                            response.status_code)
+        return response
+
+    def _load_role(self):
+        # IMDSv2: a session token first
+        response = self.session.put(
+            self.metadata_url + '/api/token', timeout=self.timeout,
+            headers={'X-aws-ec2-metadata-token-ttl-seconds': '21600'})
+        if response.status_code != 200:
+            raise AuthError('No AWS credentials: not in the environment, '
+                            'and no instance metadata')
+        token = response.text
+        role = self._metadata('', token).text.strip().split('\n')[0]
+        values = self._metadata(role, token).json()
+        self.access_key = values['AccessKeyId']
+        self.secret_key = values['SecretAccessKey']
+        self.session_token = values.get('Token')
+        self.expires_at = parse_timestamp(values['Expiration'])
+
+
+def _quote(value, safe='-_.~'):
+    if isinstance(value, unicode):
+        value = value.encode('utf8')
+    return urllib.quote(value, safe=safe)
+
+
+def _sign(key, message):
+    return hmac.new(key, message.encode('utf8'), hashlib.sha256).digest()
+
+
+class SigV4(AuthBase):
+    """Signs the requests with AWS Signature Version 4, for the *service*
+    -- *execute-api*, *s3*... -- of the *region*: the one of the
+    *AWS_REGION* or *AWS_DEFAULT_REGION* variables by default.
+
+    :param credentials: the :class:`AWSCredentials`, found in the
+                        environment or in the instance role by default.
+    :param unsigned_payload: when True, the bodies are not hashed -- S3
+                             accepts it.
+    """
+    def __init__(self, service, region=None, credentials=None,
+                 unsigned_payload=False, clock=time.time):
+        if region is None:
+            region = (os.environ.get('AWS_REGION') or
+                      os.environ.get('AWS_DEFAULT_REGION') or 'us-east-1')
+        self.service = service
+        self.region = region
+        self.credentials = credentials or AWSCredentials(clock=clock)
+        self.unsigned_payload = unsigned_payload
+        self.clock = clock
+
+    def _payload_hash(self, request):
+        body = request.body
+        if self.unsigned_payload or hasattr(body, 'read'):
+            return 'UNSIGNED-PAYLOAD'
+        if body is None:
+            body = ''
+        if isinstance(body, unicode):
+            body = body.encode('utf8')
+        return hashlib.sha256(body).hexdigest()
+
+    def _canonical_uri(self, path):
+        if self.service == 's3':
+            # S3 signs the path as it is sent
+            return path or '/'
+        segments = []
+        for segment in (path or '/').split('/'):
+            if segment == '..':
+                if segments:
+                    segments.pop()
+            elif segment != '.':
+                segments.append(segment)
+        path = '/'.join(segments)
+        if not path.startswith('/'):
+            path = '/' + path
+        return _quote(path, safe='/~')
+
+    def _canonical_query(self, query):
+        pairs = [(_quote(urllib.unquote(key)), _quote(urllib.unquote(value)))
+                 for key, value in urlparse.parse_qsl(
+                     query, keep_blank_values=True)]
+        return '&'.join(['%s=%s' % pair for pair in sorted(pairs)])
+
+    def signature(self, request, timestamp=None):
+        """Sets the headers of the signature of the request, and returns
+        the *Authorization* one."""
+        access_key, secret_key, session_token = self.credentials.get()
+        if access_key is None or secret_key is None:
+            raise AuthError('No AWS credentials')
+        if timestamp is None:
+            timestamp = self.clock()
+        now = time.gmtime(timestamp)
+        amz_date = time.strftime('%Y%m%dT%H%M%SZ', now)
+        date = amz_date[:8]
+
+        url = urlparse.urlsplit(request.url)
+        payload_hash = self._payload_hash(request)
+        request.headers['Host'] = url.netloc
+        request.headers['X-Amz-Date'] = amz_date
+        if self.service == 's3':
+            request.headers['X-Amz-Content-SHA256'] = payload_hash
+        if session_token:
+            request.headers['X-Amz-Security-Token'] = session_token
+
+        headers = dict([(name.lower(), ' '.join(str(value).split()))
+                        for name, value in request.headers.items()
+                        if name.lower() in ('host', 'content-type',
+                                            'content-md5', 'range') or
+                        name.lower().startswith('x-amz-')])
+        signed_headers = ';'.join(sorted(headers))
+        canonical = '\n'.join([
+            request.method.upper(), self._canonical_uri(url.path),
+            self._canonical_query(url.query),
+            ''.join(['%s:%s\n' % (name, headers[name])
+                     for name in sorted(headers)]),
+            signed_headers, payload_hash])
+
+        scope = '%s/%s/%s/aws4_request' % (date, self.region, self.service)
+        to_sign = '\n'.join(['AWS4-HMAC-SHA256', amz_date, scope,
+                             hashlib.sha256(canonical).hexdigest()])
+        key = _sign(('AWS4' + secret_key).encode('utf8'), date)
+        for part in (self.region, self.service, 'aws4_request'):
+            key = _sign(key, part)
+        signature = hmac.new(key, to_sign, hashlib.sha256).hexdigest()
+        return ('AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, '
+                'Signature=%s' % (access_key, scope, signed_headers,
+                                  signature))
+
+    def __call__(self, request):
+        request.headers['Authorization'] = self.signature(request)
+        return request
@@ -0,0 +1,91 @@
+"""The baselines of the runs: a short calibration run records the 95th
+percentile times and the error rates of every endpoint, and writes them
+in a thresholds file for the next runs -- the ones of a CI -- to be
+evaluated against::
+
+    $ loads-runner example.TestWebSite.test_es -u 10 -d 60 \\
+        --baseline thresholds.txt --baseline-margin 20%
+    $ loads-runner example.TestWebSite.test_es -u 10 -d 60 \\
+        --threshold-file thresholds.txt
+
+- *--baseline FILE*: writes the thresholds of the run in the file, at its
+  end -- the thresholds of the run are still evaluated.
+- *--baseline-margin*: how much slower the next runs can be, in percents
+  of the times -- 20% by default.
+- *--baseline-error-margin*: how many more errors they can have, in
+  percentage points of the hits -- 1% by default.
+
+The file has the thresholds of all the hits first, then the ones of
+every endpoint::
+
+    p95 < 300ms
+    hits_error_rate <= 1%
+    p95 < 120ms @ /items/{id}
+    hits_error_rate <= 1% @ /items/{id}
+
+It can be edited like any thresholds file, and kept with the tests.
+"""
+import math
+import time
+
+from loads.thresholds import get_metric
+
+DEFAULT_MARGIN = 20.
+DEFAULT_ERROR_MARGIN = 1.
+
+
+def parse_margin(value):
+    """Converts a margin like "20%" or "20" in a number of percents."""
+    value = str(value).strip()
+    if value.endswith('%'):
+        value = value[:-1]
+    margin = float(value)
+    if margin < 0:
+        raise ValueError('The margin is not negative')
+    return margin
+
+
+def _format_rate(rate):
+    return ('%.2f' % rate).rstrip('0').rstrip('.')
+
+
+def get_baseline(test_result, margin=DEFAULT_MARGIN,
+                 error_margin=DEFAULT_ERROR_MARGIN):
+    """Returns the threshold expressions of the results: the 95th
+    percentile times plus *margin* percents, and the error rates of the
+    hits plus *error_margin* percentage points -- of all the hits, then of
+    every endpoint."""
+    endpoints = sorted(set([endpoint for endpoint, series
+                            in test_result.histograms]))
+    expressions = []
+    for endpoint in [None] + endpoints:
+        suffix = endpoint is not None and ' @ %s' % endpoint or ''
+        p95 = get_metric(test_result, 'p95', endpoint)
+        if p95 is not None:
+            # rounded up to the next millisecond
+            limit = p95 * 1000 * (1 + margin / 100.)
+            limit = int(math.ceil(round(limit, 6)))
+            expressions.append('p95 < %dms%s' % (max(limit, 1), suffix))
+
+        rate = get_metric(test_result, 'hits_error_rate', endpoint)
+        if rate is not None:
+            rate = min(rate + error_margin, 100.)
+            expressions.append('hits_error_rate <= %s%%%s' % (
+                _format_rate(rate), suffix))
+    return expressions
+
+
+def write_baseline(path, test_result, margin=DEFAULT_MARGIN,
+                   error_margin=DEFAULT_ERROR_MARGIN):
+    """Writes the thresholds of the results in a file, and returns them."""
+    expressions = get_baseline(test_result, margin, error_margin)
+    args = test_result.args or {}
+    with open(path, 'w') as f:
+        f.write('# The baseline of %s, on %s\n' % (
+            args.get('fqn', 'a run'),
+            time.strftime('%Y-%m-%d %H:%M:%S')))
+        f.write('# margins: %s%% of the times, %s%% of errors\n' % (
+            _format_rate(margin), _format_rate(error_margin)))
+        for expression in expressions:
+            f.write(expression + '\n')
+    return expressions
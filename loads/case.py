@@ -1,9 +1,21 @@
+import functools
 import unittest
 
-from requests.adapters import HTTPAdapter
-
+from loads.endpoints import get_endpoints
+from loads.httpcache import get_http_cache
 from loads.measure import Session, TestApp
+from loads.network import get_network
+from loads.pacing import get_think_time
+from loads.pooling import get_pool_options
+from loads.proxies import get_proxy_list
+from loads.qos import get_ip_options
+from loads.ratelimit import LIMITER
+from loads.sockopts import get_socket_options
+from loads.sources import get_source_addresses
 from loads.results import LoadsTestResult, UnitTestTestResult
+from loads.seeds import get_random, next_user
+from loads.tls import get_tls_config
+from loads.tracing import TracedHTTPAdapter
 
 
 class FakeTestApp(object):
@@ -23,6 +35,8 @@ MAX_CON = 1000
 class TestCase(unittest.TestCase):
 
     server_url = None
+    # the options of the connection pools by scenario -- see loads.pooling
+    connection_pools = None
 
     def __init__(self, test_name, test_result=None, config=None):
         super(TestCase, self).__init__(test_name)
@@ -34,10 +48,83 @@ class TestCase(unittest.TestCase):
         self._test_result = test_result
 
         self.session = Session(test=self, test_result=test_result)
-        http_adapter = HTTPAdapter(pool_maxsize=MAX_CON,
-                                   pool_connections=MAX_CON)
+        self.sources = get_source_addresses(config)
+        self.ip_options = get_ip_options(config)
+        self.socket_options = get_socket_options(config)
+        self.pool_options = get_pool_options(config, test_name,
+                                             self.connection_pools)
+        http_adapter = TracedHTTPAdapter(tls=get_tls_config(config),
+                                         sources=self.sources,
+                                         family=config.get('ip_family'),
+                                         ip_options=self.ip_options,
+                                         socket_options=self.socket_options,
+                                         pool=self.pool_options,
+                                         pool_maxsize=MAX_CON,
+                                         pool_connections=MAX_CON)
         self.session.mount('http://', http_adapter)
         self.session.mount('https://', http_adapter)
+        if (self.pool_options is not None and
+                not self.pool_options.keep_alive):
+            self.session.headers['Connection'] = 'close'
+
+        max_redirects = config.get('max_redirects')
+        if max_redirects is not None:
+            self.session.max_redirects = max_redirects
+            self.session.follow_redirects = max_redirects > 0
+
+        accept_encoding = config.get('accept_encoding')
+        if accept_encoding == 'all':
+            from loads.compression import ENCODINGS
+            accept_encoding = ', '.join(ENCODINGS)
+        if accept_encoding:
+            self.session.headers['Accept-Encoding'] = accept_encoding
+
+        self.session.trace_requests = bool(config.get('trace_requests'))
+        self.session.request_id_header = config.get('request_id_header')
+        self.session.server_timing = bool(config.get('server_timing'))
+        self.session.header_metrics = config.get('header_metric') or ()
+        self.session.endpoints = get_endpoints(config)
+        self.session.http_cache = get_http_cache(config)
+        if config.get('plugin'):
+            from loads.plugins import PluginAuth, get_plugins
+            auth_plugins = get_plugins(config, 'auth')
+            if auth_plugins:
+                self.session.auth = PluginAuth(auth_plugins[0])
+        self.session.rate_limiter = LIMITER
+        self.session.family = config.get('ip_family')
+        # the generators of the virtual user, with --seed
+        self._user = next_user()
+        self._feeder_random = get_random(config, 'feeder', self._user)
+        self.session.random = get_random(config, 'templates', self._user)
+        # a virtual user keeps its network conditions
+        self.network = self.session.network = get_network(
+            config, get_random(config, 'network', self._user))
+        self.think_time = self.session.think_time = get_think_time(
+            config, get_random(config, 'think-time', self._user))
+        if config.get('hooks'):
+            from loads.hooks import get_hooks
+            self.session.loads_hooks = get_hooks(config['hooks'])
+
+        self.session.templates = bool(config.get('templates'))
+        if config.get('feeder'):
+            self.session.feeder = functools.partial(
+                self.feed, config['feeder'],
+                config.get('feeder_strategy') or 'round-robin')
+
+        proxies = get_proxy_list(config)
+        if proxies is not None:
+            # a virtual user keeps its proxy
+            proxy = proxies.next(get_random(config, 'proxies', self._user))
+            self.session.proxies = {'http': proxy, 'https': proxy}
+
+        if config.get('http2') or config.get('h2c'):
+            from loads.engines.http2 import HTTP2Adapter, DEFAULT_MAX_STREAMS
+            max_streams = (config.get('http2_max_streams') or
+                           DEFAULT_MAX_STREAMS)
+            http2_adapter = HTTP2Adapter(prior_knowledge=config.get('h2c'),
+                                         max_streams=max_streams)
+            self.session.mount('http://', http2_adapter)
+            self.session.mount('https://', http2_adapter)
 
         if self.server_url is not None:
             self.app = TestApp(self.server_url, self.session, test_result)
@@ -45,13 +132,111 @@ class TestCase(unittest.TestCase):
             self.app = FakeTestApp()
 
         self._ws = []
+        self._clients = []
         self._loads_status = None
 
+        # kept between the runs of the test by the same virtual user
+        self.state = {}
+        # what the setup of the run returned -- see loads.lifecycle
+        self.fixtures = config.get('fixtures') or {}
+
+    def setup_run(self):
+        """Executed once before the load, returns the fixtures of the run
+        -- see :mod:`loads.lifecycle`."""
+        return None
+
+    def teardown_run(self, fixtures):
+        """Executed once after the load, with the fixtures of the run."""
+        pass
+
     def defaultTestResult(self):
         return LoadsTestResult()
 
-    def incr_counter(self, name):
-        self._test_result.incr_counter(self, self._loads_status, name)
+    def reset_session(self):
+        """Forgets the cookies and the state of the virtual user."""
+        self.session.cookies.clear()
+        if self.server_url is not None:
+            self.app.reset()
+        self.state.clear()
+
+    def extract(self, response, into=None, json=None, regex=None,
+                header=None):
+        """Extracts a value from the response with a JSON path, a regular
+        expression or a header name, and stores it in the state when
+        :param into: is given.
+
+        A failed extraction raises an :class:`ExtractionError`, and is
+        counted in the *extraction-errors* custom metric.
+        """
+        from loads import extractors
+        try:
+            if json is not None:
+                value = extractors.json_path(response, json)
+            elif regex is not None:
+                value = extractors.regex(response, regex)
+            elif header is not None:
+                value = extractors.header(response, header)
+            else:
+                raise ValueError('Give a JSON path, a regex or a header')
+        except extractors.ExtractionError:
+            if self._test_result is not None:
+                self.incr_counter('extraction-errors')
+            raise
+
+        if into is not None:
+            self.state[into] = value
+        return value
+
+    def check(self, response, name=None, status=None, contains=None,
+              latency=None, json=None):
+        """Checks the response and reports every check in the results --
+        see :func:`loads.checks.run_checks` for the options. The names of
+        the checks are prefixed by :param name: when it's given.
+
+        Returns True when all the checks passed.
+        """
+        from loads.checks import run_checks
+        results = run_checks(response, status=status, contains=contains,
+                             latency=latency, json=json)
+        for check, passed in results:
+            if name is not None:
+                check = '%s: %s' % (name, check)
+            if self._test_result is not None:
+                self._test_result.add_check(check, passed)
+
+        return all([passed for check, passed in results])
+
+    def think(self, seconds=None):
+        """Pauses for :param seconds:, or for the think time of the run
+        -- see :mod:`loads.pacing`."""
+        if seconds is not None:
+            import gevent
+            gevent.sleep(seconds)
+        elif self.think_time is not None:
+            self.think_time.wait()
+
+    def incr_counter(self, name, value=1):
+        self._test_result.incr_counter(self, self._loads_status, name,
+                                       value=value)
+
+    def set_gauge(self, name, value):
+        """Sets the value of a custom gauge -- see :mod:`loads.custom`."""
+        self._test_result.set_gauge(name, value)
+
+    def add_trend(self, name, value):
+        """Adds a value to a custom trend -- see :mod:`loads.custom`."""
+        self._test_result.add_trend(name, value)
+
+    def next_id(self, name='default'):
+        """Returns the next id of a sequence, unique in the whole run --
+        see :mod:`loads.sequences`."""
+        from loads.sequences import get_sequence
+        return get_sequence(name, self.config).next()
+
+    def feed(self, filename, strategy='round-robin', format=None):
+        from loads.feeders import get_feeder
+        feeder = get_feeder(filename, strategy, format, self.config)
+        return feeder.next(user=id(self), rand=self._feeder_random)
 
     def create_ws(self, url, callback=None, protocols=None, extensions=None,
                   klass=None):
@@ -65,11 +250,90 @@ class TestCase(unittest.TestCase):
         self._ws.append(ws)
         return ws
 
+    def create_grpc(self, target, descriptor_set, secure=False,
+                    credentials=None, options=None):
+        from loads.engines.grpc import GRPCClient
+        client = GRPCClient(target, descriptor_set, self._test_result,
+                            test_case=self, secure=secure,
+                            credentials=credentials, options=options)
+        self._clients.append(client)
+        return client
+
+    def create_graphql(self, url, headers=None):
+        """Returns a client of a GraphQL endpoint -- see
+        :mod:`loads.graphql`."""
+        from loads.graphql import GraphQLClient
+        return GraphQLClient(self.session, url, headers=headers)
+
+    def create_sse(self, url, **options):
+        """Returns a client of a Server-Sent Events stream -- see
+        :class:`loads.engines.sse.SSEClient`."""
+        from loads.engines.sse import SSEClient
+        client = SSEClient(url, self.session, **options)
+        self._clients.append(client)
+        return client
+
+    def create_dns(self, server, port=None, **options):
+        from loads.engines.dns import DNSClient
+        client = DNSClient(server, port, test_result=self._test_result,
+                           test_case=self, **options)
+        self._clients.append(client)
+        return client
+
+    def create_tcp(self, host, port, **options):
+        from loads.engines.tcp import TCPClient
+        options.setdefault('network', self.network)
+        if self.sources is not None:
+            options.setdefault('source_address', (self.sources.next(), 0))
+        options.setdefault('ip_family', self.config.get('ip_family'))
+        options.setdefault('ip_options', self.ip_options)
+        options.setdefault('socket_options', self.socket_options)
+        client = TCPClient(host, port, self._test_result, test_case=self,
+                           **options)
+        self._clients.append(client)
+        return client
+
+    def create_udp(self, host, port, **options):
+        from loads.engines.udp import UDPClient
+        options.setdefault('network', self.network)
+        options.setdefault('ip_family', self.config.get('ip_family'))
+        options.setdefault('ip_options', self.ip_options)
+        options.setdefault('socket_options', self.socket_options)
+        client = UDPClient(host, port, self._test_result, test_case=self,
+                           **options)
+        self._clients.append(client)
+        return client
+
+    def create_mqtt(self, host, port=1883, **options):
+        from loads.engines.mqtt import MQTTClient
+        client = MQTTClient(host, port, self._test_result, test_case=self,
+                            **options)
+        self._clients.append(client)
+        return client
+
+    def create_replayer(self, path, target, **options):
+        from loads.engines.replay import LogReplayer
+        replayer = LogReplayer(path, target, test_case=self, **options)
+        self._clients.append(replayer)
+        return replayer
+
+    def create_engine(self, name, **options):
+        from loads.engines import get_engine
+        engine = get_engine(name)(self._test_result, test_case=self,
+                                  **options)
+        engine.setup()
+        self._clients.append(engine)
+        return engine
+
     def tearDown(self):
         for ws in self._ws:
             if ws._th.dead:
                 ws._th.get()  # re-raise any exception swallowed by gevent
 
+        for client in self._clients:
+            client.close()
+        self._clients[:] = []
+
     def run(self, result=None, loads_status=None):
         if (loads_status is not None
                 and result is None
@@ -79,6 +343,8 @@ class TestCase(unittest.TestCase):
         if loads_status is not None:
             self._loads_status = self.session.loads_status = loads_status
 
+        # the first request of the test doesn't wait for a think time
+        self.session.steps = 0
         return super(TestCase, self).run(result)
 
 
@@ -0,0 +1,198 @@
+"""The chaos events of a run: actions executed at given times, to test the
+resilience of the system under load. The events are in a JSON file::
+
+    [{"at": "2m", "name": "restart the api",
+      "command": "kubectl rollout restart deployment/api"},
+     {"at": "5m", "name": "new checkout on",
+      "http": "POST http://flags/api/flags/new-checkout",
+      "data": {"enabled": true}},
+     {"at": "8m", "name": "lose some agents", "kill_agents": "20%"}]
+
+    $ loads-runner example.TestWebSite.test_es -u 50 -d 600 --agents 10 \\
+        --chaos chaos.json
+
+Every event has the time it happens at, from the start of the run -- like
+"90", "30s" or "2m" -- an optional name, and one of the actions:
+
+- *command*: a shell command, which fails when it exits with an error.
+- *http*: a request, like "POST http://host/path" -- just an URL is a GET
+  -- with optional *data*, sent as JSON unless it's a string, and
+  *headers*. It fails when the status is not 2XX or 3XX.
+- *kill_agents*: stops the runners of a share of the agents of a
+  distributed run, like "20%", or a number of agents -- at least one.
+
+The events are run by loads-runner, one after the other. Whether they
+failed or not, they end up in the results: in the summary of the run, and
+on the timeline of its report.
+"""
+import json
+import math
+import random
+import subprocess
+import time
+
+from loads.util import logger, parse_duration
+
+
+ACTIONS = ('command', 'http', 'kill_agents')
+
+
+class ChaosEvent(object):
+    """An action of a chaos file, at a time of the run."""
+
+    def __init__(self, at, name=None, command=None, http=None, data=None,
+                 headers=None, kill_agents=None):
+        actions = [action for action, value in (('command', command),
+                                                ('http', http),
+                                                ('kill_agents', kill_agents))
+                   if value is not None]
+        if len(actions) != 1:
+            raise ValueError('A chaos event has one of %s' %
+                             ', '.join(ACTIONS))
+        self.at = parse_duration(at)
+        self.action = actions[0]
+        self.command = command
+        self.data = data
+        self.headers = headers or {}
+        if http is not None:
+            parts = http.split(None, 1)
+            if len(parts) == 1:
+                parts.insert(0, 'GET')
+            self.method, self.url = parts[0].upper(), parts[1].strip()
+        self.kill_agents = kill_agents
+        if kill_agents is not None:
+            # raises the errors of the invalid ones
+            self.get_agents_to_kill(1)
+        self.name = name or '%s %s' % (self.action,
+                                       command or http or kill_agents)
+
+    def get_agents_to_kill(self, agents):
+        """Returns the number of agents to stop among *agents*."""
+        value = str(self.kill_agents).strip()
+        if value.endswith('%'):
+            ratio = float(value[:-1]) / 100.
+            if not 0 < ratio <= 1:
+                raise ValueError('Invalid share of agents %r' % value)
+            return max(1, int(math.ceil(agents * ratio - 1e-9)))
+        count = int(value)
+        if count < 1:
+            raise ValueError('Invalid number of agents %r' % value)
+        return min(count, agents)
+
+    def __str__(self):
+        return self.name
+
+
+def read_chaos(path):
+    """Returns the events of a chaos file, ordered by time."""
+    with open(path) as f:
+        try:
+            items = json.load(f)
+        except ValueError, e:
+            raise ValueError('Invalid chaos file %s: %s' % (path, e))
+    if not isinstance(items, list):
+        raise ValueError('A chaos file is a list of events')
+    events = []
+    for item in items:
+        try:
+            events.append(ChaosEvent(**dict([(str(key), value)
+                                             for key, value
+                                             in item.items()])))
+        except TypeError, e:
+            raise ValueError('Invalid chaos event %r: %s' % (item, e))
+    events.sort(key=lambda event: event.at)
+    return events
+
+
+def parse_chaos(value):
+    """Checks a chaos file, sent as it is to the runner."""
+    read_chaos(value)
+    return value
+
+
+def _run_command(event):
+    proc = subprocess.Popen(event.command, shell=True,
+                            stdout=subprocess.PIPE, stderr=subprocess.STDOUT)
+    out = proc.communicate()[0].strip()
+    if proc.returncode != 0:
+        raise ValueError('exited with %d: %s' % (proc.returncode, out))
+    return out
+
+
+def _send_request(event):
+    import requests
+    data, headers = event.data, dict(event.headers)
+    if data is not None and not isinstance(data, basestring):
+        data = json.dumps(data)
+        headers.setdefault('Content-Type', 'application/json')
+    res = requests.request(event.method, event.url, data=data,
+                           headers=headers, timeout=30)
+    if not 200 <= res.status_code < 400:
+        raise ValueError('status %d' % res.status_code)
+    return 'status %d' % res.status_code
+
+
+class Chaos(object):
+    """Runs the events of a run at their time, and adds them to the results.
+
+    :param events: the :class:`ChaosEvent` of the run.
+    :param test_result: the results of the run.
+    :param kill_agents: stops the runners of a number of agents, and
+                        returns their ids -- None out of the distributed
+                        runs.
+    :param clock: returns the current time.
+    """
+    def __init__(self, events, test_result, kill_agents=None,
+                 clock=time.time):
+        self.pending = sorted(events, key=lambda event: event.at)
+        self.test_result = test_result
+        self.kill_agents = kill_agents
+        self.clock = clock
+        self.started = None
+
+    def execute(self, event):
+        """Runs the action of an event, and returns what it did."""
+        if event.action == 'command':
+            return _run_command(event)
+        if event.action == 'http':
+            return _send_request(event)
+        if self.kill_agents is None:
+            raise ValueError('only in distributed mode')
+        return 'stopped %s' % ', '.join(self.kill_agents(event))
+
+    def tick(self):
+        """Runs the events that are due."""
+        if self.started is None:
+            self.started = self.clock()
+        elapsed = self.clock() - self.started
+        while self.pending and self.pending[0].at <= elapsed:
+            event = self.pending.pop(0)
+            logger.info('Chaos event %s' % event)
+            try:
+                detail, success = self.execute(event), True
+            except Exception, e:
+                logger.warning('The chaos event %s failed: %s' % (event, e))
+                detail, success = str(e), False
+            if detail and len(detail) > 200:
+                detail = detail[:200] + '...'
+            self.test_result.chaos_event(str(event), event.action, success,
+                                         detail)
+
+    def run(self, sleep=time.sleep, interval=.5):
+        """Runs the events at their time -- in a greenlet."""
+        while self.pending:
+            self.tick()
+            sleep(interval)
+
+
+def kill_agents(client, agents, event):
+    """Stops the runners of a random share of the agents of a run -- as
+    *event* asks -- with a broker :class:`Client`, and returns their
+    ids."""
+    agents = list(agents)
+    if not agents:
+        raise ValueError('no agents')
+    killed = random.sample(agents, event.get_agents_to_kill(len(agents)))
+    for agent_id in killed:
+        client.stop(agent_id)
+    return killed
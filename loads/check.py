@@ -0,0 +1,206 @@
+""" Checks a run before launching it, without any agent: its options and
+its thresholds are validated, its test is resolved and its feeder read,
+then every scenario runs once -- against the target, or against a mock
+with *--mock* -- and its requests are printed, rendered::
+
+    $ loads-check config.ini
+    $ loads-check example.TestWebSite.test_es --templates \\
+        --feeder users.csv --threshold "p95 < 250ms" --mock
+
+*loads-check* takes the options of *loads-runner*, or its configuration
+file. The think times and the pacing are skipped, and the exit code is 1
+when something failed.
+"""
+import itertools
+import os
+import sys
+import traceback
+
+from requests.adapters import BaseAdapter
+from requests.models import Response
+from requests.structures import CaseInsensitiveDict
+
+from loads.feeders import read_rows
+from loads.results import TestResult
+from loads.runners.local import _get_scenarios
+from loads.thresholds import parse_thresholds, read_thresholds
+from loads.util import resolve_name
+
+
+# the characters of the bodies printed
+MAX_BODY = 200
+_CONFIG_EXTENSIONS = ('.ini', '.cfg', '.conf')
+
+
+class MockAdapter(BaseAdapter):
+    """Answers every request with an empty *200 OK*."""
+
+    def send(self, request, **kwargs):
+        response = Response()
+        response.status_code = 200
+        response.reason = 'OK'
+        response.headers = CaseInsensitiveDict({'Content-Length': '0'})
+        response.url = request.url
+        response.request = request
+        response.connection = self
+        response._content = ''
+        response._content_consumed = True
+        return response
+
+    def close(self):
+        pass
+
+
+def _shorten(body):
+    if body is None:
+        return None
+    if not isinstance(body, basestring):
+        return '<%d bytes>' % len(body)
+    if len(body) > MAX_BODY:
+        return body[:MAX_BODY] + '...'
+    return body
+
+
+class _Printer(object):
+    """The hooks printing the requests of a test, after calling the hooks
+    of the run."""
+
+    def __init__(self, hooks=None, stream=None):
+        self.hooks = hooks
+        self.stream = stream or sys.stdout
+
+    def before_request(self, request, test):
+        if self.hooks is not None:
+            self.hooks.before_request(request, test)
+        self.stream.write('  > %s %s\n' % (request.method, request.url))
+        for name, value in sorted(request.headers.items()):
+            self.stream.write('  >   %s: %s\n' % (name, value))
+        body = _shorten(request.body)
+        if body:
+            self.stream.write('  >   %s\n' % body)
+
+    def after_response(self, response, request, test):
+        if self.hooks is not None:
+            self.hooks.after_response(response, request, test)
+        self.stream.write('  < %d %s\n' % (response.status_code,
+                                           response.reason or ''))
+
+
+def _get_tests(fqn):
+    """Returns the test case class and the names of its scenarios."""
+    test = resolve_name(fqn)
+    if isinstance(test, type) and getattr(test, 'scenarios', None):
+        return test, sorted(set(_get_scenarios(test)))
+    return test.im_class, [test.__name__]
+
+
+def run_check(args, stream=None):
+    """Checks the run of the arguments of *loads-runner*, and returns the
+    list of the problems found."""
+    if stream is None:
+        stream = sys.stdout
+    problems = []
+
+    def _report(message, problem=None):
+        if problem is None:
+            stream.write('- %s: OK\n' % message)
+        else:
+            stream.write('- %s: FAILED (%s)\n' % (message, problem))
+            problems.append('%s: %s' % (message, problem))
+
+    for expression in args.get('threshold') or []:
+        try:
+            parse_thresholds([expression])
+        except ValueError, e:
+            _report('threshold %r' % expression, str(e))
+        else:
+            _report('threshold %r' % expression)
+
+    if args.get('threshold_file'):
+        message = 'thresholds file %s' % args['threshold_file']
+        try:
+            read_thresholds(args['threshold_file'])
+        except (IOError, ValueError), e:
+            _report(message, str(e))
+        else:
+            _report(message)
+
+    if args.get('feeder'):
+        feeder = 'feeder %s' % args['feeder']
+        try:
+            rows = list(read_rows(args['feeder']))
+        except (IOError, ValueError), e:
+            _report(feeder, str(e))
+        else:
+            if not rows:
+                _report(feeder, 'no rows')
+            else:
+                _report('%s, %d rows of %s' % (feeder, len(rows),
+                                               ', '.join(sorted(rows[0]))))
+
+    try:
+        klass, scenarios = _get_tests(args['fqn'])
+    except Exception, e:
+        _report('test %s' % args['fqn'], str(e))
+        return problems
+    _report('test %s' % args['fqn'])
+
+    for name in scenarios:
+        stream.write('- scenario %s\n' % name)
+        test_result = TestResult(args=args)
+        test = klass(test_name=name, test_result=test_result, config=args)
+        test.think_time = test.session.think_time = None
+        if args.get('mock'):
+            test.session.mount('http://', MockAdapter())
+            test.session.mount('https://', MockAdapter())
+            test.session.resolve_hosts = False
+        test.session.loads_hooks = _Printer(test.session.loads_hooks,
+                                             stream)
+        try:
+            test.run(loads_status=(1, 1, 1, 1))
+        except Exception, e:
+            _report('scenario %s' % name, str(e))
+            continue
+
+        exc_infos = [exc_info for exc_infos in itertools.chain(
+                     test_result.failures, test_result.errors)
+                     for exc_info in exc_infos]
+        if exc_infos:
+            for exc_info in exc_infos:
+                stream.write(''.join(traceback.format_exception(*exc_info)))
+            _report('scenario %s' % name, traceback.format_exception_only(
+                *exc_infos[0][:2])[-1].strip())
+        else:
+            _report('scenario %s, %d requests' % (name, test_result.nb_hits))
+    return problems
+
+
+def main(sysargs=None):
+    from loads.main import _parse
+
+    if sysargs is None:
+        sysargs = sys.argv[1:]
+    sysargs = list(sysargs)
+    mock = '--mock' in sysargs
+    if mock:
+        sysargs.remove('--mock')
+    # the configuration file can be given alone
+    if (sysargs and os.path.splitext(sysargs[0])[-1] in _CONFIG_EXTENSIONS
+            and os.path.isfile(sysargs[0])):
+        sysargs[:1] = ['--config', sysargs[0]]
+
+    args, parser = _parse(sysargs)
+    if args.fqn is None:
+        parser.error('loads-check needs a test, or a configuration file')
+    args = dict(args._get_kwargs())
+    args['mock'] = mock
+    problems = run_check(args)
+    if problems:
+        print('%d problem(s) found.' % len(problems))
+        return 1
+    print('Everything looks fine.')
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
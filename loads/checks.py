@@ -0,0 +1,55 @@
+"""Checks verify the responses without stopping the test, and every check
+gets its own pass/fail count in the results.
+
+They accept the responses of Requests and of WebTest.
+"""
+from loads.extractors import ExtractionError, json_path, _get_body
+from loads.util import total_seconds
+
+
+def _get_status(response):
+    status = getattr(response, 'status_code', None)
+    if status is None:
+        status = response.status_int
+    return status
+
+
+def _get_latency(response):
+    elapsed = getattr(response, 'elapsed', None)
+    if elapsed is None:
+        raise ValueError('The latency of this response is unknown')
+    return total_seconds(elapsed)
+
+
+def run_checks(response, status=None, contains=None, latency=None,
+               json=None):
+    """Returns a list of (name, passed) -- one for every check.
+
+    :param status: the expected status code.
+    :param contains: a string the body contains.
+    :param latency: the maximum latency, in seconds.
+    :param json: a mapping of JSON paths to their expected values.
+    """
+    results = []
+
+    if status is not None:
+        results.append(('status == %s' % status,
+                        _get_status(response) == status))
+
+    if contains is not None:
+        results.append(('body contains %r' % contains,
+                        contains in _get_body(response)))
+
+    if latency is not None:
+        results.append(('latency < %sms' % int(latency * 1000),
+                        _get_latency(response) < latency))
+
+    if json is not None:
+        for path, expected in sorted(json.items()):
+            try:
+                passed = json_path(response, path) == expected
+            except ExtractionError:
+                passed = False
+            results.append(('%s == %r' % (path, expected), passed))
+
+    return results
@@ -0,0 +1,367 @@
+""" Compares the results of two runs written by the *file* output, and
+detects the regressions of the second one.
+
+    $ loads-compare before.log after.log --tolerance 10%
+
+The requests are grouped by scenario, method and URL pattern. A group has
+a latency regression when its request times are significantly higher in
+the second run -- with a Mann-Whitney U test -- and its median grew by more
+than the tolerance. It has an error regression when its error rate is
+significantly higher -- with a two-proportion z-test -- and grew by more
+than the tolerance. The tests of every scenario are compared the same way.
+
+It also trends the runs of a series of the broker -- see
+:mod:`loads.schedule`::
+
+    $ loads-compare --series nightly --broker tcp://broker:7780
+
+The last run is compared to the median of the previous ones: its median,
+its 95th percentile and its error rate regressed when they grew by more
+than the tolerance.
+"""
+import argparse
+import math
+import sys
+import time
+from collections import defaultdict
+
+from loads.report import Report, read_results
+from loads.util import get_url_pattern, total_seconds
+
+
+def _norm_sf(z):
+    """Returns P(Z > z) for the standard normal distribution."""
+    # Abramowitz and Stegun 7.1.26 -- math.erf is not in Python 2.6
+    x = abs(z) / math.sqrt(2)
+    t = 1 / (1 + .3275911 * x)
+    poly = t * (.254829592 + t * (-.284496736 + t * (1.421413741 + t * (
+        -1.453152027 + t * 1.061405429))))
+    half_erfc = poly * math.exp(-x * x) / 2
+    if z >= 0:
+        return half_erfc
+    return 1 - half_erfc
+
+
+def mann_whitney(first, second):
+    """Returns the one-sided p-value of the values of second being higher
+    than the ones of first, with the normal approximation of the Mann-Whitney
+    U test."""
+    n1, n2 = len(first), len(second)
+    if not n1 or not n2:
+        return 1.
+
+    values = sorted([(value, 0) for value in first] +
+                    [(value, 1) for value in second])
+    ranks = [0.] * len(values)
+    ties = 0.
+    index = 0
+    while index < len(values):
+        end = index
+        while end + 1 < len(values) and values[end + 1][0] == values[index][0]:
+            end += 1
+        rank = (index + end) / 2. + 1
+        for position in range(index, end + 1):
+            ranks[position] = rank
+        count = end - index + 1
+        ties += count ** 3 - count
+        index = end + 1
+
+    rank_sum = sum([rank for rank, (value, run) in zip(ranks, values)
+                    if run == 1])
+    u = rank_sum - n2 * (n2 + 1) / 2.
+    total = n1 + n2
+    variance = n1 * n2 / 12. * ((total + 1) - ties / (total * (total - 1)))
+    if variance <= 0:
+        return 1.
+    z = (u - n1 * n2 / 2.) / math.sqrt(variance)
+    return _norm_sf(z)
+
+
+def two_proportions(errors1, total1, errors2, total2):
+    """Returns the one-sided p-value of the second error rate being higher
+    than the first one."""
+    if not total1 or not total2:
+        return 1.
+    pooled = float(errors1 + errors2) / (total1 + total2)
+    variance = pooled * (1 - pooled) * (1. / total1 + 1. / total2)
+    if variance <= 0:
+        return 1.
+    z = (float(errors2) / total2 - float(errors1) / total1)
+    return _norm_sf(z / math.sqrt(variance))
+
+
+def _median(values):
+    values = sorted(values)
+    middle = len(values) // 2
+    if len(values) % 2:
+        return values[middle]
+    return (values[middle - 1] + values[middle]) / 2.
+
+
+def _percentile(values, percentile):
+    values = sorted(values)
+    index = int(math.ceil(percentile / 100. * len(values))) - 1
+    return values[max(index, 0)]
+
+
+def _grew(before, after, tolerance):
+    if before == 0:
+        return after > 0
+    return (after - before) / float(before) > tolerance
+
+
+def get_groups(report):
+    """Returns the {(scenario, method, endpoint): (times, errors)} of the
+    requests of a run, and the {scenario: (runs, failed)} of its tests."""
+    requests = defaultdict(lambda: ([], 0))
+    for hit in report.hits:
+        endpoint = hit.endpoint
+        if endpoint == hit.url:
+            endpoint = get_url_pattern(hit.url)
+        key = hit.scenario, hit.method, endpoint
+        times, errors = requests[key]
+        times.append(total_seconds(hit.elapsed))
+        requests[key] = times, errors + (not hit.success and 1 or 0)
+
+    tests = {}
+    for name, scenario in report.get_scenarios().items():
+        failed = scenario['failures'] + scenario['errors']
+        tests[name] = scenario['success'] + failed, failed
+    return dict(requests), tests
+
+
+class Comparison(object):
+    """The comparison of a group of requests, or of the tests of a
+    scenario, between two runs."""
+
+    def __init__(self, kind, name, before, after):
+        self.kind = kind
+        self.name = name
+        self.before = before
+        self.after = after
+        self.regressions = []
+
+    @property
+    def regressed(self):
+        return bool(self.regressions)
+
+
+def compare(first, second, tolerance=.1, significance=.05):
+    """Compares two reports, and returns the list of comparisons."""
+    requests1, tests1 = get_groups(first)
+    requests2, tests2 = get_groups(second)
+    comparisons = []
+
+    for key in sorted(set(requests1) | set(requests2)):
+        scenario, method, url = key
+        name = ' '.join([str(part) for part in (scenario, method, url)
+                         if part is not None])
+        comparison = Comparison('requests', name, requests1.get(key),
+                                requests2.get(key))
+        comparisons.append(comparison)
+        if comparison.before is None or comparison.after is None:
+            continue
+
+        times1, errors1 = comparison.before
+        times2, errors2 = comparison.after
+        p_value = mann_whitney(times1, times2)
+        if (p_value < significance and
+                _grew(_median(times1), _median(times2), tolerance)):
+            comparison.regressions.append(('latency', p_value))
+
+        rate1 = float(errors1) / len(times1)
+        rate2 = float(errors2) / len(times2)
+        p_value = two_proportions(errors1, len(times1), errors2, len(times2))
+        if p_value < significance and _grew(rate1, rate2, tolerance):
+            comparison.regressions.append(('error rate', p_value))
+
+    for scenario in sorted(set(tests1) | set(tests2)):
+        comparison = Comparison('tests', '%s (tests)' % scenario,
+                                tests1.get(scenario), tests2.get(scenario))
+        comparisons.append(comparison)
+        if comparison.before is None or comparison.after is None:
+            continue
+
+        runs1, failed1 = comparison.before
+        runs2, failed2 = comparison.after
+        p_value = two_proportions(failed1, runs1, failed2, runs2)
+        if (p_value < significance and runs1 and
+                _grew(float(failed1) / runs1, float(failed2) / runs2,
+                      tolerance)):
+            comparison.regressions.append(('failure rate', p_value))
+
+    return comparisons
+
+
+def _describe_requests(times, errors):
+    return '%d requests, p50 %.1fms, p95 %.1fms, %.1f%% errors' % (
+        len(times), _median(times) * 1000, _percentile(times, 95) * 1000,
+        errors * 100. / len(times))
+
+
+def _describe_tests(runs, failed):
+    if not runs:
+        return '0 tests'
+    return '%d tests, %.1f%% failed' % (runs, failed * 100. / runs)
+
+
+def _describe(comparison, data):
+    if data is None:
+        return 'missing'
+    if comparison.kind == 'tests':
+        return _describe_tests(*data)
+    return _describe_requests(*data)
+
+
+def print_comparisons(comparisons, stream=None):
+    if stream is None:
+        stream = sys.stdout
+    for comparison in comparisons:
+        stream.write('\n%s\n' % comparison.name)
+        stream.write('  before: %s\n' % _describe(comparison,
+                                                  comparison.before))
+        stream.write('  after:  %s\n' % _describe(comparison,
+                                                  comparison.after))
+        for kind, p_value in comparison.regressions:
+            stream.write('  REGRESSION of the %s (p=%.4f)\n' % (kind,
+                                                                p_value))
+
+    regressions = len([c for c in comparisons if c.regressed])
+    if regressions:
+        stream.write('\n%d regression(s) detected.\n' % regressions)
+    else:
+        stream.write('\nNo regression detected.\n')
+
+
+def _error_rate(summary):
+    if not summary['hits']:
+        return 0.
+    return float(summary['errors']) / summary['hits']
+
+
+_TRENDED = (('p50', lambda summary: summary['p50']),
+            ('p95', lambda summary: summary['p95']),
+            ('error rate', _error_rate))
+
+
+def get_trend(runs, tolerance=.1, window=7):
+    """Returns the regressions of the last run of a series -- the runs
+    given by the broker -- against the median of the *window* runs before
+    it, as (name, before, after) tuples."""
+    runs = [run for run in runs if run.get('summary')]
+    if len(runs) < 2:
+        return []
+    last = runs[-1]['summary']
+    previous = [run['summary'] for run in runs[-window - 1:-1]]
+
+    regressions = []
+    for name, get in _TRENDED:
+        values = [get(summary) for summary in previous
+                  if get(summary) is not None]
+        after = get(last)
+        if not values or after is None:
+            continue
+        before = _median(values)
+        if _grew(before, after, tolerance):
+            regressions.append((name, before, after))
+    return regressions
+
+
+def _describe_run(run):
+    summary = run['summary']
+    started = run.get('started') and time.strftime(
+        '%Y-%m-%d %H:%M', time.localtime(run['started'])) or '-'
+    if not summary['hits']:
+        return '%s  %s  0 requests' % (started, run['run_id'])
+    return '%s  %s  %d requests, p50 %.1fms, p95 %.1fms, %.1f%% errors' % (
+        started, run['run_id'], summary['hits'], summary['p50'] * 1000,
+        summary['p95'] * 1000, _error_rate(summary) * 100)
+
+
+def print_trend(runs, regressions, stream=None):
+    if stream is None:
+        stream = sys.stdout
+    for run in runs:
+        if run.get('summary'):
+            stream.write('%s\n' % _describe_run(run))
+
+    for name, before, after in regressions:
+        if name == 'error rate':
+            values = '%.1f%%, was %.1f%%' % (after * 100, before * 100)
+        else:
+            values = '%.1fms, was %.1fms' % (after * 1000, before * 1000)
+        stream.write('  REGRESSION of the %s: %s\n' % (name, values))
+    if regressions:
+        stream.write('\nThe last run regressed.\n')
+    else:
+        stream.write('\nNo regression detected.\n')
+
+
+def _parse_rate(value):
+    value = value.strip()
+    if value.endswith('%'):
+        return float(value[:-1]) / 100.
+    return float(value)
+
+
+def main(args=sys.argv[1:]):
+    parser = argparse.ArgumentParser(description='Compares two runs, '
+                                                 'written by the file '
+                                                 'output, and detects the '
+                                                 'regressions.')
+    parser.add_argument('before', help='The results of the reference run',
+                        nargs='?')
+    parser.add_argument('after', help='The results of the new run',
+                        nargs='?')
+    parser.add_argument('--tolerance', default='10%', type=_parse_rate,
+                        help='How much the median request time and the '
+                             'error rates can grow, e.g. "10%%"')
+    parser.add_argument('--significance', default=.05, type=float,
+                        help='The p-value under which a difference is '
+                             'significant')
+    parser.add_argument('--series', default=None,
+                        help='Trends the runs of a series of the broker '
+                             'instead')
+    parser.add_argument('--window', default=7, type=int,
+                        help='The number of previous runs of the series the '
+                             'last one is compared to')
+    parser.add_argument('--broker', default=None,
+                        help='The broker of the series')
+    parser.add_argument('--api-key', default=None,
+                        help='The API key of the broker')
+    args = parser.parse_args(args)
+
+    if args.series is not None:
+        return _trend(args)
+    if args.before is None or args.after is None:
+        parser.error('Give the results of two runs, or a series')
+
+    comparisons = compare(Report(read_results(args.before)),
+                          Report(read_results(args.after)),
+                          tolerance=args.tolerance,
+                          significance=args.significance)
+    print_comparisons(comparisons)
+    if [comparison for comparison in comparisons if comparison.regressed]:
+        return 1
+    return 0
+
+
+def _trend(args):
+    from loads.transport.client import Client
+    from loads.transport.util import DEFAULT_FRONTEND
+    client = Client(args.broker or DEFAULT_FRONTEND, api_key=args.api_key)
+    try:
+        runs = client.get_series(args.series)
+    finally:
+        client.close()
+
+    regressions = get_trend(runs, tolerance=args.tolerance,
+                            window=args.window)
+    print_trend(runs, regressions)
+    if regressions:
+        return 1
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
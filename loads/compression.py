@@ -0,0 +1,64 @@
+"""The compressed responses: *--accept-encoding* asks for them, like with
+*gzip, br, zstd*. The bodies are decompressed for the checks of the test
+-- gzip and deflate by urllib3, br with
+`brotli <http://pypi.python.org/pypi/Brotli>`_ installed and zstd with
+`zstandard <http://pypi.python.org/pypi/zstandard>`_ -- and every hit
+keeps both the size of its decompressed body and the bytes that were
+received.
+"""
+
+
+def _brotli(data):
+    import brotli
+    return brotli.decompress(data)
+
+
+def _zstd(data):
+    import zstandard
+    return zstandard.ZstdDecompressor().decompressobj().decompress(data)
+
+
+_DECOMPRESSORS = {'br': _brotli, 'zstd': _zstd}
+
+
+def _available(encoding):
+    module = {'br': 'brotli', 'zstd': 'zstandard'}.get(encoding)
+    if module is None:
+        return False
+    try:
+        __import__(module)
+    except ImportError:
+        return False
+    return True
+
+
+# the encodings loads can decompress
+ENCODINGS = ['gzip', 'deflate'] + [encoding for encoding in _DECOMPRESSORS
+                                   if _available(encoding)]
+
+
+def decompress(response):
+    """Decompresses the body of a response when urllib3 did not."""
+    encoding = response.headers.get('Content-Encoding', '').strip().lower()
+    decoders = getattr(response.raw, 'CONTENT_DECODERS', ())
+    if encoding in decoders or encoding not in _DECOMPRESSORS:
+        return
+    if not _available(encoding) or not response.content:
+        return
+    response._content = _DECOMPRESSORS[encoding](response.content)
+
+
+def get_sizes(response):
+    """Returns the size of the decompressed body of a response, and the
+    bytes received for it -- or None when the body was not read."""
+    if not getattr(response, '_content_consumed', False):
+        return None, None
+    size = len(response.content or '')
+    wire_size = size
+    tell = getattr(response.raw, 'tell', None)
+    if tell is not None:
+        try:
+            wire_size = tell()
+        except (IOError, ValueError, AttributeError):
+            pass
+    return size, wire_size
@@ -0,0 +1,120 @@
+"""The custom metrics of the tests, next to the request times: the
+counters, the gauges and the trends::
+
+    class TestShop(TestCase):
+
+        def test_cart(self):
+            res = self.session.get(self.server_url + '/cart')
+            self.incr_counter('carts')
+            self.set_gauge('items_in_cart', len(res.json()['items']))
+            self.add_trend('server-db', float(res.headers['X-Db-Time']))
+
+- a *counter* adds up its values -- 1 by default.
+- a *gauge* keeps its last value, and the smallest and the largest ones.
+- a *trend* keeps all its values, for their average, their extremes and
+  their percentiles.
+
+The metrics are in the summary of the run, in its report, in the
+StatsD, InfluxDB and OTLP outputs and on the */metrics* endpoints, and
+the thresholds take them -- the values are the ones of the tests, without
+a unit::
+
+    $ loads-runner example.TestShop.test_cart -u 10 -d 60 \\
+        --threshold "counter.carts > 100" \\
+        --threshold "gauge.items_in_cart.max <= 20" \\
+        --threshold "trend.server-db.p95 < 50"
+
+The gauges give their last value without a statistic, and the trends
+their average. The thresholds on the custom metrics are evaluated at the
+end of a run, not during it.
+"""
+import re
+
+
+KINDS = ('counter', 'gauge', 'trend')
+GAUGE_STATS = ('last', 'min', 'max')
+TREND_STATS = ('avg', 'min', 'max', 'p50', 'p90', 'p95', 'p99')
+_DEFAULT_STATS = {'counter': 'total', 'gauge': 'last', 'trend': 'avg'}
+_STATS = {'counter': ('total',), 'gauge': GAUGE_STATS,
+          'trend': TREND_STATS}
+
+_METRIC = re.compile(r'^(counter|gauge|trend)\.([\w-]+)(?:\.(\w+))?$')
+
+
+class Gauge(object):
+    """The last, the smallest and the largest values of a gauge."""
+
+    def __init__(self):
+        self.last = self.min = self.max = None
+        self.count = 0
+
+    def set(self, value):
+        self.last = value
+        if self.min is None or value < self.min:
+            self.min = value
+        if self.max is None or value > self.max:
+            self.max = value
+        self.count += 1
+
+    def get(self, stat='last'):
+        return getattr(self, stat)
+
+
+class Trend(object):
+    """The values of a trend."""
+
+    def __init__(self):
+        self.values = []
+
+    def add(self, value):
+        self.values.append(value)
+
+    @property
+    def count(self):
+        return len(self.values)
+
+    def get(self, stat='avg'):
+        """Returns a statistic of the values, or None without values."""
+        if not self.values:
+            return None
+        if stat == 'avg':
+            return float(sum(self.values)) / len(self.values)
+        if stat == 'min':
+            return min(self.values)
+        if stat == 'max':
+            return max(self.values)
+        values = sorted(self.values)
+        index = int(round(int(stat[1:]) / 100. * (len(values) - 1)))
+        return values[index]
+
+
+def parse_metric(metric):
+    """Returns the (kind, name, statistic) of a custom metric like
+    "trend.server-db.p95", or None when it's not one."""
+    match = _METRIC.match(metric)
+    if match is None:
+        return None
+    kind, name, stat = match.groups()
+    if stat is None:
+        stat = _DEFAULT_STATS[kind]
+    elif stat not in _STATS[kind]:
+        raise ValueError('A %s has no %r -- only %s' % (
+            kind, stat, ', '.join(_STATS[kind])))
+    return kind, name, stat
+
+
+def get_custom_metric(test_result, kind, name, stat):
+    """Returns the value of a custom metric, or None when there's no
+    data."""
+    if kind == 'counter':
+        counters = test_result.get_counters()
+        if name not in counters:
+            return None
+        return counters[name]
+    if kind == 'gauge':
+        metrics = test_result.get_gauges()
+    else:
+        metrics = test_result.get_trends()
+    if name not in metrics:
+        return None
+    return metrics[name].get(stat)
@@ -2,6 +2,12 @@ from loads.util import logger
 
 
 class BaseDB(object):
+    """Base class of the databases the broker stores the results in.
+
+    A database has a *name* -- the one of the --db option of the broker --
+    and *options*, mapping every option to its default value, its help and
+    its type.
+    """
 
     name = ''
     options = {}
@@ -47,6 +53,11 @@ class BaseDB(object):
     def get_urls(self, run_id):
         raise NotImplementedError()
 
+    def downsample_run(self, run_id, steps, now=None):
+        """Merges the hits of a run in intervals, by age -- see
+        :mod:`loads.db.retention`. Returns the number of records removed."""
+        raise NotImplementedError()
+
 
 def get_database(name='python', loop=None, **options):
     if name == 'python':
@@ -55,6 +66,12 @@ def get_database(name='python', loop=None, **options):
     elif name == 'redis':
         from loads.db._redis import RedisDB
         klass = RedisDB
+    elif name == 'sqlite':
+        from loads.db._sqlite import SQLiteDB
+        klass = SQLiteDB
+    elif name == 'postgres':
+        from loads.db._postgres import PostgresDB
+        klass = PostgresDB
     else:
         raise NotImplementedError(name)
 
@@ -75,10 +92,22 @@ def get_backends():
     from loads.db._python import BrokerDB
     backends.append((BrokerDB.name, _options(BrokerDB)))
 
+    # sqlite3 is in the standard library
+    from loads.db._sqlite import SQLiteDB
+    backends.append((SQLiteDB.name, _options(SQLiteDB)))
+
     try:
         from loads.db._redis import RedisDB
     except ImportError:
-        return backends
+        pass
+    else:
+        backends.append((RedisDB.name, _options(RedisDB)))
+
+    try:
+        from loads.db._postgres import PostgresDB
+    except ImportError:
+        pass
+    else:
+        backends.append((PostgresDB.name, _options(PostgresDB)))
 
-    backends.append((RedisDB.name, _options(RedisDB)))
     return backends
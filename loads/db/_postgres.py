@@ -0,0 +1,20 @@
+try:
+    import psycopg2
+except ImportError:
+    raise ImportError("You need to install "
+                      "http://pypi.python.org/pypi/psycopg2")
+
+from loads.db._sql import SQLDB
+
+
+class PostgresDB(SQLDB):
+    """A SQL database on a PostgreSQL server, 9.5 or later."""
+    name = 'postgres'
+    options = dict(SQLDB.options,
+                   dsn=('dbname=loads', 'PostgreSQL connection string, like '
+                        '"host=db dbname=loads user=loads".', str))
+    error = psycopg2.Error
+
+    def _connect(self):
+        self.dsn = self.params['dsn']
+        return psycopg2.connect(self.dsn)
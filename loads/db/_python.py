@@ -5,6 +5,8 @@ from collections import defaultdict
 from gevent.queue import Queue
 from zmq.green.eventloop import ioloop
 from loads.db import BaseDB
+from loads.db.retention import (PRUNE_DELAY, apply_retention, downsample,
+                                parse_retention)
 from loads.util import json, dict_hash
 
 
@@ -72,7 +74,9 @@ class BrokerDB(BaseDB):
     name = 'python'
     options = {'directory': (DEFAULT_DBDIR, 'DB path.', str),
                'sync_delay': (2000, 'Sync delay', int),
-               'max_size': (-1, 'Max Size in Gigabytes', float)}
+               'max_size': (-1, 'Max Size in Gigabytes', float),
+               'retention': ('', 'Retention policy of the hits, like '
+                                 '"1s:24h,1m"', str)}
 
     def _initialize(self):
         self.directory = self.params['directory']
@@ -87,6 +91,12 @@ class BrokerDB(BaseDB):
         self._callback = ioloop.PeriodicCallback(self.flush, self.sync_delay,
                                                  self.loop)
         self._callback.start()
+        self._prune = None
+        if self.params['retention']:
+            steps = parse_retention(self.params['retention'])
+            self._prune = ioloop.PeriodicCallback(
+                lambda: apply_retention(self, steps), PRUNE_DELAY, self.loop)
+            self._prune.start()
         self._counts = defaultdict(lambda: defaultdict(int))
         self._dirty = False
         self._metadata = defaultdict(dict)
@@ -176,7 +186,7 @@ class BrokerDB(BaseDB):
         self._buffer[run_id].put(dict(data))
 
         if 'url' in data:
-            self._urls[run_id][data['url']] += 1
+            self._urls[run_id][data.get('endpoint') or data['url']] += 1
 
         if data_type == 'addError':
             self._errors[run_id].put(dict(data))
@@ -229,6 +239,27 @@ class BrokerDB(BaseDB):
             if run_id in mapping:
                 del mapping[run_id]
 
+    def downsample_run(self, run_id, steps, now=None):
+        filename = os.path.join(self.directory, run_id + '-db.json')
+        records = list(self.get_data(run_id))
+        downsampled = list(downsample(records, steps, now))
+        if downsampled == records:
+            return 0
+
+        # the counts and the urls are still the ones of the hits
+        queue = Queue()
+        for record in downsampled:
+            queue.put(record)
+        tmp = filename + '.tmp'
+        if os.path.exists(tmp):
+            os.remove(tmp)
+        self._dump_queue(run_id, queue, tmp)
+        os.rename(tmp, filename)
+        filename = os.path.join(self.directory, run_id + '-headers.json')
+        with open(filename, 'w') as f:
+            json.dump(self._headers[run_id], f)
+        return len(records) - len(downsampled)
+
     def is_summarized(self, run_id):
         db = os.path.join(self.directory, '%s-db.json' % run_id)
         meta = os.path.join(self.directory, '%s-metadata.json' % run_id)
@@ -287,6 +318,8 @@ class BrokerDB(BaseDB):
 
     def close(self):
         self._callback.stop()
+        if self._prune is not None:
+            self._prune.stop()
 
     def get_urls(self, run_id):
         self.flush()
@@ -64,7 +64,7 @@ class RedisDB(BaseDB):
 
         # adding urls
         if 'url' in data:
-            url = data['url']
+            url = data.get('endpoint') or data['url']
             urls = 'urls:%s' % run_id
             if not self._redis.sismember(urls, url):
                 pipeline.sadd(urls, url)
@@ -0,0 +1,327 @@
+""" The results kept in SQL tables, so they outlive the broker and can be
+queried later on, by loads or by any SQL client:
+
+- **loads_runs** has a row per run, with the JSON of its metadata.
+- **loads_data** has a row per result, with the JSON of the result and the
+  columns worth filtering on: the data type, the agent, the endpoint --
+  the URL, or its pattern --, status and elapsed time of the hits, the name
+  of the checks and whether they passed, and the time the broker received
+  it.
+"""
+import time
+
+from zmq.green.eventloop import ioloop
+
+from loads.db import BaseDB
+from loads.db.retention import (PRUNE_DELAY, apply_retention, downsample,
+                                parse_retention, _timestamp)
+from loads.util import json
+
+
+_TABLES = ("""\
+CREATE TABLE IF NOT EXISTS loads_runs (
+    run_id VARCHAR(64) PRIMARY KEY,
+    created FLOAT NOT NULL,
+    metadata TEXT NOT NULL)""", """\
+CREATE TABLE IF NOT EXISTS loads_data (
+    id %(serial)s,
+    run_id VARCHAR(64) NOT NULL,
+    data_type VARCHAR(64) NOT NULL,
+    agent_id VARCHAR(64),
+    received FLOAT NOT NULL,
+    size INTEGER NOT NULL,
+    url TEXT,
+    status INTEGER,
+    elapsed FLOAT,
+    name TEXT,
+    passed INTEGER,
+    data TEXT NOT NULL)""", """\
+CREATE INDEX IF NOT EXISTS loads_data_run ON loads_data (run_id, data_type)""")
+
+_COLUMNS = ('run_id', 'data_type', 'agent_id', 'received', 'size', 'url',
+            'status', 'elapsed', 'name', 'passed', 'data')
+
+_FAILURES = ('addError', 'addFailure')
+
+
+def _number(value, type_=float):
+    try:
+        return type_(value)
+    except (TypeError, ValueError):
+        return None
+
+
+class SQLDB(BaseDB):
+    """Base class of the SQL databases.
+
+    The results are buffered, and written every *sync_delay* milliseconds,
+    or before they are read. The runs are kept -- their details included --
+    until there are more than *max_runs* of them, and their hits are
+    downsampled every hour with a *retention* policy.
+    """
+    options = {'sync_delay': (2000, 'Sync delay', int),
+               'max_runs': (-1, 'Max number of runs kept', int),
+               'retention': ('', 'Retention policy of the hits, like '
+                                 '"1s:24h,1m"', str)}
+
+    # the placeholder of the parameters, the type of the ids, what a LIMIT
+    # without limit is, and the errors of the driver
+    param = '%s'
+    serial = 'BIGSERIAL PRIMARY KEY'
+    no_limit = 'ALL'
+    error = Exception
+
+    def _initialize(self):
+        self.sync_delay = self.params['sync_delay']
+        self.max_runs = self.params['max_runs']
+        self._buffer = []
+        self._runs = set()
+        self._conn = self._connect()
+
+        for table in _TABLES:
+            self._execute(table % {'serial': self.serial})
+        self._conn.commit()
+
+        self._callback = ioloop.PeriodicCallback(self.flush, self.sync_delay,
+                                                 self.loop)
+        self._callback.start()
+        self._prune = None
+        if self.params['retention']:
+            steps = parse_retention(self.params['retention'])
+            self._prune = ioloop.PeriodicCallback(
+                lambda: apply_retention(self, steps), PRUNE_DELAY, self.loop)
+            self._prune.start()
+
+    def _connect(self):
+        raise NotImplementedError()
+
+    def _execute(self, query, params=(), many=False):
+        query = query.replace('%s', self.param)
+        cursor = self._conn.cursor()
+        try:
+            if many:
+                cursor.executemany(query, params)
+            else:
+                cursor.execute(query, params)
+        except self.error:
+            self._conn.rollback()
+            raise
+        return cursor
+
+    def _range(self, start, size):
+        if start is None and size is None:
+            return ''
+        limit = size is None and self.no_limit or int(size)
+        return ' LIMIT %s OFFSET %d' % (limit, start or 0)
+
+    def ping(self):
+        try:
+            self._execute('SELECT 1')
+            return True
+        except self.error:
+            return False
+
+    #
+    # APIs
+    #
+    def save_metadata(self, run_id, metadata):
+        dumped = json.dumps(metadata)
+        cursor = self._execute('UPDATE loads_runs SET metadata = %s '
+                               'WHERE run_id = %s', (dumped, run_id))
+        if cursor.rowcount == 0:
+            self._execute('INSERT INTO loads_runs (run_id, created, metadata) '
+                          'VALUES (%s, %s, %s)', (run_id, time.time(), dumped))
+        self._conn.commit()
+
+    def update_metadata(self, run_id, **metadata):
+        existing = self.get_metadata(run_id)
+        existing.update(metadata)
+        self.save_metadata(run_id, existing)
+
+    def get_metadata(self, run_id):
+        cursor = self._execute('SELECT metadata FROM loads_runs '
+                               'WHERE run_id = %s', (run_id,))
+        row = cursor.fetchone()
+        if row is None:
+            return {}
+        return json.loads(row[0])
+
+    def add(self, data):
+        run_id = data['run_id']
+        if run_id not in self._runs:
+            self.update_metadata(run_id, has_data=1)
+            self._runs.add(run_id)
+
+        self._buffer.append(self._get_row(data, time.time()))
+
+    def _get_row(self, data, received):
+        data_type = data['data_type'] = data.get('data_type', 'unknown')
+        agent_id = data.get('agent_id')
+        if agent_id is not None:
+            agent_id = str(agent_id)
+        passed = data.get('passed')
+        if passed is not None:
+            passed = int(bool(passed))
+
+        return (data['run_id'], data_type, agent_id, received,
+                data.get('size', 1), data.get('endpoint') or data.get('url'),
+                _number(data.get('status'), int),
+                _number(data.get('elapsed')), data.get('name'), passed,
+                json.dumps(data))
+
+    def _insert(self, rows):
+        query = 'INSERT INTO loads_data (%s) VALUES (%s)' % (
+            ', '.join(_COLUMNS), ', '.join(['%s'] * len(_COLUMNS)))
+        self._execute(query, rows, many=True)
+        self._conn.commit()
+
+    def flush(self):
+        if not self._buffer:
+            return
+        rows, self._buffer = self._buffer, []
+        self._insert(rows)
+
+    def close(self):
+        self._callback.stop()
+        if self._prune is not None:
+            self._prune.stop()
+        self.flush()
+        self._conn.close()
+
+    def get_urls(self, run_id):
+        self.flush()
+        cursor = self._execute('SELECT url, COUNT(*) FROM loads_data '
+                               'WHERE run_id = %s AND url IS NOT NULL '
+                               'GROUP BY url', (run_id,))
+        return dict([(url, int(count)) for url, count in cursor.fetchall()])
+
+    def get_counts(self, run_id):
+        self.flush()
+        cursor = self._execute('SELECT data_type, SUM(size) FROM loads_data '
+                               'WHERE run_id = %s GROUP BY data_type',
+                               (run_id,))
+        return dict([(data_type, int(count))
+                     for data_type, count in cursor.fetchall()])
+
+    def get_runs(self):
+        # from older to newer...
+        cursor = self._execute('SELECT run_id FROM loads_runs '
+                               'ORDER BY created')
+        return [run_id for run_id, in cursor.fetchall()]
+
+    def get_errors(self, run_id, start=None, size=None):
+        return self.get_data(run_id, data_type='addError', start=start,
+                             size=size)
+
+    def get_data(self, run_id, data_type=None, groupby=False, start=None,
+                 size=None):
+        self.flush()
+        where, params = 'run_id = %s', (run_id,)
+        if data_type is not None:
+            where += ' AND data_type = %s'
+            params += (data_type,)
+
+        if not groupby:
+            cursor = self._execute('SELECT data FROM loads_data WHERE %s '
+                                   'ORDER BY id%s' %
+                                   (where, self._range(start, size)), params)
+            for data, in cursor.fetchall():
+                yield json.loads(data)
+        else:
+            cursor = self._execute('SELECT data, SUM(size) FROM loads_data '
+                                   'WHERE %s GROUP BY data ORDER BY MIN(id)%s'
+                                   % (where, self._range(start, size)),
+                                   params)
+            for data, count in cursor.fetchall():
+                data = json.loads(data)
+                data['count'] = int(count)
+                yield data
+
+    def get_checks(self, run_id):
+        """Returns how many times every check passed and failed."""
+        self.flush()
+        cursor = self._execute('SELECT name, passed, SUM(size) '
+                               'FROM loads_data WHERE run_id = %s AND '
+                               "data_type = 'add_check' GROUP BY name, passed",
+                               (run_id,))
+        checks = {}
+        for name, passed, count in cursor.fetchall():
+            check = checks.setdefault(name, {'passed': 0, 'failed': 0})
+            check[passed and 'passed' or 'failed'] += int(count)
+        return checks
+
+    def get_intervals(self, run_id, interval=1.):
+        """Returns the hits, their mean elapsed time and the failures of the
+        tests of every *interval* seconds of the run, from its first result
+        on."""
+        self.flush()
+        cursor = self._execute('SELECT data_type, received, elapsed, size '
+                               'FROM loads_data WHERE run_id = %s '
+                               'ORDER BY received', (run_id,))
+        intervals, origin = [], None
+
+        for data_type, received, elapsed, size in cursor.fetchall():
+            if origin is None:
+                origin = received
+            index = int((received - origin) / interval)
+            while len(intervals) <= index:
+                intervals.append({'start': len(intervals) * interval,
+                                  'hits': 0, 'elapsed': 0., 'failures': 0})
+            current = intervals[index]
+
+            # the downsampled hits are one row per interval
+            if data_type == 'add_hit':
+                current['hits'] += size
+                current['elapsed'] += (elapsed or 0.) * size
+            elif data_type in _FAILURES:
+                current['failures'] += 1
+
+        for current in intervals:
+            if current['hits']:
+                current['elapsed'] /= current['hits']
+        return intervals
+
+    def prepare_run(self):
+        if self.max_runs == -1:
+            return
+        # making room for the new run, wiping up the older ones
+        runs = self.get_runs()
+        for run_id in runs[:max(len(runs) - self.max_runs + 1, 0)]:
+            self.delete_run(run_id)
+
+    def delete_run(self, run_id):
+        self.flush()
+        for table in ('loads_data', 'loads_runs'):
+            self._execute('DELETE FROM %s WHERE run_id = %%s' % table,
+                          (run_id,))
+        self._conn.commit()
+        self._runs.discard(run_id)
+
+    def downsample_run(self, run_id, steps, now=None):
+        self.flush()
+        cursor = self._execute('SELECT id, data FROM loads_data '
+                               "WHERE run_id = %s AND data_type = 'add_hit' "
+                               'ORDER BY id', (run_id,))
+        rows = cursor.fetchall()
+        records = [json.loads(data) for id_, data in rows]
+        downsampled = list(downsample(records, steps, now))
+        if downsampled == records:
+            return 0
+
+        # the hits received in the meantime are left as they are
+        self._execute("DELETE FROM loads_data WHERE run_id = %s AND "
+                      "data_type = 'add_hit' AND id <= %s",
+                      (run_id, rows[-1][0]))
+        received = time.time()
+        self._insert([self._get_row(record, record.get('started') and
+                                    _timestamp(record['started']) or
+                                    received)
+                      for record in downsampled])
+        return len(records) - len(downsampled)
+
+    def is_summarized(self, run_id):
+        return False
+
+    def summarize_run(self, run_id):
+        # the details are kept, to be queried later on
+        pass
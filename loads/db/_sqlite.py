@@ -0,0 +1,25 @@
+import os
+import sqlite3
+
+from loads.db._sql import SQLDB
+
+
+DEFAULT_PATH = os.path.join('/tmp', 'loads', 'loads.db')
+
+
+class SQLiteDB(SQLDB):
+    """A SQL database in a SQLite file."""
+    name = 'sqlite'
+    options = dict(SQLDB.options,
+                   path=(DEFAULT_PATH, 'Path of the SQLite file.', str))
+    param = '?'
+    serial = 'INTEGER PRIMARY KEY AUTOINCREMENT'
+    no_limit = '-1'
+    error = sqlite3.Error
+
+    def _connect(self):
+        self.path = self.params['path']
+        directory = os.path.dirname(self.path)
+        if directory and not os.path.exists(directory):
+            os.makedirs(directory)
+        return sqlite3.connect(self.path)
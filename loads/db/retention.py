@@ -0,0 +1,210 @@
+""" The retention of the results kept by the broker.
+
+The hits of a long run are downsampled as they age: the ``1s:24h,1m``
+policy keeps them in intervals of 1 second for 24 hours, then of 1 minute.
+``raw`` keeps the hits as they are -- like ``raw:1h,1s:24h,1m`` -- and an
+age on the last resolution deletes the runs that ended before it, like
+``1s:24h,1m:30d``.
+
+An interval is an *add_hit* per agent, endpoint, method and status, with
+*interval*, its length in seconds, *size*, the number of hits, *elapsed*,
+their mean time, and *histogram*, the HDR histogram of their times. The
+histograms are merged without any loss, so the percentiles of the 1 minute
+intervals are the ones of their hits.
+
+The broker applies the *retention* option of its database every hour, like
+``--db-sqlite-retention 1s:24h,1m``, and **loads-prune** applies a policy
+once::
+
+    $ loads-prune --db sqlite --db-sqlite-path /var/loads/loads.db \\
+        --retention 1s:24h,1m:30d
+
+The results of the *python* database are only kept during the runs, and
+the *redis* one has no retention.
+"""
+import argparse
+import calendar
+import sys
+import time
+from datetime import datetime
+
+from loads.db import get_backends, get_database
+from loads.histogram import Histogram
+from loads.report import _parse_date
+from loads.transport.dashboard import _get_hit
+from loads.util import parse_duration, set_logger
+
+
+DEFAULT_RETENTION = '1s:24h,1m'
+
+# the milliseconds between two prunings of the broker
+PRUNE_DELAY = 3600 * 1000
+
+# the fields of the hits of an interval
+_KEY = ('run_id', 'agent_id', 'hostname', 'url', 'endpoint', 'method',
+        'status', 'scenario', 'protocol', 'family')
+
+
+def parse_retention(value):
+    """Returns the (resolution, age) in seconds of every step of a policy
+    -- the resolution of the raw hits being 0, and the age of the last step
+    None when the runs are kept forever."""
+    steps = []
+    for step in str(value).split(','):
+        if ':' in step:
+            resolution, age = step.split(':', 1)
+            age = parse_duration(age)
+        else:
+            resolution, age = step, None
+        resolution = resolution.strip()
+        if resolution == 'raw':
+            resolution = 0
+        else:
+            resolution = parse_duration(resolution)
+            if resolution <= 0:
+                raise ValueError('Invalid resolution %r' % step)
+        steps.append((resolution, age))
+
+    for index, (resolution, age) in enumerate(steps[1:]):
+        previous, previous_age = steps[index]
+        if previous_age is None or (age is not None and age <= previous_age):
+            raise ValueError('The ages of %r are not increasing' % value)
+        # the intervals are made of whole intervals of the previous step
+        if resolution <= previous or (previous and resolution % previous):
+            raise ValueError('Every resolution of %r is a multiple of the '
+                             'previous one' % value)
+    return steps
+
+
+def get_resolution(steps, age):
+    """Returns the resolution of the hits of an age, in seconds."""
+    for resolution, max_age in steps:
+        if max_age is None or age < max_age:
+            return resolution
+    return steps[-1][0]
+
+
+def _timestamp(started):
+    started = _parse_date(started)
+    return calendar.timegm(started.timetuple()) + started.microsecond / 1e6
+
+
+def _merge(interval, hit):
+    if 'histogram' in hit:
+        histogram = Histogram.from_dict(hit['histogram'])
+    else:
+        histogram = Histogram()
+        histogram.record_value(_get_hit(hit)[1])
+    interval['histogram'].add(histogram)
+    size = hit.get('size', 1)
+    interval['size'] += size
+    interval['elapsed'] += (hit.get('elapsed') or 0.) * size
+
+
+def downsample(records, steps, now=None):
+    """Yields the records of a run, with their hits merged in intervals by
+    age -- the other records as they are."""
+    if now is None:
+        now = time.time()
+
+    intervals = {}
+    for record in records:
+        if record.get('data_type') != 'add_hit' or not record.get('started'):
+            yield record
+            continue
+
+        started = _timestamp(record['started'])
+        resolution = get_resolution(steps, now - started)
+        if resolution <= record.get('interval', 0):
+            yield record
+            continue
+
+        start = started - started % resolution
+        key = (start, resolution) + tuple([record.get(field)
+                                           for field in _KEY])
+        if key not in intervals:
+            interval = dict([(field, record[field]) for field in _KEY
+                             if record.get(field) is not None])
+            interval.update({'data_type': 'add_hit', 'interval': resolution,
+                             'started': datetime.utcfromtimestamp(start),
+                             'size': 0, 'elapsed': 0.,
+                             'histogram': Histogram()})
+            intervals[key] = interval
+        _merge(intervals[key], record)
+
+    for key in sorted(intervals):
+        interval = intervals[key]
+        interval['started'] = interval['started'].isoformat()
+        interval['elapsed'] /= interval['size']
+        interval['histogram'] = interval['histogram'].to_dict()
+        yield interval
+
+
+def apply_retention(db, steps, now=None):
+    """Deletes the runs of a database that ended before the last age of a
+    policy, and downsamples the hits of the other ones. Returns the number
+    of runs deleted and of records removed."""
+    if now is None:
+        now = time.time()
+
+    db.flush()
+    max_age = steps[-1][1]
+    deleted = removed = 0
+    for run_id in list(db.get_runs()):
+        ended = db.get_metadata(run_id).get('ended')
+        if max_age is not None and ended is not None and \
+                now - ended >= max_age:
+            db.delete_run(run_id)
+            deleted += 1
+        elif not db.is_summarized(run_id):
+            removed += db.downsample_run(run_id, steps, now)
+    return deleted, removed
+
+
+def main(args=sys.argv[1:]):
+    parser = argparse.ArgumentParser(description='Downsamples the hits '
+                                                 'kept by the broker, and '
+                                                 'deletes the old runs.')
+    parser.add_argument('--db', default='python',
+                        choices=[name for name, options in get_backends()],
+                        help='The database of the broker')
+    parser.add_argument('--retention', default=DEFAULT_RETENTION,
+                        help='The retention policy, like "1s:24h,1m:30d"')
+    parser.add_argument('--debug', action='store_true', default=False,
+                        help='Debug mode')
+
+    for backend, options in get_backends():
+        for option, default, help, type_ in options:
+            option = 'db_%s_%s' % (backend, option)
+            parser.add_argument('--%s' % option.replace('_', '-'),
+                                dest=option, default=default, type=type_,
+                                help=help)
+    args = parser.parse_args(args)
+    set_logger(args.debug)
+
+    try:
+        steps = parse_retention(args.retention)
+    except ValueError, e:
+        parser.error(str(e))
+
+    prefix = 'db_%s_' % args.db
+    dboptions = dict([(key[len(prefix):], value) for key, value
+                      in args._get_kwargs() if key.startswith(prefix)])
+    # the pruning is done once, not on the loop of a broker
+    dboptions['retention'] = ''
+    db = get_database(args.db, **dboptions)
+    try:
+        deleted, removed = apply_retention(db, steps)
+    except NotImplementedError:
+        print('The %s database has no retention.' % args.db)
+        return 1
+    finally:
+        db.close()
+
+    print('%d run(s) deleted, %d record(s) removed by the downsampling.' %
+          (deleted, removed))
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
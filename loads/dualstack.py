@@ -0,0 +1,142 @@
+"""The address family of the connections: *--ip-family ipv4* or *ipv6*
+connects to the addresses of this family only, and *happy-eyeballs* tries
+both, IPv6 first, like the dual-stack clients do (RFC 8305)::
+
+    $ loads-runner example.TestWebSite.test_es --ip-family ipv6
+    $ loads-runner example.TestWebSite.test_es --ip-family happy-eyeballs
+
+Without it, the connections use the IPv4 address the host resolves to.
+
+With *happy-eyeballs*, the addresses of both families are interleaved,
+IPv6 first, and the next address is tried when the previous one failed or
+did not connect within :data:`CONNECTION_ATTEMPT_DELAY` seconds: the
+first connection established wins, the other ones are closed.
+
+Every hit knows the family of its connection, and the hits are compared by
+family in the *address family* stats.
+"""
+import socket
+
+import gevent
+from gevent.queue import Queue, Empty
+
+
+FAMILIES = ('ipv4', 'ipv6', 'happy-eyeballs')
+CONNECTION_ATTEMPT_DELAY = .25
+
+_AF = {'ipv4': socket.AF_INET, 'ipv6': socket.AF_INET6}
+_NAMES = {socket.AF_INET: 'ipv4', socket.AF_INET6: 'ipv6'}
+
+
+def get_family(sock):
+    """Returns the family of a socket -- "ipv4" or "ipv6" -- or None."""
+    return _NAMES.get(getattr(sock, 'family', None))
+
+
+def get_addresses(host, port, family=None):
+    """Returns the (family, sockaddr) a host resolves to.
+
+    With *happy-eyeballs*, the IPv6 and the IPv4 addresses alternate,
+    starting with IPv6.
+    """
+    af = _AF.get(family, socket.AF_UNSPEC)
+    addresses = []
+    for info in socket.getaddrinfo(host, port, af, socket.SOCK_STREAM):
+        if info[0] in _NAMES and (info[0], info[4]) not in addresses:
+            addresses.append((info[0], info[4]))
+    if not addresses:
+        raise socket.gaierror('No %s address for %s' % (family or 'IP',
+                                                         host))
+    if family != 'happy-eyeballs':
+        return addresses
+
+    ipv6 = [address for address in addresses if address[0] == socket.AF_INET6]
+    ipv4 = [address for address in addresses if address[0] == socket.AF_INET]
+    interleaved = []
+    for index in range(max(len(ipv6), len(ipv4))):
+        interleaved.extend(ipv6[index:index + 1] + ipv4[index:index + 1])
+    return interleaved
+
+
+def _connect(af, sockaddr, timeout, source_address, socket_options,
+             ip_options):
+    sock = socket.socket(af, socket.SOCK_STREAM)
+    try:
+        for option in socket_options or ():
+            sock.setsockopt(*option)
+        if ip_options is not None:
+            ip_options.apply(sock)
+        if timeout is not socket._GLOBAL_DEFAULT_TIMEOUT:
+            sock.settimeout(timeout)
+        if source_address:
+            sock.bind(source_address)
+        sock.connect(sockaddr)
+    except Exception:
+        sock.close()
+        raise
+    return sock
+
+
+def create_connection(address, family, timeout=socket._GLOBAL_DEFAULT_TIMEOUT,
+                      source_address=None, socket_options=None,
+                      delay=CONNECTION_ATTEMPT_DELAY, ip_options=None):
+    """Connects to the (host, port) :param address:, with the addresses of
+    :param family: -- all of them, racing, with *happy-eyeballs*, and in
+    turn when the family is None.
+
+    The sockets get the :param ip_options: -- see :mod:`loads.qos`.
+
+    Raises the error of the last address when none connects.
+    """
+    host, port = address
+    addresses = get_addresses(host, port, family)
+    if family != 'happy-eyeballs':
+        error = None
+        for af, sockaddr in addresses:
+            try:
+                return _connect(af, sockaddr, timeout, source_address,
+                                socket_options, ip_options)
+            except socket.error, e:
+                error = e
+        raise error
+
+    results = Queue()
+    done = []
+
+    def _attempt(af, sockaddr):
+        try:
+            sock = _connect(af, sockaddr, timeout, source_address,
+                            socket_options, ip_options)
+        except socket.error, e:
+            results.put((None, e))
+            return
+        if done:
+            # another address won
+            sock.close()
+        else:
+            results.put((sock, None))
+
+    running = 0
+    error = None
+    try:
+        while addresses or running:
+            if addresses:
+                gevent.spawn(_attempt, *addresses.pop(0))
+                running += 1
+            try:
+                sock, e = results.get(timeout=addresses and delay or None)
+            except Empty:
+                # too slow: the next address gets its chance too
+                continue
+            running -= 1
+            if sock is not None:
+                return sock
+            error = e
+    finally:
+        done.append(True)
+        # the other attempts that connected meanwhile
+        while not results.empty():
+            other = results.get()[0]
+            if other is not None:
+                other.close()
+    raise error
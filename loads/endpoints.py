@@ -0,0 +1,61 @@
+"""The endpoints of the HTTP requests: the hits are aggregated by endpoint,
+not by URL, so */users/123* and */users/456* are the one row of
+*/users/{id}*.
+
+By default, the ids of the paths -- numbers, UUIDs and hashes -- are
+replaced by placeholders, and the query strings are dropped.
+*--url-pattern* gives the labels of the URLs matching a regular
+expression, and *--no-url-grouping* keeps every URL apart::
+
+    $ loads-runner example.TestWebSite.test_es \\
+        --url-pattern '/search/=search' --url-pattern '\\.(css|js)$=assets'
+
+A request can also have its own label, explicitly::
+
+    self.session.get(url, label='home page')
+"""
+import re
+
+from loads.util import get_url_pattern
+
+
+def parse_pattern(value):
+    """Returns the (regular expression, label) of a *REGEX=LABEL* option --
+    the label is after the last "="."""
+    if '=' not in value:
+        raise ValueError('No label in the URL pattern %r' % value)
+    regex, label = value.rsplit('=', 1)
+    if not regex or not label.strip():
+        raise ValueError('Invalid URL pattern %r' % value)
+    return re.compile(regex), label.strip()
+
+
+class Endpoints(object):
+    """Gives the endpoints of the URLs.
+
+    :param patterns: the (regular expression, label) of the URLs with a
+                     label -- the first one matching the URL is used.
+    :param grouping: when False, the URLs without a label are their own
+                     endpoint.
+    """
+    def __init__(self, patterns=(), grouping=True):
+        self.patterns = [isinstance(pattern, basestring) and
+                         parse_pattern(pattern) or pattern
+                         for pattern in patterns]
+        self.grouping = grouping
+
+    def get_endpoint(self, url, label=None):
+        if label is not None:
+            return label
+        for regex, label in self.patterns:
+            if regex.search(url):
+                return label
+        if self.grouping:
+            return get_url_pattern(url)
+        return url
+
+
+def get_endpoints(config):
+    """Returns the endpoints of the options."""
+    return Endpoints(config.get('url_pattern') or (),
+                     not config.get('no_url_grouping'))
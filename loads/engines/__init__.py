@@ -0,0 +1,126 @@
+import time
+from datetime import datetime
+
+
+_ENGINES = {}
+
+# the engines of loads, registered when their module is imported
+_BUILTIN_ENGINES = {'evloop': 'loads.engines.evloop'}
+
+
+class Engine(object):
+    """Base class for the protocol engines.
+
+    An engine is a client for a given protocol. To add one, subclass this
+    class, set its *name* and implement :meth:`issue_request`, then
+    register it with :func:`register_engine`. The tests can then use it
+    with :meth:`loads.case.TestCase.create_engine`.
+
+    *options* lists the options the engine accepts, as a mapping of
+    option name to a (default, help, type) tuple. The values are available
+    in *params*.
+
+    Every request issued with :meth:`request` is reported to the test
+    result as a hit on the url returned by :meth:`get_url`. Its status is
+    the one returned by :meth:`get_status`, or the name of the exception
+    raised.
+    """
+    name = ''
+    options = {}
+
+    def __init__(self, test_result=None, test_case=None, **kw):
+        if self.name == '':
+            raise ValueError('You need to set a name')
+
+        self._test_result = test_result
+        self.test_case = test_case
+        self.params = {}
+        for key, (default, help, type) in self.options.items():
+            value = kw.get(key, default)
+            if value is not None:
+                value = type(value)
+            self.params[key] = value
+
+    #
+    # APIs
+    #
+    def setup(self):
+        """Called once, before the first request."""
+        pass
+
+    def issue_request(self, *args, **kw):
+        """Sends a request and returns its response."""
+        raise NotImplementedError()
+
+    def teardown(self):
+        """Called once, when the test is over."""
+        pass
+
+    def metrics(self):
+        """Returns the engine metrics, as a mapping of name to value.
+
+        They are added to the custom metrics of the test on teardown.
+        """
+        return {}
+
+    def get_url(self, *args, **kw):
+        """Returns the url to report for a request."""
+        return '%s://' % self.name
+
+    def get_status(self, response):
+        """Returns the status to report for a response."""
+        return 'OK'
+
+    #
+    # Used by the test case
+    #
+    def request(self, *args, **kw):
+        started = datetime.utcnow()
+        start = time.time()
+        status = None
+        try:
+            response = self.issue_request(*args, **kw)
+            status = self.get_status(response)
+            return response
+        except Exception as e:
+            status = e.__class__.__name__
+            raise
+        finally:
+            self._add_hit(self.get_url(*args, **kw), status, started,
+                          time.time() - start)
+
+    def _add_hit(self, url, status, started, elapsed):
+        if self._test_result is None:
+            return
+
+        loads_status = None
+        if self.test_case is not None:
+            loads_status = self.test_case._loads_status
+
+        self._test_result.add_hit(url=url, method=self.name.upper(),
+                                  status=status, started=started,
+                                  elapsed=elapsed, loads_status=loads_status)
+
+    def close(self):
+        try:
+            self.teardown()
+        finally:
+            if self.test_case is not None and self._test_result is not None:
+                for name, value in self.metrics().items():
+                    self.test_case.incr_counter(name, value)
+
+
+def register_engine(klass):
+    _ENGINES[klass.name] = klass
+
+
+def get_engine(name):
+    if name not in _ENGINES and name in _BUILTIN_ENGINES:
+        __import__(_BUILTIN_ENGINES[name])
+    if name not in _ENGINES:
+        raise NotImplementedError(name)
+    return _ENGINES[name]
+
+
+def engine_list():
+    return _ENGINES.values()
@@ -0,0 +1,392 @@
+from __future__ import absolute_import
+
+import random
+import socket
+import struct
+import time
+from datetime import datetime
+
+import gevent
+from gevent.pool import Pool
+
+
+TRANSPORTS = ('udp', 'tcp', 'tls')
+TYPES = {'A': 1, 'NS': 2, 'CNAME': 5, 'SOA': 6, 'PTR': 12, 'MX': 15,
+         'TXT': 16, 'AAAA': 28, 'SRV': 33, 'ANY': 255, 'CAA': 257}
+RCODES = ('NOERROR', 'FORMERR', 'SERVFAIL', 'NXDOMAIN', 'NOTIMP', 'REFUSED')
+
+_HEADER = struct.Struct('!HHHHHH')
+_QUESTION = struct.Struct('!HH')
+_RECORD = struct.Struct('!HHIH')
+_LENGTH = struct.Struct('!H')
+_TYPE_NAMES = dict([(value, name) for name, value in TYPES.items()])
+# the flags of the responses
+_TRUNCATED = 0x0200
+_RECURSION = 0x0100
+
+
+class DNSError(AssertionError):
+    """A response that is not the expected one, with the status of its
+    hit."""
+
+    def __init__(self, status, message):
+        self.status = status
+        super(DNSError, self).__init__(message)
+
+
+def get_type(rdtype):
+    """Returns the number of a record type, like "AAAA" or "TYPE65"."""
+    if isinstance(rdtype, (int, long)):
+        return rdtype
+    rdtype = rdtype.upper()
+    if rdtype in TYPES:
+        return TYPES[rdtype]
+    if rdtype.startswith('TYPE') and rdtype[4:].isdigit():
+        return int(rdtype[4:])
+    raise ValueError('Unknown record type %r' % rdtype)
+
+
+def get_type_name(rdtype):
+    return _TYPE_NAMES.get(rdtype, 'TYPE%d' % rdtype)
+
+
+def get_rcode_name(rcode):
+    if rcode < len(RCODES):
+        return RCODES[rcode]
+    return 'RCODE%d' % rcode
+
+
+def _encode_name(name):
+    labels = [label for label in name.rstrip('.').split('.') if label]
+    for label in labels:
+        if len(label) > 63:
+            raise ValueError('The label %r is too long' % label)
+    return ''.join([chr(len(label)) + label for label in labels]) + '\0'
+
+
+def build_query(name, rdtype='A', id=None, recursion=True):
+    """Returns the message of a query, and its id."""
+    if id is None:
+        id = random.randint(0, 0xffff)
+    flags = recursion and _RECURSION or 0
+    message = (_HEADER.pack(id, flags, 1, 0, 0, 0) + _encode_name(name) +
+               _QUESTION.pack(get_type(rdtype), 1))
+    return message, id
+
+
+def _read_name(data, offset):
+    """Returns a name of a message, and the offset after it -- the names
+    can point to the ones before them."""
+    labels = []
+    end = None
+    for jump in range(128):
+        length = ord(data[offset])
+        if length & 0xc0 == 0xc0:
+            if end is None:
+                end = offset + 2
+            offset = _LENGTH.unpack(data[offset:offset + 2])[0] & 0x3fff
+            continue
+        if length == 0:
+            break
+        labels.append(data[offset + 1:offset + 1 + length])
+        offset += 1 + length
+    else:
+        raise ValueError('Too many pointers in a name')
+    if end is None:
+        end = offset + 1
+    return '.'.join(labels) + '.', end
+
+
+def _read_rdata(data, offset, length, rdtype):
+    rdata = data[offset:offset + length]
+    if rdtype == TYPES['A'] and length == 4:
+        return socket.inet_ntoa(rdata)
+    if rdtype == TYPES['AAAA'] and length == 16:
+        return socket.inet_ntop(socket.AF_INET6, rdata)
+    if rdtype in (TYPES['NS'], TYPES['CNAME'], TYPES['PTR']):
+        return _read_name(data, offset)[0]
+    if rdtype == TYPES['MX']:
+        return '%d %s' % (_LENGTH.unpack(rdata[:2])[0],
+                          _read_name(data, offset + 2)[0])
+    if rdtype == TYPES['SRV']:
+        priority, weight, port = struct.unpack('!HHH', rdata[:6])
+        return '%d %d %d %s' % (priority, weight, port,
+                                _read_name(data, offset + 6)[0])
+    if rdtype == TYPES['TXT']:
+        strings, index = [], 0
+        while index < length:
+            size = ord(rdata[index])
+            strings.append(rdata[index + 1:index + 1 + size])
+            index += 1 + size
+        return ''.join(strings)
+    if rdtype == TYPES['SOA']:
+        mname, end = _read_name(data, offset)
+        rname, end = _read_name(data, end)
+        return '%s %s %s' % (mname, rname, ' '.join(
+            [str(value) for value in struct.unpack('!IIIII',
+                                                   data[end:end + 20])]))
+    return rdata.encode('hex')
+
+
+class Response(object):
+    """A DNS response: its *id*, *rcode* -- like "NXDOMAIN" -- whether it
+    is *truncated*, and its *answers*, the (name, type, ttl, value) of
+    the records."""
+
+    def __init__(self, id, rcode, truncated, answers, size):
+        self.id = id
+        self.rcode = rcode
+        self.truncated = truncated
+        self.answers = answers
+        self.size = size
+
+    @property
+    def values(self):
+        return [value for name, rdtype, ttl, value in self.answers]
+
+
+def parse_response(data):
+    """Returns the :class:`Response` of a message."""
+    if len(data) < _HEADER.size:
+        raise ValueError('A DNS message has a header')
+    id, flags, questions, answers, authorities, additionals = \
+        _HEADER.unpack(data[:_HEADER.size])
+    offset = _HEADER.size
+    for index in range(questions):
+        offset = _read_name(data, offset)[1] + _QUESTION.size
+
+    records = []
+    for index in range(answers):
+        name, offset = _read_name(data, offset)
+        rdtype, rdclass, ttl, length = _RECORD.unpack(
+            data[offset:offset + _RECORD.size])
+        offset += _RECORD.size
+        records.append((name, get_type_name(rdtype), ttl,
+                        _read_rdata(data, offset, length, rdtype)))
+        offset += length
+    return Response(id, get_rcode_name(flags & 0xf),
+                    bool(flags & _TRUNCATED), records, len(data))
+
+
+def _recv_exactly(sock, size):
+    data = ''
+    while len(data) < size:
+        chunk = sock.recv(size - len(data))
+        if not chunk:
+            raise IOError('The connection was closed')
+        data += chunk
+    return data
+
+
+class DNSClient(object):
+    """A DNS client, querying a server over UDP, TCP, or TLS -- DNS over
+    TLS, on the port 853 by default.
+
+    Every query is reported to the test result as a hit with a
+    *dns://server:port/name/TYPE* url and a *DNS* method. Its status is
+    *OK* when the response is the one expected, its RCODE otherwise --
+    like *NXDOMAIN* -- or *BAD_ANSWER* when the answers are not the
+    expected ones, and *TIMEOUT* without a response in :param timeout:
+    seconds.
+
+    The truncated responses over UDP are queried again over TCP, and
+    counted in the *dns-truncated* custom metric of the test. Over TCP and
+    TLS, the connections are kept for the next queries.
+
+    :param verify: checks the certificate of the server over TLS, for
+                   :param server_hostname: -- *server* by default.
+    """
+    def __init__(self, server, port=None, transport='udp', test_result=None,
+                 test_case=None, timeout=2., recursion=True, verify=True,
+                 server_hostname=None):
+        if transport not in TRANSPORTS:
+            raise ValueError('Unknown transport %r' % transport)
+        if port is None:
+            port = transport == 'tls' and 853 or 53
+        self.server = server
+        self.port = port
+        self.transport = transport
+        self._test_result = test_result
+        self.test_case = test_case
+        self.timeout = timeout
+        self.recursion = recursion
+        self.verify = verify
+        self.server_hostname = server_hostname or server
+        self._connections = []
+
+    def _get_address(self, socktype):
+        info = socket.getaddrinfo(self.server, self.port, 0, socktype)[0]
+        return info[0], info[4]
+
+    def _query_udp(self, message, id):
+        family, address = self._get_address(socket.SOCK_DGRAM)
+        sock = socket.socket(family, socket.SOCK_DGRAM)
+        try:
+            sock.settimeout(self.timeout)
+            sock.sendto(message, address)
+            deadline = time.time() + self.timeout
+            while True:
+                data = sock.recv(65535)
+                # the responses to the queries before are dropped
+                if len(data) >= 2 and _LENGTH.unpack(data[:2])[0] == id:
+                    return data
+                remaining = deadline - time.time()
+                if remaining <= 0:
+                    raise socket.timeout('timed out')
+                sock.settimeout(remaining)
+        finally:
+            sock.close()
+
+    def _connect(self):
+        family, address = self._get_address(socket.SOCK_STREAM)
+        sock = socket.socket(family, socket.SOCK_STREAM)
+        sock.settimeout(self.timeout)
+        sock.connect(address)
+        if self.transport == 'tls':
+            import ssl
+            context = ssl.create_default_context()
+            if not self.verify:
+                context.check_hostname = False
+                context.verify_mode = ssl.CERT_NONE
+            sock = context.wrap_socket(sock,
+                                       server_hostname=self.server_hostname)
+        return sock
+
+    def _query_stream(self, message, id):
+        # a free connection, or a new one
+        sock = self._connections and self._connections.pop() or None
+        reused = sock is not None
+        if sock is None:
+            sock = self._connect()
+        try:
+            sock.sendall(_LENGTH.pack(len(message)) + message)
+            length, = _LENGTH.unpack(_recv_exactly(sock, 2))
+            data = _recv_exactly(sock, length)
+        except socket.timeout:
+            sock.close()
+            raise
+        except (IOError, socket.error):
+            sock.close()
+            if not reused:
+                raise
+            # closed by the server since the last query
+            return self._query_stream(message, id)
+        self._connections.append(sock)
+        return data
+
+    def _check(self, response, rcode, expect):
+        if rcode is not None and response.rcode != rcode:
+            return response.rcode
+        if expect is None:
+            return 'OK'
+        if callable(expect):
+            valid = expect(response)
+        else:
+            if isinstance(expect, basestring):
+                expect = [expect]
+            valid = set(expect) <= set(response.values)
+        return valid and 'OK' or 'BAD_ANSWER'
+
+    def query(self, name, rdtype='A', rcode='NOERROR', expect=None):
+        """Queries the records of a name, and returns the
+        :class:`Response`.
+
+        Raises :class:`DNSError` when the RCODE is not :param rcode: --
+        any RCODE with None -- or when the answers do not have the values
+        of :param expect:, or when it's a callable returning a false value
+        for the response.
+        """
+        message, id = build_query(name, rdtype, recursion=self.recursion)
+        started = datetime.utcnow()
+        start = time.time()
+        status = 'TIMEOUT'
+        try:
+            if self.transport == 'udp':
+                data = self._query_udp(message, id)
+                if parse_response(data).truncated:
+                    self._incr_counter('dns-truncated', 1)
+                    data = self._query_stream(message, id)
+            else:
+                data = self._query_stream(message, id)
+            response = parse_response(data)
+            status = self._check(response, rcode, expect)
+        except socket.timeout:
+            raise
+        except Exception:
+            status = 'ERROR'
+            raise
+        finally:
+            self._add_hit(name, rdtype, status, started, time.time() - start)
+
+        if status != 'OK':
+            raise DNSError(status, '%s %s: %s %s' % (
+                name, rdtype, status, ', '.join(response.values)))
+        return response
+
+    def run(self, queries, count=None, duration=None, qps=None,
+            concurrency=100, **options):
+        """Sends the :param queries: -- (name, type) tuples, in turn --
+        :param count: times or for :param duration: seconds, at :param qps:
+        queries per second -- as fast as possible if not set.
+
+        The queries are sent by :param concurrency: greenlets at most, with
+        the *options* of :meth:`query`. Returns a dict with the number of
+        queries *sent*, and the ones that *failed*, by status.
+        """
+        if count is None and duration is None:
+            count = len(queries)
+        stats = {'sent': 0, 'failed': {}}
+        pool = Pool(concurrency)
+
+        def _query(name, rdtype):
+            try:
+                self.query(name, rdtype, **options)
+                return
+            except DNSError, e:
+                status = e.status
+            except socket.timeout:
+                status = 'TIMEOUT'
+            except (IOError, socket.error, ValueError):
+                status = 'ERROR'
+            stats['failed'][status] = stats['failed'].get(status, 0) + 1
+
+        started = time.time()
+        index = 0
+        while count is None or index < count:
+            if duration is not None and time.time() - started >= duration:
+                break
+            if qps is not None:
+                delay = started + index / float(qps) - time.time()
+                if delay > 0:
+                    gevent.sleep(delay)
+            name, rdtype = queries[index % len(queries)]
+            pool.spawn(_query, name, rdtype)
+            stats['sent'] += 1
+            index += 1
+        pool.join()
+        return stats
+
+    def close(self):
+        for sock in self._connections:
+            sock.close()
+        self._connections[:] = []
+
+    def _incr_counter(self, name, value):
+        if self.test_case is None or self._test_result is None:
+            return
+        if value > 0:
+            self.test_case.incr_counter(name, value)
+
+    def _add_hit(self, name, rdtype, status, started, elapsed):
+        if self._test_result is None:
+            return
+
+        loads_status = None
+        if self.test_case is not None:
+            loads_status = self.test_case._loads_status
+
+        url = 'dns://%s:%s/%s/%s' % (self.server, self.port,
+                                     name.rstrip('.'), rdtype)
+        self._test_result.add_hit(url=url, method='DNS', status=status,
+                                  started=started, elapsed=elapsed,
+                                  loads_status=loads_status)
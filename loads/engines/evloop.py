@@ -0,0 +1,517 @@
+"""An engine that keeps its connections on a single event loop -- epoll on
+Linux, poll or select elsewhere -- instead of a greenlet per connection,
+for the tests that hold hundreds of thousands of them, mostly idle::
+
+    class TestPresence(TestCase):
+
+        def test_idle(self):
+            engine = self.create_engine('evloop', host='localhost',
+                                        port=8080, connections=100000,
+                                        websocket='/presence')
+            engine.request('ping')
+            engine.hold(60)
+
+A connection only costs its socket and its buffers, and nothing runs for
+the idle ones. The options are:
+
+- *host*, *port* and *connections*: where to connect, and how many times.
+- *framing*, *delimiter* and *length_size*: the framing of the messages,
+  like for :class:`loads.engines.tcp.TCPClient`.
+- *websocket*: the path of the web socket the connections open. The
+  messages are then unfragmented text frames, and the pings of the server
+  get their pongs.
+- *timeout*: how long the connections and the replies are waited for.
+
+:meth:`EventLoopEngine.request` sends a message on the next connection and
+returns its reply, :meth:`EventLoopEngine.broadcast` sends a message on
+every connection, and :meth:`EventLoopEngine.hold` keeps the connections
+open for a while. The connections opened, failed and closed by the server
+are the *evloop-opened*, *evloop-failed* and *evloop-closed* custom
+metrics of the test.
+
+There is no io_uring loop: Python 2 has no binding for it, and epoll
+already costs per active connection, not per idle one. Raise the limit of
+the open files -- ``ulimit -n`` -- to go beyond a thousand connections;
+select can't.
+"""
+import base64
+import errno
+import hashlib
+import os
+import select
+import socket
+import struct
+import time
+from loads.engines import Engine, register_engine
+from loads.engines.tcp import FRAMINGS, _LENGTH_FORMATS
+
+
+# the values of poll and epoll -- the errors and the hang-ups are read
+READ = 0x001
+WRITE = 0x004
+_ERRORS = 0x008 | 0x010
+
+_CONNECTING, _HANDSHAKE, _OPEN, _CLOSED = range(4)
+_WOULD_BLOCK = (errno.EAGAIN, errno.EWOULDBLOCK)
+_IN_PROGRESS = (0, errno.EINPROGRESS, errno.EWOULDBLOCK)
+
+_WS_GUID = '258EAFA5-E914-47DA-95CA-C5AB0DC85B11'
+_WS_TEXT, _WS_CLOSE, _WS_PING, _WS_PONG = 0x1, 0x8, 0x9, 0xA
+_WS_HANDSHAKE = ('GET %s HTTP/1.1\r\nHost: %s:%d\r\nUpgrade: websocket\r\n'
+                 'Connection: Upgrade\r\nSec-WebSocket-Key: %s\r\n'
+                 'Sec-WebSocket-Version: 13\r\n\r\n')
+
+
+def _get_original(name):
+    # gevent takes epoll away from the select module it patches
+    try:
+        from gevent.monkey import get_original
+        return get_original('select', name)
+    except (ImportError, AttributeError):
+        return getattr(select, name, None)
+
+
+class _EpollPoller(object):
+
+    def __init__(self, epoll):
+        self._epoll = epoll()
+        self.register = self._epoll.register
+        self.modify = self._epoll.modify
+        self.unregister = self._epoll.unregister
+
+    def poll(self, timeout):
+        # waiting on the descriptor of epoll lets the other greenlets run
+        # when select is patched by gevent
+        if not select.select([self._epoll.fileno()], [], [], timeout)[0]:
+            return []
+        return self._epoll.poll(0)
+
+    def close(self):
+        self._epoll.close()
+
+
+class _PollPoller(object):
+
+    def __init__(self):
+        self._poll = select.poll()
+        self.register = self._poll.register
+        self.modify = self._poll.modify
+        self.unregister = self._poll.unregister
+
+    def poll(self, timeout):
+        return self._poll.poll(timeout * 1000)
+
+    def close(self):
+        pass
+
+
+class _SelectPoller(object):
+
+    def __init__(self):
+        self._readers = set()
+        self._writers = set()
+
+    def register(self, fd, events):
+        self.unregister(fd)
+        if events & READ:
+            self._readers.add(fd)
+        if events & WRITE:
+            self._writers.add(fd)
+
+    modify = register
+
+    def unregister(self, fd):
+        self._readers.discard(fd)
+        self._writers.discard(fd)
+
+    def poll(self, timeout):
+        readers, writers, errors = select.select(
+            self._readers, self._writers, self._writers, timeout)
+        events = {}
+        for fds, event in ((readers, READ), (writers, WRITE),
+                           (errors, _ERRORS)):
+            for fd in fds:
+                events[fd] = events.get(fd, 0) | event
+        return events.items()
+
+    def close(self):
+        pass
+
+
+def get_poller():
+    """Returns the best poller of the platform."""
+    epoll = _get_original('epoll')
+    if epoll is not None:
+        return _EpollPoller(epoll)
+    if hasattr(select, 'poll'):
+        return _PollPoller()
+    return _SelectPoller()
+
+
+def _mask(payload, key):
+    key = [ord(c) for c in key]
+    return ''.join([chr(ord(c) ^ key[i % 4]) for i, c in enumerate(payload)])
+
+
+def websocket_frame(payload, opcode=_WS_TEXT):
+    """Returns the masked frame of a client message."""
+    first = 0x80 | opcode
+    size = len(payload)
+    if size < 126:
+        header = struct.pack('!BB', first, 0x80 | size)
+    elif size < 65536:
+        header = struct.pack('!BBH', first, 0x80 | 126, size)
+    else:
+        header = struct.pack('!BBQ', first, 0x80 | 127, size)
+    key = os.urandom(4)
+    return header + key + _mask(payload, key)
+
+
+def parse_websocket_frame(data):
+    """Returns the (opcode, payload, rest) of the first frame received in
+    :param data:, or None while it is incomplete."""
+    if len(data) < 2:
+        return None
+    first, second = ord(data[0]), ord(data[1])
+    size, offset = second & 0x7f, 2
+    if size == 126:
+        if len(data) < 4:
+            return None
+        size, = struct.unpack('!H', data[2:4])
+        offset = 4
+    elif size == 127:
+        if len(data) < 10:
+            return None
+        size, = struct.unpack('!Q', data[2:10])
+        offset = 10
+    key = None
+    if second & 0x80:
+        key, offset = data[offset:offset + 4], offset + 4
+    if len(data) < offset + size:
+        return None
+    payload = data[offset:offset + size]
+    if key is not None:
+        payload = _mask(payload, key)
+    return first & 0x0f, payload, data[offset + size:]
+
+
+class _Connection(object):
+    __slots__ = ('sock', 'fd', 'state', 'started', 'output', 'input',
+                 'accept', 'replies')
+
+    def __init__(self, sock):
+        self.sock = sock
+        self.fd = sock.fileno()
+        self.state = _CONNECTING
+        self.started = time.time()
+        self.output = self.input = ''
+        self.accept = None
+        # the messages received while a request waits for its reply
+        self.replies = None
+
+
+class EventLoopEngine(Engine):
+    """Keeps a lot of connections on an event loop -- see
+    :mod:`loads.engines.evloop`."""
+    name = 'evloop'
+    options = {
+        'host': ('localhost', 'The host to connect to', str),
+        'port': (None, 'The port to connect to', int),
+        'connections': (1, 'The number of connections', int),
+        'framing': ('raw', 'The framing of the messages', str),
+        'delimiter': ('\n', 'The end of the delimited messages', str),
+        'length_size': (4, 'The size of the length prefixes', int),
+        'websocket': (None, 'The path of the web socket to open', str),
+        'timeout': (30., 'The seconds to wait for the connections and '
+                         'the replies', float),
+        'buffer_size': (65536, 'The size of the reads', int)}
+
+    def get_url(self, *args, **kw):
+        url = '%s:%d' % (self.params['host'], self.params['port'])
+        if self.params['websocket'] is not None:
+            return 'ws://%s%s' % (url, self.params['websocket'])
+        return 'tcp://%s' % url
+
+    def setup(self):
+        framing = self.params['framing']
+        if framing not in FRAMINGS:
+            raise ValueError('Unknown framing %r' % framing)
+        if (framing == 'length' and
+                self.params['length_size'] not in _LENGTH_FORMATS):
+            raise ValueError('The length prefix is 1, 2, 4 or 8 bytes')
+        if self.params['port'] is None:
+            raise ValueError('The port to connect to is missing')
+
+        self.opened = self.failed = self.closed = 0
+        self._poller = get_poller()
+        self._connections = {}
+        self._open = []
+        self._next = 0
+        self._connecting = 0
+        self._pending = set()
+
+        family, sock_type, proto, _, address = socket.getaddrinfo(
+            self.params['host'], self.params['port'], 0,
+            socket.SOCK_STREAM)[0]
+        for i in range(self.params['connections']):
+            self._connect(family, sock_type, proto, address)
+        self._run(lambda: not self._connecting, self.params['timeout'])
+        for connection in self._connections.values():
+            if connection.state in (_CONNECTING, _HANDSHAKE):
+                self._fail(connection)
+
+    #
+    # APIs
+    #
+    def issue_request(self, payload):
+        """Sends a message on the next connection, and returns its reply."""
+        if not self._open:
+            raise socket.error('No connection is open')
+        connection = self._open[self._next % len(self._open)]
+        self._next += 1
+
+        start = time.time()
+        connection.replies = replies = []
+        self._send(connection, self._frame(payload))
+        self._run(lambda: replies or connection.state == _CLOSED,
+                  self.params['timeout'])
+        connection.replies = None
+        if not replies:
+            if connection.state == _CLOSED:
+                raise socket.error('Connection closed by %s:%s' % (
+                    self.params['host'], self.params['port']))
+            raise socket.timeout('timed out')
+
+        if self._test_result is not None:
+            self._test_result.socket_rtt(time.time() - start)
+        return replies[0]
+
+    def broadcast(self, payload):
+        """Sends a message on every open connection, and returns how many
+        there are."""
+        data = self._frame(payload)
+        for connection in self._open:
+            self._send(connection, data)
+        self._run(lambda: not self._pending, self.params['timeout'])
+        return len(self._open)
+
+    def hold(self, seconds):
+        """Keeps the connections for :param seconds:, or until the server
+        closed them all."""
+        self._run(lambda: not self._connections, seconds)
+
+    def metrics(self):
+        return {'evloop-opened': self.opened, 'evloop-failed': self.failed,
+                'evloop-closed': self.closed}
+
+    def teardown(self):
+        # closing the sockets takes them away from the poller
+        for connection in self._connections.values():
+            if (connection.state == _OPEN and
+                    self._test_result is not None):
+                self._test_result.socket_close()
+            connection.state = _CLOSED
+            connection.sock.close()
+        self._connections.clear()
+        self._pending.clear()
+        self._open = []
+        self._poller.close()
+
+    #
+    # The loop
+    #
+    def _run(self, until, timeout):
+        deadline = time.time() + timeout
+        while not until():
+            remaining = deadline - time.time()
+            if remaining <= 0:
+                return
+            for fd, events in self._poller.poll(remaining):
+                connection = self._connections.get(fd)
+                if connection is not None:
+                    self._handle(connection, events)
+
+    def _handle(self, connection, events):
+        if connection.state == _CONNECTING:
+            if connection.sock.getsockopt(socket.SOL_SOCKET,
+                                          socket.SO_ERROR):
+                self._fail(connection)
+            else:
+                self._connected(connection)
+            return
+        if events & (READ | _ERRORS):
+            self._read(connection)
+        if connection.state != _CLOSED and events & WRITE:
+            self._write(connection)
+
+    def _connect(self, family, sock_type, proto, address):
+        sock = socket.socket(family, sock_type, proto)
+        sock.setblocking(0)
+        connection = _Connection(sock)
+        self._connections[connection.fd] = connection
+        self._connecting += 1
+        if sock.connect_ex(address) not in _IN_PROGRESS:
+            self._fail(connection)
+            return
+        self._poller.register(connection.fd, WRITE)
+
+    def _connected(self, connection):
+        path = self.params['websocket']
+        if path is None:
+            self._poller.modify(connection.fd, READ)
+            self._opened(connection)
+            return
+
+        key = base64.b64encode(os.urandom(16))
+        connection.accept = base64.b64encode(
+            hashlib.sha1(key + _WS_GUID).digest())
+        connection.state = _HANDSHAKE
+        self._poller.modify(connection.fd, READ)
+        self._send(connection, _WS_HANDSHAKE % (path, self.params['host'],
+                                                self.params['port'], key))
+
+    def _opened(self, connection):
+        connection.state = _OPEN
+        self._connecting -= 1
+        self.opened += 1
+        self._open.append(connection)
+        if self._test_result is not None:
+            self._test_result.socket_open(time.time() - connection.started)
+
+    def _send(self, connection, data):
+        connection.output += data
+        if connection not in self._pending:
+            self._pending.add(connection)
+            self._poller.modify(connection.fd, READ | WRITE)
+
+    def _write(self, connection):
+        try:
+            sent = connection.sock.send(connection.output)
+        except socket.error, e:
+            if e.args[0] not in _WOULD_BLOCK:
+                self._lost(connection)
+            return
+        connection.output = connection.output[sent:]
+        if not connection.output:
+            self._pending.discard(connection)
+            self._poller.modify(connection.fd, READ)
+
+    def _read(self, connection):
+        try:
+            data = connection.sock.recv(self.params['buffer_size'])
+        except socket.error, e:
+            if e.args[0] in _WOULD_BLOCK:
+                return
+            data = ''
+        if not data:
+            self._lost(connection)
+            return
+
+        connection.input += data
+        if connection.state == _HANDSHAKE:
+            if '\r\n\r\n' not in connection.input:
+                return
+            head, connection.input = connection.input.split('\r\n\r\n', 1)
+            if not self._accepted(connection, head):
+                self._fail(connection)
+                return
+            self._opened(connection)
+        for message in self._parse(connection):
+            if self._test_result is not None:
+                self._test_result.socket_message(len(message))
+            if connection.replies is not None:
+                connection.replies.append(message)
+
+    def _accepted(self, connection, head):
+        lines = head.split('\r\n')
+        if lines[0].split(' ')[1:2] != ['101']:
+            return False
+        for line in lines[1:]:
+            name, _, value = line.partition(':')
+            if name.strip().lower() == 'sec-websocket-accept':
+                return value.strip() == connection.accept
+        return False
+
+    def _lost(self, connection):
+        if connection.state == _OPEN:
+            self.closed += 1
+            if self._test_result is not None:
+                self._test_result.socket_disconnect()
+            self._close(connection)
+        else:
+            self._fail(connection)
+
+    def _fail(self, connection):
+        self.failed += 1
+        self._connecting -= 1
+        self._close(connection)
+
+    def _close(self, connection):
+        if connection.state == _OPEN:
+            self._open.remove(connection)
+        if connection.state != _CLOSED:
+            try:
+                self._poller.unregister(connection.fd)
+            except (KeyError, IOError, ValueError):
+                # never registered, when the connection failed at once
+                pass
+        connection.state = _CLOSED
+        connection.sock.close()
+        self._pending.discard(connection)
+        self._connections.pop(connection.fd, None)
+
+    #
+    # The framing
+    #
+    def _frame(self, payload):
+        if self.params['websocket'] is not None:
+            return websocket_frame(payload)
+        framing = self.params['framing']
+        if framing == 'length':
+            fmt = _LENGTH_FORMATS[self.params['length_size']]
+            return struct.pack(fmt, len(payload)) + payload
+        elif framing == 'delimiter':
+            return payload + self.params['delimiter']
+        return payload
+
+    def _parse(self, connection):
+        """Returns the messages received, and keeps the rest."""
+        messages = []
+        if self.params['websocket'] is not None:
+            while connection.state == _OPEN:
+                frame = parse_websocket_frame(connection.input)
+                if frame is None:
+                    break
+                opcode, payload, connection.input = frame
+                if opcode == _WS_PING:
+                    self._send(connection, websocket_frame(payload,
+                                                           _WS_PONG))
+                elif opcode == _WS_CLOSE:
+                    self._lost(connection)
+                elif opcode != _WS_PONG:
+                    messages.append(payload)
+            return messages
+
+        framing = self.params['framing']
+        if framing == 'length':
+            size = self.params['length_size']
+            fmt = _LENGTH_FORMATS[size]
+            while len(connection.input) >= size:
+                length, = struct.unpack(fmt, connection.input[:size])
+                if len(connection.input) < size + length:
+                    break
+                messages.append(connection.input[size:size + length])
+                connection.input = connection.input[size + length:]
+        elif framing == 'delimiter':
+            delimiter = self.params['delimiter']
+            while delimiter in connection.input:
+                message, connection.input = connection.input.split(
+                    delimiter, 1)
+                messages.append(message)
+        elif connection.input:
+            messages.append(connection.input)
+            connection.input = ''
+        return messages
+
+
+register_engine(EventLoopEngine)
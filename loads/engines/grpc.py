@@ -0,0 +1,144 @@
+from __future__ import absolute_import
+
+import time
+from datetime import datetime
+
+from loads.util import try_import
+
+
+STREAMING = ('unary_unary', 'unary_stream', 'stream_unary', 'stream_stream')
+
+
+def load_descriptor_set(filename):
+    """Loads a file descriptor set, as generated by
+    *protoc --include_imports --descriptor_set_out*, into a new pool.
+    """
+    from google.protobuf import descriptor_pb2, descriptor_pool
+
+    descriptors = descriptor_pb2.FileDescriptorSet()
+    with open(filename, 'rb') as f:
+        descriptors.ParseFromString(f.read())
+
+    pool = descriptor_pool.DescriptorPool()
+    for proto in descriptors.file:
+        pool.Add(proto)
+    return pool
+
+
+def _get_message_class(descriptor):
+    from google.protobuf import message_factory
+
+    if hasattr(message_factory, 'GetMessageClass'):
+        return message_factory.GetMessageClass(descriptor)
+    return message_factory.MessageFactory(descriptor.file.pool).GetPrototype(
+        descriptor)
+
+
+class GRPCClient(object):
+    """A gRPC client driven by a descriptor set.
+
+    Every call is reported to the test result as a hit, with the
+    *grpc://target/package.Service/Method* url and the name of the gRPC
+    status code (e.g. *OK*, *UNAVAILABLE*) as its status.
+    """
+    def __init__(self, target, descriptor_set, test_result=None,
+                 test_case=None, secure=False, credentials=None,
+                 options=None):
+        try_import('grpc', 'google.protobuf')
+        import grpc
+
+        self.target = target
+        self._test_result = test_result
+        self.test_case = test_case
+        self.pool = load_descriptor_set(descriptor_set)
+        self._methods = {}
+
+        if secure or credentials is not None:
+            if credentials is None:
+                credentials = grpc.ssl_channel_credentials()
+            self.channel = grpc.secure_channel(target, credentials, options)
+        else:
+            self.channel = grpc.insecure_channel(target, options)
+
+    def _get_method(self, name):
+        name = name.lstrip('/')
+        if name in self._methods:
+            return self._methods[name]
+
+        try:
+            service, method = name.rsplit('/', 1)
+        except ValueError:
+            raise ValueError('%r is not a package.Service/Method name' % name)
+
+        desc = self.pool.FindServiceByName(service).methods_by_name[method]
+        request_class = _get_message_class(desc.input_type)
+        response_class = _get_message_class(desc.output_type)
+        kind = STREAMING[desc.client_streaming * 2 + desc.server_streaming]
+
+        callable_ = getattr(self.channel, kind)(
+            '/' + name,
+            request_serializer=request_class.SerializeToString,
+            response_deserializer=response_class.FromString)
+
+        self._methods[name] = name, kind, request_class, callable_
+        return self._methods[name]
+
+    def _build(self, request_class, request):
+        if isinstance(request, dict):
+            return request_class(**request)
+        return request
+
+    def call(self, method, request=None, timeout=None, metadata=None,
+             **fields):
+        """Calls the given *package.Service/Method*.
+
+        The request can be a message or a dict of its fields, and the
+        fields can also be passed as keyword arguments. For client
+        streaming methods, the request is an iterable of messages or dicts.
+
+        Returns the response, or the list of responses for server streaming
+        methods -- the latency then covers the whole stream.
+        """
+        import grpc
+
+        name, kind, request_class, callable_ = self._get_method(method)
+
+        if kind.startswith('stream_'):
+            request = (self._build(request_class, req) for req in request)
+        else:
+            if request is None:
+                request = fields
+            request = self._build(request_class, request)
+
+        started = datetime.utcnow()
+        start = time.time()
+        status = 'OK'
+        try:
+            response = callable_(request, timeout=timeout, metadata=metadata)
+            if kind.endswith('_stream'):
+                response = list(response)
+            return response
+        except grpc.RpcError as e:
+            status = e.code().name
+            raise
+        except Exception:
+            status = 'UNKNOWN'
+            raise
+        finally:
+            self._add_hit(name, status, started, time.time() - start)
+
+    def _add_hit(self, name, status, started, elapsed):
+        if self._test_result is None:
+            return
+
+        loads_status = None
+        if self.test_case is not None:
+            loads_status = self.test_case._loads_status
+
+        self._test_result.add_hit(url='grpc://%s/%s' % (self.target, name),
+                                  method='GRPC', status=status,
+                                  started=started, elapsed=elapsed,
+                                  loads_status=loads_status)
+
+    def close(self):
+        self.channel.close()
@@ -0,0 +1,53 @@
+from __future__ import absolute_import
+
+from urlparse import urlparse
+
+from gevent.lock import BoundedSemaphore
+
+from loads.util import try_import
+
+try_import('hyper')
+
+from hyper import HTTP20Connection  # NOQA
+from hyper.contrib import HTTP20Adapter  # NOQA
+
+
+DEFAULT_MAX_STREAMS = 100
+
+
+class HTTP2Adapter(HTTP20Adapter):
+    """A Requests adapter speaking HTTP/2.
+
+    HTTPS connections negotiate the protocol with ALPN. Plain HTTP
+    connections either try to upgrade to h2c, or speak HTTP/2 right
+    away when :param prior_knowledge: is set.
+
+    No more than :param max_streams: requests are sent concurrently on
+    the connection to a given host.
+    """
+    def __init__(self, prior_knowledge=False,
+                 max_streams=DEFAULT_MAX_STREAMS, *args, **kw):
+        super(HTTP2Adapter, self).__init__(*args, **kw)
+        self.prior_knowledge = prior_knowledge
+        self.max_streams = max_streams
+        self._streams = {}
+
+    def get_connection(self, host, port, scheme, *args, **kw):
+        if scheme == 'http' and self.prior_knowledge:
+            key = host, port, 'h2c'
+            if key not in self.connections:
+                self.connections[key] = HTTP20Connection(host, port,
+                                                         secure=False)
+            return self.connections[key]
+
+        return super(HTTP2Adapter, self).get_connection(host, port, scheme,
+                                                        *args, **kw)
+
+    def send(self, request, **kw):
+        url = urlparse(request.url)
+        key = url.scheme, url.netloc
+        if key not in self._streams:
+            self._streams[key] = BoundedSemaphore(self.max_streams)
+
+        with self._streams[key]:
+            return super(HTTP2Adapter, self).send(request, **kw)
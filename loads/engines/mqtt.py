@@ -0,0 +1,142 @@
+from __future__ import absolute_import
+
+import threading
+import time
+from datetime import datetime
+
+from loads.util import try_import
+
+try_import('paho.mqtt')
+
+from paho.mqtt import client as mqtt  # NOQA
+
+
+class MQTTClient(object):
+    """A MQTT client, based on paho-mqtt.
+
+    Every publication is reported to the test result as a hit with a
+    *mqtt://host:port/topic* url and a *PUBLISH* method. Its latency is
+    the time it took to send the message for QoS 0, and the time it took
+    to get it acknowledged by the broker for QoS 1 and 2.
+
+    The connection time is reported like for the other sockets, and the
+    connections lost without calling :meth:`disconnect` are counted as
+    disconnections.
+    """
+    def __init__(self, host, port=1883, test_result=None, test_case=None,
+                 client_id='', keepalive=60, timeout=10., username=None,
+                 password=None, tls=False, clean_session=True):
+        self.host = host
+        self.port = port
+        self._test_result = test_result
+        self.test_case = test_case
+        self.keepalive = keepalive
+        self.timeout = timeout
+        self._connected = threading.Event()
+        self._connect_rc = None
+        self._disconnecting = False
+        self._callbacks = {}
+
+        self.client = mqtt.Client(client_id=client_id,
+                                  clean_session=clean_session)
+        if username is not None:
+            self.client.username_pw_set(username, password)
+        if tls:
+            self.client.tls_set()
+
+        self.client.on_connect = self._on_connect
+        self.client.on_disconnect = self._on_disconnect
+        self.client.on_message = self._on_message
+
+    def _on_connect(self, client, userdata, flags, rc):
+        self._connect_rc = rc
+        self._connected.set()
+
+    def _on_disconnect(self, client, userdata, rc):
+        self._connected.clear()
+        if self._disconnecting or self._test_result is None:
+            return
+        if rc != mqtt.MQTT_ERR_SUCCESS:
+            self._test_result.socket_disconnect(rc)
+
+    def _on_message(self, client, userdata, message):
+        if self._test_result is not None:
+            self._test_result.socket_message(len(message.payload))
+
+        callback = self._callbacks.get(message.topic)
+        if callback is None:
+            for topic, callback in self._callbacks.items():
+                if mqtt.topic_matches_sub(topic, message.topic):
+                    break
+            else:
+                return
+        callback(message)
+
+    def connect(self):
+        """Connects to the broker and waits for its acknowledgment."""
+        start = time.time()
+        self._disconnecting = False
+        self.client.connect(self.host, self.port, self.keepalive)
+        self.client.loop_start()
+
+        if not self._connected.wait(self.timeout):
+            self.client.loop_stop()
+            raise IOError('No CONNACK from %s:%s' % (self.host, self.port))
+
+        if self._connect_rc != mqtt.CONNACK_ACCEPTED:
+            self.client.loop_stop()
+            raise IOError(mqtt.connack_string(self._connect_rc))
+
+        if self._test_result is not None:
+            self._test_result.socket_open(time.time() - start)
+
+    def disconnect(self):
+        if not self._connected.is_set():
+            return
+        self._disconnecting = True
+        self.client.disconnect()
+        self.client.loop_stop()
+        if self._test_result is not None:
+            self._test_result.socket_close()
+
+    close = disconnect
+
+    def subscribe(self, topic, qos=0, callback=None):
+        """Subscribes to :param topic:. The messages received are passed to
+        :param callback:, if given.
+        """
+        if callback is not None:
+            self._callbacks[topic] = callback
+        rc, mid = self.client.subscribe(topic, qos)
+        if rc != mqtt.MQTT_ERR_SUCCESS:
+            raise IOError(mqtt.error_string(rc))
+        return mid
+
+    def publish(self, topic, payload=None, qos=0, retain=False):
+        """Publishes a message and waits for it to be acknowledged."""
+        started = datetime.utcnow()
+        start = time.time()
+        info = self.client.publish(topic, payload, qos, retain)
+        status = 'OK'
+        if info.rc != mqtt.MQTT_ERR_SUCCESS:
+            status = mqtt.error_string(info.rc)
+        else:
+            info.wait_for_publish()
+
+        self._add_hit(topic, status, started, time.time() - start)
+        if status != 'OK':
+            raise IOError(status)
+        return info
+
+    def _add_hit(self, topic, status, started, elapsed):
+        if self._test_result is None:
+            return
+
+        loads_status = None
+        if self.test_case is not None:
+            loads_status = self.test_case._loads_status
+
+        url = 'mqtt://%s:%s/%s' % (self.host, self.port, topic)
+        self._test_result.add_hit(url=url, method='PUBLISH', status=status,
+                                  started=started, elapsed=elapsed,
+                                  loads_status=loads_status)
@@ -0,0 +1,209 @@
+from __future__ import absolute_import
+
+import re
+import time
+
+import gevent
+from gevent.pool import Pool
+
+from loads.util import json, parse_timestamp
+
+
+FORMATS = ('combined', 'json')
+
+# the common log format, and the combined one when the referer and the user
+# agent follow
+_COMBINED = re.compile(r'^(?P<host>\S+) \S+ \S+ \[(?P<time>[^\]]+)\] '
+                       r'"(?P<request>[^"]*)" (?P<status>\d{3}|-) \S+'
+                       r'(?: "(?P<referer>[^"]*)" "(?P<agent>[^"]*)")?')
+
+# the fields of the JSON access logs -- the first one found is used
+_JSON_FIELDS = {'time': ('time', 'timestamp', '@timestamp', 'time_iso8601',
+                         'msec', 'date'),
+                'method': ('method', 'request_method', 'verb'),
+                'path': ('path', 'request_uri', 'uri', 'url'),
+                'request': ('request',),
+                'referer': ('referer', 'http_referer', 'referrer'),
+                'agent': ('user_agent', 'http_user_agent', 'agent')}
+
+
+class LogEntry(object):
+    """A request of an access log."""
+
+    def __init__(self, timestamp, method, path, referer=None, agent=None):
+        self.timestamp = timestamp
+        self.method = method
+        self.path = path
+        self.referer = referer
+        self.agent = agent
+
+    def get_headers(self):
+        headers = {}
+        if self.referer and self.referer != '-':
+            headers['Referer'] = self.referer
+        if self.agent and self.agent != '-':
+            headers['User-Agent'] = self.agent
+        return headers
+
+
+def _split_request(request):
+    parts = (request or '').split()
+    if len(parts) < 2:
+        return None, None
+    return parts[0], parts[1]
+
+
+def parse_combined(line):
+    """Returns the entry of a line of a common or combined log, or None."""
+    match = _COMBINED.match(line)
+    if match is None:
+        return None
+    method, path = _split_request(match.group('request'))
+    if method is None:
+        return None
+    return LogEntry(parse_timestamp(match.group('time')), method, path,
+                    match.group('referer'), match.group('agent'))
+
+
+def _get_field(data, name):
+    for field in _JSON_FIELDS[name]:
+        if data.get(field) not in (None, ''):
+            return data[field]
+    return None
+
+
+def parse_json(line):
+    """Returns the entry of a line of a JSON access log, or None."""
+    data = json.loads(line)
+    if not isinstance(data, dict):
+        return None
+
+    method, path = _get_field(data, 'method'), _get_field(data, 'path')
+    if method is None or path is None:
+        method, path = _split_request(_get_field(data, 'request'))
+        if method is None:
+            return None
+
+    timestamp = _get_field(data, 'time')
+    if isinstance(timestamp, basestring):
+        try:
+            timestamp = float(timestamp)
+        except ValueError:
+            timestamp = parse_timestamp(timestamp)
+    elif timestamp is None:
+        return None
+
+    return LogEntry(float(timestamp), method, path,
+                    _get_field(data, 'referer'), _get_field(data, 'agent'))
+
+
+def parse_line(line, format=None):
+    """Returns the entry of a line of an access log, or None when it's not
+    a request. Without a *format*, the lines starting with a "{" are JSON."""
+    line = line.strip()
+    if not line:
+        return None
+    if format is None:
+        format = line.startswith('{') and 'json' or 'combined'
+    try:
+        if format == 'json':
+            return parse_json(line)
+        return parse_combined(line)
+    except ValueError:
+        return None
+
+
+class LogReplayer(object):
+    """Replays the requests of an access log against a target host.
+
+    The requests are sent with the session of the test -- and reported like
+    its other requests -- with the referer and the user agent of the log.
+    They keep the gaps of the log, divided by :param speed:, or are sent at
+    a fixed :param rate: of requests per second. They don't wait for the
+    previous ones to be answered, up to :param concurrency: requests in
+    flight.
+
+    The lines that are not requests, and the requests whose method is not
+    in :param methods: when it's given, are counted in the *replay-skipped*
+    custom metric of the test. The requests that could not be sent are
+    counted in *replay-errors*.
+    """
+    def __init__(self, path, target, test_case=None, format=None, speed=1.,
+                 rate=None, concurrency=100, methods=None, session=None,
+                 clock=time.time, sleep=gevent.sleep):
+        if format is not None and format not in FORMATS:
+            raise ValueError('Unknown format %r' % format)
+        if speed <= 0 or (rate is not None and rate <= 0):
+            raise ValueError('The speed and the rate must be positive')
+
+        self.path = path
+        self.target = target.rstrip('/')
+        self.test_case = test_case
+        self.format = format
+        self.speed = speed
+        self.rate = rate
+        self.concurrency = concurrency
+        if methods is not None:
+            methods = [method.upper() for method in methods]
+        self.methods = methods
+        if session is None:
+            session = test_case.session
+        self.session = session
+        self.clock = clock
+        self.sleep = sleep
+        self._pool = None
+
+    def _incr(self, name):
+        if self.test_case is not None and \
+                self.test_case._test_result is not None:
+            self.test_case.incr_counter(name)
+
+    def read(self):
+        """Yields the entries of the log to replay."""
+        with open(self.path) as f:
+            for line in f:
+                entry = parse_line(line, self.format)
+                if entry is None or (self.methods is not None and
+                                     entry.method.upper() not in
+                                     self.methods):
+                    self._incr('replay-skipped')
+                    continue
+                yield entry
+
+    def _send(self, entry):
+        url = self.target + '/' + entry.path.lstrip('/')
+        try:
+            self.session.request(entry.method, url,
+                                 headers=entry.get_headers())
+        except Exception:
+            self._incr('replay-errors')
+
+    def replay(self, limit=None):
+        """Sends the requests of the log -- at most *limit* of them -- and
+        returns how many were sent once they are all answered."""
+        self._pool = pool = Pool(self.concurrency)
+        start, origin, sent = self.clock(), None, 0
+
+        for entry in self.read():
+            if limit is not None and sent >= limit:
+                break
+            if self.rate is not None:
+                due = start + sent / float(self.rate)
+            else:
+                if origin is None:
+                    origin = entry.timestamp
+                due = start + max(entry.timestamp - origin, 0) / self.speed
+
+            delay = due - self.clock()
+            if delay > 0:
+                self.sleep(delay)
+            pool.spawn(self._send, entry)
+            sent += 1
+
+        pool.join()
+        return sent
+
+    def close(self):
+        if self._pool is not None:
+            self._pool.kill()
+            self._pool = None
@@ -0,0 +1,193 @@
+from __future__ import absolute_import
+
+import json
+import re
+import time
+
+
+DEFAULT_RETRY = 3.
+_EOL = re.compile(r'\r\n|\r|\n')
+
+
+class InvalidEvent(AssertionError):
+    pass
+
+
+class Event(object):
+    """An event of a stream."""
+
+    def __init__(self, data='', event='message', id=None, retry=None):
+        self.data = data
+        self.event = event
+        self.id = id
+        self.retry = retry
+
+    def json(self):
+        return json.loads(self.data)
+
+    def __repr__(self):
+        return '<Event %s %r>' % (self.event, self.data[:50])
+
+
+def iter_lines(chunks):
+    """Returns the lines of the chunks of a stream -- ended by CRLF, LF or
+    CR."""
+    pending = ''
+    for chunk in chunks:
+        pending += chunk
+        start = 0
+        for match in _EOL.finditer(pending):
+            if match.group() == '\r' and match.end() == len(pending):
+                # the LF of a CRLF can be in the next chunk
+                break
+            yield pending[start:match.start()]
+            start = match.end()
+        pending = pending[start:]
+    if pending.endswith('\r'):
+        yield pending[:-1]
+
+
+def iter_events(lines, on_retry=None):
+    """Returns the events of the lines of a stream. The *retry* fields are
+    passed to :param on_retry: as they come, in seconds."""
+    data, fields = [], {}
+    for line in lines:
+        if not line:
+            if data:
+                yield Event('\n'.join(data), fields.get('event') or 'message',
+                            fields.get('id'), fields.get('retry'))
+            data, fields = [], {}
+            continue
+        if line.startswith(':'):
+            # a comment, like the keep-alives of the servers
+            continue
+        name, _, value = line.partition(':')
+        if value.startswith(' '):
+            value = value[1:]
+        if name == 'data':
+            data.append(value)
+        elif name in ('event', 'id'):
+            fields[name] = value
+        elif name == 'retry' and value.isdigit():
+            fields['retry'] = int(value)
+            if on_retry is not None:
+                on_retry(int(value) / 1000.)
+
+
+class SSEClient(object):
+    """A Server-Sent Events client, reading the event streams with the
+    session of a test.
+
+    Opening a stream is a hit. The time from the request to the first
+    event of a stream is added to the *sse-first-event* trend, and the time
+    between two events to the *sse-event-interval* one -- see
+    :mod:`loads.custom`. The streams, the events, the drops and the
+    reconnections are counted in the *sse-streams*, *sse-events*,
+    *sse-drops* and *sse-reconnects* custom metrics of the test.
+
+    A stream dropped by the server is opened again after its *retry* --
+    :param retry: seconds when the server gives none -- with the
+    *Last-Event-ID* of the last event, :param max_reconnects: times at
+    most.
+
+    :param validate: a callable returning whether an event is valid -- one
+                     returning a false value or raising an exception is
+                     counted in *sse-invalid-events*, and raises
+                     :class:`InvalidEvent`.
+    """
+    def __init__(self, url, session, headers=None, validate=None,
+                 timeout=None, retry=DEFAULT_RETRY, max_reconnects=3,
+                 sleep=time.sleep):
+        self.url = url
+        self.session = session
+        self.headers = headers or {}
+        self.validate = validate
+        self.timeout = timeout
+        self.retry = retry
+        self.max_reconnects = max_reconnects
+        self.sleep = sleep
+        self.last_event_id = None
+        self._response = None
+
+    def _incr(self, name, value=1):
+        test_result = self.session.test_result
+        if test_result is not None:
+            test_result.incr_counter(self.session.test,
+                                     self.session.loads_status, name,
+                                     value=value)
+
+    def _add_trend(self, name, value):
+        if self.session.test_result is not None:
+            self.session.test_result.add_trend(name, value)
+
+    def _open(self):
+        headers = dict(self.headers)
+        headers['Accept'] = 'text/event-stream'
+        headers['Cache-Control'] = 'no-cache'
+        if self.last_event_id is not None:
+            headers['Last-Event-ID'] = self.last_event_id
+        res = self.session.get(self.url, headers=headers, stream=True,
+                               timeout=self.timeout)
+        res.raise_for_status()
+        self._response = res
+        self._incr('sse-streams')
+        return res
+
+    def _check(self, event):
+        if self.validate is None:
+            return
+        try:
+            valid, error = self.validate(event), 'rejected'
+        except Exception, e:
+            valid, error = False, str(e)
+        if not valid:
+            self._incr('sse-invalid-events')
+            raise InvalidEvent('Invalid event %r: %s' % (event, error))
+
+    def events(self, count=None, duration=None):
+        """Returns the events of the stream -- :param count: events, or the
+        ones of :param duration: seconds, at most."""
+        deadline = duration is not None and time.time() + duration or None
+        received = reconnects = 0
+        retry = [self.retry]
+        while True:
+            last, first = time.time(), True
+            res = self._open()
+            # the chunks of a chunked response come as they are received
+            chunk_size = 1
+            if getattr(res.raw, 'chunked', False):
+                chunk_size = None
+            try:
+                lines = iter_lines(res.iter_content(chunk_size=chunk_size))
+                for event in iter_events(lines, on_retry=retry.append):
+                    now = time.time()
+                    self._add_trend(first and 'sse-first-event' or
+                                    'sse-event-interval', now - last)
+                    last, first = now, False
+                    self._incr('sse-events')
+                    if event.id is not None:
+                        self.last_event_id = event.id
+                    self._check(event)
+                    yield event
+                    received += 1
+                    if ((count is not None and received >= count) or
+                            (deadline is not None and now >= deadline)):
+                        return
+            except IOError:
+                # the connection errors of a dropped stream
+                pass
+            finally:
+                self.close()
+
+            self._incr('sse-drops')
+            if reconnects >= self.max_reconnects:
+                raise IOError('The stream %s dropped %d times' %
+                              (self.url, reconnects + 1))
+            reconnects += 1
+            self._incr('sse-reconnects')
+            self.sleep(retry[-1])
+
+    def close(self):
+        if self._response is not None:
+            self._response.close()
+            self._response = None
@@ -0,0 +1,172 @@
+from __future__ import absolute_import
+
+import socket
+import struct
+import time
+
+from loads.dualstack import create_connection, get_family
+from loads.network import RETRANSMISSION_DELAY
+
+
+FRAMINGS = ('raw', 'length', 'delimiter')
+_LENGTH_FORMATS = {1: '!B', 2: '!H', 4: '!I', 8: '!Q'}
+
+
+class TCPClient(object):
+    """A TCP client.
+
+    The messages are framed with :param framing:, which can be:
+
+    - *raw*: no framing. A message is whatever is received by a single read
+      of at most :param buffer_size: bytes.
+    - *length*: every message is prefixed by its length, as a big-endian
+      unsigned integer of :param length_size: bytes.
+    - *delimiter*: every message ends with :param delimiter:.
+
+    The connection time, the round-trip times and the bytes received are
+    reported to the test result like for the web sockets.
+
+    With :param network:, the messages sent get its latency, the bytes
+    sent and received its bandwidth, and the lost messages are delayed by
+    a retransmission, counted in the *tcp-retransmissions* custom metric
+    of the test -- see :mod:`loads.network`.
+
+    The connection binds the (host, port) of :param source_address: when
+    it is set, and connects to the addresses of :param ip_family: -- see
+    :mod:`loads.dualstack`. Once connected, *family* is the family of the
+    connection. The socket gets the :param ip_options: -- see
+    :mod:`loads.qos` -- and the (level, option, value) of
+    :param socket_options: -- see :mod:`loads.sockopts`.
+    """
+    def __init__(self, host, port, test_result=None, test_case=None,
+                 framing='raw', delimiter='\n', length_size=4, timeout=None,
+                 buffer_size=8192, network=None, source_address=None,
+                 ip_family=None, ip_options=None, socket_options=None):
+        if framing not in FRAMINGS:
+            raise ValueError('Unknown framing %r' % framing)
+        if framing == 'length' and length_size not in _LENGTH_FORMATS:
+            raise ValueError('The length prefix is 1, 2, 4 or 8 bytes')
+
+        self.host = host
+        self.port = port
+        self._test_result = test_result
+        self.test_case = test_case
+        self.framing = framing
+        self.delimiter = delimiter
+        self.length_size = length_size
+        self.timeout = timeout
+        self.buffer_size = buffer_size
+        self.network = network
+        self.source_address = source_address
+        self.ip_family = ip_family
+        self.ip_options = ip_options
+        self.socket_options = socket_options
+        self.family = None
+        self._socket = None
+        self._buffer = ''
+
+    @property
+    def connected(self):
+        return self._socket is not None
+
+    def connect(self):
+        start = time.time()
+        if (self.ip_family is None and self.ip_options is None and
+                self.socket_options is None):
+            self._socket = socket.create_connection(
+                (self.host, self.port), self.timeout, self.source_address)
+        else:
+            self._socket = create_connection(
+                (self.host, self.port), self.ip_family, self.timeout,
+                self.source_address, self.socket_options,
+                ip_options=self.ip_options)
+        self.family = get_family(self._socket)
+        if self._test_result is not None:
+            self._test_result.socket_open(time.time() - start)
+
+    def close(self):
+        if self._socket is None:
+            return
+        self._socket.close()
+        self._socket = None
+        self._buffer = ''
+        if self._test_result is not None:
+            self._test_result.socket_close()
+
+    def _frame(self, payload):
+        if self.framing == 'length':
+            fmt = _LENGTH_FORMATS[self.length_size]
+            return struct.pack(fmt, len(payload)) + payload
+        elif self.framing == 'delimiter':
+            return payload + self.delimiter
+        return payload
+
+    def send(self, payload):
+        """Sends a message, connecting first if needed."""
+        if self._socket is None:
+            self.connect()
+        data = self._frame(payload)
+        if self.network is not None:
+            self.network.wait(len(data))
+            if self.network.lost():
+                self.network.sleep(RETRANSMISSION_DELAY)
+                self._incr_counter('tcp-retransmissions')
+        self._socket.sendall(data)
+
+    def _fill(self):
+        data = self._socket.recv(self.buffer_size)
+        if not data:
+            self._socket.close()
+            self._socket = None
+            if self._test_result is not None:
+                self._test_result.socket_disconnect()
+            raise socket.error('Connection closed by %s:%s' % (self.host,
+                                                                self.port))
+        if self.network is not None:
+            self.network.wait(len(data), latency=False)
+        self._buffer += data
+
+    def _read(self, size):
+        while len(self._buffer) < size:
+            self._fill()
+        data, self._buffer = self._buffer[:size], self._buffer[size:]
+        return data
+
+    def receive(self):
+        """Returns the next message received."""
+        if self.framing == 'length':
+            fmt = _LENGTH_FORMATS[self.length_size]
+            size, = struct.unpack(fmt, self._read(self.length_size))
+            message = self._read(size)
+        elif self.framing == 'delimiter':
+            while self.delimiter not in self._buffer:
+                self._fill()
+            message, self._buffer = self._buffer.split(self.delimiter, 1)
+        else:
+            if not self._buffer:
+                self._fill()
+            message, self._buffer = self._buffer, ''
+
+        if self._test_result is not None:
+            self._test_result.socket_message(len(message))
+        return message
+
+    def send_receive(self, payload):
+        """Sends a message and returns the next message received.
+
+        The round-trip time doesn't include the connection time.
+        """
+        if self._socket is None:
+            self.connect()
+
+        start = time.time()
+        self.send(payload)
+        message = self.receive()
+        if self._test_result is not None:
+            self._test_result.socket_rtt(time.time() - start)
+        return message
+
+    def _incr_counter(self, name, value=1):
+        if self.test_case is None or self._test_result is None:
+            return
+        self.test_case.incr_counter(name, value)
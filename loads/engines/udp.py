@@ -0,0 +1,174 @@
+from __future__ import absolute_import
+
+import socket
+import struct
+import time
+from datetime import datetime
+
+import gevent
+
+from loads.dualstack import get_addresses, get_family
+from loads.sockopts import get_datagram_options
+
+
+_HEADER = struct.Struct('!Q')
+
+
+class UDPClient(object):
+    """A UDP client.
+
+    Every datagram starts with a 8 bytes sequence number, that the server
+    is expected to send back, so the responses can be matched with the
+    datagrams sent.
+
+    Every response is reported to the test result as a hit with a
+    *udp://host:port* url, so the latency percentiles are computed like
+    for HTTP. The datagrams lost and received out of order are counted in
+    the *udp-lost* and *udp-out-of-order* custom metrics of the test.
+
+    With :param network:, the datagrams sent get its latency and bandwidth,
+    and the datagrams dropped by its packet loss -- either way -- are
+    counted in *udp-dropped*, as well as in *udp-lost*. See
+    :mod:`loads.network`.
+
+    The datagrams go to the first address of :param ip_family: -- the
+    first IPv6 one with *happy-eyeballs*, see :mod:`loads.dualstack` --
+    with the :param ip_options: -- see :mod:`loads.qos` -- and the socket
+    options of :param socket_options: that are not TCP options -- see
+    :mod:`loads.sockopts`.
+    """
+    def __init__(self, host, port, test_result=None, test_case=None,
+                 timeout=1., buffer_size=65535, network=None,
+                 ip_family=None, ip_options=None, socket_options=None):
+        self.host = host
+        self.port = port
+        self.url = 'udp://%s:%s' % (host, port)
+        self._test_result = test_result
+        self.test_case = test_case
+        self.timeout = timeout
+        self.buffer_size = buffer_size
+        self.network = network
+        self._sequence = 0
+
+        if ip_family is None:
+            info = socket.getaddrinfo(host, port, 0, socket.SOCK_DGRAM)[0]
+            family, self.address = info[0], info[4]
+        else:
+            family, self.address = get_addresses(host, port, ip_family)[0]
+        self._socket = socket.socket(family, socket.SOCK_DGRAM)
+        self.family = get_family(self._socket)
+        if ip_options is not None:
+            ip_options.apply(self._socket)
+        for option in get_datagram_options(socket_options):
+            self._socket.setsockopt(*option)
+        self._socket.settimeout(.1)
+
+    def close(self):
+        self._socket.close()
+
+    def _receive(self, sent, stats, done):
+        highest = -1
+        while not done:
+            try:
+                data = self._socket.recv(self.buffer_size)
+            except socket.timeout:
+                continue
+            except socket.error:
+                break
+
+            if len(data) < _HEADER.size:
+                continue
+            sequence, = _HEADER.unpack(data[:_HEADER.size])
+            if sequence not in sent:
+                # unknown, duplicated or late response
+                continue
+            if self.network is not None and self.network.lost():
+                stats['dropped'] += 1
+                continue
+
+            started, start = sent.pop(sequence)
+            elapsed = time.time() - start
+            stats['received'] += 1
+            stats['latencies'].append(elapsed)
+            if sequence < highest:
+                stats['out_of_order'] += 1
+            else:
+                highest = sequence
+            self._add_hit(started, elapsed)
+
+    def _add_hit(self, started, elapsed):
+        if self._test_result is None:
+            return
+
+        loads_status = None
+        if self.test_case is not None:
+            loads_status = self.test_case._loads_status
+
+        self._test_result.add_hit(url=self.url, method='UDP', status='OK',
+                                  started=started, elapsed=elapsed,
+                                  loads_status=loads_status,
+                                  family=self.family)
+
+    def run(self, payload='', count=1, rate=None):
+        """Sends :param count: datagrams at :param rate: datagrams per second
+        -- as fast as possible if not set.
+
+        Once everything is sent, waits up to *timeout* seconds for the
+        missing responses, then returns a dict with the number of
+        datagrams *sent*, *received*, *lost* and received *out_of_order*,
+        the ones *dropped* by the network, and the list of *latencies*.
+        """
+        stats = {'sent': 0, 'received': 0, 'lost': 0, 'out_of_order': 0,
+                 'dropped': 0, 'latencies': []}
+        sent = {}
+        done = []
+        delayed = []
+        receiver = gevent.spawn(self._receive, sent, stats, done)
+
+        try:
+            started = time.time()
+            for i in range(count):
+                if rate is not None:
+                    delay = started + i / float(rate) - time.time()
+                    if delay > 0:
+                        gevent.sleep(delay)
+
+                self._sequence += 1
+                sent[self._sequence] = datetime.utcnow(), time.time()
+                datagram = _HEADER.pack(self._sequence) + payload
+                stats['sent'] += 1
+                if self.network is None:
+                    self._socket.sendto(datagram, self.address)
+                elif self.network.lost():
+                    stats['dropped'] += 1
+                else:
+                    delay = (self.network.delay() +
+                             self.network.transfer(len(datagram)))
+                    delayed.append(gevent.spawn_later(delay, self._send,
+                                                      datagram))
+
+            deadline = time.time() + self.timeout
+            while sent and time.time() < deadline:
+                gevent.sleep(.01)
+        finally:
+            done.append(True)
+            gevent.killall(delayed)
+            receiver.join()
+
+        stats['lost'] = len(sent)
+        self._incr_counter('udp-lost', stats['lost'])
+        self._incr_counter('udp-out-of-order', stats['out_of_order'])
+        self._incr_counter('udp-dropped', stats['dropped'])
+        return stats
+
+    def _send(self, datagram):
+        try:
+            self._socket.sendto(datagram, self.address)
+        except socket.error:
+            pass
+
+    def _incr_counter(self, name, value):
+        if self.test_case is None or self._test_result is None:
+            return
+        if value > 0:
+            self.test_case.incr_counter(name, value)
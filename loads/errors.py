@@ -0,0 +1,152 @@
+"""The taxonomy of the errors: every failed request, and every error or
+failure of a test, is in one of the :data:`CATEGORIES`, counted with a few
+examples -- the messages of the exceptions, and the bodies of the failed
+responses.
+
+The exceptions are classified by their class, or by their message -- the
+errors of the HTTP connections are mostly *ConnectionError* exceptions,
+with the error of the socket in their message. The requests with a 4xx or
+a 5xx status are classified by their hit, so a test failing on a 500 has
+both a *5xx* request and an *assertion-failed* test. So are the GraphQL
+responses with errors -- see :mod:`loads.graphql`.
+"""
+import inspect
+import re
+
+
+CATEGORIES = ('dns', 'connect-timeout', 'connect-refused', 'tls', 'reset',
+              'read-timeout', '4xx', '5xx', 'graphql', 'assertion-failed',
+              'extraction-failed', 'other')
+
+# the length of the examples
+SNIPPET_SIZE = 200
+# the examples kept per category
+MAX_EXAMPLES = 3
+
+_CLASSES = (('ExtractionError', 'extraction-failed'),
+            ('GraphQLError', 'graphql'),
+            ('AssertionError', 'assertion-failed'),
+            ('gaierror', 'dns'),
+            ('NameResolutionError', 'dns'),
+            ('ConnectTimeout', 'connect-timeout'),
+            ('ConnectTimeoutError', 'connect-timeout'),
+            ('SSLError', 'tls'),
+            ('CertificateError', 'tls'),
+            ('ReadTimeout', 'read-timeout'),
+            ('ReadTimeoutError', 'read-timeout'))
+
+_MESSAGES = ((re.compile(r'Name or service not known|nodename nor servname|'
+                         r'getaddrinfo failed|name resolution|'
+                         r'Failed to resolve|No address associated', re.I),
+              'dns'),
+             (re.compile(r'connect timeout|timed out connecting', re.I),
+              'connect-timeout'),
+             (re.compile(r'SSLError|SSL routines|_ssl\.c|\[SSL|'
+                         r'certificate verify|handshake', re.I), 'tls'),
+             (re.compile(r'Connection reset|ECONNRESET|Errno 104|'
+                         r'Connection aborted|RemoteDisconnected|'
+                         r'BadStatusLine|Broken pipe', re.I), 'reset'),
+             (re.compile(r'Connection refused|ECONNREFUSED|Errno 111',
+                         re.I), 'connect-refused'),
+             (re.compile(r'Read timed out|read timeout', re.I),
+              'read-timeout'))
+
+_STATUS = re.compile(r'^([45])\d\d ')
+# the statuses of the hits failing with a successful response
+_STATUS_CATEGORIES = {'GRAPHQL_ERROR': 'graphql'}
+# the class name of the exceptions sent by the agents, like
+# "<class 'loads.extractors.ExtractionError'>"
+_CLASS_NAME = re.compile(r"([\w]+)'?>?$")
+
+
+def _get_names(exc_class):
+    if isinstance(exc_class, basestring):
+        match = _CLASS_NAME.search(exc_class.strip())
+        return match and [match.group(1)] or []
+    if inspect.isclass(exc_class):
+        return [klass.__name__ for klass in inspect.getmro(exc_class)]
+    return []
+
+
+def _get_message(exc):
+    if isinstance(exc, basestring):
+        return exc
+    try:
+        return str(exc)
+    except UnicodeError:
+        return repr(exc)
+
+
+def get_status_category(status):
+    """Returns the category of a HTTP status, or None when it is not an
+    error."""
+    if status in _STATUS_CATEGORIES:
+        return _STATUS_CATEGORIES[status]
+    if not isinstance(status, (int, long)) or status < 400:
+        return None
+    return status < 500 and '4xx' or '5xx'
+
+
+def get_category(exc_class, exc):
+    """Returns the category of an exception -- its class, or the name of its
+    class."""
+    names = _get_names(exc_class)
+    message = _get_message(exc)
+    if 'HTTPError' in names:
+        match = _STATUS.match(message)
+        if match is not None:
+            return match.group(1) + 'xx'
+    for name, category in _CLASSES:
+        if name in names:
+            return category
+    for regex, category in _MESSAGES:
+        if regex.search(message):
+            return category
+    return 'other'
+
+
+def snippet(text, size=SNIPPET_SIZE):
+    """Returns the start of a text, on one line, encoded in UTF-8."""
+    text = _get_message(text)
+    text = ' '.join(text.split())
+    if len(text) > size:
+        text = text[:size - 3] + '...'
+    if isinstance(text, unicode):
+        text = text.encode('utf-8')
+    return text
+
+
+def _get_class_name(exc_class):
+    names = _get_names(exc_class)
+    return names and names[0] or str(exc_class)
+
+
+def get_error_groups(exc_infos=(), hits=()):
+    """Returns the {category: {'count': count, 'examples': [...]}} of the
+    errors and failures of the tests, and of the failed requests."""
+    groups = {}
+
+    def _add(category, example):
+        group = groups.setdefault(category, {'count': 0, 'examples': []})
+        group['count'] += 1
+        if (example not in group['examples'] and
+                len(group['examples']) < MAX_EXAMPLES):
+            group['examples'].append(example)
+
+    for exc_info in exc_infos:
+        if not exc_info:
+            continue
+        exc_class, exc = exc_info[:2]
+        _add(get_category(exc_class, exc),
+             snippet('%s: %s' % (_get_class_name(exc_class),
+                                 _get_message(exc))))
+
+    for hit in hits:
+        category = get_status_category(hit.status)
+        if category is None:
+            continue
+        example = '%s %s: %s' % (hit.method, hit.endpoint, hit.status)
+        if getattr(hit, 'error_body', None):
+            example += ' ' + hit.error_body
+        _add(category, snippet(example))
+    return groups
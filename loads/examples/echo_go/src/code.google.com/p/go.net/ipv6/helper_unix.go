@@ -2,13 +2,14 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// +build darwin freebsd linux netbsd openbsd
+// +build darwin freebsd linux netbsd openbsd solaris
 
 package ipv6
 
 import (
 	"net"
 	"reflect"
+	"syscall"
 )
 
 func (c *genericOpt) sysfd() (int, error) {
@@ -32,6 +33,18 @@ func (c *payloadHandler) sysfd() (int, error) {
 }
 
 func sysfd(c net.Conn) (int, error) {
+	// the netFD of the Go releases with syscall.Conn has no sysfd field
+	if sc, ok := c.(syscall.Conn); ok {
+		rc, err := sc.SyscallConn()
+		if err != nil {
+			return 0, err
+		}
+		var sysfd int
+		if err := rc.Control(func(fd uintptr) { sysfd = int(fd) }); err != nil {
+			return 0, err
+		}
+		return sysfd, nil
+	}
 	cv := reflect.ValueOf(c)
 	switch ce := cv.Elem(); ce.Kind() {
 	case reflect.Struct:
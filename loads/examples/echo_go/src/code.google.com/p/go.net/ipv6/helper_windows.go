@@ -31,6 +31,18 @@ func (c *payloadHandler) sysfd() (syscall.Handle, error) {
 }
 
 func sysfd(c net.Conn) (syscall.Handle, error) {
+	// the netFD of the Go releases with syscall.Conn has no sysfd field
+	if sc, ok := c.(syscall.Conn); ok {
+		rc, err := sc.SyscallConn()
+		if err != nil {
+			return syscall.InvalidHandle, err
+		}
+		sysfd := syscall.InvalidHandle
+		if err := rc.Control(func(fd uintptr) { sysfd = syscall.Handle(fd) }); err != nil {
+			return syscall.InvalidHandle, err
+		}
+		return sysfd, nil
+	}
 	cv := reflect.ValueOf(c)
 	switch ce := cv.Elem(); ce.Kind() {
 	case reflect.Struct:
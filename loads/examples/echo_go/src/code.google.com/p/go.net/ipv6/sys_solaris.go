@@ -0,0 +1,34 @@
+// Copyright 2013 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv6
+
+// RFC 3493 options
+const (
+	// See /usr/include/netinet/in.h.
+	sysSockoptUnicastHopLimit    = 0x5
+	sysSockoptMulticastHopLimit  = 0x7
+	sysSockoptMulticastInterface = 0x6
+	sysSockoptMulticastLoopback  = 0x8
+	sysSockoptJoinGroup          = 0x9
+	sysSockoptLeaveGroup         = 0xa
+)
+
+// RFC 3542 options
+const (
+	// See /usr/include/netinet/in.h.
+	sysSockoptReceiveTrafficClass = 0x19
+	sysSockoptTrafficClass        = 0x26
+	sysSockoptReceiveHopLimit     = 0x13
+	sysSockoptHopLimit            = 0xc
+	sysSockoptReceivePacketInfo   = 0x12
+	sysSockoptPacketInfo          = 0xb
+	sysSockoptReceivePathMTU      = 0x24
+	sysSockoptPathMTU             = 0x25
+	sysSockoptNextHop             = 0xd
+	sysSockoptChecksum            = 0x18
+
+	// See /usr/include/netinet/icmp6.h.
+	sysSockoptICMPFilter = 0x1
+)
@@ -16,8 +16,7 @@ import (
 // I think because the 5-register system call interface can't handle
 // the 6-argument calls like sendto and recvfrom. Instead the
 // arguments to the underlying system call are the number below and a
-// pointer to an array of uintptr. We hide the pointer in the
-// socketcall assembly to avoid allocation on every system call.
+// pointer to an array of uintptr.
 
 const (
 	// See /usr/include/linux/net.h.
@@ -25,7 +24,13 @@ const (
 	_GETSOCKOPT = 15
 )
 
-var socketcall func(call int, a0, a1, a2, a3, a4, a5 uintptr) (int, syscall.Errno)
+// socketcall goes through syscall.Syscall: the assembly calling the
+// vDSO of the runtime doesn't link with the Go releases after 1.2.
+func socketcall(call int, a0, a1, a2, a3, a4, a5 uintptr) (int, syscall.Errno) {
+	args := [6]uintptr{a0, a1, a2, a3, a4, a5}
+	n, _, errno := syscall.Syscall(syscall.SYS_SOCKETCALL, uintptr(call), uintptr(unsafe.Pointer(&args)), 0)
+	return int(n), errno
+}
 
 func getsockopt(fd int, level int, name int, v uintptr, l *sysSockoptLen) error {
 	if _, errno := socketcall(_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(v), uintptr(unsafe.Pointer(l)), 0); errno != 0 {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// loadCertificate returns the certificate of certFile and keyFile. When
+// they are empty, or when the files don't exist, a self-signed one is
+// generated for hosts -- and written to the files, if any.
+func loadCertificate(certFile, keyFile string, hosts []string) (
+	tls.Certificate, error) {
+	if certFile != "" {
+		if _, err := os.Stat(certFile); err == nil {
+			return tls.LoadX509KeyPair(certFile, keyFile)
+		}
+	}
+	certPEM, keyPEM, err := generateCertificate(hosts, time.Now())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if certFile != "" {
+		if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// generateCertificate returns a self-signed certificate for hosts, valid
+// for a year from now, and its key -- PEM encoded.
+func generateCertificate(hosts []string, now time.Time) ([]byte, []byte,
+	error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1),
+		128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Loads echo"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage: x509.KeyUsageDigitalSignature |
+			x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		// the clients can trust the certificate as its own authority
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY",
+		Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Clients are the open connections, closed after a grace period when the
+// server is drained.
+type Clients struct {
+	mu    sync.Mutex
+	conns map[io.Closer]bool
+	// closed when the last connection is, while draining
+	empty chan struct{}
+}
+
+func NewClients() *Clients {
+	return &Clients{conns: map[io.Closer]bool{}}
+}
+
+func (c *Clients) Add(conn io.Closer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[conn] = true
+}
+
+func (c *Clients) Remove(conn io.Closer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+	if len(c.conns) == 0 && c.empty != nil {
+		close(c.empty)
+		c.empty = nil
+	}
+}
+
+// Drain waits for the connections to be closed by the clients, timeout at
+// most, then closes the other ones. It returns how many were closed.
+func (c *Clients) Drain(timeout time.Duration) int {
+	c.mu.Lock()
+	if len(c.conns) == 0 {
+		c.mu.Unlock()
+		return 0
+	}
+	empty := make(chan struct{})
+	c.empty = empty
+	c.mu.Unlock()
+
+	select {
+	case <-empty:
+		return 0
+	case <-time.After(timeout):
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	closed := 0
+	for conn := range c.conns {
+		conn.Close()
+		closed++
+	}
+	return closed
+}
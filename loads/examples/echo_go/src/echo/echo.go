@@ -1,47 +1,254 @@
-/* EchoServer
- */
+/*
+EchoServer echoes what it receives, on a web socket at /ws -- over HTTP
+and over TLS -- and on raw TCP connections, or UDP datagrams with -proto
+udp. "/" displays the max and the current number of connections.
+
+	GO111MODULE=off GOPATH=$PWD go run echo -tls-addr :9443 -echo-addr :9001
+
+With -group, the UDP echo joins an IPv6 multicast group on the port of
+-echo-addr -- on the -iface interface -- and answers the senders with a
+hop limit of -hops:
+
+	go run echo -proto udp -group ff02::114 -iface eth0 -hops 1
+
+Without -cert and -key, the TLS listener uses a self-signed certificate
+generated at startup. With them, the files are generated when they don't
+exist, so the clients can trust the certificate of the next runs.
+
+/metrics serves the connections, the bytes and the latencies of the echoes
+in the Prometheus text format. On SIGTERM, the server stops accepting new
+connections and waits -drain-timeout for the open ones to be closed by
+the clients, before closing them.
+
+The echoes can be delayed, corrupted with -corrupt, and the connections
+dropped with -drop -- see faults.go. From a -seed, the same sequence of
+echoes gets the same faults from one run to the next.
+*/
 package main
 
 import (
 	"code.google.com/p/go.net/websocket"
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-var ActiveClients int = 0
-var MaxClients int = 0
+var ActiveClients int64 = 0
+var MaxClients int64 = 0
+
+// the faults injected in the echoes -- none by default
+var faults = &Faults{}
+
+var metrics = NewMetrics()
+var clients = NewClients()
 
-func closeSocket(ws *websocket.Conn) {
-	ws.Close()
-	ActiveClients -= 1
+func openClient() {
+	active := atomic.AddInt64(&ActiveClients, 1)
+	for {
+		max := atomic.LoadInt64(&MaxClients)
+		if active <= max || atomic.CompareAndSwapInt64(&MaxClients, max,
+			active) {
+			return
+		}
+	}
 }
 
-func Echo(ws *websocket.Conn) {
-	ActiveClients += 1
+func closeClient() {
+	atomic.AddInt64(&ActiveClients, -1)
+}
 
-	if ActiveClients > MaxClients {
-		MaxClients = ActiveClients
+// serve echoes conn until it is closed.
+func serve(conn io.ReadWriteCloser, proto string) {
+	openClient()
+	defer closeClient()
+	clients.Add(conn)
+	defer clients.Remove(conn)
+	metrics.Opened(proto)
+	dropped := faults.Dropped()
+	defer metrics.Closed(proto, dropped)
+	defer conn.Close()
+	if dropped {
+		return
 	}
+	faults.Echo(conn, func(n int, latency time.Duration, corrupted bool) {
+		metrics.Echoed(proto, n, latency, corrupted)
+	})
+}
 
-	defer closeSocket(ws)
-	io.Copy(ws, ws)
+func Echo(ws *websocket.Conn) {
+	proto := "ws"
+	if ws.Request().TLS != nil {
+		proto = "wss"
+	}
+	serve(ws, proto)
 }
 
 func StatusHandler(resp http.ResponseWriter, req *http.Request) {
-	reply := fmt.Sprintf("{\"max\":%d,\"active\":%d}", MaxClients, ActiveClients)
+	reply := fmt.Sprintf("{\"max\":%d,\"active\":%d}",
+		atomic.LoadInt64(&MaxClients), atomic.LoadInt64(&ActiveClients))
 	resp.Write([]byte(reply))
 }
 
-func main() {
-	// root page, displays the max number of sockets
-	http.HandleFunc("/", StatusHandler)
+// serveTCP echoes the connections of listener until it is closed.
+func serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serve(conn, "tcp")
+	}
+}
 
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	// root page, displays the max number of sockets
+	mux.HandleFunc("/", StatusHandler)
+	mux.Handle("/metrics", metrics)
 	// web socket
-	http.Handle("/ws", websocket.Handler(Echo))
-	fmt.Println("Listening on port 9000")
-	err := http.ListenAndServe(":9000", nil)
+	mux.Handle("/ws", websocket.Handler(Echo))
+	return mux
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "the HTTP address, \"\" to "+
+		"disable it")
+	tlsAddr := flag.String("tls-addr", ":9443", "the HTTPS address, \"\" "+
+		"to disable it")
+	echoAddr := flag.String("echo-addr", ":9001", "the address of the "+
+		"raw echo, \"\" to disable it")
+	proto := flag.String("proto", "tcp", "the protocol of the raw echo: "+
+		"tcp or udp")
+	group := flag.String("group", "", "the IPv6 multicast group the UDP "+
+		"echo joins")
+	iface := flag.String("iface", "", "the interface of -group, the one "+
+		"of the system by default")
+	hops := flag.Int("hops", 0, "the hop limit of the echoes of -group, "+
+		"the one of the system by default")
+	certFile := flag.String("cert", "", "the certificate of the TLS "+
+		"listener, generated when it doesn't exist")
+	keyFile := flag.String("key", "", "the key of -cert")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "the names "+
+		"and the addresses of a generated certificate")
+	delay := flag.String("delay", "", "the delay of the echoes: 50ms, "+
+		"uniform:10ms,50ms or lognormal:50ms,0.5")
+	drop := flag.Float64("drop", 0, "the percentage of the connections "+
+		"closed before any echo")
+	corrupt := flag.Float64("corrupt", 0, "the percentage of the echoes "+
+		"with a wrong byte")
+	seed := flag.Int64("seed", 0, "the seed of the faults, the current "+
+		"time by default")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second,
+		"how long the open connections have to close on SIGTERM")
+	flag.Parse()
+
+	faultsDelay, err := ParseDelay(*delay)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	faults, err = NewFaults(faultsDelay, *drop, *corrupt, *seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	multicast, err := ParseMulticast(*group, *iface, *hops)
 	if err != nil {
-		panic("ListenAndServe: " + err.Error())
+		log.Fatal(err)
+	}
+	if *proto != "tcp" && *proto != "udp" {
+		log.Fatalf("unknown protocol %q", *proto)
+	}
+	if multicast != nil && *proto != "udp" {
+		log.Fatal("-group needs -proto udp")
+	}
+
+	// the listeners stopped when draining send their error too
+	errors := make(chan error, 3)
+	var servers []*http.Server
+	mux := newMux()
+	if *addr != "" {
+		server := &http.Server{Addr: *addr, Handler: mux}
+		servers = append(servers, server)
+		fmt.Println("Listening on", *addr)
+		go func() {
+			errors <- server.ListenAndServe()
+		}()
+	}
+	if *tlsAddr != "" {
+		cert, err := loadCertificate(*certFile, *keyFile,
+			strings.Split(*hosts, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := &http.Server{Addr: *tlsAddr, Handler: mux,
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+		servers = append(servers, server)
+		fmt.Println("Listening on", *tlsAddr, "with TLS")
+		go func() {
+			errors <- server.ListenAndServeTLS("", "")
+		}()
+	}
+	var listener io.Closer
+	if *echoAddr != "" && *proto == "tcp" {
+		tcp, err := net.Listen("tcp", *echoAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listener = tcp
+		fmt.Println("Echoing TCP on", *echoAddr)
+		go func() {
+			errors <- serveTCP(tcp)
+		}()
+	}
+	if *echoAddr != "" && *proto == "udp" {
+		udp, err := listenUDP(*echoAddr, multicast)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listener = udp
+		if multicast != nil {
+			fmt.Println("Echoing UDP on", udp.LocalAddr(), "for",
+				multicast.Group)
+		} else {
+			fmt.Println("Echoing UDP on", *echoAddr)
+		}
+		go func() {
+			errors <- serveUDP(udp)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	select {
+	case err := <-errors:
+		log.Fatal(err)
+	case <-signals:
+	}
+
+	fmt.Println("Draining the connections")
+	deadline := time.Now().Add(*drainTimeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	// the web sockets are hijacked: Shutdown doesn't wait for them
+	for _, server := range servers {
+		server.Shutdown(ctx)
+	}
+	if listener != nil {
+		listener.Close()
 	}
+	closed := clients.Drain(time.Until(deadline))
+	fmt.Printf("Drained, %d connections closed by the server\n", closed)
 }
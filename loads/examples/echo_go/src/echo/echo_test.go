@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/websocket"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func echoes(t *testing.T, conn net.Conn) {
+	if _, err := conn.Write([]byte("something")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 9)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "something" {
+		t.Fatalf("got %q", reply)
+	}
+}
+
+func TestTLSWebSocket(t *testing.T) {
+	certPEM, keyPEM, err := generateCertificate([]string{"127.0.0.1"},
+		time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(newMux())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	// the clients verify the server with the self-signed certificate
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	config, err := websocket.NewConfig("wss://"+server.Listener.Addr().
+		String()+"/ws", "https://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.TlsConfig = &tls.Config{RootCAs: roots}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	echoes(t, ws)
+}
+
+func TestTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveTCP(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echoes(t, conn)
+}
+
+func TestLoadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	first, err := loadCertificate(certFile, keyFile, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	// the next runs use the generated files
+	second, err := loadCertificate(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first.Certificate[0]) != string(second.Certificate[0]) {
+		t.Fatal("the certificate was generated again")
+	}
+}
+
+func TestParseDelay(t *testing.T) {
+	ms := time.Millisecond
+	delays := map[string]Delay{
+		"":                   {},
+		"50ms":               {"fixed", 50 * ms, 0, 0},
+		"uniform:10ms,50ms":  {"uniform", 10 * ms, 50 * ms, 0},
+		"lognormal:50ms,0.5": {"lognormal", 50 * ms, 0, .5},
+	}
+	for value, wanted := range delays {
+		delay, err := ParseDelay(value)
+		if err != nil || delay != wanted {
+			t.Errorf("%q: got %v, %v", value, delay, err)
+		}
+	}
+	for _, value := range []string{"50", "uniform:50ms,10ms", "nope:1s",
+		"lognormal:0s,1", "-1s"} {
+		if _, err := ParseDelay(value); err == nil {
+			t.Errorf("%q is valid", value)
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	delay, _ := ParseDelay("uniform:1ms,2ms")
+	pauses := func() []time.Duration {
+		faults, _ := NewFaults(delay, 0, 0, 42)
+		var pauses []time.Duration
+		for i := 0; i < 10; i++ {
+			pause := faults.Pause()
+			if pause < time.Millisecond || pause > 2*time.Millisecond {
+				t.Fatalf("got a pause of %v", pause)
+			}
+			pauses = append(pauses, pause)
+		}
+		return pauses
+	}
+	// the same seed, the same faults
+	if fmt.Sprint(pauses()) != fmt.Sprint(pauses()) {
+		t.Error("the pauses of a seed differ")
+	}
+
+	if _, err := NewFaults(Delay{}, 101, 0, 1); err == nil {
+		t.Error("101% of the connections are dropped")
+	}
+	faults, _ := NewFaults(Delay{}, 100, 100, 1)
+	if !faults.Dropped() {
+		t.Error("the connection is not dropped")
+	}
+	conn := &bufferConn{in: bytes.NewBufferString("something")}
+	if err := faults.Echo(conn, nil); err != nil {
+		t.Fatal(err)
+	}
+	if echo := conn.out.String(); echo == "something" || len(echo) != 9 {
+		t.Errorf("got %q", echo)
+	}
+}
+
+type bufferConn struct {
+	in  *bytes.Buffer
+	out bytes.Buffer
+}
+
+func (c *bufferConn) Read(p []byte) (int, error) {
+	return c.in.Read(p)
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Opened("tcp")
+	metrics.Echoed("tcp", 9, 3*time.Millisecond, true)
+	metrics.Opened("ws")
+	metrics.Closed("ws", true)
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, line := range []string{
+		`echo_connections_total{proto="tcp"} 1`,
+		`echo_connections{proto="tcp"} 1`,
+		`echo_connections{proto="ws"} 0`,
+		`echo_dropped_connections_total{proto="ws"} 1`,
+		`echo_sent_bytes_total{proto="tcp"} 9`,
+		`echo_corrupted_echoes_total{proto="tcp"} 1`,
+		`echo_latency_seconds_bucket{proto="tcp",le="0.0025"} 0`,
+		`echo_latency_seconds_bucket{proto="tcp",le="0.005"} 1`,
+		`echo_latency_seconds_bucket{proto="tcp",le="+Inf"} 1`,
+		`echo_latency_seconds_count{proto="tcp"} 1`,
+		`node_netstat_Tcp_CurrEstab 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("no %s in\n%s", line, body)
+		}
+	}
+}
+
+type closer struct {
+	closed chan bool
+}
+
+func (c *closer) Close() error {
+	c.closed <- true
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+	clients := NewClients()
+	if closed := clients.Drain(time.Hour); closed != 0 {
+		t.Fatalf("closed %d connections", closed)
+	}
+
+	// closed by the client
+	conn := &closer{make(chan bool, 1)}
+	clients.Add(conn)
+	time.AfterFunc(10*time.Millisecond, func() { clients.Remove(conn) })
+	if closed := clients.Drain(time.Hour); closed != 0 {
+		t.Fatalf("closed %d connections", closed)
+	}
+
+	// closed by the server after the timeout
+	clients.Add(conn)
+	if closed := clients.Drain(10 * time.Millisecond); closed != 1 {
+		t.Fatalf("closed %d connections", closed)
+	}
+	<-conn.closed
+}
+
+func udpEchoes(t *testing.T, server net.PacketConn, to *net.UDPAddr) {
+	go serveUDP(server)
+	conn, err := net.ListenUDP(to.Network(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP([]byte("something"), to); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 100)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply[:n]) != "something" {
+		t.Fatalf("got %q", reply[:n])
+	}
+}
+
+func TestUDP(t *testing.T) {
+	server, err := listenUDP("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	udpEchoes(t, server, server.LocalAddr().(*net.UDPAddr))
+}
+
+func TestMulticast(t *testing.T) {
+	if multicast, err := ParseMulticast("", "", 0); multicast != nil ||
+		err != nil {
+		t.Errorf("got %v, %v without a group", multicast, err)
+	}
+	for _, group := range []string{"10.0.0.1", "fd00::1", "nope"} {
+		if _, err := ParseMulticast(group, "", 1); err == nil {
+			t.Errorf("%q is a valid group", group)
+		}
+	}
+	if _, err := ParseMulticast("ff02::114", "", 256); err == nil {
+		t.Error("256 is a valid hop limit")
+	}
+	interfaces, _ := net.Interfaces()
+	var ifi *net.Interface
+	for i := range interfaces {
+		flags := interfaces[i].Flags
+		if flags&net.FlagUp != 0 && flags&net.FlagMulticast != 0 {
+			ifi = &interfaces[i]
+			break
+		}
+	}
+	if ifi == nil {
+		t.Skip("no multicast interface")
+	}
+	multicast, err := ParseMulticast("ff02::114", ifi.Name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listenUDP(":0", multicast)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer server.Close()
+	port := server.LocalAddr().(*net.UDPAddr).Port
+	udpEchoes(t, server, &net.UDPAddr{IP: multicast.Group, Port: port,
+		Zone: ifi.Name})
+}
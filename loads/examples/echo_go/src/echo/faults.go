@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delay is the pause before every echo: a fixed one like "50ms", one
+// between two durations like "uniform:10ms,50ms", or the long tail of
+// "lognormal:50ms,0.5" -- a median of 50ms, the logarithms of the pauses
+// having a standard deviation of 0.5.
+type Delay struct {
+	Distribution string
+	First        time.Duration
+	// the longest uniform pause
+	Second time.Duration
+	// the sigma of the log-normal pauses
+	Sigma float64
+}
+
+// ParseDelay returns the Delay of value, "" being no delay.
+func ParseDelay(value string) (Delay, error) {
+	var delay Delay
+	if value == "" {
+		return delay, nil
+	}
+	delay.Distribution = "fixed"
+	if i := strings.Index(value, ":"); i >= 0 {
+		delay.Distribution, value = value[:i], value[i+1:]
+	}
+	values := strings.Split(value, ",")
+	first, err := time.ParseDuration(values[0])
+	if err != nil {
+		return delay, err
+	}
+	delay.First = first
+	switch {
+	case delay.Distribution == "fixed" && len(values) == 1:
+	case delay.Distribution == "uniform" && len(values) == 2:
+		delay.Second, err = time.ParseDuration(values[1])
+		if err == nil && delay.Second < delay.First {
+			err = fmt.Errorf("the uniform delay needs MIN,MAX")
+		}
+	case delay.Distribution == "lognormal" && len(values) == 2:
+		delay.Sigma, err = strconv.ParseFloat(values[1], 64)
+		if err == nil && delay.First <= 0 {
+			err = fmt.Errorf("the log-normal delay needs MEDIAN,SIGMA")
+		}
+	default:
+		err = fmt.Errorf("invalid delay %q", value)
+	}
+	if err == nil && delay.First < 0 {
+		err = fmt.Errorf("the delays are not negative")
+	}
+	return delay, err
+}
+
+// Faults are injected in the echoes, so the clients see the errors and
+// the latencies of a broken server -- the same ones from the same seed.
+type Faults struct {
+	Delay Delay
+	// the percentages of the connections closed before any echo, and of
+	// the echoes with a wrong byte
+	Drop    float64
+	Corrupt float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewFaults(delay Delay, drop, corrupt float64, seed int64) (*Faults,
+	error) {
+	if drop < 0 || drop > 100 || corrupt < 0 || corrupt > 100 {
+		return nil, fmt.Errorf("the percentages are between 0 and 100")
+	}
+	return &Faults{Delay: delay, Drop: drop, Corrupt: corrupt,
+		rand: rand.New(rand.NewSource(seed))}, nil
+}
+
+// roll returns true percent times out of 100.
+func (f *Faults) roll(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64()*100 < percent
+}
+
+// Dropped returns whether a new connection is closed right away.
+func (f *Faults) Dropped() bool {
+	return f.roll(f.Drop)
+}
+
+// Pause returns the delay of the next echo.
+func (f *Faults) Pause() time.Duration {
+	delay := f.Delay
+	switch delay.Distribution {
+	case "fixed":
+		return delay.First
+	case "uniform":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return delay.First + time.Duration(f.rand.Int63n(
+			int64(delay.Second-delay.First)+1))
+	case "lognormal":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return time.Duration(float64(delay.First) *
+			math.Exp(delay.Sigma*f.rand.NormFloat64()))
+	}
+	return 0
+}
+
+// corrupt flips a byte of data, Corrupt percent of the times, and
+// returns whether it did.
+func (f *Faults) corrupt(data []byte) bool {
+	if len(data) == 0 || !f.roll(f.Corrupt) {
+		return false
+	}
+	f.mu.Lock()
+	i := f.rand.Intn(len(data))
+	f.mu.Unlock()
+	data[i] ^= 0xff
+	return true
+}
+
+// apply pauses before the echo of data and corrupts it, returning whether
+// it did.
+func (f *Faults) apply(data []byte) bool {
+	if pause := f.Pause(); pause > 0 {
+		time.Sleep(pause)
+	}
+	return f.corrupt(data)
+}
+
+// Echo writes back what conn reads, a read at a time, with the faults.
+// echoed is called after every echo, if not nil.
+func (f *Faults) Echo(conn io.ReadWriter, echoed func(n int,
+	latency time.Duration, corrupted bool)) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			start := time.Now()
+			corrupted := f.apply(buf[:n])
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return err
+			}
+			if echoed != nil {
+				echoed(n, time.Since(start), corrupted)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the upper bounds of the buckets of the latencies, in seconds
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05,
+	.1, .25, .5, 1, 2.5, 5}
+
+// protoMetrics are the metrics of the connections of a protocol.
+type protoMetrics struct {
+	connections int64
+	active      int64
+	dropped     int64
+	received    int64
+	sent        int64
+	corrupted   int64
+	// the echoes by latency bucket, the last one being +Inf
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+// Metrics are the metrics of the server by protocol -- "ws", "wss",
+// "tcp" and "udp" --, served in the Prometheus text format on /metrics.
+type Metrics struct {
+	mu     sync.Mutex
+	protos map[string]*protoMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{protos: map[string]*protoMetrics{}}
+}
+
+func (m *Metrics) get(proto string) *protoMetrics {
+	metrics, ok := m.protos[proto]
+	if !ok {
+		metrics = &protoMetrics{
+			buckets: make([]int64, len(latencyBuckets)+1)}
+		m.protos[proto] = metrics
+	}
+	return metrics
+}
+
+func (m *Metrics) Opened(proto string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics := m.get(proto)
+	metrics.connections++
+	metrics.active++
+}
+
+func (m *Metrics) Closed(proto string, dropped bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics := m.get(proto)
+	metrics.active--
+	if dropped {
+		metrics.dropped++
+	}
+}
+
+// Dropped counts a datagram dropped by -drop.
+func (m *Metrics) Dropped(proto string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(proto).dropped++
+}
+
+// Echoed counts an echo of n bytes, from the read to the end of the
+// write.
+func (m *Metrics) Echoed(proto string, n int, latency time.Duration,
+	corrupted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics := m.get(proto)
+	metrics.received += int64(n)
+	metrics.sent += int64(n)
+	if corrupted {
+		metrics.corrupted++
+	}
+	seconds := latency.Seconds()
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+	metrics.buckets[bucket]++
+	metrics.count++
+	metrics.sum += seconds
+}
+
+func (m *Metrics) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var protos []string
+	var established int64
+	for proto, metrics := range m.protos {
+		protos = append(protos, proto)
+		established += metrics.active
+	}
+	sort.Strings(protos)
+
+	var out bytes.Buffer
+	counter := func(name, help string, value func(*protoMetrics) int64) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s counter\n", name,
+			help, name)
+		for _, proto := range protos {
+			fmt.Fprintf(&out, "%s{proto=%q} %d\n", name, proto,
+				value(m.protos[proto]))
+		}
+	}
+	counter("echo_connections_total", "The connections accepted.",
+		func(p *protoMetrics) int64 { return p.connections })
+	counter("echo_dropped_connections_total", "The connections -- the "+
+		"datagrams of udp -- dropped by -drop.",
+		func(p *protoMetrics) int64 { return p.dropped })
+	counter("echo_received_bytes_total", "The bytes received.",
+		func(p *protoMetrics) int64 { return p.received })
+	counter("echo_sent_bytes_total", "The bytes sent.",
+		func(p *protoMetrics) int64 { return p.sent })
+	counter("echo_corrupted_echoes_total", "The echoes corrupted by "+
+		"-corrupt.", func(p *protoMetrics) int64 { return p.corrupted })
+
+	fmt.Fprintf(&out, "# HELP echo_connections The open connections.\n"+
+		"# TYPE echo_connections gauge\n")
+	for _, proto := range protos {
+		fmt.Fprintf(&out, "echo_connections{proto=%q} %d\n", proto,
+			m.protos[proto].active)
+	}
+
+	fmt.Fprintf(&out, "# HELP echo_latency_seconds The time from the "+
+		"read to the write of the echoes.\n"+
+		"# TYPE echo_latency_seconds histogram\n")
+	for _, proto := range protos {
+		metrics := m.protos[proto]
+		var cumulated int64
+		for i, count := range metrics.buckets {
+			cumulated += count
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = fmt.Sprint(latencyBuckets[i])
+			}
+			fmt.Fprintf(&out, "echo_latency_seconds_bucket{proto=%q,"+
+				"le=%q} %d\n", proto, le, cumulated)
+		}
+		fmt.Fprintf(&out, "echo_latency_seconds_sum{proto=%q} %g\n"+
+			"echo_latency_seconds_count{proto=%q} %d\n", proto,
+			metrics.sum, proto, metrics.count)
+	}
+
+	// the name loads' --monitor reads the connections of a host from
+	fmt.Fprintf(&out, "# HELP node_netstat_Tcp_CurrEstab The open "+
+		"connections of the echo server.\n"+
+		"# TYPE node_netstat_Tcp_CurrEstab gauge\n"+
+		"node_netstat_Tcp_CurrEstab %d\n", established)
+
+	resp.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	resp.Write(out.Bytes())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"code.google.com/p/go.net/ipv6"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Multicast is the IPv6 multicast group a UDP echo joins, on an
+// interface -- the one of the system when nil. The echoes are sent back to
+// the senders with a hop limit of Hops, when not 0.
+type Multicast struct {
+	Group     net.IP
+	Interface *net.Interface
+	Hops      int
+}
+
+// ParseMulticast returns the Multicast of a group, an interface name and
+// a hop limit -- nil without a group.
+func ParseMulticast(group, iface string, hops int) (*Multicast, error) {
+	if group == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(group)
+	if ip == nil || ip.To4() != nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%q is not an IPv6 multicast group", group)
+	}
+	if hops < 0 || hops > 255 {
+		return nil, fmt.Errorf("the hop limit is between 0 and 255")
+	}
+	multicast := &Multicast{Group: ip, Hops: hops}
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		multicast.Interface = ifi
+	}
+	return multicast, nil
+}
+
+// Join joins the group on conn and sets the hop limit of its echoes, with
+// the socket options of the ipv6 package.
+func (m *Multicast) Join(conn net.PacketConn) error {
+	p := ipv6.NewPacketConn(conn)
+	if m.Hops != 0 {
+		if err := p.SetHopLimit(m.Hops); err != nil {
+			return err
+		}
+	}
+	return p.JoinGroup(m.Interface, &net.UDPAddr{IP: m.Group})
+}
+
+// listenUDP returns the UDP socket of addr, joined to multicast if not
+// nil -- on the port of addr.
+func listenUDP(addr string, multicast *Multicast) (net.PacketConn, error) {
+	if multicast == nil {
+		return net.ListenPacket("udp", addr)
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp6", net.JoinHostPort("::", port))
+	if err != nil {
+		return nil, err
+	}
+	if err := multicast.Join(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// serveUDP echoes the datagrams of conn until it is closed, with the
+// faults -- the dropped "connections" being datagrams.
+func serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if faults.Dropped() {
+			metrics.Dropped("udp")
+			continue
+		}
+		data := append([]byte(nil), buf[:n]...)
+		// the delays don't hold the next datagrams back
+		go func() {
+			start := time.Now()
+			corrupted := faults.apply(data)
+			if _, err := conn.WriteTo(data, addr); err == nil {
+				metrics.Echoed("udp", len(data), time.Since(start),
+					corrupted)
+			}
+		}()
+	}
+}
@@ -0,0 +1,94 @@
+"""Extractors capture values from the responses, to use them in the next
+requests.
+
+They accept the responses of Requests and of WebTest, and raise
+:class:`ExtractionError` when the value is not found.
+"""
+import json
+import re
+
+
+class ExtractionError(Exception):
+    pass
+
+
+_PATH_PART = re.compile(r"\.([^.\[\]]+)|\[(\d+)\]|\['([^']*)'\]|"
+                        r'\["([^"]*)"\]')
+
+
+def _get_body(response):
+    text = getattr(response, 'text', None)
+    if text is None:
+        text = response.body
+    return text
+
+
+def _get_json(response):
+    data = getattr(response, 'json', None)
+    if callable(data):
+        return data()
+    if data is None:
+        return json.loads(_get_body(response))
+    return data
+
+
+def parse_json_path(path):
+    """Splits a JSON path like ``$.items[0].id`` into its keys and indexes.
+
+    Only the children (``.key``, ``['key']``) and the indexes (``[0]``) are
+    supported.
+    """
+    if not path.startswith('$'):
+        raise ValueError('A JSON path starts with $: %r' % path)
+
+    parts = []
+    position = 1
+    while position < len(path):
+        match = _PATH_PART.match(path, position)
+        if match is None:
+            raise ValueError('Invalid JSON path %r' % path)
+        key, index, quoted, dquoted = match.groups()
+        if index is not None:
+            parts.append(int(index))
+        elif key is not None:
+            parts.append(key)
+        elif quoted is not None:
+            parts.append(quoted)
+        else:
+            parts.append(dquoted)
+        position = match.end()
+    return parts
+
+
+def json_path(response, path):
+    """Returns the value at the given JSON path in the body."""
+    try:
+        value = _get_json(response)
+    except ValueError:
+        raise ExtractionError('The body is not JSON')
+
+    for part in parse_json_path(path):
+        try:
+            value = value[part]
+        except (KeyError, IndexError, TypeError):
+            raise ExtractionError('%r not found' % path)
+    return value
+
+
+def regex(response, pattern, group=1):
+    """Returns the given group of the first match of the pattern in the
+    body -- or the whole match if the pattern has no groups."""
+    match = re.search(pattern, _get_body(response))
+    if match is None:
+        raise ExtractionError('%r not found' % pattern)
+    if match.re.groups == 0:
+        return match.group(0)
+    return match.group(group)
+
+
+def header(response, name):
+    """Returns the value of the given header."""
+    value = response.headers.get(name)
+    if value is None:
+        raise ExtractionError('No %r header' % name)
+    return value
@@ -0,0 +1,179 @@
+"""Fake data for the payloads -- signups, checkouts... -- generated fast
+enough for every request, and unique where it matters: the user names and
+the emails of the users never repeat, even across the agents.
+
+The generators are given by name, the CamelCase way -- *Name*, *Email*,
+*IPv6*, *CreditCard*. When `Faker <http://pypi.python.org/pypi/Faker>`_ is
+installed, the names loads does not know are looked for in its providers:
+*Iban* is its *iban*, *LicensePlate* its *license_plate*.
+"""
+import itertools
+import random
+import re
+import string
+import uuid
+
+
+_FIRST_NAMES = ('James', 'Mary', 'Robert', 'Patricia', 'John', 'Jennifer',
+                'Michael', 'Linda', 'David', 'Elizabeth', 'Ada', 'Grace',
+                'Alan', 'Margaret', 'Dennis', 'Barbara', 'Ken', 'Frances',
+                'Edsger', 'Radia')
+_LAST_NAMES = ('Smith', 'Johnson', 'Williams', 'Brown', 'Jones', 'Garcia',
+               'Miller', 'Davis', 'Lovelace', 'Hopper', 'Turing', 'Hamilton',
+               'Ritchie', 'Liskov', 'Thompson', 'Allen', 'Dijkstra',
+               'Perlman', 'Knuth', 'Lamport')
+_DOMAINS = ('example.com', 'example.org', 'example.net')
+_CITIES = ('Springfield', 'Riverside', 'Fairview', 'Madison', 'Georgetown',
+           'Salem', 'Clinton', 'Franklin', 'Greenville', 'Bristol')
+_COUNTRIES = ('France', 'Germany', 'Spain', 'Italy', 'United States',
+              'Canada', 'Japan', 'Brazil', 'India', 'Australia')
+_STREETS = ('Main', 'Oak', 'Pine', 'Maple', 'Cedar', 'Elm', 'Lake', 'Hill',
+            'Park', 'Church')
+_STREET_TYPES = ('Street', 'Avenue', 'Road', 'Boulevard', 'Lane')
+_COMPANY_SUFFIXES = ('Inc', 'LLC', 'Ltd', 'Group', 'Systems')
+_WORDS = ('lorem', 'ipsum', 'dolor', 'sit', 'amet', 'consectetur',
+          'adipiscing', 'elit', 'sed', 'do', 'eiusmod', 'tempor')
+_CAMEL = re.compile(r'(?<=[a-z0-9])(?=[A-Z])')
+
+# the unique values of this process start with it, so they don't collide
+# with the ones of the other agents
+_PROCESS = uuid.uuid4().hex[:6]
+_COUNTER = itertools.count(1)
+
+
+def _normalize(name):
+    return name.replace('_', '').lower()
+
+
+def luhn_digit(digits):
+    """Returns the check digit to append to a number, with the Luhn
+    algorithm."""
+    total = 0
+    for index, digit in enumerate(reversed(digits)):
+        digit = int(digit)
+        if index % 2 == 0:
+            digit *= 2
+            if digit > 9:
+                digit -= 9
+        total += digit
+    return str((10 - total % 10) % 10)
+
+
+class Faker(object):
+    """Generates fake data.
+
+    :param seed: the seed of the random generator, to get the same data
+                 every run.
+    """
+    def __init__(self, seed=None):
+        self.random = random.Random(seed)
+        self._external = None
+        self._generators = dict([(_normalize(name), getattr(self, name))
+                                 for name in dir(self)
+                                 if not name.startswith('_') and
+                                 name not in ('get', 'random')])
+
+    def _unique(self):
+        return '%s%d' % (_PROCESS, _COUNTER.next())
+
+    def first_name(self):
+        return self.random.choice(_FIRST_NAMES)
+
+    def last_name(self):
+        return self.random.choice(_LAST_NAMES)
+
+    def name(self):
+        return '%s %s' % (self.first_name(), self.last_name())
+
+    def user_name(self):
+        return '%s.%s.%s' % (self.first_name().lower(),
+                             self.last_name().lower(), self._unique())
+
+    def email(self):
+        return '%s@%s' % (self.user_name(), self.random.choice(_DOMAINS))
+
+    def password(self, length=12):
+        characters = string.ascii_letters + string.digits + '!@#$%^&*'
+        return ''.join([self.random.choice(characters)
+                        for index in range(length)])
+
+    def phone(self):
+        return '+1-%03d-%03d-%04d' % (self.random.randint(200, 999),
+                                      self.random.randint(200, 999),
+                                      self.random.randint(0, 9999))
+
+    def ipv4(self):
+        return '.'.join([str(self.random.randint(1, 254))
+                         for index in range(4)])
+
+    def ipv6(self):
+        return ':'.join(['%x' % self.random.randint(0, 0xffff)
+                         for index in range(8)])
+
+    def mac_address(self):
+        return ':'.join(['%02x' % self.random.randint(0, 255)
+                         for index in range(6)])
+
+    def credit_card(self):
+        """A 16 digits Visa number, valid for the Luhn algorithm."""
+        digits = '4' + ''.join([str(self.random.randint(0, 9))
+                                for index in range(14)])
+        return digits + luhn_digit(digits)
+
+    def uuid(self):
+        return str(uuid.UUID(int=self.random.getrandbits(128), version=4))
+
+    def company(self):
+        return '%s %s' % (self.last_name(),
+                          self.random.choice(_COMPANY_SUFFIXES))
+
+    def street_address(self):
+        return '%d %s %s' % (self.random.randint(1, 9999),
+                             self.random.choice(_STREETS),
+                             self.random.choice(_STREET_TYPES))
+
+    def city(self):
+        return self.random.choice(_CITIES)
+
+    def country(self):
+        return self.random.choice(_COUNTRIES)
+
+    def zip_code(self):
+        return '%05d' % self.random.randint(501, 99950)
+
+    def word(self):
+        return self.random.choice(_WORDS)
+
+    def sentence(self, words=8):
+        sentence = ' '.join([self.word() for index in range(words)])
+        return sentence.capitalize() + '.'
+
+    def get(self, name):
+        """Returns a value of the generator called *name* -- like *Email*
+        or *CreditCard*."""
+        generator = self._generators.get(_normalize(name))
+        if generator is not None:
+            return generator()
+
+        if self._external is None:
+            try:
+                import faker
+            except ImportError:
+                raise ValueError('Unknown fake data %r -- install Faker '
+                                 'for more' % name)
+            self._external = faker.Faker()
+        attribute = _CAMEL.sub('_', name).lower()
+        if not hasattr(self._external, attribute):
+            raise ValueError('Unknown fake data %r' % name)
+        return getattr(self._external, attribute)()
+
+
+_FAKER = None
+
+
+def get_faker():
+    """Returns the faker shared by the tests of the process."""
+    global _FAKER
+    if _FAKER is None:
+        _FAKER = Faker()
+    return _FAKER
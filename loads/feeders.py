@@ -0,0 +1,179 @@
+"""Feeders provide rows of test data -- e.g. credentials -- to the tests.
+
+The rows are read from CSV files, with a header line giving the names of the
+columns, or from JSON Lines files, with one JSON object per line.
+
+In distributed mode, *--feeder-partition* sends the *--feeder* file to the
+broker once, and the broker ships every agent its own partition -- the
+rows of the *unique* strategy an agent would have picked -- so the agents
+don't load the whole file::
+
+    $ loads-runner example.TestSignup.test_signup --agents 20 \\
+        --feeder users.csv --feeder-strategy unique --feeder-partition
+"""
+import csv
+import json
+import os
+import random
+import zlib
+from StringIO import StringIO
+
+
+STRATEGIES = ('round-robin', 'random', 'unique')
+_FORMATS = {'.csv': 'csv', '.json': 'jsonl', '.jsonl': 'jsonl',
+            '.ndjson': 'jsonl'}
+_FEEDERS = {}
+
+
+class FeederExhausted(Exception):
+    pass
+
+
+def read_rows(filename, format=None):
+    """Yields the rows of a CSV or JSON Lines file, as dicts.
+
+    The format is guessed from the extension of the file, if not given.
+    """
+    if format is None:
+        format = _get_format(filename)
+
+    with open(filename) as f:
+        if format == 'csv':
+            for row in csv.DictReader(f):
+                yield row
+        elif format == 'jsonl':
+            for line in f:
+                line = line.strip()
+                if line:
+                    yield json.loads(line)
+        else:
+            raise ValueError('Unknown format %r' % format)
+
+
+class Feeder(object):
+    """Gives the rows of a file following a strategy:
+
+    - *round-robin*: the rows are given in order, starting over at the end.
+    - *random*: a random row is given every time.
+    - *unique*: every user gets its own row, and keeps it. A row is never
+      given twice, and :class:`FeederExhausted` is raised once they are all
+      taken. In distributed mode, every agent only uses its share of the
+      rows, so no two agents use the same row.
+    """
+    def __init__(self, filename, strategy='round-robin', format=None,
+                 agent_index=0, agents=1):
+        if strategy not in STRATEGIES:
+            raise ValueError('Unknown strategy %r' % strategy)
+
+        self.filename = filename
+        self.strategy = strategy
+        self.rows = list(read_rows(filename, format))
+        if strategy == 'unique' and agents > 1:
+            self.rows = self.rows[agent_index::agents]
+
+        self._position = 0
+        self._users = {}
+
+    def next(self, user=None, rand=None):
+        """Returns the next row for :param user:, drawn with its generator
+        :param rand: by the *random* strategy."""
+        if len(self.rows) == 0:
+            raise FeederExhausted('%r has no rows' % self.filename)
+
+        if self.strategy == 'random':
+            return (rand or random).choice(self.rows)
+
+        if self.strategy == 'unique':
+            if user is not None and user in self._users:
+                return self._users[user]
+            if self._position >= len(self.rows):
+                raise FeederExhausted('All the rows of %r are taken' %
+                                      self.filename)
+
+        row = self.rows[self._position % len(self.rows)]
+        self._position += 1
+
+        if self.strategy == 'unique' and user is not None:
+            self._users[user] = row
+        return row
+
+
+def _get_format(filename):
+    extension = os.path.splitext(filename)[-1].lower()
+    if extension not in _FORMATS:
+        raise ValueError('Unknown format for %r' % filename)
+    return _FORMATS[extension]
+
+
+def pack_feeder(filename):
+    """Returns the content of a feeder file, compressed, for the broker to
+    split it -- see :func:`split_feeder`."""
+    _get_format(filename)
+    with open(filename) as f:
+        return zlib.compress(f.read()).encode('base64')
+
+
+def unpack_feeder(data, filename):
+    """Writes the content of :func:`pack_feeder` in a file."""
+    with open(filename, 'w') as f:
+        f.write(zlib.decompress(str(data).decode('base64')))
+
+
+def split_feeder(data, filename, parts):
+    """Splits the content of :func:`pack_feeder` in *parts* partitions,
+    packed the same way. The partition *i* has the rows *i*, *i + parts*,
+    *i + 2 * parts*... like the share of an agent of the *unique*
+    strategy."""
+    content = zlib.decompress(str(data).decode('base64'))
+    partitions = [StringIO() for part in range(parts)]
+
+    if _get_format(filename) == 'csv':
+        reader = csv.reader(StringIO(content))
+        writers = [csv.writer(partition) for partition in partitions]
+        header = next(reader, None)
+        if header is not None:
+            for writer in writers:
+                writer.writerow(header)
+        for index, row in enumerate(reader):
+            writers[index % parts].writerow(row)
+    else:
+        lines = [line for line in content.splitlines() if line.strip()]
+        for index, line in enumerate(lines):
+            partitions[index % parts].write(line + '\n')
+
+    return [zlib.compress(partition.getvalue()).encode('base64')
+            for partition in partitions]
+
+
+def _get_key(filename, strategy):
+    return '%s:%s' % (strategy, filename)
+
+
+def get_feeder(filename, strategy='round-robin', format=None, config=None):
+    """Returns the feeder of the file, shared by all the users.
+
+    When the run is resumed, the feeder starts at the position it had at
+    the last checkpoint -- see :func:`get_positions`. The partition of the
+    *--feeder* file an agent got from the broker is its share already.
+    """
+    key = filename, strategy
+    if key not in _FEEDERS:
+        if config is None:
+            config = {}
+        agents = config.get('agents') or 1
+        if (config.get('feeder_partitioned') and
+                filename == config.get('feeder')):
+            agents = 1
+        feeder = Feeder(filename, strategy, format,
+                        agent_index=config.get('agent_index') or 0,
+                        agents=agents)
+        positions = config.get('feeder_positions') or {}
+        feeder._position = positions.get(_get_key(filename, strategy), 0)
+        _FEEDERS[key] = feeder
+    return _FEEDERS[key]
+
+
+def get_positions():
+    """Returns the positions of the feeders in their rows."""
+    return dict([(_get_key(filename, strategy), feeder._position)
+                 for (filename, strategy), feeder in _FEEDERS.items()])
@@ -0,0 +1,28 @@
+package loads
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// engines are the transports of vu.HTTP a run can pick with
+// LOADS_HTTP_ENGINE -- "net/http" by default. The fasthttp one is built in
+// with the fasthttp tag.
+var engines = map[string]func() http.RoundTripper{
+	"net/http": func() http.RoundTripper { return http.DefaultTransport },
+}
+
+func newEngine(name string) (http.RoundTripper, error) {
+	if name == "" {
+		name = "net/http"
+	}
+	engine, ok := engines[name]
+	if ok {
+		return engine(), nil
+	}
+	if name == "fasthttp" {
+		return nil, fmt.Errorf("the fasthttp engine is not built in: " +
+			"build the scenarios with -tags fasthttp")
+	}
+	return nil, fmt.Errorf("unknown HTTP engine %q", name)
+}
@@ -0,0 +1,213 @@
+package loads
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	firstNames = []string{"James", "Mary", "Robert", "Patricia", "John",
+		"Jennifer", "Michael", "Linda", "David", "Elizabeth", "Ada", "Grace",
+		"Alan", "Margaret", "Dennis", "Barbara", "Ken", "Frances", "Edsger",
+		"Radia"}
+	lastNames = []string{"Smith", "Johnson", "Williams", "Brown", "Jones",
+		"Garcia", "Miller", "Davis", "Lovelace", "Hopper", "Turing",
+		"Hamilton", "Ritchie", "Liskov", "Thompson", "Allen", "Dijkstra",
+		"Perlman", "Knuth", "Lamport"}
+	domains = []string{"example.com", "example.org", "example.net"}
+	cities  = []string{"Springfield", "Riverside", "Fairview", "Madison",
+		"Georgetown", "Salem", "Clinton", "Franklin", "Greenville", "Bristol"}
+	countries = []string{"France", "Germany", "Spain", "Italy",
+		"United States", "Canada", "Japan", "Brazil", "India", "Australia"}
+	streets = []string{"Main", "Oak", "Pine", "Maple", "Cedar", "Elm",
+		"Lake", "Hill", "Park", "Church"}
+	streetTypes     = []string{"Street", "Avenue", "Road", "Boulevard", "Lane"}
+	companySuffixes = []string{"Inc", "LLC", "Ltd", "Group", "Systems"}
+	words           = []string{"lorem", "ipsum", "dolor", "sit", "amet",
+		"consectetur", "adipiscing", "elit", "sed", "do", "eiusmod", "tempor"}
+)
+
+// the unique values of this process start with it, so they don't collide
+// with the ones of the other agents
+var (
+	fakeProcess = processPrefix()
+	fakeCounter uint64
+)
+
+func processPrefix() string {
+	prefix := make([]byte, 3)
+	if _, err := rand.Read(prefix); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano()%0xffffff, 16)
+	}
+	return hex.EncodeToString(prefix)
+}
+
+// LuhnDigit returns the check digit to append to a number, with the Luhn
+// algorithm.
+func LuhnDigit(digits string) string {
+	total := 0
+	for i := 0; i < len(digits); i++ {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		total += digit
+	}
+	return strconv.Itoa((10 - total%10) % 10)
+}
+
+// Faker generates fake data for the payloads, like the one of loads.fake:
+// the user names and the emails never repeat, even across the agents. A
+// Faker is not safe for concurrent use -- every VU has its own.
+type Faker struct {
+	rand *mathrand.Rand
+}
+
+// NewFaker returns a Faker, seeded to get the same data every run.
+func NewFaker(seed int64) *Faker {
+	return &Faker{rand: mathrand.New(mathrand.NewSource(seed))}
+}
+
+func (f *Faker) choice(values []string) string {
+	return values[f.rand.Intn(len(values))]
+}
+
+// between returns a number from low to high, both included.
+func (f *Faker) between(low, high int) int {
+	return low + f.rand.Intn(high-low+1)
+}
+
+func (f *Faker) unique() string {
+	return fakeProcess + strconv.FormatUint(atomic.AddUint64(&fakeCounter, 1), 10)
+}
+
+func (f *Faker) FirstName() string { return f.choice(firstNames) }
+func (f *Faker) LastName() string  { return f.choice(lastNames) }
+
+func (f *Faker) Name() string {
+	return f.FirstName() + " " + f.LastName()
+}
+
+func (f *Faker) UserName() string {
+	return strings.ToLower(f.FirstName()) + "." +
+		strings.ToLower(f.LastName()) + "." + f.unique()
+}
+
+func (f *Faker) Email() string {
+	return f.UserName() + "@" + f.choice(domains)
+}
+
+func (f *Faker) Password() string {
+	const characters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
+	password := make([]byte, 12)
+	for i := range password {
+		password[i] = characters[f.rand.Intn(len(characters))]
+	}
+	return string(password)
+}
+
+func (f *Faker) Phone() string {
+	return fmt.Sprintf("+1-%03d-%03d-%04d", f.between(200, 999),
+		f.between(200, 999), f.between(0, 9999))
+}
+
+func (f *Faker) IPv4() string {
+	return fmt.Sprintf("%d.%d.%d.%d", f.between(1, 254), f.between(1, 254),
+		f.between(1, 254), f.between(1, 254))
+}
+
+func (f *Faker) IPv6() string {
+	groups := make([]string, 8)
+	for i := range groups {
+		groups[i] = strconv.FormatInt(int64(f.rand.Intn(0x10000)), 16)
+	}
+	return strings.Join(groups, ":")
+}
+
+func (f *Faker) MACAddress() string {
+	groups := make([]string, 6)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%02x", f.rand.Intn(256))
+	}
+	return strings.Join(groups, ":")
+}
+
+// CreditCard returns a 16 digits Visa number, valid for the Luhn
+// algorithm.
+func (f *Faker) CreditCard() string {
+	digits := make([]byte, 15)
+	digits[0] = '4'
+	for i := 1; i < len(digits); i++ {
+		digits[i] = byte('0' + f.rand.Intn(10))
+	}
+	return string(digits) + LuhnDigit(string(digits))
+}
+
+func (f *Faker) UUID() string {
+	id := make([]byte, 16)
+	f.rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[:4], id[4:6], id[6:8], id[8:10],
+		id[10:])
+}
+
+func (f *Faker) Company() string {
+	return f.LastName() + " " + f.choice(companySuffixes)
+}
+
+func (f *Faker) StreetAddress() string {
+	return fmt.Sprintf("%d %s %s", f.between(1, 9999), f.choice(streets),
+		f.choice(streetTypes))
+}
+
+func (f *Faker) City() string    { return f.choice(cities) }
+func (f *Faker) Country() string { return f.choice(countries) }
+
+func (f *Faker) ZipCode() string {
+	return fmt.Sprintf("%05d", f.between(501, 99950))
+}
+
+func (f *Faker) Word() string { return f.choice(words) }
+
+func (f *Faker) Sentence() string {
+	sentence := make([]string, 8)
+	for i := range sentence {
+		sentence[i] = f.Word()
+	}
+	joined := strings.Join(sentence, " ")
+	return strings.ToUpper(joined[:1]) + joined[1:] + "."
+}
+
+// fakeGenerators are the generators of Fake, by their names in lower case
+// -- the way loads.fake matches them.
+var fakeGenerators = map[string]func(*Faker) string{
+	"firstname": (*Faker).FirstName, "lastname": (*Faker).LastName,
+	"name": (*Faker).Name, "username": (*Faker).UserName,
+	"email": (*Faker).Email, "password": (*Faker).Password,
+	"phone": (*Faker).Phone, "ipv4": (*Faker).IPv4, "ipv6": (*Faker).IPv6,
+	"macaddress": (*Faker).MACAddress, "creditcard": (*Faker).CreditCard,
+	"uuid": (*Faker).UUID, "company": (*Faker).Company,
+	"streetaddress": (*Faker).StreetAddress, "city": (*Faker).City,
+	"country": (*Faker).Country, "zipcode": (*Faker).ZipCode,
+	"word": (*Faker).Word, "sentence": (*Faker).Sentence,
+}
+
+// Fake returns a value of the generator called name, like "Email" or
+// "CreditCard" -- the names of the {{fake.Name}} templates.
+func (f *Faker) Fake(name string) (string, error) {
+	generator, ok := fakeGenerators[strings.ToLower(strings.Replace(name, "_", "", -1))]
+	if !ok {
+		return "", fmt.Errorf("unknown fake data %q", name)
+	}
+	return generator(f), nil
+}
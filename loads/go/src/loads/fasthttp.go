@@ -0,0 +1,80 @@
+//go:build fasthttp
+// +build fasthttp
+
+package loads
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+func init() {
+	engines["fasthttp"] = func() http.RoundTripper {
+		return &fastTransport{client: &fasthttp.Client{}}
+	}
+}
+
+// fastTransport sends the requests of vu.HTTP with fasthttp, which
+// allocates a lot less than net/http: the scenarios are the same, and so
+// are their hits. The bodies are read at once, and are not decompressed.
+type fastTransport struct {
+	client *fasthttp.Client
+}
+
+func (t *fastTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	request := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(request)
+	request.SetRequestURI(req.URL.String())
+	request.Header.SetMethod(req.Method)
+	if req.Host != "" {
+		request.Header.SetHost(req.Host)
+	}
+	for name, values := range req.Header {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		request.SetBody(body)
+	}
+
+	response := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(response)
+	var err error
+	if deadline, ok := req.Context().Deadline(); ok {
+		err = t.client.DoDeadline(request, response, deadline)
+	} else {
+		err = t.client.Do(request, response)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := response.StatusCode()
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	response.Header.VisitAll(func(key, value []byte) {
+		resp.Header.Add(string(key), string(value))
+	})
+	// the body of the response is released with it
+	body := append([]byte(nil), response.Body()...)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
@@ -0,0 +1,39 @@
+//go:build fasthttp
+// +build fasthttp
+
+package loads
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFastTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("X-Method", r.Method)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		}))
+	defer server.Close()
+
+	engine, err := newEngine("fasthttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: engine}
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("OK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated || string(body) != "OK" ||
+		resp.Header.Get("X-Method") != "POST" {
+		t.Errorf("got %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+}
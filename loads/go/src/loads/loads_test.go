@@ -0,0 +1,287 @@
+package loads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pull accepts a single PUSH peer on a unix socket, and returns the
+// messages it sends.
+func pull(t *testing.T) (string, <-chan map[string]interface{}) {
+	dir, err := ioutil.TempDir("", "loads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "receiver.ipc")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan map[string]interface{}, 100)
+	go func() {
+		defer os.RemoveAll(dir)
+		defer listener.Close()
+		defer close(messages)
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		peer := make([]byte, 64)
+		if _, err := io.ReadFull(conn, peer); err != nil {
+			return
+		}
+		conn.Write(greeting())
+		writeFrame(conn, flagCommand, readyCommand("PULL"))
+		if flags, _, err := readFrame(conn); err != nil || flags != flagCommand {
+			return
+		}
+
+		for {
+			_, body, err := readFrame(conn)
+			if err != nil {
+				return
+			}
+			var message map[string]interface{}
+			json.Unmarshal(body, &message)
+			messages <- message
+		}
+	}()
+	return "ipc://" + path, messages
+}
+
+type search struct {
+	url   string
+	setup bool
+}
+
+func (s *search) Setup(ctx context.Context) error {
+	s.setup = true
+	return nil
+}
+
+func (s *search) Teardown(ctx context.Context) error {
+	return nil
+}
+
+func (s *search) Run(ctx context.Context, vu *VU) error {
+	resp, err := vu.HTTP.Get(s.url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	vu.Incr("searches", 1)
+	if !vu.Check("status is 200", resp.StatusCode == 200) {
+		return Failf("got a %d", resp.StatusCode)
+	}
+	if vu.Hit == 3 {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func TestExecute(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(404)
+			}
+		}))
+	defer server.Close()
+
+	receiver, messages := pull(t)
+	config := &Config{Receiver: receiver, AgentID: "1", RunID: "run",
+		TotalUsers: 2, CurrentUser: 2, TotalHits: 3}
+	scenario := &search{url: server.URL}
+	if err := Execute(context.Background(), "search", scenario, config); err != nil {
+		t.Fatal(err)
+	}
+	if !scenario.setup {
+		t.Error("Setup was not called")
+	}
+
+	counts := map[string]int{}
+	for message := range messages {
+		counts[message["data_type"].(string)]++
+		if message["run_id"] != "run" || message["agent_id"] != "1" {
+			t.Errorf("unexpected message %v", message)
+		}
+		if message["data_type"] == "add_hit" {
+			status := message["loads_status"].([]interface{})
+			if status[0].(float64) != 3 || status[3].(float64) != 2 {
+				t.Errorf("unexpected status %v", status)
+			}
+		}
+	}
+
+	wanted := map[string]int{"startTest": 3, "stopTest": 3, "add_hit": 3,
+		"add_check": 3, "incr_counter": 3, "addSuccess": 2, "addError": 1}
+	for dataType, count := range wanted {
+		if counts[dataType] != count {
+			t.Errorf("%d %s messages, not %d", counts[dataType], dataType,
+				count)
+		}
+	}
+
+	// a failed check fails the test
+	receiver, messages = pull(t)
+	config.Receiver, config.TotalHits = receiver, 1
+	scenario.url = server.URL + "/missing"
+	if err := Execute(context.Background(), "search", scenario, config); err != nil {
+		t.Fatal(err)
+	}
+	failed := false
+	for message := range messages {
+		if message["data_type"] == "addFailure" {
+			failed = message["exc_info"].([]interface{})[0] == "got a 404"
+		}
+	}
+	if !failed {
+		t.Error("the test did not fail")
+	}
+}
+
+// recorder is a connection that keeps what is written, or not.
+type recorder struct {
+	net.Conn
+	frames [][]byte
+	keep   bool
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	if r.keep {
+		r.frames = append(r.frames, append([]byte(nil), data...))
+	}
+	return len(data), nil
+}
+
+func TestRecord(t *testing.T) {
+	conn := &recorder{keep: true}
+	report := &reporter{socket: &pushSocket{conn: conn},
+		config: &Config{AgentID: "1", RunID: "run"}}
+	vu := newVU(report, "search", &Config{TotalUsers: 2, CurrentUser: 1,
+		TotalHits: 3}, http.DefaultTransport)
+	started := time.Date(2013, 9, 27, 12, 0, 0, 5000, time.FixedZone("", 3600))
+	url := "http://localhost/?q=\"caf\xc3\xa9\"\n\x01\xff"
+	for i := 0; i < 2; i++ {
+		vu.AddHit("GET", url, 200, started, 1500*time.Millisecond)
+	}
+	// the second message is made of the encoded labels
+	sent := conn.frames[1][2:]
+	if conn.frames[1][0]&flagLong != 0 {
+		sent = conn.frames[1][9:]
+	}
+
+	var got, wanted map[string]interface{}
+	if err := json.Unmarshal(sent, &got); err != nil {
+		t.Fatalf("%s: %v", sent, err)
+	}
+	encoded, _ := json.Marshal(map[string]interface{}{
+		"data_type": "add_hit", "agent_id": "1", "run_id": "run", "url": url,
+		"method": "GET", "status": 200, "started": "2013-09-27T11:00:00.000005",
+		"elapsed": 1.5, "loads_status": []int{3, 2, 0, 1},
+		"scenario": "search"})
+	json.Unmarshal(encoded, &wanted)
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("got %v, want %v", got, wanted)
+	}
+
+	conn.keep = false
+	allocs := testing.AllocsPerRun(100, func() {
+		vu.AddHit("GET", url, 200, started, time.Second)
+		vu.Incr("searches", 1)
+		report.sendTest("stopTest", "search", vu.status())
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per hit", allocs)
+	}
+}
+
+func TestPickScenario(t *testing.T) {
+	scenarios := map[string]Scenario{"search": &search{}}
+	if name, err := pickScenario(scenarios, nil); name != "search" || err != nil {
+		t.Errorf("got %q, %v", name, err)
+	}
+	if _, err := pickScenario(scenarios, []string{"other"}); err == nil {
+		t.Error("an unknown scenario was picked")
+	}
+
+	scenarios["other"] = &search{}
+	if _, err := pickScenario(scenarios, nil); err == nil {
+		t.Error("a scenario was picked among several")
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	for _, name := range []string{"", "net/http"} {
+		if engine, err := newEngine(name); engine != http.DefaultTransport || err != nil {
+			t.Errorf("%q: got %v, %v", name, engine, err)
+		}
+	}
+	if _, err := newEngine("curl"); err == nil {
+		t.Error("an unknown engine was picked")
+	}
+}
+
+func TestSplitEndpoint(t *testing.T) {
+	network, address, err := splitEndpoint("tcp://127.0.0.1:7783")
+	if network != "tcp" || address != "127.0.0.1:7783" || err != nil {
+		t.Errorf("got %q, %q, %v", network, address, err)
+	}
+	network, address, err = splitEndpoint("ipc:///tmp/receiver.ipc")
+	if network != "unix" || address != "/tmp/receiver.ipc" || err != nil {
+		t.Errorf("got %q, %q, %v", network, address, err)
+	}
+	if _, _, err = splitEndpoint("inproc://results"); err == nil {
+		t.Error("inproc is not supported")
+	}
+}
+
+func TestFaker(t *testing.T) {
+	if digit := LuhnDigit("7992739871"); digit != "3" {
+		t.Errorf("LuhnDigit: got %s, want 3", digit)
+	}
+	faker := NewFaker(1)
+	for i := 0; i < 50; i++ {
+		number := faker.CreditCard()
+		if len(number) != 16 || LuhnDigit(number[:15]) != number[15:] {
+			t.Errorf("CreditCard: %s is not valid", number)
+		}
+	}
+
+	emails := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		emails[faker.Email()] = true
+	}
+	if len(emails) != 1000 {
+		t.Errorf("Email: got %d unique emails, want 1000", len(emails))
+	}
+	if NewFaker(42).Name() != NewFaker(42).Name() {
+		t.Error("Name: the seeded fakers differ")
+	}
+
+	if value, err := faker.Fake("IPv6"); err != nil || strings.Count(value, ":") != 7 {
+		t.Errorf("Fake: got %q, %v for IPv6", value, err)
+	}
+	if value, err := faker.Fake("zip_code"); err != nil || len(value) != 5 {
+		t.Errorf("Fake: got %q, %v for zip_code", value, err)
+	}
+	if _, err := faker.Fake("Unknown"); err == nil {
+		t.Error("Fake: no error for Unknown")
+	}
+}
@@ -0,0 +1,29 @@
+// Package plugin serves the plugins of loads -- the result sinks, the auth
+// providers and the observers shipped as separate binaries -- with
+// hashicorp/go-plugin and the services of plugin.proto.
+//
+//	type Sink struct{ brokers string }
+//
+//	func (s *Sink) Push(records []plugin.Record) error { ... }
+//	func (s *Sink) Flush() error                       { return nil }
+//
+//	func main() {
+//		sink := &Sink{}
+//		plugin.Serve(&plugin.Plugin{
+//			Name: "kafka",
+//			Configure: func(options map[string]string, run plugin.Run) error {
+//				sink.brokers = options["brokers"]
+//				return nil
+//			},
+//			Sink: sink,
+//		})
+//	}
+//
+// and then:
+//
+//	$ loads-runner example.TestWebSite.test_es -u 10 -d 600 \
+//		--plugin ./kafka-sink --plugin-option brokers=kafka1:9092
+//
+// The package needs go-plugin and gRPC in the Go path: it is built with the
+// goplugin tag.
+package plugin
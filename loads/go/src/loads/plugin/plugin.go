@@ -0,0 +1,234 @@
+//go:build goplugin
+// +build goplugin
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Handshake is the one loads-runner and loads-broker expect: the plugin
+// exits at once when they did not start it.
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "LOADS_PLUGIN",
+	MagicCookieValue: "7f3e1c5a9b2d4e6f",
+}
+
+// Record is a hit, with a "hit" type, or the result of a test, with a
+// "test" type -- see plugin.proto for their fields.
+type Record map[string]interface{}
+
+// Run is the run a plugin is started for. The broker starts its plugins
+// for all the runs: their run is empty.
+type Run struct {
+	ID      string `json:"run_id"`
+	FQN     string `json:"fqn"`
+	Project string `json:"project_name"`
+}
+
+// Request is a request of a virtual user, before the auth headers.
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// Sink gets the records of a run by batches.
+type Sink interface {
+	Push(records []Record) error
+	Flush() error
+}
+
+// Auth returns the headers to add to a request.
+type Auth interface {
+	Authenticate(req Request) (map[string]string, error)
+}
+
+// Observer gets the started, breached and ended events of the runs.
+type Observer interface {
+	Notify(event map[string]interface{}) error
+}
+
+// Plugin is what a binary serves: its kinds are the ones it has.
+type Plugin struct {
+	Name string
+	// Configure gets the --plugin-option values, once.
+	Configure func(options map[string]string, run Run) error
+	Sink      Sink
+	Auth      Auth
+	Observer  Observer
+}
+
+func (p *Plugin) kinds() []string {
+	kinds := []string{}
+	if p.Sink != nil {
+		kinds = append(kinds, "sink")
+	}
+	if p.Auth != nil {
+		kinds = append(kinds, "auth")
+	}
+	if p.Observer != nil {
+		kinds = append(kinds, "observer")
+	}
+	return kinds
+}
+
+// Serve serves a plugin until loads stops it.
+func Serve(p *Plugin) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         goplugin.PluginSet{"loads": &grpcPlugin{plugin: p}},
+		GRPCServer:      goplugin.DefaultGRPCServer,
+	})
+}
+
+// grpcPlugin registers the services: loads is the client, in Python.
+type grpcPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+	plugin *Plugin
+}
+
+func (g *grpcPlugin) GRPCServer(broker *goplugin.GRPCBroker, s *grpc.Server) error {
+	for _, desc := range serviceDescs {
+		s.RegisterService(desc, g.plugin)
+	}
+	return nil
+}
+
+func (g *grpcPlugin) GRPCClient(ctx context.Context, broker *goplugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return nil, fmt.Errorf("the plugins of loads are only served")
+}
+
+type configuration struct {
+	Options map[string]string `json:"options"`
+	Run     Run               `json:"run"`
+}
+
+func configure(p *Plugin, data []byte) (interface{}, error) {
+	var conf configuration
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, err
+	}
+	if p.Configure != nil {
+		if err := p.Configure(conf.Options, conf.Run); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"name": p.Name, "kinds": p.kinds()}, nil
+}
+
+func push(p *Plugin, data []byte) (interface{}, error) {
+	if p.Sink == nil {
+		return nil, fmt.Errorf("%s is not a sink", p.Name)
+	}
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return nil, p.Sink.Push(records)
+}
+
+func flush(p *Plugin, data []byte) (interface{}, error) {
+	if p.Sink == nil {
+		return nil, fmt.Errorf("%s is not a sink", p.Name)
+	}
+	return nil, p.Sink.Flush()
+}
+
+func authenticate(p *Plugin, data []byte) (interface{}, error) {
+	if p.Auth == nil {
+		return nil, fmt.Errorf("%s is not an auth provider", p.Name)
+	}
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	headers, err := p.Auth.Authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"headers": headers}, nil
+}
+
+func notify(p *Plugin, data []byte) (interface{}, error) {
+	if p.Observer == nil {
+		return nil, fmt.Errorf("%s is not an observer", p.Name)
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	return nil, p.Observer.Notify(event)
+}
+
+// method is a method of the services, given the JSON of its value.
+type method func(p *Plugin, data []byte) (interface{}, error)
+
+// handler decodes the JSON of the StringValue of a call, and encodes the
+// one of its reply.
+func handler(fullMethod string, m method) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(wrapperspb.StringValue)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		call := func(ctx context.Context, req interface{}) (interface{}, error) {
+			value, err := m(srv.(*Plugin),
+				[]byte(req.(*wrapperspb.StringValue).GetValue()))
+			if err != nil {
+				return nil, err
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			return wrapperspb.String(string(data)), nil
+		}
+		if interceptor == nil {
+			return call(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+		return interceptor(ctx, in, info, call)
+	}
+}
+
+func serviceDesc(service string, methods map[string]method) *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: "loads.plugin." + service,
+		HandlerType: (*interface{})(nil),
+		Streams:     []grpc.StreamDesc{},
+		Metadata:    "plugin.proto",
+	}
+	for name, m := range methods {
+		fullMethod := "/" + desc.ServiceName + "/" + name
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: name,
+			Handler:    handler(fullMethod, m),
+		})
+	}
+	return desc
+}
+
+// serviceDescs are the services of plugin.proto, by name.
+var serviceDescs = map[string]*grpc.ServiceDesc{
+	"Plugin": serviceDesc("Plugin", map[string]method{
+		"Configure": configure,
+	}),
+	"Sink": serviceDesc("Sink", map[string]method{
+		"Push":  push,
+		"Flush": flush,
+	}),
+	"Auth": serviceDesc("Auth", map[string]method{
+		"Authenticate": authenticate,
+	}),
+	"Observer": serviceDesc("Observer", map[string]method{
+		"Notify": notify,
+	}),
+}
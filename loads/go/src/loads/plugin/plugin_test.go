@@ -0,0 +1,93 @@
+//go:build goplugin
+// +build goplugin
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type sink struct {
+	records []Record
+	flushed bool
+}
+
+func (s *sink) Push(records []Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *sink) Flush() error {
+	s.flushed = true
+	return nil
+}
+
+func call(t *testing.T, p *Plugin, service, name string, value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range serviceDescs[service].Methods {
+		if m.MethodName != name {
+			continue
+		}
+		dec := func(in interface{}) error {
+			in.(*wrapperspb.StringValue).Value = string(data)
+			return nil
+		}
+		reply, err := m.Handler(p, context.Background(), dec, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result interface{}
+		err = json.Unmarshal([]byte(reply.(*wrapperspb.StringValue).Value),
+			&result)
+		return result, err
+	}
+	t.Fatalf("no %s/%s method", service, name)
+	return nil, nil
+}
+
+func TestSink(t *testing.T) {
+	s := &sink{}
+	var options map[string]string
+	p := &Plugin{Name: "test", Sink: s,
+		Configure: func(o map[string]string, run Run) error {
+			options = o
+			return nil
+		}}
+
+	info, err := call(t, p, "Plugin", "Configure", map[string]interface{}{
+		"options": map[string]string{"brokers": "kafka:9092"},
+		"run":     map[string]string{"run_id": "1234"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info, map[string]interface{}{
+		"name": "test", "kinds": []interface{}{"sink"}}) {
+		t.Errorf("got %v", info)
+	}
+	if options["brokers"] != "kafka:9092" {
+		t.Errorf("got %v", options)
+	}
+
+	if _, err := call(t, p, "Sink", "Push", []Record{{"type": "hit"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(t, p, "Sink", "Flush", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.records) != 1 || s.records[0]["type"] != "hit" || !s.flushed {
+		t.Errorf("got %v %v", s.records, s.flushed)
+	}
+
+	// not an auth provider
+	if _, err := call(t, p, "Auth", "Authenticate", Request{}); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,174 @@
+package loads
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLabels bounds the labels a reporter keeps encoded, so the urls made
+// unique by their query strings don't grow it for ever.
+const maxLabels = 4096
+
+// The messages sent for every hit and every run are encoded in the buffer
+// of the reporter, without maps nor reflection, and the labels -- the
+// tests, the urls, the methods, the names of the counters -- are coded as
+// integers, so each of them is escaped only once. A run of the scenario
+// then allocates nothing to report itself.
+//
+// A message is written between begin and end, with the reporter locked.
+
+// begin starts a message, and returns false when the reporting stopped.
+func (r *reporter) begin(dataType string) bool {
+	r.mu.Lock()
+	if r.err != nil {
+		r.mu.Unlock()
+		return false
+	}
+	if r.header == nil {
+		r.header = append(r.header, `,"agent_id":`...)
+		r.header = appendQuoted(r.header, r.config.AgentID)
+		r.header = append(r.header, `,"run_id":`...)
+		r.header = appendQuoted(r.header, r.config.RunID)
+	}
+	r.buf = append(r.buf[:0], `{"data_type":`...)
+	r.buf = r.appendLabel(r.buf, dataType)
+	r.buf = append(r.buf, r.header...)
+	return true
+}
+
+// end sends the message, and unlocks the reporter.
+func (r *reporter) end() {
+	r.buf = append(r.buf, '}')
+	r.err = r.socket.Send(r.buf)
+	r.mu.Unlock()
+}
+
+func (r *reporter) key(name string) {
+	r.buf = append(r.buf, ',', '"')
+	r.buf = append(r.buf, name...)
+	r.buf = append(r.buf, '"', ':')
+}
+
+func (r *reporter) label(name, value string) {
+	r.key(name)
+	r.buf = r.appendLabel(r.buf, value)
+}
+
+func (r *reporter) integer(name string, value int) {
+	r.key(name)
+	r.buf = strconv.AppendInt(r.buf, int64(value), 10)
+}
+
+func (r *reporter) seconds(name string, value time.Duration) {
+	r.key(name)
+	r.buf = strconv.AppendFloat(r.buf, value.Seconds(), 'f', -1, 64)
+}
+
+func (r *reporter) boolean(name string, value bool) {
+	r.key(name)
+	r.buf = strconv.AppendBool(r.buf, value)
+}
+
+func (r *reporter) date(name string, value time.Time) {
+	r.key(name)
+	r.buf = append(r.buf, '"')
+	r.buf = value.UTC().AppendFormat(r.buf, isoFormat)
+	r.buf = append(r.buf, '"')
+}
+
+func (r *reporter) status(status [4]int) {
+	r.key("loads_status")
+	for i, value := range status {
+		if i == 0 {
+			r.buf = append(r.buf, '[')
+		} else {
+			r.buf = append(r.buf, ',')
+		}
+		r.buf = strconv.AppendInt(r.buf, int64(value), 10)
+	}
+	r.buf = append(r.buf, ']')
+}
+
+// value writes an int or a string without allocating, and anything else
+// as encoding/json does.
+func (r *reporter) value(name string, value interface{}) {
+	switch value := value.(type) {
+	case int:
+		r.integer(name, value)
+	case string:
+		r.label(name, value)
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte("null")
+		}
+		r.key(name)
+		r.buf = append(r.buf, encoded...)
+	}
+}
+
+// sendTest sends the messages about a run, like startTest.
+func (r *reporter) sendTest(dataType, test string, status [4]int) {
+	if r.begin(dataType) {
+		r.label("test", test)
+		r.status(status)
+		r.end()
+	}
+}
+
+// appendLabel appends a string encoded once, and found by its code.
+func (r *reporter) appendLabel(buf []byte, label string) []byte {
+	if code, ok := r.codes[label]; ok {
+		return append(buf, r.labels[code]...)
+	}
+	start := len(buf)
+	buf = appendQuoted(buf, label)
+	if len(r.labels) < maxLabels {
+		if r.codes == nil {
+			r.codes = make(map[string]int)
+		}
+		r.codes[label] = len(r.labels)
+		r.labels = append(r.labels, append([]byte(nil), buf[start:]...))
+	}
+	return buf
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendQuoted appends a string as a JSON one, the invalid UTF-8 being
+// replaced like encoding/json does.
+func appendQuoted(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf = append(buf, `\ufffd`...)
+			} else {
+				buf = append(buf, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, '\\', 'n')
+		case c == '\r':
+			buf = append(buf, '\\', 'r')
+		case c == '\t':
+			buf = append(buf, '\\', 't')
+		case c < 0x20:
+			buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4],
+				hexDigits[c&0xf])
+		default:
+			buf = append(buf, c)
+		}
+		i++
+	}
+	return append(buf, '"')
+}
@@ -0,0 +1,217 @@
+// Package loads runs load test scenarios written in Go as external runners
+// of loads: the binary is given to loads-runner with --test-runner, and
+// reports the tests, the hits, the checks and the counters of every virtual
+// user to loads through ZeroMQ.
+//
+//	type Search struct{}
+//
+//	func (s *Search) Setup(ctx context.Context) error    { return nil }
+//	func (s *Search) Teardown(ctx context.Context) error { return nil }
+//
+//	func (s *Search) Run(ctx context.Context, vu *loads.VU) error {
+//		resp, err := vu.HTTP.Get("http://localhost:9200/")
+//		if err != nil {
+//			return err
+//		}
+//		defer resp.Body.Close()
+//		if !vu.Check("status is 200", resp.StatusCode == 200) {
+//			return loads.Failf("got a %d", resp.StatusCode)
+//		}
+//		return nil
+//	}
+//
+//	func main() {
+//		loads.Main(map[string]loads.Scenario{"search": &Search{}})
+//	}
+//
+// and then:
+//
+//	$ loads-runner --test-runner "./scenarios {test}" search -u 10 -d 60
+package loads
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Scenario is a load test written in Go.
+//
+// Setup and Teardown are called once per virtual user, before and after
+// its runs; Run is called for every hit, or until the duration of the
+// cycle is over -- ctx is then done.
+type Scenario interface {
+	Setup(ctx context.Context) error
+	Run(ctx context.Context, vu *VU) error
+	Teardown(ctx context.Context) error
+}
+
+// Failure is an error that makes the test fail, instead of erroring.
+type Failure struct {
+	Message string
+}
+
+func (f *Failure) Error() string {
+	return f.Message
+}
+
+// Failf returns a Failure with a formatted message.
+func Failf(format string, args ...interface{}) error {
+	return &Failure{fmt.Sprintf(format, args...)}
+}
+
+// Config is what loads tells the external runners, through the
+// environment variables.
+type Config struct {
+	Receiver    string
+	AgentID     string
+	RunID       string
+	TotalUsers  int
+	CurrentUser int
+	TotalHits   int
+	Duration    time.Duration
+	// HTTPEngine is the transport of vu.HTTP, "net/http" when empty.
+	HTTPEngine string
+}
+
+func getInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number: %q", name, value)
+	}
+	return number, nil
+}
+
+// ConfigFromEnv reads the LOADS_* environment variables.
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{Receiver: os.Getenv("LOADS_ZMQ_RECEIVER"),
+		AgentID: os.Getenv("LOADS_AGENT_ID"),
+		RunID:   os.Getenv("LOADS_RUN_ID")}
+	config.HTTPEngine = os.Getenv("LOADS_HTTP_ENGINE")
+	if config.Receiver == "" {
+		return nil, fmt.Errorf("LOADS_ZMQ_RECEIVER is not set, run the " +
+			"scenarios with loads-runner --test-runner")
+	}
+
+	var err error
+	if config.TotalUsers, err = getInt("LOADS_TOTAL_USERS", 1); err != nil {
+		return nil, err
+	}
+	if config.CurrentUser, err = getInt("LOADS_CURRENT_USER", 1); err != nil {
+		return nil, err
+	}
+	if config.TotalHits, err = getInt("LOADS_TOTAL_HITS", 1); err != nil {
+		return nil, err
+	}
+
+	if duration := os.Getenv("LOADS_DURATION"); duration != "" {
+		seconds, err := strconv.ParseFloat(duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("LOADS_DURATION is not a number: %q",
+				duration)
+		}
+		config.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return config, nil
+}
+
+// Main runs the scenario named by the first argument -- or the only one --
+// with the configuration of the environment, and exits.
+func Main(scenarios map[string]Scenario) {
+	name, err := pickScenario(scenarios, os.Args[1:])
+	if err == nil {
+		var config *Config
+		if config, err = ConfigFromEnv(); err == nil {
+			err = Execute(context.Background(), name, scenarios[name],
+				config)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func pickScenario(scenarios map[string]Scenario, args []string) (string, error) {
+	if len(args) > 0 {
+		if _, ok := scenarios[args[0]]; !ok {
+			return "", fmt.Errorf("unknown scenario %q", args[0])
+		}
+		return args[0], nil
+	}
+	if len(scenarios) == 1 {
+		for name := range scenarios {
+			return name, nil
+		}
+	}
+
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("pick a scenario among %v", names)
+}
+
+// Execute runs a scenario as a virtual user of loads: Setup, then Run for
+// every hit or until the duration is over, then Teardown. Every run is a
+// test reported to loads, which fails when Run returns a Failure and
+// errors when it returns another error.
+func Execute(ctx context.Context, name string, scenario Scenario, config *Config) error {
+	engine, err := newEngine(config.HTTPEngine)
+	if err != nil {
+		return err
+	}
+	socket, err := dialPush(config.Receiver, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %v", config.Receiver, err)
+	}
+	defer socket.Close()
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	test := fmt.Sprintf("%s (%s)", name, filepath.Base(os.Args[0]))
+	vu := newVU(&reporter{socket: socket, config: config}, test, config,
+		engine)
+
+	if err := scenario.Setup(ctx); err != nil {
+		vu.report.addError("addError", test, vu.status(), err)
+		return err
+	}
+
+	for hit := 1; config.Duration > 0 || hit <= config.TotalHits; hit++ {
+		if ctx.Err() != nil {
+			break
+		}
+		vu.Hit = hit
+		status := vu.status()
+		vu.report.sendTest("startTest", test, status)
+
+		err := scenario.Run(ctx, vu)
+		if _, failed := err.(*Failure); failed {
+			vu.report.addError("addFailure", test, status, err)
+		} else if err != nil && ctx.Err() == nil {
+			vu.report.addError("addError", test, status, err)
+		} else if err == nil {
+			vu.report.sendTest("addSuccess", test, status)
+		}
+		vu.report.sendTest("stopTest", test, status)
+	}
+
+	if err := scenario.Teardown(context.Background()); err != nil {
+		return err
+	}
+	return vu.report.err
+}
@@ -0,0 +1,179 @@
+package loads
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the format of the dates loads expects
+const isoFormat = "2006-01-02T15:04:05.000000"
+
+// reporter sends the messages of a virtual user to loads.
+type reporter struct {
+	socket *pushSocket
+	config *Config
+	mu     sync.Mutex
+	// the first error, that stops the reporting
+	err error
+
+	// the message being encoded, the ids that start every message, and
+	// the labels by their code -- see record.go
+	buf    []byte
+	header []byte
+	codes  map[string]int
+	labels [][]byte
+}
+
+func (r *reporter) send(dataType string, data map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+
+	data["data_type"] = dataType
+	data["agent_id"] = r.config.AgentID
+	data["run_id"] = r.config.RunID
+	message, err := json.Marshal(data)
+	if err == nil {
+		err = r.socket.Send(message)
+	}
+	r.err = err
+}
+
+func (r *reporter) addError(dataType, test string, status [4]int, err error) {
+	// a string, the class and the traceback of the exception
+	excInfo := []string{err.Error(), fmt.Sprintf("%T", err), ""}
+	r.send(dataType, map[string]interface{}{
+		"test": test, "exc_info": excInfo, "loads_status": status[:]})
+}
+
+// VU is a virtual user, given to every run of a scenario.
+type VU struct {
+	// User is the number of the user, from 1 to Users.
+	User  int
+	Users int
+	// Hit is the number of the current run, from 1 on.
+	Hit int
+	// HTTP is a client that reports its requests as hits.
+	HTTP *http.Client
+	// Fake generates fake data for the payloads.
+	Fake *Faker
+
+	report *reporter
+	test   string
+	hits   int
+}
+
+func newVU(report *reporter, test string, config *Config, engine http.RoundTripper) *VU {
+	vu := &VU{User: config.CurrentUser, Users: config.TotalUsers,
+		report: report, test: test, hits: config.TotalHits}
+	vu.HTTP = &http.Client{Transport: &hitTransport{vu: vu, base: engine}}
+	vu.Fake = NewFaker(time.Now().UnixNano() + int64(config.CurrentUser))
+	return vu
+}
+
+// status returns the loads_status of the current run: the hits and the
+// users of the cycle, the current hit and the current user.
+func (vu *VU) status() [4]int {
+	return [4]int{vu.hits, vu.Users, vu.Hit, vu.User}
+}
+
+// AddHit reports a request made without the HTTP client, like with
+// another protocol -- the status is then "OK" or an error.
+func (vu *VU) AddHit(method, url string, status interface{}, started time.Time, elapsed time.Duration) {
+	if r := vu.report; r.begin("add_hit") {
+		r.label("url", url)
+		r.label("method", method)
+		r.value("status", status)
+		r.date("started", started)
+		r.seconds("elapsed", elapsed)
+		r.status(vu.status())
+		r.label("scenario", vu.test)
+		r.end()
+	}
+}
+
+// Check reports whether a check passed, and returns it.
+func (vu *VU) Check(name string, passed bool) bool {
+	if r := vu.report; r.begin("add_check") {
+		r.label("name", name)
+		r.boolean("passed", passed)
+		r.end()
+	}
+	return passed
+}
+
+// Incr increments a custom counter of the test.
+func (vu *VU) Incr(name string, value int) {
+	if r := vu.report; r.begin("incr_counter") {
+		r.label("test", vu.test)
+		r.status(vu.status())
+		r.label("name", name)
+		r.integer("value", value)
+		r.end()
+	}
+}
+
+// Dial connects a socket with dial -- like websocket.Dial -- and returns
+// it wrapped, so its connection time, its messages and its closing are
+// reported.
+func (vu *VU) Dial(dial func() (net.Conn, error)) (net.Conn, error) {
+	started := time.Now()
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	vu.report.send("socket_open", map[string]interface{}{
+		"elapsed": time.Since(started).Seconds()})
+	return &socket{Conn: conn, vu: vu}, nil
+}
+
+// RTT reports the round-trip time of a message.
+func (vu *VU) RTT(elapsed time.Duration) {
+	if r := vu.report; r.begin("socket_rtt") {
+		r.seconds("elapsed", elapsed)
+		r.end()
+	}
+}
+
+type hitTransport struct {
+	vu   *VU
+	base http.RoundTripper
+}
+
+func (t *hitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.vu.AddHit(req.Method, req.URL.String(), resp.StatusCode, started,
+		time.Since(started))
+	return resp, nil
+}
+
+type socket struct {
+	net.Conn
+	vu *VU
+}
+
+func (s *socket) Read(data []byte) (int, error) {
+	n, err := s.Conn.Read(data)
+	if n > 0 {
+		if r := s.vu.report; r.begin("socket_message") {
+			r.integer("size", n)
+			r.end()
+		}
+	}
+	return n, err
+}
+
+func (s *socket) Close() error {
+	s.vu.report.send("socket_close", map[string]interface{}{})
+	return s.Conn.Close()
+}
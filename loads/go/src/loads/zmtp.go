@@ -0,0 +1,170 @@
+package loads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The flags of a ZMTP frame.
+const (
+	flagLong    = 0x02
+	flagCommand = 0x04
+)
+
+// pushSocket is a ZeroMQ PUSH socket connected to a single PULL peer,
+// speaking ZMTP 3.0 with the NULL mechanism -- enough to send the results
+// to loads without linking libzmq.
+type pushSocket struct {
+	mu   sync.Mutex
+	conn net.Conn
+	// the frame being sent, reused by every message
+	frame []byte
+}
+
+// splitEndpoint returns the network and the address of a ZeroMQ endpoint,
+// like "tcp://127.0.0.1:7783" or "ipc:///tmp/loads-receiver.ipc".
+func splitEndpoint(endpoint string) (string, string, error) {
+	parts := strings.SplitN(endpoint, "://", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	switch parts[0] {
+	case "tcp":
+		return "tcp", parts[1], nil
+	case "ipc":
+		return "unix", parts[1], nil
+	}
+	return "", "", fmt.Errorf("unsupported transport %q", parts[0])
+}
+
+func dialPush(endpoint string, timeout time.Duration) (*pushSocket, error) {
+	network, address, err := splitEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := handshake(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &pushSocket{conn: conn}, nil
+}
+
+func greeting() []byte {
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3 // version 3.0
+	copy(greeting[12:32], "NULL")
+	return greeting
+}
+
+func handshake(conn net.Conn) error {
+	if _, err := conn.Write(greeting()); err != nil {
+		return err
+	}
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(conn, peer); err != nil {
+		return err
+	}
+	if peer[0] != 0xff || peer[9]&0x01 != 1 || peer[10] < 3 {
+		return errors.New("the peer does not speak ZMTP 3")
+	}
+
+	if err := writeFrame(conn, flagCommand, readyCommand("PUSH")); err != nil {
+		return err
+	}
+	flags, body, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	if flags&flagCommand == 0 || len(body) < 6 || string(body[1:6]) != "READY" {
+		return errors.New("the peer is not ready")
+	}
+	return nil
+}
+
+func readyCommand(socketType string) []byte {
+	var body bytes.Buffer
+	body.WriteByte(5)
+	body.WriteString("READY")
+	body.WriteByte(byte(len("Socket-Type")))
+	body.WriteString("Socket-Type")
+	binary.Write(&body, binary.BigEndian, uint32(len(socketType)))
+	body.WriteString(socketType)
+	return body.Bytes()
+}
+
+func appendFrame(frame []byte, flags byte, body []byte) []byte {
+	if len(body) > 255 {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(body)))
+		frame = append(frame, flags|flagLong)
+		frame = append(frame, size[:]...)
+	} else {
+		frame = append(frame, flags, byte(len(body)))
+	}
+	return append(frame, body...)
+}
+
+func writeFrame(w io.Writer, flags byte, body []byte) error {
+	if _, err := w.Write(appendFrame(nil, flags, body)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 1, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	flags := header[0]
+
+	var size uint64
+	if flags&flagLong != 0 {
+		long := make([]byte, 8)
+		if _, err := io.ReadFull(r, long); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(long)
+	} else {
+		short := make([]byte, 1)
+		if _, err := io.ReadFull(r, short); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(short[0])
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// Send sends a single-frame message.
+func (s *pushSocket) Send(message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frame = appendFrame(s.frame[:0], 0, message)
+	_, err := s.conn.Write(s.frame)
+	return err
+}
+
+func (s *pushSocket) Close() error {
+	return s.conn.Close()
+}
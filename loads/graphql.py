@@ -0,0 +1,157 @@
+"""The GraphQL requests: a client sending the queries and the mutations of
+a test to a GraphQL endpoint, with their variables::
+
+    class TestShop(TestCase):
+
+        def test_cart(self):
+            graphql = self.create_graphql(self.server_url + '/graphql')
+            cart = graphql.query('''
+                query GetCart($user: ID!) {
+                    cart(user: $user) { items { id } }
+                }''', {'user': '{{feeder.user_id}}'})
+            graphql.mutate('queries/add_item.graphql',
+                           {'cart': cart['cart']['id'],
+                            'quantity': '{{randInt 1 5}}'})
+
+- The query is the text of the operation, or the path of a *.graphql*
+  file.
+- The variables are rendered like the templates of the requests -- see
+  :mod:`loads.templates` -- and a variable that is only a template keeps
+  the type of its value: the *quantity* above is a number.
+- The hits are aggregated by operation, like *query GetCart*, not by the
+  URL of the endpoint, which is the same for all of them.
+- A response with *errors* is a failed hit, even with a 200 status: its
+  status is *GRAPHQL_ERROR*, in the *graphql* category of the errors. The
+  call raises :class:`GraphQLError`, so the test is a failure.
+
+The calls return the *data* of the responses.
+"""
+import json
+import os
+import re
+
+from loads.templates import Context, evaluate, render_all
+
+
+ERROR_STATUS = 'GRAPHQL_ERROR'
+_EXTENSIONS = ('.graphql', '.gql')
+_OPERATION = re.compile(r'\b(query|mutation|subscription)\s*'
+                        r'([_A-Za-z]\w*)?')
+_COMMENT = re.compile(r'#[^\n]*')
+_TEMPLATE = re.compile(r'^\{\{\s*(.+?)\s*\}\}$')
+_QUERIES = {}
+
+
+class GraphQLError(AssertionError):
+    """The errors of a GraphQL response."""
+
+    def __init__(self, errors, response=None):
+        self.errors = errors
+        self.response = response
+        super(GraphQLError, self).__init__('; '.join(errors))
+
+
+def get_operation(query, operation_name=None):
+    """Returns the label of the operation of a query, like "query GetCart"
+    -- the one named *operation_name* when the query has several."""
+    query = _COMMENT.sub('', query)
+    operations = _OPERATION.findall(query)
+    for kind, name in operations:
+        if operation_name is None or name == operation_name:
+            return name and '%s %s' % (kind, name) or kind
+    if operation_name is not None:
+        return 'query %s' % operation_name
+    # the "{ ... }" shorthand
+    return 'query'
+
+
+def get_errors(response):
+    """Returns the messages of the errors of a GraphQL response, or None."""
+    try:
+        result = response.json()
+    except ValueError:
+        return None
+    if not isinstance(result, dict) or not result.get('errors'):
+        return None
+    return [isinstance(error, dict) and error.get('message') or str(error)
+            for error in result['errors']]
+
+
+def _validate(response):
+    errors = get_errors(response)
+    if errors is None:
+        return None
+    return ERROR_STATUS, '; '.join(errors)
+
+
+def read_query(query):
+    """Returns the text of a query, read from its file when it's the path
+    of a *.graphql* file."""
+    if not query.endswith(_EXTENSIONS) or not os.path.exists(query):
+        return query
+    if query not in _QUERIES:
+        with open(query) as f:
+            _QUERIES[query] = f.read()
+    return _QUERIES[query]
+
+
+def render_variables(variables, context=None):
+    """Renders the templates of the variables of a query."""
+    if isinstance(variables, basestring):
+        match = _TEMPLATE.match(variables)
+        if match is not None:
+            return evaluate(match.group(1), context)
+    if isinstance(variables, dict):
+        return dict([(key, render_variables(value, context))
+                     for key, value in variables.items()])
+    if isinstance(variables, (list, tuple)):
+        return [render_variables(value, context) for value in variables]
+    return render_all(variables, context)
+
+
+class GraphQLClient(object):
+    """Sends the operations of a test to a GraphQL endpoint, with the
+    session of the test.
+
+    :param session: the session of the test.
+    :param url: the URL of the endpoint.
+    :param headers: the headers of every request, like an Authorization.
+    """
+    def __init__(self, session, url, headers=None):
+        self.session = session
+        self.url = url
+        self.headers = headers or {}
+
+    def execute(self, query, variables=None, operation_name=None,
+                label=None, **kwargs):
+        """Sends an operation, and returns the *data* of the response.
+
+        Raises :class:`GraphQLError` when the response has errors. The
+        other options are the ones of the requests of the session.
+        """
+        query = read_query(query)
+        payload = {'query': query}
+        if variables:
+            context = Context(self.session.feeder,
+                              getattr(self.session.test, 'state', None),
+                              self.session.random)
+            payload['variables'] = render_variables(variables, context)
+        if operation_name is not None:
+            payload['operationName'] = operation_name
+        if label is None:
+            label = get_operation(query, operation_name)
+
+        headers = dict(self.headers)
+        headers.update(kwargs.pop('headers', None) or {})
+        headers['Content-Type'] = 'application/json'
+        res = self.session.post(self.url, data=json.dumps(payload),
+                                headers=headers, label=label,
+                                validate=_validate, **kwargs)
+        res.raise_for_status()
+        errors = get_errors(res)
+        if errors is not None:
+            raise GraphQLError(errors, res)
+        result = res.json()
+        return isinstance(result, dict) and result.get('data') or None
+
+    query = mutate = execute
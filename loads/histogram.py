@@ -0,0 +1,157 @@
+"""A HDR histogram of the request times.
+
+The values are recorded in microseconds with a fixed number of significant
+digits, so the histogram has a bounded size whatever the number of values,
+and two histograms can be merged without losing any precision. This is
+what makes the percentiles exact when the results of several agents or
+intervals are merged.
+
+The count, sum, min and max of the values are exact.
+"""
+import math
+
+
+def _bit_length(value):
+    # int.bit_length() is not available in Python 2.6
+    return len(bin(value)) - 2
+
+
+class Histogram(object):
+    """A HDR histogram.
+
+    :param significant_figures: the number of significant decimal digits
+                                kept for the values, from 1 to 5.
+    """
+    def __init__(self, significant_figures=3):
+        if not 1 <= significant_figures <= 5:
+            raise ValueError('Between 1 and 5 significant figures')
+
+        self.significant_figures = significant_figures
+        largest = 2 * 10 ** significant_figures
+        magnitude = int(math.ceil(math.log(largest, 2)))
+        self._half_magnitude = max(magnitude, 1) - 1
+        self._half_count = 2 ** self._half_magnitude
+        self._mask = 2 * self._half_count - 1
+
+        self.counts = {}
+        self.total_count = 0
+        self.total = 0
+        self.min = None
+        self.max = None
+
+    def _get_index(self, value):
+        bucket = (_bit_length(value | self._mask) -
+                  (self._half_magnitude + 1))
+        sub_bucket = value >> bucket
+        return (((bucket + 1) << self._half_magnitude) +
+                sub_bucket - self._half_count)
+
+    def _get_value(self, index):
+        """Returns the highest value that is counted at this index."""
+        bucket = (index >> self._half_magnitude) - 1
+        sub_bucket = (index & (self._half_count - 1)) + self._half_count
+        if bucket < 0:
+            sub_bucket -= self._half_count
+            bucket = 0
+        return ((sub_bucket + 1) << bucket) - 1
+
+    def record_value(self, value, count=1):
+        """Records a value, in microseconds."""
+        value = int(value)
+        if value < 0:
+            raise ValueError('Negative value %d' % value)
+
+        index = self._get_index(value)
+        self.counts[index] = self.counts.get(index, 0) + count
+        self.total_count += count
+        self.total += value * count
+        if self.min is None or value < self.min:
+            self.min = value
+        if self.max is None or value > self.max:
+            self.max = value
+
+    def record_corrected_value(self, value, expected_interval, count=1):
+        """Records a value, and the values that would have been recorded
+        if the requests had been sent every expected_interval, instead of
+        waiting for the previous response -- the coordinated omission.
+        """
+        self.record_value(value, count)
+        if expected_interval <= 0:
+            return
+
+        missing = value - expected_interval
+        while missing >= expected_interval:
+            self.record_value(missing, count)
+            missing -= expected_interval
+
+    def add(self, other):
+        """Merges another histogram in this one."""
+        if other.significant_figures != self.significant_figures:
+            raise ValueError('The histograms have different precisions')
+
+        for index, count in other.counts.items():
+            self.counts[index] = self.counts.get(index, 0) + count
+        self.total_count += other.total_count
+        self.total += other.total
+        for value in (other.min, other.max):
+            if value is None:
+                continue
+            if self.min is None or value < self.min:
+                self.min = value
+            if self.max is None or value > self.max:
+                self.max = value
+
+    def corrected(self, expected_interval):
+        """Returns a copy of the histogram corrected for the coordinated
+        omission -- see :meth:`record_corrected_value`."""
+        histogram = Histogram(self.significant_figures)
+        for index, count in self.counts.items():
+            value = min(self._get_value(index), self.max)
+            histogram.record_corrected_value(value, expected_interval, count)
+        return histogram
+
+    def get_mean(self):
+        if self.total_count == 0:
+            return 0
+        return float(self.total) / self.total_count
+
+    def get_value_at_percentile(self, percentile):
+        """Returns the value under which the given percentage of the values
+        are, or None if the histogram is empty."""
+        if self.total_count == 0:
+            return None
+
+        # rounded first, so 99.9% of 1000 values is 999, not 1000
+        wanted = round(percentile / 100. * self.total_count, 9)
+        wanted = int(math.ceil(wanted))
+        wanted = min(max(wanted, 1), self.total_count)
+
+        seen = 0
+        for index in sorted(self.counts):
+            seen += self.counts[index]
+            if seen >= wanted:
+                break
+
+        value = self._get_value(index)
+        return max(min(value, self.max), self.min)
+
+    def to_dict(self):
+        """Returns a JSON-serializable version of the histogram."""
+        return {'significant_figures': self.significant_figures,
+                'counts': [[index, count] for index, count
+                           in sorted(self.counts.items())],
+                'total_count': self.total_count,
+                'total': self.total,
+                'min': self.min,
+                'max': self.max}
+
+    @classmethod
+    def from_dict(cls, data):
+        histogram = cls(data['significant_figures'])
+        histogram.counts = dict([(index, count) for index, count
+                                 in data['counts']])
+        histogram.total_count = data['total_count']
+        histogram.total = data['total']
+        histogram.min = data['min']
+        histogram.max = data['max']
+        return histogram
@@ -0,0 +1,173 @@
+"""Hooks called around every HTTP request of the tests, to change the
+requests -- to sign them, for instance -- and to validate the responses,
+without changing the tests.
+
+A hooks file is a Python or a Lua script -- Lua needs `lupa
+<http://pypi.python.org/pypi/lupa>`_ -- which defines any of:
+
+- **before_request(request)**: called before the request is sent. The
+  request has a *method*, a *url*, *headers* and a *body*, that the hook
+  can change.
+- **after_response(response)**: called with the response, which has a
+  *status*, *headers*, a *body*, the *elapsed* seconds and the *request*.
+
+The hooks can call **incr(name, value)** to increment a custom counter of
+the test, **gauge(name, value)** and **trend(name, value)** to report the
+custom gauges and trends -- see :mod:`loads.custom` -- and **check(name,
+passed)** to report a check.
+"""
+import os
+
+from loads.util import total_seconds, try_import
+
+
+_HOOKS = {}
+
+
+class _Request(object):
+    """What the Python hooks get of a request."""
+    def __init__(self, request):
+        self.method = request.method
+        self.url = request.url
+        self.headers = request.headers
+        self.body = request.body
+
+
+class _Response(object):
+    """What the Python hooks get of a response."""
+    def __init__(self, response, request):
+        self.status = response.status_code
+        self.headers = response.headers
+        self.body = response.content
+        self.elapsed = total_seconds(response.elapsed)
+        self.request = request
+
+
+def _update(request, method, url, headers, body):
+    request.method = method
+    request.url = url
+    headers = dict(headers.items())
+    request.headers.clear()
+    request.headers.update(headers)
+    if body != request.body:
+        request.body = body
+        request.headers['Content-Length'] = str(len(body or ''))
+
+
+class Hooks(object):
+    """Calls the hooks of a file, for the requests of a test."""
+
+    def __init__(self, path):
+        self.path = path
+
+    def _report(self, test):
+        """Returns the incr, gauge, trend and check functions of the hooks,
+        for *test*, by name."""
+        def incr(name, value=1):
+            test.incr_counter(name, value=int(value))
+
+        def gauge(name, value):
+            if test._test_result is not None:
+                test.set_gauge(name, float(value))
+
+        def trend(name, value):
+            if test._test_result is not None:
+                test.add_trend(name, float(value))
+
+        def check(name, passed):
+            if test._test_result is not None:
+                test._test_result.add_check(name, bool(passed))
+        return {'incr': incr, 'gauge': gauge, 'trend': trend, 'check': check}
+
+    def before_request(self, request, test):
+        """Calls the before_request hook with the prepared *request*, and
+        updates it."""
+        raise NotImplementedError()
+
+    def after_response(self, response, request, test):
+        """Calls the after_response hook with the *response* -- and the
+        *request* it answers."""
+        raise NotImplementedError()
+
+
+class PythonHooks(Hooks):
+
+    def __init__(self, path):
+        super(PythonHooks, self).__init__(path)
+        self.namespace = {}
+        execfile(path, self.namespace)
+
+    def _call(self, name, test, *args):
+        hook = self.namespace.get(name)
+        if hook is None:
+            return
+        self.namespace.update(self._report(test))
+        hook(*args)
+
+    def before_request(self, request, test):
+        if 'before_request' not in self.namespace:
+            return
+        wrapped = _Request(request)
+        self._call('before_request', test, wrapped)
+        _update(request, wrapped.method, wrapped.url, wrapped.headers,
+                wrapped.body)
+
+    def after_response(self, response, request, test):
+        self._call('after_response', test, _Response(response, request))
+
+
+class LuaHooks(Hooks):
+
+    def __init__(self, path):
+        super(LuaHooks, self).__init__(path)
+        try_import('lupa')
+        from lupa import LuaRuntime
+
+        self.lua = LuaRuntime(unpack_returned_tuples=True)
+        with open(path) as f:
+            self.lua.execute(f.read())
+        self.hooks = self.lua.globals()
+
+    def _table(self, mapping):
+        return self.lua.table_from(dict(mapping))
+
+    def _request(self, request):
+        return self._table({'method': request.method, 'url': request.url,
+                            'headers': self._table(request.headers),
+                            'body': request.body})
+
+    def _call(self, name, test, *args):
+        hook = self.hooks[name]
+        if hook is None:
+            return
+        for key, function in self._report(test).items():
+            self.hooks[key] = function
+        hook(*args)
+
+    def before_request(self, request, test):
+        if self.hooks.before_request is None:
+            return
+        table = self._request(request)
+        self._call('before_request', test, table)
+        _update(request, table.method, table.url, table.headers, table.body)
+
+    def after_response(self, response, request, test):
+        if self.hooks.after_response is None:
+            return
+        table = self._table({'status': response.status_code,
+                             'headers': self._table(response.headers),
+                             'body': response.content,
+                             'elapsed': total_seconds(response.elapsed),
+                             'request': self._request(request)})
+        self._call('after_response', test, table)
+
+
+def get_hooks(path):
+    """Returns the hooks of a file, loaded once per process."""
+    path = os.path.abspath(path)
+    if path not in _HOOKS:
+        if path.endswith('.lua'):
+            _HOOKS[path] = LuaHooks(path)
+        else:
+            _HOOKS[path] = PythonHooks(path)
+    return _HOOKS[path]
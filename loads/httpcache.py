@@ -0,0 +1,218 @@
+"""The HTTP cache of the virtual users, so they load the servers like the
+browsers do instead of fetching everything every time::
+
+    $ loads-runner example.TestWebSite.test_es -u 100 --http-cache
+
+With *--http-cache*, every user keeps the responses of its GET requests
+the way a private cache does:
+
+- A fresh response -- younger than the *max-age* of its *Cache-Control*,
+  its *Expires* date or a tenth of its age since *Last-Modified* -- is
+  served from the cache, without any request.
+- A stale one with an *ETag* or a *Last-Modified* date is revalidated:
+  the request is sent with *If-None-Match* and *If-Modified-Since*, and a
+  *304 Not Modified* gives the test the cached response.
+- *no-store* responses are never kept, *no-cache* and *max-age=0* ones
+  are always revalidated, and the responses varying on a header are only
+  served to the requests with the same value.
+
+Every GET is counted in *http-cache-hits*, *http-cache-revalidated* or
+*http-cache-misses*, and the summary gives the hit ratios. The responses
+served from the cache are not hits: only the requests sent are.
+"""
+import copy
+import email.utils
+import time
+
+
+# the responses a user keeps, the least recently used being dropped
+MAX_ENTRIES = 1000
+
+# the fraction of the age of a response without an expiration that is
+# fresh, like the browsers do
+HEURISTIC_FRACTION = .1
+
+_CACHEABLE = (200, 203)
+
+
+def parse_cache_control(value):
+    """Returns the directives of a *Cache-Control* header, by lowercase
+    name -- None for the ones without a value."""
+    directives = {}
+    for directive in (value or '').split(','):
+        name, _, argument = directive.partition('=')
+        name = name.strip().lower()
+        if name:
+            directives[name] = argument.strip().strip('"') or None
+    return directives
+
+
+def _parse_date(value):
+    if not value:
+        return None
+    parsed = email.utils.parsedate_tz(value)
+    if parsed is None:
+        return None
+    return email.utils.mktime_tz(parsed)
+
+
+def _get_int(value):
+    try:
+        return max(int(value), 0)
+    except (TypeError, ValueError):
+        return None
+
+
+def get_freshness(headers, now):
+    """Returns the seconds a response stays fresh from :param now:, or 0
+    when it's stale already."""
+    directives = parse_cache_control(headers.get('Cache-Control'))
+    if 'no-cache' in directives:
+        return 0
+
+    lifetime = _get_int(directives.get('max-age'))
+    date = _parse_date(headers.get('Date')) or now
+    if lifetime is None:
+        expires = _parse_date(headers.get('Expires'))
+        last_modified = _parse_date(headers.get('Last-Modified'))
+        if expires is not None:
+            lifetime = expires - date
+        elif last_modified is not None:
+            lifetime = (date - last_modified) * HEURISTIC_FRACTION
+        else:
+            lifetime = 0
+
+    age = max(_get_int(headers.get('Age')) or 0, now - date, 0)
+    return max(lifetime - age, 0)
+
+
+def _copy(response):
+    # the tests get their own response, telling it was cached
+    response = copy.copy(response)
+    response.from_cache = True
+    return response
+
+
+class _Entry(object):
+
+    def __init__(self, response, vary, expires):
+        self.response = response
+        self.vary = vary
+        self.expires = expires
+        self.used = 0
+
+
+class HTTPCache(object):
+    """The cache of a virtual user.
+
+    :param max_entries: the responses kept.
+    :param clock: returns the current time.
+    """
+    def __init__(self, max_entries=MAX_ENTRIES, clock=time.time):
+        self.max_entries = max_entries
+        self.clock = clock
+        self._entries = {}
+
+    def __len__(self):
+        return len(self._entries)
+
+    def _get_entry(self, request):
+        if request.method != 'GET':
+            return None
+        directives = parse_cache_control(request.headers.get('Cache-Control'))
+        if 'no-store' in directives or 'no-cache' in directives:
+            return None
+        entry = self._entries.get(request.url)
+        if entry is None:
+            return None
+        for name, value in entry.vary:
+            if request.headers.get(name) != value:
+                return None
+        return entry
+
+    def lookup(self, request):
+        """Returns the cached response of a fresh entry, or None. The
+        request of a stale one gets its validators."""
+        entry = self._get_entry(request)
+        if entry is None:
+            return None
+        entry.used = self.clock()
+        if entry.expires > entry.used:
+            return _copy(entry.response)
+
+        headers = entry.response.headers
+        if ('ETag' in headers and
+                'If-None-Match' not in request.headers):
+            request.headers['If-None-Match'] = headers['ETag']
+        if ('Last-Modified' in headers and
+                'If-Modified-Since' not in request.headers):
+            request.headers['If-Modified-Since'] = headers['Last-Modified']
+        return None
+
+    def store(self, request, response):
+        """Keeps a response. Returns the response of the test -- the cached
+        one when a 304 revalidated it -- and whether it was revalidated."""
+        if request.method != 'GET':
+            return response, False
+        now = self.clock()
+        entry = self._get_entry(request)
+        if response.status_code == 304 and entry is not None:
+            # the 304 updates the headers of the cached response
+            for name in ('Cache-Control', 'Date', 'Expires', 'ETag', 'Age',
+                         'Last-Modified'):
+                if name in response.headers:
+                    entry.response.headers[name] = response.headers[name]
+                elif name == 'Age':
+                    entry.response.headers.pop(name, None)
+            entry.expires = now + get_freshness(entry.response.headers, now)
+            entry.used = now
+            return _copy(entry.response), True
+
+        self._entries.pop(request.url, None)
+        if not self._is_cacheable(request, response):
+            return response, False
+
+        vary = tuple([(name.strip(), request.headers.get(name.strip()))
+                      for name in response.headers.get('Vary', '').split(',')
+                      if name.strip()])
+        if len(self._entries) >= self.max_entries:
+            oldest = min(self._entries.items(),
+                         key=lambda item: item[1].used)[0]
+            del self._entries[oldest]
+        entry = _Entry(response, vary,
+                       now + get_freshness(response.headers, now))
+        entry.used = now
+        self._entries[request.url] = entry
+        return response, False
+
+    def _is_cacheable(self, request, response):
+        if response.status_code not in _CACHEABLE:
+            return False
+        for headers in (request.headers, response.headers):
+            if 'no-store' in parse_cache_control(headers.get('Cache-Control')):
+                return False
+        if response.headers.get('Vary', '').strip() == '*':
+            return False
+        # a response without any freshness or validator is never reused
+        return ('ETag' in response.headers or
+                'Last-Modified' in response.headers or
+                get_freshness(response.headers, self.clock()) > 0)
+
+
+def get_http_cache(config):
+    """Returns the cache of a new virtual user, or None without
+    *--http-cache*."""
+    if not config.get('http_cache'):
+        return None
+    return HTTPCache()
+
+
+def get_hit_ratios(counters):
+    """Returns the ratios of the GET requests served from the cache, and of
+    the ones revalidated, or None when none were counted."""
+    hits = counters.get('http-cache-hits', 0)
+    revalidated = counters.get('http-cache-revalidated', 0)
+    total = hits + revalidated + counters.get('http-cache-misses', 0)
+    if not total:
+        return None
+    return hits / float(total), revalidated / float(total)
@@ -0,0 +1,685 @@
+""" Generates loads tests from the captures and the plans of other tools.
+
+    $ loads-import har capture.har --skip-static -o test_capture.py
+    $ loads-import jmx plan.jmx -o test_plan.py
+
+A HAR capture -- exported from the network panel of a browser -- becomes a
+test with a step per page of the capture, or per burst of requests when it
+has no pages. The gaps between the steps are kept as think times, and the
+values of the requests that look dynamic -- that came in a previous
+response, or that look like ids or tokens -- are flagged, to be
+parameterized.
+
+A JMeter plan becomes a test with a method per thread group -- see
+:class:`JMXConverter`.
+"""
+import argparse
+import os
+import re
+import sys
+import urlparse
+from xml.etree import ElementTree
+
+from loads.util import json, parse_timestamp
+
+
+# the tools sending the requests set these headers themselves
+_SKIPPED_HEADERS = ('host', 'content-length', 'connection', 'cookie',
+                    'accept-encoding', 'keep-alive', 'transfer-encoding')
+# the headers whose values are worth flagging
+_FLAGGED_HEADERS = ('authorization', 'x-csrf-token', 'x-csrftoken',
+                    'x-xsrf-token', 'x-auth-token', 'x-api-key')
+
+_STATIC_TYPES = re.compile(r'^(image/|font/|text/css|application/font|'
+                           r'application/(x-)?javascript|text/javascript)')
+_STATIC_EXTENSIONS = ('.js', '.css', '.png', '.jpg', '.jpeg', '.gif',
+                      '.svg', '.ico', '.woff', '.woff2', '.ttf', '.eot',
+                      '.map', '.webp')
+
+_DYNAMIC = (re.compile(r'^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-'
+                       r'[0-9a-f]{12}$', re.I),
+            re.compile(r'^[0-9a-f]{16,}$', re.I),
+            re.compile(r'^(?=.*\d)(?=.*[a-zA-Z])[\w\-.~+/]{20,}={0,2}$'))
+# the values shorter than this are not looked for in the responses
+_MIN_CORRELATED = 6
+
+DEFAULT_THINK_TIME = 1.
+
+
+def is_static(entry):
+    """Tells whether the entry fetches a static asset -- an image, a style
+    sheet, a script or a font."""
+    mime_type = entry.get('response', {}).get('content', {}).get('mimeType')
+    if mime_type and _STATIC_TYPES.match(mime_type):
+        return True
+    path = urlparse.urlsplit(entry['request']['url']).path.lower()
+    return path.endswith(_STATIC_EXTENSIONS)
+
+
+class Transaction(object):
+    """The requests of a step of the capture, and the think time before
+    them."""
+
+    def __init__(self, name, entries, think_time=0.):
+        self.name = name
+        self.entries = entries
+        self.think_time = think_time
+
+
+def _end(entry):
+    return entry['started'] + max(entry.get('time') or 0, 0) / 1000.
+
+
+def get_transactions(har, skip_static=False,
+                     min_think_time=DEFAULT_THINK_TIME):
+    """Returns the transactions of a HAR capture: the requests of every page,
+    or of every burst of requests when the capture has no pages. The gaps of
+    more than *min_think_time* seconds become think times."""
+    log = har['log']
+    entries = []
+    for entry in log.get('entries', []):
+        if skip_static and is_static(entry):
+            continue
+        entry = dict(entry)
+        entry['started'] = parse_timestamp(entry['startedDateTime'])
+        entries.append(entry)
+    entries.sort(key=lambda entry: entry['started'])
+
+    titles = dict([(page['id'], page.get('title') or page['id'])
+                   for page in log.get('pages', [])])
+    transactions, end = [], None
+
+    for entry in entries:
+        gap = end is not None and entry['started'] - end or 0.
+        if titles:
+            name = titles.get(entry.get('pageref'), entry.get('pageref'))
+            new = not transactions or transactions[-1].name != name
+        else:
+            name = 'Step %d' % (len(transactions) + 1)
+            new = not transactions or gap >= min_think_time
+
+        if new:
+            think_time = gap >= min_think_time and gap or 0.
+            transactions.append(Transaction(name, [], round(think_time, 1)))
+        transactions[-1].entries.append(entry)
+        end = max(end, _end(entry))
+
+    return transactions
+
+
+def _request_values(request):
+    """Yields the (where, name, value) of the values sent by a request."""
+    for param in request.get('queryString', []):
+        yield 'query', param['name'], param.get('value', '')
+
+    for header in request.get('headers', []):
+        if header['name'].lower() in _FLAGGED_HEADERS:
+            value = header.get('value', '')
+            if ' ' in value:
+                # Bearer xxx
+                value = value.split(' ', 1)[1]
+            yield 'header', header['name'], value
+
+    post = request.get('postData', {})
+    for param in post.get('params', []):
+        yield 'form', param['name'], param.get('value', '')
+    if 'json' in post.get('mimeType', ''):
+        try:
+            body = json.loads(post.get('text') or '')
+        except ValueError:
+            body = None
+        if isinstance(body, dict):
+            for name, value in body.items():
+                if isinstance(value, basestring):
+                    yield 'body', name, value
+
+
+def _response_text(entry):
+    response = entry.get('response', {})
+    parts = [header.get('value', '') for header in response.get('headers', [])]
+    parts.append(response.get('content', {}).get('text') or '')
+    return '\n'.join(parts)
+
+
+def find_dynamic_values(entries):
+    """Returns the values of the requests to parameterize, per entry: the
+    ones that came in the response of a previous entry, and the ones that
+    look like ids or tokens."""
+    flags, responses = {}, []
+
+    for index, entry in enumerate(entries):
+        request = entry['request']
+        for where, name, value in _request_values(request):
+            if not value:
+                continue
+            source = None
+            if len(value) >= _MIN_CORRELATED:
+                for previous, text in responses:
+                    if value in text:
+                        source = previous
+                        break
+            if source is not None:
+                flags.setdefault(index, []).append(
+                    '%s %s: from the response of %s %s' % (
+                        where, name, source['request']['method'],
+                        source['request']['url']))
+            elif [pattern for pattern in _DYNAMIC if pattern.match(value)]:
+                flags.setdefault(index, []).append(
+                    '%s %s: looks dynamic' % (where, name))
+
+        responses.append((entry, _response_text(entry)))
+
+    return flags
+
+
+def _literal(value):
+    if isinstance(value, unicode):
+        try:
+            value = value.encode('ascii')
+        except UnicodeEncodeError:
+            pass
+    return repr(value)
+
+
+def _identifier(name):
+    name = re.sub(r'\W+', '_', name).strip('_').lower()
+    if not name or name[0].isdigit():
+        name = 'capture_' + name
+    return name
+
+
+def _dict_literal(items, indent):
+    """Returns the literal of a dict of (key literal, value expression)."""
+    return '{%s}' % (',\n' + ' ' * indent).join(
+        ['%s: %s' % (key, value) for key, value in items])
+
+
+def _write_call(method, args, lines, indent=8):
+    """Writes the call of the session sending a request, with the
+    expressions of its arguments -- the URL first."""
+    margin = ' ' * indent
+    args = list(args)
+    if method.lower() in ('get', 'post', 'put', 'patch', 'delete', 'head',
+                          'options'):
+        call = 'self.session.%s(' % method.lower()
+    else:
+        call = 'self.session.request('
+        args.insert(0, _literal(method))
+    if len(args) == 1:
+        lines.append('%s%s%s)' % (margin, call, args[0]))
+    else:
+        lines.append(margin + call)
+        lines.append('%s    %s)' % (margin,
+                                    (',\n%s    ' % margin).join(args)))
+
+
+def _write_request(entry, flags, lines):
+    request = entry['request']
+    for flag in flags:
+        lines.append('        # parameterize the %s' % flag)
+
+    # the query string stays in the URL, as it was sent
+    args = [_literal(request['url'])]
+    headers = [(_literal(header['name']), _literal(header.get('value', '')))
+               for header in request.get('headers', [])
+               if not header['name'].startswith(':') and
+               header['name'].lower() not in _SKIPPED_HEADERS]
+    if headers:
+        args.append('headers=%s' % _dict_literal(headers, 21))
+    text = request.get('postData', {}).get('text')
+    if text:
+        args.append('data=%s' % _literal(text))
+    _write_call(request['method'], args, lines)
+
+
+def write_test(transactions, name):
+    """Returns the source of a test module calling the requests of the
+    transactions."""
+    identifier = _identifier(name)
+    lines = ['"""Generated by loads-import from %s."""' % name,
+             'import gevent', '',
+             'from loads.case import TestCase', '', '',
+             'class Test%s(TestCase):' % ''.join(
+                 [part.capitalize() for part in identifier.split('_')]),
+             '',
+             '    def test_%s(self):' % identifier]
+
+    entries = [entry for transaction in transactions
+               for entry in transaction.entries]
+    flags = find_dynamic_values(entries)
+    index = 0
+
+    for transaction in transactions:
+        if transaction.think_time:
+            lines.append('        gevent.sleep(%s)' % transaction.think_time)
+        if transaction is not transactions[0]:
+            lines.append('')
+        lines.append('        # %s' % transaction.name.encode('ascii',
+                                                            'replace'))
+        for entry in transaction.entries:
+            _write_request(entry, flags.get(index, []), lines)
+            index += 1
+
+    if not entries:
+        lines.append('        pass')
+    return '\n'.join(lines) + '\n'
+
+
+def import_har(path, skip_static=False, min_think_time=DEFAULT_THINK_TIME):
+    """Returns the source of the test generated from a HAR file."""
+    with open(path) as f:
+        har = json.load(f)
+    transactions = get_transactions(har, skip_static=skip_static,
+                                    min_think_time=min_think_time)
+    name = os.path.splitext(os.path.basename(path))[0]
+    return write_test(transactions, name)
+
+
+#
+# JMeter
+#
+_PROPERTIES = ('stringProp', 'boolProp', 'intProp', 'longProp')
+_THREAD_GROUPS = ('ThreadGroup', 'SetupThreadGroup', 'PostThreadGroup')
+_SAMPLERS = ('HTTPSamplerProxy', 'HTTPSampler')
+_CONTROLLERS = ('GenericController', 'TransactionController')
+# what loads does by itself
+_IGNORED = ('CookieManager', 'CacheManager', 'DNSCacheManager',
+            'ResultCollector', 'Summariser', 'BackendListener')
+_QUERY_METHODS = ('GET', 'HEAD', 'DELETE', 'OPTIONS')
+_FUNCTION = re.compile(r'\$\{__\w+')
+
+
+def _properties(element):
+    """Returns the simple properties of a JMeter element, by name."""
+    return dict([(child.get('name'), child.text or '')
+                 for child in element if child.tag in _PROPERTIES])
+
+
+def _find(element, tag, name):
+    for child in element:
+        if child.tag == tag and child.get('name') == name:
+            return child
+    return None
+
+
+def _arguments(element, name=None):
+    """Returns the (name, value) of the arguments of a JMeter element -- or
+    of its *name* property."""
+    if name is not None:
+        element = _find(element, 'elementProp', name)
+    if element is not None:
+        element = _find(element, 'collectionProp', 'Arguments.arguments')
+    if element is None:
+        return []
+    return [(props.get('Argument.name', ''), props.get('Argument.value', ''))
+            for props in [_properties(argument) for argument in element]]
+
+
+def _children(tree):
+    """Yields the enabled (element, children) of a JMeter hash tree."""
+    if tree is None:
+        return
+    elements = list(tree)
+    for index, element in enumerate(elements):
+        if element.tag == 'hashTree' or element.get('enabled') == 'false':
+            continue
+        children = None
+        if index + 1 < len(elements) and \
+                elements[index + 1].tag == 'hashTree':
+            children = elements[index + 1]
+        yield element, children
+
+
+def _is_true(value):
+    return (value or '').strip().lower() == 'true'
+
+
+def _int(value, default=0):
+    try:
+        return int(value)
+    except (TypeError, ValueError):
+        return default
+
+
+class _Scope(object):
+    """The configuration elements applying to the samplers of a part of a
+    test plan."""
+
+    def __init__(self, parent=None):
+        self.headers = parent and list(parent.headers) or []
+        self.defaults = parent and dict(parent.defaults) or {}
+        self.timers = parent and list(parent.timers) or []
+
+
+class ThreadGroup(object):
+    """A thread group of a test plan, and the lines of its test."""
+
+    def __init__(self, name, users=1, ramp_up=0, loops=1, duration=None):
+        self.name = name
+        self.users = users
+        self.ramp_up = ramp_up
+        self.loops = loops
+        self.duration = duration
+        self.feeds = []
+        self.lines = []
+        self.uses_variables = False
+
+    def describe(self):
+        description = '%d users' % self.users
+        if self.ramp_up:
+            description += ', ramped up in %ds' % self.ramp_up
+        if self.duration:
+            description += ', during %ds' % self.duration
+        elif self.loops > 0:
+            description += ', %d loops' % self.loops
+        return description
+
+    def get_options(self):
+        """Returns the options of loads-runner running the thread group."""
+        if self.duration and self.ramp_up:
+            return '--stages %ds:%d,%ds:%d' % (
+                self.ramp_up, self.users,
+                max(self.duration - self.ramp_up, 1), self.users)
+        options = '-u %d' % self.users
+        if self.duration:
+            options += ' -d %d' % self.duration
+        elif self.loops > 0:
+            options += ' --hits %d' % self.loops
+        return options
+
+
+class JMXConverter(object):
+    """Converts a JMeter test plan: every thread group becomes a test
+    method, sending the requests of its HTTP samplers with the headers of
+    their header managers, the defaults of the HTTP Request Defaults and the
+    rows of the CSV data sets. The ${variables} are expanded with the user
+    defined variables and the rows.
+
+    What could not be translated is listed in *notes*.
+    """
+
+    def __init__(self, root):
+        self.root = root
+        self.name = 'plan'
+        self.variables = {}
+        self.thread_groups = []
+        self.notes = []
+
+    def _note(self, element, what=None):
+        if what is None:
+            what = 'is not translated'
+        self.notes.append('%s %r %s' % (element.tag,
+                                        element.get('testname', ''), what))
+
+    def convert(self):
+        for plan, children in _children(self.root.find('hashTree')):
+            if plan.tag != 'TestPlan':
+                continue
+            self.name = plan.get('testname') or self.name
+            self.variables.update(_arguments(
+                plan, 'TestPlan.user_defined_variables'))
+            self._walk(children, _Scope(), None, 8)
+        return self
+
+    def _configure(self, tree, scope, group):
+        """Applies the configuration elements of a hash tree to a scope."""
+        for element, children in _children(tree):
+            tag = element.tag
+            props = _properties(element)
+            if tag == 'HeaderManager':
+                headers = _find(element, 'collectionProp',
+                                'HeaderManager.headers')
+                if headers is None:
+                    headers = []
+                for header in headers:
+                    header = _properties(header)
+                    scope.headers.append((header.get('Header.name', ''),
+                                          header.get('Header.value', '')))
+            elif tag == 'ConfigTestElement' and \
+                    element.get('guiclass') == 'HttpDefaultsGui':
+                for name in ('protocol', 'domain', 'port', 'path'):
+                    if props.get('HTTPSampler.' + name):
+                        scope.defaults[name] = props['HTTPSampler.' + name]
+            elif tag == 'Arguments':
+                self.variables.update(_arguments(element))
+            elif tag == 'CSVDataSet':
+                self._add_feed(element, props, group)
+            elif tag == 'ConstantTimer':
+                scope.timers.append(_int(props.get('ConstantTimer.delay')) /
+                                    1000.)
+
+    def _add_feed(self, element, props, group):
+        if group is None:
+            self._note(element, 'is outside of a thread group')
+            return
+        filename = props.get('filename', '')
+        if props.get('variableNames'):
+            self._note(element, 'needs a header line in %s: %s' % (
+                filename, props['variableNames']))
+        if props.get('delimiter', ',') not in (',', ''):
+            self._note(element, 'needs a comma delimiter')
+        strategy = 'round-robin'
+        if not _is_true(props.get('recycle', 'true')):
+            strategy = 'unique'
+        group.feeds.append((filename, strategy))
+        group.uses_variables = True
+
+    def _walk(self, tree, scope, group, indent):
+        scope = _Scope(scope)
+        self._configure(tree, scope, group)
+
+        for element, children in _children(tree):
+            tag = element.tag
+            name = element.get('testname', '')
+            if tag in ('HeaderManager', 'Arguments', 'CSVDataSet',
+                       'ConstantTimer', 'ConfigTestElement') or \
+                    tag in _IGNORED:
+                if tag == 'ConfigTestElement' and \
+                        element.get('guiclass') != 'HttpDefaultsGui':
+                    self._note(element)
+                continue
+            elif tag in _THREAD_GROUPS:
+                if group is not None:
+                    self._note(element, 'is nested')
+                    continue
+                self._add_thread_group(element, children, scope)
+            elif group is None:
+                self._note(element, 'is outside of a thread group')
+            elif tag in _SAMPLERS:
+                self._add_sampler(element, children, scope, group, indent)
+            elif tag in _CONTROLLERS:
+                group.lines.append('%s# %s' % (' ' * indent, name))
+                self._walk(children, scope, group, indent)
+            elif tag == 'LoopController':
+                loops = _int(_properties(element).get(
+                    'LoopController.loops'), 1)
+                if loops < 0:
+                    self._note(element, 'loops forever: it runs once')
+                    loops = 1
+                group.lines.append('%sfor _ in range(%d):  # %s' % (
+                    ' ' * indent, loops, name))
+                self._walk(children, scope, group, indent + 4)
+            elif tag.endswith('Controller'):
+                self._note(element, 'is not translated: its samplers always '
+                                    'run')
+                self._walk(children, scope, group, indent)
+            else:
+                self._note(element)
+
+    def _add_thread_group(self, element, children, scope):
+        props = _properties(element)
+        loops = 1
+        controller = _find(element, 'elementProp',
+                           'ThreadGroup.main_controller')
+        if controller is not None:
+            loops = _int(_properties(controller).get('LoopController.loops'),
+                         1)
+        duration = None
+        if _is_true(props.get('ThreadGroup.scheduler')):
+            duration = _int(props.get('ThreadGroup.duration')) or None
+
+        group = ThreadGroup(element.get('testname') or 'Thread Group',
+                            _int(props.get('ThreadGroup.num_threads'), 1),
+                            _int(props.get('ThreadGroup.ramp_time')),
+                            loops, duration)
+        if loops < 0 and duration is None:
+            self._note(element, 'loops forever: give a duration to '
+                                'loads-runner')
+        if group.ramp_up and duration is None:
+            self._note(element, 'ramps up without a duration: the users '
+                                'all start at once')
+        self.thread_groups.append(group)
+        self._walk(children, scope, group, 8)
+
+    def _expression(self, value, element, group):
+        """Returns the expression of a value, expanding its variables."""
+        if '${' not in value:
+            return _literal(value)
+        if _FUNCTION.search(value):
+            self._note(element, 'calls a function: %s' % value)
+        group.uses_variables = True
+        return '_expand(%s, variables)' % _literal(value)
+
+    def _add_sampler(self, element, children, scope, group, indent):
+        scope = _Scope(scope)
+        self._configure(children, scope, group)
+        for child, _ in _children(children):
+            if child.tag not in ('HeaderManager', 'ConstantTimer',
+                                 'ConfigTestElement', 'Arguments',
+                                 'CSVDataSet') and child.tag not in _IGNORED:
+                self._note(child)
+
+        props = _properties(element)
+
+        def get(name, default=''):
+            return props.get('HTTPSampler.' + name) or \
+                scope.defaults.get(name, default)
+
+        path = get('path', '/')
+        if '://' not in path:
+            port = get('port')
+            path = '%s://%s%s%s' % (get('protocol', 'http') or 'http',
+                                    get('domain'), port and ':' + port or '',
+                                    path)
+        method = get('method', 'GET').upper()
+        margin = ' ' * indent
+        for delay in scope.timers:
+            group.lines.append('%sgevent.sleep(%s)' % (margin, delay))
+        group.lines.append('%s# %s' % (margin, element.get('testname', '')))
+
+        args = [self._expression(path, element, group)]
+        if scope.headers:
+            args.append('headers=%s' % _dict_literal(
+                [(_literal(name), self._expression(value, element, group))
+                 for name, value in scope.headers], indent + 13))
+        arguments = _arguments(element, 'HTTPsampler.Arguments')
+        if arguments and _is_true(props.get('HTTPSampler.postBodyRaw')):
+            args.append('data=%s' % self._expression(arguments[0][1],
+                                                     element, group))
+        elif arguments:
+            keyword = method in _QUERY_METHODS and 'params' or 'data'
+            args.append('%s=%s' % (keyword, _dict_literal(
+                [(self._expression(name, element, group),
+                  self._expression(value, element, group))
+                 for name, value in arguments],
+                indent + 5 + len(keyword) + 1)))
+        _write_call(method, args, group.lines, indent)
+
+    def write_test(self, module=None):
+        """Returns the source of the test module of the plan."""
+        identifier = _identifier(self.name)
+        klass = 'Test%s' % ''.join([part.capitalize()
+                                    for part in identifier.split('_')])
+        module = module or 'test_' + identifier
+
+        lines = ['"""Generated by loads-import from the %s JMeter plan.' %
+                 self.name.encode('ascii', 'replace')]
+        if self.notes:
+            lines.extend(['', 'Not translated:', ''])
+            lines.extend(['- %s' % note for note in self.notes])
+        lines.extend(['"""', 'from string import Template', '',
+                      'import gevent', '', 'from loads.case import TestCase',
+                      '', '', 'VARIABLES = %s' % _dict_literal(
+                          [(_literal(name), _literal(value))
+                           for name, value in sorted(self.variables.items())],
+                          13), '', '',
+                      'def _expand(value, variables):',
+                      '    return Template(value).safe_substitute(variables)',
+                      '', '', 'class %s(TestCase):' % klass])
+
+        names = set()
+        for group in self.thread_groups:
+            name = 'test_' + _identifier(group.name)
+            while name in names:
+                name += '_'
+            names.add(name)
+            lines.extend(['', '    def %s(self):' % name,
+                          '        """%s: %s.' % (
+                              group.name.encode('ascii', 'replace'),
+                              group.describe()), '',
+                          '        loads-runner %s.%s.%s %s' % (
+                              module, klass, name, group.get_options()),
+                          '        """'])
+            if group.uses_variables:
+                lines.append('        variables = dict(VARIABLES)')
+            for filename, strategy in group.feeds:
+                lines.append('        variables.update(self.feed(%s, %s))' %
+                             (_literal(filename), _literal(strategy)))
+            lines.extend(group.lines or ['        pass'])
+
+        if not self.thread_groups:
+            lines.extend(['', '    pass'])
+        return '\n'.join(lines) + '\n'
+
+
+def import_jmx(path, module=None):
+    """Returns the source of the test generated from a JMeter plan, and what
+    could not be translated."""
+    converter = JMXConverter(ElementTree.parse(path).getroot()).convert()
+    return converter.write_test(module), converter.notes
+
+
+def main(args=sys.argv[1:]):
+    parser = argparse.ArgumentParser(description='Generates loads tests '
+                                                 'from the captures and the '
+                                                 'plans of other tools.')
+    subparsers = parser.add_subparsers(dest='format')
+
+    har = subparsers.add_parser('har', help='Imports a HAR capture')
+    har.add_argument('path', help='The HAR file')
+    har.add_argument('-o', '--output', default=None,
+                     help='The Python file to write. Defaults to stdout.')
+    har.add_argument('--skip-static', action='store_true', default=False,
+                     help='Skip the images, style sheets, scripts and fonts')
+    har.add_argument('--min-think-time', type=float,
+                     default=DEFAULT_THINK_TIME,
+                     help='The gaps between the requests, in seconds, '
+                          'that become think times')
+
+    jmx = subparsers.add_parser('jmx', help='Imports a JMeter test plan')
+    jmx.add_argument('path', help='The JMX file')
+    jmx.add_argument('-o', '--output', default=None,
+                     help='The Python file to write. Defaults to stdout.')
+    args = parser.parse_args(args)
+
+    if args.format == 'har':
+        source = import_har(args.path, skip_static=args.skip_static,
+                            min_think_time=args.min_think_time)
+    else:
+        module = None
+        if args.output is not None:
+            module = os.path.splitext(os.path.basename(args.output))[0]
+        source, notes = import_jmx(args.path, module)
+        for note in notes:
+            sys.stderr.write('Not translated: %s\n' % note)
+
+    if args.output is None:
+        sys.stdout.write(source)
+    else:
+        with open(args.output, 'w') as f:
+            f.write(source)
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
@@ -0,0 +1,77 @@
+""" Launches agents in the cloud, and keeps them for several runs.
+
+    $ loads-launch --provider aws --agents 10 --broker tcp://10.0.0.1:7780 \\
+        --provisioner-aws-ami ami-12345678
+
+The agents are terminated when you hit Ctrl-C. With --detach, they stay up,
+and you can terminate them later with the id printed at launch:
+
+    $ loads-launch --provider aws --terminate 4f5c1a2b
+"""
+import argparse
+import sys
+import time
+
+from loads.main import add_options
+from loads.provisioners import create_provisioner, provisioner_list
+from loads.provisioners.base import ProvisionError
+from loads.transport.util import DEFAULT_FRONTEND
+from loads.util import logger, set_logger
+
+
+def main(args=sys.argv[1:]):
+    providers = [provisioner.name for provisioner in provisioner_list()]
+    providers.sort()
+
+    parser = argparse.ArgumentParser(description='Launches loads agents, '
+                                                 'and terminates them '
+                                                 'afterwards.')
+    parser.add_argument('--provider', choices=providers, required=True,
+                        help='Where to launch the agents')
+    parser.add_argument('-a', '--agents', type=int, default=1,
+                        help='Number of agents to launch')
+    parser.add_argument('-b', '--broker', default=DEFAULT_FRONTEND,
+                        help='Broker endpoint')
+    parser.add_argument('--provision-timeout', type=float, default=None,
+                        help='Seconds to wait for the agents to register')
+    parser.add_argument('--detach', action='store_true', default=False,
+                        help='Leave the agents up, and exit')
+    parser.add_argument('--terminate', metavar='ID', default=None,
+                        help='Terminates the agents of a previous launch')
+    add_options(provisioner_list(), parser,
+                fmt='--provisioner-{name}-{option}')
+
+    args = dict(parser.parse_args(args)._get_kwargs())
+    args['provision_id'] = args['terminate']
+    set_logger()
+
+    try:
+        provisioner = create_provisioner(args['provider'], args)
+        if args['terminate'] is not None:
+            provisioner.stop()
+            return 0
+        provisioner.start()
+    except ProvisionError, e:
+        logger.error(str(e))
+        return 1
+
+    logger.info('%d agent(s) launched, with the id %s' % (args['agents'],
+                                                          provisioner.id))
+    if args['detach']:
+        logger.info('Run "loads-launch --provider %s --terminate %s" to '
+                    'terminate them' % (args['provider'], provisioner.id))
+        return 0
+
+    logger.info('Hit Ctrl-C to terminate them')
+    try:
+        while True:
+            time.sleep(1.)
+    except KeyboardInterrupt:
+        pass
+    finally:
+        provisioner.stop()
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
@@ -0,0 +1,130 @@
+"""The setup and the teardown of a run: the steps executed once, before and
+after the load, to create the resources the tests share -- a tenant, an
+auth token -- and to remove them::
+
+    class TestShop(TestCase):
+
+        def setup_run(self):
+            res = self.session.post(self.server_url + '/tenants')
+            return {'tenant': res.json()['id']}
+
+        def teardown_run(self, fixtures):
+            self.session.delete(self.server_url + '/tenants/%s' %
+                                fixtures['tenant'])
+
+        def test_cart(self):
+            self.session.get(self.server_url + '/%s/cart' %
+                             self.fixtures['tenant'])
+
+- **setup_run()**: returns the fixtures of the run, a mapping sent as
+  JSON. The users get them in *self.fixtures*. A failed setup fails the
+  run, before its load.
+- **teardown_run(fixtures)**: called with the fixtures once the load is
+  over, even when the run was stopped.
+
+The requests of the setup and of the teardown are not a part of the
+results. In distributed mode, the first agent of the run executes the
+setup and keeps the fixtures on the broker, while the other agents wait
+for them -- *--setup-timeout* seconds at most, 300 by default. The last
+agent done with the load executes the teardown. A resumed run keeps its
+fixtures, without a new setup.
+"""
+import time
+
+
+DEFAULT_SETUP_TIMEOUT = 300.
+_POLL_INTERVAL = .5
+
+
+class SetupError(Exception):
+    pass
+
+
+def has_lifecycle(klass):
+    """Tells if a test case class has a setup or a teardown."""
+    from loads.case import TestCase
+    for name in ('setup_run', 'teardown_run'):
+        method = getattr(klass, name, None)
+        if method is not None and (getattr(method, 'im_func', None) is not
+                                   getattr(TestCase, name).im_func):
+            return True
+    return False
+
+
+def run_setup(test):
+    """Executes the setup of a test case, and returns its fixtures."""
+    fixtures = test.setup_run()
+    if fixtures is None:
+        return {}
+    if not isinstance(fixtures, dict):
+        raise SetupError('setup_run returns a mapping, not %r' % fixtures)
+    return fixtures
+
+
+class SharedLifecycle(object):
+    """The setup and the teardown of a distributed run, shared by its
+    agents through the broker.
+
+    :param client: the broker :class:`Client`.
+    :param run_id: the id of the run.
+    :param agent_index: the index of the agent in the run -- the first one
+                        executes the setup.
+    :param timeout: the seconds the other agents wait for the fixtures.
+    :param sleep: waits for some seconds.
+    """
+    def __init__(self, client, run_id, agent_index=0,
+                 timeout=DEFAULT_SETUP_TIMEOUT, sleep=time.sleep):
+        self.client = client
+        self.run_id = run_id
+        self.agent_index = agent_index
+        self.timeout = timeout
+        self.sleep = sleep
+
+    def setup(self, setup):
+        """Returns the fixtures of the run: the ones *setup* returns on the
+        first agent, the ones kept on the broker for the others."""
+        state = self.client.get_fixtures(self.run_id)
+        if state is None and self.agent_index == 0:
+            try:
+                fixtures = setup()
+            except Exception, e:
+                self.client.set_fixtures(self.run_id, error=str(e))
+                raise
+            self.client.set_fixtures(self.run_id, fixtures=fixtures)
+            return fixtures
+
+        deadline = time.time() + self.timeout
+        while state is None:
+            if time.time() > deadline:
+                raise SetupError('No fixtures after %ds' % self.timeout)
+            self.sleep(_POLL_INTERVAL)
+            state = self.client.get_fixtures(self.run_id)
+
+        if state.get('error'):
+            raise SetupError('The setup of the run failed: %s' %
+                             state['error'])
+        return state.get('fixtures') or {}
+
+    def teardown(self, teardown, fixtures):
+        """Executes *teardown* when this agent is the last one done with
+        the load."""
+        if self.client.release_fixtures(self.run_id, self.agent_index):
+            teardown(fixtures)
+            return True
+        return False
+
+    def close(self):
+        self.client.close()
+
+
+def get_lifecycle(config):
+    """Returns the :class:`SharedLifecycle` of an agent of a distributed
+    run, or None."""
+    if not config.get('slave') or config.get('run_id') is None:
+        return None
+    from loads.transport.client import Client
+    client = Client(config['broker'], api_key=config.get('api_key'))
+    timeout = config.get('setup_timeout') or DEFAULT_SETUP_TIMEOUT
+    return SharedLifecycle(client, config['run_id'],
+                           config.get('agent_index') or 0, float(timeout))
+
@@ -1,19 +1,39 @@
 import argparse
 import logging
+import os
 import sys
+import time
 import traceback
 from datetime import datetime
 
 from konfig import Config
 
 from loads import __version__
+from loads.baseline import DEFAULT_ERROR_MARGIN, DEFAULT_MARGIN, parse_margin
+from loads.chaos import parse_chaos
+from loads.dualstack import FAMILIES
+from loads.feeders import STRATEGIES
+from loads.monitor import DEFAULT_INTERVAL, parse_monitor
+from loads.network import (PROFILES, parse_bandwidth, parse_delay, parse_loss,
+                           parse_profiles)
 from loads.output import output_list
+from loads.pacing import parse_think_time
+from loads.provisioners import create_provisioner, provisioner_list
+from loads.proxies import STRATEGIES as PROXY_STRATEGIES
+from loads.qos import parse_hop_limit, parse_traffic_class
+from loads.schedule import parse_at, parse_cron
 from loads.runners import (LocalRunner, DistributedRunner, ExternalRunner,
                            RUNNERS)
+from loads.runners.local import (DEFAULT_CHECKPOINT_INTERVAL,
+                                 DEFAULT_GRACE_PERIOD)
+from loads.sockopts import parse_size, parse_switch
+from loads.sources import STRATEGIES as SOURCE_STRATEGIES
+from loads.tls import VERSIONS as TLS_VERSIONS
+from loads.transport.capabilities import describe
 from loads.transport.client import Client, TimeoutError
 from loads.transport.util import (DEFAULT_FRONTEND, DEFAULT_PUBLISHER,
                                   DEFAULT_SSH_FRONTEND)
-from loads.util import logger, set_logger
+from loads.util import logger, set_logger, parse_duration
 from loads.observers import observers
 
 
@@ -60,8 +80,12 @@ def add_options(items, parser, fmt):
 
 def run(args):
     is_slave = args.get('slave', False)
-    has_agents = args.get('agents', None)
+    has_agents = args.get('agents', None) or args.get('provisioner')
     attach = args.get('attach', False)
+    if args.get('resume'):
+        return _resume(args)
+    if args.get('at') is not None or args.get('cron'):
+        return _schedule(args)
     if not attach and (is_slave or not has_agents):
         if args.get('test_runner', None) is not None:
             runner = ExternalRunner
@@ -75,7 +99,7 @@ def run(args):
     else:
         if attach:
             # find out what's running
-            client = Client(args['broker'])
+            client = Client(args['broker'], api_key=args.get('api_key'))
             try:
                 runs = client.list_runs()
             except TimeoutError:
@@ -111,12 +135,72 @@ def run(args):
             except KeyboardInterrupt:
                 _detach_question(runner)
         else:
+            provisioner = None
+            if args.get('provisioner'):
+                provisioner = create_provisioner(args['provisioner'], args)
+                args = provisioner.start()
+
             logger.debug('Summoning %d agents' % args['agents'])
-            runner = DistributedRunner(args)
+            runner = DistributedRunner(args, provisioner=provisioner)
             try:
                 return runner.execute()
             except KeyboardInterrupt:
-                _detach_question(runner)
+                if provisioner is None:
+                    _detach_question(runner)
+                else:
+                    # the agents go away with the run
+                    runner.cancel()
+            finally:
+                if provisioner is not None:
+                    provisioner.stop()
+
+
+def _resume(args):
+    client = Client(args['broker'], ssh=args.get('ssh'),
+                    api_key=args.get('api_key'))
+    try:
+        res = client.continue_run(args['resume'], args.get('include_file'))
+        run_id = res['run_id']
+        metadata = client.get_metadata(run_id)
+        counts = client.get_counts(run_id)
+    except TimeoutError:
+        logger.info("Can't reach the broker at %r" % args['broker'])
+        return 1
+    except ValueError, e:
+        logger.info(str(e))
+        return 1
+    finally:
+        client.close()
+
+    logger.info('Run %s resumed on %d agent(s)' % (run_id,
+                                                   len(res['agents'])))
+    args['attach'] = True
+    args['agents'] = len(res['agents'])
+    started = datetime.utcfromtimestamp(metadata['started'])
+    runner = DistributedRunner(args)
+    try:
+        return runner.attach(run_id, started, counts, metadata)
+    except KeyboardInterrupt:
+        _detach_question(runner)
+
+
+def _schedule(args):
+    client = Client(args['broker'], ssh=args.get('ssh'),
+                    api_key=args.get('api_key'))
+    try:
+        res = client.schedule_run(args)
+    except TimeoutError:
+        logger.info("Can't reach the broker at %r" % args['broker'])
+        return 1
+    except ValueError, e:
+        logger.info(str(e))
+        return 1
+    finally:
+        client.close()
+
+    logger.info('Schedule %s: the next run starts at %s' % (
+        res['schedule_id'], time.ctime(res['next'])))
+    return 0
 
 
 def _parse(sysargs=None):
@@ -124,7 +208,8 @@ def _parse(sysargs=None):
         sysargs = sys.argv[1:]
 
     parser = argparse.ArgumentParser(description='Runs a load test.')
-    parser.add_argument('fqn', help='Fully Qualified Name of the test',
+    parser.add_argument('fqn', help='Fully Qualified Name of the test, or of '
+                                    'a test case class with scenarios',
                         nargs='?')
 
     parser.add_argument('--config', help='Configuration file to read',
@@ -148,12 +233,42 @@ def _parse(sysargs=None):
     parser.add_argument('--ssh', help='SSH tunnel - e.g. user@server:port',
                         type=str, default=None)
 
-    # loads works with hits or duration
+    # loads works with hits, iterations, duration or stages
     group = parser.add_mutually_exclusive_group()
     group.add_argument('--hits', help='Number of hits per user',
                        type=str, default=None)
+    group.add_argument('--iterations', help='Number of tests of the run, '
+                                            'shared by its users. The run '
+                                            'ends with the last one.',
+                       type=int, default=None)
     group.add_argument('-d', '--duration', help='Duration of the test (s)',
                        type=int, default=None)
+    group.add_argument('--stages', help='Load shape, as a list of '
+                                        'duration:users stages - e.g. '
+                                        '"2m:100,10m:100,2m:0"',
+                       type=str, default=None)
+
+    parser.add_argument('--iterations-per-user', action='store_true',
+                        default=False,
+                        help='Every user runs the tests of --iterations.')
+
+    parser.add_argument('--max-rps', type=float, default=None,
+                        help='The ceiling of the HTTP requests per second '
+                             'of the run. In distributed mode, the agents '
+                             'share it.')
+
+    parser.add_argument('--warmup', type=parse_duration, default=None,
+                        help='The duration of the warm-up of the target, '
+                             'like "30s": the requests are sent, but left '
+                             'out of the results and the thresholds.')
+
+    parser.add_argument('--arrival-rate', help='Number of tests started per '
+                                               'second (open model)',
+                        type=float, default=None)
+
+    parser.add_argument('--max-users', help='Maximum number of concurrent '
+                                            'users when using --arrival-rate',
+                        type=int, default=None)
 
     parser.add_argument('--version', action='store_true', default=False,
                         help='Displays Loads version and exits.')
@@ -163,11 +278,309 @@ def _parse(sysargs=None):
                              'when in distributed mode. The default is '
                              'this (python) runner')
 
+    parser.add_argument('--http-engine', default=None,
+                        choices=('net/http', 'fasthttp'),
+                        help='The HTTP engine of the test runners written '
+                             'in Go. fasthttp has the throughput of a few '
+                             'agents, and needs -tags fasthttp.')
+
     parser.add_argument('--server-url', default=None,
                         help='The URL of the server you want to test. It '
                              'will override any value your provided in '
                              'the tests for the WebTest client.')
 
+    parser.add_argument('--max-redirects', type=int, default=None,
+                        help='Maximum number of redirects followed by the '
+                             'HTTP requests. Use 0 to not follow them.')
+
+    parser.add_argument('--trace-requests', action='store_true',
+                        default=False,
+                        help='Send a W3C traceparent header with every HTTP '
+                             'request, so the server traces can be '
+                             'correlated with the requests.')
+
+    parser.add_argument('--request-id-header', default=None,
+                        help='Send a unique id in this header with every '
+                             'HTTP request, like X-Request-Id. The id is '
+                             'kept with the hit.')
+
+    parser.add_argument('--server-timing', action='store_true',
+                        default=False,
+                        help='Keep the durations of the Server-Timing '
+                             'headers of the responses, as trends.')
+
+    parser.add_argument('--header-metric', action='append', default=None,
+                        help='A response header with a number, like '
+                             'X-Db-Time, kept as a trend. Can be repeated.')
+
+    parser.add_argument('--hooks', default=None,
+                        help='A Python or Lua file of hooks called before '
+                             'every HTTP request and after its response.')
+
+    parser.add_argument('--templates', action='store_true', default=False,
+                        help='Render the {{templates}} of the URLs, headers '
+                             'and bodies of the HTTP requests, like '
+                             '{{uuid}} or {{feeder.email}}.')
+
+    parser.add_argument('--feeder', default=None,
+                        help='The CSV or JSON Lines file of the rows of '
+                             'the {{feeder.column}} templates.')
+
+    parser.add_argument('--feeder-strategy', default='round-robin',
+                        choices=STRATEGIES,
+                        help='How the rows of --feeder are picked.')
+
+    parser.add_argument('--feeder-partition', action='store_true',
+                        default=False,
+                        help='In distributed mode, sends --feeder to the '
+                             'broker, which ships every agent its own '
+                             'partition of the rows.')
+
+    parser.add_argument('--seed', default=None, type=int,
+                        help='The seed of the scenarios, the random rows, '
+                             'the think times and the fake data of the '
+                             'users, so two runs send the same requests.')
+
+    parser.add_argument('--id-block-size', default=None, type=int,
+                        help='The ids of next_id() an agent reserves at '
+                             'once on the broker -- 10000 by default.')
+
+    parser.add_argument('--setup-timeout', default=None, type=float,
+                        help='In distributed mode, the seconds the agents '
+                             'wait for the fixtures of the setup_run() of '
+                             'the first agent -- 300 by default.')
+
+    parser.add_argument('--accept-encoding', default=None,
+                        help='The Accept-Encoding header of the HTTP '
+                             'requests, like "gzip, br, zstd" -- or "all" '
+                             'for all the encodings loads decompresses.')
+
+    parser.add_argument('--url-pattern', action='append', default=None,
+                        help='REGEX=LABEL: the hits of the URLs matching '
+                             'the regular expression are aggregated under '
+                             'this label. Repeat it for more labels.')
+
+    parser.add_argument('--no-url-grouping', action='store_true',
+                        default=False,
+                        help='Aggregate the hits by URL, without replacing '
+                             'the ids of the paths by placeholders.')
+
+    parser.add_argument('--proxy', action='append', default=None,
+                        help='An HTTP or SOCKS5 proxy for the HTTP '
+                             'requests. Repeat it to give the virtual '
+                             'users different proxies.')
+
+    parser.add_argument('--proxies', default=None,
+                        help='A file of proxies, one per line, read where '
+                             'the test runs.')
+
+    parser.add_argument('--proxy-strategy', default='round-robin',
+                        choices=PROXY_STRATEGIES,
+                        help='How the virtual users get their proxies.')
+
+    parser.add_argument('--source-ip', action='append', default=None,
+                        help='A local address the connections are bound '
+                             'to. Repeat it to spread the connections over '
+                             'several addresses.')
+
+    parser.add_argument('--source-ips', default=None,
+                        help='A file of local addresses, one per line, '
+                             'read where the test runs.')
+
+    parser.add_argument('--source-ip-strategy', default='round-robin',
+                        choices=SOURCE_STRATEGIES,
+                        help='Whether every connection binds the next '
+                             'address, or every virtual user keeps its '
+                             'own.')
+
+    parser.add_argument('--client-cert', action='append', default=None,
+                        help='The client certificate of the HTTPS '
+                             'connections, as cert.pem or cert.pem,key.pem. '
+                             'Repeat it to give the virtual users different '
+                             'certificates.')
+
+    parser.add_argument('--tls-min-version', default=None,
+                        choices=TLS_VERSIONS,
+                        help='The oldest TLS version allowed.')
+
+    parser.add_argument('--tls-max-version', default=None,
+                        choices=TLS_VERSIONS,
+                        help='The newest TLS version allowed.')
+
+    parser.add_argument('--tls-ciphers', default=None,
+                        help='The TLS cipher suites, in the OpenSSL format.')
+
+    parser.add_argument('--tls-server-name', default=None,
+                        help='The name sent with SNI, instead of the host '
+                             'of the URLs.')
+
+    parser.add_argument('--no-tls-resumption', action='store_true',
+                        default=False,
+                        help='Do a full TLS handshake on every connection.')
+
+    parser.add_argument('--ip-family', default=None, choices=FAMILIES,
+                        help='Connect to the IPv4 or the IPv6 addresses of '
+                             'the hosts only, or to both with Happy '
+                             'Eyeballs. The hits are compared by family.')
+
+    parser.add_argument('--hop-limit', type=parse_hop_limit, default=None,
+                        help='The hop limit -- or TTL -- of the packets '
+                             'sent.')
+
+    parser.add_argument('--traffic-class', type=parse_traffic_class,
+                        default=None,
+                        help='The traffic class -- or TOS -- of the packets '
+                             'sent: a byte, or a DSCP name like "EF" or '
+                             '"AF41".')
+
+    parser.add_argument('--reuse-port', action='store_true', default=False,
+                        help='Set SO_REUSEPORT on the connections.')
+
+    parser.add_argument('--tcp-nodelay', type=parse_switch, default=None,
+                        help='"on" or "off": TCP_NODELAY on the '
+                             'connections -- on by default for HTTP.')
+
+    parser.add_argument('--linger', type=int, default=None,
+                        help='SO_LINGER, in seconds: with 0, the closed '
+                             'connections are reset, without TIME_WAIT.')
+
+    parser.add_argument('--send-buffer', type=parse_size, default=None,
+                        help='SO_SNDBUF, in bytes, like "256k".')
+
+    parser.add_argument('--receive-buffer', type=parse_size, default=None,
+                        help='SO_RCVBUF, in bytes, like "256k".')
+
+    parser.add_argument('--tcp-fastopen', action='store_true',
+                        default=False,
+                        help='Use TCP Fast Open on the connections '
+                             '(Linux 4.11+).')
+
+    parser.add_argument('--think-time', type=parse_think_time,
+                        default=None,
+                        help='The pause before every request of a test but '
+                             'the first one: SECONDS, uniform:MIN,MAX or '
+                             'lognormal:MEDIAN,SIGMA.')
+
+    parser.add_argument('--pacing', type=float, default=None,
+                        help='The minimum duration of an iteration, in '
+                             'seconds: the users wait for the rest before '
+                             'their next test.')
+
+    parser.add_argument('--grace-period', type=float,
+                        default=DEFAULT_GRACE_PERIOD,
+                        help='The seconds the tests in flight have to '
+                             'complete when the run ends or is aborted -- '
+                             'no new test starts. The users still running '
+                             'after that are killed.')
+
+    parser.add_argument('--max-idle-connections', type=int, default=None,
+                        help='The idle HTTP connections kept by host.')
+
+    parser.add_argument('--max-connections-per-host', type=int,
+                        default=None,
+                        help='The HTTP connections open at once to a host. '
+                             'The requests wait for one beyond.')
+
+    parser.add_argument('--idle-timeout', type=float, default=None,
+                        help='The seconds after which the idle HTTP '
+                             'connections are closed instead of reused.')
+
+    parser.add_argument('--no-keep-alive', action='store_true',
+                        default=False,
+                        help='Open a new HTTP connection for every '
+                             'request.')
+
+    parser.add_argument('--http-cache', action='store_true', default=False,
+                        help='Every virtual user caches the responses of '
+                             'its GET requests like a browser, following '
+                             'their Cache-Control and ETag headers.')
+
+    parser.add_argument('--network', type=parse_profiles, default=None,
+                        help='The network conditions of the virtual users: '
+                             'one of %s, or several separated by commas, '
+                             'given to the users in turn.' %
+                             ', '.join(sorted(PROFILES)))
+
+    parser.add_argument('--latency', type=parse_delay, default=None,
+                        help='The latency added to the requests of the '
+                             'users, like "80ms".')
+
+    parser.add_argument('--jitter', type=parse_delay, default=None,
+                        help='How much the latency varies, like "10ms".')
+
+    parser.add_argument('--bandwidth', type=parse_bandwidth, default=None,
+                        help='The bandwidth of every user, like "1.5mbit".')
+
+    parser.add_argument('--packet-loss', type=parse_loss, default=None,
+                        help='The UDP datagrams and TCP messages lost, '
+                             'like "1%%".')
+
+    parser.add_argument('--http2', action='store_true', default=False,
+                        help='Use HTTP/2 for the HTTP requests. Plain HTTP '
+                             'connections try to upgrade to h2c.')
+
+    parser.add_argument('--h2c', action='store_true', default=False,
+                        help='Use HTTP/2 without upgrade on plain HTTP '
+                             'connections (prior knowledge).')
+
+    parser.add_argument('--http2-max-streams', type=int, default=None,
+                        help='Maximum number of concurrent HTTP/2 streams '
+                             'per connection.')
+
+    parser.add_argument('--expected-interval', type=float, default=None,
+                        help='The expected interval between two requests '
+                             'of a user, in seconds, used to correct the '
+                             'percentiles for the coordinated omission. '
+                             'Defaults to the average request time.')
+
+    parser.add_argument('--threshold', action='append', default=None,
+                        help='A threshold evaluated at the end of the run, '
+                             'like "p95 < 250ms" or "error_rate < 1%%". '
+                             'The exit code is 1 if any threshold fails.')
+
+    parser.add_argument('--threshold-file', default=None,
+                        help='A file of thresholds, one per line, like the '
+                             'ones --baseline writes.')
+
+    parser.add_argument('--baseline', default=None, metavar='FILE',
+                        help='Writes the p95 times and the error rates of '
+                             'the run and of its endpoints, plus the '
+                             'margins, in a thresholds file.')
+
+    parser.add_argument('--baseline-margin', type=parse_margin,
+                        default=DEFAULT_MARGIN,
+                        help='The margin of the times of the baseline, in '
+                             'percents -- 20%% by default.')
+
+    parser.add_argument('--baseline-error-margin', type=parse_margin,
+                        default=DEFAULT_ERROR_MARGIN,
+                        help='The margin of the error rates of the '
+                             'baseline, in percentage points -- 1%% by '
+                             'default.')
+
+    parser.add_argument('--live-redis', default=None,
+                        help='The host:port of the Redis where the brokers '
+                             'keep the live stats. The thresholds of a '
+                             'distributed run are then evaluated on them.')
+
+    parser.add_argument('--chaos', type=parse_chaos, default=None,
+                        metavar='FILE',
+                        help='A JSON file of the chaos events of the run: '
+                             'commands, HTTP requests or agents stopped at '
+                             'given times.')
+
+    parser.add_argument('--monitor', action='append', type=parse_monitor,
+                        default=None,
+                        help='A host of the system under test whose CPU, '
+                             'memory and connections are sampled during the '
+                             'run: the URL of its Prometheus node exporter, '
+                             'ssh://user@host, or "local".')
+
+    parser.add_argument('--monitor-interval', type=float,
+                        default=DEFAULT_INTERVAL,
+                        help='The seconds between two samples of the '
+                             '--monitor hosts.')
+
     parser.add_argument('--observer', action='append',
                         choices=[observer.name for observer in observers],
                         help='Callable that will receive the final results. '
@@ -180,10 +593,11 @@ def _parse(sysargs=None):
         prefix = '--observer-%s-' % observer.name
         for option in observer.options:
             name = prefix + option['name']
+            # the flags have an action, and no type
+            kwargs = dict([(key, option[key]) for key in ('type', 'action')
+                           if option.get(key) is not None])
             parser.add_argument(name, help=option.get('help'),
-                                default=option.get('default'),
-                                type=option.get('type'),
-                                action=option.get('action'))
+                                default=option.get('default'), **kwargs)
 
     parser.add_argument('--no-patching',
                         help='Deactivate Gevent monkey patching.',
@@ -219,6 +633,11 @@ def _parse(sysargs=None):
     parser.add_argument('-b', '--broker', help='Broker endpoint',
                         default=DEFAULT_FRONTEND)
 
+    parser.add_argument('--api-key', help='The API key of the project, when '
+                                          'the broker needs one -- '
+                                          '$LOADS_API_KEY by default',
+                        default=os.environ.get('LOADS_API_KEY'))
+
     parser.add_argument('--user-id', help='Name of the user who runs the test',
                         type=str, default='undefined')
 
@@ -228,22 +647,132 @@ def _parse(sysargs=None):
     parser.add_argument('--batched', action='store_true', default=False,
                         help='Batch results in distributed mode')
 
+    parser.add_argument('--batch-interval', type=float, default=None,
+                        help='Seconds between two batches of results sent '
+                             'by the agents.')
+
+    parser.add_argument('--batch-size', type=int, default=None,
+                        help='Number of results after which the agents send '
+                             'a batch without waiting.')
+
+    parser.add_argument('--compress-results', action='store_true',
+                        default=False,
+                        help='The agents compress the batches of results.')
+
     parser.add_argument('--quiet', action='store_true', default=False,
                         help='Do not print any log messages.')
     parser.add_argument('--output', action='append', default=['stdout'],
                         help='The output which will get the results',
                         choices=outputs)
 
+    parser.add_argument('--plugin', action='append', default=None,
+                        metavar='PATH',
+                        help='A plugin binary: a sink getting the results, '
+                             'or an auth provider of the requests')
+
+    parser.add_argument('--plugin-option', action='append', default=None,
+                        metavar='NAME=VALUE',
+                        help='An option given to the plugins')
+
     parser.add_argument('--attach', help='Reattach to a distributed run',
                         action='store_true', default=False)
 
+    parser.add_argument('--resume', metavar='RUN_ID', default=None,
+                        help='Resumes an interrupted distributed run from '
+                             'its last checkpoints')
+
+    parser.add_argument('--checkpoint-interval', type=float,
+                        default=DEFAULT_CHECKPOINT_INTERVAL,
+                        help='Seconds between two checkpoints of a '
+                             'distributed run, 0 for none.')
+
     parser.add_argument('--detach', help='Detach immediatly the current '
                                          'distributed run',
                         action='store_true', default=False)
 
-    # Adds the per-output and per-runner options.
+    parser.add_argument('--queue', help='Wait in the queue of the broker '
+                                        'when its agents are busy',
+                        action='store_true', default=False)
+
+    parser.add_argument('--priority', help='The priority of the run in the '
+                                           'queue: a run preempts the runs '
+                                           'of lower priorities',
+                        type=int, default=0)
+
+    parser.add_argument('--requires', action='append', default=None,
+                        metavar='FEATURE',
+                        help='A feature or an engine the agents of the '
+                             'distributed run need, on top of the ones of '
+                             'its options -- like "grpc" or "mqtt"')
+
+    parser.add_argument('--at', type=parse_at, default=None,
+                        help='Schedules the distributed run on the broker, '
+                             'at a date like "2026-10-15 02:00", a time '
+                             'like "02:00" or in a duration like "+2h"')
+
+    parser.add_argument('--cron', type=parse_cron, default=None,
+                        help='Schedules the distributed run on the broker, '
+                             'on a cron expression like "0 2 * * *"')
+
+    parser.add_argument('--series', default=None,
+                        help='The series of the distributed run, for '
+                             'loads-compare to trend its runs')
+
+    parser.add_argument('--list-schedules', action='store_true',
+                        default=False,
+                        help='Lists the runs scheduled on the broker, then '
+                             'exits')
+
+    parser.add_argument('--cancel-schedule', metavar='SCHEDULE_ID',
+                        default=None,
+                        help='Cancels a schedule of the broker, then exits')
+
+    parser.add_argument('--redistribute', help='When an agent is lost, '
+                                               'run its share of a run with '
+                                               'a duration on a free agent',
+                        action='store_true', default=False)
+
+    parser.add_argument('--set-users', help='Changes the number of users '
+                                            'of every agent of the current '
+                                            'distributed run, then exits',
+                        type=int, default=None)
+
+    parser.add_argument('--set-rate', help='Changes the arrival rate of '
+                                           'every agent of the current '
+                                           'distributed run, then exits',
+
+                        type=float, default=None)
+
+    parser.add_argument('--set-max-rps', help='Changes the ceiling of the '
+                                              'requests per second of the '
+                                              'current distributed run, '
+                                              'then exits',
+                        type=float, default=None)
+
+    provisioners = [provisioner.name for provisioner in provisioner_list()]
+    provisioners.sort()
+
+    parser.add_argument('--provisioner', help='Creates the agents of the '
+                                              'run, and deletes them '
+                                              'afterwards',
+                        choices=provisioners, default=None)
+
+    parser.add_argument('--users-per-agent', help='The number of users an '
+                                                  'agent can run, to compute '
+                                                  'the number of agents to '
+                                                  'provision',
+                        type=int, default=None)
+
+    parser.add_argument('--provision-timeout', help='Seconds to wait for '
+                                                    'the provisioned agents '
+                                                    'to register',
+                        type=float, default=None)
+
+    # Adds the per-output, per-runner and per-provisioner options.
     add_options(RUNNERS, parser, fmt='--{name}-{option}')
     add_options(output_list(), parser, fmt='--output-{name}-{option}')
+    add_options(provisioner_list(), parser,
+                fmt='--provisioner-{name}-{option}')
 
     args = parser.parse_args(sysargs)
     if args.config is not None:
@@ -289,7 +818,7 @@ def main(sysargs=None):
 
     if args.ping_broker or args.purge_broker or args.check_cluster:
 
-        client = Client(args.broker, ssh=args.ssh)
+        client = Client(args.broker, ssh=args.ssh, api_key=args.api_key)
         ping = client.ping()
 
         if args.purge_broker:
@@ -307,7 +836,14 @@ def main(sysargs=None):
             print('%d agents registered' % len(agents))
 
             for pid, agent_info in agents.items():
-                print('  - %s on %s' % (pid, agent_info['hostname']))
+                tags = agent_info.get('tags')
+                if tags:
+                    tags = ' (%s)' % ', '.join(['%s=%s' % tag for tag
+                                                in sorted(tags.items())])
+                capabilities = agent_info.get('capabilities')
+                print('  - %s on %s%s, %s' % (pid, agent_info['hostname'],
+                                              tags or '',
+                                              describe(capabilities)))
 
             print('endpoints:')
             for name, location in ping['endpoints'].items():
@@ -341,8 +877,58 @@ def main(sysargs=None):
             args.hits = '1'
             print('Running a health check on all %d agents' % args.agents)
 
+    if (args.set_users is not None or args.set_rate is not None or
+            args.set_max_rps is not None):
+        client = Client(args.broker, ssh=args.ssh, api_key=args.api_key)
+        runs = client.list_runs()
+        if len(runs) == 0:
+            print('Nothing is running right now.')
+            sys.exit(1)
+        elif len(runs) > 1:
+            print('We have %d runs right now, not changing them.' % len(runs))
+            sys.exit(1)
+
+        run_id = runs.keys()[0]
+        agents = client.set_load(run_id, users=args.set_users,
+                                 rate=args.set_rate,
+                                 max_rps=args.set_max_rps)
+        print('Changed the load of %d agent(s) of run %s' % (len(agents),
+                                                              run_id))
+        sys.exit(0)
+
+    if args.iterations is not None and args.iterations < 1:
+        parser.error('--iterations is at least 1')
+
+    if args.list_schedules or args.cancel_schedule is not None:
+        client = Client(args.broker, ssh=args.ssh, api_key=args.api_key)
+        if args.cancel_schedule is not None:
+            client.cancel_schedule(args.cancel_schedule)
+            print('Schedule %s cancelled' % args.cancel_schedule)
+            sys.exit(0)
+
+        schedules = client.list_schedules()
+        if len(schedules) == 0:
+            print('Nothing is scheduled right now.')
+        for schedule in schedules:
+            print('  - %s: %s, next at %s%s' % (
+                schedule['schedule_id'], schedule['fqn'],
+                time.ctime(schedule['next']),
+                schedule['cron'] and ' (%s)' % schedule['cron'] or ''))
+        sys.exit(0)
+
+    if args.at is not None and args.cron is not None:
+        parser.error('--at and --cron can not be used together')
+
+    if ((args.at is not None or args.cron is not None) and
+            (not args.agents or args.provisioner is not None)):
+        parser.error('A scheduled run is a distributed run, without '
+                     'provisioner')
+
+    if args.provisioner is not None and args.detach:
+        parser.error('A run with provisioned agents can not be detached')
+
     # if we don't have an fqn or we're not attached, something's wrong
-    if args.fqn is None and not args.attach:
+    if args.fqn is None and not args.attach and args.resume is None:
         parser.print_usage()
         sys.exit(0)
 
@@ -1,12 +1,38 @@
 import datetime
+import time
 import urlparse
+import uuid
 
+from requests.exceptions import ConnectionError
 from requests.sessions import Session as _Session
 from webtest.app import TestApp as _TestApp
 from wsgiproxy.proxies import HostProxy as _HostProxy
 from wsgiproxy.requests_client import HttpClient
 
-from loads.util import dns_resolve
+from loads.compression import decompress, get_sizes
+from loads.errors import snippet
+from loads.proxies import ProxyConnectError, is_proxy_error
+from loads.servertiming import get_server_timings
+from loads.tracing import (start_trace, set_trace, record_dns, new_span,
+                           format_traceparent, pop_handshake, pop_reuse)
+from loads.util import dns_resolve, total_seconds
+
+
+_HTTP_VERSIONS = {9: 'HTTP/0.9', 10: 'HTTP/1.0', 11: 'HTTP/1.1'}
+
+
+def get_protocol(response):
+    """Returns the protocol negotiated for the given response, e.g.
+    "HTTP/1.1" or "HTTP/2".
+    """
+    version = getattr(response.raw, 'version', None)
+    if version is None:
+        return None
+
+    # hyper uses an enum, urllib3 the version as an int.
+    if hasattr(version, 'value'):
+        return version.value
+    return _HTTP_VERSIONS.get(version, str(version))
 
 
 class TestApp(_TestApp):
@@ -68,26 +94,199 @@ class Session(_Session):
         self.test = test
         self.test_result = test_result
         self.loads_status = None, None, None, None
+        self.follow_redirects = True
+        # when True, every request starts a span sent in a traceparent header
+        self.trace_requests = False
+        # when set, every request gets a unique id in this header
+        self.request_id_header = None
+        # the hooks called around every request -- see loads.hooks
+        self.loads_hooks = None
+        # when True, the {{templates}} of the requests are rendered, with the
+        # rows returned by the feeder -- see loads.templates
+        self.templates = False
+        self.feeder = None
+        # the generator of the templates, with --seed -- see loads.seeds
+        self.random = None
+        # the endpoints the hits are aggregated by -- see loads.endpoints
+        self.endpoints = None
+        self._label = self._validate = None
+        # paces the requests under the ceiling of the run -- see
+        # loads.ratelimit
+        self.rate_limiter = None
+        # the emulated network of the virtual user -- see loads.network
+        self.network = None
+        # the address family of the connections -- see loads.dualstack
+        self.family = None
+        # the pause before the requests of a test but the first one, and
+        # the requests sent by the current test -- see loads.pacing
+        self.think_time = None
+        self.steps = 0
+        # the timings of the responses kept with the hits: the
+        # Server-Timing header, and these numeric headers -- see
+        # loads.servertiming
+        self.server_timing = False
+        self.header_metrics = ()
+        # when False, the hosts are not resolved before the adapters get
+        # the requests -- see loads.check
+        self.resolve_hosts = True
+        # the responses kept by the virtual user -- see loads.httpcache
+        self.http_cache = None
 
-    def request(self, method, url, headers=None, **kwargs):
-        if not url.startswith('https://'):
-            url, original, resolved = dns_resolve(url)
+    def request(self, method, url, headers=None, label=None, validate=None,
+                **kwargs):
+        if self.think_time is not None and self.steps:
+            self.think_time.wait()
+        self.steps += 1
+        if not self.follow_redirects:
+            kwargs['allow_redirects'] = False
+        if self.templates:
+            from loads.templates import Context, render, render_all
+            context = Context(self.feeder, getattr(self.test, 'state', None),
+                              self.random)
+            url = render(url, context)
+            headers = render_all(headers, context)
+            for name in ('params', 'data', 'json'):
+                if name in kwargs:
+                    kwargs[name] = render_all(kwargs[name], context)
+        # the dual-stack connections resolve the hosts themselves
+        if (self.resolve_hosts and not url.startswith('https://') and
+                self.family != 'happy-eyeballs'):
+            start = time.time()
+            url, original, resolved = dns_resolve(url, self.family)
+            record_dns(time.time() - start)
             if headers is None:
                 headers = {}
             headers['Host'] = original
-        return super(Session, self).request(
-            method, url, headers=headers, **kwargs)
+        self._label, self._validate = label, validate
+        try:
+            return super(Session, self).request(
+                method, url, headers=headers, **kwargs)
+        finally:
+            self._label = self._validate = None
+
+    def prepare_request(self, request):
+        prepared = _Session.prepare_request(self, request)
+        # the label is the one of this request, not of its redirects
+        prepared.label, self._label = self._label, None
+        prepared.validate, self._validate = self._validate, None
+        return prepared
 
     def send(self, request, **kwargs):
         """Do the actual request from within the session, doing some
         measures at the same time about the request (duration, status, etc).
         """
+        if self.http_cache is not None and not kwargs.get('stream'):
+            cached = self.http_cache.lookup(request)
+            if cached is not None:
+                # served without any request
+                if self.test_result is not None:
+                    self.test_result.incr_counter(self.test,
+                                                  self.loads_status,
+                                                  'http-cache-hits')
+                return cached
+        if self.rate_limiter is not None:
+            self.rate_limiter.acquire()
         # attach some information to the request object for later use.
         start = datetime.datetime.utcnow()
-        res = _Session.send(self, request, **kwargs)
-        res.started = start
-        res.method = request.method
-        self._analyse_request(res)
+        stream = kwargs.pop('stream', False)
+        shaped = 0.
+        if self.network is not None:
+            # the latency of the request and the bytes of its body
+            body = request.body
+            shaped = self.network.wait(isinstance(body, basestring) and
+                                       len(body) or 0)
+        span = None
+        if self.trace_requests and 'traceparent' not in request.headers:
+            span = new_span()
+            request.headers['traceparent'] = format_traceparent(*span)
+
+        request_id = None
+        if self.request_id_header is not None:
+            # the redirects keep the id of the first request
+            if self.request_id_header not in request.headers:
+                request.headers[self.request_id_header] = uuid.uuid4().hex
+            request_id = request.headers[self.request_id_header]
+        if self.loads_hooks is not None:
+            self.loads_hooks.before_request(request, self.test)
+        trace = start_trace()
+        try:
+            res = _Session.send(self, request, stream=True, **kwargs)
+        except ConnectionError, e:
+            if not kwargs.get('proxies') or not is_proxy_error(e):
+                raise
+            if self.test_result is not None:
+                self.test_result.incr_counter(self.test, self.loads_status,
+                                              'proxy-errors')
+            raise ProxyConnectError(e, request=request)
+
+        handshake = pop_handshake()
+        if handshake is not None and self.test_result is not None:
+            self.test_result.incr_counter(
+                self.test, self.loads_status,
+                handshake and 'tls-resumed' or 'tls-full-handshakes')
+        reused = pop_reuse()
+        if reused is not None and self.test_result is not None:
+            self.test_result.incr_counter(
+                self.test, self.loads_status,
+                reused and 'http-connections-reused' or
+                'http-connections-new')
+
+        # when the redirects are followed, only the first response comes
+        # from this request: the next ones were sent -- and measured -- by
+        # resolve_redirects.
+        first = res.history and res.history[0] or res
+        set_trace(trace)
+        trace['ttfb'] = max(total_seconds(first.elapsed) - trace['connect'] -
+                            trace['tls'], 0.) + shaped
+        if not stream:
+            body_start = time.time()
+            res.content
+            decompress(res)
+            if first is res:
+                trace['body'] = time.time() - body_start
+
+        first.body_size, first.wire_size = get_sizes(first)
+        if self.network is not None:
+            # the bytes of the response
+            shaped += self.network.wait(first.wire_size or 0, latency=False)
+        if shaped:
+            first.elapsed += datetime.timedelta(seconds=shaped)
+        if first.status_code >= 400 and first.body_size:
+            # an example of the errors -- see loads.errors
+            first.error_body = snippet(first.text)
+        validate = getattr(request, 'validate', None)
+        if validate is not None and not stream:
+            # the errors of a successful response, like the ones of GraphQL:
+            # the hit gets their status, and an example of them
+            error = validate(res)
+            if error is not None:
+                status, message = error
+                first.hit_status, first.error_body = status, snippet(message)
+        first.phases = trace
+        if self.server_timing or self.header_metrics:
+            first.server_timings = get_server_timings(
+                first.headers, self.server_timing, self.header_metrics)
+        first.started = start
+        first.method = request.method
+        first.span = span
+        first.request_id = request_id
+        label = getattr(request, 'label', None)
+        if self.endpoints is not None:
+            first.endpoint = self.endpoints.get_endpoint(first.url, label)
+        else:
+            first.endpoint = label
+        self._analyse_request(first)
+        if self.http_cache is not None and not stream:
+            cached, revalidated = self.http_cache.store(request, first)
+            if first is res:
+                res = cached
+            if self.test_result is not None and request.method == 'GET':
+                self.test_result.incr_counter(
+                    self.test, self.loads_status,
+                    revalidated and 'http-cache-revalidated' or
+                    'http-cache-misses')
+        if self.loads_hooks is not None:
+            self.loads_hooks.after_response(res, request, self.test)
         return res
 
     def _analyse_request(self, req):
@@ -99,7 +298,27 @@ class Session(_Session):
         if self.test_result is not None:
             self.test_result.add_hit(elapsed=req.elapsed,
                                      started=req.started,
-                                     status=req.status_code,
+                                     status=getattr(req, 'hit_status',
+                                                    req.status_code),
                                      url=req.url,
                                      method=req.method,
-                                     loads_status=self.loads_status)
+                                     loads_status=self.loads_status,
+                                     protocol=get_protocol(req),
+                                     phases=getattr(req, 'phases', None),
+                                     span=getattr(req, 'span', None),
+                                     request_id=getattr(req, 'request_id',
+                                                        None),
+                                     body_size=getattr(req, 'body_size',
+                                                       None),
+                                     wire_size=getattr(req, 'wire_size',
+                                                       None),
+                                     endpoint=getattr(req, 'endpoint',
+                                                      None),
+                                     error_body=getattr(req, 'error_body',
+                                                        None),
+                                     family=getattr(req, 'family', None),
+                                     server_timings=getattr(
+                                         req, 'server_timings', None),
+                                     scenario=getattr(self.test,
+                                                      '_testMethodName',
+                                                      None))
@@ -0,0 +1,244 @@
+"""The resources of the system under test, sampled during the run next to
+the times of the requests -- so a report shows the latency and the
+saturation of the servers on the same timeline::
+
+    $ loads-runner example.TestWebSite.test_es -u 50 -d 600 \\
+        --monitor http://web1:9100/metrics --monitor ssh://admin@db1 \\
+        --output file --output-file-filename results.log
+    $ loads-report results.log
+
+Every *--monitor* is a host to sample:
+
+- *http://host:9100/metrics*: the metrics of a Prometheus node exporter
+  running on the host.
+- *ssh://user@host:port*: the */proc* files of a Linux host, read with the
+  *ssh* command -- the key of the user has to be authorized there.
+- *local*: the host running loads-runner, when it is the system under
+  test.
+
+The samples -- taken every *--monitor-interval* seconds, 5 by default --
+give the CPU and the memory used, in percents, and the established TCP
+connections. The first CPU sample of a host comes with the second one,
+since it's the time spent between the two. A host that can't be sampled
+is logged and skipped until the next time.
+"""
+import os
+import re
+import socket
+import subprocess
+import time
+from datetime import datetime
+from urlparse import urlparse
+
+from loads.util import logger
+
+
+DEFAULT_INTERVAL = 5.
+_PROC_FILES = ('/proc/stat', '/proc/meminfo', '/proc/net/snmp')
+_SAMPLE = re.compile(r'^([a-zA-Z_:][\w:]*)(\{[^}]*\})?\s+(\S+)')
+_MODE = re.compile(r'mode="(\w+)"')
+_IDLE = ('idle', 'iowait')
+
+
+def parse_proc(text):
+    """Returns the (busy, total) CPU times, the memory used in percents and
+    the established TCP connections of the content of the */proc/stat*,
+    */proc/meminfo* and */proc/net/snmp* files of a host -- the ones
+    missing being None."""
+    cpu = memory = connections = None
+    meminfo = {}
+    tcp = []
+    for line in text.splitlines():
+        fields = line.split()
+        if not fields:
+            continue
+        if fields[0] == 'cpu':
+            times = [float(value) for value in fields[1:]]
+            # the idle and the iowait times
+            idle = sum(times[3:5])
+            cpu = sum(times) - idle, sum(times)
+        elif fields[0] in ('MemTotal:', 'MemAvailable:'):
+            meminfo[fields[0]] = float(fields[1])
+        elif fields[0] == 'Tcp:':
+            tcp.append(fields[1:])
+
+    if meminfo.get('MemTotal:') and 'MemAvailable:' in meminfo:
+        memory = 100. * (1 - meminfo['MemAvailable:'] / meminfo['MemTotal:'])
+    # a line with the names of the fields, then a line with the values
+    if len(tcp) == 2 and 'CurrEstab' in tcp[0]:
+        connections = int(tcp[1][tcp[0].index('CurrEstab')])
+    return cpu, memory, connections
+
+
+def parse_node_exporter(text):
+    """Like :func:`parse_proc`, on the metrics of a Prometheus node
+    exporter."""
+    busy = total = 0.
+    seen_cpu = False
+    values = {}
+    for line in text.splitlines():
+        match = _SAMPLE.match(line)
+        if match is None:
+            continue
+        name, labels, value = match.groups()
+        try:
+            value = float(value)
+        except ValueError:
+            continue
+        if name == 'node_cpu_seconds_total':
+            seen_cpu = True
+            mode = _MODE.search(labels or '')
+            total += value
+            if mode is None or mode.group(1) not in _IDLE:
+                busy += value
+        else:
+            values[name] = value
+
+    cpu = memory = connections = None
+    if seen_cpu:
+        cpu = busy, total
+    mem_total = values.get('node_memory_MemTotal_bytes')
+    available = values.get('node_memory_MemAvailable_bytes')
+    if mem_total and available is not None:
+        memory = 100. * (1 - available / mem_total)
+    if 'node_netstat_Tcp_CurrEstab' in values:
+        connections = int(values['node_netstat_Tcp_CurrEstab'])
+    return cpu, memory, connections
+
+
+class Sampler(object):
+    """Samples the resources of a host. The subclasses read the CPU times,
+    the memory and the connections with :meth:`read`."""
+
+    def __init__(self, host):
+        self.host = host
+        self._last_cpu = None
+
+    def read(self):
+        raise NotImplementedError()
+
+    def sample(self):
+        """Returns the CPU used since the last sample and the memory used,
+        in percents, and the established connections."""
+        cpu_times, memory, connections = self.read()
+        cpu = None
+        if cpu_times is not None:
+            if self._last_cpu is not None:
+                busy = cpu_times[0] - self._last_cpu[0]
+                total = cpu_times[1] - self._last_cpu[1]
+                if total > 0:
+                    cpu = max(0., min(100., 100. * busy / total))
+            self._last_cpu = cpu_times
+        return {'cpu': cpu, 'memory': memory, 'connections': connections}
+
+
+class LocalSampler(Sampler):
+    """The host running loads-runner."""
+
+    def __init__(self, host=None):
+        super(LocalSampler, self).__init__(host or socket.gethostname())
+
+    def read(self):
+        content = []
+        for filename in _PROC_FILES:
+            if os.path.exists(filename):
+                with open(filename) as f:
+                    content.append(f.read())
+        return parse_proc('\n'.join(content))
+
+
+class SSHSampler(Sampler):
+    """A Linux host, its */proc* files read with the *ssh* command."""
+
+    def __init__(self, host, user=None, port=None, timeout=10):
+        super(SSHSampler, self).__init__(host)
+        self.command = ['ssh', '-o', 'BatchMode=yes', '-o',
+                        'ConnectTimeout=%d' % timeout]
+        if port is not None:
+            self.command.extend(['-p', str(port)])
+        target = user and '%s@%s' % (user, host) or host
+        self.command.extend([target, 'cat'] + list(_PROC_FILES))
+
+    def read(self):
+        proc = subprocess.Popen(self.command, stdout=subprocess.PIPE,
+                                stderr=subprocess.PIPE)
+        out, err = proc.communicate()
+        if proc.returncode != 0:
+            raise IOError(err.strip() or 'ssh exited with %d' %
+                          proc.returncode)
+        return parse_proc(out)
+
+
+class PrometheusSampler(Sampler):
+    """A host running a Prometheus node exporter."""
+
+    def __init__(self, url, timeout=10):
+        super(PrometheusSampler, self).__init__(urlparse(url).hostname)
+        self.url = url
+        self.timeout = timeout
+
+    def read(self):
+        import requests
+        res = requests.get(self.url, timeout=self.timeout)
+        res.raise_for_status()
+        return parse_node_exporter(res.text)
+
+
+def get_sampler(target):
+    """Returns the sampler of a *--monitor* target."""
+    if target == 'local':
+        return LocalSampler()
+    url = urlparse(target)
+    if url.scheme in ('http', 'https'):
+        return PrometheusSampler(target)
+    if url.scheme == 'ssh' and url.hostname:
+        return SSHSampler(url.hostname, url.username, url.port)
+    raise ValueError('Unknown monitor %r' % target)
+
+
+def parse_monitor(value):
+    """Checks a *--monitor* target, sent as it is to the runner."""
+    get_sampler(value)
+    return value
+
+
+class Monitor(object):
+    """Samples the hosts and adds their resources to the results.
+
+    :param samplers: the samplers of the hosts.
+    :param test_result: the results of the run.
+    :param interval: the seconds between two samples of a host.
+    """
+    def __init__(self, samplers, test_result, interval=DEFAULT_INTERVAL):
+        self.samplers = samplers
+        self.test_result = test_result
+        self.interval = interval
+
+    def sample(self):
+        """Samples every host once."""
+        for sampler in self.samplers:
+            try:
+                resources = sampler.sample()
+            except Exception, e:
+                logger.warning('Could not sample %s: %s' % (sampler.host, e))
+                continue
+            self.test_result.add_resources(sampler.host,
+                                           started=datetime.utcnow(),
+                                           **resources)
+
+    def run(self, sleep=time.sleep):
+        """Samples the hosts forever -- in a greenlet."""
+        while True:
+            self.sample()
+            sleep(self.interval)
+
+
+def get_monitor(config, test_result):
+    """Returns the :class:`Monitor` of the *--monitor* targets of a run, or
+    None."""
+    targets = config.get('monitor')
+    if not targets:
+        return None
+    interval = config.get('monitor_interval') or DEFAULT_INTERVAL
+    return Monitor([get_sampler(target) for target in targets], test_result,
+                   float(interval))
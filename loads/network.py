@@ -0,0 +1,188 @@
+"""The network conditions of the virtual users: *--network* gives them the
+latency, the jitter, the bandwidth and the packet loss of a profile --
+several profiles are used in turn by the users -- and *--latency*,
+*--jitter*, *--bandwidth* and *--packet-loss* set or override them::
+
+    $ loads-runner example.TestWebSite.test_es -u 20 --network 3g,4g
+    $ loads-runner example.TestWebSite.test_es --latency 80ms --jitter 10ms
+
+The conditions are emulated by the client, so a single fleet can
+approximate mobile or cross-region clients without shaping its network:
+
+- the latency -- give or take the jitter -- is added to every HTTP request
+  and TCP message, and to every UDP datagram.
+- the bandwidth, the same both ways, spaces the bytes sent and received by
+  every user.
+- the packet loss drops the UDP datagrams, and delays the TCP messages by a
+  retransmission. The HTTP requests are not dropped.
+
+The time added is part of the elapsed time of the hits, like it would be on
+a real network.
+"""
+import itertools
+import random
+import re
+import threading
+import time
+
+from loads.util import parse_duration
+
+
+# name: (latency, jitter, bandwidth in bytes per second, packet loss)
+PROFILES = {'edge': (.65, .1, 240000 / 8, .02),
+            '3g': (.3, .05, 1600000 / 8, .01),
+            '4g': (.07, .02, 12000000 / 8, .001),
+            'wifi': (.01, .005, 30000000 / 8, 0.),
+            'cross-region': (.15, .01, None, 0.)}
+
+# the delay of a lost TCP segment, the minimal retransmission timeout of
+# Linux
+RETRANSMISSION_DELAY = .2
+
+_BANDWIDTH = re.compile(r'^([\d.]+)\s*([kmg]?)(bit|bps)?$', re.I)
+_UNITS = {'': 1, 'k': 1000, 'm': 1000 ** 2, 'g': 1000 ** 3}
+
+_CYCLES = {}
+_LOCK = threading.Lock()
+
+
+def parse_delay(value):
+    """Converts a delay like "150ms" or "0.2s" in seconds -- milliseconds
+    without a unit."""
+    value = str(value).strip().lower()
+    if value.endswith('ms'):
+        value = value[:-2]
+    elif value and not value[-1].isdigit():
+        return parse_duration(value)
+    return float(value) / 1000
+
+
+def parse_bandwidth(value):
+    """Converts a bandwidth like "750kbit" or "1.5mbit" in bytes per second
+    -- bits per second without a unit."""
+    match = _BANDWIDTH.match(str(value).strip())
+    if match is None:
+        raise ValueError('Invalid bandwidth %r' % value)
+    bits = float(match.group(1)) * _UNITS[match.group(2).lower()]
+    if bits <= 0:
+        raise ValueError('Invalid bandwidth %r' % value)
+    return bits / 8
+
+
+def parse_loss(value):
+    """Converts a packet loss like "2%" or "0.02" in a ratio."""
+    value = str(value).strip()
+    if value.endswith('%'):
+        loss = float(value[:-1]) / 100
+    else:
+        loss = float(value)
+    if not 0 <= loss <= 1:
+        raise ValueError('Invalid packet loss %r' % value)
+    return loss
+
+
+def parse_profiles(value):
+    """Checks a comma-separated list of profiles."""
+    profiles = [name.strip() for name in value.split(',') if name.strip()]
+    for name in profiles:
+        if name not in PROFILES:
+            raise ValueError('Unknown network profile %r' % name)
+    if not profiles:
+        raise ValueError('No network profile')
+    return profiles
+
+
+class NetworkConditions(object):
+    """The network of a virtual user.
+
+    :param latency: the seconds added to every exchange.
+    :param jitter: the seconds the latency varies by, both ways.
+    :param bandwidth: the bytes per second -- not limited when None.
+    :param loss: the ratio of the packets lost.
+    :param rand: the random generator -- one per user by default.
+    :param clock: returns the current time.
+    :param sleep: waits for some seconds -- gevent.sleep by default.
+    """
+    def __init__(self, latency=0., jitter=0., bandwidth=None, loss=0.,
+                 rand=None, clock=time.time, sleep=None):
+        self.latency = latency or 0.
+        self.jitter = jitter or 0.
+        self.bandwidth = bandwidth or None
+        self.loss = loss or 0.
+        self.random = rand or random.Random()
+        self.clock = clock
+        self._sleep = sleep
+        # when the bytes already sent or received are through
+        self._free_at = None
+
+    def delay(self):
+        """Returns the latency of an exchange, with its jitter."""
+        if not self.jitter:
+            return self.latency
+        return max(self.latency + self.random.uniform(-self.jitter,
+                                                      self.jitter), 0.)
+
+    def transfer(self, size):
+        """Returns the seconds before :param size: bytes are through,
+        after the ones already on their way."""
+        if self.bandwidth is None or not size:
+            return 0.
+        now = self.clock()
+        if self._free_at is None or self._free_at < now:
+            self._free_at = now
+        self._free_at += size / float(self.bandwidth)
+        return self._free_at - now
+
+    def lost(self):
+        """Tells whether a packet is lost."""
+        return self.loss > 0 and self.random.random() < self.loss
+
+    def wait(self, size=0, latency=True):
+        """Waits for the transfer of :param size: bytes, and for the latency
+        when :param latency: is True. Returns the seconds waited."""
+        seconds = self.transfer(size)
+        if latency:
+            seconds += self.delay()
+        if seconds > 0:
+            self.sleep(seconds)
+        return seconds
+
+    def sleep(self, seconds):
+        if self._sleep is not None:
+            return self._sleep(seconds)
+        import gevent
+        gevent.sleep(seconds)
+
+
+def _get_cycle(profiles):
+    key = tuple(profiles)
+    with _LOCK:
+        if key not in _CYCLES:
+            _CYCLES[key] = itertools.cycle(key)
+        return _CYCLES[key]
+
+
+def get_network(config, rand=None):
+    """Returns the network conditions of a new virtual user, or None when
+    there are none.
+
+    The users get the profiles of *--network* in turn, and the options
+    override the values of the profiles. :param rand: is the generator of
+    the user -- see :mod:`loads.seeds`.
+    """
+    profiles = config.get('network')
+    options = (config.get('latency'), config.get('jitter'),
+               config.get('bandwidth'), config.get('packet_loss'))
+    if not profiles and options == (None,) * 4:
+        return None
+
+    values = [0., 0., None, 0.]
+    if profiles:
+        cycle = _get_cycle(profiles)
+        with _LOCK:
+            name = cycle.next()
+        values = list(PROFILES[name])
+    for index, value in enumerate(options):
+        if value is not None:
+            values[index] = value
+    return NetworkConditions(*values, rand=rand)
@@ -1,5 +1,9 @@
 from loads.observers._irc import IRCObserver as irc
 from loads.observers._email import EMailObserver as email
+from loads.observers._slack import SlackObserver as slack
+from loads.observers._webhook import WebhookObserver as webhook
+from loads.observers._paging import PagerDutyObserver as pagerduty
+from loads.observers._paging import OpsgenieObserver as opsgenie
 
 
-observers = (irc, email)
+observers = (irc, email, slack, webhook, pagerduty, opsgenie)
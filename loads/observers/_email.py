@@ -1,9 +1,24 @@
+"""Mails the results of the runs, with their summary in the body and
+their HTML report attached -- including the results of their thresholds,
+on the whole run and on every scenario::
+
+    $ loads-runner example.TestWebSite.test_es --agents 4 -d 600 \\
+        --threshold "p95 < 250ms" --observer email \\
+        --observer-email-recipient team@example.com
+
+The report is built from the results kept by the broker -- the run of a
+database without them only gets its summary.
+"""
+from email.mime.multipart import MIMEMultipart
 from email.mime.text import MIMEText
 from email.header import Header
 from rfc822 import AddressList
 import smtplib
 
-from loads.util import logger
+from loads.report import (Report, from_records, get_threshold_results,
+                          render)
+from loads.thresholds import parse_thresholds
+from loads.util import logger, seconds_to_time
 
 
 class EMailObserver(object):
@@ -16,7 +31,10 @@ class EMailObserver(object):
                {'name': 'port', 'type': int, 'default': 25},
                {'name': 'user', 'type': str, 'default': None},
                {'name': 'password', 'type': str, 'default': None},
-               {'name': 'subject', 'type': str, 'default': 'Loads Results'}]
+               {'name': 'subject', 'type': str, 'default': 'Loads Results'},
+               {'name': 'no_report', 'action': 'store_true',
+                'default': False,
+                'help': 'Do not attach the HTML report'}]
 
     def _normalize_realname(self, field):
         address = AddressList(field).addresslist
@@ -28,7 +46,8 @@ class EMailObserver(object):
 
     def __init__(self, sender='tarek@mozilla.com', host='localhost', port=25,
                  user=None, password=None, subject='Loads Results',
-                 recipient='tarek@mozilla.com', **kw):
+                 recipient='tarek@mozilla.com', no_report=False, args=None,
+                 **kw):
         self.subject = subject
         self.sender = sender
         self.host = host
@@ -36,13 +55,63 @@ class EMailObserver(object):
         self.user = user
         self.password = password
         self.recipient = recipient
+        self.attach_report = not no_report
+        self.args = args or {}
+        self.records = None
 
-    def __call__(self, test_results):
-        # XXX we'll add more details in the mail later
-        msg = 'Test over. %s' % str(test_results)
-        body = msg
+    def set_records(self, records):
+        """Gets the results of the run, for its report."""
+        self.records = list(records)
+
+    def get_body(self, test_results, thresholds=None):
+        """Returns the summary of the run."""
+        lines = ['Test over. %s' % str(test_results)]
+        if not isinstance(test_results, basestring):
+            lines.append('')
+            for label, value in (
+                    ('Tests', test_results.nb_finished_tests),
+                    ('Successes', test_results.nb_success),
+                    ('Failures', test_results.nb_failures),
+                    ('Errors', test_results.nb_errors),
+                    ('Hits', test_results.nb_hits),
+                    ('Duration', seconds_to_time(test_results.duration))):
+                lines.append('%-10s %s' % (label, value))
+
+        if thresholds:
+            lines.extend(['', 'Thresholds:'])
+            for scenario, threshold, value, passed in thresholds:
+                lines.append('  [%s] %s: %s (%s)' % (
+                    passed and 'passed' or 'FAILED', scenario, threshold,
+                    threshold.format(value)))
+        return '\n'.join(lines)
 
-        msg = MIMEText(body.encode('utf-8'), 'plain', 'utf8')
+    def get_message(self, test_results):
+        """Returns the mail of the run."""
+        thresholds = parse_thresholds(self.args.get('threshold'))
+        if self.records is not None and thresholds:
+            thresholds = get_threshold_results(from_records(self.records),
+                                               thresholds)
+        else:
+            thresholds = None
+
+        body = self.get_body(test_results, thresholds)
+        text = MIMEText(body.encode('utf-8'), 'plain', 'utf8')
+        if self.records is None or not self.attach_report:
+            return text
+
+        report = Report(from_records(self.records))
+        title = 'Loads report of %s' % (self.args.get('fqn') or 'the run')
+        html = MIMEText(render(report, title, thresholds).encode('utf-8'),
+                        'html', 'utf8')
+        html.add_header('Content-Disposition', 'attachment',
+                        filename='report.html')
+        msg = MIMEMultipart()
+        msg.attach(text)
+        msg.attach(html)
+        return msg
+
+    def __call__(self, test_results):
+        msg = self.get_message(test_results)
 
         msg['From'] = self._normalize_realname(self.sender)
         msg['To'] = self._normalize_realname(self.recipient)
@@ -0,0 +1,193 @@
+"""Pages the on-call when a run breaches one of its thresholds while it
+runs -- like in the middle of the night of a soak test -- through the
+Events API of PagerDuty or the Alert API of Opsgenie::
+
+    $ loads-runner example.TestWebSite.test_soak --agents 4 -d 28800 \\
+        --threshold "p95 < 250ms" --threshold "error_rate < 1%" \\
+        --observer pagerduty --observer-pagerduty-routing-key KEY
+
+    $ loads-runner example.TestWebSite.test_soak --agents 4 -d 28800 \\
+        --threshold "p95 < 250ms" \\
+        --observer opsgenie --observer-opsgenie-api-key KEY
+
+Every breach is one alert, deduplicated on the run and the threshold:
+the broker pages once per threshold, and the retries or the other
+brokers of the run don't page twice. With *--observer-pagerduty-resolve*
+or *--observer-opsgenie-close*, the alerts of a run are resolved when
+it's over.
+"""
+import threading
+import urllib
+
+import requests
+
+from loads.util import json, logger
+
+
+def get_dedup_key(run_id, threshold):
+    """Returns the key the alerts of a breach are deduplicated on."""
+    return 'loads-%s-%s' % (run_id, threshold.replace(' ', ''))
+
+
+class _Pager(object):
+    """Posts the alerts from a thread."""
+
+    def __init__(self, timeout=5., args=None):
+        self.timeout = timeout
+        self.args = args or {}
+
+    def _describe(self, run_id):
+        return '%s (%s)' % (self.args.get('fqn') or '?', run_id)
+
+    def _details(self, run_id, threshold=None, value=None):
+        details = {'run_id': run_id, 'fqn': self.args.get('fqn'),
+                   'project': self.args.get('project_name'),
+                   'agents': self.args.get('agents'),
+                   'users': self.args.get('users')}
+        if threshold is not None:
+            details['threshold'] = threshold
+            details['value'] = value
+        return details
+
+    def _send(self, url, payload, headers):
+        headers = dict(headers, **{'Content-Type': 'application/json'})
+        try:
+            response = requests.post(url, data=json.dumps(payload),
+                                     headers=headers, timeout=self.timeout)
+        except requests.RequestException, e:
+            logger.error('Could not page on %s: %s' % (url, e))
+            return
+        if not 200 <= response.status_code < 300:
+            logger.error('%s answered %d: %s' % (url, response.status_code,
+                                                 response.text))
+
+    def _post(self, url, payload, headers=None):
+        thread = threading.Thread(target=self._send,
+                                  args=(url, payload, headers or {}))
+        thread.daemon = True
+        thread.start()
+        return thread
+
+    def _resolve(self, run_id, threshold):
+        raise NotImplementedError()
+
+    def __call__(self, test_results):
+        # only the alerts of the breached thresholds are closed
+        if not self.resolve:
+            return []
+        run_id = self.args.get('run_id')
+        return [self._resolve(run_id, threshold)
+                for threshold in self.args.get('breached') or []]
+
+
+class PagerDutyObserver(_Pager):
+    name = 'pagerduty'
+    options = [{'name': 'routing_key', 'type': str, 'default': None,
+                'help': 'The integration key of the PagerDuty service'},
+               {'name': 'severity', 'type': str, 'default': 'error',
+                'help': 'The severity of the alerts: critical, error, '
+                        'warning or info'},
+               {'name': 'resolve', 'action': 'store_true',
+                'default': False,
+                'help': 'Resolve the alerts of a run when it is over'},
+               {'name': 'url', 'type': str,
+                'default': 'https://events.pagerduty.com/v2/enqueue',
+                'help': 'The URL of the Events API'},
+               {'name': 'timeout', 'type': float, 'default': 5.,
+                'help': 'The seconds a post can take'}]
+
+    SEVERITIES = ('critical', 'error', 'warning', 'info')
+
+    def __init__(self, routing_key=None, severity='error', resolve=False,
+                 url='https://events.pagerduty.com/v2/enqueue', timeout=5.,
+                 args=None, **kw):
+        super(PagerDutyObserver, self).__init__(timeout, args)
+        if severity not in self.SEVERITIES:
+            raise ValueError('Unknown severity %r' % severity)
+        self.routing_key = routing_key
+        self.severity = severity
+        self.resolve = resolve
+        self.url = url
+
+    def _event(self, action, run_id, threshold):
+        return {'routing_key': self.routing_key, 'event_action': action,
+                'dedup_key': get_dedup_key(run_id, threshold)}
+
+    def threshold_breached(self, run_id, threshold, value):
+        if self.routing_key is None:
+            logger.error('The pagerduty observer needs a routing key')
+            return None
+        event = self._event('trigger', run_id, threshold)
+        event['payload'] = {
+            'summary': 'Run %s breached %s: %s' % (self._describe(run_id),
+                                                   threshold, value),
+            'source': 'loads',
+            'severity': self.severity,
+            'component': self.args.get('fqn'),
+            'group': self.args.get('project_name'),
+            'custom_details': self._details(run_id, threshold, value)}
+        return self._post(self.url, event)
+
+    def _resolve(self, run_id, threshold):
+        return self._post(self.url, self._event('resolve', run_id,
+                                                threshold))
+
+
+class OpsgenieObserver(_Pager):
+    name = 'opsgenie'
+    options = [{'name': 'api_key', 'type': str, 'default': None,
+                'help': 'The API key of the Opsgenie integration'},
+               {'name': 'priority', 'type': str, 'default': 'P2',
+                'help': 'The priority of the alerts, from P1 to P5'},
+               {'name': 'responders', 'type': str, 'default': None,
+                'help': 'The teams paged, comma-separated -- the ones of '
+                        'the integration by default'},
+               {'name': 'close', 'action': 'store_true', 'default': False,
+                'help': 'Close the alerts of a run when it is over'},
+               {'name': 'url', 'type': str,
+                'default': 'https://api.opsgenie.com/v2/alerts',
+                'help': 'The URL of the Alert API'},
+               {'name': 'timeout', 'type': float, 'default': 5.,
+                'help': 'The seconds a post can take'}]
+
+    PRIORITIES = ('P1', 'P2', 'P3', 'P4', 'P5')
+
+    def __init__(self, api_key=None, priority='P2', responders=None,
+                 close=False, url='https://api.opsgenie.com/v2/alerts',
+                 timeout=5., args=None, **kw):
+        super(OpsgenieObserver, self).__init__(timeout, args)
+        if priority not in self.PRIORITIES:
+            raise ValueError('Unknown priority %r' % priority)
+        self.api_key = api_key
+        self.priority = priority
+        self.responders = [team.strip() for team in
+                           (responders or '').split(',') if team.strip()]
+        self.resolve = close
+        self.url = url.rstrip('/')
+
+    @property
+    def _headers(self):
+        return {'Authorization': 'GenieKey %s' % self.api_key}
+
+    def threshold_breached(self, run_id, threshold, value):
+        if self.api_key is None:
+            logger.error('The opsgenie observer needs an API key')
+            return None
+        details = self._details(run_id, threshold, value)
+        alert = {'message': 'Run %s breached %s' % (self._describe(run_id),
+                                                    threshold),
+                 'alias': get_dedup_key(run_id, threshold),
+                 'description': 'The value of the metric is %s' % value,
+                 'priority': self.priority, 'source': 'loads',
+                 'tags': ['loads'],
+                 'details': dict([(key, str(item)) for key, item
+                                  in details.items() if item is not None])}
+        if self.responders:
+            alert['responders'] = [{'name': team, 'type': 'team'}
+                                   for team in self.responders]
+        return self._post(self.url, alert, self._headers)
+
+    def _resolve(self, run_id, threshold):
+        url = '%s/%s/close?identifierType=alias' % (
+            self.url, urllib.quote(get_dedup_key(run_id, threshold), safe=''))
+        return self._post(url, {'source': 'loads'}, self._headers)
@@ -0,0 +1,118 @@
+"""Posts the runs of the broker on Slack, through an incoming webhook:
+when they start, when they breach one of their thresholds, and when they
+are over, with a summary table::
+
+    $ loads-runner example.TestWebSite.test_es --agents 4 -d 600 \\
+        --threshold "p95 < 250ms" --observer slack \\
+        --observer-slack-webhook https://hooks.slack.com/services/...
+
+The broker can also post every run, with the same options::
+
+    $ loads-broker --observer slack \\
+        --observer-slack-webhook https://hooks.slack.com/services/...
+
+The messages are posted from a thread, so a slow Slack never holds the
+broker.
+"""
+import threading
+
+import requests
+
+from loads.util import json, logger, seconds_to_time
+
+
+class SlackObserver(object):
+    name = 'slack'
+    options = [{'name': 'webhook', 'type': str, 'default': None,
+                'help': 'The URL of the incoming webhook of Slack'},
+               {'name': 'channel', 'type': str, 'default': None,
+                'help': 'The channel to post in, instead of the one of '
+                        'the webhook'},
+               {'name': 'username', 'type': str, 'default': 'loads',
+                'help': 'The name of the poster'},
+               {'name': 'timeout', 'type': float, 'default': 5.,
+                'help': 'The seconds a post can take'}]
+
+    def __init__(self, webhook=None, channel=None, username='loads',
+                 timeout=5., args=None, **kw):
+        self.webhook = webhook
+        self.channel = channel
+        self.username = username
+        self.timeout = timeout
+        self.args = args or {}
+
+    def _describe(self, run_id):
+        description = '%s (%s)' % (self.args.get('fqn') or '?', run_id)
+        project = self.args.get('project_name')
+        if project and project != 'N/A':
+            description += ' of %s' % project
+        return description
+
+    def _send(self, payload):
+        try:
+            response = requests.post(self.webhook, data=json.dumps(payload),
+                                     headers={'Content-Type':
+                                              'application/json'},
+                                     timeout=self.timeout)
+        except requests.RequestException, e:
+            logger.error('Could not post on Slack: %s' % e)
+            return
+        if response.status_code != 200:
+            logger.error('Slack answered %d: %s' % (response.status_code,
+                                                    response.text))
+
+    def post(self, text):
+        """Posts the text from a thread, and returns the thread -- or None
+        without a webhook."""
+        if self.webhook is None:
+            logger.error('The slack observer needs a webhook')
+            return None
+        payload = {'text': text, 'username': self.username}
+        if self.channel is not None:
+            payload['channel'] = self.channel
+        thread = threading.Thread(target=self._send, args=(payload,))
+        thread.daemon = True
+        thread.start()
+        return thread
+
+    def run_started(self, run_id):
+        load = []
+        for option, label in (('users', 'users'), ('agents', 'agents'),
+                              ('duration', 'seconds'), ('hits', 'hits')):
+            value = self.args.get(option)
+            if isinstance(value, list):
+                value = ':'.join([str(item) for item in value])
+            if value is not None:
+                load.append('%s %s' % (value, label))
+        text = 'Run %s started' % self._describe(run_id)
+        if load:
+            text += ': %s' % ', '.join(load)
+        return self.post(text)
+
+    def threshold_breached(self, run_id, threshold, value):
+        return self.post('Run %s breached its threshold *%s*: %s' % (
+            self._describe(run_id), threshold, value))
+
+    def __call__(self, test_results):
+        run_id = self.args.get('run_id') or getattr(test_results, 'run_id',
+                                                    None)
+        text = 'Run %s is over' % self._describe(run_id)
+        if isinstance(test_results, basestring):
+            # the link to the results on the web dashboard
+            return self.post('%s: %s' % (text, test_results))
+
+        duration = test_results.duration
+        rows = [('Tests', test_results.nb_finished_tests),
+                ('Successes', test_results.nb_success),
+                ('Failures', test_results.nb_failures),
+                ('Errors', test_results.nb_errors),
+                ('Hits', test_results.nb_hits),
+                ('RPS', '%.2f' % (duration and
+                                  test_results.nb_hits / duration or 0)),
+                ('Duration', seconds_to_time(duration))]
+        breached = self.args.get('breached')
+        if breached:
+            rows.append(('Breached', ', '.join(breached)))
+
+        table = '\n'.join(['%-10s %s' % row for row in rows])
+        return self.post('%s\n```\n%s\n```' % (text, table))
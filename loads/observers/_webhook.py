@@ -0,0 +1,198 @@
+"""Posts the events of the runs to any URL, with a body rendered from a
+template -- for the incident and the reporting systems Loads knows
+nothing about::
+
+    $ loads-runner example.TestWebSite.test_es --agents 4 -d 600 \\
+        --observer webhook --observer-webhook-url https://hooks/loads \\
+        --observer-webhook-template @payload.json \\
+        --observer-webhook-secret s3cr3t
+
+The template -- or the file after its *@* -- has the syntax of
+:mod:`loads.templates`, with the values of the event in *event.NAME*, and
+*json* in front of a value to get it in JSON::
+
+    {"text": "{{event.fqn}} {{event.name}}", "errors": {{json event.errors}},
+     "at": "{{now}}"}
+
+The events are *started*, *breached* -- a threshold of the run failed
+while it runs -- and *ended*, and their values:
+
+- **name**, **run_id**, **fqn**, **project**, **users**, **agents**,
+  **duration**: the event and its run.
+- **threshold**, **value**: the breached threshold, and the value of its
+  metric.
+- **tests**, **successes**, **failures**, **errors**, **hits**, **rps**,
+  **elapsed**, **breached**: the results of an ended run -- or **link**,
+  their page on the web dashboard.
+
+An event without template gets all of them, in a JSON object. With a
+secret, the body is signed in the *X-Loads-Signature* header:
+*sha256=* and the hexadecimal HMAC-SHA256 of the body.
+"""
+import hashlib
+import hmac
+import threading
+
+import requests
+
+from loads.templates import Context, TemplateError, _TEMPLATE, evaluate
+from loads.util import json, logger
+
+
+EVENTS = ('started', 'breached', 'ended')
+
+_VALUES = ('name', 'run_id', 'fqn', 'project', 'users', 'agents',
+           'duration', 'threshold', 'value', 'tests', 'successes',
+           'failures', 'errors', 'hits', 'rps', 'elapsed', 'breached',
+           'link')
+
+
+class _EventContext(Context):
+
+    def __init__(self, event):
+        super(_EventContext, self).__init__()
+        self.event = event
+
+    def get(self, namespace, key):
+        if namespace != 'event':
+            return super(_EventContext, self).get(namespace, key)
+        if key not in self.event:
+            raise TemplateError('No %r in the events' % key)
+        return self.event[key]
+
+
+def render_payload(template, event):
+    """Renders the template of a body with the values of an event."""
+    context = _EventContext(event)
+
+    def _render(match):
+        expression = match.group(1)
+        if expression.startswith('json '):
+            return json.dumps(evaluate(expression[5:], context))
+        value = evaluate(expression, context)
+        if value is None:
+            return ''
+        if isinstance(value, unicode):
+            return value.encode('utf8')
+        return str(value)
+
+    return _TEMPLATE.sub(_render, template)
+
+
+def sign(secret, body):
+    """Returns the signature of a body."""
+    return 'sha256=' + hmac.new(secret, body, hashlib.sha256).hexdigest()
+
+
+class WebhookObserver(object):
+    name = 'webhook'
+    options = [{'name': 'url', 'type': str, 'default': None,
+                'help': 'The URLs the events are posted to, '
+                        'comma-separated'},
+               {'name': 'template', 'type': str, 'default': None,
+                'help': 'The template of the bodies, or @ and the file '
+                        'of the template'},
+               {'name': 'secret', 'type': str, 'default': None,
+                'help': 'The secret the bodies are signed with'},
+               {'name': 'events', 'type': str,
+                'default': ','.join(EVENTS),
+                'help': 'The events posted, comma-separated'},
+               {'name': 'content_type', 'type': str,
+                'default': 'application/json',
+                'help': 'The content type of the bodies'},
+               {'name': 'timeout', 'type': float, 'default': 5.,
+                'help': 'The seconds a post can take'}]
+
+    def __init__(self, url=None, template=None, secret=None,
+                 events=','.join(EVENTS), content_type='application/json',
+                 timeout=5., args=None, **kw):
+        self.urls = [item.strip() for item in (url or '').split(',')
+                     if item.strip()]
+        if template is not None and template.startswith('@'):
+            with open(template[1:]) as f:
+                template = f.read()
+        self.template = template
+        self.secret = secret
+        self.events = [event.strip() for event in events.split(',')]
+        unknown = set(self.events) - set(EVENTS)
+        if unknown:
+            raise ValueError('Unknown events: %s' % ', '.join(unknown))
+        self.content_type = content_type
+        self.timeout = timeout
+        self.args = args or {}
+
+    def _get_event(self, name, run_id, **values):
+        event = dict([(value, None) for value in _VALUES])
+        project = self.args.get('project_name')
+        event.update({'name': name, 'run_id': run_id,
+                      'fqn': self.args.get('fqn'),
+                      'project': project != 'N/A' and project or None,
+                      'users': self.args.get('users'),
+                      'agents': self.args.get('agents'),
+                      'duration': self.args.get('duration')})
+        event.update(values)
+        return event
+
+    def _send(self, url, body, headers):
+        try:
+            response = requests.post(url, data=body, headers=headers,
+                                     timeout=self.timeout)
+        except requests.RequestException, e:
+            logger.error('Could not post on %s: %s' % (url, e))
+            return
+        if not 200 <= response.status_code < 300:
+            logger.error('%s answered %d' % (url, response.status_code))
+
+    def post(self, event):
+        """Posts the event to every URL from a thread, and returns the
+        threads -- none when the event is not posted."""
+        if event['name'] not in self.events:
+            return []
+        if not self.urls:
+            logger.error('The webhook observer needs an URL')
+            return []
+
+        if self.template is None:
+            body = json.dumps(event)
+        else:
+            body = render_payload(self.template, event)
+
+        headers = {'Content-Type': self.content_type,
+                   'X-Loads-Event': event['name']}
+        if self.secret is not None:
+            headers['X-Loads-Signature'] = sign(self.secret, body)
+
+        threads = []
+        for url in self.urls:
+            thread = threading.Thread(target=self._send,
+                                      args=(url, body, headers))
+            thread.daemon = True
+            thread.start()
+            threads.append(thread)
+        return threads
+
+    def run_started(self, run_id):
+        return self.post(self._get_event('started', run_id))
+
+    def threshold_breached(self, run_id, threshold, value):
+        return self.post(self._get_event('breached', run_id,
+                                         threshold=threshold, value=value))
+
+    def __call__(self, test_results):
+        run_id = self.args.get('run_id')
+        breached = self.args.get('breached')
+        if isinstance(test_results, basestring):
+            # the link to the results on the web dashboard
+            return self.post(self._get_event('ended', run_id,
+                                             link=test_results,
+                                             breached=breached))
+
+        elapsed = test_results.duration
+        hits = test_results.nb_hits
+        return self.post(self._get_event(
+            'ended', run_id, tests=test_results.nb_finished_tests,
+            successes=test_results.nb_success,
+            failures=test_results.nb_failures,
+            errors=test_results.nb_errors, hits=hits,
+            rps=elapsed and hits / elapsed or 0., elapsed=elapsed,
+            breached=breached))
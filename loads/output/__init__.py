@@ -21,6 +21,15 @@ from loads.output.null import NullOutput
 from loads.output._file import FileOutput
 from loads.output.std import StdOutput
 from loads.output._funkload import FunkloadOutput
-
-for output in (NullOutput, FileOutput, StdOutput, FunkloadOutput):
+from loads.output._histogram import HistogramOutput
+from loads.output._influxdb import InfluxDBOutput
+from loads.output._statsd import StatsDOutput
+from loads.output._otlp import OTLPOutput
+from loads.output._junit import JUnitOutput
+from loads.output._jsonl import JSONLinesOutput
+from loads.output._jsonstream import JSONStreamOutput
+
+for output in (NullOutput, FileOutput, StdOutput, FunkloadOutput,
+               HistogramOutput, InfluxDBOutput, StatsDOutput, OTLPOutput,
+               JUnitOutput, JSONLinesOutput, JSONStreamOutput):
     register_output(output)
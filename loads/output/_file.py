@@ -1,8 +1,17 @@
+import traceback
+
 from loads.util import DateTimeJSONEncoder
 
 
+_TEST_EVENTS = ('startTest', 'stopTest', 'addSuccess', 'addFailure',
+                'addError')
+
+
 class FileOutput(object):
-    """A output writing to a file."""
+    """A output writing to a file, one "method - JSON data" line per call.
+
+    The file can be turned into a HTML report with loads-report.
+    """
     name = 'file'
     options = {'filename': ('Filename', str, None, True)}
 
@@ -13,8 +22,26 @@ class FileOutput(object):
         self.encoder = DateTimeJSONEncoder()
         self.fd = open(self.filename, 'a+')
 
+    def _get_test_data(self, called_method, args, data):
+        # the local runs pass the test and the exception as objects: they
+        # are written like the agents send them.
+        data = dict(data)
+        if args and 'test' not in data:
+            data['test'] = str(args[0])
+        if len(args) > 1 and 'loads_status' not in data:
+            data['loads_status'] = args[-1]
+        if (called_method in ('addFailure', 'addError') and len(args) > 1
+                and 'exc_info' not in data):
+            exc_class, exc, tb = args[1]
+            data['exc_info'] = (str(exc_class), str(exc),
+                                ''.join(traceback.format_tb(tb)))
+        return data
+
     def push(self, called_method, *args, **data):
+        if called_method in _TEST_EVENTS:
+            data = self._get_test_data(called_method, args, data)
         self.fd.write(' - '.join((called_method, self.encoder.encode(data))))
+        self.fd.write('\n')
 
     def flush(self):
         self.fd.close()
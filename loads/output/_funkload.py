@@ -135,7 +135,11 @@ class FunkloadOutput(object):
                 self.nodes.append(_CONFIG.format(key=key, value=value))
 
     def add_hit(self, loads_status=None, started=0, elapsed=0, url='',
-                method="GET", status=200, agent_id=None, _RESPONSE=_RESPONSE):
+                method="GET", status=200, agent_id=None, protocol=None,
+                phases=None, span=None, request_id=None, scenario=None,
+                body_size=None, wire_size=None, endpoint=None,
+                error_body=None, family=None,
+                _RESPONSE=_RESPONSE):
         """Generates a funkload XML item with the data coming from the request.
 
         Adds the new XML node to the list of nodes for this output.
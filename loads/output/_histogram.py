@@ -0,0 +1,34 @@
+import json
+
+from loads.results.base import HISTOGRAM_INTERVAL
+
+
+class HistogramOutput(object):
+    """Writes the histograms of the request times to a JSON file.
+
+    The histograms are not corrected for the coordinated omission, so the
+    ones of several runs or agents can be merged with
+    :meth:`loads.histogram.Histogram.add`.
+    """
+    name = 'histogram'
+    options = {'filename': ('Filename', str, None, True)}
+
+    def __init__(self, test_result, args):
+        self.test_result = test_result
+        self.filename = args['output_histogram_filename']
+
+    def push(self, called_method, *args, **data):
+        pass
+
+    def flush(self):
+        intervals = [{'start': start.isoformat(),
+                      'histogram': histogram.to_dict()}
+                     for start, histogram
+                     in self.test_result.get_interval_histograms()]
+
+        data = {'interval': HISTOGRAM_INTERVAL,
+                'histogram': self.test_result.get_histogram().to_dict(),
+                'intervals': intervals}
+
+        with open(self.filename, 'w') as f:
+            json.dump(data, f)
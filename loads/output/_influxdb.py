@@ -0,0 +1,118 @@
+import requests
+
+from loads.output._interval import (IntervalOutput, _get_seconds,
+                                    _get_timestamp)
+
+
+def _escape(value):
+    value = str(value)
+    for char in ('\\', ',', ' ', '='):
+        value = value.replace(char, '\\' + char)
+    return value
+
+
+def _format_line(measurement, tags, fields, when):
+    """Returns a line of the InfluxDB line protocol, with a timestamp in
+    milliseconds."""
+    line = _escape(measurement)
+    for name, value in sorted(tags.items()):
+        line += ',%s=%s' % (_escape(name), _escape(value))
+
+    values = []
+    for name, value in sorted(fields.items()):
+        if isinstance(value, bool):
+            value = value and 'true' or 'false'
+        elif isinstance(value, (int, long)):
+            value = '%di' % value
+        elif isinstance(value, float):
+            value = repr(value)
+        else:
+            value = '"%s"' % str(value).replace('"', '\\"')
+        values.append('%s=%s' % (_escape(name), value))
+
+    return '%s %s %d' % (line, ','.join(values), int(when * 1000))
+
+
+class InfluxDBOutput(IntervalOutput):
+    """Streams the metrics to InfluxDB every interval.
+
+    Writes the *loads_requests* and *loads_tests* measurements, the
+    *loads_gauges* and *loads_trends* ones of the custom metrics, and the
+    *loads_request* one with every request when *samples* is set.
+    """
+    name = 'influxdb'
+    options = {'url': ('The URL of InfluxDB', str,
+                       'http://localhost:8086', True),
+               'database': ('The database', str, 'loads', True),
+               'interval': ('The interval between two writes, in seconds',
+                            float, 1., True),
+               'tags': ('Tags added to the metrics, like "region=eu,env=ci"',
+                        str, '', True),
+               'samples': ('Set to 1 to also write every request', int, 0,
+                           True)}
+
+    def __init__(self, test_result, args):
+        super(InfluxDBOutput, self).__init__(test_result, args)
+        self.url = self.get_option('url', 'http://localhost:8086')
+        self.database = self.get_option('database', 'loads')
+        self.samples = bool(self.get_option('samples', 0))
+        self.session = requests.Session()
+
+    def get_lines(self, metrics, when):
+        lines = []
+        for agent_id, agent_metrics in sorted(metrics.items()):
+            tags = self.get_tags(agent_id)
+            histogram = agent_metrics['histogram']
+
+            if agent_metrics['hits']:
+                p95 = histogram.get_value_at_percentile(95)
+                fields = {'count': agent_metrics['hits'],
+                          'errors': agent_metrics['errors'],
+                          'avg': histogram.get_mean() / 10 ** 6,
+                          'max': float(histogram.max) / 10 ** 6,
+                          'p95': float(p95) / 10 ** 6}
+                lines.append(_format_line('loads_requests', tags, fields,
+                                          when))
+
+            for scenario, results in sorted(agent_metrics['tests'].items()):
+                scenario_tags = dict(tags)
+                scenario_tags['scenario'] = scenario
+                lines.append(_format_line('loads_tests', scenario_tags,
+                                          results, when))
+
+            for name, value in sorted(agent_metrics['gauges'].items()):
+                custom_tags = dict(tags, metric=name)
+                lines.append(_format_line('loads_gauges', custom_tags,
+                                          {'value': float(value)}, when))
+            for name, values in sorted(agent_metrics['trends'].items()):
+                custom_tags = dict(tags, metric=name)
+                fields = {'count': len(values),
+                          'avg': float(sum(values)) / len(values),
+                          'min': float(min(values)),
+                          'max': float(max(values))}
+                lines.append(_format_line('loads_trends', custom_tags,
+                                          fields, when))
+
+            if not self.samples:
+                continue
+
+            for hit in agent_metrics['samples']:
+                hit_tags = dict(tags)
+                hit_tags.update({'url': hit['url'], 'method': hit['method'],
+                                 'status': hit['status']})
+                fields = {'elapsed': float(_get_seconds(hit['elapsed']))}
+                started = _get_timestamp(hit.get('started'), when)
+                lines.append(_format_line('loads_request', hit_tags, fields,
+                                          started))
+        return lines
+
+    def send(self, metrics, when):
+        lines = self.get_lines(metrics, when)
+        if not lines:
+            return
+
+        res = self.session.post(self.url.rstrip('/') + '/write',
+                                params={'db': self.database,
+                                        'precision': 'ms'},
+                                data='\n'.join(lines))
+        res.raise_for_status()
@@ -0,0 +1,159 @@
+import time
+from calendar import timegm
+from datetime import timedelta
+
+import gevent
+
+from loads.histogram import Histogram
+from loads.util import logger, total_seconds, parse_tags
+
+
+def _get_scenario(test):
+    # the tests are strings like "test_es (module.TestCase)" when they come
+    # from the agents
+    return str(test).split()[0]
+
+
+def _get_seconds(elapsed):
+    if isinstance(elapsed, timedelta):
+        return total_seconds(elapsed)
+    return elapsed
+
+
+def _get_timestamp(started, default):
+    if hasattr(started, 'utctimetuple'):
+        return timegm(started.utctimetuple()) + started.microsecond / 1e6
+    return default
+
+
+class IntervalOutput(object):
+    """Base class for the outputs sending the metrics to a collector every
+    *interval* seconds, instead of at the end of the run.
+
+    The metrics are grouped by agent -- None when the tests run locally --
+    and passed to :meth:`send`.
+    """
+    name = None
+    options = {}
+    # when True, :meth:`send` is called for the intervals without metrics
+    send_empty = False
+
+    def __init__(self, test_result, args):
+        self.test_result = test_result
+        self.args = args
+        self.run_id = args.get('run_id')
+        self.interval = float(self.get_option('interval', 1.))
+        self._metrics = {}
+        self._timer = None
+        self._stopped = False
+
+    def get_option(self, option, default=None):
+        # like argparse, for the names with dashes
+        value = self.args.get('output_%s_%s' % (self.name.replace('-', '_'),
+                                                 option))
+        if value is None:
+            return default
+        return value
+
+    def get_tags(self, agent_id=None):
+        """Returns the tags of the metrics: the run, the agent and its tags,
+        and the ones given in the *tags* option, like
+        "region=eu,env=staging"."""
+        tags = parse_tags(self.get_option('tags', ''))
+
+        if self.run_id is not None:
+            tags['run_id'] = self.run_id
+        if agent_id is not None:
+            tags['agent'] = str(agent_id)
+            agent_tags = getattr(self.test_result, 'agent_tags', {})
+            tags.update(agent_tags.get(str(agent_id), {}))
+        return tags
+
+    def _get_metrics(self, agent_id):
+        if agent_id not in self._metrics:
+            self._metrics[agent_id] = {'hits': 0, 'errors': 0,
+                                       'histogram': Histogram(),
+                                       'tests': {}, 'samples': [],
+                                       'gauges': {}, 'trends': {}}
+        return self._metrics[agent_id]
+
+    def push(self, method_called, *args, **data):
+        if self._timer is None and not self._stopped:
+            self._timer = gevent.spawn_later(self.interval, self._dump)
+
+        if method_called == 'add_hit':
+            self._add_hit(data)
+        elif method_called in ('addSuccess', 'addFailure', 'addError'):
+            self._add_test(method_called, args, data)
+        elif method_called in ('set_gauge', 'add_trend'):
+            self._add_custom(method_called, args, data)
+
+    def _add_hit(self, data):
+        metrics = self._get_metrics(data.get('agent_id'))
+        metrics['hits'] += 1
+        status = data.get('status')
+        if isinstance(status, basestring):
+            failed = status != 'OK'
+        else:
+            failed = not 200 <= status < 400
+        if failed:
+            metrics['errors'] += 1
+
+        elapsed = _get_seconds(data['elapsed'])
+        metrics['histogram'].record_value(int(round(elapsed * 10 ** 6)))
+        metrics['samples'].append(data)
+
+    def _add_test(self, method_called, args, data):
+        test = data.get('test', args and args[0] or None)
+        metrics = self._get_metrics(data.get('agent_id'))
+        results = metrics['tests'].setdefault(
+            _get_scenario(test), {'success': 0, 'failures': 0, 'errors': 0})
+        key = {'addSuccess': 'success', 'addFailure': 'failures',
+               'addError': 'errors'}[method_called]
+        results[key] += 1
+
+    def _add_custom(self, method_called, args, data):
+        name = data.get('name', args and args[0] or None)
+        value = data.get('value', len(args) > 1 and args[1] or 0)
+        metrics = self._get_metrics(data.get('agent_id'))
+        if method_called == 'set_gauge':
+            metrics['gauges'][name] = value
+        else:
+            metrics['trends'].setdefault(name, []).append(value)
+
+    def _dump(self):
+        metrics, self._metrics = self._metrics, {}
+        if metrics or self.send_empty:
+            try:
+                self.send(metrics, time.time())
+            except Exception:
+                logger.exception('Could not send the metrics to %s' %
+                                 self.name)
+
+        if self._stopped:
+            self._timer = None
+        else:
+            self._timer = gevent.spawn_later(self.interval, self._dump)
+
+    def send(self, metrics, when):
+        """Sends the metrics of an interval.
+
+        :param metrics: a mapping of agent ids to their metrics: the number
+                        of *hits*, the number of *errors* among them, the
+                        *histogram* of their times, the results of the
+                        *tests* per scenario, the hits as *samples*, and
+                        the last values of the custom *gauges* and the
+                        values of the custom *trends*, by name.
+        :param when: the end of the interval, as a timestamp.
+        """
+        raise NotImplementedError()
+
+    def refresh(self, run_id=None):
+        if run_id is not None:
+            self.run_id = run_id
+
+    def flush(self):
+        self._stopped = True
+        if self._timer is not None:
+            self._timer.kill()
+        self._dump()
@@ -0,0 +1,61 @@
+import sys
+from datetime import datetime
+
+from loads.output._interval import _get_seconds
+from loads.util import DateTimeJSONEncoder, get_url_pattern
+
+
+def get_record(data):
+    """Returns the JSON object of the line of a hit."""
+    started = data.get('started')
+    if not isinstance(started, (datetime, basestring)):
+        started = None
+
+    record = {'timestamp': started,
+              'scenario': data.get('scenario'),
+              'method': data.get('method'),
+              'url': data.get('url'),
+              'url_pattern': get_url_pattern(data.get('url', '')),
+              'status': data.get('status'),
+              'elapsed': _get_seconds(data.get('elapsed')),
+              'phases': data.get('phases'),
+              'agent_id': data.get('agent_id')}
+    for field in ('request_id', 'span', 'body_size', 'wire_size',
+                  'endpoint', 'family', 'server_timings'):
+        if data.get(field) is not None:
+            record[field] = data[field]
+    return record
+
+
+class JSONLinesOutput(object):
+    """Writes every request as a line of JSON, to a file or to stdout.
+
+    The lines have the *timestamp* of the request, its *scenario*, *method*,
+    *url*, *url_pattern* and *status*, its *elapsed* time and *phases* in
+    seconds, and the *agent_id* -- so you can do your own analysis.
+    """
+    name = 'jsonl'
+    options = {'filename': ('Filename, or - for stdout', str, '-', True)}
+
+    def __init__(self, test_result, args):
+        self.test_result = test_result
+        self.filename = args.get('output_jsonl_filename') or '-'
+        self.encoder = DateTimeJSONEncoder()
+        if self.filename == '-':
+            self.fd = sys.stdout
+        else:
+            self.fd = open(self.filename, 'a+')
+
+    def get_record(self, data):
+        return get_record(data)
+
+    def push(self, called_method, *args, **data):
+        if called_method != 'add_hit':
+            return
+        self.fd.write(self.encoder.encode(self.get_record(data)) + '\n')
+
+    def flush(self):
+        if self.fd is sys.stdout:
+            self.fd.flush()
+        else:
+            self.fd.close()
@@ -0,0 +1,90 @@
+import sys
+import time
+from datetime import datetime
+
+from loads.histogram import Histogram
+from loads.output._interval import IntervalOutput
+from loads.util import DateTimeJSONEncoder
+
+
+_PERCENTILES = (50, 95, 99)
+
+
+def _get_times(histogram):
+    if not histogram.total_count:
+        return {}
+    times = {'avg': histogram.get_mean() / 10 ** 6,
+             'max': float(histogram.max) / 10 ** 6}
+    for percentile in _PERCENTILES:
+        value = histogram.get_value_at_percentile(percentile)
+        times['p%d' % percentile] = float(value) / 10 ** 6
+    return times
+
+
+class JSONStreamOutput(IntervalOutput):
+    """Writes a summary of the run as a line of JSON every interval, to a
+    file or to stdout, so the scripts wrapping a run can follow it live.
+
+    Every line has the *type* -- *interval*, or *summary* for the last one
+    --, the *timestamp* and the *elapsed* seconds of the run, the figures
+    of the last *interval* and the *total* ones since the start: the
+    *hits*, *errors* and *rps*, their times in seconds -- *avg*, *p50*,
+    *p95*, *p99* and *max* -- and the results of the *tests*.
+    """
+    name = 'json-stream'
+    options = {'filename': ('Filename, or - for stdout', str, '-', True),
+               'interval': ('The interval between two summaries, in '
+                            'seconds', float, 1., True)}
+    # the scripts get a line even when nothing happened
+    send_empty = True
+
+    def __init__(self, test_result, args):
+        super(JSONStreamOutput, self).__init__(test_result, args)
+        self.filename = self.get_option('filename', '-')
+        self.encoder = DateTimeJSONEncoder()
+        if self.filename == '-':
+            self.fd = sys.stdout
+        else:
+            self.fd = open(self.filename, 'a+')
+        self._started = self._last = time.time()
+        self._total = {'hits': 0, 'errors': 0, 'histogram': Histogram(),
+                       'tests': {'success': 0, 'failures': 0, 'errors': 0}}
+
+    def get_record(self, metrics, when):
+        interval = {'hits': 0, 'errors': 0, 'histogram': Histogram(),
+                    'tests': {'success': 0, 'failures': 0, 'errors': 0}}
+        for agent_metrics in metrics.values():
+            for figures in (interval, self._total):
+                figures['hits'] += agent_metrics['hits']
+                figures['errors'] += agent_metrics['errors']
+                figures['histogram'].add(agent_metrics['histogram'])
+                for results in agent_metrics['tests'].values():
+                    for key, value in results.items():
+                        figures['tests'][key] += value
+
+        record = {'type': self._stopped and 'summary' or 'interval',
+                  'timestamp': datetime.utcfromtimestamp(when),
+                  'elapsed': when - self._started}
+        if self.run_id is not None:
+            record['run_id'] = self.run_id
+        for name, figures, seconds in (
+                ('interval', interval, when - self._last),
+                ('total', self._total, when - self._started)):
+            record[name] = {'hits': figures['hits'],
+                            'errors': figures['errors'],
+                            'rps': seconds > 0 and
+                            figures['hits'] / float(seconds) or 0.,
+                            'tests': dict(figures['tests'])}
+            record[name].update(_get_times(figures['histogram']))
+        self._last = when
+        return record
+
+    def send(self, metrics, when):
+        self.fd.write(self.encoder.encode(self.get_record(metrics, when)) +
+                      '\n')
+        self.fd.flush()
+
+    def flush(self):
+        super(JSONStreamOutput, self).flush()
+        if self.fd is not sys.stdout:
+            self.fd.close()
@@ -0,0 +1,111 @@
+import traceback
+from xml.etree import ElementTree
+
+from loads.thresholds import parse_thresholds
+
+
+def _split_name(name):
+    # "test_es (module.TestSite)" -> "module.TestSite", "test_es"
+    name = str(name)
+    if ' (' in name and name.endswith(')'):
+        method, klass = name[:-1].split(' (', 1)
+        return klass, method
+    return 'loads', name
+
+
+def _format_exc(exc_info):
+    exc_class, exc, tb = exc_info
+    if isinstance(tb, basestring):
+        # the agents send the exceptions as strings
+        return '%s%s: %s' % (tb, exc_class, exc)
+    return ''.join(traceback.format_exception(exc_class, exc, tb))
+
+
+class JUnitOutput(object):
+    """Writes a JUnit XML file, for the CI systems.
+
+    Every scenario is a test case, failed when any of its runs failed, and
+    so is every threshold.
+    """
+    name = 'junit'
+    options = {'filename': ('Filename', str, None, True)}
+
+    def __init__(self, test_result, args):
+        self.test_result = test_result
+        self.filename = args['output_junit_filename']
+        self.thresholds = parse_thresholds(args.get('threshold'))
+
+    def push(self, called_method, *args, **data):
+        pass
+
+    def _get_scenarios(self):
+        scenarios = {}
+        for test in self.test_result.tests.values():
+            scenario = scenarios.setdefault(str(test.name), {
+                'runs': 0, 'failures': [], 'errors': [], 'time': 0.})
+            scenario['runs'] += 1
+            scenario['failures'].extend(test.failures)
+            scenario['errors'].extend(test.errors)
+            scenario['time'] += test.duration
+        return scenarios
+
+    def _add_case(self, suite, classname, name, time=0., failure=None,
+                  error=None):
+        case = ElementTree.SubElement(suite, 'testcase', classname=classname,
+                                      name=name, time='%.3f' % time)
+        for tag, problem in (('failure', failure), ('error', error)):
+            if problem is not None:
+                message, text = problem
+                element = ElementTree.SubElement(case, tag, message=message)
+                element.text = text
+        return case
+
+    def get_tree(self):
+        suite = ElementTree.Element('testsuite', name='loads')
+        counts = {'tests': 0, 'failures': 0, 'errors': 0}
+
+        for name, scenario in sorted(self._get_scenarios().items()):
+            failure = error = None
+            runs = scenario['runs']
+            if scenario['failures']:
+                failure = ('%d of %d runs failed' % (
+                    len(scenario['failures']), runs),
+                    _format_exc(scenario['failures'][0]))
+                counts['failures'] += 1
+            if scenario['errors']:
+                error = ('%d of %d runs had an error' % (
+                    len(scenario['errors']), runs),
+                    _format_exc(scenario['errors'][0]))
+                counts['errors'] += 1
+
+            classname, method = _split_name(name)
+            self._add_case(suite, classname, method, scenario['time'],
+                           failure, error)
+            counts['tests'] += 1
+
+        for threshold in self.thresholds:
+            value, passed = threshold.evaluate(self.test_result)
+            failure = None
+            if not passed:
+                if value is None:
+                    message = 'no data'
+                else:
+                    message = 'the %s is %.3f' % (threshold.metric, value)
+                failure = (message, '%s: %s' % (threshold, message))
+                counts['failures'] += 1
+            self._add_case(suite, 'loads.thresholds', str(threshold),
+                           failure=failure)
+            counts['tests'] += 1
+
+        for name, count in counts.items():
+            suite.set(name, str(count))
+        suite.set('time', '%.3f' % self.test_result.duration)
+
+        root = ElementTree.Element('testsuites')
+        root.append(suite)
+        return ElementTree.ElementTree(root)
+
+    def flush(self):
+        with open(self.filename, 'w') as f:
+            f.write('<?xml version="1.0" encoding="UTF-8"?>\n')
+            self.get_tree().write(f, encoding='utf-8')
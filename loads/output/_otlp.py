@@ -0,0 +1,223 @@
+import json
+import time
+
+import requests
+
+from loads.output._interval import (IntervalOutput, _get_seconds,
+                                    _get_timestamp)
+from loads.transport.metrics import DURATION_BUCKETS
+
+
+# the aggregation temporality of the metrics, and the kind of the spans
+_DELTA = 1
+_CLIENT = 3
+_STATUS_ERROR = 2
+
+
+def _nanos(when):
+    # the 64 bits integers are strings in the JSON encoding of OTLP
+    return str(int(when * 10 ** 9))
+
+
+def _attribute(name, value):
+    if isinstance(value, bool):
+        value = {'boolValue': value}
+    elif isinstance(value, (int, long)):
+        value = {'intValue': str(value)}
+    elif isinstance(value, float):
+        value = {'doubleValue': value}
+    else:
+        value = {'stringValue': str(value)}
+    return {'key': name, 'value': value}
+
+
+def _attributes(mapping):
+    return [_attribute(name, value) for name, value
+            in sorted(mapping.items())]
+
+
+def _is_error(status):
+    if isinstance(status, basestring):
+        return status != 'OK'
+    return not 200 <= status < 400
+
+
+class OTLPOutput(IntervalOutput):
+    """Pushes the metrics to an OpenTelemetry collector every interval,
+    with the OTLP/HTTP JSON protocol.
+
+    The requests sent with --trace-requests are exported as client spans.
+    """
+    name = 'otlp'
+    options = {'url': ('The URL of the OTLP/HTTP receiver', str,
+                       'http://localhost:4318', True),
+               'service': ('The service.name of the resource', str,
+                           'loads', True),
+               'interval': ('The interval between two exports, in seconds',
+                            float, 1., True),
+               'tags': ('Attributes added to the resource, like '
+                        '"region=eu,env=ci"', str, '', True)}
+
+    def __init__(self, test_result, args):
+        super(OTLPOutput, self).__init__(test_result, args)
+        self.url = self.get_option('url', 'http://localhost:4318')
+        self.service = self.get_option('service', 'loads')
+        self.session = requests.Session()
+        self._started = time.time()
+
+    def get_resource(self, agent_id):
+        attributes = {'service.name': self.service}
+        for name, value in self.get_tags(agent_id).items():
+            if name in ('run_id', 'agent'):
+                name = 'loads.%s' % name
+            attributes[name] = value
+        return {'attributes': _attributes(attributes)}
+
+    def _sum(self, name, description, points):
+        return {'name': name, 'description': description, 'unit': '1',
+                'sum': {'dataPoints': points, 'isMonotonic': True,
+                        'aggregationTemporality': _DELTA}}
+
+    def _get_otlp_metrics(self, agent_metrics, start, when):
+        times = {'startTimeUnixNano': _nanos(start),
+                 'timeUnixNano': _nanos(when)}
+
+        def point(value, **attributes):
+            data = {'asInt': str(value),
+                    'attributes': _attributes(attributes)}
+            data.update(times)
+            return data
+
+        metrics = []
+        if agent_metrics['hits']:
+            metrics.append(self._sum(
+                'loads.requests', 'Requests sent by the tests.',
+                [point(agent_metrics['hits'])]))
+            metrics.append(self._sum(
+                'loads.request.errors', 'Requests that failed.',
+                [point(agent_metrics['errors'])]))
+
+            buckets = [0] * (len(DURATION_BUCKETS) + 1)
+            elapsed = [_get_seconds(hit['elapsed'])
+                       for hit in agent_metrics['samples']]
+            for value in elapsed:
+                index = len(DURATION_BUCKETS)
+                for bucket_index, bucket in enumerate(DURATION_BUCKETS):
+                    if value <= bucket:
+                        index = bucket_index
+                        break
+                buckets[index] += 1
+
+            data = {'count': str(len(elapsed)), 'sum': float(sum(elapsed)),
+                    'min': float(min(elapsed)), 'max': float(max(elapsed)),
+                    'bucketCounts': [str(count) for count in buckets],
+                    'explicitBounds': list(DURATION_BUCKETS),
+                    'attributes': []}
+            data.update(times)
+            metrics.append({'name': 'loads.request.duration',
+                            'description': 'Request times.', 'unit': 's',
+                            'histogram': {'dataPoints': [data],
+                                          'aggregationTemporality': _DELTA}})
+
+        points = []
+        for scenario, results in sorted(agent_metrics['tests'].items()):
+            for result, count in sorted(results.items()):
+                points.append(point(count, scenario=scenario, result=result))
+        if points:
+            metrics.append(self._sum('loads.tests', 'Tests run, by result.',
+                                     points))
+
+        points = []
+        for name, value in sorted(agent_metrics['gauges'].items()):
+            data = {'asDouble': float(value),
+                    'attributes': _attributes({'metric': name})}
+            data.update(times)
+            points.append(data)
+        if points:
+            metrics.append({'name': 'loads.custom.gauge',
+                            'description': 'Custom gauges of the tests.',
+                            'unit': '1', 'gauge': {'dataPoints': points}})
+
+        points = []
+        for name, values in sorted(agent_metrics['trends'].items()):
+            data = {'count': str(len(values)), 'sum': float(sum(values)),
+                    'quantileValues': [
+                        {'quantile': 0., 'value': float(min(values))},
+                        {'quantile': 1., 'value': float(max(values))}],
+                    'attributes': _attributes({'metric': name})}
+            data.update(times)
+            points.append(data)
+        if points:
+            metrics.append({'name': 'loads.custom.trend',
+                            'description': 'Custom trends of the tests.',
+                            'unit': '1', 'summary': {'dataPoints': points}})
+        return metrics
+
+    def _get_spans(self, agent_metrics, when):
+        spans = []
+        for hit in agent_metrics['samples']:
+            if not hit.get('span'):
+                continue
+
+            trace_id, span_id = hit['span']
+            started = _get_timestamp(hit.get('started'), None)
+            elapsed = _get_seconds(hit['elapsed'])
+            if started is None:
+                started = when - elapsed
+
+            span = {'traceId': trace_id, 'spanId': span_id,
+                    'name': hit['method'], 'kind': _CLIENT,
+                    'startTimeUnixNano': _nanos(started),
+                    'endTimeUnixNano': _nanos(started + elapsed),
+                    'attributes': _attributes({
+                        'http.request.method': hit['method'],
+                        'url.full': hit['url'],
+                        'http.response.status_code': hit['status']})}
+            if _is_error(hit['status']):
+                span['status'] = {'code': _STATUS_ERROR}
+            spans.append(span)
+        return spans
+
+    def get_payloads(self, metrics, when):
+        """Returns the bodies of the /v1/metrics and /v1/traces requests.
+        A body is None when there is nothing to send."""
+        start, self._started = self._started, when
+        scope = {'name': 'loads'}
+        resource_metrics = []
+        resource_spans = []
+
+        for agent_id, agent_metrics in sorted(metrics.items()):
+            resource = self.get_resource(agent_id)
+            otlp_metrics = self._get_otlp_metrics(agent_metrics, start,
+                                                  when)
+            if otlp_metrics:
+                resource_metrics.append({
+                    'resource': resource,
+                    'scopeMetrics': [{'scope': scope,
+                                      'metrics': otlp_metrics}]})
+
+            spans = self._get_spans(agent_metrics, when)
+            if spans:
+                resource_spans.append({
+                    'resource': resource,
+                    'scopeSpans': [{'scope': scope, 'spans': spans}]})
+
+        metrics_payload = spans_payload = None
+        if resource_metrics:
+            metrics_payload = {'resourceMetrics': resource_metrics}
+        if resource_spans:
+            spans_payload = {'resourceSpans': resource_spans}
+        return metrics_payload, spans_payload
+
+    def _post(self, path, payload):
+        res = self.session.post(self.url.rstrip('/') + path,
+                                data=json.dumps(payload),
+                                headers={'Content-Type': 'application/json'})
+        res.raise_for_status()
+
+    def send(self, metrics, when):
+        metrics_payload, spans_payload = self.get_payloads(metrics, when)
+        if metrics_payload is not None:
+            self._post('/v1/metrics', metrics_payload)
+        if spans_payload is not None:
+            self._post('/v1/traces', spans_payload)
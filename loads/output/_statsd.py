@@ -0,0 +1,105 @@
+import random
+import re
+import socket
+
+from loads.output._interval import IntervalOutput, _get_seconds
+
+
+# the packets are kept under the size of a safe UDP payload
+MAX_PACKET_SIZE = 512
+
+# the dots separate the parts of the names
+_UNSAFE = re.compile(r'[^\w-]')
+
+
+def _clean(name):
+    return _UNSAFE.sub('_', str(name))
+
+
+class StatsDOutput(IntervalOutput):
+    """Sends the metrics to StatsD every interval.
+
+    The request times are sent as timers, sampled with the *rate* option.
+    """
+    name = 'statsd'
+    options = {'host': ('The StatsD host', str, 'localhost', True),
+               'port': ('The StatsD port', int, 8125, True),
+               'prefix': ('The prefix of the metrics names', str, 'loads',
+                          True),
+               'interval': ('The interval between two flushes, in seconds',
+                            float, 1., True),
+               'rate': ('The share of the request times sent, from 0 '
+                               'to 1', float, 1., True)}
+
+    def __init__(self, test_result, args):
+        super(StatsDOutput, self).__init__(test_result, args)
+        self.address = (self.get_option('host', 'localhost'),
+                        self.get_option('port', 8125))
+        self.prefix = self.get_option('prefix', 'loads')
+        self.sample_rate = float(self.get_option('rate', 1.))
+        if not 0 <= self.sample_rate <= 1:
+            raise ValueError('The sample rate is between 0 and 1')
+        self.sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+
+    def _get_prefix(self, agent_id):
+        if agent_id is None:
+            return self.prefix
+        return '%s.agent.%s' % (self.prefix, _clean(agent_id))
+
+    def get_lines(self, metrics):
+        lines = []
+        if self.sample_rate == 1:
+            rate = ''
+        else:
+            rate = '|@%s' % self.sample_rate
+
+        for agent_id, agent_metrics in sorted(metrics.items()):
+            prefix = self._get_prefix(agent_id)
+            if agent_metrics['hits']:
+                lines.append('%s.requests:%d|c' % (prefix,
+                                                   agent_metrics['hits']))
+                lines.append('%s.errors:%d|c' % (prefix,
+                                                 agent_metrics['errors']))
+
+            for scenario, results in sorted(agent_metrics['tests'].items()):
+                for result, count in sorted(results.items()):
+                    lines.append('%s.tests.%s.%s:%d|c' % (
+                        prefix, _clean(scenario), result, count))
+
+            for hit in agent_metrics['samples']:
+                if random.random() >= self.sample_rate:
+                    continue
+                elapsed = _get_seconds(hit['elapsed']) * 1000
+                lines.append('%s.request_time:%d|ms%s' % (prefix, elapsed,
+                                                         rate))
+
+            for name, value in sorted(agent_metrics['gauges'].items()):
+                lines.append('%s.gauges.%s:%s|g' % (prefix, _clean(name),
+                                                    value))
+            for name, values in sorted(agent_metrics['trends'].items()):
+                for value in values:
+                    lines.append('%s.trends.%s:%s|ms' % (
+                        prefix, _clean(name), value))
+        return lines
+
+    def get_packets(self, lines):
+        packets = []
+        packet = ''
+        for line in lines:
+            if packet and len(packet) + len(line) + 1 > MAX_PACKET_SIZE:
+                packets.append(packet)
+                packet = ''
+            if packet:
+                packet += '\n'
+            packet += line
+        if packet:
+            packets.append(packet)
+        return packets
+
+    def send(self, metrics, when):
+        for packet in self.get_packets(self.get_lines(metrics)):
+            self.sock.sendto(packet, self.address)
+
+    def flush(self):
+        super(StatsDOutput, self).flush()
+        self.sock.close()
@@ -3,7 +3,11 @@ import sys
 import traceback
 from collections import defaultdict
 
+from loads.errors import CATEGORIES
+from loads.httpcache import get_hit_ratios
+from loads.pooling import get_reuse_ratio
 from loads.results import ZMQTestResult
+from loads.tracing import PHASES
 
 
 def get_terminal_width(fd=1):
@@ -26,6 +30,21 @@ def get_screen_relative_value(percent, terminal_width):
     return int(round(percent * (terminal_width / 100.))) - 8
 
 
+def _format_resources(samples):
+    """Returns the summary of the samples of a host of the system under
+    test."""
+    parts = []
+    for name, label, unit in (('cpu', 'cpu', '%'), ('memory', 'memory', '%'),
+                              ('connections', 'connections', '')):
+        values = [sample[name] for sample in samples
+                  if sample[name] is not None]
+        if values:
+            parts.append('%s avg %.1f%s, max %.1f%s' % (
+                label, float(sum(values)) / len(values), unit, max(values),
+                unit))
+    return '; '.join(parts) or 'no data'
+
+
 class StdOutput(object):
     name = 'stdout'
     options = {'total': ('Total Number of items', int, None, False),
@@ -43,17 +62,45 @@ class StdOutput(object):
         self._duration_progress()
         write("\nDuration: %.2f seconds" % self.results.duration)
         write("\nHits: %d" % self.results.nb_hits)
+        if self.results.warmup:
+            write("\nWarm-up: %.2f seconds, %d hits left out" % (
+                self.results.warmup, self.results.warmup_hits))
         write("\nStarted: %s" % self.results.start_time)
         write("\nApproximate Average RPS: %d" %
               self.results.requests_per_second())
         write("\nAverage request time: %.2fs" %
               self.results.average_request_time())
+        phases = self.results.get_phase_metrics()
+        if phases:
+            write("\nRequest phases (average / p95):")
+            for phase in PHASES:
+                if phase in phases:
+                    write(" %s %.3fs / %.3fs" % (
+                        phase.upper(), phases[phase]['average'],
+                        phases[phase]['p95']))
         write("\nOpened web sockets: %d" % self.results.opened_sockets)
+        if self.results.opened_sockets:
+            write("\nAverage socket connection time: %.2fs" %
+                  self.results.average_socket_connect_time())
+            write("\nAverage socket round-trip time: %.2fs" %
+                  self.results.average_socket_rtt())
+            write("\nSocket disconnections: %d" %
+                  self.results.socket_disconnects)
+        if self.results.bytes_received:
+            write("\nBytes received via HTTP: %d, %d on the wire" % (
+                self.results.bytes_received,
+                self.results.wire_bytes_received))
         write("\nBytes received via web sockets : %d\n" %
               self.results.socket_data_received)
         write("\nSuccess: %d" % self.results.nb_success)
         write("\nErrors: %d" % self.results.nb_errors)
         write("\nFailures: %d" % self.results.nb_failures)
+        for agent_id, replacement in self.results.lost_agents:
+            if replacement is None:
+                write("\nAgent %s lost: the run is degraded" % agent_id)
+            else:
+                write("\nAgent %s lost, replaced by %s" % (agent_id,
+                                                          replacement))
         write("\n\n")
 
         if self.results.nb_errors:
@@ -64,6 +111,18 @@ class StdOutput(object):
             self._print_tb(self.results.failures)
             write('\n')
 
+        if self.results.nb_errors or self.results.nb_failures or any(
+                not hit.success for hit in self.results.hits):
+            groups = self.results.get_error_groups()
+            write("\nErrors by category:")
+            for category in CATEGORIES:
+                if category not in groups:
+                    continue
+                write("\n- %s : %d" % (category, groups[category]['count']))
+                for example in groups[category]['examples']:
+                    write("\n    %s" % example)
+            write('\n')
+
         avt = 'average_request_time'
 
         def _metric(item1, item2):
@@ -95,13 +154,77 @@ class StdOutput(object):
                                            value))
                 write('%s' % '\t'.join(res))
 
+        for tag, values in sorted(self.results.get_tag_metrics().items()):
+            write("\n\nStats by %s:" % tag)
+            for value, metric in sorted(values.items()):
+                write("\n- %s\tHits: %d\tAverage request time: %.3fs\t"
+                      "p95: %.3fs\tSuccess rate: %.2f" % (
+                          value, metric['hits'],
+                          metric['average_request_time'], metric['p95'],
+                          metric['success_rate']))
+
+        tests = self.results.get_test_metrics().items()
+        if len(tests) > 1:
+            write("\n\nStats by scenario:")
+            for name, metric in sorted(tests):
+                write("\n- %s\tTests: %d\tSuccess rate: %.2f\t"
+                      "Average test duration: %.2fs" % (
+                          name, metric['tests'], metric['success_rate'],
+                          metric['average_test_duration']))
+
         write('\n')
+        checks = self.results.get_checks()
+        if len(checks) > 0:
+            write("\nChecks:")
+            for name, counts in sorted(checks.items()):
+                write("\n- %s : %d passed, %d failed" % (
+                    name, counts['passed'], counts['failed']))
+
+            write('\n')
+
         counters = self.results.get_counters()
         if len(counters) > 0:
             write("\nCustom metrics:")
             for name, value in counters.items():
                 write("\n- %s : %s" % (name, value))
+            reuse = get_reuse_ratio(counters)
+            if reuse is not None:
+                write("\n\nConnection reuse ratio: %.2f" % reuse)
+            ratios = get_hit_ratios(counters)
+            if ratios is not None:
+                write("\n\nHTTP cache hit ratio: %.2f, revalidated: %.2f" %
+                      ratios)
+
+            write('\n')
+
+        gauges = self.results.get_gauges()
+        trends = self.results.get_trends()
+        if gauges or trends:
+            write("\nGauges and trends:")
+            for name, gauge in sorted(gauges.items()):
+                write("\n- %s : last %g, min %g, max %g" % (
+                    name, gauge.last, gauge.min, gauge.max))
+            for name, trend in sorted(trends.items()):
+                write("\n- %s : avg %g, min %g, max %g, p95 %g" % (
+                    name, trend.get('avg'), trend.get('min'),
+                    trend.get('max'), trend.get('p95')))
+
+            write('\n')
+
+        if self.results.chaos_events:
+            write("\nChaos events:")
+            for event in self.results.chaos_events:
+                result = 'OK'
+                if not event['success']:
+                    result = 'FAILED (%s)' % event['detail']
+                write("\n- %s : %s" % (event['name'], result))
+            write('\n')
 
+        resources = self.results.get_resources()
+        if resources:
+            write("\nServer resources:")
+            for host, samples in sorted(resources.items()):
+                write("\n- %s : %s" % (host, _format_resources(samples)))
             write('\n')
 
         sys.stdout.flush()
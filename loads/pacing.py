@@ -0,0 +1,136 @@
+"""The think times of the virtual users and the pacing of their iterations,
+so the users of a closed model behave like real ones instead of sending
+their requests as fast as they can::
+
+    $ loads-runner example.TestWebSite.test_es -u 100 -d 600 \\
+        --think-time lognormal:2,0.5 --pacing 10
+
+- *--think-time*: the pause before every request of a test but its first
+  one. *2* is a fixed pause of 2 seconds, *uniform:1,3* a pause between 1
+  and 3 seconds, and *lognormal:2,0.5* the long tail of the real users: a
+  median of 2 seconds, the logarithms of the pauses having a standard
+  deviation of 0.5.
+- *--pacing SECONDS*: the minimum duration of an iteration. The users
+  whose test was shorter wait for the rest before the next one, so the
+  iterations start at the same pace whatever the response times.
+
+The tests can also pause by themselves with
+:meth:`loads.case.TestCase.think`. The pauses are not a part of the times
+of the requests.
+"""
+import math
+import random
+import time
+
+
+DISTRIBUTIONS = ('fixed', 'uniform', 'lognormal')
+
+
+def _sleep(seconds):
+    import gevent
+    gevent.sleep(seconds)
+
+
+class ThinkTime(object):
+    """The pauses of a virtual user.
+
+    :param distribution: one of *DISTRIBUTIONS*.
+    :param first: the seconds of the fixed pauses, the shortest pause of
+                  the uniform ones, or the median of the log-normal ones.
+    :param second: the longest uniform pause, or the sigma of the
+                   log-normal ones.
+    :param rand: the random generator.
+    :param sleep: waits for some seconds -- gevent.sleep by default.
+    """
+    def __init__(self, distribution='fixed', first=0., second=None,
+                 rand=None, sleep=None):
+        if distribution not in DISTRIBUTIONS:
+            raise ValueError('Unknown distribution %r' % distribution)
+        if first < 0 or (second is not None and second < 0):
+            raise ValueError('The think times are not negative')
+        if distribution == 'uniform' and (second is None or second < first):
+            raise ValueError('The uniform think time needs MIN,MAX')
+        if distribution == 'lognormal' and (not first or second is None):
+            raise ValueError('The log-normal think time needs MEDIAN,SIGMA')
+        self.distribution = distribution
+        self.first = first
+        self.second = second
+        self.random = rand or random.Random()
+        self._sleep = sleep or _sleep
+
+    def next(self):
+        """Returns the seconds of the next pause."""
+        if self.distribution == 'uniform':
+            return self.random.uniform(self.first, self.second)
+        elif self.distribution == 'lognormal':
+            return self.random.lognormvariate(math.log(self.first),
+                                              self.second)
+        return self.first
+
+    def wait(self):
+        """Pauses, and returns the seconds paused."""
+        seconds = self.next()
+        if seconds > 0:
+            self._sleep(seconds)
+        return seconds
+
+
+def parse_think_time(value):
+    """Converts a think time like "2", "uniform:1,3" or "lognormal:2,0.5"
+    in the (distribution, first, second) of a :class:`ThinkTime` -- sent
+    as they are to the agents."""
+    if isinstance(value, (list, tuple)):
+        return tuple(value)
+    value = str(value).strip().lower()
+    distribution, _, values = value.rpartition(':')
+    values = [float(part) for part in values.split(',')]
+    if len(values) > 2:
+        raise ValueError('Invalid think time %r' % value)
+    values.append(None)
+    think_time = (distribution or 'fixed', values[0], values[1])
+    # raises the errors of the invalid ones
+    ThinkTime(*think_time)
+    return think_time
+
+
+class Pacing(object):
+    """The minimum duration of the iterations.
+
+    :param minimum: the seconds of the shortest iteration.
+    :param clock: returns the current time.
+    :param sleep: waits for some seconds -- gevent.sleep by default.
+    """
+    def __init__(self, minimum, clock=time.time, sleep=None):
+        if minimum < 0:
+            raise ValueError('The pacing is not negative')
+        self.minimum = minimum
+        self.clock = clock
+        self._sleep = sleep or _sleep
+
+    def wait(self, started):
+        """Waits for the rest of the iteration that started at
+        :param started:, and returns the seconds waited."""
+        remaining = self.minimum - (self.clock() - started)
+        if remaining > 0:
+            self._sleep(remaining)
+            return remaining
+        return 0.
+
+
+def get_think_time(config, rand=None):
+    """Returns the :class:`ThinkTime` of a new virtual user, or None.
+
+    :param rand: the generator of the user -- see :mod:`loads.seeds`.
+    """
+    value = config.get('think_time')
+    if value is None:
+        return None
+    # every user gets its own generator
+    return ThinkTime(*parse_think_time(value), rand=rand)
+
+
+def get_pacing(config):
+    """Returns the :class:`Pacing` of a run, or None."""
+    if config.get('pacing') is None:
+        return None
+    return Pacing(float(config['pacing']))
@@ -0,0 +1,341 @@
+"""The plugins: the result sinks, the auth providers and the observers
+shipped as separate binaries, so they can stay out of the tree of loads::
+
+    $ loads-runner example.TestWebSite.test_es -u 10 -d 600 \\
+        --plugin /opt/loads/loads-kafka-sink \\
+        --plugin-option brokers=kafka1:9092
+
+    $ loads-broker --plugin /opt/loads/loads-jira-observer
+
+A plugin is started once per process, and talks gRPC with the protocol of
+`go-plugin <https://github.com/hashicorp/go-plugin>`_: it gets the
+*LOADS_PLUGIN* magic cookie in its environment, prints the
+*1|1|unix|/tmp/plugin123|grpc* handshake line on its stdout, and serves
+the *loads.plugin* services of *loads/go/src/loads/plugin/plugin.proto*.
+The Go plugins use the *loads/plugin* package to do so. Every message is a
+*google.protobuf.StringValue* holding JSON:
+
+- **Plugin.Configure** gets the *--plugin-option* values and the run, and
+  returns the *name* of the plugin and its *kinds*.
+- **Sink.Push** gets the hits and the results of the tests by batches,
+  and **Sink.Flush** is called at the end of the run. The sinks are
+  outputs of the runner.
+- **Auth.Authenticate** gets the *method*, *url* and *headers* of every
+  request of the virtual users, and returns the *headers* to add.
+- **Observer.Notify** gets the *started*, *breached* and *ended* events of
+  the runs of the broker -- the ones of the webhook observer.
+"""
+import atexit
+import os
+import select
+import subprocess
+import threading
+import time
+
+from requests.auth import AuthBase
+
+from loads.observers._webhook import WebhookObserver
+from loads.output._interval import _get_scenario
+from loads.output._jsonl import get_record
+from loads.util import DateTimeJSONEncoder, json, logger, try_import
+
+
+KINDS = ('sink', 'auth', 'observer')
+
+# the handshake of go-plugin
+MAGIC_COOKIE_KEY = 'LOADS_PLUGIN'
+MAGIC_COOKIE_VALUE = '7f3e1c5a9b2d4e6f'
+CORE_PROTOCOL_VERSION = 1
+PROTOCOL_VERSION = 1
+
+DEFAULT_TIMEOUT = 10.
+
+# the records a sink gets at once
+BATCH_SIZE = 100
+
+_PLUGINS = {}
+_LOCK = threading.Lock()
+_ENCODER = DateTimeJSONEncoder()
+
+
+class PluginError(Exception):
+    pass
+
+
+def _encode_varint(value):
+    data = []
+    while value > 0x7f:
+        data.append(chr(value & 0x7f | 0x80))
+        value >>= 7
+    data.append(chr(value))
+    return ''.join(data)
+
+
+def _decode_varint(data, position):
+    value = shift = 0
+    while True:
+        byte = ord(data[position])
+        position += 1
+        value |= (byte & 0x7f) << shift
+        if not byte & 0x80:
+            return value, position
+        shift += 7
+
+
+def encode_string(value):
+    """Returns the *google.protobuf.StringValue* of a string."""
+    if isinstance(value, unicode):
+        value = value.encode('utf8')
+    if not value:
+        return ''
+    return '\x0a' + _encode_varint(len(value)) + value
+
+
+def decode_string(data):
+    """Returns the string of a *google.protobuf.StringValue*."""
+    value, position = '', 0
+    while position < len(data):
+        key, position = _decode_varint(data, position)
+        field, wire_type = key >> 3, key & 7
+        if wire_type == 0:
+            skipped, position = _decode_varint(data, position)
+        elif wire_type == 2:
+            length, position = _decode_varint(data, position)
+            if field == 1:
+                value = data[position:position + length]
+            position += length
+        else:
+            raise PluginError('Unexpected wire type %d' % wire_type)
+    return value
+
+
+def parse_handshake(line):
+    """Returns the (network, address) of the handshake line of a plugin."""
+    parts = line.strip().split('|')
+    if len(parts) < 5:
+        raise PluginError('Invalid handshake %r' % line.strip())
+    core, version, network, address, protocol = parts[:5]
+    if core != str(CORE_PROTOCOL_VERSION):
+        raise PluginError('Unsupported go-plugin protocol %s' % core)
+    if version != str(PROTOCOL_VERSION):
+        raise PluginError('Unsupported plugin protocol %s -- loads '
+                          'speaks %d' % (version, PROTOCOL_VERSION))
+    if protocol != 'grpc':
+        raise PluginError('The plugins speak grpc, not %s' % protocol)
+    if network not in ('unix', 'tcp'):
+        raise PluginError('Unsupported network %s' % network)
+    return network, address
+
+
+class Plugin(object):
+    """A plugin binary, started by :meth:`start`.
+
+    :param path: the binary.
+    :param options: the *--plugin-option* values, by name.
+    :param timeout: the seconds a call -- or the handshake -- can take.
+    """
+    def __init__(self, path, options=None, timeout=DEFAULT_TIMEOUT):
+        self.path = path
+        self.options = options or {}
+        self.timeout = timeout
+        self.name = os.path.basename(path)
+        self.kinds = []
+        self.process = None
+        self._channel = None
+
+    def __repr__(self):
+        return '<Plugin %s>' % self.path
+
+    def start(self, run=None):
+        env = dict(os.environ)
+        env.update({MAGIC_COOKIE_KEY: MAGIC_COOKIE_VALUE,
+                    'PLUGIN_PROTOCOL_VERSIONS': str(PROTOCOL_VERSION)})
+        try:
+            self.process = subprocess.Popen([self.path],
+                                            stdout=subprocess.PIPE, env=env)
+        except OSError, e:
+            raise PluginError('Could not start %s: %s' % (self.path, e))
+
+        ready = select.select([self.process.stdout], [], [], self.timeout)[0]
+        line = ready and self.process.stdout.readline() or ''
+        if not line:
+            self.stop()
+            raise PluginError('%s sent no handshake' % self.path)
+        try:
+            network, address = parse_handshake(line)
+        except PluginError:
+            self.stop()
+            raise
+        self._connect(network == 'unix' and 'unix:' + address or address)
+
+        info = self.call('Plugin', 'Configure', {'options': self.options,
+                                                 'run': run or {}}) or {}
+        self.name = info.get('name') or self.name
+        self.kinds = [kind for kind in info.get('kinds') or []
+                      if kind in KINDS]
+        logger.debug('%s is the %s plugin: %s' % (self.path, self.name,
+                                                  ', '.join(self.kinds)))
+
+    def _connect(self, target):
+        try_import('grpc')
+        import grpc
+        self._channel = grpc.insecure_channel(target)
+
+    def call(self, service, method, value=None):
+        """Calls a method of the *loads.plugin* services with a value, and
+        returns the one of the reply."""
+        function = self._channel.unary_unary(
+            '/loads.plugin.%s/%s' % (service, method),
+            request_serializer=encode_string,
+            response_deserializer=decode_string)
+        reply = function(_ENCODER.encode(value), timeout=self.timeout)
+        if not reply:
+            return None
+        return json.loads(reply)
+
+    def stop(self):
+        if self._channel is not None:
+            # the way go-plugin asks a plugin to exit
+            try:
+                self._channel.unary_unary(
+                    '/plugin.GRPCController/Shutdown',
+                    request_serializer=lambda value: '',
+                    response_deserializer=lambda data: data)(
+                    None, timeout=self.timeout)
+            except Exception:
+                logger.debug('Could not shut %s down' % self.path)
+            self._channel.close()
+            self._channel = None
+
+        if self.process is None:
+            return
+        deadline = time.time() + self.timeout
+        while self.process.poll() is None and time.time() < deadline:
+            time.sleep(.1)
+        if self.process.poll() is None:
+            self.process.kill()
+            self.process.wait()
+        self.process = None
+
+
+def parse_options(values):
+    """Returns the *--plugin-option* values, like "brokers=kafka:9092", by
+    name."""
+    options = {}
+    for value in values or ():
+        if '=' not in value:
+            raise ValueError('Invalid plugin option %r, not NAME=VALUE' %
+                             value)
+        name, value = value.split('=', 1)
+        options[name.strip()] = value.strip()
+    return options
+
+
+def get_plugins(config, kind=None):
+    """Returns the plugins of the *plugin* option, started once by process
+    -- the ones of a kind when one is given."""
+    paths = config.get('plugin') or []
+    if not paths:
+        return []
+    options = parse_options(config.get('plugin_option'))
+    run = dict([(name, config.get(name)) for name in ('run_id', 'fqn',
+                                                      'project_name')])
+    plugins = []
+    with _LOCK:
+        for path in paths:
+            if path not in _PLUGINS:
+                plugin = Plugin(path, options)
+                plugin.start(run)
+                _PLUGINS[path] = plugin
+            plugins.append(_PLUGINS[path])
+    return [plugin for plugin in plugins
+            if kind is None or kind in plugin.kinds]
+
+
+def stop_plugins():
+    with _LOCK:
+        for plugin in _PLUGINS.values():
+            plugin.stop()
+        _PLUGINS.clear()
+
+
+atexit.register(stop_plugins)
+
+
+class PluginOutput(object):
+    """Pushes the hits and the results of the tests to a sink plugin."""
+    options = {}
+
+    def __init__(self, test_result, args, plugin):
+        self.test_result = test_result
+        self.args = args
+        self.plugin = plugin
+        self.name = plugin.name
+        self._records = []
+
+    def push(self, called_method, *args, **data):
+        if called_method == 'add_hit':
+            record = get_record(data)
+            record['type'] = 'hit'
+        elif called_method in ('addSuccess', 'addFailure', 'addError'):
+            test = data.get('test', args and args[0] or None)
+            record = {'type': 'test', 'scenario': _get_scenario(test),
+                      'result': {'addSuccess': 'success',
+                                 'addFailure': 'failure',
+                                 'addError': 'error'}[called_method],
+                      'agent_id': data.get('agent_id')}
+        else:
+            return
+        self._records.append(record)
+        if len(self._records) >= BATCH_SIZE:
+            self._send()
+
+    def _send(self):
+        records, self._records = self._records, []
+        if not records:
+            return
+        try:
+            self.plugin.call('Sink', 'Push', records)
+        except Exception, e:
+            logger.error('%s could not get %d records: %s' %
+                         (self.name, len(records), e))
+
+    def flush(self):
+        self._send()
+        try:
+            self.plugin.call('Sink', 'Flush', {})
+        except Exception, e:
+            logger.error('%s could not flush: %s' % (self.name, e))
+
+
+class PluginAuth(AuthBase):
+    """Adds the headers an auth plugin returns to every request."""
+    def __init__(self, plugin):
+        self.plugin = plugin
+
+    def __call__(self, request):
+        reply = self.plugin.call('Auth', 'Authenticate',
+                                 {'method': request.method,
+                                  'url': request.url,
+                                  'headers': dict(request.headers)})
+        for name, value in ((reply or {}).get('headers') or {}).items():
+            request.headers[str(name)] = str(value)
+        return request
+
+
+class PluginObserver(WebhookObserver):
+    """Notifies an observer plugin of the events of the runs."""
+    options = []
+
+    def __init__(self, plugin, args=None):
+        super(PluginObserver, self).__init__(args=args)
+        self.plugin = plugin
+        self.name = plugin.name
+
+    def post(self, event):
+        try:
+            self.plugin.call('Observer', 'Notify', event)
+        except Exception, e:
+            logger.error('%s could not get the %s event: %s' %
+                         (self.name, event['name'], e))
+        return []
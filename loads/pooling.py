@@ -0,0 +1,84 @@
+"""The connection pools of the HTTP sessions, to model the clients keeping
+their connections warm as well as the ones opening a new connection for
+every request::
+
+    $ loads-runner example.TestWebSite.test_es -u 50 --idle-timeout 5 \\
+        --max-connections-per-host 4
+
+- *--max-idle-connections N*: the idle connections kept by host, the next
+  ones being closed when they are released -- 1000 by default.
+- *--max-connections-per-host N*: the connections open at once to a host,
+  the next requests waiting for one of them.
+- *--idle-timeout SECONDS*: the connections idle for longer are closed
+  instead of being reused, like the servers and the load balancers do.
+- *--no-keep-alive*: every request gets a new connection, and sends
+  *Connection: close*.
+
+A test case class can change them for some of its scenarios, with its
+*connection_pools* mapping::
+
+    class TestMix(TestCase):
+        scenarios = {'test_browse': 9, 'test_api': 1}
+        connection_pools = {'test_api': {'no_keep_alive': True}}
+
+The requests sent on a new connection are counted in
+*http-connections-new*, the other ones in *http-connections-reused*, and
+the summary gives the reuse ratio.
+"""
+
+
+OPTIONS = ('max_idle_connections', 'max_connections_per_host',
+           'idle_timeout', 'no_keep_alive')
+
+
+class PoolOptions(object):
+    """The options of the connection pools of a session."""
+
+    def __init__(self, max_idle_connections=None,
+                 max_connections_per_host=None, idle_timeout=None,
+                 no_keep_alive=False):
+        for name, value in (('max_idle_connections', max_idle_connections),
+                            ('max_connections_per_host',
+                             max_connections_per_host)):
+            if value is not None and value < 1:
+                raise ValueError('%s is at least 1' % name)
+        if idle_timeout is not None and idle_timeout < 0:
+            raise ValueError('The idle timeout is negative')
+        self.max_idle_connections = max_idle_connections
+        self.max_connections_per_host = max_connections_per_host
+        self.idle_timeout = idle_timeout
+        self.keep_alive = not no_keep_alive
+
+    @property
+    def max_idle(self):
+        """The idle connections a pool keeps, or None for all of them."""
+        if not self.keep_alive:
+            return 0
+        return self.max_idle_connections
+
+
+def get_pool_options(config, scenario=None, pools=None):
+    """Returns the :class:`PoolOptions` of a scenario -- the ones of
+    :param pools: replacing the ones of the configuration -- or None when
+    nothing is set."""
+    options = dict([(name, config.get(name)) for name in OPTIONS])
+    options.update((pools or {}).get(scenario) or {})
+    unknown = set(options) - set(OPTIONS)
+    if unknown:
+        raise ValueError('Unknown pool options: %s' %
+                         ', '.join(sorted(unknown)))
+    if not [value for value in options.values()
+            if value is not None and value is not False]:
+        return None
+    options['no_keep_alive'] = bool(options['no_keep_alive'])
+    return PoolOptions(**options)
+
+
+def get_reuse_ratio(counters):
+    """Returns the ratio of the requests sent on a reused connection, or
+    None when none were counted."""
+    new = counters.get('http-connections-new', 0)
+    reused = counters.get('http-connections-reused', 0)
+    if not new + reused:
+        return None
+    return reused / float(new + reused)
@@ -0,0 +1,24 @@
+_PROVISIONERS = {}
+
+
+def create_provisioner(kind, args):
+    if kind not in _PROVISIONERS:
+        raise NotImplementedError(kind)
+
+    return _PROVISIONERS[kind](args)
+
+
+def register_provisioner(klass):
+    _PROVISIONERS[klass.name] = klass
+
+
+def provisioner_list():
+    return _PROVISIONERS.values()
+
+
+# register our own plugins
+from loads.provisioners._kubernetes import KubernetesProvisioner
+from loads.provisioners._aws import AWSProvisioner
+
+for provisioner in (KubernetesProvisioner, AWSProvisioner):
+    register_provisioner(provisioner)
@@ -0,0 +1,137 @@
+from loads.provisioners.base import Provisioner, ProvisionError
+from loads.util import try_import
+
+
+# installs and starts the agent, tagged with its region, zone and instance
+# type, stops it when the spot instance is about to be interrupted, and shuts
+# the instance down -- which terminates it -- once the agent is gone
+_USER_DATA = """#!/bin/sh
+%(install)s
+METADATA=http://169.254.169.254/latest
+get_token() {
+    curl -s -X PUT $METADATA/api/token \\
+         -H "X-aws-ec2-metadata-token-ttl-seconds: 60"
+}
+TOKEN=$(get_token)
+ZONE=$(curl -s -H "X-aws-ec2-metadata-token: $TOKEN" \\
+       $METADATA/meta-data/placement/availability-zone)
+loads-agent --broker %(broker)s \\
+    --tags region=%(region)s,zone=$ZONE,type=%(type)s &
+AGENT=$!
+while kill -0 $AGENT 2>/dev/null; do
+    TOKEN=$(get_token)
+    if curl -sf -H "X-aws-ec2-metadata-token: $TOKEN" \\
+            $METADATA/meta-data/spot/instance-action > /dev/null; then
+        kill $AGENT
+        break
+    fi
+    sleep 5
+done
+shutdown -h now
+"""
+
+
+class AWSProvisioner(Provisioner):
+    """Runs the agents on EC2 spot instances, booted from an AMI.
+
+    Every instance installs loads, runs an agent, and terminates once the
+    agent is gone -- when the run is over, or when the spot instance is
+    about to be interrupted. The instances have a *loads-provision* tag,
+    and a *loads-run* tag with the id of their run. The agents are tagged
+    with their region, zone and instance type.
+
+    The credentials are the usual ones of boto3.
+    """
+    name = 'aws'
+    options = dict(Provisioner.options, **{
+        'ami': ('The AMI of the instances', str, None, True),
+        'type': ('The instance type', str, 'c5.large', True),
+        'region': ('The region', str, None, True),
+        'price': ('The maximum hourly spot price, by default the on-demand '
+                  'price', str, None, True),
+        'key': ('The name of the key pair of the instances', str, None,
+                True),
+        'groups': ('The comma-separated ids of the security groups', str,
+                   None, True),
+        'subnet': ('The id of the subnet', str, None, True),
+        'install': ('The command that installs loads on the instances', str,
+                    'pip install loads', True)})
+
+    def __init__(self, args):
+        super(AWSProvisioner, self).__init__(args)
+        try_import('boto3')
+        import boto3
+
+        self.ami = self.get_option('ami')
+        if self.ami is None:
+            raise ProvisionError('The AMI of the instances is required')
+        self.ec2 = boto3.client('ec2', region_name=self.get_option('region'))
+        self.instances = []
+
+    def get_user_data(self):
+        return _USER_DATA % {'install': self.get_option('install',
+                                                        'pip install loads'),
+                             'broker': self.broker,
+                             'region': self.ec2.meta.region_name,
+                             'type': self.get_option('type', 'c5.large')}
+
+    def get_instances_options(self, count):
+        spot = {'SpotInstanceType': 'one-time',
+                'InstanceInterruptionBehavior': 'terminate'}
+        if self.get_option('price') is not None:
+            spot['MaxPrice'] = self.get_option('price')
+
+        tags = [{'Key': 'Name', 'Value': 'loads-agent'},
+                {'Key': 'loads-provision', 'Value': self.id}]
+        options = {'ImageId': self.ami,
+                   'InstanceType': self.get_option('type', 'c5.large'),
+                   'MinCount': count,
+                   'MaxCount': count,
+                   'UserData': self.get_user_data(),
+                   'InstanceInitiatedShutdownBehavior': 'terminate',
+                   'InstanceMarketOptions': {'MarketType': 'spot',
+                                             'SpotOptions': spot},
+                   'TagSpecifications': [{'ResourceType': 'instance',
+                                          'Tags': tags}]}
+
+        if self.get_option('key') is not None:
+            options['KeyName'] = self.get_option('key')
+        if self.get_option('groups') is not None:
+            options['SecurityGroupIds'] = [
+                group.strip() for group in self.get_option('groups').split(',')
+                if group.strip()]
+        if self.get_option('subnet') is not None:
+            options['SubnetId'] = self.get_option('subnet')
+        return options
+
+    def _call(self, method, **options):
+        try:
+            return getattr(self.ec2, method)(**options)
+        except Exception, e:
+            raise ProvisionError('EC2 failed: %s' % e)
+
+    def create_agents(self, count):
+        res = self._call('run_instances', **self.get_instances_options(count))
+        self.instances = [instance['InstanceId']
+                          for instance in res['Instances']]
+
+    def run_started(self, run_id):
+        if self.instances:
+            self._call('create_tags', Resources=self.instances,
+                       Tags=[{'Key': 'loads-run', 'Value': run_id}])
+
+    def get_instances(self):
+        """Returns the ids of the instances that are not terminated yet."""
+        filters = [{'Name': 'tag:loads-provision', 'Values': [self.id]},
+                   {'Name': 'instance-state-name',
+                    'Values': ['pending', 'running', 'stopping', 'stopped']}]
+        res = self._call('describe_instances', Filters=filters)
+        return [instance['InstanceId']
+                for reservation in res['Reservations']
+                for instance in reservation['Instances']]
+
+    def delete_agents(self):
+        instances = self.get_instances()
+        if instances:
+            self._call('terminate_instances', InstanceIds=instances)
+        self.instances = []
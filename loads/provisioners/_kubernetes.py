@@ -0,0 +1,91 @@
+import os
+
+import requests
+
+from loads.provisioners.base import Provisioner, ProvisionError
+from loads.util import json
+
+
+_SERVICE_ACCOUNT = '/var/run/secrets/kubernetes.io/serviceaccount'
+
+
+def _read(filename, default=None):
+    if not os.path.exists(filename):
+        return default
+    with open(filename) as f:
+        return f.read().strip()
+
+
+class KubernetesProvisioner(Provisioner):
+    """Runs the agents in pods of a Kubernetes cluster.
+
+    By default, it uses the API, the namespace and the service account of
+    the pod it runs in. The pods of a run have a *loads-provision* label,
+    and are deleted when the run is over.
+    """
+    name = 'kubernetes'
+    options = dict(Provisioner.options, **{
+        'api': ('The URL of the Kubernetes API', str,
+                'https://kubernetes.default.svc', True),
+        'namespace': ('The namespace of the pods', str, None, True),
+        'image': ('The image of the agents, with loads installed', str,
+                  'loads', True),
+        'token': ('The token of the API, by default the one of the service '
+                  'account', str, None, True),
+        'cacert': ('The CA certificate of the API, by default the one of '
+                   'the service account', str, None, True)})
+
+    def __init__(self, args):
+        super(KubernetesProvisioner, self).__init__(args)
+        self.api = self.get_option('api',
+                                   'https://kubernetes.default.svc')
+        self.api = self.api.rstrip('/')
+        self.namespace = self.get_option('namespace') or _read(
+            os.path.join(_SERVICE_ACCOUNT, 'namespace'), 'default')
+        self.image = self.get_option('image', 'loads')
+
+        self.session = requests.Session()
+        token = self.get_option('token') or _read(
+            os.path.join(_SERVICE_ACCOUNT, 'token'))
+        if token is not None:
+            self.session.headers['Authorization'] = 'Bearer %s' % token
+        cacert = self.get_option('cacert')
+        if cacert is None and os.path.exists(
+                os.path.join(_SERVICE_ACCOUNT, 'ca.crt')):
+            cacert = os.path.join(_SERVICE_ACCOUNT, 'ca.crt')
+        if cacert is not None:
+            self.session.verify = cacert
+
+    @property
+    def pods_url(self):
+        return '%s/api/v1/namespaces/%s/pods' % (self.api, self.namespace)
+
+    def get_pod(self, index):
+        return {'apiVersion': 'v1',
+                'kind': 'Pod',
+                'metadata': {'name': 'loads-agent-%s-%d' % (self.id, index),
+                             'labels': {'app': 'loads-agent',
+                                        'loads-provision': self.id}},
+                'spec': {'restartPolicy': 'Never',
+                         'containers': [{'name': 'agent',
+                                         'image': self.image,
+                                         'command': ['loads-agent',
+                                                     '--broker',
+                                                     self.broker]}]}}
+
+    def _check(self, response):
+        if response.status_code >= 400:
+            raise ProvisionError('The Kubernetes API answered %d: %s' % (
+                response.status_code, response.text))
+
+    def create_agents(self, count):
+        for index in range(count):
+            pod = json.dumps(self.get_pod(index))
+            headers = {'Content-Type': 'application/json'}
+            self._check(self.session.post(self.pods_url, data=pod,
+                                          headers=headers))
+
+    def delete_agents(self):
+        selector = 'loads-provision=%s' % self.id
+        self._check(self.session.delete(self.pods_url,
+                                        params={'labelSelector': selector}))
@@ -0,0 +1,128 @@
+import math
+import time
+from uuid import uuid4
+
+from loads.runners.local import _compute_arguments
+from loads.transport.client import Client
+from loads.util import logger, parse_stages
+
+
+DEFAULT_USERS_PER_AGENT = 50
+DEFAULT_PROVISION_TIMEOUT = 300.
+_REGISTRATION_TICK = 1.
+
+
+class ProvisionError(Exception):
+    pass
+
+
+def _share(value, agents):
+    return int(math.ceil(value / float(agents)))
+
+
+def split_load(args, agents):
+    """Returns the arguments of a run of the given number of agents, the
+    users and the rate of :param args: being the total of the run."""
+    args = dict(args)
+    args['agents'] = agents
+
+    users = args.get('users')
+    if users is not None:
+        if isinstance(users, basestring):
+            users = users.split(':')
+        args['users'] = ':'.join([str(_share(int(user), agents))
+                                  for user in users])
+
+    stages = args.get('stages')
+    if stages:
+        if isinstance(stages, basestring):
+            stages = parse_stages(stages)
+        args['stages'] = ','.join(['%s:%d' % (duration, _share(users, agents))
+                                   for duration, users in stages])
+
+    if args.get('max_users'):
+        args['max_users'] = _share(args['max_users'], agents)
+    if args.get('arrival_rate') is not None:
+        args['arrival_rate'] = args['arrival_rate'] / float(agents)
+    return args
+
+
+class Provisioner(object):
+    """Starts the agents of a distributed run, and stops them when the run
+    is over.
+
+    The number of agents is the one given with **--agents**, or else the
+    target number of users of the run divided by the capacity of an agent,
+    given with **--users-per-agent**. The users and the rate of the run are
+    then split between the agents.
+    """
+    name = None
+    options = {'broker': ('The broker endpoint the agents connect to, '
+                          'by default the one of the run', str, None, True)}
+
+    def __init__(self, args):
+        self.args = args
+        self.capacity = args.get('users_per_agent') or DEFAULT_USERS_PER_AGENT
+        self.timeout = (args.get('provision_timeout') or
+                        DEFAULT_PROVISION_TIMEOUT)
+        self.id = args.get('provision_id') or str(uuid4())[:8]
+        self.broker = self.get_option('broker') or args.get('broker')
+
+    def get_option(self, name, default=None):
+        value = self.args.get('provisioner_%s_%s' % (self.name, name))
+        if value is None:
+            return default
+        return value
+
+    def get_agents_count(self):
+        if self.args.get('agents'):
+            return self.args['agents']
+        users = max(_compute_arguments(dict(self.args))[3])
+        return max(1, _share(users, self.capacity))
+
+    def create_agents(self, count):
+        raise NotImplementedError()
+
+    def delete_agents(self):
+        raise NotImplementedError()
+
+    def run_started(self, run_id):
+        """Called with the id of the run, once the broker started it."""
+        pass
+
+    def start(self):
+        """Creates the agents, waits until they are registered on the
+        broker, and returns the arguments of the run."""
+        count = self.get_agents_count()
+        client = Client(self.args['broker'], ssh=self.args.get('ssh'),
+                        api_key=self.args.get('api_key'))
+        try:
+            known = set(client.list())
+            logger.info('Provisioning %d agent(s) with %s' % (count,
+                                                              self.name))
+            self.create_agents(count)
+            try:
+                self.wait_for_agents(client, known, count)
+            except Exception:
+                self.stop()
+                raise
+        finally:
+            client.close()
+
+        return split_load(self.args, count)
+
+    def wait_for_agents(self, client, known, count):
+        deadline = time.time() + self.timeout
+        while True:
+            agents = set(client.list()) - known
+            if len(agents) >= count:
+                return agents
+            if time.time() > deadline:
+                raise ProvisionError('%d of the %d agents registered after '
+                                     '%d seconds' % (len(agents), count,
+                                                     self.timeout))
+            time.sleep(_REGISTRATION_TICK)
+
+    def stop(self):
+        logger.info('Deleting the agents')
+        self.delete_agents()
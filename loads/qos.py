@@ -0,0 +1,88 @@
+"""The IP options of the outgoing traffic: *--hop-limit* sets the hop limit
+of the packets -- the TTL in IPv4 -- and *--traffic-class* their traffic
+class -- the TOS byte in IPv4 -- so the handling of the QoS markings and
+the middleboxes that depend on the TTL can be tested under load::
+
+    $ loads-runner example.TestWebSite.test_es --traffic-class EF
+    $ loads-runner example.TestWebSite.test_es --hop-limit 2
+
+The traffic class is a byte, or the name of a DSCP code point -- *EF*,
+*AF11* to *AF43* or *CS0* to *CS7* -- shifted in the 6 high bits, the 2
+low ones being ECN.
+
+The options are set before the connections are established, so the SYN
+packets have them too, on the HTTP connections and the TCP and UDP
+clients.
+"""
+import re
+import socket
+
+
+# the values of Linux, when Python does not know the options
+IPV6_UNICAST_HOPS = getattr(socket, 'IPV6_UNICAST_HOPS', 16)
+IPV6_TCLASS = getattr(socket, 'IPV6_TCLASS', 67)
+
+_DSCP = re.compile(r'^(?:CS([0-7])|AF([1-4])([1-3])|(EF))$', re.I)
+
+
+def parse_hop_limit(value):
+    """Checks a hop limit, from 1 to 255."""
+    limit = int(value)
+    if not 1 <= limit <= 255:
+        raise ValueError('Invalid hop limit %r' % value)
+    return limit
+
+
+def parse_traffic_class(value):
+    """Returns the traffic class of a byte, or of a DSCP name like "AF41"."""
+    value = str(value).strip()
+    match = _DSCP.match(value)
+    if match is not None:
+        cs, af_class, af_drop, ef = match.groups()
+        if cs is not None:
+            dscp = int(cs) * 8
+        elif ef is not None:
+            dscp = 46
+        else:
+            dscp = int(af_class) * 8 + int(af_drop) * 2
+        return dscp << 2
+
+    traffic_class = int(value, 0)
+    if not 0 <= traffic_class <= 255:
+        raise ValueError('Invalid traffic class %r' % value)
+    return traffic_class
+
+
+class IPOptions(object):
+    """The hop limit and the traffic class of the sockets -- None leaves
+    the one of the system."""
+    def __init__(self, hop_limit=None, traffic_class=None):
+        self.hop_limit = hop_limit
+        self.traffic_class = traffic_class
+
+    def get_socket_options(self, family):
+        """Returns the (level, option, value) of a socket family."""
+        if family == socket.AF_INET6:
+            options = ((socket.IPPROTO_IPV6, IPV6_UNICAST_HOPS,
+                        self.hop_limit),
+                       (socket.IPPROTO_IPV6, IPV6_TCLASS, self.traffic_class))
+        elif family == socket.AF_INET:
+            options = ((socket.IPPROTO_IP, socket.IP_TTL, self.hop_limit),
+                       (socket.IPPROTO_IP, socket.IP_TOS, self.traffic_class))
+        else:
+            return []
+        return [option for option in options if option[2] is not None]
+
+    def apply(self, sock):
+        for level, option, value in self.get_socket_options(sock.family):
+            sock.setsockopt(level, option, value)
+
+
+def get_ip_options(config):
+    """Returns the IP options of the options, or None when there are
+    none."""
+    hop_limit = config.get('hop_limit')
+    traffic_class = config.get('traffic_class')
+    if hop_limit is None and traffic_class is None:
+        return None
+    return IPOptions(hop_limit, traffic_class)
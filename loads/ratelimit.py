@@ -0,0 +1,66 @@
+"""The ceiling of the requests per second of a run: *--max-rps* paces the
+HTTP requests of all the virtual users of a process, so they never go
+above it, whatever the number of users::
+
+    $ loads-runner example.TestWebSite.test_es -u 200 -d 60 --max-rps 500
+
+In distributed mode, the ceiling is the one of the whole run: the broker
+gives every agent its share, and shares it again when an agent is lost or
+replaced -- so adding agents adds no load. *--set-max-rps* changes it
+during the run.
+"""
+import threading
+import time
+
+
+class RateLimiter(object):
+    """Spaces the requests so there are no more than *rate* per second --
+    no limit when the rate is None.
+
+    :param rate: the requests per second.
+    :param clock: returns the current time.
+    :param sleep: waits for some seconds -- gevent.sleep by default.
+    """
+    def __init__(self, rate=None, clock=time.time, sleep=None):
+        self.clock = clock
+        self._sleep = sleep
+        self._lock = threading.Lock()
+        self._next = None
+        self.set_rate(rate)
+
+    def set_rate(self, rate):
+        """Changes the rate, from the next request."""
+        self.rate = rate and float(rate) or None
+        with self._lock:
+            self._next = None
+
+    def acquire(self):
+        """Waits for the next slot, and returns the seconds waited."""
+        if self.rate is None:
+            return 0
+        with self._lock:
+            now = self.clock()
+            if self._next is None or self._next < now:
+                self._next = now
+            delay = self._next - now
+            self._next += 1. / self.rate
+        if delay > 0:
+            self.sleep(delay)
+        return delay
+
+    def sleep(self, seconds):
+        if self._sleep is not None:
+            return self._sleep(seconds)
+        import gevent
+        gevent.sleep(seconds)
+
+
+# the limiter shared by the virtual users of the process -- without a rate
+# until the runner sets the one of the run
+LIMITER = RateLimiter()
+
+
+def get_max_rps(config):
+    """Returns the ceiling of the options: the share of the agent in
+    distributed mode, or the one of the run."""
+    return config.get('agent_max_rps') or config.get('max_rps')
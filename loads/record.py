@@ -0,0 +1,454 @@
+""" Records the requests sent through a proxy, and generates a loads test
+sending them again.
+
+    $ loads-record --create-ca
+    $ loads-record --address localhost:8888 -o test_recorded.py
+
+Point the browser -- or any HTTP client -- to the proxy, browse, then stop
+the proxy with Ctrl-C: the test is written, with a step per burst of
+requests and the idle gaps between them as think times -- see
+:mod:`loads.importer`.
+
+The HTTPS requests are only recorded with a certificate authority of the
+proxy, trusted by the client: the proxy then answers the CONNECT requests
+with a certificate of the host signed by its authority, and reads the
+requests. Without one, they are tunneled to the host and not recorded.
+"""
+import argparse
+import datetime
+import gzip
+import httplib
+import os
+import re
+import select
+import socket
+import ssl
+import subprocess
+import sys
+import tempfile
+import threading
+import time
+import urlparse
+import zlib
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+from cStringIO import StringIO
+from SocketServer import ThreadingMixIn
+
+from loads.importer import DEFAULT_THINK_TIME, get_transactions, write_test
+from loads.transport.metrics import parse_address
+from loads.util import json, logger
+
+
+DEFAULT_ADDRESS = 'localhost:8888'
+DEFAULT_CA_DIR = os.path.join(os.path.expanduser('~'), '.loads', 'ca')
+# the headers of a connection, not of the request
+_HOP_HEADERS = ('connection', 'keep-alive', 'proxy-connection',
+                'proxy-authorization', 'proxy-authenticate', 'te',
+                'trailers', 'transfer-encoding', 'upgrade')
+_TEXT_TYPES = re.compile(r'^(text/|application/(json|xml|javascript|'
+                         r'x-www-form-urlencoded)|[^;]*\+(json|xml))')
+# the bodies recorded, to find the dynamic values of the next requests
+_MAX_TEXT = 1024 * 1024
+_IP = re.compile(r'^[\d.]+$|:')
+
+
+def _openssl(*args):
+    process = subprocess.Popen(('openssl',) + args, stdout=subprocess.PIPE,
+                               stderr=subprocess.PIPE)
+    output, errors = process.communicate()
+    if process.returncode != 0:
+        raise OSError('openssl failed: %s' % errors.strip())
+    return output
+
+
+class CertificateAuthority(object):
+    """The certificate authority of the proxy, signing a certificate for
+    every host it intercepts -- with the openssl command.
+
+    :param directory: where the key and the certificate of the authority
+                      are, and the certificates of the hosts.
+    """
+    def __init__(self, directory=DEFAULT_CA_DIR):
+        self.directory = directory
+        self.key = os.path.join(directory, 'ca.key')
+        self.certificate = os.path.join(directory, 'ca.pem')
+        self.hosts_key = os.path.join(directory, 'hosts.key')
+        self._lock = threading.Lock()
+
+    def exists(self):
+        return os.path.exists(self.key) and os.path.exists(self.certificate)
+
+    def create(self, days=3650):
+        """Creates the key and the certificate of the authority -- the one
+        the clients have to trust -- and returns the path of the
+        certificate."""
+        if not os.path.isdir(self.directory):
+            os.makedirs(self.directory, 0700)
+        _openssl('req', '-x509', '-new', '-newkey', 'rsa:2048', '-nodes',
+                 '-sha256', '-days', str(days), '-subj',
+                 '/CN=Loads recording proxy', '-keyout', self.key,
+                 '-out', self.certificate)
+        os.chmod(self.key, 0600)
+        return self.certificate
+
+    def get_certificate(self, host):
+        """Returns the paths of the certificate of *host* and of its key,
+        signing the certificate the first time."""
+        path = os.path.join(self.directory, 'hosts',
+                            re.sub(r'[^\w.\-]', '_', host) + '.pem')
+        with self._lock:
+            if not os.path.exists(self.hosts_key):
+                _openssl('genrsa', '-out', self.hosts_key, '2048')
+                os.chmod(self.hosts_key, 0600)
+            if os.path.exists(path):
+                return path, self.hosts_key
+            if not os.path.isdir(os.path.dirname(path)):
+                os.makedirs(os.path.dirname(path), 0700)
+
+            kind = _IP.search(host) and 'IP' or 'DNS'
+            fd, extensions = tempfile.mkstemp()
+            try:
+                os.write(fd, 'subjectAltName=%s:%s\n' % (kind, host))
+                os.close(fd)
+                request = _openssl('req', '-new', '-key', self.hosts_key,
+                                   '-subj', '/CN=%s' % host[:64])
+                process = subprocess.Popen(
+                    ('openssl', 'x509', '-req', '-sha256', '-days', '825',
+                     '-CA', self.certificate, '-CAkey', self.key,
+                     '-set_serial', str(int(time.time() * 1000000)),
+                     '-extfile', extensions, '-out', path),
+                    stdin=subprocess.PIPE, stdout=subprocess.PIPE,
+                    stderr=subprocess.PIPE)
+                output, errors = process.communicate(request)
+                if process.returncode != 0:
+                    raise OSError('openssl failed: %s' % errors.strip())
+            finally:
+                os.remove(extensions)
+        return path, self.hosts_key
+
+
+def _decode(body, encoding):
+    encoding = (encoding or '').lower()
+    try:
+        if encoding == 'gzip':
+            return gzip.GzipFile(fileobj=StringIO(body)).read()
+        if encoding == 'deflate':
+            return zlib.decompress(body)
+    except (IOError, zlib.error):
+        return None
+    return body
+
+
+def _har_headers(headers):
+    return [{'name': name, 'value': value} for name, value in headers]
+
+
+class Recorder(object):
+    """Keeps the exchanges going through the proxy, as the entries of a HAR
+    capture."""
+
+    def __init__(self):
+        self.entries = []
+        self._lock = threading.Lock()
+
+    def add(self, started, elapsed, method, url, headers, body, status,
+            reason, response_headers, response_body):
+        query = urlparse.urlsplit(url).query
+        request = {'method': method, 'url': url, 'httpVersion': 'HTTP/1.1',
+                   'headers': _har_headers(headers),
+                   'queryString': [{'name': name, 'value': value}
+                                   for name, value in urlparse.parse_qsl(
+                                       query, keep_blank_values=True)]}
+        headers = dict([(name.lower(), value) for name, value in headers])
+        if body:
+            request['postData'] = {'mimeType': headers.get('content-type',
+                                                           ''),
+                                   'text': body}
+
+        fields = dict([(name.lower(), value)
+                               for name, value in response_headers])
+        mime_type = fields.get('content-type', '')
+        content = {'mimeType': mime_type, 'size': len(response_body)}
+        if _TEXT_TYPES.match(mime_type) and len(response_body) <= _MAX_TEXT:
+            text = _decode(response_body,
+                           fields.get('content-encoding'))
+            if text is not None:
+                content['text'] = text
+
+        entry = {'startedDateTime': started.isoformat() + 'Z',
+                 'time': elapsed * 1000, 'request': request,
+                 'response': {'status': status, 'statusText': reason,
+                              'headers': _har_headers(response_headers),
+                              'content': content}}
+        with self._lock:
+            self.entries.append(entry)
+        logger.info('%s %s %d' % (method, url, status))
+
+    def to_har(self):
+        with self._lock:
+            entries = list(self.entries)
+        return {'log': {'version': '1.2',
+                        'creator': {'name': 'loads-record', 'version': '1'},
+                        'entries': entries}}
+
+    def write_test(self, name='recorded', skip_static=False,
+                   min_think_time=DEFAULT_THINK_TIME):
+        """Returns the source of the test sending the recorded requests."""
+        transactions = get_transactions(self.to_har(), skip_static,
+                                        min_think_time)
+        return write_test(transactions, name)
+
+
+class _ProxyHandler(BaseHTTPRequestHandler):
+    protocol_version = 'HTTP/1.1'
+    # the (host, port) of the CONNECT request, once intercepted
+    _tunnel = None
+
+    def handle_one_request(self):
+        try:
+            BaseHTTPRequestHandler.handle_one_request(self)
+        except socket.error:
+            # the client went away
+            self.close_connection = 1
+
+    def do_CONNECT(self):
+        host, port = self.path, 443
+        if ':' in self.path:
+            host, port = self.path.rsplit(':', 1)
+            port = int(port)
+
+        ca = self.server.ca
+        if ca is None:
+            self._tunnel_to(host, port)
+            return
+
+        certificate, key = ca.get_certificate(host)
+        self.send_response(200, 'Connection Established')
+        self.end_headers()
+        self.wfile.flush()
+        try:
+            connection = ssl.wrap_socket(self.connection, keyfile=key,
+                                         certfile=certificate,
+                                         server_side=True)
+        except (ssl.SSLError, socket.error), e:
+            logger.warning('The TLS handshake with the client failed for %s:'
+                           ' %s -- does it trust the CA?' % (host, e))
+            self.close_connection = 1
+            return
+
+        # the next requests of the connection are read from the TLS socket
+        self.connection = connection
+        self.rfile = connection.makefile('rb', self.rbufsize)
+        self.wfile = connection.makefile('wb', 0)
+        self._tunnel = host, port
+        self.close_connection = 0
+
+    def _tunnel_to(self, host, port):
+        try:
+            upstream = socket.create_connection((host, port),
+                                                self.server.upstream_timeout)
+        except socket.error, e:
+            self.send_error(502, str(e))
+            return
+        self.send_response(200, 'Connection Established')
+        self.end_headers()
+        self.wfile.flush()
+
+        sockets = [self.connection, upstream]
+        timeout = self.server.upstream_timeout
+        try:
+            while True:
+                readable, _, errors = select.select(sockets, [], sockets,
+                                                    timeout)
+                if errors or not readable:
+                    break
+                for sock in readable:
+                    data = sock.recv(65536)
+                    if not data:
+                        return
+                    other = sock is upstream and self.connection or upstream
+                    other.sendall(data)
+        finally:
+            upstream.close()
+            self.close_connection = 1
+
+    def _forward(self):
+        if self._tunnel is not None:
+            host, port = self._tunnel
+            netloc = port == 443 and host or '%s:%d' % (host, port)
+            url = 'https://%s%s' % (netloc, self.path)
+        else:
+            url = self.path
+        parts = urlparse.urlsplit(url)
+        if parts.scheme not in ('http', 'https') or not parts.hostname:
+            self.send_error(400, 'Not a proxy request')
+            return
+
+        length = int(self.headers.get('Content-Length') or 0)
+        body = length and self.rfile.read(length) or ''
+        headers = [(name, value.strip())
+                   for name, value in [line.split(':', 1) for line in
+                                       self.headers.headers
+                                       if ':' in line]
+                   if name.lower() not in _HOP_HEADERS]
+
+        path = urlparse.urlunsplit(('', '', parts.path or '/', parts.query,
+                                    ''))
+        timeout = self.server.upstream_timeout
+        if parts.scheme == 'https':
+            context = None
+            if not self.server.verify:
+                context = ssl._create_unverified_context()
+            connection = httplib.HTTPSConnection(parts.hostname, parts.port,
+                                                 timeout=timeout,
+                                                 context=context)
+        else:
+            connection = httplib.HTTPConnection(parts.hostname, parts.port,
+                                                timeout=timeout)
+        started = datetime.datetime.utcnow()
+        start = time.time()
+        try:
+            connection.putrequest(self.command, path, skip_host=True,
+                                  skip_accept_encoding=True)
+            for name, value in headers:
+                connection.putheader(name, value)
+            if body and 'content-length' not in [
+                    name.lower() for name, value in headers]:
+                connection.putheader('Content-Length', str(len(body)))
+            connection.endheaders()
+            if body:
+                connection.send(body)
+            response = connection.getresponse()
+            response_body = response.read()
+        except (socket.error, httplib.HTTPException, ssl.SSLError), e:
+            self.send_error(502, str(e))
+            return
+        finally:
+            connection.close()
+        elapsed = time.time() - start
+
+        response_headers = [(name, value)
+                            for name, value in response.getheaders()
+                            if name.lower() not in _HOP_HEADERS and
+                            name.lower() != 'content-length']
+        self.send_response(response.status, response.reason)
+        for name, value in response_headers:
+            self.send_header(name, value)
+        self.send_header('Content-Length', str(len(response_body)))
+        self.end_headers()
+        if self.command != 'HEAD':
+            self.wfile.write(response_body)
+
+        self.server.recorder.add(started, elapsed, self.command, url,
+                                 headers, body, response.status,
+                                 response.reason, response_headers,
+                                 response_body)
+
+    do_GET = do_POST = do_PUT = do_PATCH = do_DELETE = do_HEAD = \
+        do_OPTIONS = _forward
+
+    def log_message(self, *args):
+        pass
+
+
+class _Server(ThreadingMixIn, HTTPServer):
+    daemon_threads = True
+
+
+class RecordingProxy(object):
+    """The recording proxy, listening to *address* in a thread.
+
+    :param recorder: the :class:`Recorder` of the exchanges.
+    :param ca: the :class:`CertificateAuthority` intercepting the HTTPS
+               requests. Without it, they are tunneled.
+    :param timeout: the timeout of the connections to the hosts.
+    :param verify: when False, the certificates of the hosts are not
+                   verified.
+    """
+    def __init__(self, recorder, address=DEFAULT_ADDRESS, ca=None,
+                 timeout=30., verify=True):
+        self.recorder = recorder
+        self.server = _Server(parse_address(address), _ProxyHandler)
+        self.server.recorder = recorder
+        self.server.ca = ca
+        self.server.upstream_timeout = timeout
+        self.server.verify = verify
+        self.address = '%s:%d' % self.server.server_address
+        self._thread = None
+
+    def start(self):
+        self._thread = threading.Thread(target=self.server.serve_forever)
+        self._thread.daemon = True
+        self._thread.start()
+
+    def stop(self):
+        if self._thread is not None:
+            self.server.shutdown()
+            self._thread = None
+        self.server.server_close()
+
+
+def main(args=sys.argv[1:]):
+    parser = argparse.ArgumentParser(description='Records the requests sent '
+                                                 'through a proxy into a '
+                                                 'loads test.')
+    parser.add_argument('-o', '--output', default='test_recorded.py',
+                        help='The Python file to write')
+    parser.add_argument('--address', default=DEFAULT_ADDRESS,
+                        help='The host:port the proxy listens to')
+    parser.add_argument('--har', default=None,
+                        help='Also write the capture in this HAR file')
+    parser.add_argument('--ca-dir', default=DEFAULT_CA_DIR,
+                        help='The directory of the certificate authority '
+                             'intercepting the HTTPS requests')
+    parser.add_argument('--create-ca', action='store_true', default=False,
+                        help='Create the certificate authority, and exit')
+    parser.add_argument('--insecure', action='store_true', default=False,
+                        help='Do not verify the certificates of the hosts')
+    parser.add_argument('--skip-static', action='store_true', default=False,
+                        help='Skip the images, style sheets, scripts and '
+                             'fonts')
+    parser.add_argument('--min-think-time', type=float,
+                        default=DEFAULT_THINK_TIME,
+                        help='The idle gaps, in seconds, between two steps')
+    args = parser.parse_args(args)
+
+    ca = CertificateAuthority(args.ca_dir)
+    if args.create_ca:
+        print('Trust %s in your browser, to record the HTTPS requests.'
+              % ca.create())
+        return 0
+    if not ca.exists():
+        logger.warning('No certificate authority in %s: the HTTPS requests '
+                       'are not recorded -- see --create-ca' % args.ca_dir)
+        ca = None
+
+    recorder = Recorder()
+    proxy = RecordingProxy(recorder, args.address, ca,
+                           verify=not args.insecure)
+    proxy.start()
+    print('Recording through http://%s -- Ctrl-C to stop.' % proxy.address)
+    try:
+        while True:
+            time.sleep(1.)
+    except KeyboardInterrupt:
+        pass
+    finally:
+        proxy.stop()
+
+    if args.har is not None:
+        with open(args.har, 'w') as f:
+            json.dump(recorder.to_har(), f)
+    name = os.path.splitext(os.path.basename(args.output))[0]
+    if name.startswith('test_'):
+        name = name[len('test_'):]
+    with open(args.output, 'w') as f:
+        f.write(recorder.write_test(name, args.skip_static,
+                                    args.min_think_time))
+    print('%d requests recorded in %s.' % (len(recorder.entries),
+                                           args.output))
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
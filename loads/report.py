@@ -0,0 +1,523 @@
+""" Turns the results written by the *file* output into a single-file HTML
+report, with no external resources: the charts are inline SVG.
+
+    $ loads-runner example.TestWebSite -u 10 -d 60 --output file \\
+        --output-file-filename results.log
+    $ loads-report results.log -o report.html --threshold "p95 < 250ms"
+
+The thresholds are evaluated on the whole run and on every scenario.
+"""
+import argparse
+import sys
+from cgi import escape
+from collections import defaultdict
+from datetime import datetime
+
+from loads.errors import CATEGORIES
+from loads.histogram import Histogram
+from loads.results import TestResult
+from loads.thresholds import parse_thresholds
+from loads.transport.dashboard import _get_scenario
+from loads.util import json, total_seconds
+
+
+PERCENTILES = (50, 90, 95, 99)
+
+_HIT_FIELDS = ('url', 'method', 'status', 'started', 'elapsed',
+               'loads_status', 'agent_id', 'protocol', 'phases', 'span',
+               'request_id', 'scenario', 'body_size', 'wire_size',
+               'endpoint', 'error_body', 'family', 'server_timings')
+
+_COLORS = ('#1f77b4', '#ff7f0e', '#d62728', '#2ca02c')
+
+_STYLE = """
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: .3em .6em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+th { background: #eee; }
+.failed { color: #d62728; }
+pre { background: #f6f6f6; padding: .5em; overflow: auto; }
+svg text { font-size: 11px; }
+"""
+
+
+def _parse_date(value):
+    if value is None or isinstance(value, datetime):
+        return value
+    for fmt in ('%Y-%m-%dT%H:%M:%S.%f', '%Y-%m-%dT%H:%M:%S'):
+        try:
+            return datetime.strptime(value, fmt)
+        except ValueError:
+            pass
+    raise ValueError('Unknown date %r' % value)
+
+
+def read_results(filename):
+    """Yields the (method, data) of every line of a *file* output."""
+    with open(filename) as f:
+        for line in f:
+            line = line.strip()
+            if not line:
+                continue
+            method, data = line.split(' - ', 1)
+            yield method, json.loads(data)
+
+
+def from_records(records):
+    """Yields the (method, data) of the results of a run kept by the
+    broker."""
+    for record in records:
+        yield record.get('data_type'), record
+
+
+def get_threshold_results(results, thresholds):
+    """Returns the (scenario, threshold, value, passed) of every threshold,
+    on the whole run -- the *All* scenario -- then on every scenario."""
+    results = list(results)
+    scenarios = defaultdict(list)
+    for method, data in results:
+        scenario = _get_scenario(data)
+        if scenario != '?':
+            scenarios[scenario].append((method, data))
+
+    threshold_results = []
+    runs = [('All', results)] + sorted(scenarios.items())
+    for scenario, scenario_results in runs:
+        test_result = Report(scenario_results).test_result
+        for threshold in thresholds:
+            value, passed = threshold.evaluate(test_result)
+            threshold_results.append((scenario, threshold, value, passed))
+    return threshold_results
+
+
+class Report(object):
+    """The results of a run, replayed in a :class:`TestResult`."""
+
+    def __init__(self, results):
+        self.test_result = TestResult()
+        self.hits = []
+        for method, data in results:
+            self.add(method, data)
+
+    def add(self, method, data):
+        result = self.test_result
+        agent_id = data.get('agent_id')
+        loads_status = data.get('loads_status') or (None, None, None, None)
+
+        if method == 'add_hit':
+            hit = dict([(field, data[field]) for field in _HIT_FIELDS
+                        if field in data])
+            hit['started'] = _parse_date(hit.get('started'))
+            hit.setdefault('loads_status', None)
+            result.add_hit(**hit)
+            self.hits.append(result.hits[-1])
+        elif method in ('startTest', 'stopTest', 'addSuccess'):
+            getattr(result, method)(data['test'], loads_status, agent_id)
+        elif method in ('addFailure', 'addError'):
+            getattr(result, method)(data['test'], data.get('exc_info'),
+                                    loads_status, agent_id)
+        elif method == 'add_check':
+            result.add_check(data['name'], data['passed'])
+        elif method in ('set_gauge', 'add_trend'):
+            getattr(result, method)(data['name'], data['value'])
+        elif method == 'chaos_event':
+            result.chaos_event(data['name'], data.get('action'),
+                               data.get('success'), data.get('detail'),
+                               _parse_date(data.get('started')))
+        elif method == 'add_resources':
+            result.add_resources(data['host'], data.get('cpu'),
+                                 data.get('memory'), data.get('connections'),
+                                 _parse_date(data.get('started')))
+
+    @property
+    def start(self):
+        dates = [hit.started for hit in self.hits if hit.started is not None]
+        return dates and min(dates) or None
+
+    @property
+    def duration(self):
+        """The time between the first request and the last response."""
+        if self.start is None:
+            return 0
+        end = max([hit.started + hit.elapsed for hit in self.hits
+                   if hit.started is not None])
+        return total_seconds(end - self.start)
+
+    def get_timeline(self):
+        """Returns a list of (second, hits, errors, histogram) -- one for
+        every second of the run."""
+        seconds = defaultdict(lambda: [0, 0, Histogram()])
+        start = self.start
+        for hit in self.hits:
+            if hit.started is None:
+                continue
+            second = int(total_seconds(hit.started - start))
+            data = seconds[second]
+            data[0] += 1
+            if not hit.success:
+                data[1] += 1
+            data[2].record_value(total_seconds(hit.elapsed) * 10 ** 6)
+
+        timeline = []
+        for second in range(max(seconds.keys() or [-1]) + 1):
+            hits, errors, histogram = seconds.get(second, (0, 0, None))
+            timeline.append((second, hits, errors, histogram))
+        return timeline
+
+    def get_resources(self):
+        """Returns the {host: [(second, sample)]} of the resources of the
+        system under test, on the seconds of the timeline."""
+        start = self.start
+        hosts = {}
+        for host, samples in self.test_result.get_resources().items():
+            points = []
+            for sample in samples:
+                if start is None or sample['started'] is None:
+                    continue
+                second = total_seconds(sample['started'] - start)
+                if second >= 0:
+                    points.append((second, sample))
+            if points:
+                hosts[host] = points
+        return hosts
+
+    def get_chaos_events(self):
+        """Returns the (second, event) of the chaos events, on the seconds
+        of the timeline."""
+        start = self.start
+        events = []
+        for event in self.test_result.chaos_events:
+            if start is None or event['started'] is None:
+                continue
+            events.append((total_seconds(event['started'] - start), event))
+        return events
+
+    def get_url_stats(self):
+        """Returns a list of (url, count, errors, histogram), with the total
+        of all the urls first."""
+        errors = defaultdict(int)
+        for hit in self.hits:
+            if not hit.success:
+                errors[hit.endpoint] += 1
+
+        stats = [('All', len(self.hits), sum(errors.values()),
+                  self.test_result.get_histogram())]
+        for url in sorted(self.test_result.urls):
+            histogram = self.test_result.get_histogram(url)
+            stats.append((url, histogram.total_count, errors[url],
+                          histogram))
+        return stats
+
+    def get_status_errors(self):
+        """Returns the {(url, status): count} of the failed requests."""
+        errors = defaultdict(int)
+        for hit in self.hits:
+            if not hit.success:
+                errors[hit.endpoint, hit.status] += 1
+        return errors
+
+    def get_scenarios(self):
+        """Returns the success, failures and errors of every test, with the
+        failures and errors grouped by message."""
+        scenarios = {}
+        for test in self.test_result.tests.values():
+            name = str(test.name).split()[0]
+            scenario = scenarios.setdefault(name, {
+                'success': 0, 'failures': 0, 'errors': 0,
+                'messages': defaultdict(list)})
+            scenario['success'] += test.success
+            scenario['failures'] += len(test.failures)
+            scenario['errors'] += len(test.errors)
+            for exc_info in test.failures + test.errors:
+                if exc_info:
+                    exc_class, exc, tb = exc_info
+                    message = '%s: %s' % (exc_class, exc)
+                    scenario['messages'][message].append(tb)
+        return scenarios
+
+
+def _ms(value):
+    if value is None:
+        return '-'
+    return '%.1f' % (value / 1000.)
+
+
+def _table(headers, rows):
+    html = ['<table>', '<tr>']
+    html.extend(['<th>%s</th>' % escape(str(h)) for h in headers])
+    html.append('</tr>')
+    for row in rows:
+        html.append('<tr>')
+        html.extend(['<td>%s</td>' % escape(str(cell)) for cell in row])
+        html.append('</tr>')
+    html.append('</table>')
+    return '\n'.join(html)
+
+
+def _chart(series, unit, width=800, height=240, max_x=None, events=()):
+    """Returns an SVG line chart of series, a list of (label, points).
+
+    :param max_x: the end of the x axis, to share it between the charts.
+    :param events: the (x, label) of the events marked on the chart.
+    """
+    left, bottom, top = 60, 30, 20
+    points = [point for label, values in series for point in values]
+    if not points:
+        return '<p>No data.</p>'
+
+    max_x = max([x for x, y in points] + [max_x or 1])
+    max_y = max([y for x, y in points] + [0]) or 1
+    plot_width = width - left - 10
+    plot_height = height - top - bottom
+
+    def pos(x, y):
+        return (left + float(x) / max_x * plot_width,
+                top + plot_height - float(y) / max_y * plot_height)
+
+    svg = ['<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">'
+           % (width, height)]
+    for step in range(5):
+        y = max_y * step / 4.
+        _x, _y = pos(0, y)
+        svg.append('<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" '
+                   'stroke="#ddd"/>' % (left, _y, width - 10, _y))
+        svg.append('<text x="%d" y="%.1f" text-anchor="end">%.1f%s</text>'
+                   % (left - 5, _y + 4, y, unit))
+    for step in range(5):
+        x = max_x * step / 4.
+        _x, _y = pos(x, 0)
+        svg.append('<text x="%.1f" y="%d" text-anchor="middle">%ds</text>'
+                   % (_x, height - 10, x))
+
+    for index, (label, values) in enumerate(series):
+        color = _COLORS[index % len(_COLORS)]
+        line = ' '.join(['%.1f,%.1f' % pos(x, y) for x, y in values])
+        svg.append('<polyline fill="none" stroke="%s" points="%s"/>' %
+                   (color, line))
+        svg.append('<text x="%d" y="12" fill="%s">%s</text>' %
+                   (left + index * 80, color, escape(label)))
+    for x, label in events:
+        if not 0 <= x <= max_x:
+            continue
+        _x, _y = pos(x, max_y)
+        svg.append('<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#999" '
+                   'stroke-dasharray="4,2"/>' % (_x, top, _x,
+                                                 top + plot_height))
+        svg.append('<text x="%.1f" y="%d">%s</text>' % (_x + 3, top + 10,
+                                                        escape(label)))
+    svg.append('</svg>')
+    return '\n'.join(svg)
+
+
+def render(report, title='Loads report', thresholds=None):
+    """Returns the HTML report.
+
+    :param thresholds: the results of the thresholds -- see
+                       :func:`get_threshold_results`.
+    """
+    result = report.test_result
+    html = ['<!DOCTYPE html>', '<html><head><meta charset="utf-8">',
+            '<title>%s</title>' % escape(title),
+            '<style>%s</style>' % _STYLE, '</head><body>',
+            '<h1>%s</h1>' % escape(title)]
+
+    # summary
+    duration = report.duration
+    errors = len([hit for hit in report.hits if not hit.success])
+    rps = duration and len(report.hits) / duration or 0
+    start = report.start and report.start.strftime('%Y-%m-%d %H:%M:%S UTC')
+    html.append(_table(['Started', 'Duration', 'Requests', 'Requests/s',
+                        'Failed requests', 'Tests', 'Failures', 'Errors'],
+                       [[start or '-', '%.1fs' % duration, len(report.hits),
+                         '%.1f' % rps, errors, result.nb_tests,
+                         result.nb_failures, result.nb_errors]]))
+
+    if thresholds:
+        html.append('<h2>Thresholds</h2>')
+        html.append('<table>\n<tr><th>Scenario</th><th>Threshold</th>'
+                    '<th>Value</th><th>Result</th></tr>')
+        for scenario, threshold, value, passed in thresholds:
+            html.append('<tr%s><td>%s</td><td>%s</td><td>%s</td><td>%s</td>'
+                        '</tr>' % (not passed and ' class="failed"' or '',
+                                   escape(scenario), escape(str(threshold)),
+                                   escape(threshold.format(value)),
+                                   passed and 'passed' or 'FAILED'))
+        html.append('</table>')
+
+    # the charts
+    timeline = report.get_timeline()
+    latencies = []
+    for percentile in (50, 95, 99):
+        values = [(second, histogram.get_value_at_percentile(percentile) /
+                   1000.) for second, hits, errors, histogram in timeline
+                  if histogram is not None]
+        latencies.append(('p%d' % percentile, values))
+    chaos_events = report.get_chaos_events()
+    markers = [(second, event['name']) for second, event in chaos_events]
+    html.append('<h2>Latency over time</h2>')
+    html.append(_chart(latencies, 'ms', events=markers))
+
+    throughput = [('requests/s', [(second, hits) for second, hits, errors,
+                                  histogram in timeline]),
+                  ('errors/s', [(second, errors) for second, hits, errors,
+                                histogram in timeline])]
+    html.append('<h2>Throughput</h2>')
+    html.append(_chart(throughput, '', events=markers))
+
+    if chaos_events:
+        html.append('<h2>Chaos events</h2>')
+        rows = [['%.1fs' % second, event['name'], event['action'],
+                 event['success'] and 'OK' or 'FAILED', event['detail'] or '']
+                for second, event in chaos_events]
+        html.append(_table(['Time', 'Event', 'Action', 'Result', 'Detail'],
+                           rows))
+
+    # the resources of the system under test, on the same timeline
+    resources = report.get_resources()
+    if resources:
+        usage, connections, rows = [], [], []
+        for host, points in sorted(resources.items()):
+            for name in ('cpu', 'memory'):
+                usage.append(('%s %s' % (host, name),
+                              [(second, sample[name]) for second, sample
+                               in points if sample[name] is not None]))
+            connections.append((host, [
+                (second, sample['connections']) for second, sample in points
+                if sample['connections'] is not None]))
+            cpu = [sample['cpu'] for second, sample in points
+                   if sample['cpu'] is not None]
+            memory = [sample['memory'] for second, sample in points
+                      if sample['memory'] is not None]
+            conns = [sample['connections'] for second, sample in points
+                     if sample['connections'] is not None]
+            rows.append([host, len(points),
+                         cpu and '%.1f' % (sum(cpu) / len(cpu)) or '-',
+                         cpu and '%.1f' % max(cpu) or '-',
+                         memory and '%.1f' % max(memory) or '-',
+                         conns and str(max(conns)) or '-'])
+        html.append('<h2>Server resources</h2>')
+        end = len(timeline) - 1
+        html.append(_chart(usage, '%', max_x=end))
+        html.append(_chart(connections, '', max_x=end))
+        html.append(_table(['Host', 'Samples', 'CPU avg (%)', 'CPU max (%)',
+                            'Memory max (%)', 'Connections max'], rows))
+
+    # the percentiles
+    html.append('<h2>Request times (ms)</h2>')
+    rows = []
+    for url, count, url_errors, histogram in report.get_url_stats():
+        row = [url, count, url_errors, _ms(histogram.get_mean() or None)]
+        row.extend([_ms(histogram.get_value_at_percentile(percentile))
+                    for percentile in PERCENTILES])
+        row.append(_ms(histogram.max))
+        rows.append(row)
+    html.append(_table(['URL', 'Requests', 'Failed', 'Average'] +
+                       ['p%d' % percentile for percentile in PERCENTILES] +
+                       ['Max'], rows))
+
+    # the errors
+    status_errors = report.get_status_errors()
+    if status_errors:
+        html.append('<h2>Failed requests</h2>')
+        rows = [[url, status, count] for (url, status), count
+                in sorted(status_errors.items())]
+        html.append(_table(['URL', 'Status', 'Count'], rows))
+
+    groups = result.get_error_groups()
+    if groups:
+        html.append('<h2>Errors by category</h2>')
+        rows = []
+        for category in CATEGORIES:
+            for index, example in enumerate(
+                    groups.get(category, {}).get('examples', [])):
+                rows.append([index == 0 and category or '',
+                             index == 0 and groups[category]['count'] or '',
+                             example])
+        html.append(_table(['Category', 'Count', 'Example'], rows))
+
+    checks = result.get_checks()
+    if checks:
+        html.append('<h2>Checks</h2>')
+        rows = [[name, counts['passed'], counts['failed']]
+                for name, counts in sorted(checks.items())]
+        html.append(_table(['Check', 'Passed', 'Failed'], rows))
+
+    gauges, trends = result.get_gauges(), result.get_trends()
+    if gauges or trends:
+        html.append('<h2>Custom metrics</h2>')
+        rows = [[name, 'gauge', gauge.count, '%g' % gauge.last,
+                 '%g' % gauge.min, '%g' % gauge.max, '']
+                for name, gauge in sorted(gauges.items())]
+        rows.extend([[name, 'trend', trend.count, '%g' % trend.get('avg'),
+                      '%g' % trend.get('min'), '%g' % trend.get('max'),
+                      '%g' % trend.get('p95')]
+                     for name, trend in sorted(trends.items())])
+        html.append(_table(['Metric', 'Kind', 'Values', 'Last / avg', 'Min',
+                            'Max', 'p95'], rows))
+
+    server_timings = result.get_server_timings()
+    if server_timings:
+        html.append('<h2>Server timings</h2>')
+        rows = []
+        for endpoint, timings in sorted(server_timings.items()):
+            client = result.average_request_time(endpoint) * 1000
+            for name, trend in sorted(timings.items()):
+                rows.append([endpoint, name, trend.count,
+                             '%.1f' % client, '%.1f' % trend.get('avg'),
+                             '%.1f' % trend.get('p95')])
+        html.append(_table(['Endpoint', 'Timing', 'Responses',
+                            'Request avg (ms)', 'Server avg',
+                            'Server p95'], rows))
+
+    # the scenarios
+    for name, scenario in sorted(report.get_scenarios().items()):
+        html.append('<h2>Scenario %s</h2>' % escape(name))
+        html.append(_table(['Success', 'Failures', 'Errors'],
+                           [[scenario['success'], scenario['failures'],
+                             scenario['errors']]]))
+        messages = sorted(scenario['messages'].items(),
+                          key=lambda item: -len(item[1]))
+        for message, tracebacks in messages:
+            html.append('<p class="failed">%d x %s</p>' %
+                        (len(tracebacks), escape(message)))
+            if tracebacks[0]:
+                html.append('<pre>%s</pre>' % escape(tracebacks[0]))
+
+    html.append('</body></html>')
+    return '\n'.join(html)
+
+
+def main(args=sys.argv[1:]):
+    parser = argparse.ArgumentParser(description='Generates a HTML report '
+                                                 'of the results written '
+                                                 'by the file output.')
+    parser.add_argument('results', help='The file written by the file '
+                                        'output')
+    parser.add_argument('-o', '--output', default='report.html',
+                        help='The HTML file to write')
+    parser.add_argument('--title', default='Loads report',
+                        help='The title of the report')
+    parser.add_argument('--threshold', action='append', default=None,
+                        help='A threshold evaluated on the run and on '
+                             'every scenario, like "p95 < 250ms"')
+    args = parser.parse_args(args)
+
+    try:
+        thresholds = parse_thresholds(args.threshold)
+    except ValueError, e:
+        parser.error(str(e))
+
+    report = Report(read_results(args.results))
+    if thresholds:
+        thresholds = get_threshold_results(read_results(args.results),
+                                           thresholds)
+    with open(args.output, 'w') as f:
+        f.write(render(report, args.title, thresholds))
+    print('Report written in %s' % args.output)
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
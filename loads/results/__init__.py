@@ -2,5 +2,6 @@ from loads.results.adapter import LoadsTestResult   # NOQA
 from loads.results._unittest import UnitTestTestResult   # NOQA
 from loads.results.zmqrelay import ZMQTestResult  # NOQA
 from loads.results.zmqrelay import ZMQSummarizedTestResult  # NOQA
+from loads.results.natsrelay import NATSTestResult  # NOQA
 from loads.results.base import TestResult   # NOQA
 from loads.results.remote import RemoteTestResult   # NOQA
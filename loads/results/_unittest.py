@@ -24,3 +24,9 @@ class UnitTestTestResult(unittest.TestResult):
 
     def incr_counter(self, test, *args, **kw):
         pass
+
+    def set_gauge(self, *args, **kw):
+        pass
+
+    def add_trend(self, *args, **kw):
+        pass
@@ -1,5 +1,14 @@
 import functools
 
+from gevent import GreenletExit
+
+
+def _add_error(add_error, incr_counter, test, exc_info, *args, **kw):
+    if exc_info and isinstance(exc_info[1], GreenletExit):
+        # killed after the grace period of the run -- see LocalRunner
+        return incr_counter(test, name='killed-tests')
+    return add_error(test, exc_info, *args, **kw)
+
 
 class LoadsTestResult(object):
     """Used to make unitest calls compatible with Loads.
@@ -17,5 +26,9 @@ class LoadsTestResult(object):
         if name in ('startTest', 'stopTest', 'addSuccess', 'addException',
                     'addError', 'addFailure', 'incr_counter'):
             status = klass.__getattribute__('loads_status')
-            return functools.partial(attr, loads_status=status)
+            attr = functools.partial(attr, loads_status=status)
+            if name == 'addError':
+                incr_counter = functools.partial(result.incr_counter,
+                                                 loads_status=status)
+                return functools.partial(_add_error, attr, incr_counter)
         return attr
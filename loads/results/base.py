@@ -2,7 +2,15 @@ import itertools
 from collections import defaultdict
 
 from datetime import datetime, timedelta
-from loads.util import get_quantiles, total_seconds, seconds_to_time, unbatch
+from loads.custom import Gauge, Trend
+from loads.errors import get_error_groups
+from loads.histogram import Histogram
+from loads.util import (total_seconds, seconds_to_time, unbatch,
+                        parse_duration)
+
+
+# the length of the intervals of the request times histograms, in seconds
+HISTOGRAM_INTERVAL = 10
 
 
 class TestResult(object):
@@ -21,13 +29,42 @@ class TestResult(object):
     def __init__(self, config=None, args=None):
         self.config = config
         self.hits = []
+        self.histograms = {}
+        self.interval_histograms = {}
+        self.phase_histograms = {}
         self.tests = {}
         self.opened_sockets = self.closed_sockets = 0
         self.socket_data_received = 0
+        # the bytes of the bodies of the HTTP responses, decompressed and as
+        # received
+        self.bytes_received = 0
+        self.wire_bytes_received = 0
+        self.socket_disconnects = 0
+        self.socket_connect_times = []
+        self.socket_rtts = []
+        self.current_stage = None
+        self.checks = {}
+        # the custom gauges and trends, by name -- see loads.custom
+        self.gauges = {}
+        self.trends = {}
+        # the trends of the timings reported by the servers, by endpoint --
+        # see loads.servertiming
+        self.server_timings = {}
+        # the samples of the resources of the system under test -- see
+        # loads.monitor
+        self.resources = []
+        # the chaos events of the run -- see loads.chaos
+        self.chaos_events = []
+        self.lost_agents = []
+        # the tags of every agent, like {'1234': {'region': 'eu-west'}}
+        self.agent_tags = {}
         self.start_time = None
         self.stop_time = None
         self.observers = []
         self.args = args
+        # the hits and the tests of the warm-up, left out of the results
+        self.warmup_hits = 0
+        self.warmup_tests = {}
 
     def __str__(self):
         duration = seconds_to_time(self.duration)
@@ -89,8 +126,9 @@ class TestResult(object):
 
     @property
     def urls(self):
-        """Returns the URLs that had been called."""
-        return set([h.url for h in self.hits])
+        """Returns the endpoints that had been called -- the URLs, their
+        patterns or their labels."""
+        return set([h.endpoint for h in self.hits])
 
     @property
     def nb_tests(self):
@@ -104,8 +142,8 @@ class TestResult(object):
         """Filters the hits with the given parameters.
 
         :param url:
-            The url you want to filter with. Only the hits targetting this URL
-            will be returned.
+            The endpoint you want to filter with. Only the hits targetting
+            this endpoint will be returned.
 
         :param series:
             Only the hits done during this series will be returned.
@@ -114,7 +152,7 @@ class TestResult(object):
             if series is not None and _hit.series != series:
                 return False
 
-            if url is not None and _hit.url != url:
+            if url is not None and _hit.endpoint != url:
                 return False
 
             return True
@@ -147,6 +185,58 @@ class TestResult(object):
 
         return filter(_filter, self.tests.values())
 
+    def get_histogram(self, url=None, series=None):
+        """Returns the histogram of the request times, in microseconds.
+
+        :param url: the url to filter on.
+        :param series: the series to filter on.
+        """
+        histogram = Histogram()
+        for (_url, _series), other in self.histograms.items():
+            if url is not None and _url != url:
+                continue
+            if series is not None and _series != series:
+                continue
+            histogram.add(other)
+        return histogram
+
+    def get_corrected_histogram(self, url=None, series=None):
+        """Returns the histogram of the request times, corrected for the
+        coordinated omission in the closed model.
+
+        In the closed model, a user waits for a response before sending the
+        next request, so the slow responses hide the requests that would have
+        been sent in the meantime. They are added back, using the
+        *expected_interval* option -- or the average request time -- as the
+        interval between two requests of a user.
+        """
+        histogram = self.get_histogram(url, series)
+        args = self.args or {}
+        if args.get('arrival_rate') is not None:
+            return histogram
+
+        interval = args.get('expected_interval')
+        if interval is None:
+            interval = histogram.get_mean()
+        else:
+            interval = interval * 10 ** 6
+        return histogram.corrected(interval)
+
+    def get_phase_metrics(self):
+        """Returns the average and the 95th percentile of the time spent
+        in every phase of the HTTP requests (in seconds)."""
+        metrics = {}
+        for phase, histogram in self.phase_histograms.items():
+            p95 = histogram.get_value_at_percentile(95)
+            metrics[phase] = {'average': histogram.get_mean() / 10 ** 6,
+                              'p95': float(p95) / 10 ** 6}
+        return metrics
+
+    def get_interval_histograms(self):
+        """Returns a sorted list of (start, histogram) -- one for every
+        interval of HISTOGRAM_INTERVAL seconds."""
+        return sorted(self.interval_histograms.items())
+
     def average_request_time(self, url=None, series=None):
         """Computes the average time a request takes (in seconds)
 
@@ -157,31 +247,54 @@ class TestResult(object):
             You can filter by the series, to only know the average request time
             during a particular series.
         """
-        elapsed = [total_seconds(h.elapsed)
-                   for h in self._get_hits(url, series)]
-
-        if elapsed:
-            return float(sum(elapsed)) / len(elapsed)
-        else:
-            return 0
+        return self.get_histogram(url, series).get_mean() / 10 ** 6
+
+    def average_socket_connect_time(self):
+        """Computes the average time it takes to open a web socket (in
+        seconds)."""
+        if self.socket_connect_times:
+            return (float(sum(self.socket_connect_times)) /
+                    len(self.socket_connect_times))
+        return 0
+
+    def average_socket_rtt(self):
+        """Computes the average round-trip time of the messages sent
+        through the web sockets (in seconds)."""
+        if self.socket_rtts:
+            return float(sum(self.socket_rtts)) / len(self.socket_rtts)
+        return 0
+
+    def get_request_time_percentile(self, percentile, url=None,
+                                    series=None):
+        """Returns the request time (in seconds) under which the given
+        percentage of the requests are, or None if there are no requests.
+
+        The coordinated omission is corrected in the closed model.
+        """
+        histogram = self.get_corrected_histogram(url, series)
+        value = histogram.get_value_at_percentile(percentile)
+        if value is None:
+            return None
+        return float(value) / 10 ** 6
 
     def get_request_time_quantiles(self, url=None, series=None):
-        elapsed = [total_seconds(h.elapsed)
-                   for h in self._get_hits(url=url, series=series)]
-
-        # XXX Cache these results, they might be long to compute.
-        return get_quantiles(elapsed, (0, 0.1, 0.5, 0.9, 1))
+        histogram = self.get_corrected_histogram(url, series)
+        if histogram.total_count == 0:
+            return []
+        return [float(histogram.get_value_at_percentile(q)) / 10 ** 6
+                for q in (0, 10, 50, 90, 100)]
 
     def hits_success_rate(self, url=None, series=None):
         """Returns the success rate for the filtered hits.
 
-        (A success is a hit with a status code of 2XX or 3XX).
+        (A success is a hit with a status code of 2XX or 3XX, or a gRPC
+        call with the OK status).
 
         :param url: the url to filter on.
         :param hit: the hit to filter on.
         """
         hits = list(self._get_hits(url, series))
-        success = [h for h in hits if 200 <= h.status < 400]
+        success = [h for h in hits if h.success]
 
         if hits:
             return float(len(success)) / len(hits)
@@ -195,9 +308,76 @@ class TestResult(object):
                 urls[url][metric] = getattr(self, metric)(url)
         return urls
 
+    def get_error_groups(self):
+        """Returns the count and a few examples of every category of the
+        errors and failures of the tests, and of the failed requests -- see
+        loads.errors."""
+        exc_infos = [exc_info for exc_infos in itertools.chain(
+                     self.errors, self.failures) for exc_info in exc_infos]
+        return get_error_groups(exc_infos, self.hits)
+
+    def get_tag_metrics(self):
+        """Returns the number of hits, the average and the 95th percentile of
+        the request times (in seconds) and the success rate of the hits of
+        every tag value of the agents, like
+        {'region': {'eu-west': {...}, 'us-east': {...}}}.
+
+        With *--ip-family*, the hits are also compared by the address family
+        of their connection, under 'address family'.
+        """
+        groups = defaultdict(lambda: defaultdict(list))
+        by_family = bool((self.args or {}).get('ip_family'))
+        for hit in self.hits:
+            for tag, value in self.agent_tags.get(str(hit.agent_id),
+                                                  {}).items():
+                groups[tag][value].append(hit)
+            if by_family and hit.family is not None:
+                groups['address family'][hit.family].append(hit)
+
+        metrics = {}
+        for tag, values in groups.items():
+            metrics[tag] = {}
+            for value, hits in values.items():
+                histogram = Histogram()
+                for hit in hits:
+                    elapsed = total_seconds(hit.elapsed)
+                    histogram.record_value(int(round(elapsed * 10 ** 6)))
+                p95 = histogram.get_value_at_percentile(95)
+                success = [hit for hit in hits if hit.success]
+                metrics[tag][value] = {
+                    'hits': len(hits),
+                    'average_request_time': histogram.get_mean() / 10 ** 6,
+                    'p95': float(p95) / 10 ** 6,
+                    'success_rate': float(len(success)) / len(hits)}
+        return metrics
+
+    @property
+    def warmup(self):
+        """The seconds of the start of the run left out of the results,
+        while the target warms up."""
+        warmup = (self.args or {}).get('warmup')
+        return warmup and parse_duration(warmup) or 0
+
+    @property
+    def measured_duration(self):
+        """The duration of the run, without its warm-up."""
+        return max(self.duration - self.warmup, 0)
+
+    def in_warmup(self, when=None):
+        """Tells whether the run was warming up at this time -- now by
+        default."""
+        if not self.warmup or self.start_time is None:
+            return False
+        if not isinstance(when, datetime):
+            when = datetime.utcnow()
+        return when < self.start_time + timedelta(seconds=self.warmup)
+
     def tests_per_second(self):
-        delta = self.stop_time - self.start_time
-        return self.nb_tests / total_seconds(delta)
+        duration = (total_seconds(self.stop_time - self.start_time) -
+                    self.warmup)
+        if self.warmup and duration <= 0:
+            return 0
+        return self.nb_tests / duration
 
     def average_test_duration(self, test=None, series=None):
         durations = [t.duration for t in self._get_tests(test, series)
@@ -211,10 +391,31 @@ class TestResult(object):
             return sum(rates) / len(rates)
         return 1
 
+    def get_test_metrics(self):
+        """Returns the number of runs, the success rate and the average
+        duration of every test -- e.g. of every scenario.
+        """
+        tests = defaultdict(list)
+        for test in self.tests.values():
+            tests[str(test.name)].append(test)
+
+        metrics = {}
+        for name, runs in tests.items():
+            durations = [t.duration for t in runs if t.finished]
+            if durations:
+                duration = float(sum(durations)) / len(durations)
+            else:
+                duration = 0
+            rates = [t.success_rate for t in runs]
+            metrics[name] = {'tests': len(runs),
+                             'success_rate': sum(rates) / len(rates),
+                             'average_test_duration': duration}
+        return metrics
+
     def requests_per_second(self, url=None, hit=None):
-        if self.duration == 0:
+        if self.measured_duration == 0:
             return 0
-        return float(len(self.hits)) / self.duration
+        return float(len(self.hits)) / self.measured_duration
 
     # batched results
     def batch(self, **args):
@@ -238,7 +439,11 @@ class TestResult(object):
     def startTest(self, test, loads_status, agent_id=None):
         hit, user, current_hit, current_user = loads_status
         key = self._get_key(test, loads_status, agent_id)
-        if key not in self.tests:
+        if key in self.tests or key in self.warmup_tests:
+            return
+        if self.in_warmup():
+            self.warmup_tests[key] = Test(name=test, hit=hit, user=user)
+        else:
             self.tests[key] = Test(name=test, hit=hit, user=user)
 
     def stopTest(self, test, loads_status, agent_id=None):
@@ -258,9 +463,9 @@ class TestResult(object):
         test = self._get_test(test, loads_status, agent_id)
         test.success += 1
 
-    def incr_counter(self, test, loads_status, name, agent_id=None):
+    def incr_counter(self, test, loads_status, name, agent_id=None, value=1):
         test = self._get_test(test, loads_status, agent_id)
-        test.incr_counter(name)
+        test.incr_counter(name, value)
 
     def get_counter(self, name, test=None):
         return sum([t.get_counter(name) for t in self._get_tests(name=test)])
@@ -273,24 +478,149 @@ class TestResult(object):
         return counters
 
     def add_hit(self, **data):
-        self.hits.append(Hit(**data))
-
-    def socket_open(self, agent_id=None):
+        hit = Hit(**data)
+        if self.in_warmup(hit.started):
+            self.warmup_hits += 1
+            return
+        self.hits.append(hit)
+        self._record_hit(hit)
+
+    def _record_hit(self, hit):
+        value = int(round(total_seconds(hit.elapsed) * 10 ** 6))
+        if hit.body_size is not None:
+            self.bytes_received += hit.body_size
+            if hit.wire_size is None:
+                self.wire_bytes_received += hit.body_size
+            else:
+                self.wire_bytes_received += hit.wire_size
+
+        key = hit.endpoint, hit.series
+        if key not in self.histograms:
+            self.histograms[key] = Histogram()
+        self.histograms[key].record_value(value)
+
+        started = hit.started
+        if not isinstance(started, datetime):
+            started = datetime.utcnow()
+        start = started.replace(microsecond=0)
+        start -= timedelta(seconds=start.second % HISTOGRAM_INTERVAL)
+        if start not in self.interval_histograms:
+            self.interval_histograms[start] = Histogram()
+        self.interval_histograms[start].record_value(value)
+
+        for phase, elapsed in (hit.phases or {}).items():
+            if phase not in self.phase_histograms:
+                self.phase_histograms[phase] = Histogram()
+            value = int(round(elapsed * 10 ** 6))
+            self.phase_histograms[phase].record_value(value)
+
+        if hit.server_timings:
+            timings = self.server_timings.setdefault(hit.endpoint, {})
+            for name, value in hit.server_timings.items():
+                self.trends.setdefault(name, Trend()).add(value)
+                timings.setdefault(name, Trend()).add(value)
+
+    def socket_open(self, elapsed=None, agent_id=None):
         self.opened_sockets += 1
+        if elapsed is not None:
+            self.socket_connect_times.append(elapsed)
 
     def socket_close(self, agent_id=None):
         self.closed_sockets += 1
 
+    def socket_disconnect(self, code=None, agent_id=None):
+        self.closed_sockets += 1
+        self.socket_disconnects += 1
+
     def socket_message(self, size, agent_id=None):
         self.socket_data_received += size
 
+    def socket_rtt(self, elapsed, agent_id=None):
+        self.socket_rtts.append(elapsed)
+
+    def stage_started(self, stage, users, duration, agent_id=None):
+        self.current_stage = {'stage': stage, 'users': users,
+                              'duration': duration}
+
+    def checkpoint(self, elapsed, iterations, feeders=None, agent_id=None):
+        # the broker keeps the checkpoints, to resume the run
+        pass
+
+    def add_check(self, name, passed, agent_id=None):
+        if self.in_warmup():
+            return
+        counts = self.checks.setdefault(name, {'passed': 0, 'failed': 0})
+        if passed:
+            counts['passed'] += 1
+        else:
+            counts['failed'] += 1
+
+    def get_checks(self):
+        return self.checks
+
+    def set_gauge(self, name, value, agent_id=None):
+        if self.in_warmup():
+            return
+        self.gauges.setdefault(name, Gauge()).set(value)
+
+    def get_gauges(self):
+        return self.gauges
+
+    def add_trend(self, name, value, agent_id=None):
+        if self.in_warmup():
+            return
+        self.trends.setdefault(name, Trend()).add(value)
+
+    def get_trends(self):
+        return self.trends
+
+    def get_server_timings(self):
+        """Returns the trends of the timings reported by the servers, by
+        endpoint and by name."""
+        return self.server_timings
+
+    def add_resources(self, host, cpu=None, memory=None, connections=None,
+                      started=None):
+        """A sample of the resources of a host of the system under test:
+        the CPU and the memory used, in percents, and the established
+        connections."""
+        if started is None:
+            started = datetime.utcnow()
+        self.resources.append({'host': host, 'cpu': cpu, 'memory': memory,
+                               'connections': connections,
+                               'started': started})
+
+    def get_resources(self):
+        """Returns the samples of every host, ordered by time."""
+        hosts = {}
+        for sample in self.resources:
+            hosts.setdefault(sample['host'], []).append(sample)
+        return hosts
+
+    def chaos_event(self, name, action, success, detail=None, started=None):
+        """A chaos event happened during the run -- :param success: tells
+        whether its action worked."""
+        if started is None:
+            started = datetime.utcnow()
+        self.chaos_events.append({'name': name, 'action': action,
+                                  'success': success, 'detail': detail,
+                                  'started': started})
+
+    def agent_lost(self, agent_id=None, replacement=None):
+        """An agent stopped answering during the run. :param replacement:
+        is the agent that took its place, if any."""
+        self.lost_agents.append((agent_id, replacement))
+
     def __getattribute__(self, name):
         # call the observer's "push" method after calling the method of the
         # test_result itself.
         attr = object.__getattribute__(self, name)
         if name in ('startTestRun', 'stopTestRun', 'startTest', 'stopTest',
                     'addError', 'addFailure', 'addSuccess', 'add_hit',
-                    'socket_open', 'socket_message', 'incr_counter'):
+                    'socket_open', 'socket_message', 'incr_counter',
+                    'stage_started', 'socket_rtt', 'socket_disconnect',
+                    'add_check', 'agent_lost', 'set_gauge', 'add_trend',
+                    'add_resources', 'chaos_event'):
 
             def wrapper(*args, **kwargs):
                 ret = attr(*args, **kwargs)
@@ -308,9 +638,11 @@ class TestResult(object):
 
     def _get_test(self, test, loads_status, agent_id):
         key = self._get_key(test, loads_status, agent_id)
-        if key not in self.tests:
+        if key not in self.tests and key not in self.warmup_tests:
             self.startTest(test, loads_status, agent_id)
 
+        if key in self.warmup_tests:
+            return self.warmup_tests[key]
         return self.tests[key]
 
     def sync(self, run_id):
@@ -323,10 +655,36 @@ class Hit(object):
     Used for later computation.
     """
     def __init__(self, url, method, status, started, elapsed, loads_status,
-                 agent_id=None):
+                 agent_id=None, protocol=None, phases=None, span=None,
+                 request_id=None, scenario=None, body_size=None,
+                 wire_size=None, endpoint=None, error_body=None,
+                 family=None, server_timings=None):
         self.url = url
+        # the hits are aggregated by endpoint: the label of the URL, or its
+        # pattern -- see loads.endpoints
+        self.endpoint = endpoint or url
+        # the start of the body of a 4xx or 5xx response
+        self.error_body = error_body
         self.method = method
         self.status = status
+        self.protocol = protocol
+        # the address family of the connection, "ipv4" or "ipv6"
+        self.family = family
+        # the time spent in every phase of a HTTP request, in seconds
+        self.phases = phases
+        # the timings reported in the headers of the response, by name --
+        # see loads.servertiming
+        self.server_timings = server_timings
+        # the (trace id, span id) sent in the traceparent header
+        self.span = span
+        # the id sent in the --request-id-header header
+        self.request_id = request_id
+        # the name of the test method that sent the request
+        self.scenario = scenario
+        # the bytes of the body, decompressed, and the ones that were
+        # received -- compressed with a Content-Encoding
+        self.body_size = body_size
+        self.wire_size = wire_size
         self.started = started
         if not isinstance(elapsed, timedelta):
             elapsed = timedelta(seconds=elapsed)
@@ -339,6 +697,12 @@ class Hit(object):
 
         self.agent_id = agent_id
 
+    @property
+    def success(self):
+        if isinstance(self.status, basestring):
+            return self.status == 'OK'
+        return 200 <= self.status < 400
+
 
 class Test(object):
     """Represent a test that had been run."""
@@ -358,8 +722,8 @@ class Test(object):
         for key, value in kwargs.items():
             setattr(self, key, value)
 
-    def incr_counter(self, name):
-        self._counters[name] += 1
+    def incr_counter(self, name, value=1):
+        self._counters[name] += value
 
     @property
     def finished(self):
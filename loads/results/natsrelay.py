@@ -0,0 +1,55 @@
+from uuid import uuid4
+
+import gevent
+
+from loads.results.zmqrelay import ZMQSummarizedTestResult
+from loads.transport.nats import NATSClient, NATSError, DEFAULT_NATS_SUBJECT
+
+
+class NATSTestResult(ZMQSummarizedTestResult):
+    """Relays the results in batches to a JetStream stream.
+
+    A batch is published again until JetStream stored it, with the same
+    message id, so the copies are dropped. A batch JetStream did not store
+    in time is merged with the next one, like when the broker does not keep
+    up with :class:`ZMQSummarizedTestResult`.
+    """
+    retries = 5
+    retry_delay = 1.
+
+    def _init_socket(self):
+        self._client = None
+        self.subject = '%s.%s' % (DEFAULT_NATS_SUBJECT,
+                                  self.args.get('run_id'))
+
+    def _close_client(self):
+        if self._client is not None:
+            self._client.close()
+            self._client = None
+
+    def _send(self, payload, block=True):
+        if isinstance(payload, unicode):
+            payload = payload.encode('utf8')
+        msg_id = uuid4().hex
+
+        # without blocking, the batch is merged with the next one instead
+        for attempt in range(block and self.retries or 1):
+            if attempt > 0:
+                gevent.sleep(self.retry_delay)
+            try:
+                if self._client is None:
+                    self._client = NATSClient(self.args['nats_receiver'])
+                    self._client.connect()
+                self._client.store(self.subject, payload, msg_id=msg_id)
+                return True
+            except NATSError, e:
+                error = e
+                self._close_client()
+
+        if not block:
+            return False
+        raise NATSError('JetStream did not store the results: %s' % error)
+
+    def close(self):
+        super(NATSTestResult, self).close()
+        self._close_client()
@@ -25,7 +25,8 @@ class RemoteTestResult(TestResult):
                       'nb_success': 'addSuccess',
                       'nb_tests': 'startTest',
                       'socket': 'socket_open',
-                      'socket_data_received': 'socket_message'}
+                      'socket_data_received': 'socket_message',
+                      'socket_disconnects': 'socket_disconnect'}
 
         values = ('errors', 'failures')
 
@@ -49,12 +50,32 @@ class RemoteTestResult(TestResult):
         elif name == 'errors':
             key = 'addError'
 
-        client = Client(self.args['broker'])
+        client = Client(self.args['broker'],
+                        api_key=self.args.get('api_key'))
 
         for line in client.get_data(self.run_id, data_type=key):
             line = line['exc_info']
             yield [line]
 
+    def get_checks(self):
+        """Calls the broker to get the results of the checks.
+        """
+        if self.args.get('agents') is None:
+            return TestResult.get_checks(self)
+
+        checks = {}
+        client = Client(self.args['broker'],
+                        api_key=self.args.get('api_key'))
+
+        for line in client.get_data(self.run_id, data_type='add_check'):
+            counts = checks.setdefault(line['name'],
+                                       {'passed': 0, 'failed': 0})
+            if line['passed']:
+                counts['passed'] += 1
+            else:
+                counts['failed'] += 1
+        return checks
+
     def sync(self, run_id):
         if self.args.get('agents') is None:
             return
@@ -64,6 +85,7 @@ class RemoteTestResult(TestResult):
         # we're asking the broker about the latest counts
         self.counts = defaultdict(int)
 
-        client = Client(self.args['broker'])
+        client = Client(self.args['broker'],
+                        api_key=self.args.get('api_key'))
         for line in client.get_data(run_id, groupby=True):
             self.counts[line['data_type']] += line['count']
@@ -1,14 +1,20 @@
 from cStringIO import StringIO
 import traceback
 import errno
+import zlib
 from collections import defaultdict
 
 import zmq.green as zmq
 import gevent
 from gevent.queue import Queue
 
-from loads.util import DateTimeJSONEncoder
-from loads.transport.util import get_hostname
+from loads.util import DateTimeJSONEncoder, SUMMARIZED_FIELDS, logger
+from loads.transport.util import get_hostname, connect
+
+
+DEFAULT_BATCH_INTERVAL = 1.
+DEFAULT_BATCH_SIZE = 1000
+DEFAULT_MAX_PENDING = 100
 
 
 class ZMQTestResult(object):
@@ -27,7 +33,7 @@ class ZMQTestResult(object):
         self._push = self.context.socket(zmq.PUSH)
         self._push.set_hwm(8096 * 10)
         self._push.setsockopt(zmq.LINGER, -1)
-        self._push.connect(receive)
+        connect(self._push, receive)
 
     def startTest(self, test, loads_status):
         self.push('startTest',
@@ -75,27 +81,54 @@ class ZMQTestResult(object):
     def add_hit(self, **data):
         self.push('add_hit', **data)
 
-    def socket_open(self):
-        self.push('socket_open')
+    def socket_open(self, elapsed=None):
+        self.push('socket_open', elapsed=elapsed)
 
     def socket_close(self):
         self.push('socket_close')
 
+    def socket_disconnect(self, code=None):
+        self.push('socket_disconnect', code=code)
+
     def socket_message(self, size):
         self.push('socket_message', size=size)
 
-    def incr_counter(self, test, loads_status, name, agent_id=None):
+    def socket_rtt(self, elapsed):
+        self.push('socket_rtt', elapsed=elapsed)
+
+    def stage_started(self, stage, users, duration):
+        self.push('stage_started', stage=stage, users=users,
+                  duration=duration)
+
+    def add_check(self, name, passed):
+        self.push('add_check', name=name, passed=passed)
+
+    def set_gauge(self, name, value):
+        self.push('set_gauge', name=name, value=value)
+
+    def add_trend(self, name, value):
+        self.push('add_trend', name=name, value=value)
+
+    def checkpoint(self, elapsed, iterations, feeders=None):
+        self.push('checkpoint', elapsed=elapsed, iterations=iterations,
+                  feeders=feeders)
+
+    def incr_counter(self, test, loads_status, name, agent_id=None, value=1):
+        # the broker adds up the sizes of the messages
         self.push(name, test=str(test), loads_status=loads_status,
-                  agent_id=str(agent_id))
+                  agent_id=str(agent_id), size=value)
 
     def push(self, data_type, **data):
         data.update({'data_type': data_type,
                      'agent_id': self.agent_id,
                      'hostname': get_hostname(),
                      'run_id': self.run_id})
+        self._send(self.encoder.encode(data))
+
+    def _send(self, payload):
         while True:
             try:
-                self._push.send(self.encoder.encode(data), zmq.NOBLOCK)
+                self._push.send(payload, zmq.NOBLOCK)
                 return
             except zmq.ZMQError as e:
                 if e.errno in (errno.EAGAIN, errno.EWOULDBLOCK):
@@ -110,45 +143,132 @@ class ZMQTestResult(object):
         self.context.destroy()
 
 
+def summarize_hits(counts):
+    """Merges the hits of a batch with the same endpoint, method, status
+    and series in *add_hits* summaries, with the list of their times.
+
+    The sizes of the bodies are kept along the times. The other fields are
+    the ones of the first hit, and the phases, spans, ids and server
+    timings of the requests are dropped.
+    """
+    summaries = {}
+    for summary in counts.pop('add_hits', []) + counts.pop('add_hit', []):
+        series = (summary.get('loads_status') or [None])[0]
+        key = (summary.get('endpoint') or summary['url'], summary['method'],
+               summary['status'],
+               summary.get('protocol'), summary.get('family'),
+               summary.get('scenario'), series)
+        values = {}
+        for field in SUMMARIZED_FIELDS:
+            if field in summary:
+                value = summary[field]
+                if not isinstance(value, list):
+                    value = [value]
+                values[field] = value
+        count = len(values['elapsed'])
+
+        if key in summaries:
+            merged = summaries[key]
+            before = len(merged['elapsed'])
+            for field in SUMMARIZED_FIELDS:
+                if field in merged or field in values:
+                    merged.setdefault(field, [None] * before).extend(
+                        values.get(field, [None] * count))
+            continue
+
+        summary = dict([(name, value) for name, value in summary.items()
+                        if name not in ('phases', 'span', 'request_id',
+                                        'server_timings')])
+        summary.update(values)
+        summaries[key] = summary
+
+    if summaries:
+        counts['add_hits'] = summaries.values()
+
+
 class ZMQSummarizedTestResult(ZMQTestResult):
+    """Relays the results in batches, every *batch_interval* seconds or
+    every *batch_size* results, compressed if *compress_results* is set.
+
+    When the broker does not keep up -- the batches pile up in the send
+    queue -- a batch that can't be sent is merged with the next one, and the
+    hits are summarized with :func:`summarize_hits` until the broker caught
+    up. Nothing is dropped.
+    """
     def __init__(self, args):
         super(ZMQSummarizedTestResult, self).__init__(args)
-        self.interval = 1.
+        self.interval = args.get('batch_interval') or DEFAULT_BATCH_INTERVAL
+        self.batch_size = args.get('batch_size') or DEFAULT_BATCH_SIZE
+        self.compress = args.get('compress_results', False)
+        self.degraded = False
+        self._pending = None
         self._data = Queue()
         gevent.spawn_later(self.interval, self._dump_data)
 
+    def _init_socket(self):
+        super(ZMQSummarizedTestResult, self)._init_socket()
+        self._push.set_hwm(DEFAULT_MAX_PENDING)
+
     def push(self, data_type, **data):
         self._data.put_nowait((data_type, data))
+        if self._data.qsize() >= self.batch_size:
+            self._dump_data(loop=False)
 
     def close(self):
-        while not self._data.empty():
-            self._dump_data(loop=False)
+        while self._pending is not None or not self._data.empty():
+            self._dump_data(loop=False, block=True)
         self.context.destroy()
 
-    def _dump_data(self, loop=True):
-        if self._data.empty() and loop:
+    def _send(self, payload, block=True):
+        """Sends the payload, and returns False if it could not be sent
+        without blocking."""
+        if block:
+            self._push.send(payload)
+            return True
+
+        try:
+            self._push.send(payload, zmq.NOBLOCK)
+        except zmq.ZMQError as e:
+            if e.errno in (errno.EAGAIN, errno.EWOULDBLOCK):
+                return False
+            raise
+        return True
+
+    def _dump_data(self, loop=True, block=False):
+        if loop:
             gevent.spawn_later(self.interval, self._dump_data)
-            return
 
-        data = {'data_type': 'batch',
-                'agent_id': self.agent_id,
-                'hostname': get_hostname(),
-                'run_id': self.run_id,
-                'counts': defaultdict(list)}
+        data = self._pending
+        if data is None:
+            if self._data.empty():
+                return
+            data = {'data_type': 'batch',
+                    'agent_id': self.agent_id,
+                    'hostname': get_hostname(),
+                    'run_id': self.run_id,
+                    'counts': defaultdict(list)}
+        self._pending = None
 
         # grabbing what we have
         for _ in range(self._data.qsize()):
             data_type, message = self._data.get()
             data['counts'][data_type].append(message)
 
-        while True:
-            try:
-                self._push.send(self.encoder.encode(data), zmq.NOBLOCK)
-                break
-            except zmq.ZMQError as e:
-                if e.errno in (errno.EAGAIN, errno.EWOULDBLOCK):
-                    continue
-                else:
-                    raise
-        if loop:
-            gevent.spawn_later(self.interval, self._dump_data)
+        if self.degraded:
+            summarize_hits(data['counts'])
+
+        payload = self.encoder.encode(data)
+        if self.compress:
+            payload = zlib.compress(payload)
+
+        if self._send(payload, block=block):
+            if self.degraded:
+                logger.info('The broker caught up, the hits are not '
+                            'summarized anymore')
+            self.degraded = False
+        else:
+            if not self.degraded:
+                logger.warning('The broker does not keep up, the hits are '
+                               'summarized')
+            self.degraded = True
+            self._pending = data
@@ -3,11 +3,15 @@ import json
 import zmq.green as zmq
 from zmq.green.eventloop import ioloop, zmqstream
 
+from loads.chaos import kill_agents
 from loads.runners.local import LocalRunner
-from loads.transport.util import DEFAULT_PUBLISHER, DEFAULT_SSH_PUBLISHER
-from loads.util import logger, split_endpoint
+from loads.transport.util import (DEFAULT_PUBLISHER, DEFAULT_SSH_PUBLISHER,
+                                  connect, join_endpoints, split_endpoints,
+                                  resolve_endpoint)
+from loads.util import logger
 from loads.results import TestResult, RemoteTestResult
 from loads.transport.client import Client
+from loads.transport.redisstats import RedisLiveStats
 
 
 class DistributedRunner(LocalRunner):
@@ -22,12 +26,16 @@ class DistributedRunner(LocalRunner):
     name = 'distributed'
     options = {}
 
-    def __init__(self, args):
+    def __init__(self, args, provisioner=None):
         super(DistributedRunner, self).__init__(args)
+        self.provisioner = provisioner
         self.ssh = args.get('ssh')
         self.run_id = None
         self._stopped_agents = 0
         self._nb_agents = args.get('agents')
+        self._preempted = False
+        self._position = None
+        self._live_totals = None
 
         # socket where the results are published
         self.context = zmq.Context()
@@ -52,7 +60,8 @@ class DistributedRunner(LocalRunner):
     def client(self):
         if self._client is None:
             self._client = Client(self.args['broker'],
-                                  ssh=self.args.get('ssh'))
+                                  ssh=self.args.get('ssh'),
+                                  api_key=self.args.get('api_key'))
         return self._client
 
     @property
@@ -89,7 +98,12 @@ class DistributedRunner(LocalRunner):
                              and 'stopTestRun' in data['counts'])
             agent_stopped = agent_stopped or data_type == 'stopTestRun'
 
-            if agent_stopped:
+            if data_type in ('run-queue', 'run-preempted', 'run-started'):
+                self._handle_queue(data_type, run_id, data)
+            elif agent_stopped and self._preempted:
+                # the run goes back to the queue, it is not over
+                pass
+            elif agent_stopped:
                 # Make sure all the agents are finished before stopping the
                 # loop.
                 self._stopped_agents += 1
@@ -100,10 +114,47 @@ class DistributedRunner(LocalRunner):
                 if run_id == self.run_id:
                     self.test_result.sync(self.run_id)
                     self.loop.stop()
+            elif data_type == 'agent_lost':
+                if run_id == self.run_id and data['replacement'] is None:
+                    # that agent will never stop: don't wait for it
+                    self._nb_agents -= 1
+                    if self._stopped_agents >= self._nb_agents:
+                        self.test_result.sync(self.run_id)
+                        self.loop.stop()
         except Exception:
             self.loop.stop()
             raise
 
+    def _handle_queue(self, data_type, run_id, data):
+        if data_type == 'run-queue':
+            positions = [entry['position'] for entry in data['queue']
+                         if entry['run_id'] == self.run_id]
+            if positions and positions[0] != self._position:
+                logger.info('The run is at position %d in the queue' %
+                            positions[0])
+            self._position = positions and positions[0] or None
+        elif run_id != self.run_id:
+            return
+        elif data_type == 'run-preempted':
+            logger.info('The run is preempted by a run of higher priority')
+            self._preempted = True
+        elif data_type == 'run-started':
+            logger.info('The run started on %d agent(s)' %
+                        len(data['agents']))
+            self._preempted = False
+            self._stopped_agents = 0
+            self._nb_agents = len(data['agents'])
+            self.agents = data['agents']
+
+    def _get_publisher(self, endpoint):
+        if endpoint.startswith('ipc'):
+            # IPC - lets hope we're on the same box
+            return endpoint
+        elif endpoint.startswith('tcp'):
+            # TCP, with the broker ip if it is bound to 0.0.0.0
+            return resolve_endpoint(endpoint, self.args['broker'])
+        return DEFAULT_PUBLISHER
+
     def _attach_publisher(self):
         zmq_publisher = self.args.get('zmq_publisher')
 
@@ -111,27 +162,14 @@ class DistributedRunner(LocalRunner):
             # if this option is not provided by the command line,
             # we ask the broker about it
             res = self.client.ping()
-            endpoint = res['endpoints']['publisher']
-            if endpoint.startswith('ipc'):
-                # IPC - lets hope we're on the same box
-                zmq_publisher = endpoint
-            elif endpoint.startswith('tcp'):
-                # TCP, let's see what IP & port we have
-                splitted = split_endpoint(endpoint)
-                if splitted['ip'] == '0.0.0.0':
-                    # let's use the broker ip
-                    broker = self.args['broker']
-                    broker_ip = split_endpoint(broker)['ip']
-                    zmq_publisher = 'tcp://%s:%d' % (broker_ip,
-                                                     splitted['port'])
-                else:
-                    # let's use the original ip
-                    zmq_publisher = endpoint
-            else:
-                zmq_publisher = DEFAULT_PUBLISHER
+            endpoints = res['endpoints']['publisher']
+            # the broker may have a standby, publishing on its own socket
+            zmq_publisher = join_endpoints(*[
+                self._get_publisher(endpoint)
+                for endpoint in split_endpoints(endpoints)])
 
         if not self.ssh:
-            self.sub.connect(zmq_publisher)
+            connect(self.sub, zmq_publisher)
         else:
             if zmq_publisher == DEFAULT_PUBLISHER:
                 zmq_publisher = DEFAULT_SSH_PUBLISHER
@@ -147,10 +185,21 @@ class DistributedRunner(LocalRunner):
         self.test_result.startTestRun()
         detached = self.args.get('detach')
 
+        monitor = chaos = None
         if not detached:
             cb = ioloop.PeriodicCallback(self.refresh, self.refresh_rate,
                                          self.loop)
             cb.start()
+            # the resources of the system under test, sampled from here
+            if self.monitor is not None:
+                monitor = ioloop.PeriodicCallback(
+                    self.monitor.sample, self.monitor.interval * 1000,
+                    self.loop)
+                monitor.start()
+            if self.chaos is not None:
+                chaos = ioloop.PeriodicCallback(self._tick_chaos, 500,
+                                                self.loop)
+                chaos.start()
 
         try:
             self._attach_publisher()
@@ -158,6 +207,13 @@ class DistributedRunner(LocalRunner):
             res = self.client.run(self.args)
             self.run_id = res['run_id']
             self.agents = res['agents']
+            if res.get('queued'):
+                self._position = res['queued']
+                logger.info('Run %s queued at position %d' % (self.run_id,
+                                                              res['queued']))
+            if self.provisioner is not None:
+                self.provisioner.run_started(self.run_id)
+            self._set_agent_tags()
 
             if not detached:
                 logger.debug('Waiting for results')
@@ -169,6 +225,9 @@ class DistributedRunner(LocalRunner):
             if not detached:
                 # end..
                 cb.stop()
+                for callback in (monitor, chaos):
+                    if callback is not None:
+                        callback.stop()
                 self.test_result.stopTestRun()
                 self.context.destroy()
                 self.flush()
@@ -176,8 +235,39 @@ class DistributedRunner(LocalRunner):
     def cancel(self):
         self.client.stop_run(self.run_id)
 
+    def _tick_chaos(self):
+        # the time of the events starts with the run, not in the queue
+        if self._position is None:
+            self.chaos.tick()
+
+    def _get_agent_killer(self):
+        def _kill(event):
+            return kill_agents(self.client, self.agents, event)
+        return _kill
+
+    def _set_agent_tags(self):
+        # the tags the agents registered with, to report per tag value
+        agents = self.client.list()
+        self.test_result.agent_tags = dict([
+            (agent_id, info['tags']) for agent_id, info in agents.items()
+            if info.get('tags')])
+
+    def _evaluate_threshold(self, threshold):
+        # with --live-redis, on the totals the brokers kept in Redis
+        if self.args.get('live_redis') is None:
+            return super(DistributedRunner, self)._evaluate_threshold(
+                threshold)
+
+        if self._live_totals is None:
+            stats = RedisLiveStats(self.args['live_redis'])
+            self._live_totals = stats.get_totals(self.run_id)['total']
+        return threshold.evaluate_live(self._live_totals)
+
     def attach(self, run_id, started, counts, args):
+        # the key is not a part of the metadata of the run
+        args['api_key'] = self.args.get('api_key')
         self._attach_publisher()
+        self._set_agent_tags()
         self.test_result.args = args
         self.test_result.startTestRun(when=started)
         self.test_result.set_counts(counts)
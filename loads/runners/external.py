@@ -205,6 +205,7 @@ class ExternalRunner(LocalRunner):
             - LOADS_CURRENT_USER for the current user number
             - LOADS_TOTAL_HITS for the total number of hits in this step
             - LOADS_DURATION for the total duration of this step, if any
+            - LOADS_HTTP_ENGINE for the HTTP engine of the runner, if any
 
         We use environment variables because that's the easiest way to pass
         parameters to non-python executables.
@@ -221,6 +222,8 @@ class ExternalRunner(LocalRunner):
             env['LOADS_TOTAL_HITS'] = str(self.step_hits)
         else:
             env['LOADS_DURATION'] = str(self._duration)
+        if self.args.get('http_engine'):
+            env['LOADS_HTTP_ENGINE'] = self.args['http_engine']
 
         def silent_output():
             null_streams([sys.stdout, sys.stderr, sys.stdin])
@@ -1,16 +1,39 @@
 import os
+import signal
 import subprocess
 import sys
+import time
 
 import gevent
+from gevent.pool import Group
+from gevent.queue import Queue, Empty
 
 from loads.util import (resolve_name, logger, pack_include_files,
-                        unpack_include_files, set_logger)
-from loads.results import ZMQTestResult, TestResult, ZMQSummarizedTestResult
+                        unpack_include_files, set_logger, parse_stages, json)
+from loads.results import (ZMQTestResult, TestResult,
+                           ZMQSummarizedTestResult, NATSTestResult)
+from loads.feeders import get_positions
+from loads.lifecycle import get_lifecycle, has_lifecycle, run_setup
 from loads.output import create_output
+from loads.chaos import Chaos, read_chaos
+from loads.monitor import get_monitor
+from loads.pacing import get_pacing
+from loads.plugins import PluginOutput, get_plugins
+from loads.ratelimit import LIMITER, get_max_rps
+from loads.baseline import (DEFAULT_ERROR_MARGIN, DEFAULT_MARGIN,
+                            write_baseline)
+from loads.thresholds import parse_thresholds, read_thresholds
+from loads.seeds import get_random, reset as reset_users
+from loads.transport.util import (PAUSE_SIGNAL, RESUME_SIGNAL, ABORT_SIGNAL,
+                                  LOAD_SIGNAL, LOAD_FILE)
 
 
 DEFAULT_LOGFILE = os.path.join('/tmp', 'loads-worker.log')
+DEFAULT_MAX_USERS = 1000
+STAGE_TICK = .1
+PAUSE_TICK = .1
+DEFAULT_CHECKPOINT_INTERVAL = 30.
+DEFAULT_GRACE_PERIOD = 5.
 
 
 def _compute_arguments(args):
@@ -26,7 +49,13 @@ def _compute_arguments(args):
     users = [int(user) for user in users]
     hits = args.get('hits')
     duration = args.get('duration')
-    if duration is None and hits is None:
+    iterations = args.get('iterations')
+    if iterations is not None and (args.get('iterations_per_user') or
+                                   args.get('arrival_rate') is not None):
+        # the same as the hits of every user, or as the arrivals
+        hits = [int(iterations)]
+        iterations = None
+    if duration is None and hits is None and iterations is None:
         hits = '1'
 
     if hits is not None:
@@ -35,17 +64,89 @@ def _compute_arguments(args):
 
     agents = args.get('agents', 1)
 
+    # when the load shape is given as stages, they drive both the duration
+    # and the number of users.
+    stages = args.get('stages')
+    if stages:
+        if isinstance(stages, basestring):
+            stages = parse_stages(stages)
+        args['stages'] = stages
+        hits = None
+        duration = sum([stage_duration for stage_duration, _ in stages])
+        users = [max([1] + [stage_users for _, stage_users in stages])]
+
+    # in arrival rate mode, the users are only a cap, and each hit is
+    # a single arrival.
+    if args.get('arrival_rate') is not None:
+        users = [args.get('max_users') or DEFAULT_MAX_USERS]
+
     # XXX duration based == no total
     total = 0
     if duration is None:
-        for user in users:
-            total += sum([hit * user for hit in hits])
+        if args.get('arrival_rate') is not None:
+            total = sum(hits)
+        elif hits is None:
+            # the iterations are shared by the users of every step
+            total = int(iterations) * len(users)
+        else:
+            for user in users:
+                total += sum([hit * user for hit in hits])
         if agents is not None:
             total *= agents
 
     return total, hits, duration, users, agents
 
 
+def _get_stage(stages, elapsed):
+    """Returns the (index, users) of the stage running after :param elapsed:
+    seconds.
+
+    The number of users is interpolated between the target of the previous
+    stage (or 0 for the first one) and the target of the current stage.
+    """
+    start = 0
+    previous = 0
+    for index, (duration, users) in enumerate(stages):
+        if elapsed < start + duration:
+            progress = (elapsed - start) / float(duration)
+            return index, int(round(previous + (users - previous) * progress))
+        start += duration
+        previous = users
+
+    return len(stages) - 1, previous
+
+
+def _get_scenarios(klass):
+    """Returns the sequence of the scenarios the users of the given
+    test case class run, following the weights of its *scenarios* mapping.
+
+    The scenarios are interleaved with a smooth weighted round-robin, so
+    any number of consecutive users is as close as possible to the
+    weights.
+    """
+    weights = sorted(klass.scenarios.items())
+    for name, weight in weights:
+        if not hasattr(klass, name):
+            raise ValueError('%r has no %r scenario' % (klass, name))
+        if weight < 0:
+            raise ValueError('The weight of %r is negative' % name)
+
+    total = sum([weight for name, weight in weights])
+    if total == 0:
+        raise ValueError('The scenarios of %r have no weight' % klass)
+
+    current = dict([(name, 0) for name, weight in weights])
+    sequence = []
+    for i in range(total):
+        for name, weight in weights:
+            current[name] += weight
+        selected = max(weights, key=lambda item: current[item[0]])[0]
+        current[selected] -= total
+        sequence.append(selected)
+
+    return sequence
+
+
 class LocalRunner(object):
     """Local tests runner.
 
@@ -57,6 +158,15 @@ class LocalRunner(object):
       outputs.
     - The "slave" mode where the results are sent to a ZMQ endpoint and no
       output is called.
+
+    In slave mode, the runner also sends a checkpoint of the run every
+    *checkpoint_interval* seconds: the elapsed time, the iterations done and
+    the positions of the feeders. A run given a *checkpoint* goes on from
+    there.
+
+    When a run ends or is aborted, no new test starts, and the tests in
+    flight have *grace_period* seconds to complete -- their requests are
+    recorded as usual. The users still running after that are killed.
     """
 
     name = 'local'
@@ -66,19 +176,57 @@ class LocalRunner(object):
         self.args = args
         self.fqn = args.get('fqn')
         self.test = None
+        self.scenarios = None
         self.slave = args.get('slave', False)
         if self.slave:
             set_logger(True, logfile=args.get('logfile', DEFAULT_LOGFILE))
 
         self.run_id = None
+        # the users of the run are numbered from 0 -- see loads.seeds
+        reset_users()
         self.project_name = args.get('project_name', 'N/A')
         self._test_result = None
         self.outputs = []
         self.stop = False
+        self.paused = False
+        self.target_users = None
+        self.target_rate = None
 
         (self.total, self.hits,
          self.duration, self.users, self.agents) = _compute_arguments(args)
 
+        self.arrival_rate = args.get('arrival_rate')
+        self.dropped_arrivals = 0
+        self._dropped_test = None
+        self.stages = args.get('stages')
+        self.thresholds = parse_thresholds(args.get('threshold'))
+        self._monitor = self._chaos = None
+        if args.get('threshold_file') and not args.get('slave'):
+            self.thresholds.extend(read_thresholds(args['threshold_file']))
+        # the minimum duration of the iterations of the closed model --
+        # see loads.pacing
+        self.pacing = get_pacing(args)
+        # the iterations shared by the users, when they end the run
+        self.max_iterations = None
+        if self.hits is None and self.duration is None:
+            self.max_iterations = int(args['iterations'])
+        self._remaining = 0
+
+        # the state of the run when it is resumed from a checkpoint
+        checkpoint = args.get('checkpoint') or {}
+        self.checkpoint_interval = args.get('checkpoint_interval',
+                                            DEFAULT_CHECKPOINT_INTERVAL)
+        self.iterations = self._skip = checkpoint.get('iterations') or 0
+        self.grace_period = args.get('grace_period')
+        if self.grace_period is None:
+            self.grace_period = DEFAULT_GRACE_PERIOD
+        self._resumed_at = checkpoint.get('elapsed') or 0.
+        self._started = None
+        if checkpoint.get('feeders'):
+            self.args['feeder_positions'] = checkpoint['feeders']
+
+        if self.stages:
+            self.args['duration'] = self.duration
         self.args['hits'] = self.hits
         self.args['users'] = self.users
         self.args['agents'] = self.agents
@@ -88,12 +236,28 @@ class LocalRunner(object):
         if self.fqn is not None:
             self.test = resolve_name(self.fqn)
 
+            # a test case class with scenarios: every user runs one of them
+            if (isinstance(self.test, type) and
+                    getattr(self.test, 'scenarios', None)):
+                self.scenarios = _get_scenarios(self.test)
+                # with --seed, the agents start at their own place in the
+                # sequence
+                rand = get_random(self.args, 'scenarios')
+                if rand is not None:
+                    start = rand.randrange(len(self.scenarios))
+                    self.scenarios = (self.scenarios[start:] +
+                                      self.scenarios[:start])
+                self.test = getattr(self.test, self.scenarios[0])
+
     @property
     def test_result(self):
         if self._test_result is None:
             # If we are in slave mode, set the test_result to a 0mq relay
+            # or to JetStream, if the broker gets the results from NATS
             if self.slave:
-                if self.args.get('batched', False):
+                if self.args.get('nats_receiver'):
+                    self._test_result = NATSTestResult(self.args)
+                elif self.args.get('batched', False):
                     self._test_result = ZMQSummarizedTestResult(self.args)
                 else:
                     self._test_result = ZMQTestResult(self.args)
@@ -104,6 +268,27 @@ class LocalRunner(object):
 
         return self._test_result
 
+    @property
+    def monitor(self):
+        """The sampler of the resources of the system under test -- see
+        loads.monitor -- or None."""
+        if self._monitor is None and not self.slave:
+            self._monitor = get_monitor(self.args, self.test_result)
+        return self._monitor
+
+    @property
+    def chaos(self):
+        """The chaos events of the run -- see loads.chaos -- or None."""
+        if (self._chaos is None and not self.slave and
+                self.args.get('chaos')):
+            self._chaos = Chaos(read_chaos(self.args['chaos']),
+                                self.test_result, self._get_agent_killer())
+        return self._chaos
+
+    def _get_agent_killer(self):
+        # the local runs have no agents
+        return None
+
     def register_output(self, output_name):
         output = create_output(output_name, self.test_result, self.args)
         self.outputs.append(output)
@@ -160,13 +345,22 @@ class LocalRunner(object):
         if not self.slave:
             for output in self.args.get('output', ['stdout']):
                 self.register_output(output)
+            for plugin in get_plugins(self.args, 'sink'):
+                output = PluginOutput(self.test_result, self.args, plugin)
+                self.outputs.append(output)
+                self.test_result.add_observer(output)
 
         old_location = os.getcwd()
         self.running = True
         try:
             self._execute()
-            if (not self.slave and
-                    self.test_result.nb_errors + self.test_result.nb_failures):
+            if self.slave:
+                return 0
+            if self.args.get('baseline'):
+                self._write_baseline()
+            passed = self._check_thresholds()
+            if (self.test_result.nb_errors + self.test_result.nb_failures or
+                    not passed):
                 return 1
             return 0
         except Exception:
@@ -177,42 +371,430 @@ class LocalRunner(object):
             self.running = False
             os.chdir(old_location)
 
+    def _check_thresholds(self):
+        """Evaluates the thresholds and displays their results.
+
+        Returns False if any of them failed.
+        """
+        if not self.thresholds:
+            return True
+
+        passed = True
+        sys.stdout.write('\nThresholds:')
+        for threshold in self.thresholds:
+            value, ok = self._evaluate_threshold(threshold)
+            passed = passed and ok
+            if value is None:
+                value = 'no data'
+            else:
+                value = '%.3f' % value
+            sys.stdout.write('\n- %s : %s (%s)' % (
+                threshold, ok and 'passed' or 'FAILED', value))
+        sys.stdout.write('\n')
+        sys.stdout.flush()
+        return passed
+
+    def _evaluate_threshold(self, threshold):
+        return threshold.evaluate(self.test_result)
+
+    def _write_baseline(self):
+        """Writes the thresholds of the results in the --baseline file."""
+        path = self.args['baseline']
+        margin = self.args.get('baseline_margin')
+        error_margin = self.args.get('baseline_error_margin')
+        if margin is None:
+            margin = DEFAULT_MARGIN
+        if error_margin is None:
+            error_margin = DEFAULT_ERROR_MARGIN
+        expressions = write_baseline(path, self.test_result, margin,
+                                     error_margin)
+        sys.stdout.write('\nBaseline written in %s (%d thresholds)\n' % (
+            path, len(expressions)))
+        sys.stdout.flush()
+
+    def pause(self, *args):
+        """Pauses the run: the users finish their current test, then wait
+        -- keeping their connections -- until the run is resumed."""
+        logger.info('Pausing the run')
+        self.paused = True
+
+    def resume(self, *args):
+        logger.info('Resuming the run')
+        self.paused = False
+
+    def abort(self, *args):
+        """Aborts the run: the users finish their current test within the
+        grace period, and the results are flushed as usual. Aborting twice
+        interrupts the run."""
+        if self.stop:
+            raise KeyboardInterrupt()
+        logger.info('Aborting the run')
+        self.stop = True
+        self.paused = False
+
+    def set_load(self, users=None, rate=None, rps=None):
+        """Changes the number of users of a run with a duration or stages,
+        or the rate of a run with an arrival rate. The change is applied at
+        the next tick. The ceiling of the requests per second changes from
+        the next request."""
+        if users is not None:
+            logger.info('Running %s users' % users)
+            self.target_users = int(users)
+        if rate is not None:
+            logger.info('Running %s arrivals per second' % rate)
+            self.target_rate = float(rate)
+        if rps is not None:
+            logger.info('Sending at most %s requests per second' % rps)
+            LIMITER.set_rate(rps)
+
+    def _read_load(self, *args):
+        test_dir = self.args.get('test_dir') or os.getcwd()
+        try:
+            with open(os.path.join(test_dir, LOAD_FILE)) as f:
+                load = json.loads(f.read())
+        except (IOError, ValueError), e:
+            logger.error('Could not read the new load: %s' % e)
+            return
+        self.set_load(load.get('users'), load.get('rate'), load.get('rps'))
+
+    def _get_users(self, users):
+        if self.target_users is None:
+            return users
+        return self.target_users
+
+    def _is_active(self, num):
+        return self.target_users is None or num < self.target_users
+
+    def _handle_signals(self):
+        """Handles the signals, and returns the previous handlers."""
+        previous = {}
+        for signum, handler in ((PAUSE_SIGNAL, self.pause),
+                                (RESUME_SIGNAL, self.resume),
+                                (ABORT_SIGNAL, self.abort),
+                                (LOAD_SIGNAL, self._read_load)):
+            try:
+                previous[signum] = signal.signal(signum, handler)
+            except ValueError:
+                # not in the main thread
+                logger.debug('Cannot handle the signal %d' % signum)
+        return previous
+
+    def _wait(self):
+        """Holds a user while the run is paused. Returns False when the
+        run is aborted."""
+        while self.paused and not self.stop:
+            gevent.sleep(PAUSE_TICK)
+        return not self.stop
+
+    def _take_elapsed(self):
+        """Returns the seconds the run lasted before it was resumed, the
+        first time only."""
+        elapsed, self._resumed_at = self._resumed_at, 0.
+        return elapsed
+
+    def get_checkpoint(self):
+        return {'elapsed': time.time() - self._started,
+                'iterations': self.iterations,
+                'feeders': get_positions()}
+
+    def _send_checkpoints(self):
+        while True:
+            gevent.sleep(self.checkpoint_interval)
+            self.test_result.checkpoint(**self.get_checkpoint())
+
     def _run(self, num, user):
         """This method is actually spawned by gevent so there is more than
         one actual test suite running in parallel.
         """
-        # creating the test case instance
-        test = self.test.im_class(test_name=self.test.__name__,
-                                  test_result=self.test_result,
-                                  config=self.args)
+        test = self._create_test(num)
 
         if self.stop:
             return
 
-        if self.duration is None:
+        if self.max_iterations is not None:
+            loads_status = list(self.args.get('loads_status',
+                                              (self.max_iterations, user, 0,
+                                               num)))
+            while self._wait() and self._claim():
+                loads_status[2] += 1
+                self._iterate(test, list(loads_status))
+                self.iterations += 1
+                gevent.sleep(0)
+        elif self.duration is None:
             for hit in self.hits:
                 gevent.sleep(0)
                 loads_status = list(self.args.get('loads_status',
                                                   (hit, user, 0, num)))
                 for current_hit in range(hit):
+                    if not self._wait():
+                        return
+                    if self._skip > 0:
+                        # done before the run was resumed
+                        self._skip -= 1
+                        continue
                     loads_status[2] = current_hit + 1
-                    test(loads_status=list(loads_status))
+                    self._iterate(test, list(loads_status))
+                    self.iterations += 1
                     gevent.sleep(0)
         else:
-            def spawn_test():
-                loads_status = list(self.args.get('loads_status',
-                                                  (0, user, 0, num)))
-                while True:
-                    loads_status[2] += 1
-                    test(loads_status=loads_status)
+            loads_status = list(self.args.get('loads_status',
+                                              (0, user, 0, num)))
+            # no test starts after the end of the duration
+            while (self._wait() and self._is_active(num) and
+                   time.time() < self._deadline):
+                loads_status[2] += 1
+                self._iterate(test, loads_status)
+                gevent.sleep(0)
+
+    def _claim(self):
+        """Takes one of the remaining iterations of the run. Returns False
+        when they are all taken."""
+        if self._remaining <= 0:
+            return False
+        self._remaining -= 1
+        return True
+
+    def _iterate(self, test, loads_status):
+        """Runs an iteration of a user of the closed model, and waits for
+        the rest of the pacing."""
+        started = time.time()
+        test(loads_status=loads_status)
+        if self.pacing is not None:
+            self.pacing.wait(started)
+
+    def _run_duration(self, user):
+        """Runs the users until the end of the duration. When the number of
+        users is changed, the missing users are started, and the extra ones
+        stop after their current test."""
+        self._deadline = time.time() + self.duration - self._take_elapsed()
+        users = {}
+        while not self.stop and time.time() < self._deadline:
+            for num in range(self._get_users(user)):
+                if num not in users or users[num].ready():
+                    users[num] = gevent.spawn(self._run, num, user)
                     gevent.sleep(0)
+            gevent.sleep(STAGE_TICK)
+
+        self._stop_users(users.values())
+
+    def _join_users(self, users):
+        """Waits for the users to run all their tests, or for the run to
+        be aborted."""
+        while not self.stop and not all([user.ready() for user in users]):
+            gevent.joinall(users, timeout=STAGE_TICK)
+        self._stop_users(users)
+
+    def _stop_users(self, users):
+        """Gives the tests in flight the grace period to complete, then
+        kills the users still running: their tests are counted in the
+        *killed-tests* counter, not as errors."""
+        # the paused users leave at once
+        self.paused = False
+        users = [user for user in users if not user.ready()]
+        if users and self.grace_period > 0:
+            logger.debug('Waiting %.1fs for the tests of %d users' %
+                         (self.grace_period, len(users)))
+            gevent.joinall(users, timeout=self.grace_period)
+
+        users = [user for user in users if not user.ready()]
+        if users:
+            logger.info('Killing the %d users still running after the '
+                        'grace period' % len(users))
+            gevent.killall(users)
+
+    def _setup_run(self):
+        """Executes the setup of the test case, once for the whole run, and
+        returns the teardown to call after the load -- or None.
+
+        The users get the fixtures in their config. The setup and the
+        teardown record no hits -- see :mod:`loads.lifecycle`.
+        """
+        if not has_lifecycle(self.test.im_class):
+            return None
+        test = self.test.im_class(test_name=self.test.__name__,
+                                  config=self.args)
+        shared = get_lifecycle(self.args)
+        try:
+            if shared is None:
+                fixtures = run_setup(test)
+            else:
+                fixtures = shared.setup(lambda: run_setup(test))
+        except Exception:
+            if shared is not None:
+                shared.close()
+            raise
+        self.args['fixtures'] = test.fixtures = fixtures
+
+        def teardown():
+            try:
+                if shared is None:
+                    test.teardown_run(fixtures)
+                else:
+                    shared.teardown(test.teardown_run, fixtures)
+            except Exception as e:
+                # the results of the load are kept anyway
+                logger.error('The teardown of the run failed: %s' % e)
+            finally:
+                if shared is not None:
+                    shared.close()
+        return teardown
+
+    def _create_test(self, num=0):
+        """Creates a test case instance, i.e. a virtual user.
+
+        When the test case has scenarios, :param num: decides which one the
+        user runs.
+        """
+        test_name = self.test.__name__
+        if self.scenarios is not None:
+            test_name = self.scenarios[num % len(self.scenarios)]
+
+        return self.test.im_class(test_name=test_name,
+                                  test_result=self.test_result,
+                                  config=self.args)
+
+    def _run_arrival(self, test, loads_status, idle):
+        try:
+            test(loads_status=loads_status)
+            self.iterations += 1
+        finally:
+            idle.put((loads_status[3], test))
+
+    def _drop_arrival(self, loads_status):
+        self.dropped_arrivals += 1
+        if self._dropped_test is None:
+            self._dropped_test = self._create_test()
+
+        # all the drops are tracked under the same status
+        loads_status = loads_status[:2] + [0, 0]
+        self.test_result.incr_counter(self._dropped_test, loads_status,
+                                      'dropped-arrivals')
+
+    def _run_arrivals(self):
+        """Starts the tests at a fixed rate (open model).
+
+        The start time of every test is computed from the rate only, so a
+        slow response never delays the next arrival. A test is run by the
+        first idle virtual user, and a new one is created when they are all
+        busy -- up to the *max_users* cap. Arrivals happening while the users
+        are exhausted are dropped and counted in the *dropped-arrivals*
+        counter. The arrivals are shifted by the time the run was paused, but
+        that time counts in the duration. A new rate applies from the next
+        arrival.
+        """
+        users = self.users[0]
+        if self.duration is None:
+            total = max(sum(self.hits) - self._skip, 0)
+        else:
+            total = None
+
+        rate = self.arrival_rate
+        interval = 1. / rate
+        idle = Queue()
+        group = Group()
+        created = 0
+        arrival = 0
+        started = time.time()
+        begin = started - self._take_elapsed()
+
+        while not self.stop:
+            if self.paused:
+                paused = time.time()
+                if not self._wait():
+                    break
+                started += time.time() - paused
+
+            if self.target_rate and self.target_rate != rate:
+                # the next arrival is now, and the others at the new rate
+                rate = self.target_rate
+                interval = 1. / rate
+                started = time.time() - arrival * interval
+            users = self._get_users(self.users[0])
+
+            # when is the next arrival ?
+            scheduled = started + arrival * interval
+            if total is not None and arrival >= total:
+                break
+            if (self.duration is not None and
+                    scheduled - begin >= self.duration):
+                break
+
+            arrival += 1
+            delay = scheduled - time.time()
+            if delay > 0:
+                gevent.sleep(delay)
+
+            loads_status = list(self.args.get('loads_status',
+                                              (total or 0, users, 0, 0)))
+            loads_status[2] = arrival
 
-            spawned_test = gevent.spawn(spawn_test)
-            timer = gevent.Timeout(self.duration).start()
             try:
-                spawned_test.join(timeout=timer)
-            except (gevent.Timeout, KeyboardInterrupt):
-                pass
+                loads_status[3], test = idle.get_nowait()
+            except Empty:
+                if created >= users:
+                    self._drop_arrival(loads_status)
+                    continue
+                created += 1
+                loads_status[3], test = created, self._create_test(created)
+
+            group.spawn(self._run_arrival, test, loads_status, idle)
+
+        self._stop_users(group.greenlets)
+
+        if self.dropped_arrivals > 0:
+            logger.info('%d arrivals were dropped, all the %d users were '
+                        'busy.' % (self.dropped_arrivals, users))
+
+    def _run_stage_user(self, num, active):
+        """Runs the test in a loop for as long as the user is active."""
+        test = self._create_test(num)
+        loads_status = list(self.args.get('loads_status',
+                                          (0, self.users[0], 0, num)))
+
+        while self._wait() and num in active:
+            loads_status[2] += 1
+            self._iterate(test, list(loads_status))
+            gevent.sleep(0)
+
+    def _run_stages(self):
+        """Runs the tests following the stages of the load shape.
+
+        The number of users is adjusted every *STAGE_TICK* seconds. A user
+        that is no longer needed finishes its current test before stopping.
+        Every new stage is reported with *stage_started*.
+        """
+        active = []
+        group = Group()
+        created = 0
+        current = None
+        started = time.time() - self._take_elapsed()
+
+        while not self.stop:
+            elapsed = time.time() - started
+            if elapsed >= self.duration:
+                break
+
+            index, users = _get_stage(self.stages, elapsed)
+            users = self._get_users(users)
+            if index != current:
+                current = index
+                duration, target = self.stages[index]
+                logger.debug('Starting stage %d: %d users in %ds' %
+                             (index, target, duration))
+                self.test_result.stage_started(index, target, duration)
+
+            while len(active) < users:
+                created += 1
+                active.append(created)
+                group.spawn(self._run_stage_user, created, active)
+
+            while len(active) > users:
+                active.pop()
+
+            gevent.sleep(STAGE_TICK)
+
+        del active[:]
+        self._stop_users(group.greenlets)
 
     def _prepare_filesystem(self):
         test_dir = self.args.get('test_dir')
@@ -254,6 +836,8 @@ class LocalRunner(object):
         logger.debug('Ready to spawn greenlets for testing.')
         agent_id = self.args.get('agent_id')
         exception = None
+        handlers = {}
+        checkpoints = monitor = chaos = teardown = None
         try:
             if not self.args.get('no_patching', False):
                 logger.debug('Gevent monkey patches the stdlib')
@@ -264,21 +848,46 @@ class LocalRunner(object):
                 raise ValueError("The FQN of the test doesn't point to a test "
                                  "class (%s)." % self.test)
 
+            teardown = self._setup_run()
             gevent.spawn(self._grefresh)
+            handlers = self._handle_signals()
+            LIMITER.set_rate(get_max_rps(self.args))
+
+            self._started = time.time() - self._resumed_at
+            if self.slave and self.checkpoint_interval:
+                checkpoints = gevent.spawn(self._send_checkpoints)
+            elif not self.slave and self.monitor is not None:
+                monitor = gevent.spawn(self.monitor.run, gevent.sleep)
+            if not self.slave and self.chaos is not None:
+                chaos = gevent.spawn(self.chaos.run, gevent.sleep)
 
             if not self.args.get('externally_managed'):
                 self.test_result.startTestRun(agent_id)
 
+            if self.arrival_rate is not None:
+                self._run_arrivals()
+            elif self.stages:
+                self._run_stages()
+
             for user in self.users:
-                if self.stop:
+                if self.stop or self.arrival_rate is not None or self.stages:
                     break
 
+                if self.duration is not None:
+                    self._run_duration(user)
+                    continue
+
+                if self.max_iterations is not None:
+                    # the ones done before the run was resumed are skipped
+                    self._remaining = max(self.max_iterations - self._skip, 0)
+                    self._skip = 0
+
                 group = []
                 for i in range(user):
                     group.append(gevent.spawn(self._run, i, user))
                     gevent.sleep(0)
 
-                gevent.joinall(group)
+                self._join_users(group)
 
             gevent.sleep(0)
 
@@ -290,6 +899,18 @@ class LocalRunner(object):
             exception = e
         finally:
             logger.debug('Test over - cleaning up')
+            LIMITER.set_rate(None)
+            for greenlet in (monitor, chaos):
+                if greenlet is not None:
+                    greenlet.kill()
+            if teardown is not None:
+                teardown()
+            if checkpoints is not None:
+                checkpoints.kill()
+                # the last one, for when the run is preempted
+                self.test_result.checkpoint(**self.get_checkpoint())
+            for signum, handler in handlers.items():
+                signal.signal(signum, handler)
             # be sure we flush the outputs that need it.
             # but do it only if we are in "normal" mode
             try:
@@ -0,0 +1,211 @@
+"""The runs scheduled on the broker, for a future time or on a cron
+expression -- like the nightly regression tests::
+
+    $ loads-runner example.TestWebSite.test_es -u 50 -d 600 --agents 4 \\
+        --cron "0 2 * * *" --series nightly
+
+- *--at WHEN*: runs once, at a date like "2026-10-15 02:00", at the next
+  "02:00", or in a duration like "+2h".
+- *--cron EXPRESSION*: runs on the *minute hour day month weekday*
+  fields of cron -- "*", "*/15", "1-5", "mon,wed" -- or on @hourly,
+  @daily, @weekly, @monthly or @yearly. The times are the ones of the
+  broker.
+- *--series NAME*: the runs end up in the series, for loads-compare to
+  trend them.
+
+The runs of a schedule wait in the queue of the broker for their agents,
+like the runs of *--queue*. The end of every run of a series stores its
+summary -- see :func:`summarize_run` -- along its metadata.
+"""
+import time
+from datetime import datetime, timedelta
+
+from loads.histogram import Histogram
+from loads.util import parse_duration
+
+
+_ALIASES = {'@yearly': '0 0 1 1 *', '@annually': '0 0 1 1 *',
+            '@monthly': '0 0 1 * *', '@weekly': '0 0 * * 0',
+            '@daily': '0 0 * * *', '@midnight': '0 0 * * *',
+            '@hourly': '0 * * * *'}
+
+_MONTHS = ('jan', 'feb', 'mar', 'apr', 'may', 'jun', 'jul', 'aug', 'sep',
+           'oct', 'nov', 'dec')
+_WEEKDAYS = ('sun', 'mon', 'tue', 'wed', 'thu', 'fri', 'sat')
+
+# how far the next time of an expression is looked for
+_MAX_YEARS = 5
+
+
+def _parse_value(value, low, names):
+    value = value.lower()
+    if value in names:
+        return names.index(value) + low
+    return int(value)
+
+
+def _parse_field(field, low, high, names=()):
+    """Returns the set of the values of a cron field."""
+    values = set()
+    for part in field.split(','):
+        step = 1
+        if '/' in part:
+            part, step = part.split('/', 1)
+            step = int(step)
+            if step < 1:
+                raise ValueError('Invalid step in %r' % field)
+        if part == '*':
+            first, last = low, high
+        elif '-' in part:
+            first, last = part.split('-', 1)
+            first = _parse_value(first, low, names)
+            last = _parse_value(last, low, names)
+        else:
+            first = _parse_value(part, low, names)
+            # "5/10" goes from 5 to the end
+            last = step > 1 and high or first
+        if not low <= first <= last <= high:
+            raise ValueError('Invalid range in %r' % field)
+        values.update(range(first, last + 1, step))
+    return values
+
+
+class Cron(object):
+    """A cron expression.
+
+    :param expression: the five fields of cron, or one of its aliases.
+    """
+    def __init__(self, expression):
+        self.expression = expression.strip()
+        fields = _ALIASES.get(self.expression.lower(),
+                              self.expression).split()
+        if len(fields) != 5:
+            raise ValueError('A cron expression has 5 fields: %r' %
+                             expression)
+        minutes, hours, days, months, weekdays = fields
+        self.minutes = _parse_field(minutes, 0, 59)
+        self.hours = _parse_field(hours, 0, 23)
+        self.days = _parse_field(days, 1, 31)
+        self.months = _parse_field(months, 1, 12, _MONTHS)
+        # 0 and 7 are both sundays
+        self.weekdays = set([day % 7 for day in
+                             _parse_field(weekdays, 0, 7, _WEEKDAYS)])
+        self._any_day = days == '*'
+        self._any_weekday = weekdays == '*'
+
+    def _day_matches(self, when):
+        day = when.day in self.days
+        weekday = (when.weekday() + 1) % 7 in self.weekdays
+        # like cron, a day matches one of the fields when both are given
+        if not self._any_day and not self._any_weekday:
+            return day or weekday
+        return day and weekday
+
+    def next(self, after=None):
+        """Returns the timestamp of the first minute after the timestamp
+        :param after: -- now by default -- matching the expression."""
+        if after is None:
+            after = time.time()
+        when = datetime.fromtimestamp(int(after) // 60 * 60 + 60)
+        last_year = when.year + _MAX_YEARS
+
+        while when.year <= last_year:
+            if when.month not in self.months:
+                when = when.replace(day=1, hour=0, minute=0)
+                when = (when + timedelta(days=32)).replace(day=1)
+            elif not self._day_matches(when):
+                when = when.replace(hour=0, minute=0) + timedelta(days=1)
+            elif when.hour not in self.hours:
+                when = when.replace(minute=0) + timedelta(hours=1)
+            elif when.minute not in self.minutes:
+                when += timedelta(minutes=1)
+            else:
+                return time.mktime(when.timetuple())
+
+        raise ValueError('%r never happens' % self.expression)
+
+
+def parse_cron(value):
+    """Checks a cron expression, sent as it is to the broker."""
+    return Cron(value).expression
+
+
+def parse_at(value, now=None):
+    """Converts a date like "2026-10-15 02:00", a time like "02:00" -- the
+    next one -- or a duration like "+2h" in a timestamp."""
+    if now is None:
+        now = time.time()
+    value = str(value).strip()
+    if value.startswith('+'):
+        return now + parse_duration(value[1:])
+
+    for fmt in ('%Y-%m-%d %H:%M:%S', '%Y-%m-%d %H:%M', '%Y-%m-%dT%H:%M:%S',
+                '%Y-%m-%dT%H:%M'):
+        try:
+            return time.mktime(datetime.strptime(value, fmt).timetuple())
+        except ValueError:
+            pass
+
+    try:
+        hour, minute = [int(part) for part in value.split(':')]
+        when = datetime.fromtimestamp(now).replace(hour=hour, minute=minute,
+                                                   second=0, microsecond=0)
+    except ValueError:
+        raise ValueError('Invalid date %r' % value)
+    if time.mktime(when.timetuple()) <= now:
+        when += timedelta(days=1)
+    return time.mktime(when.timetuple())
+
+
+def _percentile(times, percentile):
+    index = int(round(percentile / 100. * (len(times) - 1)))
+    return times[index]
+
+
+def _is_success(status):
+    if isinstance(status, basestring):
+        return status == 'OK'
+    return status is not None and 200 <= status < 400
+
+
+def summarize_run(records):
+    """Returns the summary of the results of a run kept for its series: the
+    number of hits, their errors, their median and 95th percentile times,
+    and the number of tests and of failed tests.
+
+    The percentiles of the hits downsampled in intervals -- see
+    :mod:`loads.db.retention` -- are the ones of their histograms."""
+    times, errors, tests, failed = [], 0, 0, 0
+    histogram = Histogram()
+    for record in records:
+        data_type = record.get('data_type')
+        if data_type == 'add_hit' and 'histogram' in record:
+            histogram.add(Histogram.from_dict(record['histogram']))
+            if not _is_success(record.get('status')):
+                errors += record['size']
+        elif data_type in ('add_hit', 'add_hits'):
+            elapsed = record.get('elapsed')
+            if not isinstance(elapsed, list):
+                elapsed = [elapsed]
+            elapsed = [value for value in elapsed if value is not None]
+            times.extend(elapsed)
+            if not _is_success(record.get('status')):
+                errors += len(elapsed)
+        elif data_type == 'stopTest':
+            tests += 1
+        elif data_type in ('addFailure', 'addError'):
+            failed += 1
+
+    times.sort()
+    summary = {'hits': len(times) + histogram.total_count, 'errors': errors,
+               'tests': tests, 'failed': failed, 'p50': None, 'p95': None}
+    if histogram.total_count:
+        for elapsed in times:
+            histogram.record_value(elapsed * 10 ** 6)
+        for percentile in (50, 95):
+            value = histogram.get_value_at_percentile(percentile)
+            summary['p%d' % percentile] = value / 10. ** 6
+    elif times:
+        summary['p50'] = _percentile(times, 50)
+        summary['p95'] = _percentile(times, 95)
+    return summary
@@ -0,0 +1,58 @@
+"""The seed of a run, to replay the requests of a flaky regression::
+
+    $ loads-runner example.TestWebSite.test_es -u 10 -d 60 --seed 42
+
+With *--seed*, what loads draws at random comes from generators seeded
+with the seed, the index of the agent and the number of the virtual user:
+the scenarios of the users, the rows of the *random* feeders, the think
+times, the network conditions, the *random* proxies, the random functions
+of the templates and their fake data. Two runs with the same seed, the same
+agents and the same users send the same requests, in the same order for
+every user -- the way the users interleave still depends on the response
+times of the servers. The user names and the emails of the fake data keep
+their unique part, so the signups of a replay don't collide with the ones
+of the first run.
+
+Every generator has a name, so a request drawing a think time does not
+shift the rows of the feeder. Without a seed, the generators are the ones
+of the system.
+"""
+import hashlib
+import itertools
+import random
+import threading
+
+
+_LOCK = threading.Lock()
+_USERS = itertools.count()
+
+
+def derive(seed, *names):
+    """Returns the seed of the generator called *names*, for a run seeded
+    with :param seed:."""
+    key = ':'.join([str(part) for part in (seed,) + names])
+    return int(hashlib.md5(key).hexdigest(), 16)
+
+
+def get_random(config, *names):
+    """Returns the generator called *names* of an agent, like ('think-time',
+    3) for the think times of its fourth user, or None when the run has no
+    seed."""
+    seed = config.get('seed')
+    if seed is None:
+        return None
+    return random.Random(derive(seed, config.get('agent_index') or 0,
+                                *names))
+
+
+def next_user():
+    """Returns the number of a new virtual user of the process."""
+    with _LOCK:
+        return _USERS.next()
+
+
+def reset():
+    """Numbers the users of the next run from 0."""
+    global _USERS
+    with _LOCK:
+        _USERS = itertools.count()
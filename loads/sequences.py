@@ -0,0 +1,86 @@
+"""The unique ids of a run, for the scenarios creating resources -- users,
+orders -- whose ids can't collide, whatever the agent creating them::
+
+    class TestSignup(TestCase):
+
+        def test_signup(self):
+            user_id = self.next_id('users')
+            self.session.post(self.server_url + '/users',
+                              data={'login': 'user-%d' % user_id})
+
+The ids of a sequence start at 0. In distributed mode, the broker keeps
+the sequences of the runs, and an agent reserves them by blocks -- of
+*--id-block-size* ids, 10000 by default -- so it only calls the broker
+once for every block. The ids are unique, but not ordered between the
+agents, and the ids left in the blocks at the end of a run are never
+used.
+
+Without a broker, the sequences are the ones of the process.
+"""
+import threading
+
+
+DEFAULT_BLOCK_SIZE = 10000
+_SEQUENCES = {}
+_LOCK = threading.Lock()
+
+
+class Sequence(object):
+    """The ids of a sequence, reserved by blocks.
+
+    :param name: the name of the sequence.
+    :param reserve: returns the first id of a new block of *block_size*
+                    ids, for the sequence of the given name -- by default,
+                    the ids of the process.
+    :param block_size: the ids of every block.
+    """
+    def __init__(self, name, reserve=None, block_size=DEFAULT_BLOCK_SIZE):
+        if block_size < 1:
+            raise ValueError('A block has at least 1 id')
+        self.name = name
+        self.block_size = block_size
+        self._reserve = reserve or self._reserve_locally
+        self._lock = threading.Lock()
+        self._next = self._end = 0
+        self._local = 0
+
+    def _reserve_locally(self, name, block_size):
+        start, self._local = self._local, self._local + block_size
+        return start
+
+    def next(self):
+        """Returns the next id, reserving a new block when needed."""
+        with self._lock:
+            if self._next >= self._end:
+                self._next = self._reserve(self.name, self.block_size)
+                self._end = self._next + self.block_size
+            self._next += 1
+            return self._next - 1
+
+
+def _get_reserve(config):
+    """Returns the reservation of the blocks on the broker of a run, or
+    None out of the agents."""
+    if not config.get('slave') or config.get('run_id') is None:
+        return None
+    from loads.transport.client import Client
+
+    def _reserve(name, block_size):
+        client = Client(config['broker'], api_key=config.get('api_key'))
+        try:
+            return client.reserve_ids(config['run_id'], name, block_size)
+        finally:
+            client.close()
+    return _reserve
+
+
+def get_sequence(name, config=None):
+    """Returns the sequence of the name, shared by all the users."""
+    with _LOCK:
+        if name not in _SEQUENCES:
+            if config is None:
+                config = {}
+            block_size = config.get('id_block_size') or DEFAULT_BLOCK_SIZE
+            _SEQUENCES[name] = Sequence(name, _get_reserve(config),
+                                        block_size)
+        return _SEQUENCES[name]
@@ -0,0 +1,72 @@
+"""The timings the servers report in their responses, next to the times
+the tests measured: the *Server-Timing* header, and the numeric headers
+given with *--header-metric*::
+
+    $ loads-runner example.TestWebSite.test_es -u 10 -d 60 --server-timing \\
+        --header-metric X-Db-Time --threshold "trend.server-timing-db.p95 < 50"
+
+A response with *Server-Timing: db;dur=53.2, cache;desc="Cache";dur=1.2*
+adds 53.2 to the *server-timing-db* trend and 1.2 to the
+*server-timing-cache* one -- in milliseconds, like the header. A
+*X-Db-Time: 12* header adds 12 to the *x-db-time* trend. The metrics
+without a duration and the headers that are not numbers are left out.
+
+The trends are the custom ones -- see :mod:`loads.custom` -- for the
+thresholds, and they are kept by endpoint too: the report compares them
+to the times of the requests of every endpoint. The hits summarized by
+a relay that did not keep up with the broker lose their timings.
+"""
+import re
+
+
+PREFIX = 'server-timing-'
+
+_METRIC = re.compile(r'\s*([^\s;,="]+)((?:\s*;\s*[^\s;,="]+\s*'
+                     r'(?:=\s*(?:"(?:[^"\\]|\\.)*"|[^\s;,"]*))?)*)\s*'
+                     r'(?:,|$)')
+_PARAM = re.compile(r';\s*([^\s;,="]+)\s*(?:=\s*("(?:[^"\\]|\\.)*"|'
+                    r'[^\s;,"]*))?')
+_UNSAFE = re.compile(r'[^\w-]')
+
+
+def parse_server_timing(value):
+    """Returns the (name, duration) of the metrics of a *Server-Timing*
+    header that have a duration, in milliseconds."""
+    timings = []
+    position = 0
+    value = value or ''
+    while position < len(value.rstrip()):
+        match = _METRIC.match(value, position)
+        if match is None:
+            break
+        position = match.end()
+        name, params = match.groups()
+        for param, param_value in _PARAM.findall(params):
+            if param.lower() != 'dur':
+                continue
+            try:
+                timings.append((name, float(param_value.strip('"'))))
+            except ValueError:
+                pass
+            break
+    return timings
+
+
+def get_server_timings(headers, server_timing=False, header_metrics=()):
+    """Returns the timings of the headers of a response as a mapping of
+    trend names to their values, or None when there are none."""
+    timings = {}
+    if server_timing:
+        for name, duration in parse_server_timing(
+                headers.get('Server-Timing')):
+            timings[PREFIX + _UNSAFE.sub('_', name)] = duration
+
+    for header in header_metrics or ():
+        value = headers.get(header)
+        if value is None:
+            continue
+        try:
+            timings[_UNSAFE.sub('_', header.lower())] = float(value)
+        except ValueError:
+            pass
+    return timings or None
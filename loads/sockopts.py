@@ -0,0 +1,86 @@
+"""The tuning of the sockets of the outgoing connections, for the tests whose
+throughput is limited by the default behavior of the kernel::
+
+    $ loads-runner example.TestWebSite.test_es -u 500 --linger 0 \\
+        --send-buffer 1m --receive-buffer 1m --tcp-fastopen
+
+- *--reuse-port*: SO_REUSEPORT, so the local ports can be shared.
+- *--tcp-nodelay on|off*: TCP_NODELAY -- on by default for the HTTP
+  connections, off for the TCP clients.
+- *--linger SECONDS*: SO_LINGER. With 0, closing a connection resets it,
+  and leaves no socket in TIME_WAIT.
+- *--send-buffer* and *--receive-buffer*: SO_SNDBUF and SO_RCVBUF, in bytes
+  -- or with a *k* or *m* suffix.
+- *--tcp-fastopen*: TCP_FASTOPEN_CONNECT, so the data of the first request
+  goes with the SYN when the server knows the client -- Linux 4.11 and
+  later.
+
+The options are set on the HTTP connections and on the TCP clients, and
+the ones that are not TCP options on the UDP clients.
+"""
+import socket
+import struct
+
+
+# the values of Linux, when Python does not know the options
+SO_REUSEPORT = getattr(socket, 'SO_REUSEPORT', 15)
+TCP_FASTOPEN_CONNECT = getattr(socket, 'TCP_FASTOPEN_CONNECT', 30)
+
+_SIZE_UNITS = {'k': 1024, 'm': 1024 ** 2}
+
+
+def parse_size(value):
+    """Converts a size like "256k" or "1m" in bytes."""
+    value = str(value).strip().lower()
+    unit = 1
+    if value[-1:] in _SIZE_UNITS:
+        value, unit = value[:-1], _SIZE_UNITS[value[-1]]
+    size = int(float(value) * unit)
+    if size <= 0:
+        raise ValueError('Invalid size %r' % value)
+    return size
+
+
+def parse_switch(value):
+    """Converts "on" or "off" in True or False."""
+    value = str(value).strip().lower()
+    if value not in ('on', 'off'):
+        raise ValueError('Use on or off, not %r' % value)
+    return value == 'on'
+
+
+def merge_options(defaults, options):
+    """Returns the :param options:, after the :param defaults: they don't
+    replace."""
+    replaced = set((level, name) for level, name, _ in options)
+    return ([option for option in defaults
+             if (option[0], option[1]) not in replaced] + list(options))
+
+
+def get_socket_options(config):
+    """Returns the (level, option, value) of the options, or None when
+    there are none."""
+    options = []
+    if config.get('reuse_port'):
+        options.append((socket.SOL_SOCKET, SO_REUSEPORT, 1))
+    if config.get('tcp_nodelay') is not None:
+        options.append((socket.IPPROTO_TCP, socket.TCP_NODELAY,
+                        int(config['tcp_nodelay'])))
+    if config.get('linger') is not None:
+        options.append((socket.SOL_SOCKET, socket.SO_LINGER,
+                        struct.pack('ii', 1, int(config['linger']))))
+    if config.get('send_buffer'):
+        options.append((socket.SOL_SOCKET, socket.SO_SNDBUF,
+                        config['send_buffer']))
+    if config.get('receive_buffer'):
+        options.append((socket.SOL_SOCKET, socket.SO_RCVBUF,
+                        config['receive_buffer']))
+    if config.get('tcp_fastopen'):
+        options.append((socket.IPPROTO_TCP, TCP_FASTOPEN_CONNECT, 1))
+    return options or None
+
+
+def get_datagram_options(options):
+    """Returns the options of a list that are not TCP options."""
+    return [option for option in options or ()
+            if option[0] != socket.IPPROTO_TCP]
@@ -0,0 +1,91 @@
+"""The local addresses of the outgoing connections: *--source-ip* gives one,
+or several, and *--source-ips* a file of addresses -- one per line -- read
+where the test runs, so every agent can bind its own addresses::
+
+    $ loads-runner example.TestWebSite.test_es -u 500 \\
+        --source-ip 10.0.0.11 --source-ip 10.0.0.12
+    $ loads-runner example.TestWebSite.test_es --source-ips ips.txt
+
+A single address runs out of ephemeral ports -- about 28000 on Linux --
+long before the CPU of the agent runs out when the connections are opened
+and closed at a high rate. Every address gets its own ports.
+
+With the *round-robin* strategy, every new connection binds the next
+address. With *per-user*, a virtual user keeps its address for all its
+connections. The addresses must be the ones of the machine, of the family
+of the targets.
+"""
+import itertools
+import socket
+import threading
+
+
+STRATEGIES = ('round-robin', 'per-user')
+
+_LISTS = {}
+_LOCK = threading.Lock()
+
+
+def parse_address(value):
+    """Checks an IPv4 or IPv6 address."""
+    value = value.strip()
+    for family in (socket.AF_INET, socket.AF_INET6):
+        try:
+            socket.inet_pton(family, value)
+        except (socket.error, ValueError):
+            continue
+        return value
+    raise ValueError('Invalid source address %r' % value)
+
+
+def read_addresses(path):
+    """Returns the addresses of a file, skipping the blanks and the #
+    comments."""
+    addresses = []
+    with open(path) as f:
+        for line in f:
+            line = line.split('#', 1)[0].strip()
+            if line:
+                addresses.append(parse_address(line))
+    if not addresses:
+        raise ValueError('No source address in %s' % path)
+    return addresses
+
+
+class SourceAddresses(object):
+    """Gives the addresses in turn, to the connections of all the users of
+    the process."""
+    def __init__(self, addresses):
+        if not addresses:
+            raise ValueError('No source address')
+        self.addresses = [parse_address(address) for address in addresses]
+        self._cycle = itertools.cycle(self.addresses)
+        self._lock = threading.Lock()
+
+    def next(self):
+        with self._lock:
+            return self._cycle.next()
+
+
+def get_source_addresses(config):
+    """Returns the addresses of a new virtual user -- their next() is the
+    address of its next connection --, or None when there are none."""
+    addresses = list(config.get('source_ip') or [])
+    path = config.get('source_ips')
+    strategy = config.get('source_ip_strategy') or 'round-robin'
+    if strategy not in STRATEGIES:
+        raise ValueError('Unknown strategy %r' % strategy)
+    if not addresses and not path:
+        return None
+
+    key = tuple(addresses), path
+    with _LOCK:
+        if key not in _LISTS:
+            if path:
+                addresses.extend(read_addresses(path))
+            _LISTS[key] = SourceAddresses(addresses)
+        sources = _LISTS[key]
+
+    if strategy == 'per-user':
+        return itertools.repeat(sources.next())
+    return sources
@@ -0,0 +1,204 @@
+"""The templates of the requests: with *--templates*, the *{{ ... }}* of the
+URLs, headers and bodies are rendered before every request, so each one gets
+its own values::
+
+    self.session.post('http://app/users/{{randInt 1 100}}',
+                      headers={'X-Request-Id': '{{uuid}}'},
+                      data='{"email": "{{feeder.email}}", "at": "{{now}}"}')
+
+A template calls a function with its arguments -- quoted when they have
+spaces -- or gives a value:
+
+- **uuid**: a random UUID.
+- **now [format]**: the current UTC time, in the *RFC3339* format by
+  default, or *unix*, *unixms*, or any *strftime* format.
+- **randInt min max**, **randFloat min max**: a random number, *min* and
+  *max* included.
+- **randString length**: random letters and digits.
+- **randChoice a b c**: one of the arguments.
+- **env NAME [default]**: an environment variable.
+- **feeder.column**: a column of the row of the feeder given with *--feeder*
+  -- a request gets a single row.
+- **state.name**: a value of the state of the test -- see *extract*.
+- **fake.Generator**: fake data, like *fake.Name*, *fake.Email* or
+  *fake.CreditCard* -- see :mod:`loads.fake`.
+
+More functions can be added with :func:`register_function`. With *--seed*,
+the random functions and the fake data of every user are drawn from its own
+generator -- see :mod:`loads.seeds`.
+"""
+import datetime
+import functools
+import os
+import random
+import re
+import shlex
+import string
+import time
+import uuid
+
+
+_TEMPLATE = re.compile(r'\{\{\s*(.+?)\s*\}\}')
+_CHARACTERS = string.ascii_letters + string.digits
+_NOW_FORMATS = {'RFC3339': '%Y-%m-%dT%H:%M:%SZ',
+                'RFC1123': '%a, %d %b %Y %H:%M:%S GMT'}
+
+
+class TemplateError(ValueError):
+    pass
+
+
+def _now(format='RFC3339'):
+    if format == 'unix':
+        return int(time.time())
+    if format == 'unixms':
+        return int(time.time() * 1000)
+    return datetime.datetime.utcnow().strftime(_NOW_FORMATS.get(format,
+                                                                format))
+
+
+def _uuid(rand):
+    if rand is random:
+        return str(uuid.uuid4())
+    return str(uuid.UUID(int=rand.getrandbits(128), version=4))
+
+
+def _rand_string(rand, length=16):
+    return ''.join([rand.choice(_CHARACTERS)
+                    for index in range(int(length))])
+
+
+def _env(name, default=None):
+    value = os.environ.get(name, default)
+    if value is None:
+        raise TemplateError('%s is not in the environment' % name)
+    return value
+
+
+_FUNCTIONS = {'now': _now,
+              'env': _env}
+
+# the functions drawing at random, called with the generator of the context
+# first
+_RANDOM_FUNCTIONS = {'uuid': _uuid,
+                     'randInt': lambda rand, low, high:
+                     rand.randint(int(low), int(high)),
+                     'randFloat': lambda rand, low, high:
+                     rand.uniform(float(low), float(high)),
+                     'randString': _rand_string,
+                     'randChoice': lambda rand, *choices:
+                     rand.choice(choices)}
+
+
+def register_function(name, function):
+    """Makes *function* available to the templates as *name*. It's called
+    with the arguments of the template, as strings."""
+    _FUNCTIONS[name] = function
+
+
+class Context(object):
+    """What the templates of a request get.
+
+    :param feeder: returns the row of the feeder, called once per request.
+    :param state: the state of the test.
+    :param rand: the generator of the random functions and of the fake
+                 data -- see :mod:`loads.seeds`.
+    """
+    def __init__(self, feeder=None, state=None, rand=None):
+        self._feeder = feeder
+        self._row = None
+        self.state = state or {}
+        self.random = rand or random
+        self._faker = None
+
+    @property
+    def row(self):
+        if self._row is None:
+            if self._feeder is None:
+                raise TemplateError('No feeder given, see --feeder')
+            self._row = self._feeder()
+        return self._row
+
+    @property
+    def faker(self):
+        if self._faker is None:
+            from loads.fake import Faker, get_faker
+            if self.random is random:
+                self._faker = get_faker()
+            else:
+                self._faker = Faker(self.random.getrandbits(64))
+        return self._faker
+
+    def get(self, namespace, key):
+        if namespace == 'fake':
+            try:
+                return self.faker.get(key)
+            except ValueError, e:
+                raise TemplateError(str(e))
+        elif namespace == 'feeder':
+            values = self.row
+        elif namespace == 'state':
+            values = self.state
+        else:
+            raise TemplateError('Unknown namespace %r' % namespace)
+        if key not in values:
+            raise TemplateError('No %r in the %s' % (key, namespace))
+        return values[key]
+
+
+def evaluate(expression, context=None):
+    """Returns the value of the expression of a template."""
+    if context is None:
+        context = Context()
+    if isinstance(expression, unicode):
+        expression = expression.encode('utf8')
+    try:
+        words = shlex.split(expression)
+    except ValueError, e:
+        raise TemplateError('Invalid template %r: %s' % (expression, e))
+    if not words:
+        raise TemplateError('Empty template')
+
+    name, args = words[0], words[1:]
+    if '.' in name and not args:
+        return context.get(*name.split('.', 1))
+    if name in _FUNCTIONS:
+        function = _FUNCTIONS[name]
+    elif name in _RANDOM_FUNCTIONS:
+        function = functools.partial(_RANDOM_FUNCTIONS[name],
+                                     context.random)
+    else:
+        raise TemplateError('Unknown template function %r' % name)
+    try:
+        return function(*args)
+    except TypeError, e:
+        raise TemplateError('Invalid arguments for %s: %s' % (name, e))
+
+
+def render(template, context=None):
+    """Renders the templates of a string."""
+    if '{{' not in template:
+        return template
+    if context is None:
+        context = Context()
+
+    def _render(match):
+        value = evaluate(match.group(1), context)
+        if isinstance(value, unicode):
+            return value
+        return str(value)
+
+    return _TEMPLATE.sub(_render, template)
+
+
+def render_all(value, context=None):
+    """Renders the templates of the strings of a value -- and of the keys and
+    values of its dicts, lists and tuples."""
+    if isinstance(value, basestring):
+        return render(value, context)
+    if isinstance(value, dict):
+        return dict([(render_all(key, context), render_all(item, context))
+                     for key, item in value.items()])
+    if isinstance(value, (list, tuple)):
+        return type(value)([render_all(item, context) for item in value])
+    return value
@@ -46,7 +46,10 @@ def get_runner_args(fqn, users=1, hits=1, duration=None,
                     test_dir=None, include_file=None, python_dep=None,
                     observer=None, slave=False, agent_id=None, run_id=None,
                     loads_status=None, externally_managed=False,
-                    project_name='N/A'):
+                    project_name='N/A', arrival_rate=None, max_users=None,
+                    stages=None, threshold=None, checkpoint=None,
+                    checkpoint_interval=None, iterations=None,
+                    iterations_per_user=False):
     if output is None:
         output = ['null']
 
@@ -77,6 +80,9 @@ def get_runner_args(fqn, users=1, hits=1, duration=None,
 
     if duration is not None:
         args['duration'] = float(duration)
+    elif iterations is not None:
+        args['iterations'] = iterations
+        args['iterations_per_user'] = iterations_per_user
     else:
         args['hits'] = str(hits)
 
@@ -89,6 +95,22 @@ def get_runner_args(fqn, users=1, hits=1, duration=None,
     if loads_status is not None:
         args['loads_status'] = loads_status
 
+    if arrival_rate is not None:
+        args['arrival_rate'] = arrival_rate
+        args['max_users'] = max_users
+
+    if stages is not None:
+        args['stages'] = stages
+
+    if threshold is not None:
+        args['threshold'] = threshold
+
+    if checkpoint is not None:
+        args['checkpoint'] = checkpoint
+
+    if checkpoint_interval is not None:
+        args['checkpoint_interval'] = checkpoint_interval
+
     return args
 
 
@@ -0,0 +1,162 @@
+import json
+import urllib2
+
+import mock
+import unittest2
+
+from loads.transport.api import ApiServer, get_run_args
+from loads.transport.exc import ExecutionError, AuthenticationError
+
+
+class _Request(urllib2.Request):
+    method = None
+
+    def get_method(self):
+        return self.method or urllib2.Request.get_method(self)
+
+
+class TestApiServer(unittest2.TestCase):
+
+    def setUp(self):
+        patcher = mock.patch('loads.transport.api.Client')
+        self._patched = patcher.start()
+        self.client = self._patched.return_value
+        self.addCleanup(patcher.stop)
+        self.server = ApiServer('ipc:///tmp/loads-front.ipc',
+                                '127.0.0.1:0', token='secret')
+        self.server.start()
+        self.addCleanup(self.server.stop)
+
+    def _call(self, path, method=None, body=None, token='secret'):
+        url = 'http://%s%s' % (self.server.address, path)
+        if body is not None:
+            body = json.dumps(body)
+        request = _Request(url, body)
+        request.method = method
+        if token is not None:
+            request.add_header('Authorization', 'Bearer %s' % token)
+        try:
+            response = urllib2.urlopen(request)
+        except urllib2.HTTPError, e:
+            response = e
+        return response.code, json.loads(response.read())
+
+    def test_agents(self):
+        self.client.list.return_value = {'1': {'hostname': 'here'}}
+        self.assertEqual(self._call('/agents'),
+                         (200, {'1': {'hostname': 'here'}}))
+        self.assertTrue(self.client.close.called)
+
+    def test_api_key(self):
+        self.server.server.token = None
+        self.client.list.return_value = {}
+        self._call('/agents', token='team-a-key')
+        kwargs = self._patched.call_args[1]
+        self.assertEqual(kwargs['api_key'], 'team-a-key')
+
+        self.client.list.side_effect = AuthenticationError('Invalid API key')
+        self.assertEqual(self._call('/agents', token='wrong'),
+                         (401, {'error': 'Invalid API key'}))
+
+    def test_token(self):
+        status, result = self._call('/agents', token='wrong')
+        self.assertEqual(status, 401)
+        status, result = self._call('/agents', token=None)
+        self.assertEqual(status, 401)
+        self.assertFalse(self.client.list.called)
+
+    def test_start_run(self):
+        self.client.run.return_value = {'run_id': 'run', 'agents': ['1']}
+        status, result = self._call('/runs', body={'fqn': 'a.Test.test_a',
+                                                   'users': 5, 'agents': 1})
+        self.assertEqual(status, 201)
+        self.assertEqual(result['run_id'], 'run')
+
+        args = self.client.run.call_args[0][0]
+        self.assertEqual(args['fqn'], 'a.Test.test_a')
+        self.assertEqual(args['users'], '5')
+
+        self.client.run.side_effect = ExecutionError('Not enough agents')
+        status, result = self._call('/runs', body={'fqn': 'a.Test.test_a'})
+        self.assertEqual(status, 409)
+
+        status, result = self._call('/runs', body={'users': 5})
+        self.assertEqual(status, 400)
+
+    def test_status(self):
+        self.client.get_metadata.return_value = {'active': True,
+                                                 'started': 1000.}
+        self.client.list_runs.return_value = {'run': [['1', 1000.]]}
+        self.client.get_counts.return_value = [['add_hit', 10]]
+
+        status, result = self._call('/runs/run')
+        self.assertEqual(status, 200)
+        self.assertTrue(result['active'])
+        self.assertEqual(result['agents'], ['1'])
+        self.assertEqual(result['counts'], {'add_hit': 10})
+
+        self.client.get_metadata.return_value = {}
+        self.assertEqual(self._call('/runs/unknown')[0], 404)
+
+    def test_queue(self):
+        queue = [{'run_id': 'run', 'position': 1}]
+        self.client.get_queue.return_value = queue
+        self.assertEqual(self._call('/queue'), (200, queue))
+
+        self.client.get_metadata.return_value = {'active': True,
+                                                 'queued': 1000.}
+        self.client.list_runs.return_value = {}
+        self.client.get_counts.return_value = []
+        status, result = self._call('/runs/run')
+        self.assertTrue(result['queued'])
+        self.assertEqual(result['position'], 1)
+
+    def test_stop(self):
+        self.client.stop_run.return_value = ['1']
+        self.assertEqual(self._call('/runs/run', method='DELETE'),
+                         (200, {'run_id': 'run', 'agents': ['1']}))
+        self.assertEqual(self._call('/runs/run/stop', body={})[0], 200)
+        self.client.stop_run.assert_called_with('run')
+
+    def test_pause_resume_abort(self):
+        for action in ('pause', 'resume', 'abort'):
+            command = getattr(self.client, '%s_run' % action)
+            command.return_value = ['1']
+            self.assertEqual(self._call('/runs/run/%s' % action, body={}),
+                             (200, {'run_id': 'run', 'agents': ['1']}))
+            command.assert_called_with('run')
+
+        self.assertEqual(self._call('/runs/run/pause')[0], 404)
+
+    def test_load(self):
+        self.client.set_load.return_value = ['1']
+        self.assertEqual(self._call('/runs/run/load', body={'users': 20}),
+                         (200, {'run_id': 'run', 'agents': ['1']}))
+        self.client.set_load.assert_called_with('run', users=20, rate=None,
+                                                max_rps=None)
+        self._call('/runs/run/load', body={'max_rps': 1000})
+        self.client.set_load.assert_called_with('run', users=None, rate=None,
+                                                max_rps=1000)
+        self.assertEqual(self._call('/runs/run/load', body=[20])[0], 400)
+
+    def test_data(self):
+        self.client.get_data.return_value = [{'data_type': 'add_hit'}]
+        status, result = self._call('/runs/run/data?data_type=add_hit'
+                                    '&size=10&other=1')
+        self.assertEqual(status, 200)
+        self.client.get_data.assert_called_with('run', data_type='add_hit',
+                                                size='10')
+
+    def test_not_found(self):
+        self.assertEqual(self._call('/nope')[0], 404)
+        self.assertEqual(self._call('/runs/run/nope')[0], 404)
+
+
+class TestRunArgs(unittest2.TestCase):
+
+    def test_defaults(self):
+        args = get_run_args({'fqn': 'a.Test.test_a', 'hits': 2})
+        self.assertEqual(args['agents'], 1)
+        self.assertEqual(args['hits'], '2')
+        self.assertEqual(args['users'], '1')
+        self.assertIn('include_file', args)
@@ -0,0 +1,245 @@
+import base64
+import calendar
+import datetime
+import hashlib
+import hmac
+import json
+import os
+import sys
+
+import mock
+import unittest2
+from requests.models import PreparedRequest
+
+from loads import auth
+from loads.auth import (JWT, AWSCredentials, AuthError, OAuth2, SigV4,
+                        encode_jwt)
+
+
+class _Clock(object):
+
+    def __init__(self):
+        self.now = 1000.
+
+    def __call__(self):
+        return self.now
+
+
+def _response(status=200, **values):
+    response = mock.Mock(status_code=status, text=json.dumps(values),
+                         elapsed=datetime.timedelta(seconds=.1))
+    response.json.return_value = values
+    return response
+
+
+def _prepare(provider, method='GET', url='http://app/', data=None):
+    request = PreparedRequest()
+    request.prepare(method=method, url=url, data=data, hooks={})
+    return provider(request)
+
+
+def _decode(segment):
+    return base64.urlsafe_b64decode(segment + '=' * (-len(segment) % 4))
+
+
+class TestOAuth2(unittest2.TestCase):
+
+    def setUp(self):
+        self.clock = _Clock()
+        self.session = mock.Mock()
+        self.session.post.return_value = _response(
+            access_token='abc', token_type='bearer', expires_in=60)
+        self.addCleanup(auth._SHARED.clear)
+
+    def _oauth2(self, **options):
+        return OAuth2('http://auth/token', 'loads', 'secret',
+                      session=self.session, clock=self.clock, **options)
+
+    def test_client_credentials(self):
+        provider = self._oauth2(scope=['read', 'write'])
+        request = _prepare(provider)
+        self.assertEqual(request.headers['Authorization'], 'Bearer abc')
+
+        self.session.post.assert_called_once_with(
+            'http://auth/token', auth=('loads', 'secret'), timeout=30,
+            headers={'Accept': 'application/json'},
+            data={'grant_type': 'client_credentials', 'scope': 'read write'})
+
+        # the token is kept until it's about to expire
+        self.clock.now += 29
+        _prepare(provider)
+        self.assertEqual(self.session.post.call_count, 1)
+        self.clock.now += 2
+        _prepare(provider)
+        self.assertEqual(self.session.post.call_count, 2)
+
+    def test_password(self):
+        provider = self._oauth2(username='bob', password='pw',
+                                client_auth='body')
+        _prepare(provider)
+        data = self.session.post.call_args[1]['data']
+        self.assertEqual(data, {'grant_type': 'password', 'username': 'bob',
+                                'password': 'pw', 'client_id': 'loads',
+                                'client_secret': 'secret'})
+        self.assertEqual(self.session.post.call_args[1]['auth'], None)
+
+    def test_refresh(self):
+        self.session.post.return_value = _response(
+            access_token='abc', expires_in=60, refresh_token='xyz')
+        provider = self._oauth2()
+        _prepare(provider)
+
+        self.clock.now += 60
+        self.session.post.return_value = _response(access_token='def')
+        request = _prepare(provider)
+        self.assertEqual(request.headers['Authorization'], 'Bearer def')
+        self.assertEqual(self.session.post.call_args[1]['data'],
+                         {'grant_type': 'refresh_token',
+                          'refresh_token': 'xyz'})
+
+        # no expiry: kept forever
+        self.clock.now += 10 ** 6
+        _prepare(provider)
+        self.assertEqual(self.session.post.call_count, 2)
+
+    def test_shared(self):
+        first = self._oauth2(shared=True)
+        second = self._oauth2(shared=True)
+        _prepare(first)
+        _prepare(second)
+        self.assertEqual(self.session.post.call_count, 1)
+
+        # a rejected token is renewed
+        first._handle_401(mock.Mock(status_code=401))
+        _prepare(second)
+        self.assertEqual(self.session.post.call_count, 2)
+
+    def test_errors(self):
+        self.session.post.return_value = _response(401, error='invalid')
+        self.assertRaises(AuthError, _prepare, self._oauth2())
+        self.session.post.return_value = _response(token='abc')
+        self.assertRaises(AuthError, _prepare, self._oauth2())
+        self.assertRaises(ValueError, self._oauth2, client_auth='digest')
+
+    def test_record(self):
+        test = mock.Mock(_loads_status=(1, 1, 1, 1))
+        _prepare(self._oauth2(test_case=test))
+        self.assertFalse(test._test_result.add_hit.called)
+
+        _prepare(self._oauth2(test_case=test, record=True))
+        hit = test._test_result.add_hit.call_args[1]
+        self.assertEqual(hit['scenario'], 'auth')
+        self.assertEqual(hit['url'], 'http://auth/token')
+        self.assertEqual(hit['elapsed'], .1)
+
+
+class TestJWT(unittest2.TestCase):
+
+    def test_encode(self):
+        token = encode_jwt({'sub': 'bob'}, 'secret')
+        header, claims, signature = token.split('.')
+        self.assertEqual(json.loads(_decode(header)),
+                         {'alg': 'HS256', 'typ': 'JWT'})
+        self.assertEqual(json.loads(_decode(claims)), {'sub': 'bob'})
+        expected = hmac.new('secret', '%s.%s' % (header, claims),
+                            hashlib.sha256).digest()
+        self.assertEqual(_decode(signature), expected)
+
+        with mock.patch.dict(sys.modules, {'jwt': None}):
+            self.assertRaises(ValueError, encode_jwt, {}, 'key', 'RS256')
+
+    def test_renewal(self):
+        clock = _Clock()
+        test = mock.Mock(user=7)
+        provider = JWT('secret', lambda test: {'sub': test.user},
+                       lifetime=60, test_case=test, clock=clock)
+        value = _prepare(provider).headers['Authorization']
+        self.assertTrue(value.startswith('Bearer '))
+        claims = json.loads(_decode(value.split('.')[1]))
+        self.assertEqual(claims, {'sub': 7, 'iat': 1000, 'exp': 1060})
+
+        clock.now += 20
+        self.assertEqual(_prepare(provider).headers['Authorization'], value)
+        clock.now += 20
+        self.assertNotEqual(_prepare(provider).headers['Authorization'],
+                            value)
+
+
+# the credentials and the date of the test suite of AWS
+_EXAMPLE = AWSCredentials('AKIDEXAMPLE',
+                          'wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY')
+_DATE = calendar.timegm((2015, 8, 30, 12, 36, 0))
+
+
+class TestSigV4(unittest2.TestCase):
+
+    def _signature(self, url, service='service', credentials=_EXAMPLE,
+                   **options):
+        provider = SigV4(service, 'us-east-1', credentials,
+                         clock=lambda: _DATE)
+        request = _prepare(provider, url=url, **options)
+        return request.headers, request.headers['Authorization']
+
+    def test_test_suite(self):
+        url = 'https://example.amazonaws.com/'
+        headers, signature = self._signature(url)
+        self.assertEqual(headers['X-Amz-Date'], '20150830T123600Z')
+        self.assertEqual(signature, (
+            'AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/'
+            'service/aws4_request, SignedHeaders=host;x-amz-date, Signature='
+            '5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763f'
+            'bf31'))
+
+        headers, signature = self._signature(url +
+                                             '?Param2=value2&Param1=value1')
+        self.assertTrue(signature.endswith(
+            'b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf'
+            '2500'))
+
+    def test_s3(self):
+        credentials = AWSCredentials('AKID', 'secret', session_token='tok')
+        headers, signature = self._signature(
+            'https://bucket.s3.amazonaws.com/key', 's3', credentials,
+            method='PUT', data='body')
+        self.assertEqual(headers['X-Amz-Content-SHA256'],
+                         hashlib.sha256('body').hexdigest())
+        self.assertEqual(headers['X-Amz-Security-Token'], 'tok')
+        self.assertIn('SignedHeaders=host;x-amz-content-sha256;x-amz-date;'
+                      'x-amz-security-token,', signature)
+
+    def test_environment(self):
+        environ = {'AWS_ACCESS_KEY_ID': 'AKID', 'AWS_SECRET_ACCESS_KEY': 'sk'}
+        with mock.patch.dict(os.environ, environ):
+            self.assertEqual(AWSCredentials().get(), ('AKID', 'sk', None))
+
+    def test_instance_role(self):
+        clock = _Clock()
+        session = mock.Mock()
+        session.put.return_value = mock.Mock(status_code=200, text='imds')
+        role = mock.Mock(status_code=200, text='loads-role\n')
+        values = {'AccessKeyId': 'ASIA', 'SecretAccessKey': 'sk',
+                  'Token': 'tok', 'Expiration': '1970-01-01T01:00:00Z'}
+        credentials = mock.Mock(status_code=200)
+        credentials.json.return_value = values
+        session.get.side_effect = [role, credentials] * 2
+
+        with mock.patch.dict(os.environ, {}, clear=True):
+            provider = AWSCredentials(session=session, clock=clock)
+        self.assertEqual(provider.get(), ('ASIA', 'sk', 'tok'))
+        self.assertEqual(session.get.call_args[0][0],
+                         'http://169.254.169.254/latest/meta-data/iam/'
+                         'security-credentials/loads-role')
+        self.assertEqual(session.get.call_args[1]['headers'],
+                         {'X-aws-ec2-metadata-token': 'imds'})
+
+        # renewed 5 minutes before they expire
+        clock.now = 3600 - 301
+        provider.get()
+        self.assertEqual(session.put.call_count, 1)
+        clock.now = 3600 - 299
+        provider.get()
+        self.assertEqual(session.put.call_count, 2)
+
+        session.put.return_value = mock.Mock(status_code=404)
+        provider.expires_at = None
+        self.assertRaises(AuthError, provider.get)
@@ -0,0 +1,61 @@
+import datetime
+import os
+import tempfile
+
+import unittest2
+
+from loads.baseline import get_baseline, parse_margin, write_baseline
+from loads.results import TestResult
+from loads.thresholds import read_thresholds
+
+
+_STATUS = (1, 1, 1, 1)
+
+
+def _get_result():
+    result = TestResult(args={'fqn': 'example.TestWebSite.test_es'})
+    start = datetime.datetime.utcnow()
+    for url, elapsed, status in (('http://localhost', .1, 200),
+                                 ('http://localhost', .1, 500),
+                                 ('http://localhost/items', .01, 200)):
+        result.add_hit(url=url, method='GET', status=status,
+                       started=start, elapsed=elapsed, loads_status=_STATUS)
+    return result
+
+
+class TestBaseline(unittest2.TestCase):
+
+    def test_parse_margin(self):
+        self.assertEqual(parse_margin('20%'), 20.)
+        self.assertEqual(parse_margin('2.5'), 2.5)
+        self.assertRaises(ValueError, parse_margin, '-1%')
+        self.assertRaises(ValueError, parse_margin, 'much')
+
+    def test_get_baseline(self):
+        baseline = get_baseline(_get_result(), margin=50, error_margin=1)
+        self.assertEqual(baseline, [
+            'p95 < 150ms', 'hits_error_rate <= 34.33%',
+            'p95 < 150ms @ http://localhost',
+            'hits_error_rate <= 51% @ http://localhost',
+            'p95 < 15ms @ http://localhost/items',
+            'hits_error_rate <= 1% @ http://localhost/items'])
+
+        self.assertEqual(get_baseline(TestResult()), [])
+
+    def test_write_baseline(self):
+        fd, path = tempfile.mkstemp()
+        os.close(fd)
+        result = _get_result()
+        try:
+            expressions = write_baseline(path, result)
+            with open(path) as f:
+                header = f.readline()
+            thresholds = read_thresholds(path)
+        finally:
+            os.remove(path)
+
+        self.assertTrue('example.TestWebSite.test_es' in header)
+        self.assertEqual([str(t) for t in thresholds], expressions)
+        # the run the baseline was taken on passes it
+        for threshold in thresholds:
+            self.assertTrue(threshold.evaluate(result)[1], threshold)
@@ -1,3 +1,5 @@
+import functools
+import os
 import unittest2
 import tempfile
 import shutil
@@ -6,10 +8,13 @@ import time
 
 import psutil
 from zmq.green.eventloop import ioloop
+from loads.feeders import pack_feeder, read_rows, unpack_feeder
 from loads.util import json
+from loads.transport.dashboard import LiveStats
 from loads.transport.brokerctrl import (BrokerController,
                                         NotEnoughWorkersError,
                                         _compute_observers)
+from loads.transport.capabilities import get_capabilities
 
 
 class Stream(object):
@@ -28,11 +33,32 @@ class FakeBroker(object):
 
     msgs = defaultdict(list)
     endpoints = {'receiver': 'xxx'}
+    live_stats = None
 
     def send_json(self, target, msg):
         self.msgs[str(target)].append(msg)
 
 
+class FakeObserver(object):
+    name = 'fake'
+    events = []
+
+    def __init__(self, args=None, **options):
+        self.options = options
+
+    def run_started(self, run_id):
+        self.events.append(('started', run_id, self.options))
+
+    def threshold_breached(self, run_id, threshold, value):
+        self.events.append(('breached', run_id, threshold, value))
+
+    def set_records(self, records):
+        self.events.append(('records', len(list(records))))
+
+    def __call__(self, test_result):
+        self.events.append(('ended',))
+
+
 class TestBrokerController(unittest2.TestCase):
 
     def setUp(self):
@@ -60,6 +86,15 @@ class TestBrokerController(unittest2.TestCase):
         self.ctrl.unregister_agent('1')
         self.assertFalse('1' in self.ctrl.agents)
 
+    def test_registration_with_tags(self):
+        # the results of an agent may come before its registration
+        self.ctrl.register_agent({'pid': '1', 'hostname': 'here'})
+        self.ctrl.register_agent({'pid': '1', 'hostname': 'here',
+                                  'tags': {'region': 'eu-west'}})
+        self.ctrl.register_agent({'pid': '1', 'hostname': '?'})
+        self.assertEqual(self.ctrl.agents['1']['tags'],
+                         {'region': 'eu-west'})
+
     def test_reserve_agents(self):
         self.ctrl.register_agent({'pid': '1'})
         self.ctrl.register_agent({'pid': '2'})
@@ -89,6 +124,44 @@ class TestBrokerController(unittest2.TestCase):
         self.assertEqual(msgs[0][-1], '{"command":"STOP"}')
         self.assertEqual(len(msgs), 1)
 
+    def test_pause_resume_abort(self):
+        self.ctrl.register_agent({'pid': '1'})
+        self.ctrl.reserve_agents(1, 'run')
+
+        self.assertEqual(self.ctrl.pause_run(['somemsg'], {'run_id': 'run'}),
+                         ['1'])
+        self.assertTrue(self.ctrl._db.get_metadata('run')['paused'])
+        self.ctrl.resume_run(['somemsg'], {'run_id': 'run'})
+        self.ctrl.abort_run(['somemsg'], {'run_id': 'run'})
+
+        metadata = self.ctrl._db.get_metadata('run')
+        self.assertFalse(metadata['paused'])
+        self.assertTrue(metadata['aborted'])
+
+        msgs = [json.loads(msg[-1])['command'] for msg in Stream.msgs
+                if '_STATUS' not in msg[-1]]
+        self.assertEqual(msgs, ['PAUSE', 'RESUME', 'ABORT'])
+
+        # nothing runs that one
+        self.assertEqual(self.ctrl.pause_run(['somemsg'],
+                                             {'run_id': 'other'}), [])
+
+    def test_set_load(self):
+        self.ctrl.register_agent({'pid': '1'})
+        self.ctrl.reserve_agents(1, 'run')
+
+        self.ctrl.set_load(['somemsg'], {'run_id': 'run', 'users': 20,
+                                         'rate': None})
+        self.assertEqual(self.ctrl._db.get_metadata('run')['load'],
+                         {'users': 20})
+
+        msgs = [json.loads(msg[-1]) for msg in Stream.msgs
+                if '_STATUS' not in msg[-1]]
+        self.assertEqual(msgs, [{'command': 'SET_LOAD', 'users': 20}])
+
+        self.assertRaises(ValueError, self.ctrl.set_load, ['somemsg'],
+                          {'run_id': 'run'})
+
     def test_db_access(self):
         self.ctrl.register_agent({'pid': '1'})
         self.ctrl.reserve_agents(1, 'run')
@@ -133,6 +206,183 @@ class TestBrokerController(unittest2.TestCase):
         runs = self.broker.msgs.values()[0][-1]
         self.assertEqual(runs['result']['agents'], ['agent1'])
 
+    def test_capabilities(self):
+        msg = ['somedata', '', 'target']
+        self.addCleanup(self.broker.msgs.clear)
+        self.ctrl.register_agent({'pid': '1', 'tags': {}})
+        self.ctrl.register_agent({'pid': '2', 'tags': {}})
+        self.assertEqual(self.ctrl.get_capabilities('1'), None)
+
+        # the reply of an agent, and the one of an agent older than the
+        # handshake
+        self.ctrl.set_capabilities('1', get_capabilities())
+        self.ctrl.set_capabilities('2')
+        self.assertEqual(self.ctrl.get_capabilities('2')['version'], None)
+
+        # a new registration keeps them
+        self.ctrl.register_agent({'pid': '1', 'tags': {'region': 'eu'}})
+        self.assertEqual(self.ctrl.get_capabilities('1'), get_capabilities())
+
+        # the old agent can't run the think times
+        self.ctrl.run(msg, {'agents': 2, 'args': {'think_time': 1}})
+        self.assertEqual(self.broker.msgs['somedata'][-1],
+                         {'error': 'Not enough agents supporting '
+                                   'think-time: 1 agent(s) running '
+                                   'protocol 1 lack it'})
+        self.ctrl.run(msg, {'agents': 1,
+                            'args': {'think_time': 1, 'requires': ['nope']}})
+        self.assertTrue('nope' in self.broker.msgs['somedata'][-1]['error'])
+
+        self.ctrl.run(msg, {'agents': 1, 'args': {'think_time': 1}})
+        self.assertEqual(self.broker.msgs['somedata'][-1]['result']['agents'],
+                         ['1'])
+
+        # but the tests that need no feature
+        self.ctrl.run(msg, {'agents': 1, 'args': {}})
+        self.assertEqual(self.broker.msgs['somedata'][-1]['result']['agents'],
+                         ['2'])
+
+    def _lose_agents(self):
+        self.addCleanup(self.broker.msgs.clear)
+        self.ctrl.agent_timeout = 0.1
+        self.ctrl.clean()
+        time.sleep(.2)
+        self.ctrl.clean()
+        published = [json.loads(msg) for msg in Stream.msgs
+                     if isinstance(msg, basestring)]
+        return [msg for msg in published
+                if msg['data_type'] == 'agent_lost']
+
+    def test_lost_agent(self):
+        msg = ['somedata', '', 'target']
+        self.ctrl._agents['agent1'] = {'pid': '1234'}
+        self.ctrl.run(msg, {'agents': 1, 'args': {'duration': 60}})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+
+        lost = self._lose_agents()
+        self.assertEqual(lost, [{'data_type': 'agent_lost', 'run_id': run_id,
+                                 'agent_id': 'agent1', 'replacement': None}])
+        metadata = self.ctrl._db.get_metadata(run_id)
+        self.assertTrue(metadata['degraded'])
+        self.assertEqual(metadata['lost_agents'], ['agent1'])
+
+    def test_lost_agent_is_replaced(self):
+        msg = ['somedata', '', 'target']
+        self.ctrl._agents['agent1'] = {'pid': '1234'}
+        self.ctrl.run(msg, {'agents': 1, 'args': {'duration': 60,
+                                                  'redistribute': True}})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+
+        # a new agent shows up before the first one is lost
+        self.ctrl._agents['agent2'] = {'pid': '1235'}
+        Stream.msgs[:] = []
+        lost = self._lose_agents()
+        self.assertEqual(lost[0]['replacement'], 'agent2')
+        self.assertEqual(self.ctrl.runs['agent2'][0], run_id)
+
+        runs = [json.loads(msg[-1]) for msg in Stream.msgs
+                if not isinstance(msg, basestring) and msg[0] == 'agent2' and
+                'RUN' in msg[-1]]
+        self.assertEqual(len(runs), 1)
+        self.assertEqual(runs[0]['run_id'], run_id)
+        self.assertTrue(runs[0]['args']['duration'] < 60)
+        self.assertEqual(runs[0]['args']['agent_index'], 0)
+        self.assertFalse('started' in runs[0]['args'])
+
+        metadata = self.ctrl._db.get_metadata(run_id)
+        self.assertFalse(metadata.get('degraded'))
+
+    def _get_sent(self, command):
+        sent = []
+        for msg in Stream.msgs:
+            if isinstance(msg, basestring) or command not in msg[-1]:
+                continue
+            sent.append((msg[0], json.loads(msg[-1])))
+        return sent
+
+    def test_max_rps(self):
+        msg = ['somedata', '', 'target']
+        for index in range(3):
+            self.ctrl._agents['agent%d' % index] = {
+                'pid': str(index), 'capabilities': get_capabilities()}
+        self.ctrl.run(msg, {'agents': 3, 'args': {'duration': 60,
+                                                  'max_rps': 300,
+                                                  'redistribute': True}})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+        self.addCleanup(self.broker.msgs.clear)
+        runs = self._get_sent('RUN')
+        self.assertEqual([run['args']['agent_max_rps'] for _, run in runs],
+                         [100.] * 3)
+
+        # the share of a lost agent goes to the others...
+        Stream.msgs[:] = []
+        lost = self.ctrl._get_run_agents(run_id)[0]
+        self.ctrl.agent_timeout = 5
+        for agent_id in self.ctrl._get_run_agents(run_id):
+            self.ctrl._agent_times[agent_id] = time.time()
+        self.ctrl._agent_times[lost] = time.time() - 10
+        self.ctrl.clean()
+        loads = self._get_sent('SET_LOAD')
+        self.assertEqual(sorted([agent_id for agent_id, _ in loads]),
+                         sorted(self.ctrl._get_run_agents(run_id)))
+        self.assertEqual([load['rps'] for _, load in loads], [150.] * 2)
+
+        # ...and to its replacement
+        del self.ctrl._agents[lost]
+        self.ctrl._agents['agent3'] = {'pid': '3',
+                                       'capabilities': get_capabilities()}
+        Stream.msgs[:] = []
+        lost = self.ctrl._get_run_agents(run_id)[0]
+        self.ctrl._agent_times[lost] = time.time() - 10
+        self.ctrl.clean()
+        runs = self._get_sent('RUN')
+        self.assertEqual([(agent_id, run['args']['agent_max_rps'])
+                          for agent_id, run in runs], [('agent3', 150.)])
+        self.assertEqual([load['rps'] for _, load
+                          in self._get_sent('SET_LOAD')], [150.] * 2)
+
+        # and a new ceiling is shared too
+        Stream.msgs[:] = []
+        self.ctrl.set_load(['somemsg'], {'run_id': run_id, 'max_rps': 600})
+        self.assertEqual([load['rps'] for _, load
+                          in self._get_sent('SET_LOAD')], [300.] * 2)
+        self.assertEqual(self.ctrl._run_data[run_id][0]['args']['max_rps'],
+                         600)
+
+    def test_feeder_partitions(self):
+        fd, feeder = tempfile.mkstemp(suffix='.jsonl')
+        with os.fdopen(fd, 'w') as f:
+            for index in range(5):
+                f.write('{"login": "user%d"}\n' % index)
+        self.addCleanup(os.remove, feeder)
+
+        msg = ['somedata', '', 'target']
+        for index in range(2):
+            self.ctrl._agents['agent%d' % index] = {
+                'pid': str(index), 'capabilities': get_capabilities()}
+        Stream.msgs[:] = []
+        self.ctrl.run(msg, {'agents': 2, 'args': {'feeder': feeder},
+                            'feeder_data': pack_feeder(feeder)})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+        self.addCleanup(self.broker.msgs.clear)
+
+        partitions = []
+        for agent_id, run in sorted(self._get_sent('RUN'),
+                                    key=lambda sent: sent[1]['args'][
+                                        'agent_index']):
+            self.assertFalse('feeder_data' in run)
+            unpack_feeder(run['feeder_partition'], feeder)
+            partitions.append([row['login'] for row in read_rows(feeder)])
+        self.assertEqual(partitions, [['user0', 'user2', 'user4'],
+                                      ['user1', 'user3']])
+
+        # the run data does not keep them
+        data = self.ctrl._run_data[run_id][0]
+        self.assertFalse('feeder_data' in data)
+        self.assertFalse('feeder_partition' in data)
+        self.ctrl.test_ended(run_id)
+        self.assertFalse(run_id in self.ctrl._partitions)
+
     def test_run_command(self):
         msg = ['somedata', '', 'target']
         data = {'agents': 1, 'args': {}, 'agent_id': '1'}
@@ -165,3 +415,383 @@ class TestBrokerController(unittest2.TestCase):
 
         self.assertEqual(self.ctrl._agent_times, {})
         self.ctrl.test_ended('run')
+
+    def test_state(self):
+        self.addCleanup(self.broker.msgs.clear)
+        self.ctrl._agents['agent1'] = {'pid': 'agent1'}
+        self.ctrl.run(['somedata', '', 'target'],
+                      {'agents': 1, 'args': {'fqn': 'a.Test.test_a'},
+                       'filedata': 'zip'})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+
+        # the state goes through the publisher
+        state = json.loads(json.dumps(self.ctrl.get_state()))
+        self.assertEqual(state['agents'], {'agent1': {'pid': 'agent1'}})
+        self.assertNotIn('filedata', state['run_data'][run_id][0])
+        self.assertEqual(state['metadata'][run_id]['fqn'], 'a.Test.test_a')
+
+        dbdir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, dbdir)
+        standby = BrokerController(self.broker, ioloop.IOLoop(),
+                                   dboptions={'directory': dbdir})
+        standby.set_state(state)
+        self.assertEqual(standby.agents, self.ctrl.agents)
+        self.assertEqual(standby.runs['agent1'][0], run_id)
+        self.assertEqual(standby._run_data[run_id][1], {'agent1': 0})
+        self.assertTrue(standby._db.get_metadata(run_id)['active'])
+
+    def test_continue_run(self):
+        self.addCleanup(self.broker.msgs.clear)
+        msg = ['somedata', '', 'target']
+        self.ctrl._agents['agent1'] = {'pid': '1234'}
+        self.ctrl.run(msg, {'agents': 1, 'args': {'agents': 1,
+                                                  'duration': 600}})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+
+        checkpoint = {'elapsed': 120., 'iterations': 10,
+                      'feeders': {'unique:users.csv': 4}}
+        self.ctrl.save_data('agent1', dict(checkpoint,
+                                           data_type='checkpoint'))
+        self.ctrl.flush_db()
+        metadata = self.ctrl._db.get_metadata(run_id)
+        self.assertEqual(metadata['checkpoints']['0']['iterations'], 10)
+
+        self.ctrl.continue_run(msg, {'run_id': run_id})
+        self.assertEqual(self.broker.msgs['somedata'][-1],
+                         {'error': 'The run %s is running' % run_id})
+
+        # the broker is restarted, and another agent takes over
+        ctrl = BrokerController(self.broker, ioloop.IOLoop(),
+                                dboptions={'directory': self.dbdir})
+        ctrl._agents['agent2'] = {'pid': '1235'}
+        Stream.msgs[:] = []
+        ctrl.continue_run(msg, {'run_id': run_id})
+        self.assertEqual(self.broker.msgs['somedata'][-1]['result'],
+                         {'agents': ['agent2'], 'run_id': run_id})
+
+        runs = [json.loads(msg_[-1]) for msg_ in Stream.msgs
+                if msg_[0] == 'agent2']
+        self.assertEqual(len(runs), 1)
+        self.assertEqual(runs[0]['args']['duration'], 600)
+        self.assertEqual(runs[0]['args']['checkpoint']['feeders'],
+                         checkpoint['feeders'])
+
+        metadata = ctrl._db.get_metadata(run_id)
+        self.assertEqual(metadata['resumed'], 1)
+        self.assertTrue(metadata['started'] <= time.time() - 120)
+
+        ctrl.continue_run(msg, {'run_id': 'xxx'})
+        self.assertEqual(self.broker.msgs['somedata'][-1],
+                         {'error': 'Unknown run xxx'})
+
+    def _published(self, data_type):
+        published = [json.loads(msg) for msg in Stream.msgs
+                     if isinstance(msg, basestring)]
+        return [msg for msg in published if msg['data_type'] == data_type]
+
+    def _sent(self, agent_id, command):
+        sent = [json.loads(msg[-1]) for msg in Stream.msgs
+                if not isinstance(msg, basestring) and msg[0] == agent_id]
+        return [msg for msg in sent if msg['command'] == command]
+
+    def test_queue(self):
+        self.addCleanup(self.broker.msgs.clear)
+        msg = ['somedata', '', 'target']
+        self.ctrl.run(msg, {'agents': 1, 'args': {'queue': True}})
+        res = self.broker.msgs['somedata'][-1]['result']
+        self.assertEqual(res['agents'], [])
+        self.assertEqual(res['queued'], 1)
+        first = res['run_id']
+        self.assertTrue(self.ctrl._db.get_metadata(first)['queued'])
+
+        # a run of a higher priority goes first
+        self.ctrl.run(msg, {'agents': 1, 'args': {'queue': True,
+                                                  'priority': 5}})
+        res = self.broker.msgs['somedata'][-1]['result']
+        self.assertEqual(res['queued'], 1)
+        second = res['run_id']
+        self.assertEqual([(entry['run_id'], entry['position'])
+                          for entry in self.ctrl.queue],
+                         [(second, 1), (first, 2)])
+        self.assertEqual(self._published('run-queue')[-1]['queue'],
+                         self.ctrl.queue)
+
+        # it starts once an agent shows up
+        self.ctrl.register_agent({'pid': 'agent1'})
+        self.assertEqual(self.ctrl.runs['agent1'][0], second)
+        self.assertEqual(len(self._sent('agent1', 'RUN')), 1)
+        self.assertEqual(self._published('run-started')[0]['agents'],
+                         ['agent1'])
+        metadata = self.ctrl._db.get_metadata(second)
+        self.assertFalse(metadata['queued'])
+        self.assertTrue(metadata['started'] > 0)
+
+        # a queued run can be stopped
+        self.assertEqual(self.ctrl.stop_run(msg, {'run_id': first}), [])
+        self.assertEqual(self.ctrl.queue, [])
+        self.assertEqual(self._published('run-finished')[0]['run_id'],
+                         first)
+
+    def test_project_limits(self):
+        self.addCleanup(self.broker.msgs.clear)
+        msg = ['somedata', '', 'target']
+        self.ctrl.project_limits = {'*': 1}
+        self.ctrl._agents['agent1'] = {'pid': 'agent1'}
+        self.ctrl._agents['agent2'] = {'pid': 'agent2'}
+
+        self.ctrl.run(msg, {'agents': 1, 'args': {'project_name': 'a'}})
+        self.assertFalse('error' in self.broker.msgs['somedata'][-1])
+        self.ctrl.run(msg, {'agents': 1, 'args': {'project_name': 'a'}})
+        self.assertEqual(self.broker.msgs['somedata'][-1],
+                         {'error': 'The project a has too many runs'})
+
+        # the run waits for the other run of its project, not for agents
+        self.ctrl.run(msg, {'agents': 1, 'args': {'project_name': 'a',
+                                                  'queue': True}})
+        self.assertEqual(self.broker.msgs['somedata'][-1]['result']['queued'],
+                         1)
+        self.ctrl.run(msg, {'agents': 1, 'args': {'project_name': 'b',
+                                                  'queue': True}})
+        res = self.broker.msgs['somedata'][-1]['result']
+        self.assertEqual(len(res['agents']), 1)
+        self.assertFalse('queued' in res)
+
+    def test_preemption(self):
+        self.addCleanup(self.broker.msgs.clear)
+        msg = ['somedata', '', 'target']
+        self.ctrl._agents['agent1'] = {'pid': 'agent1'}
+        self.ctrl.run(msg, {'agents': 1, 'args': {'duration': 600}})
+        low = self.broker.msgs['somedata'][-1]['result']['run_id']
+
+        self.ctrl.run(msg, {'agents': 1, 'args': {'queue': True,
+                                                  'priority': 1}})
+        high = self.broker.msgs['somedata'][-1]['result']['run_id']
+        self.assertEqual(len(self._sent('agent1', 'ABORT')), 1)
+        self.assertEqual(self._published('run-preempted')[0]['run_id'], low)
+        self.assertEqual(self.ctrl._db.get_metadata(low)['preempted'], 1)
+
+        # once its agent stopped, the preempted run goes back to the queue
+        self.ctrl.save_data('agent1', {'data_type': 'checkpoint',
+                                       'elapsed': 100., 'iterations': 10})
+        status = {'status': {'1': 'terminated'}, 'command': '_STATUS'}
+        self.assertEqual(self.ctrl.update_status('agent1', status), None)
+        self.assertEqual(self.ctrl.runs['agent1'][0], high)
+        self.assertEqual(self.ctrl.queue[0]['run_id'], low)
+        self.assertTrue(self.ctrl.queue[0]['preempted'])
+        self.assertFalse(self.ctrl._db.get_metadata(low).get('stopped'))
+
+        # and goes on from its checkpoint after the other one
+        Stream.msgs[:] = []
+        self.assertEqual(self.ctrl.update_status('agent1', status), high)
+        runs = self._sent('agent1', 'RUN')
+        self.assertEqual(runs[0]['run_id'], low)
+        self.assertEqual(runs[0]['args']['checkpoint']['iterations'], 10)
+        self.assertEqual(runs[0]['args']['duration'], 600)
+        self.assertEqual(self.ctrl.queue, [])
+
+    def test_scopes(self):
+        self.addCleanup(self.broker.msgs.clear)
+        msg = ['somedata', '', 'target']
+        self.ctrl._agents['agent1'] = {'pid': '1', 'tags': {'project': 'a'}}
+        self.ctrl._agents['agent2'] = {'pid': '2', 'tags': {'project': 'b'}}
+        self.ctrl._agents['agent3'] = {'pid': '3'}
+        self.assertEqual(sorted(self.ctrl.get_agents('b')),
+                         ['agent2', 'agent3'])
+
+        # the run of a key is one of its project, on the agents of its pool
+        self.ctrl.run(msg, {'agents': 2, 'scope': 'a',
+                            'args': {'project_name': 'b'}})
+        res = self.broker.msgs['somedata'][-1]['result']
+        self.assertEqual(sorted(res['agents']), ['agent1', 'agent3'])
+        run_id = res['run_id']
+        self.assertEqual(self.ctrl.get_project(run_id), 'a')
+
+        self.assertEqual(self.ctrl.list_runs(msg, {'scope': 'b'}), {})
+        self.assertEqual(len(self.ctrl.list_runs(msg, {'scope': 'a'})), 1)
+        self.assertEqual(len(self.ctrl.list_runs(msg, {'scope': None})), 1)
+
+        # the runs and the agents of the other projects are unknown
+        self.ctrl.run_command('STOP_RUN', msg, {'run_id': run_id,
+                                                'scope': 'b'})
+        self.assertEqual(self.broker.msgs['somedata'][-1],
+                         {'error': 'Unknown run %s' % run_id})
+        self.ctrl.run_command('AGENT_STATUS', msg, {'agent_id': 'agent1',
+                                                    'scope': 'b'})
+        self.assertEqual(self.broker.msgs['somedata'][-1],
+                         {'error': 'Unknown agent agent1'})
+
+    def test_schedules(self):
+        self.addCleanup(self.broker.msgs.clear)
+        msg = ['somedata', '', 'target']
+        data = {'agents': 1, 'args': {'fqn': 'a.Test.test_a',
+                                      'cron': '0 2 * * *',
+                                      'series': 'nightly'},
+                'filedata': 'zip'}
+        res = self.ctrl.schedule_run(msg, data)
+        self.assertTrue(res['next'] > time.time())
+        res = self.ctrl.schedule_run(msg, {'agents': 1, 'scope': 'b',
+                                           'args': {'at': 1.}})
+        once = res['schedule_id']
+        self.assertRaises(ValueError, self.ctrl.schedule_run, msg,
+                          {'agents': 1, 'args': {}})
+        self.assertEqual(len(self.ctrl.list_schedules(msg, {})), 2)
+        self.assertEqual(len(self.ctrl.list_schedules(msg, {'scope': 'b'})),
+                         1)
+
+        # the due runs wait in the queue for their agents
+        self.ctrl._check_schedules()
+        self.assertEqual(len(self.ctrl.queue), 1)
+        self.assertEqual(self.ctrl.queue[0]['project'], 'b')
+        metadata = self.ctrl._db.get_metadata(self.ctrl.queue[0]['run_id'])
+        self.assertEqual(metadata['scheduled'], 1.)
+        self.assertEqual(metadata['schedule_id'], once)
+        self.assertFalse('at' in metadata)
+
+        # the recurring ones stay
+        schedules = self.ctrl.list_schedules(msg, {})
+        self.assertEqual(len(schedules), 1)
+        nightly = schedules[0]['schedule_id']
+        first = schedules[0]['next']
+        self.ctrl._check_schedules(first)
+        schedules = self.ctrl.list_schedules(msg, {})
+        self.assertEqual(schedules[0]['runs'], 1)
+        self.assertEqual(schedules[0]['next'] - first, 24 * 3600)
+        run = self.ctrl._get_entry(schedules[0]['last_run'])
+        self.assertEqual(run['data']['filedata'], 'zip')
+        self.assertEqual(run['data']['args']['series'], 'nightly')
+
+        # the standby broker gets them
+        state = json.loads(json.dumps(self.ctrl.get_state()))
+        self.assertEqual(len(state['schedules']), 1)
+
+        self.assertRaises(ValueError, self.ctrl.cancel_schedule, msg,
+                          {'schedule_id': nightly, 'scope': 'b'})
+        self.ctrl.cancel_schedule(msg, {'schedule_id': nightly})
+        self.assertEqual(self.ctrl.list_schedules(msg, {}), [])
+
+    def test_live_stats(self):
+        msg = ['somedata', '', 'target']
+        self.assertRaises(ValueError, self.ctrl.get_live_stats, msg,
+                          {'run_id': 'run'})
+
+        self.broker.live_stats = LiveStats()
+        self.addCleanup(setattr, self.broker, 'live_stats', None)
+        self.ctrl._runs['agent1'] = 'run', time.time()
+        for agent_id in ('agent1', 'agent2'):
+            self.broker.live_stats.add({'data_type': 'add_hit',
+                                        'agent_id': agent_id, 'status': 200,
+                                        'elapsed': .1})
+        stats = self.ctrl.get_live_stats(msg, {'run_id': 'run'})
+        self.assertEqual(stats['agents'].keys(), ['agent1'])
+        self.assertTrue(stats['active'])
+        self.assertFalse('queue' in stats)
+        stats = self.ctrl.get_live_stats(msg, {'run_id': 'other'})
+        self.assertEqual((stats['agents'], stats['active']), ({}, False))
+
+    def test_reserve_ids(self):
+        msg = ['somedata', '', 'target']
+        self.ctrl.save_metadata('run', {'sequences': {'users': 5}})
+        reserve = functools.partial(self.ctrl.reserve_ids, msg)
+        self.assertEqual(reserve({'run_id': 'run', 'size': 10}), 0)
+        self.assertEqual(reserve({'run_id': 'run', 'name': 'users'}), 5)
+        self.assertEqual(reserve({'run_id': 'run', 'size': 10}), 10)
+        self.assertRaises(ValueError, reserve, {'run_id': 'run', 'size': 0})
+        self.assertEqual(self.ctrl._db.get_metadata('run')['sequences'],
+                         {'default': 20, 'users': 10005})
+
+        # the sequences of a resumed run go on
+        self.ctrl._sequences.clear()
+        self.assertEqual(reserve({'run_id': 'run', 'size': 10}), 20)
+
+    def test_fixtures(self):
+        msg = ['somedata', '', 'target']
+        self.ctrl.save_metadata('run', {})
+        self.ctrl._run_data['run'] = ({'args': {}}, {'agent1': 0,
+                                                     'agent2': 1})
+        self.assertEqual(self.ctrl.get_fixtures(msg, {'run_id': 'run'}),
+                         None)
+        self.ctrl.set_fixtures(msg, {'run_id': 'run',
+                                     'fixtures': {'tenant': 't1'}})
+        fixtures = self.ctrl.get_fixtures(msg, {'run_id': 'run'})
+        self.assertEqual(fixtures['fixtures'], {'tenant': 't1'})
+
+        # the last agent done with the load tears the fixtures down, once
+        release = functools.partial(self.ctrl.release_fixtures, msg)
+        self.assertFalse(release({'run_id': 'run', 'agent_index': 1}))
+        self.assertFalse(release({'run_id': 'run', 'agent_index': 1}))
+        self.assertTrue(release({'run_id': 'run', 'agent_index': 0}))
+        self.assertFalse(release({'run_id': 'run', 'agent_index': 0}))
+
+        # nothing to tear down after a failed setup
+        self.ctrl.set_fixtures(msg, {'run_id': 'run', 'error': 'no tenant'})
+        self.assertFalse(release({'run_id': 'run', 'agent_index': 0}))
+
+    def test_series(self):
+        self.addCleanup(self.broker.msgs.clear)
+        msg = ['somedata', '', 'target']
+        self.ctrl._agents['agent1'] = {'pid': 'agent1'}
+        self.ctrl.run(msg, {'agents': 1, 'args': {'series': 'nightly'}})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+        self.ctrl.run(msg, {'agents': 1, 'args': {'queue': True}})
+        for elapsed, status in ((.1, 200), (.3, 500), (.2, 200)):
+            self.ctrl.save_data('agent1', {'data_type': 'add_hit',
+                                           'elapsed': elapsed,
+                                           'status': status,
+                                           'url': 'http://a', 'method': 'GET'})
+        self.ctrl.test_ended(run_id)
+
+        series = self.ctrl.get_series(msg, {'series': 'nightly'})
+        self.assertEqual([run['run_id'] for run in series], [run_id])
+        summary = series[0]['summary']
+        self.assertEqual((summary['hits'], summary['errors']), (3, 1))
+        self.assertEqual(summary['p50'], .2)
+        self.assertEqual(self.ctrl.get_series(msg, {'series': 'nightly',
+                                                    'scope': 'b'}), [])
+
+    def test_observers(self):
+        self.addCleanup(self.broker.msgs.clear)
+        self.addCleanup(setattr, FakeObserver, 'events', [])
+        self.broker.web_root = None
+        fqn = 'loads.tests.test_brokerctrl.FakeObserver'
+        self.ctrl.observers = {fqn: {'level': 1}}
+        msg = ['somedata', '', 'target']
+        self.ctrl._agents['agent1'] = {'pid': 'agent1'}
+        args = {'threshold': ['p50 < 100ms', 'error_rate < 50%'],
+                'observer_fake_level': 2}
+        self.ctrl.run(msg, {'agents': 1, 'args': args})
+        run_id = self.broker.msgs['somedata'][-1]['result']['run_id']
+        self.assertEqual(FakeObserver.events,
+                         [('started', run_id, {'level': 2})])
+
+        for elapsed in (.1, .3, .2):
+            self.ctrl.save_data('agent1', {'data_type': 'add_hit',
+                                           'elapsed': elapsed,
+                                           'status': 200,
+                                           'url': 'http://a', 'method': 'GET'})
+        self.ctrl.clean()
+        self.ctrl.clean()
+        self.assertEqual(FakeObserver.events[1:],
+                         [('breached', run_id, 'p50 < 100ms', '200.1ms')])
+        metadata = self.ctrl._db.get_metadata(run_id)
+        self.assertEqual(metadata['breached'], ['p50 < 100ms'])
+
+        self.ctrl.test_ended(run_id)
+        self.assertEqual(FakeObserver.events[-2:], [('records', 3),
+                                                    ('ended',)])
+        self.assertFalse(run_id in self.ctrl._watched)
+
+    def test_plugin_observers(self):
+        self.addCleanup(self.broker.msgs.clear)
+        self.addCleanup(setattr, self.ctrl, 'plugins', [])
+        calls = []
+
+        class _Plugin(object):
+            name = 'fake'
+
+            def call(self, service, method, value=None):
+                calls.append((service, method, value['name']))
+
+        self.ctrl.plugins = [_Plugin()]
+        self.ctrl._agents['agent1'] = {'pid': 'agent1'}
+        self.ctrl.run(['somedata', '', 'target'], {'agents': 1, 'args': {}})
+        self.assertEqual(calls, [('Observer', 'Notify', 'started')])
@@ -64,3 +64,21 @@ class TestTestCase(unittest2.TestCase):
             self.assertEquals(test.server_url, 'http://example.org')
         finally:
             del _MyTestCase.server_url
+
+    def test_redirects_policy(self):
+        test = _MyTestCase('test_one', test_result=mock.sentinel.results,
+                           config={'max_redirects': 0})
+        self.assertFalse(test.session.follow_redirects)
+
+        test = _MyTestCase('test_one', test_result=mock.sentinel.results,
+                           config={'max_redirects': 3})
+        self.assertTrue(test.session.follow_redirects)
+        self.assertEqual(test.session.max_redirects, 3)
+
+    def test_reset_session(self):
+        test = _MyTestCase('test_one', test_result=mock.sentinel.results)
+        test.session.cookies.set('session', 'xxx')
+        test.state['token'] = 'yyy'
+        test.reset_session()
+        self.assertEqual(len(test.session.cookies), 0)
+        self.assertEqual(test.state, {})
@@ -0,0 +1,131 @@
+import datetime
+import json
+import os
+import tempfile
+
+import unittest2
+
+from loads.chaos import (Chaos, ChaosEvent, kill_agents, parse_chaos,
+                         read_chaos)
+from loads.report import Report, from_records, render
+from loads.results import TestResult
+
+
+class _Clock(object):
+
+    def __init__(self):
+        self.now = 1000.
+
+    def __call__(self):
+        return self.now
+
+
+class _Client(object):
+
+    def __init__(self):
+        self.stopped = []
+
+    def stop(self, agent_id):
+        self.stopped.append(agent_id)
+
+
+class TestChaos(unittest2.TestCase):
+
+    def _write(self, events):
+        fd, path = tempfile.mkstemp(suffix='.json')
+        os.write(fd, json.dumps(events))
+        os.close(fd)
+        self.addCleanup(os.remove, path)
+        return path
+
+    def test_event(self):
+        event = ChaosEvent('2m', http='http://flags/on')
+        self.assertEqual(event.at, 120)
+        self.assertEqual(event.action, 'http')
+        self.assertEqual((event.method, event.url), ('GET', 'http://flags/on'))
+        self.assertEqual(str(event), 'http http://flags/on')
+
+        event = ChaosEvent('30s', name='lose', kill_agents='20%')
+        self.assertEqual(str(event), 'lose')
+        self.assertEqual(event.get_agents_to_kill(10), 2)
+        self.assertEqual(event.get_agents_to_kill(3), 1)
+        self.assertEqual(ChaosEvent(0, kill_agents=5).get_agents_to_kill(3),
+                         3)
+
+        for bad in ({}, {'command': 'ls', 'http': 'http://a'},
+                    {'kill_agents': '0%'}, {'kill_agents': 0},
+                    {'kill_agents': 'some'}):
+            self.assertRaises(ValueError, ChaosEvent, '1s', **bad)
+
+    def test_read_chaos(self):
+        path = self._write([{'at': '2m', 'command': 'true'},
+                            {'at': '30', 'http': 'POST http://flags/on'}])
+        events = read_chaos(path)
+        self.assertEqual([event.at for event in events], [30, 120])
+        self.assertEqual(parse_chaos(path), path)
+
+        for bad in ({'at': '1s'}, [{'command': 'true'}],
+                    [{'at': '1s', 'command': 'true', 'when': 'now'}]):
+            self.assertRaises(ValueError, read_chaos, self._write(bad))
+
+    def test_tick(self):
+        clock = _Clock()
+        result = TestResult()
+        chaos = Chaos([ChaosEvent('10s', name='ok', command='echo done'),
+                       ChaosEvent('20s', name='ko', command='exit 3'),
+                       ChaosEvent('20s', name='lose', kill_agents=1)],
+                      result, clock=clock)
+        chaos.tick()
+        clock.now += 5
+        chaos.tick()
+        self.assertEqual(result.chaos_events, [])
+
+        clock.now += 5
+        chaos.tick()
+        self.assertEqual([(event['name'], event['success'], event['detail'])
+                          for event in result.chaos_events],
+                         [('ok', True, 'done')])
+
+        clock.now += 10
+        chaos.tick()
+        self.assertEqual([(event['name'], event['success'], event['detail'])
+                          for event in result.chaos_events[1:]],
+                         [('ko', False, 'exited with 3: '),
+                          ('lose', False, 'only in distributed mode')])
+        self.assertEqual(chaos.pending, [])
+
+    def test_kill_agents(self):
+        client = _Client()
+        event = ChaosEvent(0, kill_agents='50%')
+        killed = kill_agents(client, ['1', '2', '3', '4'], event)
+        self.assertEqual(len(killed), 2)
+        self.assertEqual(client.stopped, killed)
+        self.assertRaises(ValueError, kill_agents, client, [], event)
+
+        result = TestResult()
+        chaos = Chaos([event], result,
+                      lambda event: kill_agents(client, ['5'], event))
+        chaos.tick()
+        self.assertEqual(result.chaos_events[0]['detail'], 'stopped 5')
+
+    def test_report(self):
+        start = datetime.datetime(2013, 5, 14, 0, 51, 8)
+        records = [{'data_type': 'add_hit', 'url': 'http://a',
+                    'method': 'GET', 'status': 200, 'elapsed': .1,
+                    'started': (start + datetime.timedelta(seconds=second)
+                                ).isoformat()} for second in (0, 10)]
+        records.append({'data_type': 'chaos_event', 'name': 'restart <api>',
+                        'action': 'command', 'success': False,
+                        'detail': 'exited with 1',
+                        'started': (start + datetime.timedelta(seconds=5)
+                                    ).isoformat()})
+        report = Report(from_records(records))
+        self.assertEqual([(second, event['name']) for second, event
+                          in report.get_chaos_events()],
+                         [(5, 'restart <api>')])
+
+        html = render(report)
+        self.assertTrue('<h2>Chaos events</h2>' in html)
+        self.assertTrue('stroke-dasharray' in html)
+        self.assertTrue('restart &lt;api&gt;' in html)
+        self.assertTrue('<td>FAILED</td>' in html)
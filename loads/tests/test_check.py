@@ -0,0 +1,72 @@
+import os
+import tempfile
+from StringIO import StringIO
+
+import unittest2
+
+from loads import check
+from loads.case import TestCase
+from loads.tests.support import hush
+
+
+class _Test(TestCase):
+    scenarios = {'get_page': 2, 'post_form': 1}
+
+    def get_page(self):
+        res = self.session.get('http://example.invalid/{{uuid}}')
+        self.assertEqual(res.status_code, 200)
+
+    def post_form(self):
+        self.session.post('http://example.invalid/', data='x' * 500)
+        self.fail('broken')
+
+
+class TestCheck(unittest2.TestCase):
+
+    def setUp(self):
+        fd, self.feeder = tempfile.mkstemp(suffix='.csv')
+        os.write(fd, 'user,password\nbob,secret\n')
+        os.close(fd)
+        self.addCleanup(os.remove, self.feeder)
+
+    def _check(self, **args):
+        args.setdefault('fqn', 'loads.tests.test_check._Test.get_page')
+        args.setdefault('mock', True)
+        args.setdefault('templates', True)
+        stream = StringIO()
+        return check.run_check(args, stream), stream.getvalue()
+
+    def test_one_iteration(self):
+        problems, output = self._check(threshold=['p95 < 250ms'],
+                                       feeder=self.feeder)
+        self.assertEqual(problems, [])
+        self.assertTrue("- threshold 'p95 < 250ms': OK" in output)
+        self.assertTrue('rows of password, user: OK' in output)
+        self.assertTrue('  > GET http://example.invalid/' in output)
+        self.assertFalse('{{uuid}}' in output)
+        self.assertTrue('  < 200 OK' in output)
+        self.assertTrue('- scenario get_page, 1 requests: OK' in output)
+
+    def test_problems(self):
+        problems, output = self._check(threshold=['p95 <'],
+                                       fqn='loads.tests.test_check._Test')
+        self.assertEqual(len(problems), 2)
+        self.assertTrue(problems[0].startswith("threshold 'p95 <'"))
+        self.assertEqual(problems[1], 'scenario post_form: '
+                                      'AssertionError: broken')
+        self.assertTrue('x' * check.MAX_BODY + '...' in output)
+        self.assertTrue('- scenario get_page, 1 requests: OK' in output)
+
+        problems, output = self._check(fqn='loads.tests.test_check._Nope')
+        self.assertEqual(len(problems), 1)
+
+        open(self.feeder, 'w').close()
+        problems, output = self._check(feeder=self.feeder)
+        self.assertEqual(problems, ['feeder %s: no rows' % self.feeder])
+
+    @hush
+    def test_main(self):
+        fqn = 'loads.tests.test_check._Test'
+        self.assertEqual(check.main([fqn + '.get_page', '--mock']), 0)
+        self.assertEqual(check.main([fqn, '--mock']), 1)
+
@@ -0,0 +1,65 @@
+import datetime
+
+import unittest2
+
+from loads.case import TestCase
+from loads.checks import run_checks
+from loads.results import TestResult
+
+
+class _Response(object):
+    def __init__(self, status_code=200, text='', elapsed=.1):
+        self.status_code = status_code
+        self.text = text
+        self.headers = {}
+        self.elapsed = datetime.timedelta(seconds=elapsed)
+
+
+class _WebTestResponse(object):
+    status_int = 404
+    body = 'Not Found'
+
+
+class _CheckTestCase(TestCase):
+    def test_check(self):
+        pass
+
+
+class TestChecks(unittest2.TestCase):
+
+    def test_run_checks(self):
+        resp = _Response(text='{"user": {"name": "bob"}}')
+        results = run_checks(resp, status=200, contains='bob', latency=.3,
+                             json={'$.user.name': 'bob', '$.user.id': 1})
+        self.assertEqual(results, [('status == 200', True),
+                                   ("body contains 'bob'", True),
+                                   ('latency < 300ms', True),
+                                   ('$.user.id == 1', False),
+                                   ("$.user.name == 'bob'", True)])
+
+        resp = _Response(status_code=500, elapsed=.5)
+        results = run_checks(resp, status=200, latency=.3)
+        self.assertEqual(results, [('status == 200', False),
+                                   ('latency < 300ms', False)])
+
+    def test_webtest_responses(self):
+        results = run_checks(_WebTestResponse(), status=404,
+                             contains='Found')
+        self.assertEqual(results, [('status == 404', True),
+                                   ("body contains 'Found'", True)])
+        self.assertRaises(ValueError, run_checks, _WebTestResponse(),
+                          latency=.3)
+
+    def test_checks_are_reported(self):
+        result = TestResult()
+        test = _CheckTestCase('test_check', test_result=result)
+
+        self.assertTrue(test.check(_Response(), status=200))
+        self.assertFalse(test.check(_Response(500), 'home', status=200,
+                                    latency=.3))
+
+        self.assertEqual(result.get_checks(),
+                         {'status == 200': {'passed': 1, 'failed': 0},
+                          'home: status == 200': {'passed': 0, 'failed': 1},
+                          'home: latency < 300ms': {'passed': 1,
+                                                    'failed': 0}})
@@ -0,0 +1,156 @@
+import datetime
+import os
+import random
+import shutil
+import tempfile
+from StringIO import StringIO
+
+import unittest2
+
+from loads.compare import (compare, get_trend, main, mann_whitney,
+                           print_trend, two_proportions)
+from loads.output import FileOutput
+from loads.report import Report
+from loads.tests.support import hush
+
+
+TIME1 = datetime.datetime(2013, 5, 14, 0, 51, 8)
+_TEST = 'test_es (module.TestSite)'
+
+
+def _get_hits(times, errors=0):
+    hits = []
+    for index, elapsed in enumerate(times):
+        hits.append({'url': 'http://a/users/%d' % index, 'method': 'GET',
+                     'status': index < errors and 500 or 200,
+                     'started': TIME1.isoformat(), 'elapsed': elapsed,
+                     'scenario': 'test_es', 'loads_status': [1, 1, 1, 1]})
+    return hits
+
+
+def _get_report(times, errors=0, failures=0):
+    report = Report([])
+    for hit in _get_hits(times, errors):
+        report.add('add_hit', hit)
+    for index in range(10):
+        method = index < failures and 'addFailure' or 'addSuccess'
+        report.add(method, {'test': _TEST, 'exc_info': ['E', 'e', ''],
+                            'loads_status': [1, 1, index, 1]})
+    return report
+
+
+class TestStatistics(unittest2.TestCase):
+
+    def test_mann_whitney(self):
+        random.seed(1)
+        first = [random.gauss(.1, .01) for i in range(200)]
+        same = [random.gauss(.1, .01) for i in range(200)]
+        slower = [random.gauss(.12, .01) for i in range(200)]
+
+        self.assertTrue(mann_whitney(first, same) > .05)
+        self.assertTrue(mann_whitney(first, slower) < .001)
+        self.assertTrue(mann_whitney(slower, first) > .999)
+        self.assertEqual(mann_whitney([], first), 1)
+        # all the values are tied
+        self.assertEqual(mann_whitney([.1, .1], [.1, .1]), 1)
+
+    def test_two_proportions(self):
+        self.assertTrue(two_proportions(1, 1000, 50, 1000) < .001)
+        self.assertTrue(two_proportions(10, 1000, 11, 1000) > .05)
+        self.assertEqual(two_proportions(0, 100, 0, 100), 1)
+        self.assertEqual(two_proportions(0, 0, 1, 100), 1)
+
+
+class TestCompare(unittest2.TestCase):
+
+    def _compare(self, before, after, **kw):
+        return dict([(comparison.name, comparison) for comparison
+                     in compare(before, after, **kw)])
+
+    def test_alignment(self):
+        comparisons = self._compare(_get_report([.1] * 10),
+                                    _get_report([.1] * 10))
+        self.assertEqual(sorted(comparisons),
+                         ['test_es (tests)',
+                          'test_es GET http://a/users/{id}'])
+        self.assertFalse([c for c in comparisons.values() if c.regressed])
+
+    def test_latency_regression(self):
+        random.seed(1)
+        before = [random.gauss(.1, .01) for i in range(100)]
+        after = [random.gauss(.13, .01) for i in range(100)]
+        comparison = self._compare(_get_report(before), _get_report(after))[
+            'test_es GET http://a/users/{id}']
+        self.assertEqual([kind for kind, p in comparison.regressions],
+                         ['latency'])
+
+        # within the tolerance
+        comparison = self._compare(_get_report(before), _get_report(after),
+                                   tolerance=.5)[
+            'test_es GET http://a/users/{id}']
+        self.assertFalse(comparison.regressed)
+
+    def test_error_regressions(self):
+        comparisons = self._compare(_get_report([.1] * 100),
+                                    _get_report([.1] * 100, errors=20,
+                                                failures=8))
+        requests = comparisons['test_es GET http://a/users/{id}']
+        self.assertEqual([kind for kind, p in requests.regressions],
+                         ['error rate'])
+        tests = comparisons['test_es (tests)']
+        self.assertEqual([kind for kind, p in tests.regressions],
+                         ['failure rate'])
+
+
+class TestTrend(unittest2.TestCase):
+
+    def _run(self, p50, p95, errors=0, hits=100):
+        return {'run_id': 'run', 'started': 1., 'summary': {
+            'hits': hits, 'errors': errors, 'tests': hits, 'failed': 0,
+            'p50': p50, 'p95': p95}}
+
+    def test_trend(self):
+        runs = [self._run(.1, .2), self._run(.12, .2), self._run(.1, .21),
+                {'run_id': 'running', 'summary': None}]
+        self.assertEqual(get_trend(runs), [])
+        self.assertEqual(get_trend(runs[:1]), [])
+
+        runs.append(self._run(.105, .3, errors=5))
+        self.assertEqual(get_trend(runs), [('p95', .2, .3),
+                                           ('error rate', 0., .05)])
+        # only the last runs count
+        self.assertEqual(get_trend(runs, window=1), [('p95', .21, .3),
+                                                     ('error rate', 0., .05)])
+        self.assertEqual(get_trend(runs, tolerance=1.)[0][0], 'error rate')
+
+        stream = StringIO()
+        print_trend(runs, get_trend(runs), stream)
+        self.assertTrue('p50 105.0ms, p95 300.0ms, 5.0% errors' in
+                        stream.getvalue())
+        self.assertTrue('REGRESSION of the p95: 300.0ms, was 200.0ms' in
+                        stream.getvalue())
+
+
+class TestMain(unittest2.TestCase):
+
+    def setUp(self):
+        self.tmpdir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.tmpdir)
+
+    def _write(self, name, times):
+        filename = os.path.join(self.tmpdir, name)
+        output = FileOutput(None, {'output_file_filename': filename})
+        for hit in _get_hits(times):
+            output.push('add_hit', **hit)
+        output.flush()
+        return filename
+
+    @hush
+    def test_exit_code(self):
+        before = self._write('before', [.1] * 50 + [.11] * 50)
+        after = self._write('after', [.2] * 50 + [.21] * 50)
+        self.assertEqual(main([before, before]), 0)
+        self.assertEqual(main([before, after]), 1)
+        self.assertEqual(main([before, after, '--tolerance', '150%']), 0)
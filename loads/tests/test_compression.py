@@ -0,0 +1,134 @@
+import gzip
+import threading
+import zlib
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+from cStringIO import StringIO
+
+import mock
+import unittest2
+
+from loads import compression
+from loads.case import TestCase
+from loads.results import TestResult
+from loads.results.zmqrelay import summarize_hits
+from loads.util import unbatch
+
+
+_BODY = '{"items": [%s]}' % ', '.join(['"item"'] * 500)
+
+
+def _gzip(data):
+    buffer = StringIO()
+    f = gzip.GzipFile(fileobj=buffer, mode='wb')
+    f.write(data)
+    f.close()
+    return buffer.getvalue()
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        accepted = self.headers.get('Accept-Encoding', '')
+        body, encoding = _BODY, None
+        if self.path == '/zstd':
+            # not really zstd: the test decompresses it with zlib
+            body, encoding = zlib.compress(_BODY), 'zstd'
+        elif 'gzip' in accepted:
+            body, encoding = _gzip(_BODY), 'gzip'
+        self.send_response(200)
+        if encoding is not None:
+            self.send_header('Content-Encoding', encoding)
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_compression(self):
+        pass
+
+
+class TestCompression(unittest2.TestCase):
+
+    def setUp(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        self.url = 'http://127.0.0.1:%d' % server.server_address[1]
+        self.result = TestResult()
+
+    def _get(self, path='/', **config):
+        test = _Test('test_compression', test_result=self.result,
+                     config=config)
+        self.addCleanup(test.session.close)
+        return test.session.get(self.url + path)
+
+    def test_gzip(self):
+        response = self._get(accept_encoding='gzip')
+        self.assertEqual(response.request.headers['Accept-Encoding'], 'gzip')
+        self.assertEqual(response.text, _BODY)
+
+        hit, = self.result.hits
+        self.assertEqual(hit.body_size, len(_BODY))
+        self.assertEqual(hit.wire_size, len(_gzip(_BODY)))
+        self.assertTrue(hit.wire_size < hit.body_size)
+
+        self._get(accept_encoding='identity')
+        self.assertEqual(self.result.bytes_received, len(_BODY) * 2)
+        self.assertEqual(self.result.wire_bytes_received,
+                         len(_BODY) + len(_gzip(_BODY)))
+
+    def test_zstd(self):
+        with mock.patch.dict(compression._DECOMPRESSORS,
+                             {'zstd': zlib.decompress}):
+            with mock.patch('loads.compression._available',
+                            lambda encoding: True):
+                response = self._get('/zstd', accept_encoding='zstd')
+        self.assertEqual(response.text, _BODY)
+        hit, = self.result.hits
+        self.assertEqual((hit.body_size, hit.wire_size),
+                         (len(_BODY), len(zlib.compress(_BODY))))
+
+        # without the module, the body is left as it is
+        with mock.patch('loads.compression._available',
+                        lambda encoding: False):
+            response = self._get('/zstd', accept_encoding='zstd')
+        self.assertEqual(response.content, zlib.compress(_BODY))
+
+    def test_all(self):
+        response = self._get(accept_encoding='all')
+        self.assertEqual(response.request.headers['Accept-Encoding'],
+                         ', '.join(compression.ENCODINGS))
+
+    def test_stream(self):
+        test = _Test('test_compression', test_result=self.result)
+        self.addCleanup(test.session.close)
+        test.session.get(self.url, stream=True).close()
+        hit, = self.result.hits
+        self.assertEqual((hit.body_size, hit.wire_size), (None, None))
+        self.assertEqual(self.result.bytes_received, 0)
+
+    def test_summarized(self):
+        hit = {'url': 'http://host', 'method': 'GET', 'status': 200,
+               'loads_status': [1, 1, 1, 1]}
+        counts = {'add_hit': [
+            dict(hit, elapsed=.1, body_size=10, wire_size=4),
+            dict(hit, elapsed=.2),
+            dict(hit, elapsed=.3, body_size=30, wire_size=8)]}
+        summarize_hits(counts)
+        summary, = counts['add_hits']
+        self.assertEqual(summary['body_size'], [10, None, 30])
+        self.assertEqual(summary['wire_size'], [4, None, 8])
+
+        hits = [message for field, message in
+                unbatch({'agent_id': 1, 'counts': counts})]
+        self.assertEqual([(message['elapsed'], message['body_size'])
+                          for message in hits],
+                         [(.1, 10), (.2, None), (.3, 30)])
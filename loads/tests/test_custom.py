@@ -0,0 +1,77 @@
+import unittest2
+
+from loads.case import TestCase
+from loads.custom import Gauge, Trend, parse_metric
+from loads.report import Report, render
+from loads.results import TestResult
+from loads.thresholds import Threshold, get_live_metric
+
+
+class _CartTestCase(TestCase):
+    def cart(self):
+        self.incr_counter('carts')
+        for items in (3, 1, 2):
+            self.set_gauge('items_in_cart', items)
+        for elapsed in range(1, 11):
+            self.add_trend('server-db', elapsed)
+
+
+class TestCustomMetrics(unittest2.TestCase):
+
+    def _run(self):
+        result = TestResult()
+        _CartTestCase('cart', test_result=result).run(
+            loads_status=(1, 1, 1, 1))
+        return result
+
+    def test_gauge(self):
+        gauge = Gauge()
+        self.assertEqual(gauge.get(), None)
+        for value in (3, 1, 2):
+            gauge.set(value)
+        self.assertEqual([gauge.get(stat) for stat in ('last', 'min', 'max')],
+                         [2, 1, 3])
+        self.assertEqual(gauge.count, 3)
+
+    def test_trend(self):
+        trend = Trend()
+        self.assertEqual(trend.get(), None)
+        for value in range(1, 11):
+            trend.add(value)
+        self.assertEqual(trend.get(), 5.5)
+        self.assertEqual([trend.get(stat) for stat in ('min', 'p50', 'p90',
+                                                        'max')],
+                         [1, 6, 9, 10])
+
+    def test_parse_metric(self):
+        self.assertEqual(parse_metric('p95'), None)
+        self.assertEqual(parse_metric('counter.carts'),
+                         ('counter', 'carts', 'total'))
+        self.assertEqual(parse_metric('gauge.items_in_cart'),
+                         ('gauge', 'items_in_cart', 'last'))
+        self.assertEqual(parse_metric('trend.server-db.p95'),
+                         ('trend', 'server-db', 'p95'))
+        self.assertRaises(ValueError, parse_metric, 'counter.carts.max')
+
+    def test_thresholds(self):
+        result = self._run()
+        for expression, value, passed in (
+                ('counter.carts > 0', 1, True),
+                ('gauge.items_in_cart.max <= 2', 3, False),
+                ('gauge.items_in_cart == 2', 2, True),
+                ('trend.server-db.p95 < 10', 10, False),
+                ('trend.server-db < 10', 5.5, True),
+                ('trend.unknown < 10', None, False)):
+            threshold = Threshold(expression)
+            self.assertEqual(threshold.evaluate(result), (value, passed))
+        self.assertEqual(get_live_metric({}, 'gauge.items_in_cart'), None)
+
+    def test_report(self):
+        records = [('set_gauge', {'name': 'items_in_cart', 'value': 4}),
+                   ('add_trend', {'name': 'server-db', 'value': .5})]
+        report = Report(records)
+        self.assertEqual(report.test_result.get_gauges()['items_in_cart'].max,
+                         4)
+        html = render(report, 'Run')
+        self.assertTrue('<h2>Custom metrics</h2>' in html)
+        self.assertTrue('<td>server-db</td>' in html)
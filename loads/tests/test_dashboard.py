@@ -0,0 +1,170 @@
+import json
+import socket
+import struct
+import urllib2
+
+import unittest2
+
+from loads.transport.dashboard import (LiveStats, DashboardServer,
+                                       websocket_accept, websocket_frame)
+
+
+def _hit(status=200, elapsed=.2, agent_id='1', scenario='test_es'):
+    return {'data_type': 'add_hit', 'status': status, 'elapsed': elapsed,
+            'agent_id': agent_id, 'url': 'http://a', 'method': 'GET',
+            'scenario': scenario}
+
+
+class _Clock(object):
+    now = 1000.
+
+    def __call__(self):
+        return self.now
+
+
+class TestLiveStats(unittest2.TestCase):
+
+    def setUp(self):
+        self.clock = _Clock()
+        self.stats = LiveStats(window=10, clock=self.clock)
+
+    def test_snapshot(self):
+        for elapsed in (.1, .2, .3):
+            self.stats.add(_hit(elapsed=elapsed))
+        self.stats.add(_hit(status=500, agent_id='2', scenario='test_search'))
+        self.stats.add({'data_type': 'startTest', 'agent_id': '1',
+                        'test': 'test_es (module.TestSite)'})
+
+        snapshot = self.stats.snapshot()
+        total = snapshot['total']
+        self.assertEqual(total['users'], 1)
+        self.assertEqual(total['rps'], .4)
+        self.assertEqual(total['error_rate'], 25.)
+        self.assertAlmostEqual(total['p50'], 200, 0)
+
+        self.assertEqual(sorted(snapshot['agents']), ['1', '2'])
+        agent = snapshot['agents']['1']
+        self.assertEqual(agent['total']['error_rate'], 0)
+        self.assertEqual(sorted(agent['scenarios']), ['test_es'])
+        self.assertEqual(snapshot['scenarios']['test_search']['error_rate'],
+                         100.)
+
+    def test_window(self):
+        self.stats.add(_hit())
+        self.clock.now += 5
+        self.stats.add(_hit())
+        self.assertEqual(self.stats.snapshot()['total']['rps'], .2)
+
+        self.clock.now += 6
+        self.assertEqual(self.stats.snapshot()['total']['rps'], .1)
+
+        self.clock.now += 10
+        snapshot = self.stats.snapshot()
+        self.assertEqual(snapshot['total']['p95'], None)
+        self.assertEqual(snapshot['agents'], {})
+
+    def test_batches(self):
+        self.stats.add({'data_type': 'batch', 'agent_id': '1',
+                        'counts': {'add_hit': [_hit(), _hit()]}})
+        self.assertEqual(self.stats.snapshot()['agents']['1']['total']['rps'],
+                         .2)
+
+
+    def test_queue(self):
+        self.assertEqual(self.stats.snapshot()['queue'], [])
+        queue = [{'run_id': 'run', 'position': 1, 'priority': 0}]
+        self.stats.set_queue(queue)
+        self.assertEqual(self.stats.snapshot()['queue'], queue)
+
+    def test_projects(self):
+        self.stats.add(_hit(), 'team-a')
+        self.stats.add(_hit(agent_id='2'), 'team-b')
+        self.stats.set_queue([{'run_id': 'run', 'project': 'team-b'}])
+
+        snapshot = self.stats.snapshot('team-a')
+        self.assertEqual(sorted(snapshot['agents']), ['1'])
+        self.assertEqual(snapshot['total']['rps'], .1)
+        self.assertEqual(snapshot['queue'], [])
+        self.assertEqual(len(self.stats.snapshot()['agents']), 2)
+
+    def test_agents(self):
+        self.stats.add(_hit())
+        self.stats.add(_hit(agent_id='2'))
+        snapshot = self.stats.snapshot(agents=['2'])
+        self.assertEqual(sorted(snapshot['agents']), ['2'])
+        self.assertEqual(snapshot['total']['rps'], .1)
+
+class TestWebSocket(unittest2.TestCase):
+
+    def test_accept(self):
+        # the example of the RFC 6455
+        self.assertEqual(websocket_accept('dGhlIHNhbXBsZSBub25jZQ=='),
+                         's3pPLMBiTxaQ9kYGzzhZRbK+xOo=')
+
+    def test_frame(self):
+        self.assertEqual(websocket_frame('hi'), '\x81\x02hi')
+        self.assertEqual(websocket_frame('x' * 200)[:4], '\x81\x7e\x00\xc8')
+        self.assertEqual(websocket_frame('x' * 70000)[:2], '\x81\x7f')
+
+
+class TestDashboardServer(unittest2.TestCase):
+
+    def setUp(self):
+        self.stats = LiveStats()
+        self.stats.add(_hit())
+        self.server = DashboardServer(self.stats, '127.0.0.1:0',
+                                      interval=.1)
+        self.server.start()
+        self.url = 'http://%s' % self.server.address
+
+    def tearDown(self):
+        self.server.stop()
+
+    def test_pages(self):
+        self.assertTrue('WebSocket' in urllib2.urlopen(self.url).read())
+        stats = json.loads(urllib2.urlopen(self.url + '/stats').read())
+        self.assertEqual(sorted(stats['agents']), ['1'])
+        self.assertRaises(urllib2.HTTPError, urllib2.urlopen,
+                          self.url + '/other')
+
+    def test_keys(self):
+        self.server.server.keys = {'s3cr3t': 'team-a', 'admin': '*'}
+        self.stats.add(_hit(agent_id='2'), 'team-b')
+        self.assertRaises(urllib2.HTTPError, urllib2.urlopen,
+                          self.url + '/stats?key=wrong')
+
+        stats = json.loads(urllib2.urlopen(self.url +
+                                           '/stats?key=s3cr3t').read())
+        self.assertEqual(stats['agents'], {})
+        request = urllib2.Request(self.url + '/stats',
+                                  headers={'Authorization': 'Bearer admin'})
+        stats = json.loads(urllib2.urlopen(request).read())
+        self.assertEqual(sorted(stats['agents']), ['1', '2'])
+
+    def test_websocket(self):
+        host, port = self.server.address.split(':')
+        sock = socket.create_connection((host, int(port)))
+        try:
+            sock.sendall('GET /ws HTTP/1.1\r\nHost: %s\r\n'
+                         'Upgrade: websocket\r\nConnection: Upgrade\r\n'
+                         'Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n'
+                         'Sec-WebSocket-Version: 13\r\n\r\n' %
+                         self.server.address)
+            stream = sock.makefile()
+            self.assertTrue(stream.readline().startswith('HTTP/1.0 101'))
+            headers = []
+            line = stream.readline()
+            while line.strip():
+                headers.append(line.strip())
+                line = stream.readline()
+            self.assertTrue('Sec-WebSocket-Accept: '
+                            's3pPLMBiTxaQ9kYGzzhZRbK+xOo=' in headers)
+
+            opcode, length = struct.unpack('!BB', stream.read(2))
+            self.assertEqual(opcode, 0x81)
+            if length == 126:
+                length, = struct.unpack('!H', stream.read(2))
+            snapshot = json.loads(stream.read(length))
+            self.assertEqual(snapshot['total']['rps'], .1)
+        finally:
+            sock.close()
@@ -10,16 +10,20 @@ try:
         NO_REDIS_RUNNING = True
 except ImportError:
     NO_REDIS_RUNNING = NO_REDIS_LIB = True
+try:
+    import psycopg2  # NOQA
+    NO_POSTGRES_LIB = False
+except ImportError:
+    NO_POSTGRES_LIB = True
 
 
 class TestDB(unittest2.TestCase):
 
     def test_get_backends(self):
-        backends = get_backends()
-        if NO_REDIS_LIB:
-            self.assertEqual(len(backends), 1)
-        else:
-            self.assertEqual(len(backends), 2)
+        backends = [name for name, options in get_backends()]
+        self.assertEqual(backends[:2], ['python', 'sqlite'])
+        self.assertEqual('redis' in backends, not NO_REDIS_LIB)
+        self.assertEqual('postgres' in backends, not NO_POSTGRES_LIB)
 
     def test_get_database(self):
         db = get_database('python')
@@ -0,0 +1,149 @@
+import socket
+import struct
+import threading
+
+import unittest2
+
+from loads.case import TestCase
+from loads.engines.dns import (DNSError, build_query, get_type,
+                               parse_response)
+from loads.results import TestResult
+
+
+def _answer(query, truncated=False):
+    """Answers 10.0.0.1 for the A queries of known.test, and NXDOMAIN for
+    the other ones -- a TXT record for txt.test."""
+    id, = struct.unpack('!H', query[:2])
+    question = query[12:]
+    name = question[:question.index('\0') + 1]
+    rdtype, = struct.unpack('!H', question[len(name):len(name) + 2])
+    question = question[:len(name) + 4]
+    flags = 0x8180 | (truncated and 0x0200 or 0)
+    records = ''
+    if name == '\x05known\x04test\0' and rdtype == 1:
+        # a pointer to the name of the question
+        records = ('\xc0\x0c' + struct.pack('!HHIH', 1, 1, 300, 4) +
+                   socket.inet_aton('10.0.0.1'))
+    elif name == '\x03txt\x04test\0':
+        rdata = '\x05hello\x06 world'
+        records = ('\xc0\x0c' + struct.pack('!HHIH', 16, 1, 60, len(rdata)) +
+                   rdata)
+    else:
+        flags |= 3
+    count = records and 1 or 0
+    return struct.pack('!HHHHHH', id, flags, 1, count, 0, 0) + question + \
+        records
+
+
+def _serve_udp(sock, truncate):
+    while True:
+        try:
+            query, address = sock.recvfrom(512)
+        except socket.error:
+            return
+        sock.sendto(_answer(query, truncate), address)
+
+
+def _serve_tcp(sock):
+    while True:
+        try:
+            client, _ = sock.accept()
+        except socket.error:
+            return
+        while True:
+            size = client.recv(2)
+            if not size:
+                break
+            query = client.recv(struct.unpack('!H', size)[0])
+            answer = _answer(query)
+            client.sendall(struct.pack('!H', len(answer)) + answer)
+        client.close()
+
+
+class _Test(TestCase):
+
+    def resolve(self):
+        pass
+
+
+def _test(result):
+    test = _Test('resolve', test_result=result)
+    test._loads_status = 1, 1, 1, 0
+    return test
+
+
+class TestDNS(unittest2.TestCase):
+
+    def _server(self, truncate=False):
+        udp = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+        udp.bind(('127.0.0.1', 0))
+        port = udp.getsockname()[1]
+        tcp = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        tcp.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+        tcp.bind(('127.0.0.1', port))
+        tcp.listen(5)
+        for target, args in ((_serve_udp, (udp, truncate)),
+                             (_serve_tcp, (tcp,))):
+            thread = threading.Thread(target=target, args=args)
+            thread.daemon = True
+            thread.start()
+        self.addCleanup(udp.close)
+        self.addCleanup(tcp.close)
+        return port
+
+    def test_messages(self):
+        query, id = build_query('known.test.', 'A', id=7)
+        self.assertEqual(query[:2], '\x00\x07')
+        self.assertEqual(get_type('aaaa'), 28)
+        self.assertEqual(get_type('TYPE65'), 65)
+        self.assertRaises(ValueError, get_type, 'NOPE')
+
+        response = parse_response(_answer(query))
+        self.assertEqual((response.id, response.rcode), (7, 'NOERROR'))
+        self.assertEqual(response.answers,
+                         [('known.test.', 'A', 300, '10.0.0.1')])
+        query, id = build_query('txt.test', 'TXT')
+        self.assertEqual(parse_response(_answer(query)).values,
+                         ['hello world'])
+        query, id = build_query('unknown.test', 'A')
+        self.assertEqual(parse_response(_answer(query)).rcode, 'NXDOMAIN')
+
+    def test_query(self):
+        port = self._server()
+        result = TestResult()
+        test = _test(result)
+        for transport in ('udp', 'tcp'):
+            client = test.create_dns('127.0.0.1', port, transport=transport)
+            response = client.query('known.test', expect='10.0.0.1')
+            self.assertEqual(response.values, ['10.0.0.1'])
+            self.assertRaises(DNSError, client.query, 'known.test',
+                              expect='10.0.0.2')
+            client.query('unknown.test', rcode='NXDOMAIN')
+            self.assertRaises(DNSError, client.query, 'unknown.test')
+        test.tearDown()
+
+        self.assertEqual([hit.status for hit in result.hits],
+                         ['OK', 'BAD_ANSWER', 'OK', 'NXDOMAIN'] * 2)
+        self.assertEqual(result.hits[0].url,
+                         'dns://127.0.0.1:%d/known.test/A' % port)
+        self.assertEqual(result.hits[0].method, 'DNS')
+
+    def test_truncated(self):
+        port = self._server(truncate=True)
+        result = TestResult()
+        test = _test(result)
+        client = test.create_dns('127.0.0.1', port)
+        self.assertEqual(client.query('known.test').values, ['10.0.0.1'])
+        test.tearDown()
+        self.assertEqual(result.get_counters()['dns-truncated'], 1)
+
+    def test_run(self):
+        port = self._server()
+        result = TestResult()
+        test = _test(result)
+        client = test.create_dns('127.0.0.1', port)
+        stats = client.run([('known.test', 'A'), ('unknown.test', 'A')],
+                           count=10, qps=1000)
+        test.tearDown()
+        self.assertEqual(stats, {'sent': 10, 'failed': {'NXDOMAIN': 5}})
+        self.assertEqual(len(result.hits), 10)
@@ -0,0 +1,152 @@
+import socket
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+import mock
+
+from loads.case import TestCase
+from loads.dualstack import create_connection, get_addresses, get_family
+from loads.engines.tcp import TCPClient
+from loads.results import TestResult
+from loads.util import dns_resolve
+
+
+def _listen(family, host):
+    sock = socket.socket(family, socket.SOCK_STREAM)
+    sock.bind((host, 0))
+    sock.listen(5)
+    return sock
+
+
+def _getaddrinfo(*addresses):
+    return [(family, socket.SOCK_STREAM, 6, '', sockaddr)
+            for family, sockaddr in addresses]
+
+
+class _HTTPServer6(HTTPServer):
+    address_family = socket.AF_INET6
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_family(self):
+        pass
+
+
+class TestDualStack(unittest2.TestCase):
+
+    def test_addresses(self):
+        v6 = [(socket.AF_INET6, ('fd00::%d' % index, 80, 0, 0))
+              for index in (1, 2)]
+        v4 = [(socket.AF_INET, ('10.0.0.%d' % index, 80))
+              for index in (1, 2, 3)]
+        with mock.patch('socket.getaddrinfo') as getaddrinfo:
+            getaddrinfo.return_value = _getaddrinfo(*(v4 + v6))
+            self.assertEqual(get_addresses('api', 80), v4 + v6)
+            self.assertEqual(get_addresses('api', 80, 'happy-eyeballs'),
+                             [v6[0], v4[0], v6[1], v4[1], v4[2]])
+            get_addresses('api', 80, 'ipv6')
+            self.assertEqual(getaddrinfo.call_args[0][2], socket.AF_INET6)
+
+            getaddrinfo.return_value = []
+            self.assertRaises(socket.gaierror, get_addresses, 'api', 80)
+
+    def test_families(self):
+        for family, host, name in ((socket.AF_INET, '127.0.0.1', 'ipv4'),
+                                   (socket.AF_INET6, '::1', 'ipv6')):
+            server = _listen(family, host)
+            self.addCleanup(server.close)
+            sock = create_connection((host, server.getsockname()[1]), name)
+            self.addCleanup(sock.close)
+            self.assertEqual(get_family(sock), name)
+
+    def test_happy_eyeballs(self):
+        server = _listen(socket.AF_INET, '127.0.0.1')
+        self.addCleanup(server.close)
+        port = server.getsockname()[1]
+        # nothing listens to the IPv6 address: IPv4 wins
+        closed = _listen(socket.AF_INET6, '::1')
+        closed_port = closed.getsockname()[1]
+        closed.close()
+
+        with mock.patch('socket.getaddrinfo') as getaddrinfo:
+            getaddrinfo.return_value = _getaddrinfo(
+                (socket.AF_INET6, ('::1', closed_port, 0, 0)),
+                (socket.AF_INET, ('127.0.0.1', port)))
+            sock = create_connection(('api', port), 'happy-eyeballs')
+        self.addCleanup(sock.close)
+        self.assertEqual(get_family(sock), 'ipv4')
+
+        with mock.patch('socket.getaddrinfo') as getaddrinfo:
+            getaddrinfo.return_value = _getaddrinfo(
+                (socket.AF_INET6, ('::1', closed_port, 0, 0)))
+            self.assertRaises(socket.error, create_connection,
+                              ('api', closed_port), 'happy-eyeballs')
+
+    def test_dns_resolve(self):
+        url, original, resolved = dns_resolve('http://[::1]:8080/path',
+                                              'ipv6')
+        self.assertEqual((url, original, resolved),
+                         ('http://[::1]:8080/path', '[::1]', '::1'))
+        self.assertRaises(socket.gaierror, dns_resolve, 'http://[::1]/',
+                          'ipv4')
+
+    def test_tcp(self):
+        server = _listen(socket.AF_INET6, '::1')
+        self.addCleanup(server.close)
+        client = TCPClient('::1', server.getsockname()[1], ip_family='ipv6')
+        self.addCleanup(client.close)
+        client.connect()
+        self.assertEqual(client.family, 'ipv6')
+
+
+class TestSessionFamily(unittest2.TestCase):
+
+    def test_session(self):
+        server = _HTTPServer6(('::1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        url = 'http://[::1]:%d/' % server.server_address[1]
+
+        for family in ('ipv6', 'happy-eyeballs'):
+            result = TestResult(args={'ip_family': family})
+            test = _Test('test_family', test_result=result,
+                         config={'ip_family': family})
+            self.addCleanup(test.session.close)
+            test.session.get(url)
+            hit, = result.hits
+            self.assertEqual(hit.family, 'ipv6')
+            metrics = result.get_tag_metrics()['address family']
+            self.assertEqual(metrics['ipv6']['hits'], 1)
+
+    def test_default(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+
+        # the family is only compared with --ip-family
+        result = TestResult()
+        test = _Test('test_family', test_result=result)
+        self.addCleanup(test.session.close)
+        test.session.get('http://127.0.0.1:%d/' % server.server_address[1])
+        self.assertEqual(result.hits[0].family, 'ipv4')
+        self.assertEqual(result.get_tag_metrics(), {})
@@ -0,0 +1,124 @@
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.endpoints import Endpoints, get_endpoints, parse_pattern
+from loads.results import TestResult
+from loads.results.zmqrelay import summarize_hits
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        if self.path.startswith('/old/'):
+            self.send_response(302)
+            self.send_header('Location', '/users/' + self.path[5:])
+            self.send_header('Content-Length', '0')
+            self.end_headers()
+            return
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_endpoint(self):
+        pass
+
+
+class TestEndpoints(unittest2.TestCase):
+
+    def test_parse(self):
+        regex, label = parse_pattern('/search\\?q=.*=search')
+        self.assertEqual(label, 'search')
+        self.assertTrue(regex.search('http://api/search?q=loads'))
+        self.assertRaises(ValueError, parse_pattern, '/search')
+        self.assertRaises(ValueError, parse_pattern, '/search= ')
+
+    def test_endpoints(self):
+        endpoints = Endpoints(['/static/=assets'])
+        self.assertEqual(endpoints.get_endpoint('http://api/users/123?x=1'),
+                         'http://api/users/{id}')
+        self.assertEqual(endpoints.get_endpoint('http://api/static/a.css'),
+                         'assets')
+        self.assertEqual(endpoints.get_endpoint('http://api/users/1',
+                                                'user'), 'user')
+
+        endpoints = get_endpoints({'no_url_grouping': True})
+        self.assertEqual(endpoints.get_endpoint('http://api/users/123'),
+                         'http://api/users/123')
+
+    def test_aggregation(self):
+        result = TestResult()
+        for url in ('http://api/users/123', 'http://api/users/456'):
+            result.add_hit(url=url, method='GET', status=200, started=None,
+                           elapsed=0.2, loads_status=(1, 1, 1, 1),
+                           endpoint='http://api/users/{id}')
+        result.add_hit(url='http://api/', method='GET', status=500,
+                       started=None, elapsed=0.4, loads_status=(1, 1, 1, 1))
+        self.assertEqual(result.urls,
+                         set(['http://api/users/{id}', 'http://api/']))
+        self.assertEqual(result.get_histogram('http://api/users/{id}')
+                         .total_count, 2)
+        self.assertEqual(result.hits_success_rate('http://api/users/{id}'),
+                         1)
+
+    def test_summaries(self):
+        counts = {'add_hit': [
+            {'url': url, 'method': 'GET', 'status': 200, 'elapsed': 0.1,
+             'endpoint': 'http://api/users/{id}'}
+            for url in ('http://api/users/1', 'http://api/users/2')]}
+        summarize_hits(counts)
+        summary, = counts['add_hits']
+        self.assertEqual(summary['elapsed'], [0.1, 0.1])
+
+
+class TestSessionEndpoints(unittest2.TestCase):
+
+    def setUp(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        self.url = 'http://127.0.0.1:%d' % server.server_address[1]
+
+    def _get_session(self, result, **config):
+        test = _Test('test_endpoint', test_result=result, config=config)
+        self.addCleanup(test.session.close)
+        return test.session
+
+    def test_grouping(self):
+        result = TestResult()
+        session = self._get_session(result)
+        session.get(self.url + '/users/123')
+        session.get(self.url + '/users/456')
+        self.assertEqual(result.urls, set([self.url + '/users/{id}']))
+
+        result = TestResult()
+        session = self._get_session(result, no_url_grouping=True)
+        session.get(self.url + '/users/123')
+        session.get(self.url + '/users/456')
+        self.assertEqual(len(result.urls), 2)
+
+    def test_labels(self):
+        result = TestResult()
+        session = self._get_session(result, url_pattern=['/users/=users'])
+        session.get(self.url + '/users/123')
+        session.get(self.url + '/', label='home')
+        self.assertEqual(result.urls, set(['users', 'home']))
+
+        # the redirects do not keep the label of the request
+        result = TestResult()
+        session = self._get_session(result)
+        session.get(self.url + '/old/123', label='old user')
+        self.assertEqual(result.urls,
+                         set(['old user', self.url + '/users/{id}']))
@@ -0,0 +1,61 @@
+import unittest2
+
+from loads import Engine, register_engine
+from loads.case import TestCase
+from loads.engines import get_engine
+from loads.results import TestResult
+
+
+class _EchoEngine(Engine):
+    name = 'echo'
+    options = {'prefix': ('', 'Prefix of the responses', str)}
+
+    def setup(self):
+        self.sent = 0
+
+    def issue_request(self, data):
+        if data is None:
+            raise ValueError(data)
+        self.sent += 1
+        return self.params['prefix'] + data
+
+    def get_url(self, data):
+        return 'echo://%s' % data
+
+    def metrics(self):
+        return {'echo-sent': self.sent}
+
+
+register_engine(_EchoEngine)
+
+
+class _EchoTestCase(TestCase):
+
+    def test_echo(self):
+        engine = self.create_engine('echo', prefix='> ')
+        self.assertEqual(engine.request('one'), '> one')
+        self.assertEqual(engine.request('two'), '> two')
+        self.assertRaises(ValueError, engine.request, None)
+
+
+class TestEngines(unittest2.TestCase):
+
+    def test_registry(self):
+        self.assertEqual(get_engine('echo'), _EchoEngine)
+        self.assertRaises(NotImplementedError, get_engine, 'xxx')
+
+    def test_engine_needs_a_name(self):
+        self.assertRaises(ValueError, Engine)
+
+    def test_engine(self):
+        result = TestResult()
+        case = _EchoTestCase('test_echo', test_result=result)
+        case(loads_status=(1, 1, 1, 1))
+
+        self.assertEqual(result.nb_success, 1)
+        self.assertEqual(len(result.hits), 3)
+        self.assertEqual(result.hits[0].url, 'echo://one')
+        self.assertEqual(result.hits[0].method, 'ECHO')
+        self.assertEqual(result.hits[2].status, 'ValueError')
+        self.assertEqual(result.hits_success_rate(), 2. / 3)
+        self.assertEqual(result.get_counter('echo-sent'), 2)
@@ -0,0 +1,125 @@
+import socket
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+from requests.exceptions import (ConnectionError, ConnectTimeout, HTTPError,
+                                 ReadTimeout, SSLError)
+
+from loads.case import TestCase
+from loads.errors import (CATEGORIES, get_category, get_error_groups,
+                          get_status_category, snippet)
+from loads.extractors import ExtractionError
+from loads.graphql import GraphQLError
+from loads.results import TestResult
+from loads.results.base import Hit
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        body = '{"error": "the database\n is down"}'
+        self.send_response(503)
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_errors(self):
+        pass
+
+
+def _hit(status, error_body=None):
+    return Hit(url='http://api/users/1', method='GET', status=status,
+               started=None, elapsed=0.1, loads_status=None,
+               endpoint='http://api/users/{id}', error_body=error_body)
+
+
+class TestErrors(unittest2.TestCase):
+
+    def test_categories(self):
+        for exc_class, exc, category in (
+                (socket.gaierror, '[Errno -2] Name or service not known',
+                 'dns'),
+                (ConnectTimeout, 'Connection to api timed out. '
+                 '(connect timeout=1)', 'connect-timeout'),
+                (ConnectionError, '<NewConnectionError: [Errno 111] '
+                 'Connection refused>', 'connect-refused'),
+                (SSLError, 'certificate verify failed', 'tls'),
+                (ConnectionError, "('Connection aborted.', error(104, "
+                 "'Connection reset by peer'))", 'reset'),
+                (ReadTimeout, 'Read timed out.', 'read-timeout'),
+                (HTTPError, '404 Client Error: Not Found', '4xx'),
+                (HTTPError, '502 Server Error: Bad Gateway', '5xx'),
+                (AssertionError, '500 != 200', 'assertion-failed'),
+                (ExtractionError, 'No token', 'extraction-failed'),
+                (GraphQLError, 'Out of stock', 'graphql'),
+                (ValueError, 'Nope', 'other')):
+            self.assertEqual(get_category(exc_class, exc), category)
+            self.assertIn(category, CATEGORIES)
+
+        # the agents send the names of the classes
+        self.assertEqual(get_category(
+            "<class 'loads.extractors.ExtractionError'>", 'No token'),
+            'extraction-failed')
+        self.assertEqual(get_category("<type 'exceptions.AssertionError'>",
+                                      ''), 'assertion-failed')
+
+        self.assertEqual(get_status_category(200), None)
+        self.assertEqual(get_status_category(404), '4xx')
+        self.assertEqual(get_status_category('UNAVAILABLE'), None)
+        self.assertEqual(get_status_category('GRAPHQL_ERROR'), 'graphql')
+
+    def test_snippet(self):
+        self.assertEqual(snippet('a\n  b'), 'a b')
+        self.assertEqual(len(snippet('x' * 500)), 200)
+        self.assertEqual(snippet(u'caf\xe9'), 'caf\xc3\xa9')
+
+    def test_groups(self):
+        exc_infos = [(AssertionError, AssertionError('500 != 200'), None)] * 5
+        exc_infos.append((ExtractionError, 'No token', None))
+        hits = [_hit(200), _hit(500, 'oops'), _hit(503), _hit(404)]
+        groups = get_error_groups(exc_infos, hits)
+        self.assertEqual(groups['assertion-failed'],
+                         {'count': 5,
+                          'examples': ['AssertionError: 500 != 200']})
+        self.assertEqual(groups['extraction-failed']['count'], 1)
+        self.assertEqual(groups['5xx'], {
+            'count': 2,
+            'examples': ['GET http://api/users/{id}: 500 oops',
+                         'GET http://api/users/{id}: 503']})
+        self.assertEqual(groups['4xx']['count'], 1)
+        self.assertNotIn('dns', groups)
+
+
+class TestResultErrors(unittest2.TestCase):
+
+    def test_test_result(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+
+        result = TestResult()
+        test = _Test('test_errors', test_result=result)
+        self.addCleanup(test.session.close)
+        test.session.get('http://127.0.0.1:%d/' % server.server_address[1])
+        hit, = result.hits
+        self.assertEqual(hit.error_body,
+                         '{"error": "the database is down"}')
+
+        loads_status = 1, 1, 1, 1
+        result.startTest('test_errors', loads_status)
+        result.addFailure('test_errors', (AssertionError,
+                                          AssertionError('503 != 200'),
+                                          None), loads_status)
+        groups = result.get_error_groups()
+        self.assertEqual(sorted(groups), ['5xx', 'assertion-failed'])
+        self.assertEqual(groups['5xx']['count'], 1)
@@ -0,0 +1,180 @@
+import base64
+import hashlib
+import socket
+import SocketServer
+import threading
+
+import mock
+import unittest2
+
+from loads.case import TestCase
+from loads.engines import get_engine
+from loads.engines.evloop import (EventLoopEngine, _PollPoller,
+                                  _SelectPoller, _WS_GUID, _WS_PING,
+                                  _WS_PONG, parse_websocket_frame,
+                                  websocket_frame)
+from loads.results import TestResult
+
+
+class _Server(SocketServer.ThreadingTCPServer):
+    daemon_threads = True
+    allow_reuse_address = True
+    request_queue_size = 128
+
+    def handle_error(self, request, client_address):
+        # the connections closed with unread messages are reset
+        pass
+
+
+class _EchoHandler(SocketServer.BaseRequestHandler):
+
+    def handle(self):
+        for data in iter(lambda: self.request.recv(4096), ''):
+            self.request.sendall(data)
+
+
+class _ClosingHandler(SocketServer.BaseRequestHandler):
+
+    def handle(self):
+        self.request.close()
+
+
+def _server_frame(payload, opcode=0x1):
+    # the frames of the servers are not masked
+    return chr(0x80 | opcode) + chr(len(payload)) + payload
+
+
+class _WebSocketHandler(SocketServer.StreamRequestHandler):
+
+    def handle(self):
+        key = None
+        for line in iter(self.rfile.readline, '\r\n'):
+            if line.lower().startswith('sec-websocket-key:'):
+                key = line.split(':', 1)[1].strip()
+        accept = base64.b64encode(hashlib.sha1(key + _WS_GUID).digest())
+        self.wfile.write('HTTP/1.1 101 Switching Protocols\r\n'
+                         'Upgrade: websocket\r\nConnection: Upgrade\r\n'
+                         'Sec-WebSocket-Accept: %s\r\n\r\n' % accept)
+        self.wfile.write(_server_frame('hey', _WS_PING))
+        data = ''
+        while True:
+            chunk = self.request.recv(1024)
+            if not chunk:
+                return
+            data += chunk
+            frame = parse_websocket_frame(data)
+            while frame is not None:
+                opcode, payload, data = frame
+                if opcode == _WS_PONG:
+                    self.server.pongs.append(payload)
+                else:
+                    self.wfile.write(_server_frame(payload.upper()))
+                frame = parse_websocket_frame(data)
+
+
+def _serve(test, handler):
+    server = _Server(('127.0.0.1', 0), handler)
+    server.pongs = []
+    thread = threading.Thread(target=server.serve_forever)
+    thread.daemon = True
+    thread.start()
+    test.addCleanup(server.server_close)
+    test.addCleanup(server.shutdown)
+    return server
+
+
+class _Test(TestCase):
+
+    def test_evloop(self):
+        pass
+
+
+class TestEventLoop(unittest2.TestCase):
+
+    def _engine(self, result=None, **options):
+        options.setdefault('timeout', 5)
+        test = _Test('test_evloop', test_result=result)
+        test._loads_status = (1, 1, 1, 1)
+        engine = test.create_engine('evloop', **options)
+        self.addCleanup(engine.close)
+        return engine
+
+    def test_registry(self):
+        self.assertEqual(get_engine('evloop'), EventLoopEngine)
+
+    def test_frames(self):
+        frame = websocket_frame('x' * 300)
+        self.assertEqual(ord(frame[1]) & 0x7f, 126)
+        self.assertEqual(parse_websocket_frame(frame), (1, 'x' * 300, ''))
+        self.assertEqual(parse_websocket_frame(frame[:100]), None)
+        frame = websocket_frame('pong', _WS_PONG)
+        self.assertEqual(parse_websocket_frame(frame + 'rest'),
+                         (_WS_PONG, 'pong', 'rest'))
+
+    def test_pollers(self):
+        server = _serve(self, _EchoHandler)
+        port = server.server_address[1]
+        for poller in (None, _PollPoller, _SelectPoller):
+            patch = mock.patch('loads.engines.evloop.get_poller', poller)
+            if poller is not None:
+                patch.start()
+            try:
+                engine = self._engine(port=port, connections=50,
+                                      framing='delimiter')
+            finally:
+                if poller is not None:
+                    patch.stop()
+            self.assertEqual(engine.opened, 50)
+            self.assertEqual(engine.request('ping'), 'ping')
+            self.assertEqual(engine.request('pong'), 'pong')
+            self.assertEqual(engine.broadcast('all'), 50)
+            engine.close()
+
+    def test_hits(self):
+        server = _serve(self, _EchoHandler)
+        result = TestResult()
+        engine = self._engine(result, port=server.server_address[1],
+                              connections=3, framing='length',
+                              length_size=2)
+        self.assertEqual(engine.request('x' * 1000), 'x' * 1000)
+        engine.close()
+
+        self.assertEqual(len(result.hits), 1)
+        self.assertEqual(result.hits[0].method, 'EVLOOP')
+        self.assertEqual(result.hits[0].url,
+                         'tcp://localhost:%d' % server.server_address[1])
+        self.assertEqual(result.opened_sockets, 3)
+        self.assertEqual(result.closed_sockets, 3)
+        self.assertEqual(result.socket_data_received, 1000)
+
+    def test_failures(self):
+        sock = socket.socket()
+        sock.bind(('127.0.0.1', 0))
+        port = sock.getsockname()[1]
+        sock.close()
+
+        engine = self._engine(host='127.0.0.1', port=port, connections=5)
+        self.assertEqual(engine.metrics(), {'evloop-opened': 0,
+                                            'evloop-failed': 5,
+                                            'evloop-closed': 0})
+        self.assertRaises(socket.error, engine.request, 'ping')
+        self.assertRaises(ValueError, self._engine, port=port,
+                          framing='xml')
+
+    def test_closed(self):
+        server = _serve(self, _ClosingHandler)
+        engine = self._engine(port=server.server_address[1], connections=10)
+        engine.hold(5)
+        self.assertEqual(engine.closed + engine.failed, 10)
+        self.assertRaises(socket.error, engine.request, 'ping')
+
+    def test_websocket(self):
+        server = _serve(self, _WebSocketHandler)
+        engine = self._engine(port=server.server_address[1], connections=4,
+                              websocket='/chat')
+        self.assertEqual(engine.opened, 4)
+        self.assertEqual(engine.request('hello'), 'HELLO')
+        engine.hold(.2)
+        self.assertEqual(server.pongs, ['hey'] * 4)
+        self.assertEqual(engine.get_url(), 'ws://localhost:%d/chat' %
+                         server.server_address[1])
@@ -216,3 +216,14 @@ class TestExternalRunner(TestCase):
                           ["LOADS_AGENT_ID", "LOADS_CURRENT_USER",
                            "LOADS_DURATION", "LOADS_RUN_ID",
                            "LOADS_TOTAL_USERS", "LOADS_ZMQ_RECEIVER"])
+
+    @mock.patch('loads.runners.external.subprocess.Popen',
+                lambda *args, **kwargs: FakeProcess(options=(args, kwargs)))
+    def test_spawn_external_runner_with_http_engine(self):
+        runner = ExternalRunner({'test_runner': 'foobar', 'hits': [1],
+                                 'users': [1], 'fqn': 'baz',
+                                 'http_engine': 'fasthttp'})
+        runner.spawn_external_runner(1)
+
+        args, kwargs = runner._processes[0].options
+        self.assertEquals(kwargs['env']['LOADS_HTTP_ENGINE'], 'fasthttp')
@@ -0,0 +1,64 @@
+import unittest2
+import mock
+
+from loads.case import TestCase
+from loads.extractors import (ExtractionError, json_path, regex, header,
+                              parse_json_path)
+
+
+class _Response(object):
+    def __init__(self, text='', headers=None):
+        self.text = text
+        self.headers = headers or {}
+
+
+class _ExtractTestCase(TestCase):
+    def test_extract(self):
+        pass
+
+
+class TestExtractors(unittest2.TestCase):
+
+    def test_parse_json_path(self):
+        self.assertEqual(parse_json_path('$'), [])
+        self.assertEqual(parse_json_path("$.items[0]['first name'].id"),
+                         ['items', 0, 'first name', 'id'])
+        self.assertRaises(ValueError, parse_json_path, 'items')
+        self.assertRaises(ValueError, parse_json_path, '$.items[x]')
+
+    def test_json_path(self):
+        resp = _Response('{"token": "xyz", "items": [{"id": 1}]}')
+        self.assertEqual(json_path(resp, '$.token'), 'xyz')
+        self.assertEqual(json_path(resp, '$.items[0].id'), 1)
+        self.assertRaises(ExtractionError, json_path, resp, '$.items[1]')
+        self.assertRaises(ExtractionError, json_path, resp, '$.token.id')
+        self.assertRaises(ExtractionError, json_path, _Response('<html>'),
+                          '$.token')
+
+    def test_regex(self):
+        resp = _Response('<input name="csrf" value="abc123">')
+        self.assertEqual(regex(resp, r'value="(\w+)"'), 'abc123')
+        self.assertEqual(regex(resp, r'abc\d+'), 'abc123')
+        self.assertRaises(ExtractionError, regex, resp, r'token=(\w+)')
+
+    def test_header(self):
+        resp = _Response(headers={'X-Token': 'xyz'})
+        self.assertEqual(header(resp, 'X-Token'), 'xyz')
+        self.assertRaises(ExtractionError, header, resp, 'Location')
+
+    def test_extract_into_state(self):
+        results = mock.Mock()
+        test = _ExtractTestCase('test_extract', test_result=results)
+        resp = _Response('{"token": "xyz"}', {'Location': '/home'})
+
+        self.assertEqual(test.extract(resp, 'token', json='$.token'), 'xyz')
+        self.assertEqual(test.state['token'], 'xyz')
+        test.extract(resp, 'next', header='Location')
+        self.assertEqual(test.state['next'], '/home')
+        self.assertFalse(results.incr_counter.called)
+
+        self.assertRaises(ExtractionError, test.extract, resp, 'id',
+                          regex=r'id=(\d+)')
+        self.assertNotIn('id', test.state)
+        args = results.incr_counter.call_args[0]
+        self.assertEqual(args[2], 'extraction-errors')
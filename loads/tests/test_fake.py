@@ -0,0 +1,56 @@
+import re
+import sys
+
+import mock
+import unittest2
+
+from loads.fake import Faker, luhn_digit
+from loads.templates import TemplateError, render
+
+
+def _luhn_valid(number):
+    return luhn_digit(number[:-1]) == number[-1]
+
+
+class TestFake(unittest2.TestCase):
+
+    def test_luhn(self):
+        self.assertEqual(luhn_digit('7992739871'), '3')
+        faker = Faker()
+        for index in range(50):
+            number = faker.credit_card()
+            self.assertEqual(len(number), 16)
+            self.assertTrue(_luhn_valid(number), number)
+
+    def test_unique(self):
+        faker = Faker(seed=1)
+        emails = set([faker.email() for index in range(1000)])
+        self.assertEqual(len(emails), 1000)
+        self.assertNotEqual(Faker(seed=1).email(), Faker(seed=1).email())
+
+    def test_seed(self):
+        first, second = Faker(seed=42), Faker(seed=42)
+        for name in ('name', 'ipv4', 'ipv6', 'phone', 'uuid', 'sentence'):
+            self.assertEqual(getattr(first, name)(), getattr(second, name)())
+
+    def test_get(self):
+        faker = Faker()
+        self.assertEqual(len(faker.get('IPv6').split(':')), 8)
+        self.assertTrue(_luhn_valid(faker.get('CreditCard')))
+        self.assertTrue(re.match(r'^\w+ \w+$', faker.get('Name')))
+        self.assertTrue(re.match(r'^\d{5}$', faker.get('zip_code')))
+
+        with mock.patch.dict(sys.modules, {'faker': None}):
+            self.assertRaises(ValueError, Faker().get, 'LicensePlate')
+
+    def test_external(self):
+        external = mock.Mock()
+        external.Faker.return_value.license_plate.return_value = 'AB-123'
+        with mock.patch.dict(sys.modules, {'faker': external}):
+            self.assertEqual(Faker().get('LicensePlate'), 'AB-123')
+
+    def test_templates(self):
+        self.assertTrue(re.match(r'^To: [\w.]+@example\.(com|org|net)$',
+                                 render('To: {{fake.Email}}')))
+        with mock.patch.dict(sys.modules, {'faker': None}):
+            self.assertRaises(TemplateError, render, '{{fake.Unknown}}')
@@ -0,0 +1,128 @@
+import os
+import shutil
+import tempfile
+
+import unittest2
+
+from loads.case import TestCase
+from loads import feeders
+from loads.feeders import (Feeder, FeederExhausted, read_rows, get_feeder,
+                           get_positions, pack_feeder, unpack_feeder,
+                           split_feeder)
+
+
+class _FeedTestCase(TestCase):
+    def test_feed(self):
+        pass
+
+
+class TestFeeders(unittest2.TestCase):
+
+    def setUp(self):
+        self.dir = tempfile.mkdtemp()
+        self.csv = os.path.join(self.dir, 'users.csv')
+        with open(self.csv, 'w') as f:
+            f.write('login,password\n')
+            for i in range(4):
+                f.write('user%d,pass%d\n' % (i, i))
+
+        self.jsonl = os.path.join(self.dir, 'users.jsonl')
+        with open(self.jsonl, 'w') as f:
+            f.write('{"login": "user0"}\n\n{"login": "user1"}\n')
+
+    def tearDown(self):
+        shutil.rmtree(self.dir)
+        feeders._FEEDERS.clear()
+
+    def test_read_rows(self):
+        rows = list(read_rows(self.csv))
+        self.assertEqual(len(rows), 4)
+        self.assertEqual(rows[0], {'login': 'user0', 'password': 'pass0'})
+
+        rows = list(read_rows(self.jsonl))
+        self.assertEqual(rows, [{'login': 'user0'}, {'login': 'user1'}])
+
+        self.assertRaises(ValueError, list, read_rows('file.xml'))
+
+    def test_round_robin(self):
+        feeder = Feeder(self.jsonl)
+        logins = [feeder.next()['login'] for i in range(3)]
+        self.assertEqual(logins, ['user0', 'user1', 'user0'])
+
+    def test_random(self):
+        feeder = Feeder(self.csv, 'random')
+        for i in range(10):
+            self.assertTrue(feeder.next() in feeder.rows)
+
+    def test_unique(self):
+        feeder = Feeder(self.csv, 'unique')
+        first = feeder.next(user=1)
+        self.assertEqual(feeder.next(user=1), first)
+        self.assertNotEqual(feeder.next(user=2), first)
+
+        feeder.next(user=3)
+        feeder.next(user=4)
+        self.assertRaises(FeederExhausted, feeder.next, user=5)
+
+    def test_unique_is_partitioned_between_agents(self):
+        logins = []
+        for index in range(2):
+            feeder = Feeder(self.csv, 'unique', agent_index=index, agents=2)
+            logins.append([row['login'] for row in feeder.rows])
+
+        self.assertEqual(logins, [['user0', 'user2'], ['user1', 'user3']])
+
+    def test_bad_strategy(self):
+        self.assertRaises(ValueError, Feeder, self.csv, 'xxx')
+
+    def test_feed(self):
+        self.assertTrue(get_feeder(self.csv) is get_feeder(self.csv))
+
+        user1 = _FeedTestCase('test_feed')
+        user2 = _FeedTestCase('test_feed')
+        row = user1.feed(self.csv, 'unique')
+        self.assertEqual(user1.feed(self.csv, 'unique'), row)
+        self.assertNotEqual(user2.feed(self.csv, 'unique'), row)
+
+    def test_positions(self):
+        get_feeder(self.csv).next()
+        positions = get_positions()
+        self.assertEqual(positions, {'round-robin:%s' % self.csv: 1})
+
+        # the feeders of a resumed run start where they were
+        feeders._FEEDERS.clear()
+        feeder = get_feeder(self.csv, config={'feeder_positions': positions})
+        self.assertEqual(feeder.next()['login'], 'user1')
+
+    def _split(self, filename, parts):
+        partitions = []
+        for index, data in enumerate(split_feeder(pack_feeder(filename),
+                                                  filename, parts)):
+            path = os.path.join(self.dir, '%d-%s' % (
+                index, os.path.basename(filename)))
+            unpack_feeder(data, path)
+            partitions.append(list(read_rows(path)))
+        return partitions
+
+    def test_split_feeder(self):
+        # the partitions are the shares of the agents
+        partitions = self._split(self.csv, 2)
+        for index, rows in enumerate(partitions):
+            feeder = Feeder(self.csv, 'unique', agent_index=index, agents=2)
+            self.assertEqual(rows, feeder.rows)
+
+        partitions = self._split(self.jsonl, 3)
+        self.assertEqual(partitions, [[{'login': 'user0'}],
+                                      [{'login': 'user1'}], []])
+        self.assertRaises(ValueError, pack_feeder, 'file.xml')
+
+    def test_partitioned_feeder(self):
+        # the partition of an agent is not split again
+        config = {'feeder': self.csv, 'feeder_partitioned': True,
+                  'agent_index': 1, 'agents': 2}
+        self.assertEqual(len(get_feeder(self.csv, 'unique', config=config
+                                        ).rows), 4)
+        feeders._FEEDERS.clear()
+        config = {'feeder': self.jsonl, 'agent_index': 1, 'agents': 2}
+        self.assertEqual(len(get_feeder(self.csv, 'unique', config=config
+                                        ).rows), 2)
@@ -0,0 +1,108 @@
+import json
+import os
+import shutil
+import tempfile
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.errors import get_error_groups
+from loads.graphql import (GraphQLError, get_operation, read_query,
+                           render_variables)
+from loads.results import TestResult
+from loads.templates import Context
+
+
+class _Handler(BaseHTTPRequestHandler):
+    payloads = []
+
+    def do_POST(self):
+        payload = json.loads(self.rfile.read(
+            int(self.headers['Content-Length'])))
+        self.payloads.append(payload)
+        if 'mutation' in payload['query']:
+            body = {'data': None, 'errors': [{'message': 'Out of stock'}]}
+        else:
+            body = {'data': {'cart': {'id': 'c1'}}}
+        body = json.dumps(body)
+        self.send_response(200)
+        self.send_header('Content-Type', 'application/json')
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def shop(self):
+        pass
+
+
+class TestGraphQL(unittest2.TestCase):
+
+    def test_get_operation(self):
+        self.assertEqual(get_operation('query GetCart($id: ID!) { a }'),
+                         'query GetCart')
+        self.assertEqual(get_operation('# a comment with query Nope\n'
+                                       'mutation AddItem { a }'),
+                         'mutation AddItem')
+        self.assertEqual(get_operation('{ cart { id } }'), 'query')
+        self.assertEqual(get_operation('query A { a } query B { b }', 'B'),
+                         'query B')
+
+    def test_render_variables(self):
+        context = Context(state={'user': 'u1'})
+        variables = render_variables({'user': '{{state.user}}',
+                                      'quantity': '{{randInt 2 2}}',
+                                      'note': 'for {{state.user}}',
+                                      'tags': ['{{state.user}}', 1]},
+                                     context)
+        self.assertEqual(variables, {'user': 'u1', 'quantity': 2,
+                                     'note': 'for u1', 'tags': ['u1', 1]})
+
+    def test_read_query(self):
+        test_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, test_dir)
+        path = os.path.join(test_dir, 'cart.graphql')
+        with open(path, 'w') as f:
+            f.write('query GetCart { cart { id } }')
+        self.assertEqual(read_query(path), 'query GetCart { cart { id } }')
+        self.assertEqual(read_query('{ cart { id } }'), '{ cart { id } }')
+
+    def test_client(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        del _Handler.payloads[:]
+
+        result = TestResult()
+        test = _Test('shop', test_result=result)
+        self.addCleanup(test.session.close)
+        graphql = test.create_graphql('http://127.0.0.1:%d/graphql' %
+                                      server.server_address[1])
+
+        data = graphql.query('query GetCart($id: ID!) { cart(id: $id) }',
+                             {'id': '{{randInt 7 7}}'})
+        self.assertEqual(data, {'cart': {'id': 'c1'}})
+        self.assertEqual(_Handler.payloads[0]['variables'], {'id': 7})
+        self.assertRaises(GraphQLError, graphql.mutate,
+                          'mutation AddItem { add }')
+
+        # aggregated by operation, the errors of the 200 are failures
+        first, second = result.hits
+        self.assertEqual((first.endpoint, first.status, first.success),
+                         ('query GetCart', 200, True))
+        self.assertEqual((second.endpoint, second.status, second.success),
+                         ('mutation AddItem', 'GRAPHQL_ERROR', False))
+        groups = get_error_groups(hits=result.hits)
+        self.assertEqual(groups['graphql']['examples'],
+                         ['POST mutation AddItem: GRAPHQL_ERROR '
+                          'Out of stock'])
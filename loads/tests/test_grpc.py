@@ -0,0 +1,74 @@
+import os
+import tempfile
+
+import unittest2
+import mock
+
+try:
+    from google.protobuf import descriptor_pb2
+    import grpc  # NOQA
+    NO_GRPC = False
+except ImportError:
+    NO_GRPC = True
+
+from loads.results import TestResult
+
+
+def _create_descriptor_set():
+    fds = descriptor_pb2.FileDescriptorSet()
+    proto = fds.file.add(name='echo.proto', package='echo')
+
+    message = proto.message_type.add(name='Message')
+    message.field.add(name='data', number=1,
+                      type=descriptor_pb2.FieldDescriptorProto.TYPE_STRING,
+                      label=descriptor_pb2.FieldDescriptorProto.LABEL_OPTIONAL)
+
+    service = proto.service.add(name='Echo')
+    service.method.add(name='Unary', input_type='.echo.Message',
+                       output_type='.echo.Message')
+    service.method.add(name='Stream', input_type='.echo.Message',
+                       output_type='.echo.Message', server_streaming=True)
+
+    fd, filename = tempfile.mkstemp()
+    os.write(fd, fds.SerializeToString())
+    os.close(fd)
+    return filename
+
+
+@unittest2.skipIf(NO_GRPC, 'grpcio is not installed')
+class TestGRPCClient(unittest2.TestCase):
+
+    def setUp(self):
+        from loads.engines.grpc import GRPCClient
+        self.filename = _create_descriptor_set()
+        self.result = TestResult()
+        self.client = GRPCClient('localhost:50051', self.filename,
+                                 self.result)
+        self.client.channel = mock.Mock()
+
+    def tearDown(self):
+        os.remove(self.filename)
+
+    def test_unary_call(self):
+        def _unary(request, timeout=None, metadata=None):
+            return request
+
+        self.client.channel.unary_unary.return_value = _unary
+        response = self.client.call('echo.Echo/Unary', data='hello')
+        self.assertEqual(response.data, 'hello')
+
+        hit = self.result.hits[0]
+        self.assertEqual(hit.url, 'grpc://localhost:50051/echo.Echo/Unary')
+        self.assertEqual(hit.status, 'OK')
+
+    def test_server_streaming_call(self):
+        def _stream(request, timeout=None, metadata=None):
+            return iter([request, request])
+
+        self.client.channel.unary_stream.return_value = _stream
+        responses = self.client.call('echo.Echo/Stream', {'data': 'hello'})
+        self.assertEqual(len(responses), 2)
+        self.assertEqual(len(self.result.hits), 1)
+
+    def test_unknown_method(self):
+        self.assertRaises(ValueError, self.client.call, 'echo.Echo')
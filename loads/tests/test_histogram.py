@@ -0,0 +1,84 @@
+import random
+
+import unittest2
+
+from loads.histogram import Histogram
+
+
+class TestHistogram(unittest2.TestCase):
+
+    def test_precision(self):
+        histogram = Histogram()
+        values = [random.randint(1, 10 ** 7) for i in range(10000)]
+        for value in values:
+            histogram.record_value(value)
+        values.sort()
+
+        for percentile in (50, 90, 99, 99.9):
+            expected = values[int(len(values) * percentile / 100.) - 1]
+            value = histogram.get_value_at_percentile(percentile)
+            self.assertTrue(abs(value - expected) <= expected / 1000. + 1,
+                            (percentile, value, expected))
+
+        self.assertEqual(histogram.get_value_at_percentile(100), values[-1])
+        self.assertEqual(histogram.get_value_at_percentile(0), values[0])
+        self.assertEqual(histogram.total_count, 10000)
+        self.assertEqual(histogram.get_mean(), sum(values) / 10000.)
+
+    def test_small_values_are_exact(self):
+        histogram = Histogram()
+        for value in range(1000):
+            histogram.record_value(value)
+        self.assertEqual(histogram.get_value_at_percentile(50), 499)
+        self.assertEqual(histogram.get_value_at_percentile(99.9), 998)
+
+    def test_empty(self):
+        histogram = Histogram()
+        self.assertEqual(histogram.get_value_at_percentile(99), None)
+        self.assertEqual(histogram.get_mean(), 0)
+        self.assertRaises(ValueError, histogram.record_value, -1)
+        self.assertRaises(ValueError, Histogram, 6)
+
+    def test_corrected_value(self):
+        histogram = Histogram()
+        histogram.record_corrected_value(1000, 100)
+        self.assertEqual(histogram.total_count, 10)
+        self.assertEqual(histogram.min, 100)
+        self.assertEqual(histogram.max, 1000)
+        self.assertEqual(histogram.get_value_at_percentile(50), 500)
+
+        # the values under the interval are not corrected
+        histogram = Histogram()
+        histogram.record_corrected_value(50, 100)
+        self.assertEqual(histogram.total_count, 1)
+
+    def test_corrected_copy(self):
+        histogram = Histogram()
+        for _ in range(99):
+            histogram.record_value(100)
+        histogram.record_value(10000)
+        self.assertEqual(histogram.get_value_at_percentile(90), 100)
+
+        corrected = histogram.corrected(100)
+        self.assertEqual(corrected.total_count, 199)
+        self.assertTrue(corrected.get_value_at_percentile(90) > 5000)
+        self.assertEqual(histogram.total_count, 100)
+
+    def test_merge_is_exact(self):
+        values = [random.randint(1, 10 ** 6) for i in range(3000)]
+        whole = Histogram()
+        parts = [Histogram() for i in range(3)]
+        for index, value in enumerate(values):
+            whole.record_value(value)
+            parts[index % 3].record_value(value)
+
+        merged = Histogram()
+        for part in parts:
+            merged.add(Histogram.from_dict(part.to_dict()))
+
+        self.assertEqual(merged.to_dict(), whole.to_dict())
+        for percentile in (50, 95, 99):
+            self.assertEqual(merged.get_value_at_percentile(percentile),
+                             whole.get_value_at_percentile(percentile))
+
+        self.assertRaises(ValueError, merged.add, Histogram(2))
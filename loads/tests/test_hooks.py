@@ -0,0 +1,94 @@
+import datetime
+import os
+import tempfile
+
+import mock
+import unittest2
+from requests import Request
+
+from loads import hooks
+
+
+_PYTHON = """\
+import hashlib
+
+def before_request(request):
+    request.headers['X-Signature'] = hashlib.md5(request.body).hexdigest()
+    request.body += '!'
+
+def after_response(response):
+    incr('bytes', len(response.body))
+    trend('elapsed', response.elapsed)
+    check('signed', response.request.headers.get('X-Signature'))
+"""
+
+_LUA = """\
+function before_request(request)
+    request.headers['X-Method'] = request.method
+    request.body = request.body .. '!'
+end
+
+function after_response(response)
+    incr('bytes', #response.body)
+    check('ok', response.status == 200)
+end
+"""
+
+
+class _FakeResponse(object):
+    status_code = 200
+    headers = {'Content-Type': 'text/plain'}
+    content = 'done'
+    elapsed = datetime.timedelta(seconds=1)
+
+
+class TestHooks(unittest2.TestCase):
+
+    def setUp(self):
+        self.test = mock.Mock()
+        self.request = Request('POST', 'http://impossible.place',
+                               data='data').prepare()
+
+    def _get_hooks(self, content, suffix):
+        fd, path = tempfile.mkstemp(suffix=suffix)
+        os.write(fd, content)
+        os.close(fd)
+        self.addCleanup(os.remove, path)
+        self.addCleanup(hooks._HOOKS.pop, path, None)
+        return hooks.get_hooks(path)
+
+    def test_python(self):
+        python_hooks = self._get_hooks(_PYTHON, '.py')
+        self.assertTrue(isinstance(python_hooks, hooks.PythonHooks))
+        self.assertTrue(hooks.get_hooks(python_hooks.path) is python_hooks)
+
+        python_hooks.before_request(self.request, self.test)
+        self.assertEqual(self.request.body, 'data!')
+        self.assertEqual(self.request.headers['Content-Length'], '5')
+        self.assertEqual(len(self.request.headers['X-Signature']), 32)
+
+        python_hooks.after_response(_FakeResponse(), self.request, self.test)
+        self.test.incr_counter.assert_called_with('bytes', value=4)
+        self.test.add_trend.assert_called_with('elapsed', 1.)
+        self.test._test_result.add_check.assert_called_with('signed', True)
+
+    def test_no_hooks(self):
+        python_hooks = self._get_hooks('', '.py')
+        python_hooks.before_request(self.request, self.test)
+        python_hooks.after_response(_FakeResponse(), self.request, self.test)
+        self.assertEqual(self.request.body, 'data')
+
+    def test_lua(self):
+        try:
+            import lupa      # NOQA
+        except ImportError:
+            raise unittest2.SkipTest('lupa is not installed')
+
+        lua_hooks = self._get_hooks(_LUA, '.lua')
+        lua_hooks.before_request(self.request, self.test)
+        self.assertEqual(self.request.body, 'data!')
+        self.assertEqual(self.request.headers['X-Method'], 'POST')
+
+        lua_hooks.after_response(_FakeResponse(), self.request, self.test)
+        self.test.incr_counter.assert_called_with('bytes', value=4)
+        self.test._test_result.add_check.assert_called_with('ok', True)
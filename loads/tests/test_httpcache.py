@@ -0,0 +1,158 @@
+import threading
+import time
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+from email.utils import formatdate
+
+import unittest2
+
+from loads.case import TestCase
+from loads.httpcache import (HTTPCache, get_freshness, get_hit_ratios,
+                             parse_cache_control)
+from loads.results import TestResult
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    protocol_version = 'HTTP/1.1'
+
+    def do_GET(self):
+        self.server.requests.append((self.path,
+                                     self.headers.get('If-None-Match')))
+        headers = {'/fresh': 'max-age=60', '/stale': 'no-cache',
+                   '/private': 'no-store'}
+        if self.headers.get('If-None-Match') == '"v1"':
+            self.send_response(304)
+            self.send_header('ETag', '"v1"')
+            self.send_header('Content-Length', '0')
+            self.end_headers()
+            return
+        self.send_response(200)
+        self.send_header('Cache-Control', headers[self.path])
+        self.send_header('ETag', '"v1"')
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_browse(self):
+        pass
+
+
+class _Request(object):
+
+    def __init__(self, url, headers=None, method='GET'):
+        self.url = url
+        self.method = method
+        self.headers = headers or {}
+
+
+class _Response(object):
+
+    def __init__(self, status_code=200, headers=None):
+        self.status_code = status_code
+        self.headers = headers or {}
+
+
+class TestHTTPCache(unittest2.TestCase):
+
+    def setUp(self):
+        self.server = HTTPServer(('127.0.0.1', 0), _Handler)
+        self.server.requests = []
+        thread = threading.Thread(target=self.server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(self.server.server_close)
+        self.addCleanup(self.server.shutdown)
+        self.url = 'http://127.0.0.1:%d' % self.server.server_address[1]
+
+    def test_freshness(self):
+        now = time.time()
+        self.assertEqual(parse_cache_control('Max-Age=60, no-cache, '
+                                             'private="x"'),
+                         {'max-age': '60', 'no-cache': None, 'private': 'x'})
+        self.assertEqual(get_freshness({'Cache-Control': 'max-age=60',
+                                        'Age': '20'}, now), 40)
+        self.assertEqual(get_freshness({'Cache-Control': 'no-cache, '
+                                                         'max-age=60'},
+                                       now), 0)
+        self.assertAlmostEqual(get_freshness(
+            {'Date': formatdate(now), 'Expires': formatdate(now + 30)},
+            now), 30, delta=1)
+        # a tenth of the age since the last change
+        self.assertAlmostEqual(get_freshness(
+            {'Date': formatdate(now),
+             'Last-Modified': formatdate(now - 1000)}, now), 100, delta=1)
+        self.assertEqual(get_freshness({}, now), 0)
+
+    def test_cache(self):
+        clock = [1000.]
+        cache = HTTPCache(max_entries=2, clock=lambda: clock[0])
+        response = _Response(headers={'Cache-Control': 'max-age=10',
+                                      'ETag': '"v1"', 'Vary': 'Accept'})
+        request = _Request('/a', {'Accept': 'text/html'})
+        self.assertEqual(cache.store(request, response), (response, False))
+        self.assertTrue(cache.lookup(request).from_cache)
+        # another representation
+        self.assertEqual(cache.lookup(_Request('/a', {'Accept': 'json'})),
+                         None)
+
+        # stale: the request gets the validator, and a 304 the response
+        clock[0] += 20
+        request = _Request('/a', {'Accept': 'text/html'})
+        self.assertEqual(cache.lookup(request), None)
+        self.assertEqual(request.headers['If-None-Match'], '"v1"')
+        cached, revalidated = cache.store(request, _Response(304))
+        self.assertTrue(revalidated)
+        self.assertTrue(cached.from_cache)
+        self.assertTrue(cache.lookup(request) is not None)
+
+        for url, headers in (('/b', {'Cache-Control': 'no-store'}),
+                             ('/c', {}), ('/d', {'Vary': '*',
+                                                 'ETag': '"v2"'})):
+            cache.store(_Request(url), _Response(headers=headers))
+        self.assertEqual(len(cache), 1)
+        cache.store(_Request('/e', method='POST'),
+                    _Response(headers={'ETag': '"v3"'}))
+        self.assertEqual(len(cache), 1)
+
+        # the least recently used is dropped
+        for url in ('/f', '/g'):
+            clock[0] += 1
+            cache.store(_Request(url), _Response(headers={'ETag': '"v4"'}))
+        self.assertEqual(len(cache), 2)
+        self.assertEqual(cache.lookup(_Request('/a', {'Accept':
+                                                      'text/html'})), None)
+
+    def test_session(self):
+        test = _Test('test_browse', test_result=TestResult(),
+                     config={'http_cache': True})
+        test.session.loads_status = (1, 1, 1, 1)
+        for path in ('/fresh', '/stale', '/private') * 3:
+            res = test.session.get(self.url + path)
+            self.assertEqual((res.status_code, res.content), (200, 'OK'))
+
+        self.assertEqual(self.server.requests,
+                         [('/fresh', None), ('/stale', None),
+                          ('/private', None), ('/stale', '"v1"'),
+                          ('/private', None), ('/stale', '"v1"'),
+                          ('/private', None)])
+        counters = test._test_result.get_counters()
+        self.assertEqual((counters['http-cache-hits'],
+                          counters['http-cache-revalidated'],
+                          counters['http-cache-misses']), (2, 2, 5))
+        self.assertEqual(get_hit_ratios(counters), (2 / 9., 2 / 9.))
+        self.assertEqual(get_hit_ratios({}), None)
+        # only the requests sent are hits
+        self.assertEqual(test._test_result.nb_hits, 7)
+        test.session.close()
+
+        # without --http-cache, everything is sent
+        test = _Test('test_browse', test_result=TestResult())
+        self.addCleanup(test.session.close)
+        test.session.get(self.url + '/fresh')
+        self.assertEqual(len(self.server.requests), 8)
@@ -0,0 +1,239 @@
+import os
+import shutil
+import tempfile
+
+import unittest2
+
+from loads.importer import (find_dynamic_values, get_transactions,
+                            import_jmx, main)
+from loads.tests.support import hush
+from loads.util import json
+
+
+_TOKEN = '3f2b8c9d4e5f60718293a4b5c6d7e8f9'
+
+
+def _entry(url, started, method='GET', page='page_1', mime_type='text/html',
+           text='', time=100, headers=None, post=None):
+    entry = {'pageref': page,
+             'startedDateTime': '2013-05-14T00:51:%s' % started,
+             'time': time,
+             'request': {'method': method, 'url': url,
+                         'headers': headers or [], 'queryString': []},
+             'response': {'status': 200, 'headers': [],
+                          'content': {'mimeType': mime_type, 'text': text}}}
+    if post is not None:
+        entry['request']['postData'] = post
+    return entry
+
+
+_HAR = {'log': {
+    'pages': [{'id': 'page_1', 'title': 'Login'},
+              {'id': 'page_2', 'title': 'Home'}],
+    'entries': [
+        _entry('http://app/login', '00.000Z',
+               text='<input name="csrf" value="%s">' % _TOKEN),
+        _entry('http://app/style.css', '00.200Z', mime_type='text/css'),
+        _entry('http://app/login', '05.000Z', method='POST', page='page_2',
+               headers=[{'name': 'Host', 'value': 'app'},
+                        {'name': 'Accept', 'value': 'text/html'}],
+               post={'mimeType': 'application/x-www-form-urlencoded',
+                     'params': [{'name': 'csrf', 'value': _TOKEN},
+                                {'name': 'user', 'value': 'bob'}],
+                     'text': 'csrf=%s&user=bob' % _TOKEN}),
+        _entry('http://app/logo.png', '05.500Z', page='page_2',
+               mime_type='image/png')]}}
+
+
+class TestImporter(unittest2.TestCase):
+
+    def test_transactions(self):
+        transactions = get_transactions(_HAR)
+        self.assertEqual([t.name for t in transactions], ['Login', 'Home'])
+        self.assertEqual([len(t.entries) for t in transactions], [2, 2])
+        self.assertEqual(transactions[1].think_time, 4.7)
+
+        transactions = get_transactions(_HAR, skip_static=True)
+        self.assertEqual([len(t.entries) for t in transactions], [1, 1])
+
+        # without pages, the requests are split at the think times
+        har = {'log': {'entries': _HAR['log']['entries']}}
+        transactions = get_transactions(har, min_think_time=.3)
+        self.assertEqual([t.name for t in transactions],
+                         ['Step 1', 'Step 2', 'Step 3'])
+        self.assertEqual([t.think_time for t in transactions],
+                         [0., 4.7, 0.4])
+
+    def test_dynamic_values(self):
+        entries = [entry for transaction in get_transactions(_HAR)
+                   for entry in transaction.entries]
+        flags = find_dynamic_values(entries)
+        self.assertEqual(flags, {2: ['form csrf: from the response of GET '
+                                     'http://app/login']})
+
+        entry = _entry('http://app/me', '00.000Z',
+                       headers=[{'name': 'Authorization',
+                                 'value': 'Bearer %s' % _TOKEN}])
+        flags = find_dynamic_values([entry])
+        self.assertEqual(flags, {0: ['header Authorization: looks dynamic']})
+
+    def test_main(self):
+        tempdir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, tempdir)
+        path = os.path.join(tempdir, 'my-capture.har')
+        with open(path, 'w') as f:
+            json.dump(_HAR, f)
+        output = os.path.join(tempdir, 'test_capture.py')
+
+        self.assertEqual(main(['har', path, '--skip-static', '-o', output]),
+                         0)
+        with open(output) as f:
+            source = f.read()
+
+        compile(source, output, 'exec')
+        self.assertIn('class TestMyCapture(TestCase):', source)
+        self.assertIn('gevent.sleep(4.9)', source)
+        self.assertIn('# parameterize the form csrf', source)
+        self.assertIn("self.session.post(\n            'http://app/login',",
+                      source)
+        self.assertNotIn("'Host'", source)
+        self.assertNotIn('style.css', source)
+
+
+_JMX = """\
+<?xml version="1.0" encoding="UTF-8"?>
+<jmeterTestPlan version="1.2">
+  <hashTree>
+    <TestPlan testname="Shop API" enabled="true">
+      <elementProp name="TestPlan.user_defined_variables"
+                   elementType="Arguments">
+        <collectionProp name="Arguments.arguments">
+          <elementProp name="host" elementType="Argument">
+            <stringProp name="Argument.name">host</stringProp>
+            <stringProp name="Argument.value">shop.example.com</stringProp>
+          </elementProp>
+        </collectionProp>
+      </elementProp>
+    </TestPlan>
+    <hashTree>
+      <ConfigTestElement guiclass="HttpDefaultsGui" testname="Defaults">
+        <stringProp name="HTTPSampler.domain">${host}</stringProp>
+        <stringProp name="HTTPSampler.protocol">https</stringProp>
+      </ConfigTestElement>
+      <hashTree/>
+      <HeaderManager testname="Headers">
+        <collectionProp name="HeaderManager.headers">
+          <elementProp name="" elementType="Header">
+            <stringProp name="Header.name">Accept</stringProp>
+            <stringProp name="Header.value">application/json</stringProp>
+          </elementProp>
+        </collectionProp>
+      </HeaderManager>
+      <hashTree/>
+      <ThreadGroup testname="Buyers">
+        <elementProp name="ThreadGroup.main_controller"
+                     elementType="LoopController">
+          <stringProp name="LoopController.loops">5</stringProp>
+        </elementProp>
+        <stringProp name="ThreadGroup.num_threads">20</stringProp>
+        <stringProp name="ThreadGroup.ramp_time">10</stringProp>
+        <boolProp name="ThreadGroup.scheduler">true</boolProp>
+        <stringProp name="ThreadGroup.duration">60</stringProp>
+      </ThreadGroup>
+      <hashTree>
+        <CSVDataSet testname="Users">
+          <stringProp name="filename">users.csv</stringProp>
+          <boolProp name="recycle">false</boolProp>
+        </CSVDataSet>
+        <hashTree/>
+        <CookieManager testname="Cookies"/>
+        <hashTree/>
+        <HTTPSamplerProxy testname="Login">
+          <stringProp name="HTTPSampler.path">/login</stringProp>
+          <stringProp name="HTTPSampler.method">POST</stringProp>
+          <elementProp name="HTTPsampler.Arguments" elementType="Arguments">
+            <collectionProp name="Arguments.arguments">
+              <elementProp name="" elementType="HTTPArgument">
+                <stringProp name="Argument.name">user</stringProp>
+                <stringProp name="Argument.value">${user}</stringProp>
+              </elementProp>
+            </collectionProp>
+          </elementProp>
+        </HTTPSamplerProxy>
+        <hashTree>
+          <ResponseAssertion testname="Logged in"/>
+          <hashTree/>
+        </hashTree>
+        <LoopController testname="Browse">
+          <stringProp name="LoopController.loops">3</stringProp>
+        </LoopController>
+        <hashTree>
+          <ConstantTimer testname="Think">
+            <stringProp name="ConstantTimer.delay">500</stringProp>
+          </ConstantTimer>
+          <hashTree/>
+          <HTTPSamplerProxy testname="Products">
+            <stringProp name="HTTPSampler.path">/products</stringProp>
+            <elementProp name="HTTPsampler.Arguments"
+                         elementType="Arguments">
+              <collectionProp name="Arguments.arguments">
+                <elementProp name="" elementType="HTTPArgument">
+                  <stringProp name="Argument.name">page</stringProp>
+                  <stringProp
+                    name="Argument.value">${__Random(1,9)}</stringProp>
+                </elementProp>
+              </collectionProp>
+            </elementProp>
+          </HTTPSamplerProxy>
+          <hashTree/>
+        </hashTree>
+        <HTTPSamplerProxy testname="Disabled" enabled="false"/>
+        <hashTree/>
+      </hashTree>
+    </hashTree>
+  </hashTree>
+</jmeterTestPlan>
+"""
+
+
+class TestJMXImporter(unittest2.TestCase):
+
+    def setUp(self):
+        tempdir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, tempdir)
+        self.path = os.path.join(tempdir, 'plan.jmx')
+        with open(self.path, 'w') as f:
+            f.write(_JMX)
+
+    def test_import(self):
+        source, notes = import_jmx(self.path)
+        self.assertEqual(notes, [
+            "ResponseAssertion 'Logged in' is not translated",
+            "HTTPSamplerProxy 'Products' calls a function: ${__Random(1,9)}"])
+
+        namespace = {}
+        exec compile(source, self.path, 'exec') in namespace
+        self.assertEqual(namespace['VARIABLES'], {'host': 'shop.example.com'})
+        self.assertTrue('test_buyers' in dir(namespace['TestShopApi']))
+
+        self.assertIn('loads-runner test_shop_api.TestShopApi.test_buyers '
+                      '--stages 10s:20,50s:20', source)
+        self.assertIn("variables.update(self.feed('users.csv', 'unique'))",
+                      source)
+        self.assertIn("        self.session.post(\n"
+                      "            _expand('https://${host}/login', "
+                      "variables),\n"
+                      "            headers={'Accept': 'application/json'},\n"
+                      "            data={'user': _expand('${user}', "
+                      "variables)})", source)
+        self.assertIn("        for _ in range(3):  # Browse\n"
+                      "            gevent.sleep(0.5)\n", source)
+        self.assertNotIn('Disabled', source)
+
+    @hush
+    def test_main(self):
+        output = os.path.join(os.path.dirname(self.path), 'test_shop.py')
+        self.assertEqual(main(['jmx', self.path, '-o', output]), 0)
+        with open(output) as f:
+            self.assertIn('loads-runner test_shop.TestShopApi.test_buyers',
+                          f.read())
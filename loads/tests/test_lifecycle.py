@@ -0,0 +1,124 @@
+import unittest2
+
+from loads.case import TestCase
+from loads.lifecycle import (SetupError, SharedLifecycle, has_lifecycle,
+                             run_setup)
+from loads.runners.local import LocalRunner
+from loads.tests.support import get_runner_args, hush
+
+
+_FQN = 'loads.tests.test_lifecycle._TenantTestCase.'
+
+
+class _TenantTestCase(TestCase):
+    calls = []
+
+    def setup_run(self):
+        self.calls.append(('setup', None))
+        return {'tenant': 'tenant-1'}
+
+    def teardown_run(self, fixtures):
+        self.calls.append(('teardown', fixtures['tenant']))
+
+    def use_tenant(self):
+        self.calls.append(('test', self.fixtures['tenant']))
+
+
+class _BrokenTestCase(TestCase):
+    def setup_run(self):
+        return ['tenant-1']
+
+    def do_nothing(self):
+        pass
+
+
+class FakeClient(object):
+    """Keeps the fixtures like the broker does."""
+
+    def __init__(self, agents=2):
+        self.agents = agents
+        self.state = None
+        self.closed = False
+
+    def set_fixtures(self, run_id, fixtures=None, error=None):
+        self.state = {'fixtures': fixtures, 'error': error, 'released': []}
+
+    def get_fixtures(self, run_id):
+        return self.state
+
+    def release_fixtures(self, run_id, agent_index=0):
+        if self.state['released'] is None:
+            return False
+        self.state['released'].append(agent_index)
+        if len(set(self.state['released'])) < self.agents:
+            return False
+        self.state['released'] = None
+        return True
+
+    def close(self):
+        self.closed = True
+
+
+class TestLifecycle(unittest2.TestCase):
+
+    def setUp(self):
+        del _TenantTestCase.calls[:]
+
+    def test_has_lifecycle(self):
+        self.assertTrue(has_lifecycle(_TenantTestCase))
+        self.assertTrue(has_lifecycle(_BrokenTestCase))
+        self.assertFalse(has_lifecycle(TestCase))
+
+    def test_run_setup(self):
+        test = _TenantTestCase('use_tenant')
+        self.assertEqual(run_setup(test), {'tenant': 'tenant-1'})
+        self.assertRaises(SetupError, run_setup,
+                          _BrokenTestCase('do_nothing'))
+
+    def test_shared(self):
+        client = FakeClient()
+        slept = []
+        first = SharedLifecycle(client, 'run', 0, sleep=slept.append)
+        second = SharedLifecycle(client, 'run', 1, sleep=slept.append)
+
+        self.assertEqual(first.setup(lambda: {'token': 'abc'}),
+                         {'token': 'abc'})
+        # the other agents get the fixtures of the first one
+        self.assertEqual(second.setup(lambda: self.fail('setup twice')),
+                         {'token': 'abc'})
+        self.assertEqual(slept, [])
+
+        teardowns = []
+        self.assertFalse(first.teardown(teardowns.append, {'token': 'abc'}))
+        self.assertTrue(second.teardown(teardowns.append, {'token': 'abc'}))
+        self.assertEqual(teardowns, [{'token': 'abc'}])
+
+    def test_shared_errors(self):
+        client = FakeClient()
+        first = SharedLifecycle(client, 'run', 0)
+
+        def setup():
+            raise ValueError('no tenant')
+
+        self.assertRaises(ValueError, first.setup, setup)
+        self.assertEqual(client.state['error'], 'no tenant')
+        second = SharedLifecycle(client, 'run', 1)
+        self.assertRaises(SetupError, second.setup, setup)
+
+        # the agents waiting for the fixtures give up
+        client.state = None
+        slept = []
+        second = SharedLifecycle(client, 'run', 1, timeout=0,
+                                 sleep=slept.append)
+        self.assertRaises(SetupError, second.setup, setup)
+
+    @hush
+    def test_runner(self):
+        runner = LocalRunner(get_runner_args(_FQN + 'use_tenant', hits=2))
+        runner.execute()
+        calls = _TenantTestCase.calls
+        self.assertEqual(calls[0], ('setup', None))
+        self.assertEqual(calls[1:-1], [('test', 'tenant-1')] * 2)
+        self.assertEqual(calls[-1], ('teardown', 'tenant-1'))
+        # no hits for the setup and the teardown
+        self.assertEqual(runner.test_result.nb_success, 2)
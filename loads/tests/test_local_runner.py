@@ -0,0 +1,353 @@
+import os
+import shutil
+import tempfile
+import time
+
+import unittest2
+
+import gevent
+
+from loads.case import TestCase
+from loads.runners.local import (LocalRunner, _compute_arguments, _get_stage,
+                                 _get_scenarios)
+from loads.ratelimit import LIMITER
+from loads.tests.support import get_runner_args, hush
+from loads.transport.util import LOAD_FILE
+
+
+class _SleepyTestCase(TestCase):
+    def test_sleep(self):
+        gevent.sleep(.1)
+
+    def test_nothing(self):
+        pass
+
+    def test_slow(self):
+        gevent.sleep(.5)
+
+    def test_stuck(self):
+        gevent.sleep(30)
+
+
+class _ScenariosTestCase(TestCase):
+    scenarios = {'test_browse': 7, 'test_search': 2, 'test_checkout': 1}
+
+    def test_browse(self):
+        pass
+
+    def test_search(self):
+        pass
+
+    def test_checkout(self):
+        pass
+
+
+class _UsersTestCase(TestCase):
+    users = set()
+
+    def test_user(self):
+        self.users.add(id(self))
+        gevent.sleep(.05)
+
+
+_FQN = 'loads.tests.test_local_runner._SleepyTestCase.'
+
+
+class TestArrivalRate(unittest2.TestCase):
+
+    def test_compute_arguments(self):
+        args = get_runner_args('foo', hits=10, agents=3, arrival_rate=100,
+                               max_users=20)
+        total, hits, duration, users, agents = _compute_arguments(args)
+        self.assertEqual(total, 30)
+        self.assertEqual(users, [20])
+
+        args = get_runner_args('foo', duration=10, arrival_rate=100)
+        total, hits, duration, users, agents = _compute_arguments(args)
+        self.assertEqual(total, 0)
+        self.assertEqual(users, [1000])
+
+    def test_hits_are_arrivals(self):
+        args = get_runner_args(_FQN + 'test_nothing', hits=5,
+                               arrival_rate=50, max_users=10)
+        runner = LocalRunner(args)
+        runner.execute()
+        self.assertEqual(runner.test_result.nb_success, 5)
+        self.assertEqual(runner.dropped_arrivals, 0)
+
+    def test_arrivals_are_dropped(self):
+        args = get_runner_args(_FQN + 'test_sleep', hits=5,
+                               arrival_rate=100, max_users=1)
+        runner = LocalRunner(args)
+        runner.execute()
+
+        result = runner.test_result
+        self.assertTrue(runner.dropped_arrivals > 0)
+        self.assertEqual(result.nb_success + runner.dropped_arrivals, 5)
+        self.assertEqual(result.get_counter('dropped-arrivals'),
+                         runner.dropped_arrivals)
+
+
+class TestStages(unittest2.TestCase):
+
+    def test_compute_arguments(self):
+        args = get_runner_args('foo', stages='1m:10,2m:20,1m:0')
+        total, hits, duration, users, agents = _compute_arguments(args)
+        self.assertEqual(duration, 240)
+        self.assertEqual(users, [20])
+        self.assertEqual(hits, None)
+
+    def test_get_stage(self):
+        stages = [(10, 100), (10, 100), (10, 0)]
+        self.assertEqual(_get_stage(stages, 0), (0, 0))
+        self.assertEqual(_get_stage(stages, 5), (0, 50))
+        self.assertEqual(_get_stage(stages, 15), (1, 100))
+        self.assertEqual(_get_stage(stages, 27.5), (2, 25))
+        self.assertEqual(_get_stage(stages, 40), (2, 0))
+
+    def test_stages_are_reported(self):
+        args = get_runner_args(_FQN + 'test_nothing',
+                               stages='.3s:2,.3s:0')
+        runner = LocalRunner(args)
+        stages = []
+
+        def _stage_started(stage, users, duration):
+            stages.append((stage, users))
+
+        runner.test_result.stage_started = _stage_started
+        runner.execute()
+        self.assertEqual(stages, [(0, 2), (1, 0)])
+        self.assertTrue(runner.test_result.nb_success > 0)
+
+
+class TestScenarios(unittest2.TestCase):
+
+    def test_get_scenarios(self):
+        sequence = _get_scenarios(_ScenariosTestCase)
+        self.assertEqual(len(sequence), 10)
+        self.assertEqual(sequence.count('test_browse'), 7)
+        self.assertEqual(sequence.count('test_search'), 2)
+        self.assertEqual(sequence.count('test_checkout'), 1)
+
+        # the scenarios are interleaved
+        self.assertTrue(sequence[:5].count('test_search') > 0)
+
+    def test_bad_scenarios(self):
+        class _Bad(TestCase):
+            scenarios = {'test_unknown': 1}
+
+        self.assertRaises(ValueError, _get_scenarios, _Bad)
+
+        _Bad.test_unknown = lambda self: None
+        _Bad.scenarios = {'test_unknown': 0}
+        self.assertRaises(ValueError, _get_scenarios, _Bad)
+
+    def test_users_are_spread(self):
+        args = get_runner_args('loads.tests.test_local_runner.'
+                               '_ScenariosTestCase', users=10)
+        runner = LocalRunner(args)
+        runner.execute()
+
+        metrics = runner.test_result.get_test_metrics()
+        counts = dict([(name.split()[0], metric['tests'])
+                       for name, metric in metrics.items()])
+        self.assertEqual(counts, {'test_browse': 7, 'test_search': 2,
+                                  'test_checkout': 1})
+
+
+class TestPauseAndAbort(unittest2.TestCase):
+
+    def test_pause(self):
+        args = get_runner_args(_FQN + 'test_nothing', hits=3)
+        runner = LocalRunner(args)
+        runner.pause()
+
+        counts = []
+        gevent.spawn_later(.3, lambda: counts.append(
+            runner.test_result.nb_success))
+        gevent.spawn_later(.5, runner.resume)
+        runner.execute()
+
+        self.assertEqual(counts, [0])
+        self.assertEqual(runner.test_result.nb_success, 3)
+
+    def test_abort(self):
+        args = get_runner_args(_FQN + 'test_sleep', duration=10)
+        runner = LocalRunner(args)
+        gevent.spawn_later(.3, runner.abort)
+
+        started = time.time()
+        runner.execute()
+        self.assertTrue(time.time() - started < 5)
+
+        # the run ended as usual
+        result = runner.test_result
+        self.assertTrue(result.nb_success > 0)
+        self.assertTrue(result.stop_time is not None)
+
+    def test_grace_period(self):
+        args = get_runner_args(_FQN + 'test_slow', users=2, duration=1)
+        args['grace_period'] = 2.
+        runner = LocalRunner(args)
+        runner.execute()
+
+        # the tests in flight at the end completed, and are recorded
+        result = runner.test_result
+        self.assertEqual(result.nb_success, 4)
+        self.assertEqual(result.nb_errors, 0)
+        self.assertEqual(result.get_counter('killed-tests'), 0)
+
+    def test_hard_deadline(self):
+        args = get_runner_args(_FQN + 'test_stuck', duration=10)
+        args['grace_period'] = .2
+        runner = LocalRunner(args)
+        gevent.spawn_later(.3, runner.abort)
+
+        started = time.time()
+        runner.execute()
+        self.assertTrue(time.time() - started < 5)
+
+        # killed, but not an error
+        result = runner.test_result
+        self.assertEqual((result.nb_success, result.nb_errors), (0, 0))
+        self.assertEqual(result.get_counter('killed-tests'), 1)
+
+
+class TestIterations(unittest2.TestCase):
+
+    def test_compute_arguments(self):
+        args = get_runner_args('foo', users='2:3', iterations=100, agents=2)
+        total, hits, duration, users, agents = _compute_arguments(args)
+        self.assertEqual(total, 400)
+        self.assertEqual(hits, None)
+
+        args = get_runner_args('foo', users=4, iterations=10,
+                               iterations_per_user=True)
+        total, hits, duration, users, agents = _compute_arguments(args)
+        self.assertEqual(total, 40)
+        self.assertEqual(hits, [10])
+
+    def test_shared_iterations(self):
+        args = get_runner_args(_FQN + 'test_sleep', users=3, iterations=7)
+        runner = LocalRunner(args)
+        runner.execute()
+        self.assertEqual(runner.test_result.nb_success, 7)
+        self.assertEqual(runner.get_checkpoint()['iterations'], 7)
+
+    def test_iterations_per_user(self):
+        args = get_runner_args(_FQN + 'test_nothing', users=3, iterations=4,
+                               iterations_per_user=True)
+        runner = LocalRunner(args)
+        runner.execute()
+        self.assertEqual(runner.test_result.nb_success, 12)
+
+    def test_resume_iterations(self):
+        checkpoint = {'elapsed': 10., 'iterations': 6, 'feeders': {}}
+        args = get_runner_args(_FQN + 'test_nothing', users=2, iterations=10,
+                               checkpoint=checkpoint)
+        runner = LocalRunner(args)
+        runner.execute()
+        self.assertEqual(runner.test_result.nb_success, 4)
+
+
+class TestCheckpoints(unittest2.TestCase):
+
+    def test_resume_hits(self):
+        checkpoint = {'elapsed': 10., 'iterations': 3, 'feeders': {}}
+        args = get_runner_args(_FQN + 'test_nothing', hits=5,
+                               checkpoint=checkpoint)
+        runner = LocalRunner(args)
+        runner.execute()
+        self.assertEqual(runner.test_result.nb_success, 2)
+        self.assertEqual(runner.get_checkpoint()['iterations'], 5)
+
+    def test_resume_duration(self):
+        args = get_runner_args(_FQN + 'test_nothing', duration=10,
+                               checkpoint={'elapsed': 9.7})
+        runner = LocalRunner(args)
+
+        started = time.time()
+        runner.execute()
+        self.assertTrue(time.time() - started < 5)
+        self.assertTrue(runner.get_checkpoint()['elapsed'] >= 10)
+
+    def test_checkpoints_are_sent(self):
+        args = get_runner_args(_FQN + 'test_sleep', hits=3,
+                               checkpoint_interval=.1)
+        runner = LocalRunner(args)
+        runner.slave = True
+        checkpoints = []
+
+        def _checkpoint(**checkpoint):
+            checkpoints.append(checkpoint)
+
+        runner.test_result.checkpoint = _checkpoint
+        runner.test_result.close = lambda: None
+        runner.execute()
+        self.assertTrue(len(checkpoints) >= 2)
+        self.assertEqual(checkpoints[-1]['feeders'], {})
+
+
+class TestSetLoad(unittest2.TestCase):
+
+    def test_users(self):
+        _UsersTestCase.users.clear()
+        args = get_runner_args('loads.tests.test_local_runner.'
+                               '_UsersTestCase.test_user', duration=1)
+        runner = LocalRunner(args)
+        gevent.spawn_later(.3, runner.set_load, users=3)
+        runner.execute()
+        self.assertEqual(len(_UsersTestCase.users), 3)
+
+    def test_rate(self):
+        args = get_runner_args(_FQN + 'test_nothing', duration=1,
+                               arrival_rate=10, max_users=10)
+        runner = LocalRunner(args)
+        gevent.spawn_later(.3, runner.set_load, rate=100)
+        runner.execute()
+        self.assertTrue(runner.test_result.nb_success > 30)
+
+    def test_read_load(self):
+        test_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, test_dir)
+        with open(os.path.join(test_dir, LOAD_FILE), 'w') as f:
+            f.write('{"users": 5, "rate": 2.5, "rps": 40}')
+
+        runner = LocalRunner(get_runner_args(_FQN + 'test_nothing',
+                                             test_dir=test_dir))
+        self.addCleanup(LIMITER.set_rate, None)
+        runner._read_load()
+        self.assertEqual(runner.target_users, 5)
+        self.assertEqual(runner.target_rate, 2.5)
+        self.assertEqual(LIMITER.rate, 40)
+
+
+class TestThresholds(unittest2.TestCase):
+
+    @hush
+    def test_exit_code(self):
+        args = get_runner_args(_FQN + 'test_nothing', hits=2,
+                               threshold=['error_rate < 1%'])
+        self.assertEqual(LocalRunner(args).execute(), 0)
+
+        # the test does no requests: there is no data for p95
+        args = get_runner_args(_FQN + 'test_nothing', hits=2,
+                               threshold=['error_rate < 1%, p95 < 1s'])
+        self.assertEqual(LocalRunner(args).execute(), 1)
+
+    def test_bad_threshold(self):
+        args = get_runner_args(_FQN + 'test_nothing',
+                               threshold=['p95 < lots'])
+        self.assertRaises(ValueError, LocalRunner, args)
+
+
+class TestPacing(unittest2.TestCase):
+
+    def test_iterations_are_paced(self):
+        args = get_runner_args(_FQN + 'test_nothing', hits=3)
+        args['pacing'] = .1
+        runner = LocalRunner(args)
+        started = time.time()
+        runner.execute()
+        self.assertEqual(runner.test_result.nb_success, 3)
+        self.assertTrue(time.time() - started >= .3)
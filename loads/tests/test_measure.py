@@ -67,7 +67,7 @@ class TestMeasure(unittest2.TestCase):
     def _send(self, *args, **kw):
         return _FakeResponse()
 
-    def _dns(self, url):
+    def _dns(self, url, family=None):
         return url, url, 'meh'
 
     def test_session(self):
@@ -100,3 +100,30 @@ class TestMeasure(unittest2.TestCase):
 
         app.server_url = 'http://somewhere-else'
         self.assertEquals(app.server_url, 'http://somewhere-else')
+
+    def test_get_protocol(self):
+        response = _FakeResponse()
+        self.assertEqual(measure.get_protocol(response), None)
+
+        response.raw = mock.Mock(version=11)
+        self.assertEqual(measure.get_protocol(response), 'HTTP/1.1')
+
+        # hyper's responses
+        response.raw = mock.Mock(version=mock.Mock(value='HTTP/2'))
+        self.assertEqual(measure.get_protocol(response), 'HTTP/2')
+
+    def test_protocol_is_reported(self):
+        test_result = _TestResult()
+        session = Session(_FakeTest(), test_result)
+        response = _FakeResponse()
+        response.started = response.method = None
+        response.raw = mock.Mock(version=11)
+        session._analyse_request(response)
+        self.assertEqual(test_result.data[0]['protocol'], 'HTTP/1.1')
+
+    def test_redirects_are_not_followed(self):
+        session = Session(_FakeTest(), _TestResult())
+        session.follow_redirects = False
+        with mock.patch('requests.sessions.Session.request') as request:
+            session.get('http://impossible.place')
+        self.assertFalse(request.call_args[1]['allow_redirects'])
@@ -0,0 +1,82 @@
+import urllib2
+
+import unittest2
+
+from loads.transport.metrics import Metrics, MetricsServer, parse_address
+
+
+def _hit(status=200, elapsed=.2, agent_id='1'):
+    return {'data_type': 'add_hit', 'status': status, 'elapsed': elapsed,
+            'agent_id': agent_id, 'url': 'http://a', 'method': 'GET'}
+
+
+class TestMetrics(unittest2.TestCase):
+
+    def test_parse_address(self):
+        self.assertEqual(parse_address('0.0.0.0:9111'), ('0.0.0.0', 9111))
+
+    def test_render(self):
+        metrics = Metrics()
+        metrics.add(_hit())
+        metrics.add(_hit(status=500, elapsed=3))
+        metrics.add({'data_type': 'startTest', 'agent_id': '1'})
+        metrics.add({'data_type': 'startTest', 'agent_id': '1'})
+        metrics.add({'data_type': 'stopTest', 'agent_id': '1'})
+        metrics.add({'data_type': 'addSuccess', 'agent_id': '1'})
+
+        lines = metrics.render().splitlines()
+        self.assertIn('# TYPE loads_hits_total counter', lines)
+        self.assertIn('loads_hits_total{agent="1",status="200"} 1', lines)
+        self.assertIn('loads_hits_total{agent="1",status="500"} 1', lines)
+        self.assertIn('loads_tests_in_flight{agent="1"} 1', lines)
+        self.assertIn('loads_tests_total{agent="1",result="success"} 1',
+                      lines)
+        self.assertIn('loads_request_duration_seconds_bucket{agent="1",'
+                      'le="0.25"} 1', lines)
+        self.assertIn('loads_request_duration_seconds_bucket{agent="1",'
+                      'le="+Inf"} 2', lines)
+        self.assertIn('loads_request_duration_seconds_sum{agent="1"} 3.2',
+                      lines)
+        self.assertIn('loads_request_duration_seconds_count{agent="1"} 2',
+                      lines)
+
+    def test_custom(self):
+        metrics = Metrics(agent_label=False)
+        for value in (3, 5):
+            metrics.add({'data_type': 'set_gauge', 'name': 'cart',
+                         'value': value})
+            metrics.add({'data_type': 'add_trend', 'name': 'db',
+                         'value': value / 10.})
+
+        lines = metrics.render().splitlines()
+        self.assertIn('# TYPE loads_custom_gauge gauge', lines)
+        self.assertIn('loads_custom_gauge{metric="cart"} 5', lines)
+        self.assertIn('loads_custom_trend_sum{metric="db"} 0.8', lines)
+        self.assertIn('loads_custom_trend_count{metric="db"} 2', lines)
+
+    def test_batches_and_gauges(self):
+        metrics = Metrics(extra=lambda: {('loads_runs', 'Runs.'): 2},
+                          agent_label=False)
+        metrics.add({'data_type': 'batch', 'agent_id': '1',
+                     'counts': {'add_hit': [_hit(), _hit()],
+                                'addError': [{}]}})
+
+        lines = metrics.render().splitlines()
+        self.assertIn('loads_hits_total{status="200"} 2', lines)
+        self.assertIn('loads_tests_total{result="error"} 1', lines)
+        self.assertIn('# TYPE loads_runs gauge', lines)
+        self.assertIn('loads_runs 2', lines)
+
+    def test_server(self):
+        metrics = Metrics()
+        metrics.add(_hit())
+        server = MetricsServer(metrics, '127.0.0.1:0')
+        server.start()
+        try:
+            url = 'http://%s' % server.address
+            body = urllib2.urlopen(url + '/metrics').read()
+            self.assertEqual(body, metrics.render())
+            self.assertRaises(urllib2.HTTPError, urllib2.urlopen,
+                              url + '/other')
+        finally:
+            server.stop()
@@ -0,0 +1,122 @@
+import datetime
+
+import unittest2
+
+from loads.monitor import (LocalSampler, Monitor, PrometheusSampler,
+                           Sampler, SSHSampler, get_monitor, get_sampler,
+                           parse_monitor, parse_node_exporter, parse_proc)
+from loads.report import Report, from_records, render
+from loads.results import TestResult
+
+
+_PROC = """\
+cpu  100 0 100 700 100 0 0 0 0 0
+cpu0 50 0 50 350 50 0 0 0 0 0
+MemTotal:        2000 kB
+MemFree:          500 kB
+MemAvailable:    1500 kB
+Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens CurrEstab
+Tcp: 1 200 120000 -1 10 42
+"""
+
+_NODE_EXPORTER = """\
+# HELP node_cpu_seconds_total Seconds the CPUs spent in each mode.
+# TYPE node_cpu_seconds_total counter
+node_cpu_seconds_total{cpu="0",mode="idle"} 700
+node_cpu_seconds_total{cpu="0",mode="iowait"} 100
+node_cpu_seconds_total{cpu="0",mode="user"} 150
+node_cpu_seconds_total{cpu="0",mode="system"} 50
+node_memory_MemAvailable_bytes 1.5e+09
+node_memory_MemTotal_bytes 2e+09
+node_netstat_Tcp_CurrEstab 42
+"""
+
+
+class _Sampler(Sampler):
+
+    def __init__(self, host, reads):
+        super(_Sampler, self).__init__(host)
+        self.reads = list(reads)
+
+    def read(self):
+        read = self.reads.pop(0)
+        if isinstance(read, Exception):
+            raise read
+        return read
+
+
+class TestMonitor(unittest2.TestCase):
+
+    def test_parse_proc(self):
+        self.assertEqual(parse_proc(_PROC), ((200., 1000.), 25., 42))
+        self.assertEqual(parse_proc(''), (None, None, None))
+
+    def test_parse_node_exporter(self):
+        self.assertEqual(parse_node_exporter(_NODE_EXPORTER),
+                         ((200., 1000.), 25., 42))
+        self.assertEqual(parse_node_exporter('# nothing'),
+                         (None, None, None))
+
+    def test_sample(self):
+        sampler = _Sampler('web1', [((200., 1000.), 25., 42),
+                                    ((500., 1500.), 30., 40)])
+        # the CPU is the one between two samples
+        self.assertEqual(sampler.sample(), {'cpu': None, 'memory': 25.,
+                                            'connections': 42})
+        self.assertEqual(sampler.sample(), {'cpu': 60., 'memory': 30.,
+                                            'connections': 40})
+
+    def test_get_sampler(self):
+        sampler = get_sampler('http://web1:9100/metrics')
+        self.assertTrue(isinstance(sampler, PrometheusSampler))
+        self.assertEqual(sampler.host, 'web1')
+
+        sampler = get_sampler('ssh://admin@db1:2222')
+        self.assertTrue(isinstance(sampler, SSHSampler))
+        self.assertEqual(sampler.host, 'db1')
+        self.assertTrue('admin@db1' in sampler.command)
+        self.assertEqual(sampler.command[sampler.command.index('-p') + 1],
+                         '2222')
+
+        self.assertTrue(isinstance(get_sampler('local'), LocalSampler))
+        self.assertEqual(parse_monitor('local'), 'local')
+        for bad in ('ftp://web1', 'web1', 'ssh://'):
+            self.assertRaises(ValueError, get_sampler, bad)
+
+        self.assertEqual(get_monitor({}, TestResult()), None)
+        monitor = get_monitor({'monitor': ['local'], 'monitor_interval': 1},
+                              TestResult())
+        self.assertEqual(monitor.interval, 1.)
+
+    def test_monitor(self):
+        result = TestResult()
+        samplers = [_Sampler('web1', [((200., 1000.), 25., 42)]),
+                    _Sampler('db1', [IOError('unreachable')])]
+        # a host that can't be sampled is skipped
+        Monitor(samplers, result).sample()
+        resources = result.get_resources()
+        self.assertEqual(resources.keys(), ['web1'])
+        self.assertEqual(resources['web1'][0]['memory'], 25.)
+
+    def test_report(self):
+        start = datetime.datetime(2013, 5, 14, 0, 51, 8)
+        records = [{'data_type': 'add_hit', 'url': 'http://a',
+                    'method': 'GET', 'status': 200, 'elapsed': .1,
+                    'started': start.isoformat()}]
+        for second, cpu in ((-5, 10.), (0, None), (5, 80.)):
+            records.append({'data_type': 'add_resources', 'host': 'web1',
+                            'cpu': cpu, 'memory': 50., 'connections': 3,
+                            'started': (start + datetime.timedelta(
+                                seconds=second)).isoformat()})
+        report = Report(from_records(records))
+
+        # the samples before the first request are left out
+        resources = report.get_resources()
+        self.assertEqual([second for second, sample in resources['web1']],
+                         [0, 5])
+
+        html = render(report)
+        self.assertTrue('<h2>Server resources</h2>' in html)
+        cells = ['web1', '2', '80.0', '80.0', '50.0', '3']
+        self.assertTrue('\n'.join(['<td>%s</td>' % cell for cell in cells])
+                        in html)
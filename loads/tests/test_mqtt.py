@@ -0,0 +1,64 @@
+import unittest2
+import mock
+
+try:
+    import paho.mqtt  # NOQA
+    NO_PAHO = False
+except ImportError:
+    NO_PAHO = True
+
+from loads.results import TestResult
+
+
+@unittest2.skipIf(NO_PAHO, 'paho-mqtt is not installed')
+class TestMQTTClient(unittest2.TestCase):
+
+    def setUp(self):
+        from loads.engines import mqtt
+        patcher = mock.patch.object(mqtt.mqtt, 'Client')
+        self.paho = patcher.start().return_value
+        self.addCleanup(patcher.stop)
+
+        self.result = TestResult()
+        self.client = mqtt.MQTTClient('localhost', test_result=self.result)
+
+        # the broker acknowledges the connection right away
+        def _connect(*args):
+            self.client._on_connect(self.paho, None, {}, 0)
+
+        self.paho.connect.side_effect = _connect
+
+    def test_publish(self):
+        self.client.connect()
+        self.assertEqual(self.result.opened_sockets, 1)
+
+        self.paho.publish.return_value = mock.Mock(rc=0)
+        self.client.publish('devices/1', 'data', qos=1)
+        self.paho.publish.return_value.wait_for_publish.assert_called_with()
+
+        hit = self.result.hits[0]
+        self.assertEqual(hit.url, 'mqtt://localhost:1883/devices/1')
+        self.assertEqual(hit.method, 'PUBLISH')
+        self.assertEqual(hit.status, 'OK')
+
+    def test_disconnections(self):
+        self.client.connect()
+        self.client._on_disconnect(self.paho, None, 1)
+        self.assertEqual(self.result.socket_disconnects, 1)
+
+        # asking for it is not a disconnection
+        self.client.connect()
+        self.client.disconnect()
+        self.client._on_disconnect(self.paho, None, 0)
+        self.assertEqual(self.result.socket_disconnects, 1)
+
+    def test_subscribe(self):
+        messages = []
+        self.paho.subscribe.return_value = 0, 1
+        self.client.connect()
+        self.client.subscribe('devices/#', callback=messages.append)
+
+        message = mock.Mock(topic='devices/1', payload='data')
+        self.client._on_message(self.paho, None, message)
+        self.assertEqual(messages, [message])
+        self.assertEqual(self.result.socket_data_received, 4)
@@ -0,0 +1,121 @@
+import mock
+import unittest2
+
+from loads.results import NATSTestResult
+from loads.transport.nats import (NATSClient, NATSError, JetStreamError,
+                                  ResultsConsumer)
+
+
+def _msg(sid, payload, reply=None, headers=None):
+    reply = reply and ' ' + reply or ''
+    if headers is None:
+        return 'MSG subject %s%s %d\r\n%s\r\n' % (sid, reply, len(payload),
+                                                  payload)
+    return 'HMSG subject %s%s %d %d\r\n%s%s\r\n' % (
+        sid, reply, len(headers), len(headers) + len(payload), headers,
+        payload)
+
+
+class FakeSocket(object):
+
+    def __init__(self, *data):
+        self.data = ['INFO {"server_id": "1"}\r\n', 'PONG\r\n'] + list(data)
+        self.sent = ''
+
+    def sendall(self, data):
+        self.sent += data
+
+    def recv(self, size):
+        if not self.data:
+            return ''
+        return self.data.pop(0)
+
+    def close(self):
+        pass
+
+
+class TestNATSClient(unittest2.TestCase):
+
+    def _get_client(self, *data):
+        self.sock = FakeSocket(*data)
+        patcher = mock.patch('loads.transport.nats.socket.create_connection',
+                             return_value=self.sock)
+        create = patcher.start()
+        self.addCleanup(patcher.stop)
+
+        client = NATSClient('nats://nats1:4222,nats://nats2')
+        client.connect()
+        create.assert_called_with(('nats1', 4222), client.timeout)
+        self.assertTrue(self.sock.sent.startswith('CONNECT {'))
+        return client
+
+    def test_store(self):
+        client = self._get_client(_msg(1, '{"stream": "LOADS", "seq": 1}'))
+        res = client.store('loads.results.run', 'data', msg_id='abc')
+        self.assertEqual(res['seq'], 1)
+        self.assertIn('Nats-Msg-Id: abc\r\n\r\ndata\r\n', self.sock.sent)
+        self.assertTrue(self.sock.sent.endswith('UNSUB 1\r\n'))
+
+    def test_errors(self):
+        client = self._get_client(
+            _msg(1, '{"error": {"err_code": 10058, "description": "in use"}}'),
+            "-ERR 'Authorization Violation'\r\n")
+
+        try:
+            client.jetstream('STREAM.CREATE.LOADS', name='LOADS')
+        except JetStreamError, e:
+            self.assertEqual(e.code, 10058)
+        else:
+            raise AssertionError('No error')
+
+        self.assertRaises(NATSError, client.request, 'subject', 'data')
+        self.assertRaises(NATSError, client.request, 'subject', 'data')
+
+    def test_consume(self):
+        client = self._get_client(
+            'PING\r\n',
+            _msg(1, 'one', reply='$JS.ACK.1'),
+            _msg(2, 'elsewhere'),
+            _msg(1, 'two', reply='$JS.ACK.2',
+                 headers='NATS/1.0\r\nNats-Msg-Id: 2\r\n\r\n'),
+            _msg(1, '', headers='NATS/1.0 408 Request Timeout\r\n\r\n'))
+
+        payloads = []
+        consumer = ResultsConsumer('nats://nats1', payloads.append)
+        consumer.consume(client)
+
+        self.assertEqual(payloads, ['one', 'two'])
+        self.assertIn('PONG\r\n', self.sock.sent)
+        self.assertIn('PUB $JS.ACK.1 4\r\n+ACK\r\n', self.sock.sent)
+        self.assertIn('PUB $JS.ACK.2 4\r\n+ACK\r\n', self.sock.sent)
+
+
+class TestNATSTestResult(unittest2.TestCase):
+
+    def setUp(self):
+        patcher = mock.patch('loads.results.natsrelay.NATSClient')
+        self.client = patcher.start().return_value
+        self.addCleanup(patcher.stop)
+        patcher = mock.patch('loads.results.natsrelay.gevent.sleep')
+        patcher.start()
+        self.addCleanup(patcher.stop)
+
+        self.result = NATSTestResult({'nats_receiver': 'nats://nats1',
+                                      'run_id': 'run', 'agent_id': 1})
+        self.addCleanup(self.result.close)
+
+    def test_retries(self):
+        self.client.store.side_effect = [NATSError('timeout'), {'seq': 1}]
+        self.result._send('data')
+
+        calls = self.client.store.call_args_list
+        self.assertEqual(len(calls), 2)
+        self.assertEqual(calls[0][0], ('loads.results.run', 'data'))
+        self.assertEqual(calls[0][1]['msg_id'], calls[1][1]['msg_id'])
+        self.assertEqual(self.client.connect.call_count, 2)
+
+    def test_not_stored(self):
+        self.client.store.side_effect = NATSError('timeout')
+        self.assertRaises(NATSError, self.result._send, 'data')
+        self.assertEqual(self.client.store.call_count,
+                         NATSTestResult.retries)
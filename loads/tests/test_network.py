@@ -0,0 +1,162 @@
+import socket
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+import mock
+
+from loads.case import TestCase
+from loads.engines.tcp import TCPClient
+from loads.engines.udp import UDPClient
+from loads.network import (PROFILES, NetworkConditions, get_network,
+                           parse_bandwidth, parse_delay, parse_loss,
+                           parse_profiles)
+from loads.results import TestResult
+from loads.util import total_seconds
+
+
+class _Clock(object):
+
+    def __init__(self):
+        self.now = 0.
+        self.sleeps = []
+
+    def __call__(self):
+        return self.now
+
+    def sleep(self, seconds):
+        self.sleeps.append(seconds)
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_network(self):
+        pass
+
+
+class TestNetwork(unittest2.TestCase):
+
+    def test_parse(self):
+        self.assertEqual(parse_delay('150ms'), .15)
+        self.assertEqual(parse_delay('0.2s'), .2)
+        self.assertEqual(parse_delay('40'), .04)
+        self.assertEqual(parse_bandwidth('1.5mbit'), 187500)
+        self.assertEqual(parse_bandwidth('800'), 100)
+        self.assertRaises(ValueError, parse_bandwidth, 'fast')
+        self.assertEqual(parse_loss('2%'), .02)
+        self.assertEqual(parse_loss('0.5'), .5)
+        self.assertRaises(ValueError, parse_loss, '120%')
+        self.assertEqual(parse_profiles('3g, wifi'), ['3g', 'wifi'])
+        self.assertRaises(ValueError, parse_profiles, '5g')
+
+    def test_conditions(self):
+        clock = _Clock()
+        network = NetworkConditions(latency=.1, bandwidth=1000, clock=clock,
+                                    sleep=clock.sleep)
+        self.assertEqual(network.wait(500), .6)
+        # the bytes queue behind the ones on their way
+        self.assertEqual(network.wait(500, latency=False), 1.)
+        clock.now += 10
+        self.assertEqual(network.wait(), .1)
+        self.assertEqual(clock.sleeps, [.6, 1., .1])
+        self.assertFalse(network.lost())
+
+        network = NetworkConditions(latency=.1, jitter=.05)
+        for index in range(20):
+            self.assertTrue(.05 <= network.delay() <= .15)
+        self.assertTrue(NetworkConditions(loss=1).lost())
+
+    def test_get_network(self):
+        self.assertEqual(get_network({}), None)
+        users = [get_network({'network': ['3g', 'cross-region'],
+                              'packet_loss': .5}) for index in range(3)]
+        self.assertEqual([user.latency for user in users],
+                         [PROFILES['3g'][0], PROFILES['cross-region'][0],
+                          PROFILES['3g'][0]])
+        self.assertEqual(set(user.loss for user in users), set([.5]))
+        self.assertEqual(users[1].bandwidth, None)
+
+        network = get_network({'latency': .08})
+        self.assertEqual((network.latency, network.jitter, network.bandwidth,
+                          network.loss), (.08, 0., None, 0.))
+
+
+class TestShaping(unittest2.TestCase):
+
+    def test_session(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+
+        result = TestResult()
+        test = _Test('test_network', test_result=result,
+                     config={'latency': .1})
+        self.addCleanup(test.session.close)
+        test.session.get('http://127.0.0.1:%d/' % server.server_address[1])
+        hit, = result.hits
+        self.assertTrue(total_seconds(hit.elapsed) >= .1)
+        self.assertTrue(hit.phases['ttfb'] >= .1)
+
+    def test_tcp(self):
+        sock = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        sock.bind(('127.0.0.1', 0))
+        sock.listen(1)
+        self.addCleanup(sock.close)
+
+        def _echo():
+            client, _ = sock.accept()
+            client.sendall(client.recv(1024))
+            client.close()
+
+        thread = threading.Thread(target=_echo)
+        thread.daemon = True
+        thread.start()
+
+        clock = _Clock()
+        network = NetworkConditions(latency=.05, loss=1, clock=clock,
+                                    sleep=clock.sleep)
+        test_case = mock.Mock()
+        client = TCPClient('127.0.0.1', sock.getsockname()[1], TestResult(),
+                           test_case=test_case, network=network)
+        self.addCleanup(client.close)
+        self.assertEqual(client.send_receive('hello'), 'hello')
+        self.assertEqual(clock.sleeps, [.05, .2])
+        test_case.incr_counter.assert_called_with('tcp-retransmissions', 1)
+
+    def test_udp(self):
+        sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+        sock.bind(('127.0.0.1', 0))
+        self.addCleanup(sock.close)
+
+        test_case = mock.Mock(_loads_status=None)
+        client = UDPClient('127.0.0.1', sock.getsockname()[1], TestResult(),
+                           test_case=test_case, timeout=.1,
+                           network=NetworkConditions(loss=1))
+        self.addCleanup(client.close)
+        stats = client.run('ping', count=5)
+        self.assertEqual((stats['sent'], stats['dropped'], stats['lost']),
+                         (5, 5, 5))
+        test_case.incr_counter.assert_called_with('udp-dropped', 5)
+
+    def test_test_case(self):
+        test = _Test('test_network', config={'network': ['wifi']})
+        self.addCleanup(test.session.close)
+        self.assertTrue(test.session.network is test.network)
+        client = test.create_udp('127.0.0.1', 9)
+        self.addCleanup(client.close)
+        self.assertTrue(client.network is test.network)
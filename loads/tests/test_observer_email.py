@@ -0,0 +1,78 @@
+import email
+import smtplib
+
+import unittest2
+
+from loads.observers import email as email_observer
+from loads.results import RemoteTestResult
+
+
+class FakeSMTP(object):
+    sent = []
+
+    def __init__(self, host, port, timeout=None):
+        pass
+
+    def sendmail(self, sender, recipients, message):
+        self.sent.append((sender, recipients, message))
+
+    def quit(self):
+        pass
+
+
+_RECORDS = [{'data_type': 'add_hit', 'url': 'http://a', 'method': 'GET',
+             'status': status, 'elapsed': elapsed, 'scenario': 'test_es'}
+            for status, elapsed in ((200, .1), (500, .4))]
+
+
+class TestEMail(unittest2.TestCase):
+
+    def setUp(self):
+        self.old = smtplib.SMTP
+        smtplib.SMTP = FakeSMTP
+
+    def tearDown(self):
+        smtplib.SMTP = self.old
+        del FakeSMTP.sent[:]
+
+    def _get_parts(self):
+        sender, recipients, message = FakeSMTP.sent[-1]
+        message = email.message_from_string(message)
+        if not message.is_multipart():
+            return [message]
+        return message.get_payload()
+
+    def test_summary(self):
+        observer = email_observer(recipient='team@example.com')
+        test_result = RemoteTestResult()
+        test_result.set_counts({'stopTest': 2, 'addFailure': 1})
+        observer(test_result)
+        self.assertEqual(FakeSMTP.sent[0][1], ['team@example.com'])
+        parts = self._get_parts()
+        self.assertEqual(len(parts), 1)
+        body = parts[0].get_payload(decode=True)
+        self.assertTrue(body.startswith('Test over. Ran 2 tests'))
+        self.assertTrue('\nFailures   1\n' in body)
+
+    def test_report(self):
+        observer = email_observer(args={'fqn': 'example.Test.test_es',
+                                        'threshold': ['p95 < 250ms']})
+        observer.set_records(iter(_RECORDS))
+        observer('http://web/run/1')
+
+        text, html = self._get_parts()
+        body = text.get_payload(decode=True)
+        self.assertTrue(body.startswith('Test over. http://web/run/1'))
+        self.assertTrue('[FAILED] All: p95 < 250ms (400.0ms)' in body)
+        self.assertTrue('[FAILED] test_es: p95 < 250ms' in body)
+        self.assertEqual(html.get_content_type(), 'text/html')
+        self.assertTrue('filename="report.html"' in
+                        html['Content-Disposition'])
+        report = html.get_payload(decode=True)
+        self.assertTrue('Loads report of example.Test.test_es' in report)
+        self.assertTrue('<h2>Thresholds</h2>' in report)
+
+        observer = email_observer(no_report=True)
+        observer.set_records(_RECORDS)
+        observer(RemoteTestResult())
+        self.assertEqual(len(self._get_parts()), 1)
@@ -0,0 +1,75 @@
+import unittest2
+
+import requests
+
+from loads.observers import opsgenie, pagerduty
+from loads.observers._paging import get_dedup_key
+from loads.results import TestResult
+from loads.util import json
+
+
+class FakeResponse(object):
+    status_code = 202
+    text = ''
+
+
+class TestPaging(unittest2.TestCase):
+
+    def setUp(self):
+        self.posted = []
+        self.old = requests.post
+        requests.post = self._post
+        self.args = {'fqn': 'example.Test.test_soak', 'run_id': 'run',
+                     'breached': ['p95 < 250ms']}
+
+    def tearDown(self):
+        requests.post = self.old
+
+    def _post(self, url, data=None, headers=None, **kw):
+        self.posted.append((url, json.loads(data), headers))
+        return FakeResponse()
+
+    def _join(self, *threads):
+        for thread in threads:
+            thread.join()
+
+    def test_pagerduty(self):
+        observer = pagerduty(routing_key='key', resolve=True, args=self.args)
+        self._join(observer.threshold_breached('run', 'p95 < 250ms',
+                                               '312.5ms'))
+        self._join(*observer(TestResult()))
+
+        (url, trigger, headers), (url, resolve, headers) = self.posted
+        self.assertEqual(url, 'https://events.pagerduty.com/v2/enqueue')
+        self.assertEqual(trigger['event_action'], 'trigger')
+        self.assertEqual(trigger['dedup_key'], 'loads-run-p95<250ms')
+        self.assertEqual(trigger['payload']['severity'], 'error')
+        self.assertEqual(trigger['payload']['custom_details']['value'],
+                         '312.5ms')
+        self.assertEqual(resolve, {'routing_key': 'key',
+                                   'event_action': 'resolve',
+                                   'dedup_key': trigger['dedup_key']})
+
+        self.assertEqual(pagerduty(args=self.args)(TestResult()), [])
+        self.assertEqual(pagerduty().threshold_breached('run', 'p95 < 1s',
+                                                        '2s'), None)
+        self.assertRaises(ValueError, pagerduty, severity='high')
+
+    def test_opsgenie(self):
+        observer = opsgenie(api_key='key', close=True, responders='a, b',
+                            args=self.args)
+        self._join(observer.threshold_breached('run', 'p95 < 250ms',
+                                               '312.5ms'))
+        self._join(*observer('http://web/run/run'))
+
+        (url, alert, headers), (close_url, close, headers) = self.posted
+        self.assertEqual(headers['Authorization'], 'GenieKey key')
+        self.assertEqual(alert['alias'], get_dedup_key('run', 'p95 < 250ms'))
+        self.assertEqual(alert['priority'], 'P2')
+        self.assertEqual(alert['responders'],
+                         [{'name': 'a', 'type': 'team'},
+                          {'name': 'b', 'type': 'team'}])
+        self.assertEqual(close_url, 'https://api.opsgenie.com/v2/alerts/'
+                                    'loads-run-p95%3C250ms/close'
+                                    '?identifierType=alias')
+        self.assertRaises(ValueError, opsgenie, priority='P0')
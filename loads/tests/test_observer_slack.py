@@ -0,0 +1,62 @@
+import unittest2
+
+import requests
+
+from loads.observers import slack
+from loads.results import TestResult
+from loads.util import json
+
+
+class FakeResponse(object):
+    status_code = 200
+    text = 'ok'
+
+
+class TestSlack(unittest2.TestCase):
+
+    def setUp(self):
+        self.posted = []
+        self.old = requests.post
+        requests.post = self._post
+
+    def tearDown(self):
+        requests.post = self.old
+
+    def _post(self, url, data=None, **kw):
+        self.posted.append((url, json.loads(data)))
+        return FakeResponse()
+
+    def _get_texts(self, *threads):
+        for thread in threads:
+            thread.join()
+        return [payload['text'] for url, payload in self.posted]
+
+    def test_run(self):
+        args = {'fqn': 'example.Test.test_es', 'users': [1, 10],
+                'agents': 2, 'duration': 60, 'breached': ['p95 < 250ms']}
+        observer = slack(webhook='http://slack', channel='#loads', args=args)
+        started = observer.run_started('run')
+        breached = observer.threshold_breached('run', 'p95 < 250ms',
+                                               '312.5ms')
+        ended = observer(TestResult())
+
+        texts = self._get_texts(started, breached, ended)
+        self.assertEqual(texts[0], 'Run example.Test.test_es (run) started: '
+                                   '1:10 users, 2 agents, 60 seconds')
+        self.assertTrue('*p95 < 250ms*: 312.5ms' in texts[1])
+        self.assertTrue('```\nTests      0\n' in texts[2])
+        self.assertTrue('Breached   p95 < 250ms\n```' in texts[2])
+        self.assertEqual(self.posted[0][0], 'http://slack')
+        self.assertEqual(self.posted[0][1]['channel'], '#loads')
+        self.assertEqual(self.posted[0][1]['username'], 'loads')
+
+    def test_link(self):
+        observer = slack(webhook='http://slack', args={'fqn': 'test'})
+        texts = self._get_texts(observer('http://web/run/1'))
+        self.assertEqual(texts, ['Run test (None) is over: '
+                                 'http://web/run/1'])
+        self.assertFalse('channel' in self.posted[0][1])
+
+    def test_no_webhook(self):
+        self.assertEqual(slack().run_started('run'), None)
+        self.assertEqual(self.posted, [])
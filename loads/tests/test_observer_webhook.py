@@ -0,0 +1,88 @@
+import hashlib
+import hmac
+import os
+import tempfile
+
+import unittest2
+
+import requests
+
+from loads.observers import webhook
+from loads.observers._webhook import render_payload
+from loads.results import TestResult
+from loads.templates import TemplateError
+from loads.util import json
+
+
+class FakeResponse(object):
+    status_code = 204
+
+
+class TestWebhook(unittest2.TestCase):
+
+    def setUp(self):
+        self.posted = []
+        self.old = requests.post
+        requests.post = self._post
+
+    def tearDown(self):
+        requests.post = self.old
+
+    def _post(self, url, data=None, headers=None, **kw):
+        self.posted.append((url, data, headers))
+        return FakeResponse()
+
+    def _join(self, threads):
+        for thread in threads:
+            thread.join()
+
+    def test_events(self):
+        args = {'fqn': 'example.Test.test_es', 'agents': 2,
+                'project_name': 'N/A', 'run_id': 'run'}
+        observer = webhook(url='http://a, http://b', args=args)
+        self._join(observer.run_started('run'))
+        self.assertEqual([url for url, body, headers in self.posted],
+                         ['http://a', 'http://b'])
+        event = json.loads(self.posted[0][1])
+        self.assertEqual(event['name'], 'started')
+        self.assertEqual(event['agents'], 2)
+        self.assertEqual(event['project'], None)
+        self.assertEqual(self.posted[0][2]['X-Loads-Event'], 'started')
+        self.assertFalse('X-Loads-Signature' in self.posted[0][2])
+
+        del self.posted[:]
+        self._join(observer(TestResult()))
+        event = json.loads(self.posted[0][1])
+        self.assertEqual((event['name'], event['run_id'], event['tests']),
+                         ('ended', 'run', 0))
+
+        observer = webhook(url='http://a', events='ended')
+        self.assertEqual(observer.run_started('run'), [])
+        self.assertRaises(ValueError, webhook, events='started,over')
+
+    def test_template(self):
+        template = ('{"text": "{{event.fqn}} {{event.name}}", '
+                    '"threshold": {{json event.threshold}}, '
+                    '"value": {{json event.value}}}')
+        fd, path = tempfile.mkstemp()
+        os.write(fd, template)
+        os.close(fd)
+        self.addCleanup(os.remove, path)
+
+        observer = webhook(url='http://a', template='@' + path,
+                           secret='s3cr3t', args={'fqn': 'test'})
+        self._join(observer.threshold_breached('run', 'p95 < 1s', '1.2s'))
+        url, body, headers = self.posted[0]
+        self.assertEqual(json.loads(body),
+                         {'text': 'test breached', 'threshold': 'p95 < 1s',
+                          'value': '1.2s'})
+        signature = hmac.new('s3cr3t', body, hashlib.sha256).hexdigest()
+        self.assertEqual(headers['X-Loads-Signature'], 'sha256=' + signature)
+
+    def test_render(self):
+        event = {'name': 'ended', 'errors': None}
+        self.assertEqual(render_payload('{{event.name}}:{{event.errors}}:'
+                                        '{{json event.errors}}', event),
+                         'ended::null')
+        self.assertRaises(TemplateError, render_payload, '{{event.what}}',
+                          event)
@@ -1,17 +1,25 @@
 import StringIO
 import datetime
+import json
 import mock
 import shutil
+import socket
 import sys
 import tempfile
+from xml.etree import ElementTree
 
 from unittest2 import TestCase
 from mock import patch
 
 from loads.output import (create_output, output_list, register_output,
                           StdOutput, NullOutput, FileOutput,
-                          FunkloadOutput)
+                          FunkloadOutput, HistogramOutput, InfluxDBOutput,
+                          StatsDOutput, OTLPOutput, JUnitOutput,
+                          JSONLinesOutput, JSONStreamOutput)
 from loads import output
+from loads.custom import Gauge
+from loads.histogram import Histogram
+from loads.results import TestResult
 
 from loads.tests.support import get_tb, hush
 
@@ -29,6 +37,8 @@ class FakeTestResult(object):
         self.requests_per_second = lambda: 0
         self.opened_sockets = 0
         self.socket_data_received = 0
+        self.bytes_received = self.wire_bytes_received = 0
+        self.warmup = self.warmup_hits = 0
         self.nb_success = 0
         self.nb_errors = nb_errors
         self.nb_failures = nb_failures
@@ -37,6 +47,14 @@ class FakeTestResult(object):
         self.failures = []
         self.hits = []
         self.tests = {}
+        self.checks = {}
+        self.gauges = {}
+        self.trends = {}
+        self.resources = {}
+        self.chaos_events = []
+        self.phases = {}
+        self.lost_agents = []
+        self.tags = {}
 
     def get_url_metrics(self):
         return {'http://foo': {'average_request_time': 1.234,
@@ -47,6 +65,30 @@ class FakeTestResult(object):
     def get_counters(self):
         return {'boo': 123}
 
+    def get_test_metrics(self):
+        return self.tests
+
+    def get_checks(self):
+        return self.checks
+
+    def get_gauges(self):
+        return self.gauges
+
+    def get_trends(self):
+        return self.trends
+
+    def get_resources(self):
+        return self.resources
+
+    def get_error_groups(self):
+        return {}
+
+    def get_phase_metrics(self):
+        return self.phases
+
+    def get_tag_metrics(self):
+        return self.tags
+
 
 class FakeOutput(object):
     name = 'fake'
@@ -82,6 +124,48 @@ class TestStdOutput(TestCase):
         out = sys.stdout.read()
         self.assertTrue('Hits: 10' in out)
         self.assertTrue('100%' in out, out)
+        self.assertFalse('Checks:' in out)
+
+    def test_std_checks(self):
+        sys.stdout = StringIO.StringIO()
+
+        test_result = FakeTestResult()
+        test_result.checks = {'status == 200': {'passed': 8, 'failed': 2}}
+        std = StdOutput(test_result, {'total': 10})
+        std.flush()
+        sys.stdout.seek(0)
+        out = sys.stdout.read()
+        self.assertTrue('- status == 200 : 8 passed, 2 failed' in out, out)
+
+    def test_std_lost_agents(self):
+        sys.stdout = StringIO.StringIO()
+
+        test_result = FakeTestResult()
+        test_result.lost_agents = [('1', None), ('2', '3')]
+        std = StdOutput(test_result, {'total': 10})
+        std.flush()
+        sys.stdout.seek(0)
+        out = sys.stdout.read()
+        self.assertTrue('Agent 1 lost: the run is degraded' in out, out)
+        self.assertTrue('Agent 2 lost, replaced by 3' in out, out)
+
+    def test_std_tags(self):
+        sys.stdout = StringIO.StringIO()
+
+        test_result = FakeTestResult()
+        test_result.tags = {'region': {
+            'eu-west': {'hits': 10, 'average_request_time': .3, 'p95': .9,
+                        'success_rate': 1.},
+            'us-east': {'hits': 12, 'average_request_time': .1, 'p95': .3,
+                        'success_rate': .5}}}
+        std = StdOutput(test_result, {'total': 10})
+        std.flush()
+        sys.stdout.seek(0)
+        out = sys.stdout.read()
+        self.assertTrue('Stats by region:' in out, out)
+        self.assertTrue('- eu-west\tHits: 10\tAverage request time: 0.300s'
+                        '\tp95: 0.900s\tSuccess rate: 1.00' in out, out)
+        self.assertTrue(out.index('eu-west') < out.index('us-east'))
 
     @hush
     def test_errors_are_processed(self):
@@ -136,6 +220,14 @@ class TestStdOutput(TestCase):
     def test_counter(self):
         sys.stdout = StringIO.StringIO()
         test_result = FakeTestResult()
+        test_result.gauges['cart'] = Gauge()
+        test_result.gauges['cart'].set(4)
+        test_result.resources['web1'] = [
+            {'cpu': None, 'memory': 40., 'connections': 10},
+            {'cpu': 50., 'memory': 60., 'connections': 30}]
+        test_result.chaos_events = [
+            {'name': 'restart', 'success': True, 'detail': ''},
+            {'name': 'flag', 'success': False, 'detail': 'status 500'}]
         std = StdOutput(test_result, {'total': 10})
         for i in range(11):
             test_result.nb_finished_tests += 1
@@ -143,10 +235,26 @@ class TestStdOutput(TestCase):
         std.flush()
         sys.stdout.seek(0)
         out = sys.stdout.read()
-        wanted = ['boo', '123']
+        wanted = ['boo', '123', '- cart : last 4, min 4, max 4',
+                  '- web1 : cpu avg 50.0%, max 50.0%; memory avg 50.0%, '
+                  'max 60.0%; connections avg 20.0, max 30.0',
+                  '- restart : OK', '- flag : FAILED (status 500)']
         for item in wanted:
             self.assertTrue(item in out)
 
+    def test_scenarios(self):
+        sys.stdout = StringIO.StringIO()
+        test_result = FakeTestResult()
+        metric = {'tests': 10, 'success_rate': 1.,
+                  'average_test_duration': 0.5}
+        test_result.tests = {'test_browse': metric, 'test_search': metric}
+        std = StdOutput(test_result, {'total': 10})
+        std.flush()
+        sys.stdout.seek(0)
+        out = sys.stdout.read()
+        self.assertTrue('Stats by scenario' in out)
+        self.assertTrue('- test_search\tTests: 10' in out)
+
 
 class TestNullOutput(TestCase):
 
@@ -167,12 +275,477 @@ class TestFileOutput(TestCase):
             output.flush()
 
             with open('%s/loads' % tmpdir) as f:
-                self.assertEquals('something - {"method": "GET"}', f.read())
+                self.assertEquals('something - {"method": "GET"}\n',
+                                  f.read())
+
+        finally:
+            shutil.rmtree(tmpdir)
 
+    def test_tests_are_written(self):
+        tmpdir = tempfile.mkdtemp()
+        try:
+            output = FileOutput(mock.sentinel.test_result,
+                                {'output_file_filename': '%s/loads' % tmpdir})
+            output.push('addFailure', 'test_es (module.TestSite)', get_tb(),
+                        [1, 1, 1, 1])
+            output.flush()
+
+            with open('%s/loads' % tmpdir) as f:
+                method, data = f.read().split(' - ', 1)
+            data = json.loads(data)
+            self.assertEqual(method, 'addFailure')
+            self.assertEqual(data['test'], 'test_es (module.TestSite)')
+            self.assertEqual(data['loads_status'], [1, 1, 1, 1])
+            self.assertEqual(data['exc_info'][:2],
+                             ["<type 'exceptions.Exception'>",
+                              'Error message'])
         finally:
             shutil.rmtree(tmpdir)
 
 
+class TestHistogramOutput(TestCase):
+
+    def test_file_is_written(self):
+        test_result = TestResult()
+        for seconds, elapsed in ((0, .1), (1, .2), (12, .3)):
+            test_result.add_hit(url='http://a', method='GET', status=200,
+                                started=TIME1 + seconds * _1,
+                                elapsed=elapsed, loads_status=None)
+
+        tmpdir = tempfile.mkdtemp()
+        try:
+            filename = '%s/histogram.json' % tmpdir
+            output = HistogramOutput(test_result,
+                                     {'output_histogram_filename': filename})
+            output.flush()
+
+            with open(filename) as f:
+                data = json.load(f)
+        finally:
+            shutil.rmtree(tmpdir)
+
+        self.assertEqual(data['interval'], 10)
+        self.assertEqual(data['histogram']['total_count'], 3)
+        self.assertEqual([i['start'] for i in data['intervals']],
+                         ['2013-05-14T00:51:00', '2013-05-14T00:51:20'])
+
+        # the intervals can be merged back
+        histogram = Histogram()
+        for interval in data['intervals']:
+            histogram.add(Histogram.from_dict(interval['histogram']))
+        self.assertEqual(histogram.to_dict(), data['histogram'])
+
+
+class TestInfluxDBOutput(TestCase):
+
+    def _get_output(self, **options):
+        args = {'run_id': 'run1'}
+        for name, value in options.items():
+            args['output_influxdb_%s' % name] = value
+        output = InfluxDBOutput(mock.sentinel.test_result, args)
+        output.session = mock.Mock()
+        return output
+
+    def _push(self, output):
+        for status, elapsed in ((200, _1), (500, 3 * _1)):
+            output.push('add_hit', url='http://a b', method='GET',
+                        status=status, started=TIME1, elapsed=elapsed,
+                        loads_status=[1, 1, 1, 1], agent_id=2)
+        output.push('addSuccess', 'test_es (module.TestSite)', [1, 1, 1, 1],
+                    agent_id=2)
+
+    def test_lines(self):
+        output = self._get_output(tags='region=eu, env=ci')
+        self._push(output)
+        lines = output.get_lines(output._metrics, 1368492668)
+
+        tags = 'agent=2,env=ci,region=eu,run_id=run1'
+        self.assertEqual(lines, [
+            'loads_requests,%s avg=2.0,count=2i,errors=1i,max=3.0,'
+            'p95=3.0 1368492668000' % tags,
+            'loads_tests,%s,scenario=test_es errors=0i,failures=0i,'
+            'success=1i 1368492668000' % tags])
+
+    def test_custom(self):
+        output = self._get_output()
+        output.push('set_gauge', 'cart', 3)
+        output.push('add_trend', name='db', value=1.5, agent_id=None)
+        output.push('add_trend', 'db', 2.5)
+        self.assertEqual(output.get_lines(output._metrics, 1368492668), [
+            'loads_gauges,metric=cart,run_id=run1 value=3.0 1368492668000',
+            'loads_trends,metric=db,run_id=run1 avg=2.0,count=2i,max=2.5,'
+            'min=1.5 1368492668000'])
+
+    def test_agent_tags(self):
+        output = self._get_output()
+        output.test_result = mock.Mock()
+        output.test_result.agent_tags = {'2': {'region': 'eu'}}
+        self.assertEqual(output.get_tags(2), {'agent': '2', 'region': 'eu',
+                                              'run_id': 'run1'})
+
+    def test_samples(self):
+        output = self._get_output(samples=1)
+        self._push(output)
+        lines = output.get_lines(output._metrics, 1368492668)
+        self.assertEqual(len(lines), 4)
+        self.assertEqual(lines[2],
+                         'loads_request,agent=2,method=GET,run_id=run1,'
+                         'status=200,url=http://a\\ b elapsed=1.0 '
+                         '1368492668000')
+
+    def test_flush(self):
+        output = self._get_output(url='http://influx:8086/',
+                                  database='tests')
+        self._push(output)
+        output.flush()
+
+        args, kwargs = output.session.post.call_args
+        self.assertEqual(args, ('http://influx:8086/write',))
+        self.assertEqual(kwargs['params'], {'db': 'tests',
+                                            'precision': 'ms'})
+        self.assertEqual(len(kwargs['data'].split('\n')), 2)
+
+        # nothing is sent when there is nothing new
+        output.session.post.reset_mock()
+        output.flush()
+        self.assertFalse(output.session.post.called)
+
+
+class TestStatsDOutput(TestCase):
+
+    def _get_output(self, **options):
+        args = {}
+        for name, value in options.items():
+            args['output_statsd_%s' % name] = value
+        return StatsDOutput(mock.sentinel.test_result, args)
+
+    def _push(self, output, agent_id=None):
+        for status, elapsed in ((200, _1), (500, 3 * _1)):
+            output.push('add_hit', url='http://a', method='GET',
+                        status=status, started=TIME1, elapsed=elapsed,
+                        loads_status=[1, 1, 1, 1], agent_id=agent_id)
+        output.push('addFailure', 'test_es (module.TestSite)', None,
+                    [1, 1, 1, 1], agent_id=agent_id)
+
+    def test_lines(self):
+        output = self._get_output(prefix='ci.loads')
+        self._push(output)
+        self.assertEqual(output.get_lines(output._metrics), [
+            'ci.loads.requests:2|c',
+            'ci.loads.errors:1|c',
+            'ci.loads.tests.test_es.errors:0|c',
+            'ci.loads.tests.test_es.failures:1|c',
+            'ci.loads.tests.test_es.success:0|c',
+            'ci.loads.request_time:1000|ms',
+            'ci.loads.request_time:3000|ms'])
+
+    def test_custom(self):
+        output = self._get_output()
+        output.push('set_gauge', 'items in cart', 3)
+        output.push('add_trend', 'db', 1.5)
+        self.assertEqual(output.get_lines(output._metrics),
+                         ['loads.gauges.items_in_cart:3|g',
+                          'loads.trends.db:1.5|ms'])
+
+    def test_sampled_timers(self):
+        output = self._get_output(rate=.5)
+        self._push(output, agent_id='1.2')
+        with patch('random.random', lambda: .2):
+            lines = output.get_lines(output._metrics)
+        self.assertEqual(lines[0], 'loads.agent.1_2.requests:2|c')
+        self.assertEqual(lines[-1],
+                         'loads.agent.1_2.request_time:3000|ms|@0.5')
+
+        with patch('random.random', lambda: .7):
+            lines = output.get_lines(output._metrics)
+        self.assertFalse([line for line in lines if '|ms' in line])
+
+        self.assertRaises(ValueError, self._get_output, rate=2)
+
+    def test_packets(self):
+        output = self._get_output()
+        lines = ['loads.request_time:%d|ms' % i for i in range(100)]
+        packets = output.get_packets(lines)
+        self.assertTrue(len(packets) > 1)
+        self.assertTrue(all([len(p) <= 512 for p in packets]))
+        self.assertEqual('\n'.join(packets).split('\n'), lines)
+
+    def test_flush(self):
+        server = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+        server.bind(('127.0.0.1', 0))
+        try:
+            output = self._get_output(host='127.0.0.1',
+                                      port=server.getsockname()[1])
+            self._push(output)
+            output.flush()
+            data = server.recv(512)
+        finally:
+            server.close()
+        self.assertTrue(data.startswith('loads.requests:2|c\n'))
+
+
+class TestOTLPOutput(TestCase):
+
+    def _get_output(self, **options):
+        args = {'run_id': 'run1'}
+        for name, value in options.items():
+            args['output_otlp_%s' % name] = value
+        output = OTLPOutput(mock.sentinel.test_result, args)
+        output.session = mock.Mock()
+        return output
+
+    def _push(self, output, span=None):
+        for status, elapsed in ((200, _1), (500, 3 * _1)):
+            output.push('add_hit', url='http://a', method='GET',
+                        status=status, started=TIME1, elapsed=elapsed,
+                        loads_status=[1, 1, 1, 1], agent_id=2, span=span)
+        output.push('addSuccess', 'test_es (module.TestSite)', [1, 1, 1, 1],
+                    agent_id=2)
+
+    def test_metrics(self):
+        output = self._get_output(tags='env=ci')
+        self._push(output)
+        payload, spans = output.get_payloads(output._metrics, 1368492668)
+        self.assertEqual(spans, None)
+
+        resource, = payload['resourceMetrics']
+        attributes = dict([(attr['key'], attr['value']['stringValue'])
+                           for attr in resource['resource']['attributes']])
+        self.assertEqual(attributes, {'service.name': 'loads', 'env': 'ci',
+                                      'loads.run_id': 'run1',
+                                      'loads.agent': '2'})
+
+        metrics = dict([(metric['name'], metric) for metric
+                        in resource['scopeMetrics'][0]['metrics']])
+        self.assertEqual(sorted(metrics), ['loads.request.duration',
+                                           'loads.request.errors',
+                                           'loads.requests', 'loads.tests'])
+        point = metrics['loads.requests']['sum']['dataPoints'][0]
+        self.assertEqual(point['asInt'], '2')
+        self.assertEqual(point['timeUnixNano'], '1368492668000000000')
+
+        histogram = metrics['loads.request.duration']['histogram']
+        point = histogram['dataPoints'][0]
+        self.assertEqual(point['count'], '2')
+        self.assertEqual(point['sum'], 4.)
+        self.assertEqual(sum([int(c) for c in point['bucketCounts']]), 2)
+
+        points = metrics['loads.tests']['sum']['dataPoints']
+        self.assertEqual([p['asInt'] for p in points], ['0', '0', '1'])
+
+        # the next interval starts where this one ended
+        payload, spans = output.get_payloads(output._metrics, 1368492669)
+        point = payload['resourceMetrics'][0]['scopeMetrics'][0]['metrics']
+        self.assertEqual(point[0]['sum']['dataPoints'][0]['startTimeUnixNano'],
+                         '1368492668000000000')
+
+    def test_custom(self):
+        output = self._get_output()
+        output.push('set_gauge', 'cart', 3, agent_id=2)
+        for value in (1, 2):
+            output.push('add_trend', 'db', value, agent_id=2)
+        payload, spans = output.get_payloads(output._metrics, 1368492668)
+        metrics = dict([(metric['name'], metric) for metric in
+                        payload['resourceMetrics'][0]['scopeMetrics'][0][
+                            'metrics']])
+        point, = metrics['loads.custom.gauge']['gauge']['dataPoints']
+        self.assertEqual(point['asDouble'], 3.)
+        point, = metrics['loads.custom.trend']['summary']['dataPoints']
+        self.assertEqual((point['count'], point['sum']), ('2', 3.))
+
+    def test_spans(self):
+        output = self._get_output()
+        self._push(output, span=['a' * 32, 'b' * 16])
+        payload, spans = output.get_payloads(output._metrics, 1368492668)
+
+        ok, error = spans['resourceSpans'][0]['scopeSpans'][0]['spans']
+        self.assertEqual(ok['traceId'], 'a' * 32)
+        self.assertEqual(ok['spanId'], 'b' * 16)
+        self.assertEqual(ok['name'], 'GET')
+        self.assertEqual(int(ok['endTimeUnixNano']) -
+                         int(ok['startTimeUnixNano']), 10 ** 9)
+        self.assertFalse('status' in ok)
+        self.assertEqual(error['status'], {'code': 2})
+
+    def test_flush(self):
+        output = self._get_output(url='http://collector:4318/')
+        self._push(output, span=['a' * 32, 'b' * 16])
+        output.flush()
+
+        urls = [args[0] for args, kwargs
+                in output.session.post.call_args_list]
+        self.assertEqual(urls, ['http://collector:4318/v1/metrics',
+                                'http://collector:4318/v1/traces'])
+
+
+class TestJUnitOutput(TestCase):
+
+    def _get_result(self):
+        test_result = TestResult()
+        test_result.startTestRun()
+        test_result.add_hit(url='http://a', method='GET', status=200,
+                            started=TIME1, elapsed=.1, loads_status=None)
+        test_result.addSuccess('test_es (module.TestSite)', [1, 1, 1, 1])
+        test_result.addSuccess('test_es (module.TestSite)', [1, 1, 2, 1])
+        test_result.addFailure('test_search (module.TestSite)', get_tb(),
+                               [1, 1, 1, 1])
+        test_result.addError('test_search (module.TestSite)',
+                             ['KeyError', "'id'", 'Traceback...\n'],
+                             [1, 1, 2, 1])
+        return test_result
+
+    def test_file_is_written(self):
+        tmpdir = tempfile.mkdtemp()
+        try:
+            filename = '%s/junit.xml' % tmpdir
+            args = {'output_junit_filename': filename,
+                    'threshold': ['p95 < 1s, hits_error_rate > 50%']}
+            output = JUnitOutput(self._get_result(), args)
+            output.flush()
+            root = ElementTree.parse(filename).getroot()
+        finally:
+            shutil.rmtree(tmpdir)
+
+        suite, = root.findall('testsuite')
+        self.assertEqual((suite.get('tests'), suite.get('failures'),
+                          suite.get('errors')), ('4', '2', '1'))
+
+        cases = suite.findall('testcase')
+        self.assertEqual([(case.get('classname'), case.get('name'))
+                          for case in cases],
+                         [('module.TestSite', 'test_es'),
+                          ('module.TestSite', 'test_search'),
+                          ('loads.thresholds', 'p95 < 1s'),
+                          ('loads.thresholds', 'hits_error_rate > 50%')])
+
+        es, search, p95, error_rate = cases
+        self.assertEqual(es.getchildren(), [])
+        failure = search.find('failure')
+        self.assertEqual(failure.get('message'), '1 of 2 runs failed')
+        self.assertTrue('Error message' in failure.text)
+        error = search.find('error')
+        self.assertEqual(error.get('message'), '1 of 2 runs had an error')
+        self.assertTrue(error.text.startswith('Traceback...'))
+
+        self.assertEqual(p95.getchildren(), [])
+        self.assertEqual(error_rate.find('failure').get('message'),
+                         'the hits_error_rate is 0.000')
+
+
+class TestJSONLinesOutput(TestCase):
+
+    def _push(self, output):
+        output.push('add_hit', url='http://a/users/42', method='GET',
+                    status=200, started=TIME1, elapsed=_1,
+                    loads_status=[1, 1, 1, 1], scenario='test_es',
+                    phases={'connect': .1}, request_id='abc')
+        output.push('addSuccess', 'test_es (module.TestSite)', [1, 1, 1, 1])
+
+    def test_file_is_written(self):
+        tmpdir = tempfile.mkdtemp()
+        try:
+            filename = '%s/requests.jsonl' % tmpdir
+            output = JSONLinesOutput(mock.sentinel.test_result,
+                                     {'output_jsonl_filename': filename})
+            self._push(output)
+            output.flush()
+
+            with open(filename) as f:
+                lines = f.readlines()
+        finally:
+            shutil.rmtree(tmpdir)
+
+        self.assertEqual(len(lines), 1)
+        self.assertEqual(json.loads(lines[0]), {
+            'timestamp': '2013-05-14T00:51:08', 'scenario': 'test_es',
+            'method': 'GET', 'url': 'http://a/users/42',
+            'url_pattern': 'http://a/users/{id}', 'status': 200,
+            'elapsed': 1.0, 'phases': {'connect': .1}, 'agent_id': None,
+            'request_id': 'abc'})
+
+    def test_stdout(self):
+        old = sys.stdout
+        sys.stdout = StringIO.StringIO()
+        try:
+            output = JSONLinesOutput(mock.sentinel.test_result, {})
+            self._push(output)
+            output.flush()
+            written = sys.stdout.getvalue()
+        finally:
+            sys.stdout = old
+        self.assertEqual(json.loads(written)['scenario'], 'test_es')
+
+
+class TestJSONStreamOutput(TestCase):
+
+    def _get_output(self, filename):
+        args = {'run_id': 'run1', 'output_json_stream_filename': filename}
+        output = JSONStreamOutput(mock.sentinel.test_result, args)
+        output._started = output._last = 1368492660
+        return output
+
+    def _push(self, output):
+        for status, elapsed, agent_id in ((200, _1, 1), (500, 3 * _1, 2)):
+            output.push('add_hit', url='http://a', method='GET',
+                        status=status, started=TIME1, elapsed=elapsed,
+                        loads_status=[1, 1, 1, 1], agent_id=agent_id)
+        output.push('addSuccess', 'test_es (module.TestSite)', [1, 1, 1, 1],
+                    agent_id=1)
+
+    def test_lines(self):
+        tmpdir = tempfile.mkdtemp()
+        try:
+            filename = '%s/progress.jsonl' % tmpdir
+            output = self._get_output(filename)
+            self._push(output)
+            output.send(output._metrics, 1368492662)
+            output._metrics = {}
+            # the intervals without hits get a line too
+            output.send({}, 1368492664)
+            self._push(output)
+            output.flush()
+
+            with open(filename) as f:
+                lines = [json.loads(line) for line in f]
+        finally:
+            shutil.rmtree(tmpdir)
+
+        first, empty, summary = lines
+        self.assertEqual(first['type'], 'interval')
+        self.assertEqual(first['run_id'], 'run1')
+        self.assertEqual(first['timestamp'], '2013-05-14T00:51:02')
+        self.assertEqual(first['elapsed'], 2)
+        self.assertEqual(first['interval'], first['total'])
+        self.assertAlmostEqual(first['interval'].pop('p50'), 1., places=2)
+        self.assertEqual(first['interval'], {
+            'hits': 2, 'errors': 1, 'rps': 1., 'avg': 2.,
+            'p95': 3., 'p99': 3., 'max': 3.,
+            'tests': {'success': 1, 'failures': 0, 'errors': 0}})
+
+        self.assertEqual(empty['interval'], {
+            'hits': 0, 'errors': 0, 'rps': 0.,
+            'tests': {'success': 0, 'failures': 0, 'errors': 0}})
+        self.assertEqual(empty['total']['hits'], 2)
+
+        self.assertEqual(summary['type'], 'summary')
+        self.assertEqual(summary['interval']['hits'], 2)
+        self.assertEqual((summary['total']['hits'],
+                          summary['total']['errors'],
+                          summary['total']['tests']['success']), (4, 2, 2))
+
+    def test_stdout(self):
+        old = sys.stdout
+        sys.stdout = StringIO.StringIO()
+        try:
+            output = self._get_output('-')
+            self._push(output)
+            output.flush()
+            written = sys.stdout.getvalue()
+        finally:
+            sys.stdout = old
+        self.assertEqual(json.loads(written)['total']['hits'], 2)
+
+
 class FakeTestCase(object):
     def __init__(self, name):
         self._testMethodName = name
@@ -0,0 +1,91 @@
+import random
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.pacing import (Pacing, ThinkTime, get_pacing, get_think_time,
+                          parse_think_time)
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-Length', '0')
+        self.end_headers()
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+    urls = ()
+
+    def test_steps(self):
+        for url in self.urls:
+            self.session.get(url)
+
+
+class TestPacing(unittest2.TestCase):
+
+    def test_parse(self):
+        self.assertEqual(parse_think_time('2'), ('fixed', 2., None))
+        self.assertEqual(parse_think_time('Uniform:1,3'),
+                         ('uniform', 1., 3.))
+        self.assertEqual(parse_think_time(['lognormal', 2, .5]),
+                         ('lognormal', 2, .5))
+        for value in ('uniform:3,1', 'uniform:1', 'gauss:1',
+                      'lognormal:2', '1,2,3', '-1'):
+            self.assertRaises(ValueError, parse_think_time, value)
+
+    def test_think_times(self):
+        rand = random.Random(1)
+        think_time = ThinkTime('uniform', 1, 3, rand=rand)
+        values = [think_time.next() for i in range(100)]
+        self.assertTrue(1 <= min(values) <= max(values) <= 3)
+
+        think_time = ThinkTime('lognormal', 2, .5, rand=rand)
+        values = sorted([think_time.next() for i in range(2001)])
+        self.assertTrue(1.8 < values[1000] < 2.2)
+        self.assertTrue(values[-1] > 4)
+
+        slept = []
+        think_time = ThinkTime('fixed', 1.5, sleep=slept.append)
+        self.assertEqual(think_time.wait(), 1.5)
+        self.assertEqual(slept, [1.5])
+        self.assertEqual(get_think_time({}), None)
+        self.assertEqual(get_think_time({'think_time': '2'}).first, 2)
+
+    def test_pacing(self):
+        slept = []
+        pacing = Pacing(10, clock=lambda: 104., sleep=slept.append)
+        self.assertEqual(pacing.wait(100.), 6.)
+        self.assertEqual(pacing.wait(90.), 0.)
+        self.assertEqual(slept, [6.])
+        self.assertEqual(get_pacing({}), None)
+        self.assertEqual(get_pacing({'pacing': '2.5'}).minimum, 2.5)
+        self.assertRaises(ValueError, Pacing, -1)
+
+    def test_steps(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+
+        test = _Test('test_steps', config={'think_time': '1'})
+        self.addCleanup(test.session.close)
+        test.urls = ['http://127.0.0.1:%d/' % server.server_address[1]] * 3
+        slept = []
+        test.think_time._sleep = slept.append
+        # the first request of every run doesn't wait
+        test.run(unittest2.TestResult())
+        test.run(unittest2.TestResult())
+        self.assertEqual(slept, [1.] * 4)
+
+        test.think()
+        test.think(0)
+        self.assertEqual(slept, [1.] * 5)
@@ -0,0 +1,165 @@
+import os
+import stat
+import tempfile
+
+import mock
+import unittest2
+from requests.models import PreparedRequest
+
+from loads import plugins
+from loads.plugins import (Plugin, PluginAuth, PluginError, PluginObserver,
+                           PluginOutput, decode_string, encode_string,
+                           get_plugins, parse_handshake, parse_options)
+from loads.results import TestResult
+
+
+_SCRIPT = """#!/bin/sh
+test "$LOADS_PLUGIN" = "7f3e1c5a9b2d4e6f" || exit 1
+echo '%s'
+sleep 30
+"""
+
+
+class _Plugin(object):
+
+    def __init__(self, kinds=None, reply=None):
+        self.name = 'fake'
+        self.kinds = kinds or []
+        self.reply = reply
+        self.calls = []
+
+    def call(self, service, method, value=None):
+        self.calls.append((service, method, value))
+        return self.reply
+
+
+class TestPlugins(unittest2.TestCase):
+
+    def tearDown(self):
+        plugins.stop_plugins()
+
+    def _script(self, handshake):
+        fd, path = tempfile.mkstemp()
+        os.write(fd, _SCRIPT % handshake)
+        os.close(fd)
+        os.chmod(path, stat.S_IRWXU)
+        self.addCleanup(os.remove, path)
+        return path
+
+    def test_string_value(self):
+        for value in ('', 'OK', 'x' * 300, u'caf\xe9'):
+            encoded = encode_string(value)
+            self.assertEqual(decode_string(encoded),
+                             value.encode('utf8') if value else '')
+        self.assertEqual(len(encode_string('x' * 300)), 303)
+        # the unknown fields are skipped
+        self.assertEqual(decode_string('\x10\x96\x01' + encode_string('OK')),
+                         'OK')
+        self.assertRaises(PluginError, decode_string, '\x0d\x00\x00\x00\x00')
+
+    def test_handshake(self):
+        self.assertEqual(parse_handshake('1|1|unix|/tmp/plugin1|grpc\n'),
+                         ('unix', '/tmp/plugin1'))
+        self.assertEqual(parse_handshake('1|1|tcp|127.0.0.1:1234|grpc|'),
+                         ('tcp', '127.0.0.1:1234'))
+        for line in ('1|1|unix|/tmp/plugin1', '2|1|unix|/tmp/plugin1|grpc',
+                     '1|2|unix|/tmp/plugin1|grpc',
+                     '1|1|unix|/tmp/plugin1|netrpc',
+                     '1|1|udp|/tmp/plugin1|grpc'):
+            self.assertRaises(PluginError, parse_handshake, line)
+
+    def test_options(self):
+        self.assertEqual(parse_options(['brokers = kafka:9092', 'a=b=c']),
+                         {'brokers': 'kafka:9092', 'a': 'b=c'})
+        self.assertEqual(parse_options(None), {})
+        self.assertRaises(ValueError, parse_options, ['brokers'])
+
+    def test_start(self):
+        path = self._script('1|1|unix|/tmp/plugin1|grpc')
+        reply = {'name': 'kafka', 'kinds': ['sink', 'unknown']}
+        with mock.patch.object(Plugin, '_connect') as connect:
+            with mock.patch.object(Plugin, 'call',
+                                   return_value=reply) as call:
+                config = {'plugin': [path], 'plugin_option': ['a=b'],
+                          'run_id': 'run'}
+                found = get_plugins(config, 'sink')
+                self.assertEqual(get_plugins(config), found)
+                self.assertEqual(get_plugins(config, 'auth'), [])
+
+        plugin, = found
+        connect.assert_called_once_with('unix:/tmp/plugin1')
+        call.assert_called_once_with(
+            'Plugin', 'Configure',
+            {'options': {'a': 'b'},
+             'run': {'run_id': 'run', 'fqn': None, 'project_name': None}})
+        self.assertEqual((plugin.name, plugin.kinds), ('kafka', ['sink']))
+        self.assertTrue(plugin.process.poll() is None)
+
+        plugin.timeout = .1
+        process = plugin.process
+        plugins.stop_plugins()
+        self.assertTrue(process.poll() is not None)
+        self.assertEqual(get_plugins({}), [])
+
+    def test_start_failed(self):
+        plugin = Plugin(self._script('2|1|unix|/tmp/plugin1|grpc'),
+                        timeout=.1)
+        self.assertRaises(PluginError, plugin.start)
+        self.assertEqual(plugin.process, None)
+        self.assertRaises(PluginError, Plugin('/no/such/plugin').start)
+
+    def test_output(self):
+        plugin = _Plugin(['sink'])
+        output = PluginOutput(TestResult(), {}, plugin)
+        output.push('startTestRun')
+        output.push('add_hit', url='http://x/1', method='GET', status=200,
+                    agent_id=1)
+        output.push('addSuccess', None, agent_id=1)
+        self.assertEqual(plugin.calls, [])
+
+        with mock.patch.object(plugins, 'BATCH_SIZE', 3):
+            output.push('addFailure', None, None)
+        records = plugin.calls[0][2]
+        self.assertEqual(plugin.calls[0][:2], ('Sink', 'Push'))
+        self.assertEqual([(record['type'], record.get('url'),
+                           record.get('result')) for record in records],
+                         [('hit', 'http://x/1', None),
+                          ('test', None, 'success'),
+                          ('test', None, 'failure')])
+
+        output.push('add_hit', url='http://x/2')
+        output.flush()
+        self.assertEqual([call[:2] for call in plugin.calls[1:]],
+                         [('Sink', 'Push'), ('Sink', 'Flush')])
+        self.assertEqual(plugin.calls[1][2][0]['url'], 'http://x/2')
+
+        # a sink failing does not fail the run
+        plugin.call = mock.Mock(side_effect=PluginError('down'))
+        output.push('add_hit', url='http://x/3')
+        output.flush()
+        self.assertEqual(plugin.call.call_count, 2)
+
+    def test_auth(self):
+        plugin = _Plugin(['auth'], {'headers': {'Authorization': 'Bearer x'}})
+        request = PreparedRequest()
+        request.prepare(method='GET', url='http://x/',
+                        headers={'Accept': 'text/html'})
+        request = PluginAuth(plugin)(request)
+        self.assertEqual(request.headers['Authorization'], 'Bearer x')
+        self.assertEqual(plugin.calls,
+                         [('Auth', 'Authenticate',
+                           {'method': 'GET', 'url': 'http://x/',
+                            'headers': {'Accept': 'text/html'}})])
+
+    def test_observer(self):
+        plugin = _Plugin(['observer'])
+        observer = PluginObserver(plugin, args={'fqn': 'example.Test.test',
+                                                'run_id': 'run'})
+        self.assertEqual(observer.run_started('run'), [])
+        service, method, event = plugin.calls[0]
+        self.assertEqual((service, method, event['name'], event['run_id']),
+                         ('Observer', 'Notify', 'started', 'run'))
+
+        plugin.call = mock.Mock(side_effect=PluginError('down'))
+        self.assertEqual(observer.threshold_breached('run', 'p95<1s', 2.),
+                         [])
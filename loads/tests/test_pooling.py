@@ -0,0 +1,108 @@
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.pooling import get_pool_options, get_reuse_ratio
+from loads.results import TestResult
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    protocol_version = 'HTTP/1.1'
+
+    def do_GET(self):
+        self.server.headers.append(self.headers.get('Connection'))
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+    connection_pools = {'test_cold': {'no_keep_alive': True}}
+
+    def test_warm(self):
+        pass
+
+    def test_cold(self):
+        pass
+
+
+class TestPooling(unittest2.TestCase):
+
+    def setUp(self):
+        self.server = HTTPServer(('127.0.0.1', 0), _Handler)
+        self.server.headers = []
+        thread = threading.Thread(target=self.server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(self.server.server_close)
+        self.addCleanup(self.server.shutdown)
+        self.url = 'http://127.0.0.1:%d/' % self.server.server_address[1]
+
+    def _get(self, test, count=3):
+        test.session.loads_status = (1, 1, 1, 1)
+        self.addCleanup(test.session.close)
+        for i in range(count):
+            test.session.get(self.url)
+        counters = test._test_result.get_counters()
+        return (counters['http-connections-new'],
+                counters['http-connections-reused'])
+
+    def test_options(self):
+        self.assertEqual(get_pool_options({}), None)
+        options = get_pool_options({'idle_timeout': 0})
+        self.assertEqual(options.idle_timeout, 0)
+        self.assertEqual(options.max_idle, None)
+        options = get_pool_options({'max_idle_connections': 4}, 'test_cold',
+                                   _Test.connection_pools)
+        self.assertEqual(options.max_idle, 0)
+        self.assertFalse(options.keep_alive)
+        self.assertRaises(ValueError, get_pool_options, {}, 'test',
+                          {'test': {'keepalive': False}})
+        self.assertRaises(ValueError, get_pool_options,
+                          {'max_connections_per_host': 0})
+
+    def test_reuse(self):
+        test = _Test('test_warm', test_result=TestResult())
+        self.assertEqual(self._get(test), (1, 2))
+        self.assertEqual(self.server.headers, ['keep-alive'] * 3)
+        self.assertEqual(get_reuse_ratio(test._test_result.get_counters()),
+                         2 / 3.)
+        self.assertEqual(get_reuse_ratio({}), None)
+
+    def test_no_keep_alive(self):
+        test = _Test('test_cold', test_result=TestResult())
+        self.assertEqual(self._get(test), (3, 0))
+        self.assertEqual(self.server.headers, ['close'] * 3)
+
+        test = _Test('test_warm', test_result=TestResult(),
+                     config={'no_keep_alive': True})
+        self.assertEqual(self._get(test), (3, 0))
+
+    def test_idle_timeout(self):
+        test = _Test('test_warm', test_result=TestResult(),
+                     config={'idle_timeout': 0})
+        self.assertEqual(self._get(test), (3, 0))
+
+    def test_pool_sizes(self):
+        test = _Test('test_warm', test_result=TestResult(),
+                     config={'max_idle_connections': 1,
+                             'max_connections_per_host': 2})
+        self.addCleanup(test.session.close)
+        adapter = test.session.get_adapter(self.url)
+        pool = adapter.poolmanager.connection_from_url(self.url)
+        self.assertEqual(pool.pool.maxsize, 2)
+        self.assertTrue(pool.block)
+
+        connections = [pool._get_conn(), pool._get_conn()]
+        for connection in connections:
+            connection.connect()
+        for connection in connections:
+            pool._put_conn(connection)
+        self.assertEqual(pool._count_idle(), 1)
@@ -0,0 +1,22 @@
+import unittest2
+
+from loads.tests import test_sqlite_db
+try:
+    from loads.db._postgres import PostgresDB
+    import psycopg2
+    psycopg2.connect('dbname=loads_tests').close()
+    NO_TEST = False
+except Exception:
+    NO_TEST = True
+
+
+@unittest2.skipIf(NO_TEST, 'No PostgreSQL loads_tests database')
+class TestPostgresDB(test_sqlite_db.TestSQLiteDB):
+
+    def _get_db(self):
+        return PostgresDB(self.loop, dsn='dbname=loads_tests')
+
+    def setUp(self):
+        super(TestPostgresDB, self).setUp()
+        for run_id in self.db.get_runs():
+            self.db.delete_run(run_id)
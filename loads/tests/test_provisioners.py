@@ -0,0 +1,181 @@
+import sys
+
+import mock
+import unittest2
+
+from loads.launch import main as launch
+from loads.provisioners import create_provisioner
+from loads.provisioners.base import (Provisioner, ProvisionError,
+                                     split_load)
+from loads.util import json
+
+
+class _Provisioner(Provisioner):
+    name = 'fake'
+
+    def __init__(self, args):
+        super(_Provisioner, self).__init__(args)
+        self.created = self.deleted = 0
+
+    def create_agents(self, count):
+        self.created += count
+
+    def delete_agents(self):
+        self.deleted += 1
+
+
+class TestProvisioner(unittest2.TestCase):
+
+    def setUp(self):
+        patcher = mock.patch('loads.provisioners.base.Client')
+        self.client = patcher.start().return_value
+        self.addCleanup(patcher.stop)
+
+    def test_agents_count(self):
+        args = {'users': '10:120', 'users_per_agent': 50}
+        self.assertEqual(_Provisioner(args).get_agents_count(), 3)
+        args = {'stages': '1m:20,1m:0', 'users_per_agent': 50}
+        self.assertEqual(_Provisioner(args).get_agents_count(), 1)
+        args = {'arrival_rate': 10, 'max_users': 200}
+        self.assertEqual(_Provisioner(args).get_agents_count(), 4)
+
+    def test_split_load(self):
+        args = split_load({'users': '100:30', 'stages': '2m:100,1m:0',
+                           'max_users': 100, 'arrival_rate': 30.}, 3)
+        self.assertEqual(args['agents'], 3)
+        self.assertEqual(args['users'], '34:10')
+        self.assertEqual(args['stages'], '120.0:34,60.0:0')
+        self.assertEqual(args['max_users'], 34)
+        self.assertEqual(args['arrival_rate'], 10.)
+
+    def test_start(self):
+        self.client.list.side_effect = [{'1': {}}, {'1': {}, '2': {}},
+                                        {'1': {}, '2': {}, '3': {}}]
+        provisioner = _Provisioner({'users': '100', 'broker': 'ipc://b'})
+        with mock.patch('loads.provisioners.base.time.sleep'):
+            args = provisioner.start()
+
+        self.assertEqual(provisioner.created, 2)
+        self.assertEqual(args['agents'], 2)
+        self.assertEqual(args['users'], '50')
+        self.assertTrue(self.client.close.called)
+
+    def test_registration_timeout(self):
+        self.client.list.return_value = {}
+        provisioner = _Provisioner({'users': '100', 'broker': 'ipc://b',
+                                    'provision_timeout': -1})
+        self.assertRaises(ProvisionError, provisioner.start)
+        self.assertEqual(provisioner.deleted, 1)
+
+
+class TestKubernetesProvisioner(unittest2.TestCase):
+
+    def setUp(self):
+        patcher = mock.patch('loads.provisioners._kubernetes.requests')
+        self.session = patcher.start().Session.return_value
+        self.session.headers = {}
+        self.addCleanup(patcher.stop)
+        self.session.post.return_value.status_code = 201
+        self.session.delete.return_value.status_code = 200
+
+        args = {'broker': 'tcp://broker:7780',
+                'provisioner_kubernetes_api': 'https://k8s/',
+                'provisioner_kubernetes_namespace': 'load',
+                'provisioner_kubernetes_image': 'me/loads',
+                'provisioner_kubernetes_token': 'secret'}
+        self.provisioner = create_provisioner('kubernetes', args)
+
+    def test_create_and_delete(self):
+        self.provisioner.create_agents(2)
+        self.assertEqual(self.session.post.call_count, 2)
+        url = self.session.post.call_args[0][0]
+        self.assertEqual(url, 'https://k8s/api/v1/namespaces/load/pods')
+        self.assertEqual(self.session.headers['Authorization'],
+                         'Bearer secret')
+
+        pod = json.loads(self.session.post.call_args[1]['data'])
+        labels = pod['metadata']['labels']
+        self.assertEqual(labels['loads-provision'], self.provisioner.id)
+        container = pod['spec']['containers'][0]
+        self.assertEqual(container['image'], 'me/loads')
+        self.assertEqual(container['command'],
+                         ['loads-agent', '--broker', 'tcp://broker:7780'])
+
+        self.provisioner.delete_agents()
+        params = self.session.delete.call_args[1]['params']
+        self.assertEqual(params['labelSelector'],
+                         'loads-provision=%s' % self.provisioner.id)
+
+    def test_api_error(self):
+        self.session.post.return_value.status_code = 403
+        self.session.post.return_value.text = 'Forbidden'
+        self.assertRaises(ProvisionError, self.provisioner.create_agents, 1)
+
+
+class TestAWSProvisioner(unittest2.TestCase):
+
+    def setUp(self):
+        self.boto3 = mock.Mock()
+        old_boto3 = sys.modules.get('boto3')
+        sys.modules['boto3'] = self.boto3
+
+        def _restore():
+            if old_boto3 is None:
+                del sys.modules['boto3']
+            else:
+                sys.modules['boto3'] = old_boto3
+        self.addCleanup(_restore)
+        self.ec2 = self.boto3.client.return_value
+        self.args = {'broker': 'tcp://broker:7780',
+                     'provisioner_aws_ami': 'ami-1234',
+                     'provisioner_aws_region': 'eu-west-1',
+                     'provisioner_aws_groups': 'sg-1, sg-2'}
+
+    def test_create_and_delete(self):
+        provisioner = create_provisioner('aws', self.args)
+        self.boto3.client.assert_called_with('ec2', region_name='eu-west-1')
+
+        self.ec2.run_instances.return_value = {
+            'Instances': [{'InstanceId': 'i-1'}, {'InstanceId': 'i-2'}]}
+        provisioner.create_agents(2)
+        options = self.ec2.run_instances.call_args[1]
+        self.assertEqual(options['ImageId'], 'ami-1234')
+        self.assertEqual(options['MaxCount'], 2)
+        self.assertEqual(options['SecurityGroupIds'], ['sg-1', 'sg-2'])
+        self.assertEqual(options['InstanceMarketOptions']['MarketType'],
+                         'spot')
+        self.assertIn('loads-agent --broker tcp://broker:7780',
+                      options['UserData'])
+        tags = options['TagSpecifications'][0]['Tags']
+        self.assertIn({'Key': 'loads-provision', 'Value': provisioner.id},
+                      tags)
+
+        provisioner.run_started('run')
+        self.ec2.create_tags.assert_called_with(
+            Resources=['i-1', 'i-2'], Tags=[{'Key': 'loads-run',
+                                             'Value': 'run'}])
+
+        self.ec2.describe_instances.return_value = {
+            'Reservations': [{'Instances': [{'InstanceId': 'i-1'}]}]}
+        provisioner.delete_agents()
+        self.ec2.terminate_instances.assert_called_with(InstanceIds=['i-1'])
+
+    def test_errors(self):
+        del self.args['provisioner_aws_ami']
+        self.assertRaises(ProvisionError, create_provisioner, 'aws',
+                          self.args)
+
+        self.args['provisioner_aws_ami'] = 'ami-1234'
+        provisioner = create_provisioner('aws', self.args)
+        self.ec2.run_instances.side_effect = Exception('No capacity')
+        self.assertRaises(ProvisionError, provisioner.create_agents, 1)
+
+    def test_launch_terminate(self):
+        self.ec2.describe_instances.return_value = {
+            'Reservations': [{'Instances': [{'InstanceId': 'i-1'}]}]}
+        res = launch(['--provider', 'aws', '--terminate', '4f5c',
+                      '--provisioner-aws-ami', 'ami-1234'])
+        self.assertEqual(res, 0)
+        filters = self.ec2.describe_instances.call_args[1]['Filters']
+        self.assertEqual(filters[0]['Values'], ['4f5c'])
+        self.ec2.terminate_instances.assert_called_with(InstanceIds=['i-1'])
@@ -0,0 +1,136 @@
+import os
+import socket
+import tempfile
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import mock
+import unittest2
+from requests.exceptions import ConnectionError
+
+from loads import proxies
+from loads.case import TestCase
+from loads.proxies import (ProxyConnectError, ProxyList, get_proxy_list,
+                           is_proxy_error, parse_proxy, read_proxies)
+from loads.record import Recorder, RecordingProxy
+
+
+class _Test(TestCase):
+
+    def test_proxy(self):
+        pass
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+def _closed_port():
+    sock = socket.socket()
+    sock.bind(('127.0.0.1', 0))
+    port = sock.getsockname()[1]
+    sock.close()
+    return port
+
+
+class TestProxies(unittest2.TestCase):
+
+    def setUp(self):
+        self.addCleanup(proxies._LISTS.clear)
+
+    def test_parse(self):
+        self.assertEqual(parse_proxy('egress:3128'), 'http://egress:3128')
+        self.assertEqual(parse_proxy(' socks5://u:p@gw:1080 '),
+                         'socks5://u:p@gw:1080')
+        self.assertRaises(ValueError, parse_proxy, 'ftp://egress')
+        self.assertRaises(ValueError, parse_proxy, 'http://')
+
+    def test_read(self):
+        fd, path = tempfile.mkstemp()
+        self.addCleanup(os.remove, path)
+        os.write(fd, '# paris\nhttp://fr:3128\n\nsocks5://de:1080  # berlin\n')
+        os.close(fd)
+        self.assertEqual(read_proxies(path),
+                         ['http://fr:3128', 'socks5://de:1080'])
+
+        with open(path, 'w') as f:
+            f.write('# none\n')
+        self.assertRaises(ValueError, read_proxies, path)
+
+    def test_list(self):
+        proxy_list = ProxyList(['a:1', 'b:2'])
+        self.assertEqual([proxy_list.next() for index in range(3)],
+                         ['http://a:1', 'http://b:2', 'http://a:1'])
+        proxy_list = ProxyList(['a:1', 'b:2'], 'random')
+        self.assertIn(proxy_list.next(), proxy_list.proxies)
+        self.assertRaises(ValueError, ProxyList, [])
+        self.assertRaises(ValueError, ProxyList, ['a:1'], 'sticky')
+
+    def test_virtual_users(self):
+        self.assertEqual(get_proxy_list({}), None)
+        config = {'proxy': ['http://a:1', 'http://b:2']}
+        self.assertTrue(get_proxy_list(config) is get_proxy_list(config))
+
+        first = _Test('test_proxy', config=config)
+        second = _Test('test_proxy', config=config)
+        self.assertEqual(first.session.proxies,
+                         {'http': 'http://a:1', 'https': 'http://a:1'})
+        self.assertEqual(second.session.proxies['http'], 'http://b:2')
+
+    def test_route(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        recorder = Recorder()
+        proxy = RecordingProxy(recorder, '127.0.0.1:0')
+        proxy.start()
+        self.addCleanup(proxy.stop)
+
+        test = _Test('test_proxy', config={'proxy': [proxy.address]})
+        self.addCleanup(test.session.close)
+        url = 'http://127.0.0.1:%d/' % server.server_address[1]
+        self.assertEqual(test.session.get(url).text, 'OK')
+        entry, = recorder.entries
+        self.assertEqual(entry['request']['url'], url)
+
+    def test_errors(self):
+        result = mock.Mock()
+        proxy = 'http://127.0.0.1:%d' % _closed_port()
+        test = _Test('test_proxy', test_result=result,
+                     config={'proxy': [proxy]})
+        url = 'http://127.0.0.1:%d/' % _closed_port()
+        self.assertRaises(ProxyConnectError, test.session.get, url)
+        self.assertIn('proxy-errors', [call[0][2] for call in
+                                       result.incr_counter.call_args_list])
+
+        # the errors of the target are not the ones of the proxy
+        result = mock.Mock()
+        test = _Test('test_proxy', test_result=result)
+        try:
+            test.session.get(url)
+        except ConnectionError, e:
+            self.assertNotIsInstance(e, ProxyConnectError)
+        else:
+            self.fail('No error')
+        self.assertNotIn('proxy-errors', [call[0][2] for call in
+                                          result.incr_counter.call_args_list])
+
+    def test_socks_errors(self):
+        reason = mock.Mock(reason=Exception(
+            'Failed to establish a new connection: Error connecting to '
+            'SOCKS5 proxy gw:1080: [Errno 111] Connection refused'))
+        self.assertTrue(is_proxy_error(ConnectionError(reason)))
+        reason = mock.Mock(reason=Exception(
+            'Failed to establish a new connection: 0x04: Host unreachable'))
+        self.assertFalse(is_proxy_error(ConnectionError(reason)))
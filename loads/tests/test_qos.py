@@ -0,0 +1,110 @@
+import socket
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.dualstack import create_connection
+from loads.engines.tcp import TCPClient
+from loads.engines.udp import UDPClient
+from loads.qos import (IPV6_TCLASS, IPV6_UNICAST_HOPS, IPOptions,
+                       get_ip_options, parse_hop_limit, parse_traffic_class)
+
+
+def _listen(family, host):
+    sock = socket.socket(family, socket.SOCK_STREAM)
+    sock.bind((host, 0))
+    sock.listen(5)
+    return sock
+
+
+def _get_ip_options(sock):
+    if sock.family == socket.AF_INET6:
+        return (sock.getsockopt(socket.IPPROTO_IPV6, IPV6_UNICAST_HOPS),
+                sock.getsockopt(socket.IPPROTO_IPV6, IPV6_TCLASS))
+    return (sock.getsockopt(socket.IPPROTO_IP, socket.IP_TTL),
+            sock.getsockopt(socket.IPPROTO_IP, socket.IP_TOS))
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    # the connection is kept, so the test can look at its socket
+    protocol_version = 'HTTP/1.1'
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_qos(self):
+        pass
+
+
+class TestQoS(unittest2.TestCase):
+
+    def test_parse(self):
+        self.assertEqual(parse_traffic_class('EF'), 184)
+        self.assertEqual(parse_traffic_class('af41'), 136)
+        self.assertEqual(parse_traffic_class('CS1'), 32)
+        self.assertEqual(parse_traffic_class('0x28'), 40)
+        self.assertEqual(parse_traffic_class(4), 4)
+        self.assertRaises(ValueError, parse_traffic_class, 'AF51')
+        self.assertRaises(ValueError, parse_traffic_class, '256')
+        self.assertEqual(parse_hop_limit('2'), 2)
+        self.assertRaises(ValueError, parse_hop_limit, '0')
+
+    def test_options(self):
+        self.assertEqual(get_ip_options({}), None)
+        options = get_ip_options({'traffic_class': 184})
+        self.assertEqual(options.get_socket_options(socket.AF_INET6),
+                         [(socket.IPPROTO_IPV6, IPV6_TCLASS, 184)])
+        self.assertEqual(options.get_socket_options(socket.AF_INET),
+                         [(socket.IPPROTO_IP, socket.IP_TOS, 184)])
+        self.assertEqual(options.get_socket_options(socket.AF_UNIX), [])
+
+    def test_connections(self):
+        options = IPOptions(hop_limit=3, traffic_class=184)
+        for family, host in ((socket.AF_INET, '127.0.0.1'),
+                             (socket.AF_INET6, '::1')):
+            server = _listen(family, host)
+            self.addCleanup(server.close)
+            port = server.getsockname()[1]
+
+            sock = create_connection((host, port), None, ip_options=options)
+            self.addCleanup(sock.close)
+            self.assertEqual(_get_ip_options(sock), (3, 184))
+
+            client = TCPClient(host, port, ip_options=options)
+            self.addCleanup(client.close)
+            client.connect()
+            self.assertEqual(_get_ip_options(client._socket), (3, 184))
+
+            client = UDPClient(host, port, ip_options=options)
+            self.addCleanup(client.close)
+            self.assertEqual(_get_ip_options(client._socket), (3, 184))
+
+    def test_session(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+
+        test = _Test('test_qos', config={'hop_limit': 5,
+                                         'traffic_class': 40})
+        self.addCleanup(test.session.close)
+        url = 'http://127.0.0.1:%d/' % server.server_address[1]
+        test.session.get(url)
+        adapter = test.session.get_adapter(url)
+        pool = adapter.poolmanager.connection_from_url(url)
+        connection = pool.pool.get()
+        self.assertEqual(_get_ip_options(connection.sock), (5, 40))
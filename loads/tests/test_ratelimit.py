@@ -0,0 +1,87 @@
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.ratelimit import LIMITER, RateLimiter, get_max_rps
+from loads.results import TestResult
+
+
+class _Clock(object):
+
+    def __init__(self):
+        self.now = 0.
+        self.sleeps = []
+
+    def __call__(self):
+        return self.now
+
+    def sleep(self, seconds):
+        self.sleeps.append(seconds)
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_rate(self):
+        pass
+
+
+class TestRateLimiter(unittest2.TestCase):
+
+    def test_limiter(self):
+        clock = _Clock()
+        limiter = RateLimiter(4, clock=clock, sleep=clock.sleep)
+        self.assertEqual([limiter.acquire() for index in range(3)],
+                         [0, .25, .5])
+
+        # the slots missed are not made up for
+        clock.now += 10
+        self.assertEqual(limiter.acquire(), 0)
+        self.assertEqual(limiter.acquire(), .25)
+        self.assertEqual(clock.sleeps, [.25, .5, .25])
+
+        limiter.set_rate(None)
+        self.assertEqual(limiter.acquire(), 0)
+        limiter.set_rate(1)
+        self.assertEqual([limiter.acquire() for index in range(2)], [0, 1])
+
+    def test_max_rps(self):
+        self.assertEqual(get_max_rps({}), None)
+        self.assertEqual(get_max_rps({'max_rps': 300}), 300)
+        self.assertEqual(get_max_rps({'max_rps': 300,
+                                      'agent_max_rps': 100}), 100)
+
+    def test_session(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+
+        clock = _Clock()
+        self.addCleanup(setattr, LIMITER, 'clock', LIMITER.clock)
+        self.addCleanup(setattr, LIMITER, '_sleep', None)
+        self.addCleanup(LIMITER.set_rate, None)
+        LIMITER.clock, LIMITER._sleep = clock, clock.sleep
+        LIMITER.set_rate(10)
+
+        test = _Test('test_rate', test_result=TestResult())
+        self.addCleanup(test.session.close)
+        for index in range(3):
+            test.session.get('http://127.0.0.1:%d/' %
+                             server.server_address[1])
+        self.assertEqual(clock.sleeps, [.1, .2])
@@ -0,0 +1,139 @@
+import datetime
+import httplib
+import os
+import shutil
+import ssl
+import subprocess
+import tempfile
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.record import CertificateAuthority, RecordingProxy, Recorder
+
+
+def _has_openssl():
+    try:
+        subprocess.call(['openssl', 'version'], stdout=subprocess.PIPE)
+        return True
+    except OSError:
+        return False
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self._send('{"token": "3f2b8c9d4e5f6071"}')
+
+    def do_POST(self):
+        self._send(self.rfile.read(int(self.headers['Content-Length'])))
+
+    def _send(self, body):
+        self.send_response(200)
+        self.send_header('Content-Type', 'application/json')
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+class TestRecord(unittest2.TestCase):
+
+    def _serve(self, server):
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        return server.server_address[1]
+
+    def _start(self, ca=None):
+        self.recorder = Recorder()
+        proxy = RecordingProxy(self.recorder, '127.0.0.1:0', ca=ca,
+                               verify=False)
+        proxy.start()
+        self.addCleanup(proxy.stop)
+        return int(proxy.address.split(':')[1])
+
+    def test_http(self):
+        port = self._serve(HTTPServer(('127.0.0.1', 0), _Handler))
+        proxy_port = self._start()
+        url = 'http://127.0.0.1:%d' % port
+
+        connection = httplib.HTTPConnection('127.0.0.1', proxy_port)
+        connection.request('GET', url + '/login?next=/')
+        self.assertEqual(connection.getresponse().read(),
+                         '{"token": "3f2b8c9d4e5f6071"}')
+        connection.request('POST', url + '/login', '3f2b8c9d4e5f6071',
+                           {'Content-Type': 'text/plain',
+                            'Proxy-Connection': 'keep-alive'})
+        self.assertEqual(connection.getresponse().read(), '3f2b8c9d4e5f6071')
+        connection.close()
+
+        first, second = self.recorder.entries
+        self.assertEqual(first['request']['queryString'],
+                         [{'name': 'next', 'value': '/'}])
+        self.assertEqual(first['response']['content']['text'],
+                         '{"token": "3f2b8c9d4e5f6071"}')
+        self.assertEqual(second['request']['postData'],
+                         {'mimeType': 'text/plain',
+                          'text': '3f2b8c9d4e5f6071'})
+        self.assertNotIn('Proxy-Connection', [
+            header['name'] for header in second['request']['headers']])
+
+        source = self.recorder.write_test('login')
+        compile(source, 'test_login.py', 'exec')
+        self.assertIn("self.session.get('%s/login?next=/')" % url, source)
+
+    def test_idle_gaps(self):
+        recorder = Recorder()
+        started = datetime.datetime(2013, 5, 14)
+        for seconds in (0, .1, 3, 3.2):
+            recorder.add(started + datetime.timedelta(seconds=seconds), .05,
+                         'GET', 'http://app/%s' % seconds, [], '', 200, 'OK',
+                         [('Content-Type', 'image/png')], 'PNG')
+
+        source = recorder.write_test('gaps')
+        self.assertIn('# Step 2', source)
+        self.assertIn('gevent.sleep(2.9)', source)
+        self.assertNotIn('# Step 2', recorder.write_test('gaps',
+                                                         min_think_time=5))
+        self.assertNotIn('http://app', recorder.write_test('gaps',
+                                                           skip_static=True))
+
+    def test_https(self):
+        if not _has_openssl():
+            raise unittest2.SkipTest('openssl is not installed')
+        directory = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, directory)
+        ca = CertificateAuthority(directory)
+        self.assertFalse(ca.exists())
+        ca.create()
+        self.assertTrue(ca.exists())
+
+        certificate, key = ca.get_certificate('localhost')
+        self.assertEqual(ca.get_certificate('localhost'), (certificate, key))
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        server.socket = ssl.wrap_socket(server.socket, keyfile=key,
+                                        certfile=certificate,
+                                        server_side=True)
+        port = self._serve(server)
+        proxy_port = self._start(ca)
+
+        # the client trusts the authority of the proxy
+        context = ssl.create_default_context(cafile=ca.certificate)
+        connection = httplib.HTTPSConnection('127.0.0.1', proxy_port,
+                                             context=context)
+        connection.set_tunnel('localhost', port)
+        connection.request('GET', '/secret')
+        self.assertEqual(connection.getresponse().status, 200)
+        connection.close()
+
+        entry, = self.recorder.entries
+        self.assertEqual(entry['request']['url'],
+                         'https://localhost:%d/secret' % port)
+        self.assertTrue(os.path.exists(os.path.join(directory, 'hosts',
+                                                    'localhost.pem')))
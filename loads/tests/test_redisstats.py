@@ -0,0 +1,100 @@
+import sys
+
+import mock
+import unittest2
+
+from loads.tests.test_dashboard import _hit, _Clock
+from loads.transport.redisstats import RedisLiveStats
+
+
+class FakeRedis(object):
+    """Enough of a StrictRedis for the live stats, shared by all the
+    instances like a server would be."""
+    hashes = {}
+
+    def __init__(self, host=None, port=None):
+        pass
+
+    def pipeline(self):
+        return self
+
+    def execute(self):
+        pass
+
+    def hset(self, key, field, value):
+        self.hashes.setdefault(key, {})[field] = str(value)
+
+    def hgetall(self, key):
+        return dict(self.hashes.get(key, {}))
+
+    def hdel(self, key, field):
+        self.hashes.get(key, {}).pop(field, None)
+
+    def expire(self, key, seconds):
+        pass
+
+
+class TestRedisLiveStats(unittest2.TestCase):
+
+    def setUp(self):
+        redis = mock.Mock(StrictRedis=FakeRedis)
+        with mock.patch.dict(sys.modules, {'redis': redis}):
+            self.clock = _Clock()
+            self.stats = self._get_stats()
+            self.standby = self._get_stats()
+        self.addCleanup(FakeRedis.hashes.clear)
+
+    def _get_stats(self):
+        return RedisLiveStats('redis:6379', window=10, clock=self.clock)
+
+    def _hit(self, run_id='run', **kw):
+        hit = _hit(**kw)
+        hit['run_id'] = run_id
+        return hit
+
+    def test_snapshot(self):
+        for elapsed in (.1, .2, .3):
+            self.stats.add(self._hit(elapsed=elapsed), 'team-a')
+        self.stats.add(self._hit(run_id='other', status=500, agent_id='2',
+                                 scenario='test_search'), 'team-b')
+        self.stats.add({'data_type': 'startTest', 'agent_id': '1',
+                        'run_id': 'run', 'test': 'test_es (module.TestSite)'},
+                       'team-a')
+
+        # the stats of every broker writing to the same Redis
+        snapshot = self.standby.snapshot()
+        total = snapshot['total']
+        self.assertEqual(total['users'], 1)
+        self.assertEqual(total['rps'], .4)
+        self.assertEqual(total['error_rate'], 25.)
+        self.assertAlmostEqual(total['p50'], 200, 0)
+        self.assertEqual(sorted(snapshot['agents']), ['1', '2'])
+
+        snapshot = self.stats.snapshot('team-b')
+        self.assertEqual(snapshot['total']['rps'], .1)
+        self.assertEqual(sorted(snapshot['scenarios']), ['test_search'])
+
+        # out of the window
+        self.clock.now += 11
+        self.assertEqual(self.stats.snapshot()['total']['rps'], 0)
+
+    def test_totals(self):
+        self.stats.add(self._hit(elapsed=.1))
+        self.clock.now += 20
+        self.stats.add(self._hit(elapsed=.3, status=500))
+        self.stats.add({'data_type': 'batch', 'run_id': 'run',
+                        'agent_id': '1',
+                        'counts': {'stopTest': [{'test': 'test_es (m.T)'},
+                                                {'test': 'test_es (m.T)'}]}})
+
+        # a standby taking over goes on with the totals in Redis
+        self.standby.add({'data_type': 'addFailure', 'run_id': 'run',
+                     'agent_id': '1', 'test': 'test_es (module.T)'})
+
+        totals = self.stats.get_totals('run')
+        self.assertEqual(sorted(totals['scenarios']), ['test_es'])
+        total = totals['total']
+        self.assertEqual((total['hits'], total['errors'], total['tests'],
+                          total['failures']), (2, 1, 2, 1))
+        self.assertEqual(total['updated'] - total['started'], 20)
+        self.assertEqual(total['histogram'].total_count, 2)
@@ -0,0 +1,88 @@
+import os
+import tempfile
+
+import mock
+import unittest2
+
+from loads.engines.replay import LogReplayer, parse_line
+
+
+_LOG = """\
+10.0.0.1 - - [14/May/2013:00:51:08 +0000] "GET /users?page=2 HTTP/1.1" 200 \
+512 "http://app/" "Mozilla/5.0"
+10.0.0.2 - bob [14/May/2013:00:51:10 +0000] "POST /login HTTP/1.1" 302 0
+garbage
+{"time": 1368492674.5, "method": "DELETE", "path": "/users/42"}
+{"@timestamp": "2013-05-14T00:51:16Z", "request": "GET / HTTP/1.1"}
+"""
+
+
+class _FakeClock(object):
+
+    def __init__(self):
+        self.now = 0.
+        self.sleeps = []
+
+    def time(self):
+        return self.now
+
+    def sleep(self, delay):
+        self.sleeps.append(delay)
+        self.now += delay
+
+
+class TestReplay(unittest2.TestCase):
+
+    def setUp(self):
+        fd, self.path = tempfile.mkstemp()
+        os.write(fd, _LOG)
+        os.close(fd)
+        self.addCleanup(os.remove, self.path)
+        self.clock = _FakeClock()
+        self.test_case = mock.Mock()
+
+    def _replay(self, **options):
+        replayer = LogReplayer(self.path, 'http://staging:8080/',
+                               test_case=self.test_case,
+                               clock=self.clock.time, sleep=self.clock.sleep,
+                               **options)
+        self.addCleanup(replayer.close)
+        return replayer.replay()
+
+    def test_parse_line(self):
+        entry = parse_line(_LOG.splitlines()[0])
+        self.assertEqual((entry.timestamp, entry.method, entry.path),
+                         (1368492668, 'GET', '/users?page=2'))
+        self.assertEqual(entry.get_headers(), {'Referer': 'http://app/',
+                                               'User-Agent': 'Mozilla/5.0'})
+        self.assertEqual(parse_line(_LOG.splitlines()[1]).get_headers(), {})
+        self.assertEqual(parse_line(_LOG.splitlines()[3]).method, 'DELETE')
+        self.assertEqual(parse_line('garbage'), None)
+        self.assertEqual(parse_line('{}', format='json'), None)
+
+    def test_speed(self):
+        self.assertEqual(self._replay(speed=2.), 4)
+
+        # the gaps of the log, halved
+        self.assertEqual(self.clock.sleeps, [1., 2.25, .75])
+        requests = self.test_case.session.request.call_args_list
+        self.assertEqual([args for args, kw in requests],
+                         [('GET', 'http://staging:8080/users?page=2'),
+                          ('POST', 'http://staging:8080/login'),
+                          ('DELETE', 'http://staging:8080/users/42'),
+                          ('GET', 'http://staging:8080/')])
+        self.assertEqual(requests[0][1]['headers']['User-Agent'],
+                         'Mozilla/5.0')
+        self.test_case.incr_counter.assert_called_once_with('replay-skipped')
+
+    def test_rate(self):
+        self.test_case.session.request.side_effect = IOError('refused')
+        self.assertEqual(self._replay(rate=10, methods=['get']), 2)
+        self.assertEqual(self.clock.sleeps, [.1])
+        self.test_case.incr_counter.assert_called_with('replay-errors')
+
+    def test_options(self):
+        self.assertRaises(ValueError, LogReplayer, self.path, 'http://app',
+                          test_case=self.test_case, format='xml')
+        self.assertRaises(ValueError, LogReplayer, self.path, 'http://app',
+                          test_case=self.test_case, speed=0)
@@ -0,0 +1,120 @@
+import datetime
+import os
+import shutil
+import tempfile
+
+import unittest2
+
+from loads.output import FileOutput
+from loads.report import (Report, from_records, get_threshold_results,
+                          read_results, render, main)
+from loads.tests.support import get_tb, hush
+from loads.thresholds import parse_thresholds
+
+
+TIME1 = datetime.datetime(2013, 5, 14, 0, 51, 8)
+_1 = datetime.timedelta(seconds=1)
+_TEST = 'test_es (module.TestSite)'
+
+
+class TestReport(unittest2.TestCase):
+
+    def setUp(self):
+        self.tmpdir = tempfile.mkdtemp()
+        self.results = os.path.join(self.tmpdir, 'results.log')
+
+        output = FileOutput(None, {'output_file_filename': self.results})
+        for second, status, elapsed in ((0, 200, .1), (0, 200, .2),
+                                        (2, 500, .3)):
+            output.push('add_hit', url='http://a', method='GET',
+                        status=status, started=TIME1 + second * _1,
+                        elapsed=datetime.timedelta(seconds=elapsed),
+                        loads_status=(1, 1, 1, 1))
+        output.push('startTest', _TEST, (1, 1, 1, 1))
+        output.push('addSuccess', _TEST, (1, 1, 1, 1))
+        output.push('addFailure', _TEST, get_tb(), (1, 1, 1, 1))
+        output.push('add_check', name='status == 200', passed=False)
+        output.flush()
+
+    def tearDown(self):
+        shutil.rmtree(self.tmpdir)
+
+    def test_report(self):
+        report = Report(read_results(self.results))
+        self.assertEqual(len(report.hits), 3)
+        self.assertEqual(report.start, TIME1)
+        self.assertAlmostEqual(report.duration, 2.3)
+
+        timeline = report.get_timeline()
+        self.assertEqual([(second, hits, errors) for second, hits, errors,
+                          histogram in timeline],
+                         [(0, 2, 0), (1, 0, 0), (2, 1, 1)])
+        self.assertEqual(timeline[1][3], None)
+
+        stats = report.get_url_stats()
+        self.assertEqual([stat[:3] for stat in stats],
+                         [('All', 3, 1), ('http://a', 3, 1)])
+        self.assertEqual(report.get_status_errors(), {('http://a', 500): 1})
+        groups = report.test_result.get_error_groups()
+        self.assertEqual(groups['5xx']['count'], 1)
+        self.assertEqual(groups['other']['examples'],
+                         ['Exception: Error message'])
+
+        scenarios = report.get_scenarios()
+        scenario = scenarios['test_es']
+        self.assertEqual((scenario['success'], scenario['failures'],
+                          scenario['errors']), (1, 1, 0))
+        self.assertEqual(scenario['messages'].keys(),
+                         ["<type 'exceptions.Exception'>: Error message"])
+
+    def test_render(self):
+        html = render(Report(read_results(self.results)), 'My <run>')
+        self.assertTrue(html.startswith('<!DOCTYPE html>'))
+        self.assertTrue('<h1>My &lt;run&gt;</h1>' in html)
+        self.assertTrue('<svg' in html)
+        for section in ('Latency over time', 'Request times (ms)',
+                        'Failed requests', 'Errors by category', 'Checks',
+                        'Scenario test_es'):
+            self.assertTrue(section in html, section)
+
+        # no external resources
+        self.assertFalse('src=' in html)
+        self.assertFalse('href=' in html)
+
+    def test_empty_results(self):
+        open(self.results, 'w').close()
+        html = render(Report(read_results(self.results)))
+        self.assertTrue('No data.' in html)
+
+    def test_thresholds(self):
+        records = [{'data_type': 'add_hit', 'url': 'http://a',
+                    'method': 'GET', 'status': 200, 'elapsed': elapsed,
+                    'scenario': scenario}
+                   for scenario, elapsed in (('test_a', .1), ('test_a', .1),
+                                             ('test_b', .5))]
+        thresholds = parse_thresholds(['p50 < 250ms', 'max < 1s'])
+        results = get_threshold_results(from_records(records), thresholds)
+        self.assertEqual([(scenario, str(threshold), passed)
+                          for scenario, threshold, value, passed in results],
+                         [('All', 'p50 < 250ms', True),
+                          ('All', 'max < 1s', True),
+                          ('test_a', 'p50 < 250ms', True),
+                          ('test_a', 'max < 1s', True),
+                          ('test_b', 'p50 < 250ms', False),
+                          ('test_b', 'max < 1s', True)])
+
+        html = render(Report(from_records(records)), thresholds=results)
+        self.assertTrue('<h2>Thresholds</h2>' in html)
+        self.assertTrue('<tr class="failed"><td>test_b</td>'
+                        '<td>p50 &lt; 250ms</td><td>500.0ms</td>'
+                        '<td>FAILED</td></tr>' in html)
+
+    @hush
+    def test_main(self):
+        filename = os.path.join(self.tmpdir, 'report.html')
+        self.assertEqual(main([self.results, '-o', filename,
+                               '--threshold', 'p95 < 1s']), 0)
+        with open(filename) as f:
+            html = f.read()
+        self.assertTrue('Scenario test_es' in html)
+        self.assertTrue('<td>All</td><td>p95 &lt; 1s</td>' in html)
@@ -1,11 +1,13 @@
 from unittest2 import TestCase
+import errno
 import traceback
+import zlib
 from StringIO import StringIO
 import zmq.green as zmq
 
-from loads.results import ZMQTestResult
+from loads.results import ZMQTestResult, ZMQSummarizedTestResult
 from loads.tests.support import get_tb, hush
-from loads.util import json
+from loads.util import json, unbatch, unpack_message
 
 import mock
 
@@ -139,3 +141,57 @@ class TestZmqRelay(TestCase):
         self.context.destroy()
         args = {'foo': 'bar', 'baz': 'foobar'}
         self.assertRaises(zmq.ZMQError, self.relay.add_hit, **args)
+
+
+class TestZmqSummarizedRelay(TestCase):
+
+    def setUp(self):
+        patcher = mock.patch('loads.results.zmqrelay.gevent.spawn_later')
+        patcher.start()
+        self.addCleanup(patcher.stop)
+
+        self.context = zmq.Context()
+        self.relay = ZMQSummarizedTestResult(
+            args={'zmq_receiver': 'inproc://ok', 'zmq_context': self.context,
+                  'batch_size': 3, 'compress_results': True})
+        self.relay._push = self.push = mock.Mock()
+        self.addCleanup(self.relay.close)
+
+    def _add_hits(self, count):
+        for index in range(count):
+            self.relay.add_hit(url='http://host', method='GET', status=200,
+                               started='2013-01-01T00:00:00',
+                               elapsed=index / 10., loads_status=[1, 1, 1, 1],
+                               phases={'connect': 0.})
+
+    def _get_sent(self):
+        return json.loads(unpack_message(self.push.send.call_args[0][0]))
+
+    def test_batch_size(self):
+        self._add_hits(2)
+        self.assertFalse(self.push.send.called)
+        self._add_hits(1)
+
+        payload = self.push.send.call_args[0][0]
+        self.assertEqual(self.push.send.call_args[0][1], zmq.NOBLOCK)
+        data = json.loads(zlib.decompress(payload))
+        self.assertEqual(len(data['counts']['add_hit']), 3)
+
+    def test_backpressure(self):
+        self.push.send.side_effect = [zmq.ZMQError(errno.EAGAIN), None, None]
+        self._add_hits(3)
+        self.assertTrue(self.relay.degraded)
+
+        # the broker caught up: the hits of both batches are summarized
+        self._add_hits(3)
+        self.assertFalse(self.relay.degraded)
+        data = self._get_sent()
+        self.assertNotIn('add_hit', data['counts'])
+        summary, = data['counts']['add_hits']
+        self.assertEqual(summary['elapsed'], [0., .1, .2, 0., .1, .2])
+        self.assertNotIn('phases', summary)
+
+        hits = [message for field, message in unbatch(data)]
+        self.assertEqual(len(hits), 6)
+        self.assertEqual(hits[1]['elapsed'], .1)
+        self.assertEqual(hits[1]['url'], 'http://host')
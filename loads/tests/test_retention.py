@@ -0,0 +1,133 @@
+import unittest2
+import os
+import shutil
+import tempfile
+
+from zmq.green.eventloop import ioloop
+from loads.db._python import BrokerDB
+from loads.db._sqlite import SQLiteDB
+from loads.db.retention import (parse_retention, get_resolution, downsample,
+                                apply_retention, main, _timestamp)
+from loads.histogram import Histogram
+from loads.schedule import summarize_run
+from loads.tests.support import hush
+
+
+# a day after the hits
+_NOW = _timestamp('2013-06-27T10:11:00')
+
+
+def _get_hits():
+    hits = []
+    for second in range(4):
+        for index in range(5):
+            hits.append({'data_type': 'add_hit', 'run_id': '1',
+                         'agent_id': 1, 'url': 'http://127.0.0.1:9200/',
+                         'method': 'GET', 'status': 200,
+                         'started': '2013-06-26T10:11:0%d.%06d' %
+                                    (second, index),
+                         'elapsed': (second * 5 + index + 1) / 100.})
+    return hits
+
+
+class TestRetention(unittest2.TestCase):
+
+    def setUp(self):
+        self.loop = ioloop.IOLoop()
+        self.tmp = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.tmp)
+
+    def test_parse_retention(self):
+        self.assertEqual(parse_retention('1s:24h,1m'),
+                         [(1, 86400), (60, None)])
+        self.assertEqual(parse_retention('raw:1h,1s:1d,1m:30d'),
+                         [(0, 3600), (1, 86400), (60, 30 * 86400)])
+        for value in ('1m:24h,1s', '1s,1m', '1s:1d,1m:1h', '7s:1h,1m',
+                      '0s', 'nope'):
+            self.assertRaises(ValueError, parse_retention, value)
+
+        steps = parse_retention('raw:1h,1s:24h,1m')
+        self.assertEqual(get_resolution(steps, 60), 0)
+        self.assertEqual(get_resolution(steps, 7200), 1)
+        self.assertEqual(get_resolution(steps, 86400 * 7), 60)
+
+    def test_downsample(self):
+        other = {'data_type': 'addSuccess', 'run_id': '1'}
+        records = list(downsample(_get_hits() + [other],
+                                  parse_retention('1s:2d,1m'), _NOW))
+        self.assertEqual(records[0], other)
+        self.assertEqual([(record['started'], record['size'])
+                          for record in records[1:]],
+                         [('2013-06-26T10:11:0%d' % second, 5)
+                          for second in range(4)])
+        self.assertAlmostEqual(records[1]['elapsed'], .03)
+
+        # the minutes are merged from the seconds, with the same
+        # histogram as the one of the hits
+        minutes = list(downsample(records, parse_retention('1s:1m,1m'),
+                                  _NOW))
+        self.assertEqual(len(minutes), 2)
+        minute = minutes[1]
+        self.assertEqual((minute['started'], minute['interval'],
+                          minute['size']), ('2013-06-26T10:11:00', 60, 20))
+        histogram = Histogram()
+        for hit in _get_hits():
+            histogram.record_value(hit['elapsed'] * 10 ** 6)
+        self.assertEqual(Histogram.from_dict(minute['histogram']).to_dict(),
+                         histogram.to_dict())
+        self.assertAlmostEqual(minute['elapsed'], .105)
+
+        # and they are summarized like the hits
+        summary = summarize_run(minutes)
+        self.assertEqual(summary['hits'], 20)
+        self.assertAlmostEqual(summary['p50'], .1, places=3)
+        self.assertAlmostEqual(summary['p95'], .19, places=3)
+
+        # the young hits are kept as they are
+        hits = _get_hits()
+        self.assertEqual(list(downsample(hits, parse_retention('raw:2d,1m'),
+                                         _NOW)), hits)
+
+    def _prune(self, db):
+        for hit in _get_hits():
+            db.add(hit)
+        db.add({'data_type': 'addSuccess', 'run_id': '1'})
+        db.save_metadata('2', {'ended': _NOW - 86400 * 31})
+
+        steps = parse_retention('1s:1h,1m:30d')
+        self.assertEqual(apply_retention(db, steps, _NOW), (1, 19))
+        self.assertEqual(db.get_runs(), ['1'])
+        records = list(db.get_data('1'))
+        self.assertEqual(len(records), 2)
+        self.assertEqual(sorted([record['data_type'] for record in records]),
+                         ['addSuccess', 'add_hit'])
+        self.assertEqual(db.get_counts('1')['add_hit'], 20)
+        self.assertEqual(apply_retention(db, steps, _NOW), (0, 0))
+
+    def test_python_db(self):
+        db = BrokerDB(self.loop, directory=self.tmp)
+        self.addCleanup(db.close)
+        self._prune(db)
+
+    def test_sqlite_db(self):
+        db = SQLiteDB(self.loop, path=os.path.join(self.tmp, 'loads.db'))
+        self.addCleanup(db.close)
+        self._prune(db)
+        intervals = db.get_intervals('1', interval=60.)
+        self.assertEqual(intervals[0]['hits'], 20)
+        self.assertAlmostEqual(intervals[0]['elapsed'], .105)
+
+    @hush
+    def test_main(self):
+        path = os.path.join(self.tmp, 'loads.db')
+        db = SQLiteDB(self.loop, path=path)
+        db.save_metadata('1', {'ended': 0})
+        db.close()
+
+        self.assertEqual(main(['--db', 'sqlite', '--db-sqlite-path', path,
+                               '--retention', '1s:1h,1m:30d']), 0)
+        db = SQLiteDB(self.loop, path=path)
+        self.addCleanup(db.close)
+        self.assertEqual(db.get_runs(), [])
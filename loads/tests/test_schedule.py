@@ -0,0 +1,66 @@
+import time
+from datetime import datetime
+
+import unittest2
+
+from loads.schedule import Cron, parse_at, parse_cron, summarize_run
+
+
+def _timestamp(*when):
+    return time.mktime(datetime(*when).timetuple())
+
+
+def _next(expression, *when):
+    return datetime.fromtimestamp(Cron(expression).next(_timestamp(*when)))
+
+
+class TestSchedule(unittest2.TestCase):
+
+    def test_cron(self):
+        self.assertEqual(_next('0 2 * * *', 2026, 10, 14, 1, 30),
+                         datetime(2026, 10, 14, 2, 0))
+        self.assertEqual(_next('0 2 * * *', 2026, 10, 14, 2, 0),
+                         datetime(2026, 10, 15, 2, 0))
+        self.assertEqual(_next('*/15 * * * *', 2026, 10, 14, 1, 31, 10),
+                         datetime(2026, 10, 14, 1, 45))
+        self.assertEqual(_next('30 3 * * mon-fri', 2026, 10, 17, 0, 0),
+                         datetime(2026, 10, 19, 3, 30))
+        self.assertEqual(_next('0 0 29 feb *', 2026, 1, 1),
+                         datetime(2028, 2, 29))
+        # the days of the month or of the week
+        self.assertEqual(_next('0 0 1 * 0', 2026, 10, 14),
+                         datetime(2026, 10, 18))
+        self.assertEqual(_next('@monthly', 2026, 12, 14),
+                         datetime(2027, 1, 1))
+        self.assertEqual(_next('0 9 * * 7', 2026, 10, 14),
+                         datetime(2026, 10, 18, 9, 0))
+
+        for expression in ('0 2 * *', '60 * * * *', '0 0 31 feb *',
+                           '*/0 * * * *', '0 0 * * sat-sun'):
+            self.assertRaises(ValueError, lambda: Cron(expression).next())
+        self.assertEqual(parse_cron(' @daily '), '@daily')
+
+    def test_at(self):
+        now = _timestamp(2026, 10, 14, 12, 0)
+        self.assertEqual(parse_at('+2h', now), now + 7200)
+        self.assertEqual(parse_at('2026-10-15 02:00', now),
+                         _timestamp(2026, 10, 15, 2, 0))
+        self.assertEqual(parse_at('2026-10-15T02:00:30', now),
+                         _timestamp(2026, 10, 15, 2, 0, 30))
+        self.assertEqual(parse_at('13:00', now),
+                         _timestamp(2026, 10, 14, 13, 0))
+        self.assertEqual(parse_at('02:00', now),
+                         _timestamp(2026, 10, 15, 2, 0))
+        self.assertRaises(ValueError, parse_at, 'tomorrow', now)
+
+    def test_summarize(self):
+        records = [{'data_type': 'add_hit', 'elapsed': .1, 'status': 200},
+                   {'data_type': 'add_hits', 'elapsed': [.2, .4],
+                    'status': 503},
+                   {'data_type': 'add_hit', 'elapsed': .3, 'status': 'OK'},
+                   {'data_type': 'stopTest'}, {'data_type': 'stopTest'},
+                   {'data_type': 'addFailure'}]
+        self.assertEqual(summarize_run(records),
+                         {'hits': 4, 'errors': 2, 'tests': 2, 'failed': 1,
+                          'p50': .3, 'p95': .4})
+        self.assertEqual(summarize_run([])['p95'], None)
@@ -0,0 +1,81 @@
+import os
+import tempfile
+
+import unittest2
+
+from loads.case import TestCase
+from loads.runners.local import LocalRunner
+from loads.seeds import derive, get_random, reset
+from loads.templates import Context, render
+
+
+class _Test(TestCase):
+
+    def test_one(self):
+        pass
+
+    def test_two(self):
+        pass
+
+
+class _Scenarios(_Test):
+    scenarios = {'test_one': 1, 'test_two': 3}
+
+
+def _draw(config):
+    reset()
+    draws = []
+    for user in range(3):
+        test = _Test('test_one', config=config)
+        context = Context(rand=test.session.random)
+        draws.append((test.think_time.next(), test.network.delay(),
+                      test.feed(config['feeder'], 'random')['name'],
+                      render('{{randInt 1 1000000}} {{uuid}} '
+                             '{{fake.City}}', context)))
+    return draws
+
+
+class TestSeeds(unittest2.TestCase):
+
+    def setUp(self):
+        fd, self.feeder = tempfile.mkstemp(suffix='.csv')
+        os.write(fd, 'name\n' + '\n'.join(['user%d' % index
+                                           for index in range(100)]))
+        os.close(fd)
+        self.addCleanup(os.remove, self.feeder)
+
+    def test_get_random(self):
+        self.assertEqual(get_random({}, 'think-time', 0), None)
+        self.assertEqual(derive(42, 0, 'feeder'), derive(42, 0, 'feeder'))
+        self.assertNotEqual(derive(42, 0, 'feeder'), derive(42, 1, 'feeder'))
+
+        first = get_random({'seed': 42}, 'think-time', 0).random()
+        self.assertEqual(get_random({'seed': 42}, 'think-time', 0).random(),
+                         first)
+        self.assertNotEqual(get_random({'seed': 42, 'agent_index': 1},
+                                       'think-time', 0).random(), first)
+
+    def test_users(self):
+        config = {'seed': 42, 'think_time': 'uniform:1,3',
+                  'latency': .1, 'jitter': .05, 'feeder': self.feeder}
+        draws = _draw(config)
+        # the same seed, the same draws for every user
+        self.assertEqual(_draw(config), draws)
+        self.assertEqual(len(set(draws)), 3)
+
+        # but not on another agent, or with another seed
+        self.assertNotEqual(_draw(dict(config, agent_index=1)), draws)
+        self.assertNotEqual(_draw(dict(config, seed=43)), draws)
+
+    def test_scenarios(self):
+        def _get_scenarios(**args):
+            args['fqn'] = 'loads.tests.test_seeds._Scenarios'
+            runner = LocalRunner(args)
+            runner._resolve_name()
+            return runner.scenarios
+
+        scenarios = _get_scenarios()
+        self.assertEqual(len(scenarios), 4)
+        seeded = _get_scenarios(seed=42)
+        self.assertEqual(sorted(seeded), sorted(scenarios))
+        self.assertEqual(_get_scenarios(seed=42), seeded)
@@ -0,0 +1,73 @@
+import unittest2
+
+from loads import sequences
+from loads.case import TestCase
+from loads.sequences import Sequence, get_sequence
+from loads.transport import client
+
+
+class _SignupTestCase(TestCase):
+    def test_signup(self):
+        pass
+
+
+class FakeClient(object):
+    sequences = {}
+
+    def __init__(self, *args, **kw):
+        pass
+
+    def reserve_ids(self, run_id, name, size):
+        start = self.sequences.get((run_id, name), 0)
+        self.sequences[run_id, name] = start + size
+        return start
+
+    def close(self):
+        pass
+
+
+class TestSequences(unittest2.TestCase):
+
+    def setUp(self):
+        self.old_client = client.Client
+        client.Client = FakeClient
+
+    def tearDown(self):
+        client.Client = self.old_client
+        FakeClient.sequences.clear()
+        sequences._SEQUENCES.clear()
+
+    def test_local(self):
+        sequence = Sequence('users', block_size=2)
+        self.assertEqual([sequence.next() for i in range(5)],
+                         [0, 1, 2, 3, 4])
+        self.assertRaises(ValueError, Sequence, 'users', block_size=0)
+
+    def test_blocks(self):
+        reserved = []
+
+        def reserve(name, block_size):
+            reserved.append((name, block_size))
+            return len(reserved) * 100
+
+        sequence = Sequence('users', reserve, block_size=3)
+        self.assertEqual([sequence.next() for i in range(4)],
+                         [100, 101, 102, 200])
+        self.assertEqual(reserved, [('users', 3), ('users', 3)])
+
+    def test_agents(self):
+        # two agents of the same run never get the same ids
+        config = {'slave': True, 'run_id': 'run', 'broker': 'tcp://b',
+                  'id_block_size': 2}
+        first = get_sequence('users', config)
+        self.assertTrue(get_sequence('users') is first)
+        second = Sequence('users', sequences._get_reserve(config), 2)
+        ids = [first.next(), second.next(), first.next(), first.next(),
+               second.next()]
+        self.assertEqual(ids, [0, 2, 1, 4, 3])
+        self.assertEqual(sequences._get_reserve({'run_id': 'run'}), None)
+
+    def test_next_id(self):
+        test = _SignupTestCase('test_signup')
+        self.assertEqual([test.next_id(), test.next_id('users'),
+                          test.next_id()], [0, 0, 1])
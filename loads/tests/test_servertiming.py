@@ -0,0 +1,83 @@
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.report import Report, render
+from loads.results import TestResult
+from loads.servertiming import get_server_timings, parse_server_timing
+from loads.thresholds import Threshold
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Server-Timing', 'db;dur=12.5, cache;desc="Hit"')
+        self.send_header('X-Db-Time', '3')
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+    def get_page(self):
+        pass
+
+
+class TestServerTiming(unittest2.TestCase):
+
+    def test_parse(self):
+        self.assertEqual(parse_server_timing(
+            'db;dur=53.2, cache;desc="Cache, read";dur=1.2, miss, '
+            'cpu;dur="2.5", bad;dur=abc'),
+            [('db', 53.2), ('cache', 1.2), ('cpu', 2.5)])
+        self.assertEqual(parse_server_timing(None), [])
+
+    def test_headers(self):
+        headers = {'Server-Timing': 'db.read;dur=5', 'X-Db-Time': '2.5',
+                   'X-Region': 'eu'}
+        self.assertEqual(get_server_timings(headers, True,
+                                            ['X-Db-Time', 'X-Region']),
+                         {'server-timing-db_read': 5., 'x-db-time': 2.5})
+        self.assertEqual(get_server_timings(headers), None)
+
+    def test_session(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        url = 'http://127.0.0.1:%d/' % server.server_address[1]
+
+        result = TestResult()
+        test = _Test('get_page', test_result=result,
+                     config={'server_timing': True,
+                             'header_metric': ['X-Db-Time']})
+        self.addCleanup(test.session.close)
+        test.session.loads_status = (1, 1, 1, 1)
+        for i in range(2):
+            test.session.get(url)
+
+        self.assertEqual(result.hits[0].server_timings,
+                         {'server-timing-db': 12.5, 'x-db-time': 3.})
+        self.assertEqual(result.get_trends()['server-timing-db'].count, 2)
+        timings = result.get_server_timings()[url]
+        self.assertEqual(timings['x-db-time'].get('avg'), 3.)
+        self.assertEqual(Threshold('trend.server-timing-db.p95 < 10')
+                         .evaluate(result), (12.5, False))
+
+    def test_report(self):
+        hit = {'url': 'http://a', 'method': 'GET', 'status': 200,
+               'elapsed': .02, 'endpoint': '/items',
+               'server_timings': {'server-timing-db': 12.5}}
+        report = Report([('add_hit', hit)])
+        html = render(report, 'Run')
+        self.assertTrue('<h2>Server timings</h2>' in html)
+        self.assertTrue('<td>/items</td>\n<td>server-timing-db</td>\n'
+                        '<td>1</td>\n<td>20.0</td>\n<td>12.5</td>' in html)
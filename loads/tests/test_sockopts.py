@@ -0,0 +1,117 @@
+import socket
+import struct
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.engines.udp import UDPClient
+from loads.sockopts import (SO_REUSEPORT, get_socket_options, merge_options,
+                            parse_size, parse_switch)
+
+
+class _Server(HTTPServer):
+
+    def handle_error(self, request, client_address):
+        # the connections closed with a linger of 0 are reset
+        pass
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    # the connection is kept, so the test can look at its socket
+    protocol_version = 'HTTP/1.1'
+
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_sockopts(self):
+        pass
+
+
+def _get_linger(sock):
+    return struct.unpack('ii', sock.getsockopt(socket.SOL_SOCKET,
+                                               socket.SO_LINGER, 8))
+
+
+class TestSocketOptions(unittest2.TestCase):
+
+    def test_parse(self):
+        self.assertEqual(parse_size('256k'), 262144)
+        self.assertEqual(parse_size('1m'), 1048576)
+        self.assertEqual(parse_size('4096'), 4096)
+        self.assertRaises(ValueError, parse_size, '0')
+        self.assertTrue(parse_switch('On'))
+        self.assertFalse(parse_switch('off'))
+        self.assertRaises(ValueError, parse_switch, 'yes')
+
+    def test_options(self):
+        self.assertEqual(get_socket_options({'tcp_nodelay': None}), None)
+        options = get_socket_options({'reuse_port': True, 'tcp_nodelay': False,
+                                      'linger': 0, 'send_buffer': 65536})
+        self.assertEqual(options, [
+            (socket.SOL_SOCKET, SO_REUSEPORT, 1),
+            (socket.IPPROTO_TCP, socket.TCP_NODELAY, 0),
+            (socket.SOL_SOCKET, socket.SO_LINGER, struct.pack('ii', 1, 0)),
+            (socket.SOL_SOCKET, socket.SO_SNDBUF, 65536)])
+
+        defaults = [(socket.IPPROTO_TCP, socket.TCP_NODELAY, 1)]
+        self.assertEqual(merge_options(defaults, options[1:2]), options[1:2])
+        self.assertEqual(merge_options(defaults, options[:1]),
+                         defaults + options[:1])
+
+    def test_session(self):
+        server = _Server(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+
+        test = _Test('test_sockopts', config={'linger': 0,
+                                              'receive_buffer': 65536,
+                                              'reuse_port': True})
+        self.addCleanup(test.session.close)
+        url = 'http://127.0.0.1:%d/' % server.server_address[1]
+        test.session.get(url)
+        adapter = test.session.get_adapter(url)
+        sock = adapter.poolmanager.connection_from_url(url).pool.get().sock
+        self.assertEqual(_get_linger(sock), (1, 0))
+        self.assertTrue(sock.getsockopt(socket.SOL_SOCKET,
+                                        socket.SO_RCVBUF) >= 65536)
+        self.assertEqual(sock.getsockopt(socket.SOL_SOCKET, SO_REUSEPORT), 1)
+        # the default of urllib3 is kept
+        self.assertEqual(sock.getsockopt(socket.IPPROTO_TCP,
+                                         socket.TCP_NODELAY), 1)
+
+    def test_engines(self):
+        server = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        server.bind(('127.0.0.1', 0))
+        server.listen(1)
+        self.addCleanup(server.close)
+
+        test = _Test('test_sockopts', config={'tcp_nodelay': True,
+                                              'send_buffer': 65536})
+        self.addCleanup(test.session.close)
+        client = test.create_tcp('127.0.0.1', server.getsockname()[1])
+        self.addCleanup(client.close)
+        client.connect()
+        self.assertEqual(client._socket.getsockopt(socket.IPPROTO_TCP,
+                                                   socket.TCP_NODELAY), 1)
+
+        # the TCP options are left out
+        client = UDPClient('127.0.0.1', 9,
+                           socket_options=test.socket_options)
+        self.addCleanup(client.close)
+        self.assertTrue(client._socket.getsockopt(socket.SOL_SOCKET,
+                                                  socket.SO_SNDBUF) >= 65536)
@@ -0,0 +1,124 @@
+import os
+import socket
+import tempfile
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.engines.tcp import TCPClient
+from loads.sources import (SourceAddresses, get_source_addresses,
+                           parse_address, read_addresses)
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    # HTTP/1.0: every request has its own connection
+    def do_GET(self):
+        self.server.clients.append(self.client_address[0])
+        self.send_response(200)
+        self.send_header('Content-Length', '2')
+        self.end_headers()
+        self.wfile.write('OK')
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def test_sources(self):
+        pass
+
+
+class TestSources(unittest2.TestCase):
+
+    def test_parse(self):
+        self.assertEqual(parse_address(' 10.0.0.1 '), '10.0.0.1')
+        self.assertEqual(parse_address('fd00::1'), 'fd00::1')
+        self.assertRaises(ValueError, parse_address, '10.0.0')
+        self.assertRaises(ValueError, parse_address, 'localhost')
+
+    def test_read(self):
+        fd, path = tempfile.mkstemp()
+        os.close(fd)
+        self.addCleanup(os.remove, path)
+        with open(path, 'w') as f:
+            f.write('# the agent\n10.0.0.1\n\n10.0.0.2  # second\n')
+        self.assertEqual(read_addresses(path), ['10.0.0.1', '10.0.0.2'])
+
+        with open(path, 'w') as f:
+            f.write('# nothing\n')
+        self.assertRaises(ValueError, read_addresses, path)
+
+    def test_strategies(self):
+        self.assertEqual(get_source_addresses({}), None)
+        sources = SourceAddresses(['10.0.0.1', '10.0.0.2'])
+        self.assertEqual([sources.next() for index in range(3)],
+                         ['10.0.0.1', '10.0.0.2', '10.0.0.1'])
+
+        config = {'source_ip': ['10.0.1.1', '10.0.1.2'],
+                  'source_ip_strategy': 'per-user'}
+        first = get_source_addresses(config)
+        second = get_source_addresses(config)
+        self.assertEqual([first.next(), first.next()],
+                         ['10.0.1.1', '10.0.1.1'])
+        self.assertEqual(second.next(), '10.0.1.2')
+        self.assertRaises(ValueError, get_source_addresses,
+                          {'source_ip': ['10.0.1.1'],
+                           'source_ip_strategy': 'random'})
+
+
+class TestBinding(unittest2.TestCase):
+
+    def setUp(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        server.clients = []
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        self.server = server
+        self.url = 'http://127.0.0.1:%d/' % server.server_address[1]
+
+    def _get_test(self, **config):
+        test = _Test('test_sources', config=config)
+        self.addCleanup(test.session.close)
+        return test
+
+    def test_round_robin(self):
+        test = self._get_test(source_ip=['127.0.0.2', '127.0.0.3'])
+        for index in range(3):
+            test.session.get(self.url)
+        self.assertEqual(self.server.clients,
+                         ['127.0.0.2', '127.0.0.3', '127.0.0.2'])
+
+    def test_per_user(self):
+        config = {'source_ip': ['127.0.0.4', '127.0.0.5'],
+                  'source_ip_strategy': 'per-user'}
+        for user in range(2):
+            test = self._get_test(**config)
+            for index in range(2):
+                test.session.get(self.url)
+        self.assertEqual(self.server.clients,
+                         ['127.0.0.4', '127.0.0.4', '127.0.0.5', '127.0.0.5'])
+
+    def test_tcp(self):
+        sock = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        sock.bind(('127.0.0.1', 0))
+        sock.listen(1)
+        self.addCleanup(sock.close)
+
+        client = TCPClient('127.0.0.1', sock.getsockname()[1],
+                           source_address=('127.0.0.6', 0))
+        self.addCleanup(client.close)
+        client.connect()
+        connection, address = sock.accept()
+        connection.close()
+        self.assertEqual(address[0], '127.0.0.6')
+
+        test = self._get_test(source_ip=['127.0.0.7'])
+        client = test.create_tcp('127.0.0.1', sock.getsockname()[1])
+        self.assertEqual(client.source_address, ('127.0.0.7', 0))
@@ -0,0 +1,117 @@
+import unittest2
+import os
+import shutil
+import tempfile
+
+from zmq.green.eventloop import ioloop
+from loads.db._sqlite import SQLiteDB
+from loads.tests.test_python_db import ONE_RUN
+
+
+class TestSQLiteDB(unittest2.TestCase):
+
+    def setUp(self):
+        self.loop = ioloop.IOLoop()
+        self.tmp = tempfile.mkdtemp()
+        self.db = self._get_db()
+
+    def tearDown(self):
+        self.db.close()
+        self.loop.close()
+        shutil.rmtree(self.tmp)
+
+    def _get_db(self):
+        return SQLiteDB(self.loop, path=os.path.join(self.tmp, 'loads.db'))
+
+    def _add_data(self):
+        for i in range(2):
+            for line in ONE_RUN:
+                data = dict(line)
+                data['run_id'] = '1'
+                self.db.add(data)
+                data['run_id'] = '2'
+                self.db.add(data)
+
+    def test_brokerdb(self):
+        self.assertEqual(list(self.db.get_data('swwqqsw')), [])
+        self.assertTrue(self.db.ping())
+        self._add_data()
+
+        counts = self.db.get_counts('1')
+        for type_ in ('addSuccess', 'stopTestRun', 'stopTest',
+                      'startTest', 'startTestRun', 'add_hit'):
+            self.assertEqual(counts[type_], 2)
+
+        self.assertEqual(len(list(self.db.get_data('1'))), 14)
+        self.assertEqual(len(list(self.db.get_data('1', size=2))), 2)
+        self.assertEqual(len(list(self.db.get_data('1', start=2))), 12)
+        batch = list(self.db.get_data('1', start=2, size=5))
+        self.assertEqual(len(batch), 5)
+        self.assertEqual(batch[0]['data_type'], 'add_hit')
+
+        # filtered
+        data = list(self.db.get_data('1', data_type='add_hit'))
+        self.assertEqual(len(data), 2)
+        self.assertEqual(data[0]['url'], 'http://127.0.0.1:9200/')
+
+        # group by
+        res = list(self.db.get_data('1', groupby=True))
+        self.assertEqual(len(res), 7)
+        self.assertEqual(res[0]['count'], 2)
+
+        self.assertEqual(self.db.get_runs(), ['1', '2'])
+
+    def test_metadata(self):
+        self.assertEqual(self.db.get_metadata('1'), {})
+        self.db.save_metadata('1', {'hey': 'ho'})
+        self.assertEqual(self.db.get_metadata('1'), {'hey': 'ho'})
+
+        self.db.update_metadata('1', one=2)
+        meta = self.db.get_metadata('1').items()
+        meta.sort()
+        self.assertEqual(meta, [('hey', 'ho'), ('one', 2)])
+
+    def test_get_urls(self):
+        self._add_data()
+        urls = self.db.get_urls('1')
+        self.assertEqual(urls, {'http://127.0.0.1:9200/': 2})
+
+    def test_get_errors(self):
+        self._add_data()
+        errors = list(self.db.get_errors('2'))
+        self.assertEqual(len(errors), 2, errors)
+        self.assertEqual(errors[0]['data_type'], 'addError')
+
+    def test_checks_and_intervals(self):
+        for passed in (True, True, False):
+            self.db.add({'run_id': '1', 'data_type': 'add_check',
+                         'name': 'status', 'passed': passed})
+        self._add_data()
+
+        checks = self.db.get_checks('1')
+        self.assertEqual(checks, {'status': {'passed': 2, 'failed': 1}})
+
+        intervals = self.db.get_intervals('1', interval=60.)
+        self.assertEqual(len(intervals), 1)
+        self.assertEqual(intervals[0]['hits'], 2)
+        self.assertEqual(intervals[0]['failures'], 2)
+        self.assertAlmostEqual(intervals[0]['elapsed'], 0.008656)
+
+    def test_survives_restarts(self):
+        self.db.save_metadata('1', {'hey': 'ho'})
+        self._add_data()
+        self.db.close()
+
+        self.db = self._get_db()
+        self.assertEqual(self.db.get_metadata('1')['hey'], 'ho')
+        self.assertEqual(self.db.get_counts('1')['add_hit'], 2)
+
+    def test_max_runs(self):
+        self.db.max_runs = 2
+        for run_id in ('run_1', 'run_2', 'run_3'):
+            self.db.prepare_run()
+            self.db.add({'run_id': run_id, 'data_type': 'add_hit'})
+
+        self.assertEqual(self.db.get_runs(), ['run_2', 'run_3'])
+        self.assertEqual(list(self.db.get_data('run_1')), [])
+        self.assertFalse(self.db.is_summarized('run_3'))
@@ -0,0 +1,87 @@
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.case import TestCase
+from loads.engines.sse import InvalidEvent, iter_events, iter_lines
+from loads.results import TestResult
+
+
+class _Handler(BaseHTTPRequestHandler):
+    last_event_ids = []
+
+    def do_GET(self):
+        self.last_event_ids.append(self.headers.get('Last-Event-ID'))
+        start = int(self.headers.get('Last-Event-ID') or 0)
+        self.send_response(200)
+        self.send_header('Content-Type', 'text/event-stream')
+        self.end_headers()
+        self.wfile.write(': hello\nretry: 10\n\n')
+        # two events, then the stream is dropped
+        for index in (start + 1, start + 2):
+            self.wfile.write('id: %d\ndata: {"n": %d}\n\n' % (index, index))
+            self.wfile.flush()
+
+    def log_message(self, *args):
+        pass
+
+
+class _Test(TestCase):
+
+    def listen(self):
+        pass
+
+
+class TestSSE(unittest2.TestCase):
+
+    def test_iter_lines(self):
+        chunks = ['one\r', '\ntwo\nthr', 'ee\rfour', '\r']
+        self.assertEqual(list(iter_lines(chunks)),
+                         ['one', 'two', 'three', 'four'])
+
+    def test_iter_events(self):
+        lines = [': keep-alive', 'event: update', 'id: 7', 'data: a',
+                 'data:b', '', 'retry: 500', '', 'data: c', '', 'data: d']
+        events = list(iter_events(lines))
+        self.assertEqual([(event.event, event.id, event.data)
+                          for event in events],
+                         [('update', '7', 'a\nb'), ('message', None, 'c')])
+
+    def test_client(self):
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        del _Handler.last_event_ids[:]
+        url = 'http://127.0.0.1:%d/events' % server.server_address[1]
+
+        result = TestResult()
+        test = _Test('listen', test_result=result)
+        self.addCleanup(test.session.close)
+        slept = []
+        client = test.create_sse(url, sleep=slept.append)
+        events = list(client.events(count=3))
+        self.assertEqual([event.json()['n'] for event in events], [1, 2, 3])
+
+        # the stream is opened again after a drop, from the last event
+        self.assertEqual(_Handler.last_event_ids, [None, '2'])
+        self.assertEqual(slept, [.01])
+        counters = result.get_counters()
+        self.assertEqual((counters['sse-streams'], counters['sse-events'],
+                          counters['sse-drops'], counters['sse-reconnects']),
+                         (2, 3, 1, 1))
+        self.assertEqual(len(result.hits), 2)
+        self.assertEqual(result.get_trends()['sse-first-event'].count, 2)
+        self.assertEqual(result.get_trends()['sse-event-interval'].count, 1)
+
+        # too many drops
+        client = test.create_sse(url, sleep=slept.append, max_reconnects=0)
+        self.assertRaises(IOError, list, client.events(count=3))
+
+        client = test.create_sse(url, validate=lambda event: event.json()['n']
+                                 < 2)
+        self.assertRaises(InvalidEvent, list, client.events())
+        self.assertEqual(result.get_counters()['sse-invalid-events'], 1)
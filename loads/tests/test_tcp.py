@@ -0,0 +1,69 @@
+import socket
+import threading
+
+import unittest2
+
+from loads.engines.tcp import TCPClient
+from loads.results import TestResult
+
+
+def _echo(sock):
+    client, _ = sock.accept()
+    while True:
+        data = client.recv(1024)
+        if not data:
+            break
+        client.sendall(data)
+    client.close()
+
+
+class TestTCPClient(unittest2.TestCase):
+
+    def setUp(self):
+        self.sock = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        self.sock.bind(('127.0.0.1', 0))
+        self.sock.listen(1)
+        self.port = self.sock.getsockname()[1]
+        thread = threading.Thread(target=_echo, args=(self.sock,))
+        thread.daemon = True
+        thread.start()
+        self.result = TestResult()
+
+    def tearDown(self):
+        self.sock.close()
+
+    def _client(self, **options):
+        return TCPClient('127.0.0.1', self.port, self.result, **options)
+
+    def test_raw(self):
+        client = self._client()
+        self.assertEqual(client.send_receive('hello'), 'hello')
+        client.close()
+
+        self.assertEqual(self.result.opened_sockets, 1)
+        self.assertEqual(len(self.result.socket_connect_times), 1)
+        self.assertEqual(len(self.result.socket_rtts), 1)
+        self.assertEqual(self.result.socket_data_received, 5)
+        self.assertEqual(self.result.sockets, 0)
+
+    def test_length_prefix(self):
+        client = self._client(framing='length', length_size=2)
+        client.send('one')
+        client.send('two')
+        self.assertEqual(client.receive(), 'one')
+        self.assertEqual(client.receive(), 'two')
+        client.close()
+
+    def test_delimiter(self):
+        client = self._client(framing='delimiter', delimiter='\r\n')
+        client.send('one')
+        client.send('two')
+        self.assertEqual(client.receive(), 'one')
+        self.assertEqual(client.send_receive('three'), 'two')
+        self.assertEqual(client.receive(), 'three')
+        client.close()
+
+    def test_bad_options(self):
+        self.assertRaises(ValueError, self._client, framing='xml')
+        self.assertRaises(ValueError, self._client, framing='length',
+                          length_size=3)
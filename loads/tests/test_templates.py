@@ -0,0 +1,65 @@
+import os
+import re
+
+import mock
+import unittest2
+
+from loads import templates
+from loads.measure import Session
+from loads.templates import (Context, TemplateError, evaluate, render,
+                             render_all)
+
+
+class TestTemplates(unittest2.TestCase):
+
+    def test_functions(self):
+        self.assertTrue(re.match('^[0-9a-f-]{36}$', render('{{uuid}}')))
+        self.assertTrue(re.match(r'^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ$',
+                                 render('{{ now }}')))
+        self.assertTrue(re.match(r'^\d{4}$', render('{{now "%Y"}}')))
+        self.assertTrue(1 <= int(render('{{randInt 1 3}}')) <= 3)
+        self.assertEqual(len(render('{{randString 8}}')), 8)
+        self.assertIn(render("{{randChoice 'a b' c}}"), ('a b', 'c'))
+        self.assertEqual(render('{{env LOADS_NOT_SET default}}'), 'default')
+
+        with mock.patch.dict(os.environ, {'LOADS_TOKEN': 'secret'}):
+            self.assertEqual(render('Bearer {{env LOADS_TOKEN}}'),
+                             'Bearer secret')
+        self.assertEqual(render('no template'), 'no template')
+
+    def test_errors(self):
+        self.assertRaises(TemplateError, render, '{{unknown}}')
+        self.assertRaises(TemplateError, render, '{{randInt 1}}')
+        self.assertRaises(TemplateError, render, '{{feeder.email}}')
+        self.assertRaises(TemplateError, render, '{{now "unterminated}}')
+        self.assertRaises(TemplateError, evaluate, 'state.missing')
+
+    def test_context(self):
+        rows = iter([{'email': 'bob@example.com', 'name': 'Bob'}])
+        context = Context(lambda: rows.next(), state={'token': 'abc'})
+
+        # a single row for all the templates
+        rendered = render_all({'to': '{{feeder.email}}',
+                               'names': ('{{feeder.name}}', 1),
+                               'token': '{{state.token}}'}, context)
+        self.assertEqual(rendered, {'to': 'bob@example.com',
+                                    'names': ('Bob', 1), 'token': 'abc'})
+
+    def test_register_function(self):
+        templates.register_function('double', lambda value: int(value) * 2)
+        self.addCleanup(templates._FUNCTIONS.pop, 'double')
+        self.assertEqual(render('{{double 21}}'), '42')
+
+    def test_session(self):
+        test = mock.Mock(state={'id': '42'})
+        session = Session(test, None)
+        session.templates = True
+        session.feeder = lambda: {'email': 'bob@example.com'}
+
+        with mock.patch('requests.Session.request') as request:
+            session.request('POST', 'https://app/users/{{state.id}}',
+                            headers={'X-Mail': '{{feeder.email}}'},
+                            data='{"id": {{randInt 7 7}}}')
+        request.assert_called_with(
+            'POST', 'https://app/users/42',
+            headers={'X-Mail': 'bob@example.com'}, data='{"id": 7}')
@@ -89,6 +89,44 @@ class TestTestResult(TestCase):
         test_result = TestResult()
         self.assertEquals(test_result.average_request_time(), 0)
 
+    def test_request_time_percentiles(self):
+        test_result = TestResult(args={'arrival_rate': 10})
+        for _ in range(9):
+            test_result.add_hit(**self._get_data(elapsed=.1))
+        test_result.add_hit(**self._get_data(elapsed=1))
+
+        # the values are kept with 3 significant digits
+        self.assertAlmostEqual(test_result.get_request_time_percentile(90),
+                               .1, places=3)
+        self.assertEqual(test_result.get_request_time_percentile(100), 1)
+        quantiles = test_result.get_request_time_quantiles()
+        self.assertEqual([round(q, 3) for q in quantiles],
+                         [.1, .1, .1, .1, 1])
+        self.assertEqual(TestResult().get_request_time_percentile(90), None)
+
+    def test_coordinated_omission_is_corrected(self):
+        # in the closed model, the slow request hid 9 other requests
+        test_result = TestResult(args={'expected_interval': .1})
+        for _ in range(9):
+            test_result.add_hit(**self._get_data(elapsed=.1))
+        test_result.add_hit(**self._get_data(elapsed=1))
+
+        self.assertEqual(test_result.get_histogram().total_count, 10)
+        self.assertEqual(test_result.get_corrected_histogram().total_count,
+                         19)
+        self.assertTrue(test_result.get_request_time_percentile(90) > .5)
+
+    def test_interval_histograms(self):
+        test_result = TestResult()
+        test_result.add_hit(**self._get_data(started=TIME1))
+        test_result.add_hit(**self._get_data(started=TIME1 + _1))
+        test_result.add_hit(**self._get_data(started=TIME1 + 15 * _1))
+
+        intervals = test_result.get_interval_histograms()
+        self.assertEqual([(start.second, histogram.total_count)
+                          for start, histogram in intervals],
+                         [(0, 2), (20, 1)])
+
     def test_urls(self):
         test_result = TestResult()
         test_result.add_hit(**self._get_data())
@@ -106,6 +144,15 @@ class TestTestResult(TestCase):
         self.assertEquals(test_result.hits_success_rate(), 0.8)
         self.assertEquals(test_result.hits_success_rate(series=1), 1)
 
+    def test_grpc_hits_success_rate(self):
+        test_result = TestResult()
+        url = 'grpc://localhost:50051/helloworld.Greeter/SayHello'
+        test_result.add_hit(**self._get_data(url=url, method='GRPC',
+                                             status='OK'))
+        test_result.add_hit(**self._get_data(url=url, method='GRPC',
+                                             status='UNAVAILABLE'))
+        self.assertEquals(test_result.hits_success_rate(url=url), 0.5)
+
     def test_requests_per_second(self):
         test_result = TestResult()
         for x in range(20):
@@ -201,18 +248,88 @@ class TestTestResult(TestCase):
             'average_request_time': 0.5,
             'hits_success_rate': 0.9})
 
+    def test_warmup(self):
+        test_result = TestResult(args={'warmup': '30s'})
+        test_result.startTestRun(when=TIME1)
+        self.assertEqual(test_result.warmup, 30)
+
+        # the hits of the first 30 seconds are left out
+        test_result.add_hit(**self._get_data(started=TIME1 + _1, status=500,
+                                             elapsed=_3))
+        test_result.add_hit(**self._get_data(started=TIME2))
+        self.assertEqual(len(test_result.hits), 1)
+        self.assertEqual(test_result.warmup_hits, 1)
+        self.assertEqual(test_result.hits_success_rate(), 1)
+        self.assertEqual(test_result.get_histogram().total_count, 1)
+
+        # and the tests started while it warms up
+        loads_status = 1, 1, 1, 1
+        test_result.start_time = datetime.utcnow()
+        self.assertTrue(test_result.in_warmup())
+        test_result.startTest('bacon', loads_status)
+        test_result.addFailure('bacon', None, loads_status)
+        test_result.stopTest('bacon', loads_status)
+        test_result.add_check('status == 200', False)
+        self.assertEqual(test_result.nb_failures, 0)
+        self.assertEqual(test_result.nb_tests, 0)
+        self.assertEqual(test_result.get_checks(), {})
+
+        test_result.stop_time = test_result.start_time + _2
+        self.assertEqual(test_result.measured_duration, 0)
+        self.assertEqual(test_result.requests_per_second(), 0)
+
     def test_counters(self):
         test_result = TestResult()
         loads_status = (1, 1, 1, 1)
         test_result.incr_counter('bacon', loads_status, 'sent')
         test_result.incr_counter('bacon', loads_status, 'sent')
         test_result.incr_counter('bacon', loads_status, 'received')
+        test_result.incr_counter('bacon', loads_status, 'bytes', value=10)
 
         self.assertEqual(test_result.get_counter('sent'), 2)
+        self.assertEqual(test_result.get_counter('bytes'), 10)
         self.assertEqual(test_result.get_counter('received', test='bacon'), 1)
         self.assertEqual(test_result.get_counter('bacon', 'xxxx'), 0)
         self.assertEqual(test_result.get_counter('xxx', 'xxxx'), 0)
 
+    def test_checks(self):
+        test_result = TestResult()
+        test_result.add_check('status == 200', True)
+        test_result.add_check('status == 200', False)
+        test_result.add_check('status == 200', True)
+        test_result.add_check('latency < 300ms', False)
+
+        self.assertEqual(test_result.get_checks(),
+                         {'status == 200': {'passed': 2, 'failed': 1},
+                          'latency < 300ms': {'passed': 0, 'failed': 1}})
+
+    def test_agent_lost(self):
+        test_result = TestResult()
+        observer = Mock()
+        test_result.add_observer(observer)
+        test_result.agent_lost(agent_id='1', replacement='2')
+        test_result.agent_lost(agent_id='3')
+
+        self.assertEqual(test_result.lost_agents, [('1', '2'), ('3', None)])
+        observer.push.assert_called_with('agent_lost', agent_id='3')
+
+    def test_tag_metrics(self):
+        test_result = TestResult()
+        test_result.agent_tags = {'1': {'region': 'eu-west'},
+                                  '2': {'region': 'us-east'}}
+        for agent_id, elapsed, status in ((1, .3, 200), (1, .3, 500),
+                                          (2, .1, 200), (3, .1, 200)):
+            data = self._get_data(elapsed=elapsed, status=status)
+            test_result.add_hit(agent_id=agent_id, **data)
+
+        metrics = test_result.get_tag_metrics()['region']
+        self.assertEqual(sorted(metrics), ['eu-west', 'us-east'])
+        self.assertEqual(metrics['eu-west']['hits'], 2)
+        self.assertEqual(metrics['eu-west']['success_rate'], .5)
+        self.assertAlmostEqual(metrics['eu-west']['average_request_time'],
+                               .3, places=3)
+        self.assertAlmostEqual(metrics['us-east']['p95'], .1, places=3)
+
     def test_socket_count(self):
         test_result = TestResult()
 
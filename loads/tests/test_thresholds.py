@@ -0,0 +1,137 @@
+import datetime
+import os
+import tempfile
+
+import unittest2
+
+from loads.histogram import Histogram
+from loads.results import TestResult
+from loads.thresholds import (Threshold, get_metric, get_live_metric,
+                              parse_thresholds, read_thresholds)
+
+
+_STATUS = (1, 1, 1, 1)
+
+
+def _get_result(elapsed=(.1, .2, .3, .4), statuses=(200, 200, 200, 500)):
+    result = TestResult()
+    start = datetime.datetime.utcnow()
+    for value, status in zip(elapsed, statuses):
+        result.add_hit(url='http://localhost', method='GET', status=status,
+                       started=start, elapsed=value, loads_status=_STATUS)
+    return result
+
+
+def _get_endpoints_result():
+    result = _get_result()
+    start = datetime.datetime.utcnow()
+    for value in (.01, .02):
+        result.add_hit(url='http://localhost/items', method='GET',
+                       status=200, started=start, elapsed=value,
+                       loads_status=_STATUS)
+    return result
+
+
+class TestThresholds(unittest2.TestCase):
+
+    def test_parse(self):
+        threshold = Threshold('p95 < 250ms')
+        self.assertEqual(threshold.metric, 'p95')
+        self.assertEqual(threshold.value, .25)
+        self.assertEqual(str(threshold), 'p95 < 250ms')
+
+        self.assertEqual(Threshold('avg<=1.5').value, 1.5)
+        self.assertEqual(Threshold('error_rate < 1%').value, 1)
+
+        self.assertEqual(Threshold('trend.server-db.p95 < 50').value, 50)
+
+        for bad in ('p95 250ms', 'p42 < 1s', 'p95 < 1%', 'rps > 10s',
+                    'error_rate < 10ms', 'trend.db.p42 < 1',
+                    'gauge.cart < 1ms', 'meter.cart < 1'):
+            self.assertRaises(ValueError, Threshold, bad)
+
+    def test_parse_thresholds(self):
+        thresholds = parse_thresholds(['p95 < 250ms, rps > 10', 'max < 1s'])
+        self.assertEqual([str(t) for t in thresholds],
+                         ['p95 < 250ms', 'rps > 10', 'max < 1s'])
+        self.assertEqual(parse_thresholds(None), [])
+
+    def test_get_metric(self):
+        result = _get_result()
+        self.assertAlmostEqual(get_metric(result, 'avg'), .25)
+        self.assertAlmostEqual(get_metric(result, 'max'), .4)
+        self.assertEqual(get_metric(result, 'hits_error_rate'), 25.)
+        self.assertEqual(get_metric(TestResult(), 'p95'), None)
+        self.assertEqual(get_metric(TestResult(), 'error_rate'), None)
+
+    def test_evaluate(self):
+        result = _get_result()
+        self.assertEqual(Threshold('max < 500ms').evaluate(result),
+                         (.4, True))
+        value, passed = Threshold('hits_error_rate < 1%').evaluate(result)
+        self.assertFalse(passed)
+
+        # no data means the threshold failed
+        self.assertEqual(Threshold('p95 < 1s').evaluate(TestResult()),
+                         (None, False))
+
+    def test_evaluate_live(self):
+        histogram = Histogram()
+        for elapsed in (100000, 200000, 300000, 400000):
+            histogram.record_value(elapsed)
+        totals = {'hits': 4, 'errors': 1, 'tests': 2, 'failures': 0,
+                  'started': 1000., 'updated': 1002., 'histogram': histogram}
+
+        self.assertAlmostEqual(get_live_metric(totals, 'avg'), .25)
+        self.assertEqual(get_live_metric(totals, 'rps'), 2.)
+        self.assertEqual(get_live_metric(totals, 'error_rate'), 0.)
+        self.assertEqual(Threshold('hits_error_rate < 30%').evaluate_live(
+            totals), (25., True))
+        value, passed = Threshold('p50 < 100ms').evaluate_live(totals)
+        self.assertAlmostEqual(value, .2, 2)
+        self.assertFalse(passed)
+
+        totals['hits'], totals['histogram'] = 0, Histogram()
+        self.assertEqual(Threshold('p95 < 1s').evaluate_live(totals),
+                         (None, False))
+
+    def test_endpoint(self):
+        threshold = Threshold('p95 < 250ms @ http://localhost/items')
+        self.assertEqual(threshold.endpoint, 'http://localhost/items')
+        self.assertEqual(threshold.value, .25)
+        self.assertEqual(Threshold('p95 < 250ms').endpoint, None)
+        for bad in ('rps > 10 @ /items', 'error_rate < 1% @ /items',
+                    'counter.carts > 1 @ /items', 'p95 < 1s @'):
+            self.assertRaises(ValueError, Threshold, bad)
+
+        result = _get_endpoints_result()
+        self.assertAlmostEqual(get_metric(result, 'max',
+                                          'http://localhost/items'), .02)
+        self.assertEqual(get_metric(result, 'hits_error_rate',
+                                    'http://localhost/items'), 0.)
+        self.assertEqual(get_metric(result, 'hits_error_rate',
+                                    'http://localhost'), 25.)
+        self.assertEqual(get_metric(result, 'p95', 'http://localhost/no'),
+                         None)
+
+        value, passed = Threshold('max < 50ms @ http://localhost/items'
+                                  ).evaluate(result)
+        self.assertTrue(passed)
+        self.assertFalse(Threshold('max < 50ms').evaluate(result)[1])
+
+        # the live stats have no endpoints
+        self.assertEqual(threshold.evaluate_live({}), (None, False))
+
+    def test_read_thresholds(self):
+        fd, path = tempfile.mkstemp()
+        os.write(fd, '# the thresholds\n\np95 < 250ms\n'
+                     'hits_error_rate <= 1% @ /items, /users\n')
+        os.close(fd)
+        try:
+            thresholds = read_thresholds(path)
+        finally:
+            os.remove(path)
+        self.assertEqual([str(t) for t in thresholds],
+                         ['p95 < 250ms', 'hits_error_rate <= 1% @ /items, '
+                          '/users'])
+        self.assertEqual(thresholds[1].endpoint, '/items, /users')
@@ -0,0 +1,139 @@
+import shutil
+import ssl
+import tempfile
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import mock
+import unittest2
+from requests.exceptions import ConnectionError
+
+from loads import tls
+from loads.case import TestCase
+from loads.record import CertificateAuthority
+from loads.tests.test_record import _has_openssl
+from loads.tls import CertPool, TLSConfig, get_tls_config, parse_cert
+
+
+class _Test(TestCase):
+
+    def test_tls(self):
+        pass
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        subject = dict([pair[0] for pair in
+                        self.connection.getpeercert()['subject']])
+        body = subject.get('commonName', '')
+        self.send_response(200)
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+class TestTLSConfig(unittest2.TestCase):
+
+    def setUp(self):
+        self.addCleanup(tls._POOLS.clear)
+
+    def test_parse_cert(self):
+        self.assertEqual(parse_cert('cert.pem'), ('cert.pem', None))
+        self.assertEqual(parse_cert('cert.pem, key.pem'),
+                         ('cert.pem', 'key.pem'))
+
+    def test_versions(self):
+        context = TLSConfig(min_version='TLSv1.2').create_context()
+        self.assertTrue(context.options & ssl.OP_NO_TLSv1)
+        self.assertTrue(context.options & ssl.OP_NO_TLSv1_1)
+        self.assertFalse(context.options & ssl.OP_NO_TLSv1_2)
+
+        context = TLSConfig(max_version='TLSv1.2').create_context()
+        self.assertFalse(context.options & ssl.OP_NO_TLSv1_2)
+        if hasattr(ssl, 'OP_NO_TLSv1_3'):
+            self.assertTrue(context.options & ssl.OP_NO_TLSv1_3)
+
+        self.assertRaises(ValueError, TLSConfig, min_version='SSLv3')
+        self.assertRaises(ValueError, TLSConfig, min_version='TLSv1.3',
+                          max_version='TLSv1.2')
+        self.assertRaises(ssl.SSLError, TLSConfig(ciphers='NOPE')
+                          .create_context)
+
+    def test_resumption(self):
+        context = TLSConfig(resumption=False).create_context()
+        self.assertFalse(context.resumption)
+        self.assertTrue(context.options & getattr(ssl, 'OP_NO_TICKET', 0)
+                        or not hasattr(ssl, 'OP_NO_TICKET'))
+
+    def test_pool(self):
+        pool = CertPool(['a.pem', 'b.pem,b.key'])
+        self.assertEqual([pool.next() for index in range(3)],
+                         [('a.pem', None), ('b.pem', 'b.key'),
+                          ('a.pem', None)])
+        self.assertRaises(ValueError, CertPool, [])
+
+        self.assertEqual(get_tls_config({}), None)
+        config = {'client_cert': ['a.pem', 'b.pem'],
+                  'tls_min_version': 'TLSv1.2'}
+        first, second = get_tls_config(config), get_tls_config(config)
+        self.assertEqual((first.cert, second.cert),
+                         (('a.pem', None), ('b.pem', None)))
+        self.assertEqual(first.min_version, 'TLSv1.2')
+        self.assertFalse(get_tls_config({'no_tls_resumption': True})
+                         .resumption)
+
+
+class TestMutualTLS(unittest2.TestCase):
+
+    def setUp(self):
+        if not _has_openssl():
+            raise unittest2.SkipTest('openssl is not installed')
+        self.addCleanup(tls._POOLS.clear)
+        directory = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, directory)
+        self.ca = CertificateAuthority(directory)
+        self.ca.create()
+
+        # the server asks for a certificate of the authority
+        certificate, key = self.ca.get_certificate('localhost')
+        context = ssl.SSLContext(ssl.PROTOCOL_SSLv23)
+        context.load_cert_chain(certificate, key)
+        context.load_verify_locations(self.ca.certificate)
+        context.verify_mode = ssl.CERT_REQUIRED
+        server = HTTPServer(('127.0.0.1', 0), _Handler)
+        server.socket = context.wrap_socket(server.socket, server_side=True)
+        thread = threading.Thread(target=server.serve_forever)
+        thread.daemon = True
+        thread.start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        self.port = server.server_address[1]
+
+    def test_client_cert(self):
+        url = 'https://localhost:%d/' % self.port
+        result = mock.Mock()
+        test = _Test('test_tls', test_result=result, config={
+            'client_cert': [self.ca.get_certificate('loads-client')]})
+        response = test.session.get(url, verify=self.ca.certificate)
+        self.assertEqual(response.text, 'loads-client')
+        counters = [call[0][2] for call in result.incr_counter.call_args_list]
+        self.assertEqual(counters.count('tls-resumed') +
+                         counters.count('tls-full-handshakes'), 1)
+
+        # with TLS 1.3, the server rejects it after the handshake
+        test = _Test('test_tls')
+        self.assertRaises(ConnectionError, test.session.get, url,
+                          verify=self.ca.certificate)
+
+    def test_server_name(self):
+        # the IP in the URL, and the name of the certificate with SNI
+        test = _Test('test_tls', config={
+            'client_cert': [self.ca.get_certificate('loads-client')],
+            'tls_server_name': 'localhost'})
+        response = test.session.get('https://127.0.0.1:%d/' % self.port,
+                                    verify=self.ca.certificate)
+        self.assertEqual(response.status_code, 200)
@@ -0,0 +1,74 @@
+import unittest2
+
+from loads import top
+from loads.tests.support import hush
+from loads.transport import client
+from loads.transport.dashboard import LiveStats
+
+
+def _stats(rps=10.):
+    stats = LiveStats(window=10)
+    for agent_id in ('agent-1', 'agent-2'):
+        for index in range(int(rps / 2)):
+            stats.add({'data_type': 'add_hit', 'status': 200,
+                       'elapsed': .1, 'agent_id': agent_id,
+                       'url': 'http://a', 'method': 'GET'})
+    snapshot = stats.snapshot()
+    snapshot.update({'run_id': 'run', 'active': True})
+    return snapshot
+
+
+class FakeClient(object):
+
+    def __init__(self, *args, **kw):
+        pass
+
+    def get_live_stats(self, run_id):
+        if run_id == 'unknown':
+            raise ValueError('Unknown run unknown')
+        return _stats()
+
+    def close(self):
+        pass
+
+
+class TestTop(unittest2.TestCase):
+
+    def test_sparkline(self):
+        self.assertEqual(top.sparkline([0, 4, 8, None, 2], top.ASCII_SPARKS),
+                         u'_=# -')
+        self.assertEqual(top.sparkline([0, 0]), top.SPARKS[0] * 2)
+        self.assertEqual(top.sparkline([]), u'')
+
+    def test_history(self):
+        history = top.History(size=2)
+        for rps in (10., 20., 40.):
+            history.add(_stats(rps))
+        self.assertEqual(history.get('total', 'rps'), [2., 4.])
+        self.assertEqual(history.get('agent-1', 'rps', 1), [2.])
+        self.assertEqual(history.get('agent-3', 'rps'), [])
+
+    def test_render(self):
+        history = top.History()
+        history.add(_stats())
+        lines = top.render(_stats(), history, chars=top.ASCII_SPARKS)
+        self.assertTrue(lines[0].startswith(u'Run run -- active -- '))
+        self.assertTrue(lines[2].startswith(u'RPS 1.0   errors 0.00%   '
+                                            u'users 0   p50 100.0ms'))
+        agents = [line for line in lines if line.startswith('agent-')]
+        self.assertEqual(len(agents), 2)
+        self.assertTrue(agents[0].endswith(u'0.00%   100.0ms  #'))
+
+        stats = _stats(0)
+        stats['active'] = False
+        lines = top.render(stats, top.History())
+        self.assertEqual(lines[-1], u'No results in the last 10s.')
+        self.assertTrue(u'-- over --' in lines[0])
+
+    @hush
+    def test_once(self):
+        old = client.Client
+        client.Client = FakeClient
+        self.addCleanup(setattr, client, 'Client', old)
+        self.assertEqual(top.main(['run', '--once', '--ascii']), 0)
+        self.assertEqual(top.main(['unknown', '--once']), 1)
@@ -0,0 +1,142 @@
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+
+import unittest2
+
+from loads.measure import Session
+from loads.results import TestResult
+from loads.tracing import (TracedHTTPAdapter, PHASES, start_trace,
+                           record_phase, record_dns, set_trace, new_span,
+                           format_traceparent)
+
+
+class _Handler(BaseHTTPRequestHandler):
+    protocol_version = 'HTTP/1.1'
+
+    def do_GET(self):
+        self.server.traceparents.append(self.headers.get('traceparent'))
+        self.server.request_ids.append(self.headers.get('X-Request-Id'))
+        if self.path == '/redirect':
+            self.send_response(302)
+            self.send_header('Location', '/ok')
+            self.send_header('Content-Length', '0')
+            self.end_headers()
+            return
+
+        self.send_response(200)
+        self.send_header('Content-Length', '5')
+        self.end_headers()
+        self.wfile.write('hello')
+
+    def log_message(self, *args):
+        pass
+
+
+class _FakeTest(object):
+    _testMethodName = 'test_fake'
+
+
+class TestTracing(unittest2.TestCase):
+
+    def setUp(self):
+        self.server = HTTPServer(('127.0.0.1', 0), _Handler)
+        self.server.traceparents = []
+        self.server.request_ids = []
+        self.url = 'http://127.0.0.1:%d' % self.server.server_port
+        thread = threading.Thread(target=self.server.serve_forever)
+        thread.daemon = True
+        thread.start()
+
+        self.result = TestResult()
+        self.session = Session(_FakeTest(), self.result)
+        self.session.mount('http://', TracedHTTPAdapter())
+
+    def tearDown(self):
+        self.session.close()
+        self.server.shutdown()
+        self.server.server_close()
+        set_trace(None)
+
+    def test_trace(self):
+        record_dns(.1)
+        trace = start_trace()
+        self.assertEqual(sorted(trace), sorted(PHASES))
+        self.assertEqual(trace['dns'], .1)
+
+        record_phase('connect', .2)
+        record_phase('connect', .3)
+        self.assertEqual(trace['connect'], .5)
+
+        # the DNS lookup is only used by the next request
+        self.assertEqual(start_trace()['dns'], 0)
+
+    def test_phases_are_recorded(self):
+        self.assertEqual(self.session.get(self.url + '/ok').text, 'hello')
+        self.session.get(self.url + '/ok')
+
+        first, second = [hit.phases for hit in self.result.hits]
+        self.assertTrue(first['connect'] > 0)
+        self.assertEqual(first['tls'], 0)
+        self.assertTrue(first['ttfb'] > 0)
+        self.assertTrue(first['body'] >= 0)
+
+        # the connection is reused
+        self.assertEqual(second['connect'], 0)
+        self.assertEqual(self.result.hits[0].scenario, 'test_fake')
+
+        metrics = self.result.get_phase_metrics()
+        self.assertEqual(sorted(metrics), sorted(PHASES))
+        self.assertTrue(metrics['connect']['average'] > 0)
+
+    def test_every_redirect_is_a_hit(self):
+        res = self.session.get(self.url + '/redirect')
+        self.assertEqual(res.status_code, 200)
+        self.assertEqual(sorted([hit.status for hit in self.result.hits]),
+                         [200, 302])
+        self.assertTrue(all([hit.phases is not None
+                             for hit in self.result.hits]))
+
+    def test_span(self):
+        trace_id, span_id = new_span()
+        self.assertEqual(len(trace_id), 32)
+        self.assertEqual(len(span_id), 16)
+        self.assertNotEqual(new_span(), (trace_id, span_id))
+        self.assertEqual(format_traceparent('a' * 32, 'b' * 16),
+                         '00-%s-%s-01' % ('a' * 32, 'b' * 16))
+
+    def test_traceparent(self):
+        self.session.get(self.url + '/ok')
+        self.assertEqual(self.server.traceparents, [None])
+        self.assertEqual(self.result.hits[0].span, None)
+
+        self.session.trace_requests = True
+        self.session.get(self.url + '/ok')
+        trace_id, span_id = self.result.hits[1].span
+        self.assertEqual(self.server.traceparents[1],
+                         format_traceparent(trace_id, span_id))
+
+        # the header given by the test is kept
+        header = format_traceparent('a' * 32, 'b' * 16)
+        self.session.get(self.url + '/ok', headers={'traceparent': header})
+        self.assertEqual(self.server.traceparents[2], header)
+        self.assertEqual(self.result.hits[2].span, None)
+
+    def test_request_id(self):
+        self.session.get(self.url + '/ok')
+        self.assertEqual(self.server.request_ids, [None])
+        self.assertEqual(self.result.hits[0].request_id, None)
+
+        self.session.request_id_header = 'X-Request-Id'
+        self.session.get(self.url + '/ok')
+        self.session.get(self.url + '/ok')
+        first, second = self.server.request_ids[1:]
+        self.assertEqual(len(first), 32)
+        self.assertNotEqual(first, second)
+        self.assertEqual([hit.request_id for hit in self.result.hits[1:]],
+                         [first, second])
+
+        # the redirects keep the id of the first request
+        self.session.get(self.url + '/redirect')
+        self.assertEqual(len(set(self.server.request_ids[3:])), 1)
+        self.assertEqual(self.result.hits[3].request_id,
+                         self.server.request_ids[3])
@@ -0,0 +1,76 @@
+import socket
+import threading
+
+import unittest2
+import mock
+
+from loads.engines.udp import UDPClient
+from loads.results import TestResult
+
+
+class _EchoServer(threading.Thread):
+    """Echoes the datagrams, dropping or swapping some of them."""
+
+    def __init__(self, drop=(), swap=False):
+        super(_EchoServer, self).__init__()
+        self.daemon = True
+        self.drop = drop
+        self.swap = swap
+        self.sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+        self.sock.bind(('127.0.0.1', 0))
+        self.port = self.sock.getsockname()[1]
+
+    def run(self):
+        count = 0
+        held = None
+        while True:
+            try:
+                data, address = self.sock.recvfrom(1024)
+            except socket.error:
+                break
+            count += 1
+            if count in self.drop:
+                continue
+            if self.swap and held is None:
+                held = data
+                continue
+            self.sock.sendto(data, address)
+            if held is not None:
+                self.sock.sendto(held, address)
+                held = None
+
+
+class TestUDPClient(unittest2.TestCase):
+
+    def _run(self, server, **options):
+        server.start()
+        self.addCleanup(server.sock.close)
+        result = TestResult()
+        test_case = mock.Mock(_loads_status=None)
+        client = UDPClient('127.0.0.1', server.port, result,
+                           test_case=test_case, timeout=.3)
+        self.addCleanup(client.close)
+        return result, test_case, client.run('ping', **options)
+
+    def test_echo(self):
+        result, test_case, stats = self._run(_EchoServer(), count=10,
+                                             rate=100)
+        self.assertEqual(stats['sent'], 10)
+        self.assertEqual(stats['received'], 10)
+        self.assertEqual(stats['lost'], 0)
+        self.assertEqual(len(result.hits), 10)
+        self.assertTrue(result.hits[0].url.startswith('udp://127.0.0.1:'))
+        self.assertEqual(result.hits_success_rate(), 1)
+
+    def test_loss(self):
+        result, test_case, stats = self._run(_EchoServer(drop=(2, 5)),
+                                             count=6)
+        self.assertEqual(stats['lost'], 2)
+        self.assertEqual(stats['received'], 4)
+        test_case.incr_counter.assert_called_with('udp-lost', 2)
+
+    def test_out_of_order(self):
+        result, test_case, stats = self._run(_EchoServer(swap=True),
+                                             count=2)
+        self.assertEqual(stats['received'], 2)
+        self.assertEqual(stats['out_of_order'], 1)
@@ -15,10 +15,13 @@ from loads import util
 from loads.util import (resolve_name, set_logger, logger, dns_resolve,
                         DateTimeJSONEncoder, try_import, split_endpoint,
                         null_streams, get_quantiles, pack_include_files,
-                        unpack_include_files, dict_hash)
+                        unpack_include_files, dict_hash, parse_duration,
+                        parse_stages, get_url_pattern, parse_tags,
+                        parse_timestamp)
 from loads.transport.util import (register_ipc_file, _cleanup_ipc_files, send,
                                   TimeoutError, recv, decode_params,
-                                  dump_stacks)
+                                  dump_stacks, split_endpoints,
+                                  join_endpoints, resolve_endpoint, connect)
 
 
 class _BadSocket(object):
@@ -184,6 +187,32 @@ class TestUtil(unittest2.TestCase):
         self.assertRaises(NotImplementedError, split_endpoint,
                           'wat://ddf:ff:f')
 
+    def test_parse_tags(self):
+        self.assertEqual(parse_tags('region=eu-west, az=eu-west-1a,bad'),
+                         {'region': 'eu-west', 'az': 'eu-west-1a'})
+        self.assertEqual(parse_tags(None), {})
+
+    def test_endpoints(self):
+        self.assertEqual(split_endpoints('ipc://a, ipc://b,'),
+                         ['ipc://a', 'ipc://b'])
+        self.assertEqual(join_endpoints('ipc://a', None, 'ipc://b,ipc://a'),
+                         'ipc://a,ipc://b')
+        self.assertEqual(resolve_endpoint('tcp://0.0.0.0:7776',
+                                          'tcp://10.0.0.1:7780,ipc://b'),
+                         'tcp://10.0.0.1:7776')
+        self.assertEqual(resolve_endpoint('ipc://a', 'tcp://10.0.0.1:7780'),
+                         'ipc://a')
+
+        socket = mock.Mock()
+        connect(socket, 'ipc://a')
+        socket.connect.assert_called_once_with('ipc://a')
+        self.assertFalse(socket.setsockopt.called)
+
+        socket = mock.Mock()
+        connect(socket, 'ipc://a,ipc://b')
+        self.assertEqual(socket.connect.call_count, 2)
+        socket.setsockopt.assert_called_once_with(zmq.IMMEDIATE, 1)
+
     def test_datetime_json_encoder(self):
         encoder = DateTimeJSONEncoder()
         date = datetime.datetime(2013, 5, 30, 18, 35, 11, 550482)
@@ -210,6 +239,38 @@ class TestUtil(unittest2.TestCase):
         stream.write('ok')
         sys.stdout.write('ok')
 
+    def test_parse_duration(self):
+        self.assertEqual(parse_duration('90'), 90)
+        self.assertEqual(parse_duration('30s'), 30)
+        self.assertEqual(parse_duration('2m'), 120)
+        self.assertEqual(parse_duration('1h'), 3600)
+        self.assertRaises(ValueError, parse_duration, '')
+        self.assertRaises(ValueError, parse_duration, '2x')
+
+    def test_parse_stages(self):
+        stages = parse_stages('2m:100, 10m:100, 2m:0')
+        self.assertEqual(stages, [(120, 100), (600, 100), (120, 0)])
+        self.assertRaises(ValueError, parse_stages, '2m')
+        self.assertRaises(ValueError, parse_stages, '')
+
+    def test_parse_timestamp(self):
+        self.assertEqual(parse_timestamp('1970-01-01T00:00:01.5Z'), 1.5)
+        self.assertEqual(parse_timestamp('1970-01-01T01:00:01+01:00'), 1)
+        self.assertEqual(parse_timestamp('01/Jan/1970:00:00:01 -0100'), 3601)
+        self.assertRaises(ValueError, parse_timestamp, 'yesterday')
+        self.assertRaises(ValueError, parse_timestamp, '01/Foo/1970:00:00:01')
+
+    def test_get_url_pattern(self):
+        self.assertEqual(get_url_pattern('http://api/users/42/items?x=1'),
+                         'http://api/users/{id}/items')
+        uuid = '6ba7b810-9dad-11d1-80b4-00c04fd430c8'
+        self.assertEqual(get_url_pattern('http://api/u/' + uuid),
+                         'http://api/u/{uuid}')
+        self.assertEqual(get_url_pattern('http://api/f/' + 'ab12' * 8),
+                         'http://api/f/{hash}')
+        self.assertEqual(get_url_pattern('http://api/v2/users/'),
+                         'http://api/v2/users/')
+
 
 class TestIncludeFileHandling(unittest2.TestCase):
 
@@ -1,4 +1,7 @@
 import unittest2
+import mock
+
+from loads.results import TestResult
 from loads.websockets import WebSocketClient, create_ws
 
 
@@ -15,3 +18,25 @@ class TestWebSockets(unittest2.TestCase):
 
         ws = create_ws('ws://example.com', None, None, klass=WS)
         self.assertTrue(isinstance(ws, WS))
+
+    def test_socket_metrics(self):
+        result = TestResult()
+        ws = create_ws('ws://example.com', result)
+        self.assertEqual(result.opened_sockets, 1)
+        self.assertEqual(len(result.socket_connect_times), 1)
+
+        ws.receive = mock.Mock(return_value='pong')
+        self.assertEqual(ws.send_receive('ping'), 'pong')
+        self.assertEqual(len(result.socket_rtts), 1)
+
+        # the server closes the socket
+        ws.closed(1006)
+        self.assertEqual(result.socket_disconnects, 1)
+        self.assertEqual(result.sockets, 0)
+
+        # closing it ourselves isn't a disconnection
+        ws = create_ws('ws://example.com', result)
+        ws.close()
+        ws.closed(1000)
+        self.assertEqual(result.socket_disconnects, 1)
+        self.assertEqual(result.sockets, 0)
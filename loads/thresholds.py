@@ -0,0 +1,204 @@
+"""Thresholds are evaluated on the results at the end of a run, e.g.
+"p95 < 250ms" or "error_rate < 1%" -- or on the custom metrics of the
+tests, e.g. "trend.server-db.p95 < 50", see :mod:`loads.custom`.
+
+The times and the hits error rate can be the ones of a single endpoint,
+given after an "@", e.g. "p95 < 250ms @ /items/{id}". The thresholds can
+also be read from a file -- one per line, the lines starting with "#"
+being comments -- like the ones *--baseline* writes, see
+:mod:`loads.baseline`.
+"""
+import operator
+import re
+
+from loads.custom import get_custom_metric, parse_metric
+
+
+_THRESHOLD = re.compile(r'^\s*([\w.-]+)\s*(<=|>=|==|<|>)\s*(\d+(?:\.\d*)?)\s*'
+                        r'(ms|s|%)?\s*(?:@\s*(\S.*?))?\s*$')
+
+_OPERATORS = {'<': operator.lt, '<=': operator.le, '>': operator.gt,
+              '>=': operator.ge, '==': operator.eq}
+
+_QUANTILES = {'p50': 50, 'p90': 90, 'p95': 95, 'p99': 99}
+
+_TIMES = ('avg', 'max') + tuple(_QUANTILES)
+_RATES = ('error_rate', 'hits_error_rate')
+_OTHERS = ('rps',)
+# the metrics that can be the ones of an endpoint
+_ENDPOINT_METRICS = _TIMES + ('hits_error_rate',)
+
+
+def get_metric(test_result, metric, endpoint=None):
+    """Returns the value of the metric -- for all the endpoints, or for the
+    given one -- or None when there's no data.
+
+    The times are in seconds and the rates in percents. The percentiles
+    are corrected for the coordinated omission in the closed model.
+    """
+    custom = parse_metric(metric)
+    if custom is not None:
+        return get_custom_metric(test_result, *custom)
+
+    if metric in _TIMES:
+        histogram = test_result.get_histogram(endpoint)
+        if histogram.total_count == 0:
+            return None
+        if metric == 'avg':
+            return histogram.get_mean() / 10 ** 6
+        if metric == 'max':
+            return float(histogram.max) / 10 ** 6
+        return test_result.get_request_time_percentile(_QUANTILES[metric],
+                                                       url=endpoint)
+
+    if metric == 'error_rate':
+        if not test_result.nb_finished_tests:
+            return None
+        errors = test_result.nb_errors + test_result.nb_failures
+        return errors * 100. / test_result.nb_finished_tests
+
+    if metric == 'hits_error_rate':
+        hits = test_result.hits
+        if endpoint is not None:
+            hits = [hit for hit in hits if hit.endpoint == endpoint]
+        if not hits:
+            return None
+        errors = len([hit for hit in hits if not hit.success])
+        return errors * 100. / len(hits)
+
+    if metric == 'rps':
+        return test_result.requests_per_second()
+
+    raise ValueError('Unknown metric %r' % metric)
+
+
+def get_live_metric(totals, metric):
+    """Returns the value of the metric on the totals of a run kept in the
+    live stats -- see :meth:`RedisLiveStats.get_totals` -- or None when
+    there's no data.
+
+    The percentiles are the ones of the hits as they are, without the
+    correction for the coordinated omission. The live stats have no custom
+    metrics, and no metrics by endpoint.
+    """
+    if parse_metric(metric) is not None:
+        return None
+
+    if metric in _TIMES:
+        histogram = totals['histogram']
+        if histogram.total_count == 0:
+            return None
+        if metric == 'avg':
+            return histogram.get_mean() / 10 ** 6
+        if metric == 'max':
+            return float(histogram.max) / 10 ** 6
+        value = histogram.get_value_at_percentile(_QUANTILES[metric])
+        return float(value) / 10 ** 6
+
+    if metric == 'error_rate':
+        if not totals['tests']:
+            return None
+        return totals['failures'] * 100. / totals['tests']
+
+    if metric == 'hits_error_rate':
+        if not totals['hits']:
+            return None
+        return totals['errors'] * 100. / totals['hits']
+
+    if metric == 'rps':
+        if totals['started'] is None:
+            return None
+        duration = totals['updated'] - totals['started']
+        return totals['hits'] / max(duration, 1.)
+
+    raise ValueError('Unknown metric %r' % metric)
+
+
+class Threshold(object):
+
+    def __init__(self, expression):
+        match = _THRESHOLD.match(expression)
+        if match is None:
+            raise ValueError('Invalid threshold %r' % expression)
+
+        self.expression = expression.strip()
+        self.metric, op, value, unit, self.endpoint = match.groups()
+        self.operator = _OPERATORS[op]
+        self.value = float(value)
+        if (self.endpoint is not None and
+                self.metric not in _ENDPOINT_METRICS):
+            raise ValueError('%r is not a metric of the endpoints' %
+                             self.metric)
+
+        if self.metric in _TIMES:
+            if unit == 'ms':
+                self.value /= 1000.
+            elif unit not in (None, 's'):
+                raise ValueError('%r is a time' % self.metric)
+        elif self.metric in _RATES:
+            if unit not in (None, '%'):
+                raise ValueError('%r is a rate' % self.metric)
+        elif self.metric in _OTHERS or parse_metric(self.metric):
+            if unit is not None:
+                raise ValueError('%r has no unit' % self.metric)
+        else:
+            raise ValueError('Unknown metric %r' % self.metric)
+
+    def __str__(self):
+        return self.expression
+
+    def evaluate(self, test_result):
+        """Returns the value of the metric, and whether the threshold
+        passed -- it fails when there is no data."""
+        return self._check(get_metric(test_result, self.metric,
+                                      self.endpoint))
+
+    def evaluate_live(self, totals):
+        """Like :meth:`evaluate`, on the totals of a run kept in the live
+        stats."""
+        if self.endpoint is not None:
+            return self._check(None)
+        return self._check(get_live_metric(totals, self.metric))
+
+    def format(self, value):
+        """Returns a value of the metric, in its unit."""
+        if value is None:
+            return 'no data'
+        if self.metric in _TIMES:
+            return '%.1fms' % (value * 1000)
+        if self.metric in _RATES:
+            return '%.2f%%' % value
+        return '%.1f' % value
+
+    def _check(self, value):
+        if value is None:
+            return None, False
+        return value, self.operator(value, self.value)
+
+
+def parse_thresholds(expressions):
+    """Returns the list of thresholds.
+
+    :param expressions: a list of expressions, or of comma-separated
+                        expressions.
+    """
+    thresholds = []
+    for expression in expressions or []:
+        for item in expression.split(','):
+            if item.strip() == '':
+                continue
+            thresholds.append(Threshold(item))
+    return thresholds
+
+
+def read_thresholds(path):
+    """Returns the list of the thresholds of a file -- one per line, the
+    empty lines and the ones starting with "#" left out."""
+    thresholds = []
+    with open(path) as f:
+        for line in f:
+            line = line.strip()
+            if line == '' or line.startswith('#'):
+                continue
+            thresholds.append(Threshold(line))
+    return thresholds
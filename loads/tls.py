@@ -0,0 +1,136 @@
+"""The TLS configuration of the HTTPS connections: the client certificates
+-- one, or a pool of them used in turn by the virtual users --, the TLS
+versions, the cipher suites, the name sent with SNI and the resumption of
+the TLS sessions.
+
+Every virtual user has its own TLS context: the sessions of its first
+connections are resumed by its next ones, to a given host. The handshakes
+are counted in *tls-resumed* and *tls-full-handshakes*. The resumption
+needs a Python with *ssl.SSLSession*: without it, every handshake is a
+full one.
+"""
+import itertools
+import ssl
+import threading
+
+
+VERSIONS = ('TLSv1', 'TLSv1.1', 'TLSv1.2', 'TLSv1.3')
+_NO_VERSIONS = {'TLSv1': 'OP_NO_TLSv1', 'TLSv1.1': 'OP_NO_TLSv1_1',
+                'TLSv1.2': 'OP_NO_TLSv1_2', 'TLSv1.3': 'OP_NO_TLSv1_3'}
+CAN_RESUME = hasattr(ssl, 'SSLSession')
+
+_POOLS = {}
+_LOCK = threading.Lock()
+
+
+def parse_cert(value):
+    """Returns the (certificate, key) of a *cert.pem[,key.pem]* option --
+    without a key file, the key is in the certificate file."""
+    if isinstance(value, (list, tuple)):
+        return tuple(value)
+    if ',' in value:
+        cert, key = value.split(',', 1)
+        return cert.strip(), key.strip()
+    return value.strip(), None
+
+
+class _Context(ssl.SSLContext):
+    """Resumes the TLS sessions of the previous connections to the same
+    host."""
+    def __init__(self, protocol):
+        ssl.SSLContext.__init__(self, protocol)
+        self.resumption = True
+        self._sessions = {}
+
+    def wrap_socket(self, sock, server_hostname=None, **options):
+        resume = CAN_RESUME and self.resumption
+        if resume and server_hostname in self._sessions:
+            options['session'] = self._sessions[server_hostname]
+        sock = ssl.SSLContext.wrap_socket(
+            self, sock, server_hostname=server_hostname, **options)
+        if resume and sock.session is not None:
+            self._sessions[server_hostname] = sock.session
+        return sock
+
+
+class TLSConfig(object):
+    """The TLS configuration of a virtual user.
+
+    :param cert: the client certificate -- a file, or a (certificate, key)
+                 tuple.
+    :param min_version: the oldest TLS version allowed, like *TLSv1.2*.
+    :param max_version: the newest TLS version allowed.
+    :param ciphers: the cipher suites, in the OpenSSL format.
+    :param server_name: the name sent with SNI, and checked in the
+                        certificate of the server.
+    :param resumption: when False, every connection does a full handshake.
+    """
+    def __init__(self, cert=None, min_version=None, max_version=None,
+                 ciphers=None, server_name=None, resumption=True):
+        for version in (min_version, max_version):
+            if version is not None and version not in VERSIONS:
+                raise ValueError('Unknown TLS version %r' % version)
+        if (min_version is not None and max_version is not None and
+                VERSIONS.index(min_version) > VERSIONS.index(max_version)):
+            raise ValueError('The minimum TLS version is above the maximum')
+        self.cert = cert
+        self.min_version = min_version
+        self.max_version = max_version
+        self.ciphers = ciphers
+        self.server_name = server_name
+        self.resumption = resumption
+
+    def create_context(self):
+        """Returns the SSL context of the connections."""
+        context = _Context(ssl.PROTOCOL_SSLv23)
+        context.options |= ssl.OP_NO_SSLv2 | ssl.OP_NO_SSLv3
+        context.options |= getattr(ssl, 'OP_NO_COMPRESSION', 0)
+        if self.min_version is not None:
+            for version in VERSIONS[:VERSIONS.index(self.min_version)]:
+                context.options |= getattr(ssl, _NO_VERSIONS[version], 0)
+        if self.max_version is not None:
+            for version in VERSIONS[VERSIONS.index(self.max_version) + 1:]:
+                context.options |= getattr(ssl, _NO_VERSIONS[version], 0)
+        if self.ciphers:
+            context.set_ciphers(self.ciphers)
+        if not self.resumption:
+            context.resumption = False
+            context.options |= getattr(ssl, 'OP_NO_TICKET', 0)
+        if self.cert is not None:
+            cert, key = parse_cert(self.cert)
+            context.load_cert_chain(cert, key)
+        return context
+
+
+class CertPool(object):
+    """Gives the client certificates to the virtual users, in turn."""
+    def __init__(self, certs):
+        if not certs:
+            raise ValueError('No client certificate')
+        self.certs = [parse_cert(cert) for cert in certs]
+        self._cycle = itertools.cycle(self.certs)
+        self._lock = threading.Lock()
+
+    def next(self):
+        with self._lock:
+            return self._cycle.next()
+
+
+def get_tls_config(config):
+    """Returns the TLS configuration of the next virtual user of the
+    options, or None when there is none."""
+    certs = tuple(config.get('client_cert') or ())
+    options = dict([(name, config.get('tls_' + name))
+                    for name in ('min_version', 'max_version', 'ciphers',
+                                 'server_name')])
+    resumption = not config.get('no_tls_resumption')
+    if not certs and not any(options.values()) and resumption:
+        return None
+
+    cert = None
+    if certs:
+        with _LOCK:
+            if certs not in _POOLS:
+                _POOLS[certs] = CertPool(certs)
+        cert = _POOLS[certs].next()
+    return TLSConfig(cert, resumption=resumption, **options)
@@ -0,0 +1,197 @@
+""" A live view of a distributed run in the terminal -- for the runs
+watched over SSH, far from the web dashboard::
+
+    $ loads-top 1e2bb2a4-8d8b-4bd4-b3ae-7ec3a4de9d8b --broker tcp://host:7780
+
+It shows the requests per second, the error rate, the users and the
+request times of the run and of every agent, with their trends since
+*loads-top* started in sparklines. The broker needs the live stats of
+its dashboard -- see *--dashboard-address* and *--live-redis*.
+
+*q* quits, and *--once* prints a single view, for the scripts.
+"""
+import argparse
+import curses
+import locale
+import sys
+import time
+
+
+SPARKS = u'\u2581\u2582\u2583\u2584\u2585\u2586\u2587\u2588'
+ASCII_SPARKS = u'_.-:=+*#'
+# the samples kept for the sparklines
+HISTORY = 120
+
+
+def sparkline(values, chars=SPARKS):
+    """Returns the sparkline of the values -- the missing ones are
+    blanks."""
+    known = [value for value in values if value is not None]
+    highest = max(known + [0])
+    line = []
+    for value in values:
+        if value is None:
+            line.append(u' ')
+        elif not highest:
+            line.append(chars[0])
+        else:
+            index = int(float(value) / highest * (len(chars) - 1) + .5)
+            line.append(chars[index])
+    return u''.join(line)
+
+
+def _ms(value):
+    if value is None:
+        return '-'
+    return '%.1fms' % value
+
+
+class History(object):
+    """The last samples of the stats of a run, and of its agents."""
+
+    def __init__(self, size=HISTORY):
+        self.size = size
+        self.samples = {}
+
+    def _add(self, key, value):
+        values = self.samples.setdefault(key, [])
+        values.append(value)
+        del values[:-self.size]
+
+    def add(self, stats):
+        total = stats['total']
+        for metric in ('rps', 'error_rate', 'p95'):
+            self._add(('total', metric), total.get(metric))
+        for agent, agent_stats in stats['agents'].items():
+            self._add((agent, 'rps'), agent_stats['total']['rps'])
+
+    def get(self, key, metric, size=None):
+        values = self.samples.get((key, metric), [])
+        if size is not None:
+            values = values[-size:]
+        return values
+
+
+def render(stats, history, width=80, chars=SPARKS):
+    """Returns the lines of the view of the live stats of a run."""
+    total = stats['total']
+    state = stats.get('active') and 'active' or 'over'
+    lines = [u'Run %s -- %s -- %s' % (
+                 stats.get('run_id', '?'), state,
+                 time.strftime('%H:%M:%S', time.localtime(stats['time']))),
+             u'',
+             u'RPS %.1f   errors %.2f%%   users %d   p50 %s   p95 %s   '
+             u'p99 %s' % (total['rps'], total['error_rate'],
+                          total['users'], _ms(total['p50']),
+                          _ms(total['p95']), _ms(total['p99'])),
+             u'']
+
+    spark_width = max(width - 10, 10)
+    for label, metric in (('RPS', 'rps'), ('errors', 'error_rate'),
+                          ('p95', 'p95')):
+        values = history.get('total', metric, spark_width)
+        lines.append(u'%-8s  %s' % (label, sparkline(values, chars)))
+
+    lines.extend([u'', u'%-20s %6s %9s %8s %9s  %s' % (
+        'Agent', 'Users', 'RPS', 'Errors', 'p95', 'RPS trend')])
+    spark_width = max(width - 66, 10)
+    for agent in sorted(stats['agents']):
+        agent_total = stats['agents'][agent]['total']
+        values = history.get(agent, 'rps', spark_width)
+        lines.append(u'%-20s %6d %9.1f %7.2f%% %9s  %s' % (
+            agent[:20], agent_total['users'], agent_total['rps'],
+            agent_total['error_rate'], _ms(agent_total['p95']),
+            sparkline(values, chars)))
+    if not stats['agents']:
+        lines.append(u'No results in the last %ds.' % stats['window'])
+    return lines
+
+
+def _get_chars(ascii=False):
+    """Returns the characters of the sparklines the terminal can show."""
+    if ascii:
+        return ASCII_SPARKS
+    try:
+        SPARKS.encode(locale.getpreferredencoding() or 'ascii')
+    except (UnicodeEncodeError, LookupError):
+        return ASCII_SPARKS
+    return SPARKS
+
+
+def _watch(screen, client, run_id, interval, chars):
+    encoding = locale.getpreferredencoding() or 'ascii'
+    try:
+        curses.curs_set(0)
+    except curses.error:
+        pass
+    screen.timeout(int(interval * 1000))
+
+    history = History()
+    lines = []
+    while True:
+        try:
+            stats = client.get_live_stats(run_id)
+        except Exception, e:
+            lines = lines[:1] + [u'No stats from the broker: %s' % e]
+        else:
+            history.add(stats)
+            height, width = screen.getmaxyx()
+            lines = render(stats, history, width, chars)
+
+        height, width = screen.getmaxyx()
+        screen.erase()
+        for index, line in enumerate(lines[:height]):
+            screen.addstr(index, 0, line[:width - 1].encode(encoding,
+                                                            'replace'))
+        screen.refresh()
+
+        if screen.getch() in (ord('q'), ord('Q'), 27):
+            return
+
+
+def main(args=sys.argv[1:]):
+    parser = argparse.ArgumentParser(description='Shows the live stats of '
+                                                 'a distributed run in the '
+                                                 'terminal.')
+    parser.add_argument('run_id', help='The id of the run')
+    parser.add_argument('--broker', default=None,
+                        help='The broker of the run')
+    parser.add_argument('--api-key', default=None,
+                        help='The API key of the broker')
+    parser.add_argument('--interval', type=float, default=1.,
+                        help='The seconds between two updates')
+    parser.add_argument('--once', action='store_true', default=False,
+                        help='Prints the stats once, and exits')
+    parser.add_argument('--ascii', action='store_true', default=False,
+                        help='Draws the sparklines with ASCII characters')
+    args = parser.parse_args(args)
+    if args.interval <= 0:
+        parser.error('The interval is positive')
+
+    from loads.transport.client import Client
+    from loads.transport.util import DEFAULT_FRONTEND
+    locale.setlocale(locale.LC_ALL, '')
+    chars = _get_chars(args.ascii)
+    client = Client(args.broker or DEFAULT_FRONTEND, api_key=args.api_key)
+    try:
+        if args.once:
+            try:
+                stats = client.get_live_stats(args.run_id)
+            except ValueError, e:
+                print(e)
+                return 1
+            history = History()
+            history.add(stats)
+            encoding = locale.getpreferredencoding() or 'ascii'
+            for line in render(stats, history, chars=chars):
+                print(line.encode(encoding, 'replace'))
+            return 0
+
+        curses.wrapper(_watch, client, args.run_id, args.interval, chars)
+    finally:
+        client.close()
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
@@ -0,0 +1,270 @@
+"""Measures the phases of the HTTP requests: the DNS lookup, the TCP
+connection, the TLS handshake, the time to first byte (TTFB) and the
+body read.
+
+The phases of the current request are kept in a greenlet-local trace -- a
+thread-local when gevent did not monkey patch the stdlib.
+
+The requests can also start a client span, propagated to the server with
+a W3C *traceparent* header, so the server-side traces can be correlated
+with the requests of the load test.
+"""
+import binascii
+import functools
+import os
+import socket
+import threading
+import time
+
+from requests.adapters import HTTPAdapter
+from requests.packages.urllib3.connection import (HTTPConnection,
+                                                  VerifiedHTTPSConnection)
+from requests.packages.urllib3.connectionpool import (HTTPConnectionPool,
+                                                      HTTPSConnectionPool)
+from requests.packages.urllib3.exceptions import (ConnectTimeoutError,
+                                                  NewConnectionError)
+
+from loads.dualstack import create_connection, get_family
+from loads.sockopts import merge_options
+
+
+PHASES = ('dns', 'connect', 'tls', 'ttfb', 'body')
+
+_local = threading.local()
+
+
+def start_trace():
+    """Starts the trace of a new request, and returns it.
+
+    The DNS lookup done before the request is added with
+    :func:`record_dns`.
+    """
+    trace = dict([(phase, 0.) for phase in PHASES])
+    trace['dns'] = getattr(_local, 'dns', 0.)
+    _local.dns = 0.
+    _local.handshake = None
+    _local.reused = None
+    _local.trace = trace
+    return trace
+
+
+def set_trace(trace):
+    _local.trace = trace
+
+
+def record_phase(phase, elapsed):
+    trace = getattr(_local, 'trace', None)
+    if trace is not None:
+        trace[phase] += elapsed
+
+
+def record_dns(elapsed):
+    """Records the DNS lookup of the next request."""
+    _local.dns = elapsed
+
+
+def record_handshake(resumed):
+    """Records a TLS handshake of the current request: a resumed one, or a
+    full one."""
+    _local.handshake = resumed
+
+
+def pop_handshake():
+    """Returns whether the TLS handshake of the last request was resumed,
+    or None when it did not do one."""
+    handshake = getattr(_local, 'handshake', None)
+    _local.handshake = None
+    return handshake
+
+
+def record_reuse(reused):
+    """Records whether the current request got a reused connection."""
+    _local.reused = reused
+
+
+def pop_reuse():
+    """Returns whether the last request got a reused connection, or None
+    when it did not get one from the traced pools."""
+    reused = getattr(_local, 'reused', None)
+    _local.reused = None
+    return reused
+
+
+def new_span():
+    """Returns the (trace id, span id) of a new client span, as the hex
+    strings of the W3C trace context."""
+    return (binascii.hexlify(os.urandom(16)),
+            binascii.hexlify(os.urandom(8)))
+
+
+def format_traceparent(trace_id, span_id, sampled=True):
+    """Returns the value of the traceparent header of a span."""
+    return '00-%s-%s-%s' % (trace_id, span_id, sampled and '01' or '00')
+
+
+class _TracedConnection(object):
+
+    def __init__(self, *args, **kwargs):
+        self._sources = kwargs.pop('sources', None)
+        self._family = kwargs.pop('family', None)
+        self._ip_options = kwargs.pop('ip_options', None)
+        super(_TracedConnection, self).__init__(*args, **kwargs)
+
+    def _new_conn(self):
+        if self._sources is not None:
+            # the local address of this connection -- see loads.sources
+            self.source_address = (self._sources.next(), 0)
+        start = time.time()
+        if self._family is None and self._ip_options is None:
+            conn = super(_TracedConnection, self)._new_conn()
+        else:
+            conn = self._new_family_conn()
+        # kept once httplib closed the socket
+        self.family = get_family(conn)
+        self._connect_time = time.time() - start
+        record_phase('connect', self._connect_time)
+        return conn
+
+    def _new_family_conn(self):
+        # the _new_conn of urllib3, with the addresses of the family and
+        # the IP options -- see loads.dualstack and loads.qos
+        host = getattr(self, '_dns_host', self.host)
+        try:
+            return create_connection((host, self.port), self._family,
+                                     self.timeout, self.source_address,
+                                     self.socket_options,
+                                     ip_options=self._ip_options)
+        except socket.timeout:
+            raise ConnectTimeoutError(
+                self, 'Connection to %s timed out. (connect timeout=%s)' %
+                (self.host, self.timeout))
+        except socket.error, e:
+            raise NewConnectionError(
+                self, 'Failed to establish a new connection: %s' % e)
+
+
+class TracedHTTPConnection(_TracedConnection, HTTPConnection):
+    pass
+
+
+class TracedHTTPSConnection(_TracedConnection, VerifiedHTTPSConnection):
+
+    def connect(self):
+        self._connect_time = 0
+        start = time.time()
+        super(TracedHTTPSConnection, self).connect()
+        record_phase('tls', time.time() - start - self._connect_time)
+        record_handshake(bool(getattr(self.sock, 'session_reused', False)))
+
+
+class _TracedPool(object):
+
+    def __init__(self, *args, **kwargs):
+        # see loads.pooling
+        self.max_idle = kwargs.pop('max_idle', None)
+        self.idle_timeout = kwargs.pop('idle_timeout', None)
+        super(_TracedPool, self).__init__(*args, **kwargs)
+
+    def _get_conn(self, timeout=None):
+        conn = super(_TracedPool, self)._get_conn(timeout)
+        idle_since = getattr(conn, '_idle_since', None)
+        if (self.idle_timeout is not None and idle_since is not None and
+                time.time() - idle_since > self.idle_timeout):
+            conn.close()
+        record_reuse(conn.sock is not None)
+        return conn
+
+    def _put_conn(self, conn):
+        if conn is not None:
+            conn._idle_since = time.time()
+            if (self.max_idle is not None and
+                    self._count_idle() >= self.max_idle):
+                conn.close()
+        super(_TracedPool, self)._put_conn(conn)
+
+    def _count_idle(self):
+        return len([conn for conn in list(self.pool.queue)
+                    if conn is not None and conn.sock is not None])
+
+
+class TracedHTTPConnectionPool(_TracedPool, HTTPConnectionPool):
+    ConnectionCls = TracedHTTPConnection
+
+
+class TracedHTTPSConnectionPool(_TracedPool, HTTPSConnectionPool):
+    ConnectionCls = TracedHTTPSConnection
+
+
+class TracedHTTPAdapter(HTTPAdapter):
+    """A Requests adapter that records the connection and the TLS handshake
+    of the new connections in the current trace.
+
+    The HTTPS connections use the :param tls: configuration -- see
+    :mod:`loads.tls` -- and the connections bind the local addresses of
+    :param sources: -- see :mod:`loads.sources`. They connect to the
+    addresses of :param family: when it is set -- see
+    :mod:`loads.dualstack` -- and the responses know the family of their
+    connection. The sockets get the :param ip_options: -- see
+    :mod:`loads.qos` -- and the :param socket_options:, after the ones of
+    urllib3 they don't replace -- see :mod:`loads.sockopts`. The pools
+    follow the :param pool: options -- see :mod:`loads.pooling` -- and
+    tell whether the requests reused their connection.
+    """
+    def __init__(self, tls=None, sources=None, family=None, ip_options=None,
+                 socket_options=None, pool=None, *args, **kwargs):
+        if pool is not None and pool.max_connections_per_host is not None:
+            kwargs['pool_maxsize'] = pool.max_connections_per_host
+            kwargs['pool_block'] = True
+        self.pool = pool
+        self.tls = tls
+        self.sources = sources
+        self.family = family
+        self.ip_options = ip_options
+        self.socket_options = socket_options
+        self.ssl_context = tls and tls.create_context() or None
+        HTTPAdapter.__init__(self, *args, **kwargs)
+
+    def init_poolmanager(self, *args, **kwargs):
+        if self.ssl_context is not None:
+            kwargs['ssl_context'] = self.ssl_context
+        if self.socket_options is not None:
+            kwargs['socket_options'] = self._get_socket_options()
+        HTTPAdapter.init_poolmanager(self, *args, **kwargs)
+        http_pool = TracedHTTPConnectionPool
+        https_pool = TracedHTTPSConnectionPool
+        conn_kw = {}
+        if self.sources is not None:
+            conn_kw['sources'] = self.sources
+        if self.family is not None:
+            conn_kw['family'] = self.family
+        if self.ip_options is not None:
+            conn_kw['ip_options'] = self.ip_options
+        if self.pool is not None:
+            conn_kw['max_idle'] = self.pool.max_idle
+            conn_kw['idle_timeout'] = self.pool.idle_timeout
+        if conn_kw:
+            http_pool = functools.partial(http_pool, **conn_kw)
+            https_pool = functools.partial(https_pool, **conn_kw)
+        if self.tls is not None and self.tls.server_name:
+            https_pool = functools.partial(
+                https_pool, server_hostname=self.tls.server_name)
+        self.poolmanager.pool_classes_by_scheme = {
+            'http': http_pool,
+            'https': https_pool}
+
+    def build_response(self, req, resp):
+        response = HTTPAdapter.build_response(self, req, resp)
+        response.family = getattr(getattr(resp, '_connection', None),
+                                  'family', None)
+        return response
+
+    def proxy_manager_for(self, proxy, **proxy_kwargs):
+        if self.ssl_context is not None:
+            proxy_kwargs['ssl_context'] = self.ssl_context
+        if self.socket_options is not None:
+            proxy_kwargs['socket_options'] = self._get_socket_options()
+        return HTTPAdapter.proxy_manager_for(self, proxy, **proxy_kwargs)
+
+    def _get_socket_options(self):
+        return merge_options(HTTPConnection.default_socket_options,
+                             self.socket_options)
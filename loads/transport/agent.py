@@ -21,14 +21,24 @@ import zmq
 from zmq.eventloop import ioloop, zmqstream
 
 from loads.transport import util
-from loads.util import logger, set_logger, json, unpack_include_files
+from loads.transport.capabilities import get_capabilities
+from loads.feeders import unpack_feeder
+from loads.util import (logger, set_logger, json, unpack_include_files,
+                        parse_tags)
 from loads.transport.util import (DEFAULT_FRONTEND, DEFAULT_TIMEOUT_MOVF,
                                   DEFAULT_MAX_AGE, DEFAULT_MAX_AGE_DELTA,
-                                  get_hostname)
+                                  PAUSE_SIGNAL, RESUME_SIGNAL, ABORT_SIGNAL,
+                                  LOAD_SIGNAL, LOAD_FILE, get_hostname)
 from loads.transport.message import Message
-from loads.transport.util import decode_params, timed
+from loads.transport.util import decode_params, timed, connect
 from loads.transport.heartbeat import Stethoscope
 from loads.transport.client import Client
+from loads.transport.metrics import Metrics, MetricsServer
+
+
+_SIGNALS = {'PAUSE': (PAUSE_SIGNAL, 'paused'),
+            'RESUME': (RESUME_SIGNAL, 'resumed'),
+            'ABORT': (ABORT_SIGNAL, 'aborting')}
 
 
 class ExecutionError(Exception):
@@ -54,11 +64,16 @@ class Agent(object):
       The agent will quit after *max_age + random(0, max_age_delta)*
       This is done to avoid having all agents quit at the same instant.
       Defaults to 0. The value must be an integer.
+    - **metrics_address**: the host:port where the Prometheus metrics of
+      the runs of this agent are served. None to not serve them.
+    - **tags**: a dict of tags, like {'region': 'eu-west'}. The results of
+      the runs are also reported per tag value.
     """
     def __init__(self, broker=DEFAULT_FRONTEND,
                  ping_delay=10., ping_retries=3,
                  params=None, timeout=DEFAULT_TIMEOUT_MOVF,
-                 max_age=DEFAULT_MAX_AGE, max_age_delta=DEFAULT_MAX_AGE_DELTA):
+                 max_age=DEFAULT_MAX_AGE, max_age_delta=DEFAULT_MAX_AGE_DELTA,
+                 metrics_address=None, tags=None):
         logger.debug('Initializing the agent.')
         self.debug = logger.isEnabledFor(logging.DEBUG)
         self.params = params
@@ -66,9 +81,11 @@ class Agent(object):
         self.timeout = timeout
         self.max_age = max_age
         self.max_age_delta = max_age_delta
+        self.tags = tags or {}
         self.env = os.environ.copy()
         self.running = False
         self._workers = {}
+        self._test_dirs = {}
         self._max_id = defaultdict(int)
 
         # Let's ask the broker its options
@@ -86,11 +103,11 @@ class Agent(object):
         # backend socket - used to receive work from the broker
         self._backend = self.ctx.socket(zmq.ROUTER)
         self._backend.identity = str(self.pid)
-        self._backend.connect(self.endpoints['backend'])
+        connect(self._backend, self.endpoints['backend'])
 
         # register socket - used to register into the broker
         self._reg = self.ctx.socket(zmq.PUSH)
-        self._reg.connect(self.endpoints['register'])
+        connect(self._reg, self.endpoints['register'])
 
         # hearbeat socket - used to check if the broker is alive
         heartbeat = self.endpoints.get('heartbeat')
@@ -112,6 +129,27 @@ class Agent(object):
                                               ping_delay * 1000,
                                               io_loop=self.loop)
 
+        # metrics - the results of our runs, read from the broker publisher
+        if metrics_address is not None:
+            self.metrics = Metrics(extra=self._get_gauges, agent_label=False)
+            self.metrics_server = MetricsServer(self.metrics, metrics_address)
+            self._sub = self.ctx.socket(zmq.SUB)
+            self._sub.setsockopt(zmq.SUBSCRIBE, '')
+            connect(self._sub, self.endpoints['publisher'])
+            self._substream = zmqstream.ZMQStream(self._sub, self.loop)
+            self._substream.on_recv(self._handle_recv_results)
+        else:
+            self.metrics = self.metrics_server = None
+
+    def _get_gauges(self):
+        return {('loads_runs_in_progress', 'Runs in progress.'):
+                len(self._workers)}
+
+    def _handle_recv_results(self, msg):
+        data = json.loads(msg[0])
+        if str(data.get('agent_id')) == str(self.pid):
+            self.metrics.add(data)
+
     def _run(self, args, run_id=None):
         logger.debug('Starting a run.')
 
@@ -119,6 +157,10 @@ class Agent(object):
         args['slave'] = True
         args['agent_id'] = self.pid
         args['zmq_receiver'] = self.endpoints['receiver']
+        # the tests reserve their ids on the broker -- see loads.sequences
+        args['broker'] = self.broker
+        if 'nats' in self.endpoints:
+            args['nats_receiver'] = self.endpoints['nats']
         args['run_id'] = run_id
 
         cmd = 'from loads.main import run;'
@@ -133,6 +175,7 @@ class Agent(object):
             raise ExecutionError(msg)
 
         self._workers[proc.pid] = proc, run_id
+        self._test_dirs[proc.pid] = args.get('test_dir')
         self._sync_hb()
         return proc.pid
 
@@ -186,6 +229,14 @@ class Agent(object):
             if filedata:
                 unpack_include_files(filedata, test_dir)
 
+            # the share of the --feeder file the broker sent to this agent
+            if data.get('feeder_partition') and data['args'].get('feeder'):
+                feeder = os.path.join(
+                    test_dir, os.path.basename(data['args']['feeder']))
+                unpack_feeder(data['feeder_partition'], feeder)
+                data['args']['feeder'] = feeder
+                data['args']['feeder_partitioned'] = True
+
             args = data['args']
             run_id = data.get('run_id')
             pid = self._run(args, run_id)
@@ -201,6 +252,19 @@ class Agent(object):
             logger.debug('asked to STOP all runs')
             return self._stop_runs(command)
 
+        elif command in _SIGNALS:
+            logger.debug('asked to %s all runs' % command)
+            return self._signal_runs(command)
+
+        elif command == 'SET_LOAD':
+            logger.debug('asked to change the load of all runs')
+            return self._set_load(command, data)
+
+        elif command == 'CAPABILITIES':
+            return {'result': {'capabilities': get_capabilities(),
+                               'agent_id': str(self.pid),
+                               'command': command}}
+
         elif command == 'QUIT':
             if len(self._workers) > 0 and not data.get('force', False):
                 # if we're busy we won't quit - unless forced !
@@ -223,16 +287,47 @@ class Agent(object):
             if proc.poll() is None:
                 proc.terminate()
                 del self._workers[pid]
+                self._test_dirs.pop(pid, None)
             status[pid] = {'status': 'terminated', 'run_id': run_id}
 
         self._sync_hb()
         return {'result': {'status': status,
                            'command': command}}
 
+    def _signal_runs(self, command):
+        signum, state = _SIGNALS[command]
+        status = {}
+        for pid, (proc, run_id) in self._workers.items():
+            if proc.poll() is None:
+                logger.debug('sending %s to the proc for run %s' %
+                             (command, str(run_id)))
+                proc.send_signal(signum)
+                status[pid] = {'status': state, 'run_id': run_id}
+
+        return {'result': {'status': status,
+                           'command': command}}
+
+    def _set_load(self, command, data):
+        load = dict([(key, data[key]) for key in ('users', 'rate', 'rps')
+                     if data.get(key) is not None])
+        status = {}
+        for pid, (proc, run_id) in self._workers.items():
+            if proc.poll() is not None:
+                continue
+            filename = os.path.join(self._test_dirs[pid], LOAD_FILE)
+            with open(filename, 'w') as f:
+                f.write(json.dumps(load))
+            proc.send_signal(LOAD_SIGNAL)
+            status[pid] = {'status': 'updated', 'run_id': run_id}
+
+        return {'result': {'status': status,
+                           'command': command}}
+
     def _check_proc(self):
         for pid, (proc, run_id) in self._workers.items():
             if not proc.poll() is None:
                 del self._workers[pid]
+                self._test_dirs.pop(pid, None)
         self._sync_hb()
 
     def _handle_recv_back(self, msg):
@@ -324,13 +419,16 @@ class Agent(object):
         if self.ping is not None:
             self.ping.stop()
         self._check.stop()
+        if self.metrics_server is not None:
+            self.metrics_server.stop()
         time.sleep(.1)
         self.ctx.destroy(0)
         logger.debug('Agent is stopped')
 
     def register(self):
         # telling the broker we are ready
-        data = {'pid': self.pid, 'hostname': get_hostname()}
+        data = {'pid': self.pid, 'hostname': get_hostname(),
+                'tags': self.tags}
         self._reg.send_multipart(['REGISTER', json.dumps(data)])
 
     def start(self):
@@ -343,6 +441,8 @@ class Agent(object):
             # running the pinger
             self.ping.start()
         self._check.start()
+        if self.metrics_server is not None:
+            self.metrics_server.start()
         self.running = True
 
         # telling the broker we are ready
@@ -410,6 +510,15 @@ def main(args=sys.argv):
                         default=DEFAULT_MAX_AGE_DELTA,
                         help='The maximum value in seconds added to max_age')
 
+    parser.add_argument('--metrics-address', default=None,
+                        help='The host:port where the Prometheus metrics '
+                             'are served, like 0.0.0.0:9112.')
+
+    parser.add_argument('--tags', default=None,
+                        help='The tags of the agent, like '
+                             '"region=eu-west,az=eu-west-1a". The results are '
+                             'also reported per tag value.')
+
     args = parser.parse_args()
     set_logger(args.debug, logfile=args.logfile)
     sys.path.insert(0, os.getcwd())  # XXX
@@ -422,7 +531,9 @@ def main(args=sys.argv):
     logger.info('Connecting to %s' % args.broker)
     agent = Agent(broker=args.broker, params=params,
                   timeout=args.timeout, max_age=args.max_age,
-                  max_age_delta=args.max_age_delta)
+                  max_age_delta=args.max_age_delta,
+                  metrics_address=args.metrics_address,
+                  tags=parse_tags(args.tags))
 
     try:
         agent.start()
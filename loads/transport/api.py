@@ -0,0 +1,249 @@
+""" A REST API to drive the broker over HTTP, for the orchestration tools.
+
+    GET    /agents              the registered agents
+    GET    /runs                the active runs
+    POST   /runs                starts a run -- the body is a JSON object of
+                                loads-runner options, like {"fqn": ...,
+                                "agents": 2, "users": 10, "duration": 60}.
+                                With {"queue": true}, the run waits in the
+                                queue for its agents
+    GET    /queue               the queued runs, in the order they will
+                                start
+    GET    /runs/<id>           the status of a run
+    POST   /runs/<id>/stop      stops a run
+    DELETE /runs/<id>           stops a run
+    POST   /runs/<id>/pause     pauses a run
+    POST   /runs/<id>/resume    resumes a paused run
+    POST   /runs/<id>/abort     aborts a run, flushing its results
+    POST   /runs/<id>/load      changes the users or the rate of every
+                                agent of a run -- {"users": 20} or
+                                {"rate": 50}
+    GET    /runs/<id>/counts    the counts of every event of a run
+    GET    /runs/<id>/data      the results of a run -- with the optional
+                                data_type, start and size parameters
+
+The server talks to the broker through its frontend, like loads-runner
+does: its threads never touch the broker's loop. The token of the
+*Authorization: Bearer* header is the API key given to the broker, so a
+key only gives access to the runs and the agents of its project.
+"""
+import threading
+import urlparse
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+from SocketServer import ThreadingMixIn
+
+import zmq
+
+from loads.transport.client import Client
+from loads.transport.exc import (ExecutionError, TimeoutError,
+                                 AuthenticationError)
+from loads.transport.metrics import parse_address
+from loads.util import json, logger
+
+
+class ApiError(Exception):
+    def __init__(self, status, message):
+        Exception.__init__(self, message)
+        self.status = status
+
+
+def get_run_args(options):
+    """Returns the arguments of a run, from the defaults of loads-runner
+    and the given options."""
+    from loads.main import _parse
+
+    if not isinstance(options, dict) or not options.get('fqn'):
+        raise ApiError(400, 'The fqn of the test is required')
+
+    args = dict(_parse([])[0]._get_kwargs())
+    args.update(options)
+    if not args.get('agents'):
+        # a run of the broker needs agents
+        args['agents'] = 1
+    for option in ('users', 'hits'):
+        # loads-runner gets those as strings
+        if args.get(option) is not None:
+            args[option] = str(args[option])
+    return args
+
+
+def get_run_status(client, run_id):
+    metadata = client.get_metadata(run_id)
+    if not metadata:
+        raise ApiError(404, 'Unknown run %r' % run_id)
+
+    agents = [agent_id for agent_id, when
+              in client.list_runs().get(run_id, [])]
+    position = None
+    if metadata.get('queued'):
+        for entry in client.get_queue():
+            if entry['run_id'] == run_id:
+                position = entry['position']
+    return {'run_id': run_id,
+            'active': bool(metadata.get('active')),
+            'queued': position is not None,
+            'position': position,
+            'started': metadata.get('started'),
+            'ended': metadata.get('ended'),
+            'stopped': bool(metadata.get('stopped')),
+            'paused': bool(metadata.get('paused')),
+            'aborted': bool(metadata.get('aborted')),
+            'load': metadata.get('load'),
+            'degraded': bool(metadata.get('degraded')),
+            'lost_agents': metadata.get('lost_agents', []),
+            'agents': agents,
+            'counts': dict(client.get_counts(run_id)),
+            'metadata': metadata}
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        self._handle('GET')
+
+    def do_POST(self):
+        self._handle('POST')
+
+    def do_DELETE(self):
+        self._handle('DELETE')
+
+    def _handle(self, method):
+        url = urlparse.urlparse(self.path)
+        parts = [part for part in url.path.split('/') if part]
+        query = dict(urlparse.parse_qsl(url.query))
+
+        client = None
+        try:
+            key = self._check_token()
+            timeout = self.server.timeout
+            client = Client(self.server.frontend, ctx=self.server.context,
+                            timeout=timeout, timeout_max_overflow=timeout,
+                            api_key=key)
+            status, result = self._route(client, method, parts, query)
+        except ApiError, e:
+            status, result = e.status, {'error': str(e)}
+        except AuthenticationError, e:
+            status, result = 401, {'error': str(e)}
+        except ExecutionError, e:
+            status, result = 409, {'error': str(e)}
+        except TimeoutError:
+            status, result = 504, {'error': 'The broker did not answer'}
+        except ValueError, e:
+            # the broker replied with an error
+            status, result = 400, {'error': str(e)}
+        finally:
+            if client is not None:
+                client.close()
+
+        self._send(status, result)
+
+    def _check_token(self):
+        """Returns the token of the request, if any."""
+        authorization = self.headers.get('Authorization', '')
+        key = None
+        if authorization.startswith('Bearer '):
+            key = authorization[len('Bearer '):]
+
+        token = self.server.token
+        if token is not None and key != token:
+            raise ApiError(401, 'Invalid token')
+        return key
+
+    def _read_json(self):
+        length = int(self.headers.get('Content-Length') or 0)
+        try:
+            return json.loads(self.rfile.read(length) or '{}')
+        except ValueError:
+            raise ApiError(400, 'The body is not valid JSON')
+
+    def _route(self, client, method, parts, query):
+        if parts == ['agents'] and method == 'GET':
+            return 200, client.list()
+
+        if parts == ['queue'] and method == 'GET':
+            return 200, client.get_queue()
+
+        if parts == ['runs']:
+            if method == 'GET':
+                return 200, client.list_runs()
+            if method == 'POST':
+                result = client.run(get_run_args(self._read_json()))
+                return 201, result
+
+        if len(parts) >= 2 and parts[0] == 'runs':
+            run_id = parts[1]
+            action = parts[2:]
+
+            if not action and method == 'GET':
+                return 200, get_run_status(client, run_id)
+            if (not action and method == 'DELETE' or
+                    action == ['stop'] and method == 'POST'):
+                return 200, {'run_id': run_id,
+                             'agents': client.stop_run(run_id)}
+            if (len(action) == 1 and method == 'POST' and
+                    action[0] in ('pause', 'resume', 'abort')):
+                command = getattr(client, '%s_run' % action[0])
+                return 200, {'run_id': run_id, 'agents': command(run_id)}
+            if action == ['load'] and method == 'POST':
+                load = self._read_json()
+                if not isinstance(load, dict):
+                    raise ApiError(400, 'The body must be a JSON object')
+                agents = client.set_load(run_id, users=load.get('users'),
+                                         rate=load.get('rate'),
+                                         max_rps=load.get('max_rps'))
+                return 200, {'run_id': run_id, 'agents': agents}
+            if action == ['counts'] and method == 'GET':
+                return 200, dict(client.get_counts(run_id))
+            if action == ['data'] and method == 'GET':
+                options = dict([(key, query[key]) for key in
+                                ('data_type', 'start', 'size')
+                                if key in query])
+                return 200, client.get_data(run_id, **options)
+
+        raise ApiError(404, 'Not found')
+
+    def _send(self, status, result):
+        body = json.dumps(result)
+        self.send_response(status)
+        self.send_header('Content-Type', 'application/json')
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+class _Server(ThreadingMixIn, HTTPServer):
+    daemon_threads = True
+
+
+class ApiServer(object):
+    """Serves the REST API on http://address/, in a thread.
+
+    :param frontend: the ZMQ frontend of the broker.
+    :param token: when set, the requests need an
+                  *Authorization: Bearer <token>* header.
+    :param timeout: the seconds to wait for the broker.
+    """
+    def __init__(self, frontend, address, token=None, timeout=5.):
+        self.server = _Server(parse_address(address), _Handler)
+        self.server.frontend = frontend
+        self.server.token = token
+        self.server.timeout = timeout
+        self.server.context = zmq.Context()
+        self.address = '%s:%d' % self.server.server_address
+        self._thread = None
+
+    def start(self):
+        logger.info('Serving the API at http://%s/' % self.address)
+        self._thread = threading.Thread(target=self.server.serve_forever)
+        self._thread.daemon = True
+        self._thread.start()
+
+    def stop(self):
+        if self._thread is not None:
+            self.server.shutdown()
+            self._thread = None
+        self.server.server_close()
+        self.server.context.destroy(0)
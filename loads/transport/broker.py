@@ -5,21 +5,36 @@ import sys
 import traceback
 import argparse
 import os
+import time
 
 import zmq.green as zmq
 from zmq.green.eventloop import ioloop, zmqstream
 
-from loads.util import set_logger, logger, json
+from loads.util import (set_logger, logger, json, unpack_message,
+                        parse_tags)
 from loads.transport.util import (register_ipc_file, DEFAULT_FRONTEND,
                                   DEFAULT_BACKEND,
                                   DEFAULT_REG, verify_broker,
                                   DEFAULT_BROKER_RECEIVER,
                                   DEFAULT_PUBLISHER,
-                                  DEFAULT_AGENT_TIMEOUT)
+                                  DEFAULT_AGENT_TIMEOUT,
+                                  DEFAULT_CHECK_INTERVAL,
+                                  DEFAULT_STATE_INTERVAL,
+                                  DEFAULT_FAILOVER_TIMEOUT,
+                                  connect, join_endpoints, split_endpoints,
+                                  resolve_endpoint)
 from loads.transport.heartbeat import Heartbeat
-from loads.transport.exc import DuplicateBrokerError
+from loads.transport.exc import DuplicateBrokerError, TimeoutError
+from loads.transport.client import Client
 from loads.db import get_backends
+from loads.observers import observers
+from loads.plugins import PluginError, get_plugins
 from loads.transport.brokerctrl import BrokerController
+from loads.transport.metrics import Metrics, MetricsServer
+from loads.transport.dashboard import LiveStats, DashboardServer
+from loads.transport.redisstats import RedisLiveStats
+from loads.transport.api import ApiServer
+from loads.transport.nats import ResultsConsumer
 
 
 DEFAULT_IOTHREADS = 1
@@ -36,13 +51,55 @@ class Broker(object):
     - **register** : the ZMQ socket to register agents.
     - **receiver**: the ZMQ socket that receives data from agents.
     - **publisher**: the ZMQ socket to publish agents data
+    - **agent_timeout**: the seconds after which an agent that does not
+      answer is lost.
+    - **check_interval**: the seconds between two checks of the running
+      agents.
+    - **metrics_address**: the host:port where the Prometheus metrics are
+      served. None to not serve them.
+    - **dashboard_address**: the host:port where the live dashboard is
+      served. None to not serve it.
+    - **api_address**: the host:port where the REST API is served. None to
+      not serve it.
+    - **api_token**: the token the REST API requires, if any.
+    - **standby_of**: the frontend of the broker this one stands by. The
+      standby gets the state of that broker, and takes over when it stops
+      sending it. None for an active broker.
+    - **state_interval**: the seconds between two states sent to the
+      standby broker.
+    - **failover_timeout**: the seconds a standby broker waits without
+      news of the active broker before taking over.
+    - **nats**: the NATS servers, like nats://10.0.0.1:4222, whose JetStream
+      carries the results of the runners to this broker. None to get them
+      on the receiver socket.
+    - **project_limits**: the number of runs every project can have at once,
+      like {'team-a': 2, '*': 1} -- "*" being for the projects not listed.
+      None to not limit them.
+    - **api_keys**: the API keys the commands need, and the project each one
+      gives access to, like {'s3cr3t': 'team-a'} -- "*" giving access to
+      every project. None to not require keys.
+    - **live_redis**: the host:port of a Redis where the live stats are
+      kept, shared with the other brokers and the runners. None to keep
+      them in memory, for the dashboard only.
+    - **observers**: the observers told about every run, with their options,
+      like {'slack': {'webhook': 'https://hooks.slack.com/...'}}. The
+      options of a run replace the ones of the broker.
+    - **plugins**: the started observer plugins told about every run -- see
+      :mod:`loads.plugins`.
     """
     def __init__(self, frontend=DEFAULT_FRONTEND, backend=DEFAULT_BACKEND,
                  heartbeat=None, register=DEFAULT_REG,
                  io_threads=DEFAULT_IOTHREADS,
                  agent_timeout=DEFAULT_AGENT_TIMEOUT,
+                 check_interval=DEFAULT_CHECK_INTERVAL,
                  receiver=DEFAULT_BROKER_RECEIVER, publisher=DEFAULT_PUBLISHER,
-                 db='python', dboptions=None, web_root=None):
+                 db='python', dboptions=None, web_root=None,
+                 metrics_address=None, dashboard_address=None,
+                 api_address=None, api_token=None, standby_of=None,
+                 state_interval=DEFAULT_STATE_INTERVAL,
+                 failover_timeout=DEFAULT_FAILOVER_TIMEOUT, nats=None,
+                 project_limits=None, api_keys=None, live_redis=None,
+                 observers=None, plugins=None):
         # before doing anything, we verify if a broker is already up and
         # running
         logger.debug('Verifying if there is a running broker')
@@ -60,6 +117,9 @@ class Broker(object):
         if heartbeat is not None:
             self.endpoints['heartbeat'] = heartbeat
 
+        if nats is not None:
+            self.endpoints['nats'] = nats
+
         logger.debug('Initializing the broker.')
 
         for endpoint in self.endpoints.values():
@@ -67,24 +127,89 @@ class Broker(object):
                 register_ipc_file(endpoint)
 
         self.context = zmq.Context(io_threads=io_threads)
+        self.loop = ioloop.IOLoop()
+        self.pid = str(os.getpid())
+        self._frontstream = self._backstream = self.pong = None
+
+        # the broker standing by this one, and the one this one stands by
+        self.standby = None
+        self.standby_of = standby_of
+        self.state_interval = state_interval
+        self.failover_timeout = failover_timeout
+        if standby_of is None:
+            self._bind()
+        else:
+            self._primary = self._get_primary(standby_of)
+
+        # status
+        self.started = False
+        self.poll_timeout = None
+        self.check_interval = check_interval
+
+        self.web_root = web_root
+        self.api_keys = api_keys
+
+        # live stats and dashboard
+        if live_redis is not None:
+            self.live_stats = RedisLiveStats(live_redis)
+        elif dashboard_address is not None:
+            self.live_stats = LiveStats()
+        else:
+            self.live_stats = None
+
+        if dashboard_address is not None:
+            self.dashboard_server = DashboardServer(self.live_stats,
+                                                    dashboard_address,
+                                                    keys=api_keys)
+        else:
+            self.dashboard_server = None
+
+        # controller
+        self.ctrl = BrokerController(self, self.loop, db=db,
+                                     dboptions=dboptions,
+                                     agent_timeout=agent_timeout,
+                                     project_limits=project_limits,
+                                     observers=observers,
+                                     plugins=plugins)
+
+        # metrics
+        self.metrics = Metrics(extra=self._get_gauges)
+        if metrics_address is not None:
+            self.metrics_server = MetricsServer(self.metrics, metrics_address)
+        else:
+            self.metrics_server = None
+
+        # REST API
+        if api_address is not None:
+            self.api_server = ApiServer(frontend, api_address,
+                                        token=api_token)
+        else:
+            self.api_server = None
+
+        # results pulled from JetStream
+        if nats is not None:
+            self.results_consumer = ResultsConsumer(nats, self._handle_result)
+        else:
+            self.results_consumer = None
+
+    def _bind(self):
+        endpoints = self.endpoints
 
         # setting up the sockets
         self._frontend = self.context.socket(zmq.ROUTER)
-        self._frontend.identity = 'broker-' + frontend
-        self._frontend.bind(frontend)
+        self._frontend.identity = 'broker-' + endpoints['frontend']
+        self._frontend.bind(endpoints['frontend'])
         self._backend = self.context.socket(zmq.ROUTER)
-        self.pid = str(os.getpid())
         self._backend.identity = self.pid
-        self._backend.bind(backend)
+        self._backend.bind(endpoints['backend'])
         self._registration = self.context.socket(zmq.PULL)
-        self._registration.bind(register)
+        self._registration.bind(endpoints['register'])
         self._receiver = self.context.socket(zmq.PULL)
-        self._receiver.bind(receiver)
+        self._receiver.bind(endpoints['receiver'])
         self._publisher = self.context.socket(zmq.PUB)
-        self._publisher.bind(publisher)
+        self._publisher.bind(endpoints['publisher'])
 
         # setting up the streams
-        self.loop = ioloop.IOLoop()
         self._frontstream = zmqstream.ZMQStream(self._frontend, self.loop)
         self._frontstream.on_recv(self._handle_recv_front)
         self._backstream = zmqstream.ZMQStream(self._backend, self.loop)
@@ -95,29 +220,48 @@ class Broker(object):
         self._rcvstream.on_recv(self._handle_recv)
 
         # heartbeat
-        if heartbeat is not None:
-            self.pong = Heartbeat(heartbeat, io_loop=self.loop,
+        if 'heartbeat' in endpoints:
+            self.pong = Heartbeat(endpoints['heartbeat'], io_loop=self.loop,
                                   ctx=self.context,
                                   onregister=self._deregister)
-        else:
-            self.pong = None
-
-        # status
-        self.started = False
-        self.poll_timeout = None
-
-        # controller
-        self.ctrl = BrokerController(self, self.loop, db=db,
-                                     dboptions=dboptions,
-                                     agent_timeout=agent_timeout)
 
-        self.web_root = web_root
+    def _get_primary(self, frontend):
+        """Returns the endpoints of the broker this one stands by."""
+        client = Client(frontend)
+        try:
+            endpoints = client.ping(timeout=self.failover_timeout)['endpoints']
+        except TimeoutError:
+            raise ValueError('No broker to stand by at %r' % frontend)
+        finally:
+            client.close()
+
+        # the first endpoints are the ones of that broker, the others the
+        # ones of its standby
+        return dict([(name, resolve_endpoint(split_endpoints(endpoint)[0],
+                                             frontend))
+                     for name, endpoint in endpoints.items()])
+
+    def get_endpoints(self):
+        """Returns the endpoints of the broker, followed by the ones of its
+        standby -- so the agents and the runners connect to both."""
+        if self.standby is None:
+            return self.endpoints
+
+        return dict([(name, join_endpoints(endpoint, self.standby.get(name)))
+                     for name, endpoint in self.endpoints.items()])
+
+    def _get_gauges(self):
+        runs = set([run_id for run_id, when in self.ctrl.runs.values()])
+        return {('loads_agents', 'Registered agents.'): len(self.ctrl.agents),
+                ('loads_runs', 'Active runs.'): len(runs),
+                ('loads_queued_runs', 'Queued runs.'): len(self.ctrl.queue)}
 
     def _handle_recv(self, msg):
         # publishing all the data received from agents
-        self._publisher.send(msg[0])
+        payload = unpack_message(msg[0])
+        self._publisher.send(payload)
 
-        data = json.loads(msg[0])
+        data = json.loads(payload)
         agent_id = str(data.get('agent_id'))
         hostname = data.get('hostname', '?')
 
@@ -126,15 +270,35 @@ class Broker(object):
 
         # saving the data locally
         self.ctrl.save_data(agent_id, data)
+        self.metrics.add(data)
+        if self.live_stats is not None:
+            project = self.ctrl.get_project(data.get('run_id'))
+            self.live_stats.add(data, project)
+
+    def _handle_result(self, payload):
+        self._handle_recv([payload])
 
     def _deregister(self):
         self.ctrl.unregister_agents('asked by the heartbeat.')
 
     def _handle_reg(self, msg):
         if msg[0] == 'REGISTER':
-            self.ctrl.register_agent(json.loads(msg[1]))
+            agent_info = json.loads(msg[1])
+            self.ctrl.register_agent(agent_info)
+
+            # the capability handshake: what does the agent support?
+            agent_id = str(agent_info['pid'])
+            if self.ctrl.get_capabilities(agent_id) is None:
+                self.ctrl.send_to_agent(agent_id,
+                                        json.dumps({'command':
+                                                    'CAPABILITIES'}))
         elif msg[0] == 'UNREGISTER':
             self.ctrl.unregister_agent(msg[1], 'asked via UNREGISTER')
+        elif msg[0] == 'STANDBY':
+            standby = json.loads(msg[1])
+            if standby != self.standby:
+                logger.info('A broker stands by at %r' % standby['frontend'])
+                self.standby = standby
 
     def send_json(self, target, data):
         assert isinstance(target, basestring), target
@@ -162,6 +326,19 @@ class Broker(object):
 
         cmd = data['command']
 
+        # the project of the API key, if any, scopes the command -- None
+        # is every project
+        key = data.pop('api_key', None)
+        data['scope'] = None
+        if self.api_keys is not None:
+            project = self.api_keys.get(key)
+            if project is None and cmd != 'PING':
+                self.send_json(target, {'error': 'Invalid API key',
+                                        'unauthorized': True})
+                return
+            if project != '*':
+                data['scope'] = project
+
         # a command handled by the controller
         if cmd.startswith('CTRL_'):
             cmd = cmd[len('CTRL_'):]
@@ -184,13 +361,19 @@ class Broker(object):
 
         # misc commands
         elif cmd == 'PING':
+            # without a key, the broker only gives its endpoints
+            if self.api_keys is not None and key not in self.api_keys:
+                agents = {}
+            else:
+                agents = self.ctrl.get_agents(data['scope'])
             res = {'result': {'pid': os.getpid(),
-                              'endpoints': self.endpoints,
-                              'agents': self.ctrl.agents}}
+                              'endpoints': self.get_endpoints(),
+                              'agents': agents}}
             self.send_json(target, res)
         elif cmd == 'LIST':
             # we return a list of agent ids and their status
-            self.send_json(target, {'result': self.ctrl.agents})
+            self.send_json(target,
+                           {'result': self.ctrl.get_agents(data['scope'])})
             return
         else:
             self.send_json(target, {'error': 'unknown command %s' % cmd})
@@ -218,6 +401,16 @@ class Broker(object):
 
         command = result.get('command')
 
+        # the reply to the capability handshake -- the agents that do not
+        # know the command reply with its name as an error
+        if command == 'CAPABILITIES':
+            self.ctrl.set_capabilities(agent_id, result['capabilities'])
+            return
+        elif (client_id is None and 'error' in data and
+              str(result.get('error', '')).startswith('CAPABILITIES')):
+            self.ctrl.set_capabilities(agent_id)
+            return
+
         # results from commands sent by the broker
         if command in ('_STATUS', 'STOP', 'QUIT'):
             run_id = self.ctrl.update_status(agent_id, result)
@@ -250,14 +443,10 @@ class Broker(object):
         if self.started:
             return
 
-        # running the heartbeat
-        if self.pong is not None:
-            self.pong.start()
-
-        # running the cleaner
-        self.cleaner = ioloop.PeriodicCallback(self.ctrl.clean,
-                                               2500, self.loop)
-        self.cleaner.start()
+        if self.standby_of is None:
+            self._start_services()
+        else:
+            self._stand_by()
 
         self.started = True
         while self.started:
@@ -277,12 +466,123 @@ class Broker(object):
             else:
                 break
 
+    def _start_services(self):
+        # running the heartbeat
+        if self.pong is not None:
+            self.pong.start()
+
+        # running the cleaner
+        self.cleaner = ioloop.PeriodicCallback(self.ctrl.clean,
+                                               self.check_interval * 1000,
+                                               self.loop)
+        self.cleaner.start()
+
+        # sending our state to the standby broker, if any
+        self.state_sender = ioloop.PeriodicCallback(
+            self._send_state, self.state_interval * 1000, self.loop)
+        self.state_sender.start()
+
+        if self.metrics_server is not None:
+            self.metrics_server.start()
+
+        if self.dashboard_server is not None:
+            self.dashboard_server.start()
+
+        if self.api_server is not None:
+            self.api_server.start()
+
+        if self.results_consumer is not None:
+            self.results_consumer.start()
+
+    def _send_state(self):
+        if self.standby is None:
+            return
+        self._publisher.send(json.dumps({'data_type': 'broker_state',
+                                         'state': self.ctrl.get_state()}))
+
+    #
+    # standby mode
+    #
+    def _stand_by(self):
+        logger.info('Standing by the broker at %r' % self.standby_of)
+        self._primary_time = time.time()
+
+        # the active broker publishes its state and the agents data
+        self._watch = self.context.socket(zmq.SUB)
+        self._watch.setsockopt(zmq.SUBSCRIBE, '')
+        self._watch.connect(self._primary['publisher'])
+        self._watchstream = zmqstream.ZMQStream(self._watch, self.loop)
+        self._watchstream.on_recv(self._handle_primary)
+
+        # and we tell it we are here, so it gives our endpoints to the
+        # agents and the runners
+        self._announce = self.context.socket(zmq.PUSH)
+        self._announce.linger = 0
+        self._announce.connect(self._primary['register'])
+
+        self.watcher = ioloop.PeriodicCallback(self._check_primary,
+                                               self.state_interval * 1000,
+                                               self.loop)
+        self.watcher.start()
+
+    def _check_primary(self):
+        if time.time() - self._primary_time > self.failover_timeout:
+            self.take_over()
+            return
+
+        try:
+            self._announce.send_multipart(['STANDBY',
+                                           json.dumps(self.endpoints)],
+                                          zmq.NOBLOCK)
+        except zmq.ZMQError:
+            logger.debug('Could not announce the standby broker')
+
+    def _handle_primary(self, msg):
+        self._primary_time = time.time()
+        data = json.loads(msg[0])
+        data_type = data.get('data_type')
+
+        if data_type == 'broker_state':
+            self.ctrl.set_state(data['state'])
+        elif data_type == 'run-finished':
+            self.ctrl.update_metadata(data['run_id'], stopped=True,
+                                      active=False, ended=time.time())
+        elif data_type != 'agent_lost':
+            # the data of the agents, to have the full results in our DB
+            self.ctrl.save_data(str(data.get('agent_id')), data)
+            self.metrics.add(data)
+            # the active broker already keeps the shared stats
+            if self.live_stats is not None and not self.live_stats.shared:
+                project = self.ctrl.get_project(data.get('run_id'))
+                self.live_stats.add(data, project)
+
+    def take_over(self):
+        """Makes the standby broker the active one."""
+        logger.info('No news of the broker at %r, taking over' %
+                    self.standby_of)
+        self.watcher.stop()
+        self._watchstream.stop_on_recv()
+        self._watchstream.close()
+        self._announce.close()
+        self.standby_of = None
+        self._bind()
+        self._start_services()
+
     def stop(self):
         """Stops the broker.
         """
         if not self.started:
             return
 
+        if self.standby_of is not None:
+            # we never took over
+            logger.debug('Stopping the standby')
+            self.watcher.stop()
+            self.loop.stop()
+            self.started = False
+            self.context.destroy(0)
+            return
+
         try:
             self._backstream.flush()
         except IOError:
@@ -294,6 +594,23 @@ class Broker(object):
 
         logger.debug('Stopping the cleaner')
         self.cleaner.stop()
+        self.state_sender.stop()
+
+        if self.metrics_server is not None:
+            logger.debug('Stopping the metrics server')
+            self.metrics_server.stop()
+
+        if self.dashboard_server is not None:
+            logger.debug('Stopping the dashboard')
+            self.dashboard_server.stop()
+
+        if self.api_server is not None:
+            logger.debug('Stopping the API')
+            self.api_server.stop()
+
+        if self.results_consumer is not None:
+            logger.debug('Stopping the NATS consumer')
+            self.results_consumer.stop()
 
         logger.debug('Stopping the loop')
         self.loop.stop()
@@ -317,6 +634,16 @@ def main(args=sys.argv):
                         default=None,
                         help="ZMQ socket for the heartbeat.")
 
+    parser.add_argument('--agent-timeout', dest='agent_timeout',
+                        default=DEFAULT_AGENT_TIMEOUT, type=float,
+                        help="Seconds after which an agent that does not "
+                             "answer is lost.")
+
+    parser.add_argument('--check-interval', dest='check_interval',
+                        default=DEFAULT_CHECK_INTERVAL, type=float,
+                        help="Seconds between two checks of the running "
+                             "agents.")
+
     parser.add_argument('--register', dest='register',
                         default=DEFAULT_REG,
                         help="ZMQ socket for the registration.")
@@ -349,6 +676,78 @@ def main(args=sys.argv):
     parser.add_argument('--web-root', help='Root url of the web dashboard.',
                         type=str, default=None)
 
+    parser.add_argument('--metrics-address', default=None,
+                        help='The host:port where the Prometheus metrics '
+                             'are served, like 0.0.0.0:9111.')
+
+    parser.add_argument('--dashboard-address', default=None,
+                        help='The host:port where the live dashboard is '
+                             'served, like 0.0.0.0:8080.')
+
+    parser.add_argument('--api-address', default=None,
+                        help='The host:port where the REST API is served, '
+                             'like 0.0.0.0:8081.')
+
+    parser.add_argument('--api-token', default=None,
+                        help='The token the REST API requires, in an '
+                             '"Authorization: Bearer" header.')
+
+    parser.add_argument('--standby-of', default=None,
+                        help='The frontend of the broker to stand by. This '
+                             'broker takes over when that one is gone.')
+
+    parser.add_argument('--state-interval', default=DEFAULT_STATE_INTERVAL,
+                        type=float,
+                        help='Seconds between two states sent to the '
+                             'standby broker.')
+
+    parser.add_argument('--failover-timeout', type=float,
+                        default=DEFAULT_FAILOVER_TIMEOUT,
+                        help='Seconds a standby broker waits without news '
+                             'of the active broker before taking over.')
+
+    parser.add_argument('--nats', default=None,
+                        help='The NATS servers whose JetStream carries the '
+                             'results of the runners, like '
+                             'nats://localhost:4222.')
+
+    parser.add_argument('--project-limits', default=None,
+                        help='The number of runs every project can have at '
+                             'once, like "team-a=2,*=1" -- "*" being for '
+                             'the projects not listed.')
+
+    parser.add_argument('--api-keys', default=None,
+                        help='A JSON file with the API keys the commands '
+                             'need, and the project each one gives access '
+                             'to, like {"s3cr3t": "team-a"} -- "*" giving '
+                             'access to every project.')
+
+    parser.add_argument('--live-redis', default=None,
+                        help='The host:port of a Redis where the live stats '
+                             'are kept, to share them with the other '
+                             'brokers and the runners.')
+
+    parser.add_argument('--observer', action='append', default=None,
+                        choices=[observer.name for observer in observers],
+                        help='An observer told about every run.')
+
+    for observer in observers:
+        prefix = '--observer-%s-' % observer.name
+        for option in observer.options:
+            kwargs = dict([(key, option[key]) for key in ('type', 'action')
+                           if option.get(key) is not None])
+            parser.add_argument(prefix + option['name'],
+                                help=option.get('help'), **kwargs)
+
+    parser.add_argument('--plugin', action='append', default=None,
+                        metavar='PATH',
+                        help='An observer plugin binary told about every '
+                             'run.')
+
+    parser.add_argument('--plugin-option', action='append', default=None,
+                        metavar='NAME=VALUE',
+                        help='An option given to the plugins.')
+
     # add db args
     for backend, options in get_backends():
         for option, default, help, type_ in options:
@@ -384,17 +783,60 @@ def main(args=sys.argv):
             continue
         dboptions[key[len(prefix):]] = value
 
+    # grabbing the options of the observers
+    observer_options = {}
+    for name in args.observer or []:
+        prefix = 'observer_%s_' % name
+        observer_options[name] = dict([(key[len(prefix):], value)
+                                       for key, value in args._get_kwargs()
+                                       if key.startswith(prefix) and
+                                       value is not None])
+
+    api_keys = None
+    if args.api_keys is not None:
+        try:
+            with open(args.api_keys) as f:
+                api_keys = json.load(f)
+        except (IOError, ValueError), e:
+            logger.info('Could not read the API keys: %s' % e)
+            return 1
+
+    try:
+        plugins = get_plugins({'plugin': args.plugin,
+                               'plugin_option': args.plugin_option},
+                              'observer')
+    except (PluginError, ValueError), e:
+        logger.info('Could not start the plugins: %s' % e)
+        return 1
+
     logger.info('Starting the broker')
     try:
         broker = Broker(frontend=args.frontend, backend=args.backend,
                         heartbeat=args.heartbeat, register=args.register,
                         receiver=args.receiver, publisher=args.publisher,
                         io_threads=args.io_threads, db=args.db,
-                        dboptions=dboptions, web_root=args.web_root)
+                        agent_timeout=args.agent_timeout,
+                        check_interval=args.check_interval,
+                        dboptions=dboptions, web_root=args.web_root,
+                        metrics_address=args.metrics_address,
+                        dashboard_address=args.dashboard_address,
+                        api_address=args.api_address,
+                        api_token=args.api_token,
+                        standby_of=args.standby_of,
+                        state_interval=args.state_interval,
+                        failover_timeout=args.failover_timeout,
+                        nats=args.nats,
+                        project_limits=parse_tags(args.project_limits),
+                        api_keys=api_keys, live_redis=args.live_redis,
+                        observers=observer_options, plugins=plugins)
     except DuplicateBrokerError, e:
         logger.info('There is already a broker running on PID %s' % e)
         logger.info('Exiting')
         return 1
+    except ValueError, e:
+        logger.info(str(e))
+        logger.info('Exiting')
+        return 1
 
     logger.info('Listening to incoming jobs at %r' % args.frontend)
     logger.info('Workers may register at %r' % args.backend)
@@ -7,16 +7,40 @@ from collections import defaultdict
 import datetime
 from uuid import uuid4
 
+import loads
 from loads.db import get_database
+from loads.transport.capabilities import (LEGACY, describe, get_missing,
+                                          get_requirements)
+from loads.feeders import split_feeder
+from loads.plugins import PluginObserver
+from loads.schedule import Cron, summarize_run
+from loads.sequences import DEFAULT_BLOCK_SIZE
+from loads.thresholds import parse_thresholds
+from loads.transport.dashboard import _get_hit
+from loads.transport.redisstats import _new_totals
 from loads.transport.util import DEFAULT_AGENT_TIMEOUT
 from loads.util import logger, resolve_name, json, unbatch
 from loads.results import RemoteTestResult
 
 
+# the metadata of a run that are not arguments of the run
+_RUN_STATE = ('started', 'active', 'stopped', 'ended', 'has_data',
+              'lost_agents', 'degraded', 'paused', 'aborted', 'resumed',
+              'checkpoints', 'zmq_receiver', 'queued', 'preempted',
+              'scheduled', 'summary', 'breached', 'sequences', 'fixtures')
+
+# what the runners put in a checkpoint
+_CHECKPOINT = ('elapsed', 'iterations', 'feeders')
+
+
 class NotEnoughWorkersError(Exception):
     pass
 
 
+class UnsupportedFeaturesError(NotEnoughWorkersError):
+    pass
+
+
 class NoDetailedDataError(Exception):
     pass
 
@@ -35,9 +59,32 @@ def _compute_observers(observers):
     return [_resolver(observer) for observer in observers]
 
 
+def _get_project(args):
+    return args.get('project_name') or 'N/A'
+
+
+def _get_priority(args):
+    return int(args.get('priority') or 0)
+
+
+def _get_rps_share(args, agents):
+    """Returns the requests per second of every agent of a run with a
+    ceiling, or None."""
+    if not args.get('max_rps') or agents <= 0:
+        return None
+    return float(args['max_rps']) / agents
+
+
+def _get_elapsed(checkpoints):
+    """Returns how long the most advanced agent of a run has run."""
+    return max([0] + [checkpoint.get('elapsed', 0)
+                      for checkpoint in checkpoints.values()])
+
+
 class BrokerController(object):
     def __init__(self, broker, loop, db='python', dboptions=None,
-                 agent_timeout=DEFAULT_AGENT_TIMEOUT):
+                 agent_timeout=DEFAULT_AGENT_TIMEOUT, project_limits=None,
+                 observers=None, plugins=None):
         self.broker = broker
         self.loop = loop
 
@@ -47,6 +94,37 @@ class BrokerController(object):
         self.agent_timeout = agent_timeout
         self._runs = {}
 
+        # the RUN message and the agents indexes of every run, to replace
+        # the lost agents
+        self._run_data = {}
+
+        # the last checkpoints of the agents of every run, by index
+        self._checkpoints = {}
+
+        # the runs waiting for agents, the runs stopping to leave their
+        # agents to a queued run, and how many runs a project can have at
+        # once -- "*" being the limit of the projects not listed
+        self._queue = []
+        self._preempted = set()
+        self.project_limits = dict([(project, int(limit)) for project, limit
+                                    in (project_limits or {}).items()])
+
+        # the runs to start later, once or on a cron expression
+        self._schedules = []
+
+        # the observers of every run, by name, with their options, and the
+        # totals of the runs whose thresholds are watched by an observer
+        self.observers = observers or {}
+        self._watched = {}
+        # the observer plugins of the broker -- see loads.plugins
+        self.plugins = plugins or []
+
+        # the next ids of the sequences of every run, by name
+        self._sequences = {}
+
+        # the partitions of the --feeder file of every run, by agent index
+        self._partitions = {}
+
         # local DB
         if dboptions is None:
             dboptions = {}
@@ -62,6 +140,25 @@ class BrokerController(object):
     def agents(self):
         return self._agents
 
+    @property
+    def runs(self):
+        return self._runs
+
+    @property
+    def queue(self):
+        """The queued runs, in the order they will start."""
+        queue = []
+        for position, entry in enumerate(self._get_queue_order()):
+            args = entry['data']['args']
+            queue.append({'run_id': entry['run_id'],
+                          'position': position + 1,
+                          'project': _get_project(args),
+                          'priority': _get_priority(args),
+                          'agents': entry['data']['agents'],
+                          'queued': entry['queued'],
+                          'preempted': entry['preempted']})
+        return queue
+
     def _remove_agent(self, agent_id, reason='unspecified'):
         logger.debug('%r removed. %s' % (agent_id, reason))
 
@@ -77,8 +174,44 @@ class BrokerController(object):
     def register_agent(self, agent_info):
         agent_id = str(agent_info['pid'])
 
-        if agent_id not in self._agents:
+        # the agents send their tags when they register, the results only
+        # tell us they are alive
+        if agent_id not in self._agents or 'tags' in agent_info:
+            new = agent_id not in self._agents
+            if not new and 'capabilities' in self._agents[agent_id]:
+                agent_info['capabilities'] = \
+                    self._agents[agent_id]['capabilities']
             self._agents[agent_id] = agent_info
+            if new:
+                self._process_queue()
+
+    def get_capabilities(self, agent_id):
+        """Returns the capabilities of an agent, or None while the broker
+        did not get them."""
+        return self._agents.get(agent_id, {}).get('capabilities')
+
+    def set_capabilities(self, agent_id, capabilities=None):
+        """Saves the reply of an agent to the CAPABILITIES command -- the
+        agents that do not know it get the legacy capabilities."""
+        if agent_id not in self._agents:
+            return
+        if capabilities is None:
+            capabilities = LEGACY
+        version = capabilities.get('version')
+        if version != loads.__version__:
+            logger.warning('The agent %s runs %s, the broker loads %s' %
+                           (agent_id, describe(capabilities),
+                            loads.__version__))
+        self._agents[agent_id]['capabilities'] = capabilities
+        self._process_queue()
+
+    def supports(self, agent_id, requirements):
+        """Tells if an agent supports the features and the engines of a
+        run."""
+        if not requirements:
+            return True
+        capabilities = self.get_capabilities(agent_id)
+        return not get_missing(capabilities, requirements)
 
     def unregister_agents(self, reason='unspecified', keep_fresh=True):
         now = time.time()
@@ -97,20 +230,96 @@ class BrokerController(object):
         if agent_id in self._agents:
             self._remove_agent(agent_id, reason)
 
+    def get_state(self):
+        """Returns what a standby broker needs to take over: the agents,
+        the runs, and their metadata."""
+        run_ids = set([run_id for run_id, when in self._runs.values()])
+        run_data = {}
+        for run_id, (data, indexes) in self._run_data.items():
+            # the included files are too big to be sent every time
+            data = dict(data)
+            data.pop('filedata', None)
+            run_data[run_id] = data, indexes
+
+        queue = []
+        for entry in self._queue:
+            entry = dict(entry)
+            entry['data'] = dict(entry['data'])
+            entry['data'].pop('filedata', None)
+            entry['data'].pop('feeder_data', None)
+            queue.append(entry)
+
+        return {'agents': self._agents,
+                'runs': self._runs,
+                'run_data': run_data,
+                'queue': queue,
+                'preempted': list(self._preempted),
+                'schedules': self._schedules,
+                'metadata': dict([(run_id, self._db.get_metadata(run_id))
+                                  for run_id in run_ids])}
+
+    def set_state(self, state):
+        """Replaces the state by the one of the active broker."""
+        self._agents = state['agents']
+        self._runs = dict([(agent_id, tuple(run))
+                           for agent_id, run in state['runs'].items()])
+        self._run_data = dict([(run_id, tuple(data)) for run_id, data
+                               in state['run_data'].items()])
+        self._queue = state.get('queue', [])
+        self._preempted = set(state.get('preempted', []))
+        self._schedules = state.get('schedules', [])
+        for run_id, metadata in state['metadata'].items():
+            if metadata:
+                self.save_metadata(run_id, metadata)
+
     def _associate(self, run_id, agents):
         when = time.time()
 
         for agent_id in agents:
             self._runs[agent_id] = run_id, when
 
-    def reserve_agents(self, num, run_id):
+    def in_pool(self, agent_id, project):
+        """Tells if an agent can run the tests of a project: the agents
+        tagged with a project are only for it, the others are shared."""
+        tags = self._agents.get(agent_id, {}).get('tags') or {}
+        return tags.get('project') in (None, project)
+
+    def get_agents(self, project=None):
+        """Returns the agents of the pool of a project, or all of them."""
+        return dict([(agent_id, info) for agent_id, info
+                     in self._agents.items()
+                     if project is None or self.in_pool(agent_id, project)])
+
+    def _get_free_agents(self, project=None, requirements=None):
+        return [wid for wid in self._agents.keys() if wid not in self._runs
+                and (project is None or self.in_pool(wid, project))
+                and self.supports(wid, requirements)]
+
+    def _describe_unsupported(self, agents, requirements):
+        missing = set()
+        versions = set()
+        for agent_id in agents:
+            capabilities = self.get_capabilities(agent_id)
+            missing.update(get_missing(capabilities, requirements))
+            versions.add(describe(capabilities))
+        return ('Not enough agents supporting %s: %d agent(s) running %s '
+                'lack it' % (', '.join(sorted(missing)), len(agents),
+                             ', '.join(sorted(versions))))
+
+    def reserve_agents(self, num, run_id, project=None, requirements=None):
         # we want to run the same command on several agents
         # provisionning them
         agents = []
-        available = [wid for wid in self._agents.keys()
-                     if wid not in self._runs]
+        available = self._get_free_agents(project, requirements)
 
         if num > len(available):
+            # enough agents, but some are too old for the run
+            free = self._get_free_agents(project)
+            if requirements and num <= len(free):
+                unsupported = [agent_id for agent_id in free
+                               if agent_id not in available]
+                raise UnsupportedFeaturesError(
+                    self._describe_unsupported(unsupported, requirements))
             raise NotEnoughWorkersError('Not Enough agents')
 
         while len(agents) < num:
@@ -163,6 +372,7 @@ class BrokerController(object):
                 logger.debug('Killing agent %s' % str(agent_id))
                 quit = json.dumps({'command': 'QUIT'})
                 self.send_to_agent(agent_id, quit)
+                self._agent_lost(agent_id, run_id)
 
                 # and remove it from the run
                 run_id = self._terminate_run(agent_id)
@@ -183,6 +393,10 @@ class BrokerController(object):
                                          'run_id': run_id})
                 self.send_to_agent(agent_id, status_msg)
 
+        self._check_thresholds()
+        self._check_schedules()
+        self._process_queue()
+
     def update_status(self, agent_id, result):
         """Checks the status of the processes. If all the processes are done,
            call self.test_ended() and return the run_id. Returns None
@@ -204,6 +418,84 @@ class BrokerController(object):
 
         self._agent_times[agent_id] = time.time()
 
+    def _replace_agent(self, agent_id, run_id):
+        """Runs the share of a lost agent on a free agent, for the rest of
+        the duration of the run. Returns the id of that agent, or None."""
+        if run_id not in self._run_data:
+            return None
+
+        data, indexes = self._run_data[run_id]
+        args = data['args']
+        if (not args.get('redistribute') or args.get('duration') is None or
+                args.get('stages')):
+            return None
+
+        remaining = args['duration'] - (time.time() - args['started'])
+        if remaining <= 0:
+            return None
+
+        try:
+            replacement = self.reserve_agents(1, run_id,
+                                              _get_project(args),
+                                              get_requirements(args))[0]
+        except NotEnoughWorkersError:
+            return None
+
+        message = dict(data)
+        message['args'] = dict(args)
+        message['args']['duration'] = remaining
+        message['args']['agent_index'] = indexes.get(agent_id, 0)
+        partitions = self._partitions.get(run_id)
+        if partitions is not None:
+            message['feeder_partition'] = partitions[indexes.get(agent_id, 0)]
+        for key in ('started', 'active'):
+            message['args'].pop(key, None)
+        share = _get_rps_share(args, len(self._get_run_agents(run_id)) - 1)
+        if share is not None:
+            message['args']['agent_max_rps'] = share
+
+        indexes[replacement] = indexes.get(agent_id, 0)
+        self.send_to_agent(replacement, json.dumps(message))
+        return replacement
+
+    def _agent_lost(self, agent_id, run_id):
+        """Called when an agent stopped answering during a run. A free
+        agent takes its place when the run asked for it, otherwise the run
+        is marked as degraded. Either way, the runners are told."""
+        replacement = self._replace_agent(agent_id, run_id)
+
+        metadata = self._db.get_metadata(run_id)
+        lost = metadata.get('lost_agents', []) + [agent_id]
+        if replacement is None:
+            logger.info('Agent %s lost, run %s degraded' % (agent_id, run_id))
+            self.update_metadata(run_id, lost_agents=lost, degraded=True)
+        else:
+            logger.info('Agent %s lost, replaced by %s' % (agent_id,
+                                                           replacement))
+            self.update_metadata(run_id, lost_agents=lost)
+
+        msg = json.dumps({'data_type': 'agent_lost', 'run_id': run_id,
+                          'agent_id': agent_id,
+                          'replacement': replacement})
+        self.broker._publisher.send(msg)
+        self._share_rps(run_id, lost=agent_id)
+
+    def _share_rps(self, run_id, lost=None):
+        """Gives the agents of a run their share of its ceiling of requests
+        per second, again -- without the lost agent."""
+        if run_id not in self._run_data:
+            return None
+        args = self._run_data[run_id][0]['args']
+        agents = [agent_id for agent_id in self._get_run_agents(run_id)
+                  if agent_id != lost]
+        share = _get_rps_share(args, len(agents))
+        if share is None:
+            return None
+        msg = json.dumps({'command': 'SET_LOAD', 'rps': share})
+        for agent_id in agents:
+            self.send_to_agent(agent_id, msg)
+        return agents
+
     def _terminate_run(self, agent_id):
         # ended
         if agent_id in self._agent_times:
@@ -218,9 +510,15 @@ class BrokerController(object):
         # is the whole run over ?
         running = [run_id_ for (run_id_, when_) in self._runs.values()]
 
-        # we want to tell the world if the run has ended
+        # we want to tell the world if the run has ended -- a preempted
+        # run only goes back to the queue
         if run_id not in running:
-            self.test_ended(run_id)
+            if run_id in self._preempted:
+                self._requeue(run_id)
+                run_id = None
+            else:
+                self.test_ended(run_id)
+            self._process_queue()
             return run_id
 
     #
@@ -235,16 +533,83 @@ class BrokerController(object):
     def get_metadata(self, msg, data):
         return self._db.get_metadata(data['run_id'])
 
+    def reserve_ids(self, msg, data):
+        """Reserves a block of ids of a sequence of a run, and returns its
+        first id -- see loads.sequences.
+
+        The sequences are kept in the metadata of the run, so a resumed
+        run goes on with them."""
+        run_id = data['run_id']
+        name = data.get('name') or 'default'
+        size = data.get('size')
+        if size is None:
+            size = DEFAULT_BLOCK_SIZE
+        if size < 1:
+            raise ValueError('A block has at least 1 id')
+
+        if run_id not in self._sequences:
+            metadata = self._db.get_metadata(run_id)
+            self._sequences[run_id] = dict(metadata.get('sequences') or {})
+        sequences = self._sequences[run_id]
+        start = sequences.get(name, 0)
+        sequences[name] = start + size
+        self.update_metadata(run_id, sequences=sequences)
+        return start
+
+    def set_fixtures(self, msg, data):
+        """Keeps the fixtures of the setup of a run -- or its error -- for
+        its agents, in its metadata -- see loads.lifecycle."""
+        fixtures = {'fixtures': data.get('fixtures') or {},
+                    'error': data.get('error'), 'released': []}
+        self.update_metadata(data['run_id'], fixtures=fixtures)
+        return fixtures
+
+    def get_fixtures(self, msg, data):
+        """Returns the fixtures of a run, or None before its setup."""
+        return self._db.get_metadata(data['run_id']).get('fixtures')
+
+    def release_fixtures(self, msg, data):
+        """An agent is done with the fixtures of a run. Returns True to the
+        last one, which executes the teardown."""
+        run_id = data['run_id']
+        fixtures = self._db.get_metadata(run_id).get('fixtures')
+        # no setup, a failed one, or already torn down
+        if (not fixtures or fixtures.get('error') or
+                fixtures.get('released') is None):
+            return False
+        released = set(fixtures['released'])
+        released.add(data.get('agent_index') or 0)
+        agents = 1
+        if run_id in self._run_data:
+            # a replacement agent has the index of the lost one
+            agents = len(set(self._run_data[run_id][1].values()))
+        if len(released) < agents:
+            fixtures['released'] = sorted(released)
+            self.update_metadata(run_id, fixtures=fixtures)
+            return False
+        # torn down once
+        fixtures['released'] = None
+        self.update_metadata(run_id, fixtures=fixtures)
+        return True
+
     def save_data(self, agent_id, data):
         if agent_id in self._runs:
             data['run_id'], data['started'] = self._runs[agent_id]
 
         if data.get('data_type') == 'batch':
             for data_type, message in unbatch(data):
+                if data_type == 'checkpoint':
+                    self._save_checkpoint(data.get('run_id'), agent_id,
+                                          message)
+                    continue
                 message['data_type'] = data_type
+                self._watch(data.get('run_id'), data_type, message)
                 callback = functools.partial(self._db.add, message)
                 self.loop.add_callback(callback)
+        elif data.get('data_type') == 'checkpoint':
+            self._save_checkpoint(data.get('run_id'), agent_id, data)
         else:
+            self._watch(data.get('run_id'), data.get('data_type'), data)
             self._db.add(data)
 
     def get_urls(self, msg, data):
@@ -299,10 +664,45 @@ class BrokerController(object):
                     return False
         return True
 
+    def get_project(self, run_id):
+        """Returns the project of a run, or None for an unknown run."""
+        if run_id is None:
+            return None
+        if run_id in self._run_data:
+            return _get_project(self._run_data[run_id][0]['args'])
+        entry = self._get_entry(run_id)
+        if entry is not None:
+            return _get_project(entry['data']['args'])
+        metadata = self._db.get_metadata(run_id)
+        if not metadata:
+            return None
+        return _get_project(metadata)
+
+    def _check_scope(self, target, data):
+        """Makes sure a command given with the API key of a project only
+        touches its runs and its agents -- the others are unknown."""
+        scope = data.get('scope')
+        if scope is None:
+            return True
+
+        if 'run_id' in data and self.get_project(data['run_id']) != scope:
+            error = 'Unknown run %s' % data['run_id']
+        elif ('agent_id' in data and
+                not self.in_pool(str(data['agent_id']), scope)):
+            error = 'Unknown agent %s' % data['agent_id']
+        else:
+            return True
+
+        self.broker.send_json(target, {'error': error})
+        return False
+
     def run_command(self, cmd, msg, data):
         cmd = cmd.lower()
         target = msg[0]
 
+        if not self._check_scope(target, data):
+            return
+
         # command for agents
         if cmd.startswith('agent_'):
             command = cmd[len('agent_'):].upper()
@@ -341,25 +741,50 @@ class BrokerController(object):
         return
 
     def list_runs(self, msg, data):
+        scope = (data or {}).get('scope')
         runs = defaultdict(list)
         for agent_id, (run_id, when) in self._runs.items():
-            runs[run_id].append((agent_id, when))
+            if scope is None or self.get_project(run_id) == scope:
+                runs[run_id].append((agent_id, when))
         return runs
 
-    def stop_run(self, msg, data):
+    def _get_run_agents(self, run_id):
+        return [agent_id for agent_id, (_run_id, when) in self._runs.items()
+                if _run_id == run_id]
+
+    def get_live_stats(self, msg, data):
+        """Returns the live stats of the agents of a run -- see
+        :meth:`LiveStats.snapshot` -- and whether it's still active."""
+        if self.broker.live_stats is None:
+            raise ValueError('The broker keeps no live stats -- start it '
+                             'with --dashboard-address or --live-redis')
         run_id = data['run_id']
-        agents = []
+        agents = [str(agent_id) for agent_id
+                  in self._get_run_agents(run_id)]
+        stats = self.broker.live_stats.snapshot(agents=agents)
+        stats.pop('queue', None)
+        stats['run_id'] = run_id
+        stats['active'] = len(agents) > 0
+        return stats
 
-        for agent_id, (_run_id, when) in self._runs.items():
-            if run_id != _run_id:
-                continue
-            agents.append(agent_id)
+    def stop_run(self, msg, data):
+        run_id = data['run_id']
+        agents = self._get_run_agents(run_id)
+        self._preempted.discard(run_id)
 
         if len(agents) == 0:
             # we don't have any agents running that test, let's
             # force the flags in the DB
             self.update_metadata(run_id, stopped=True, active=False,
-                                 ended=time.time())
+                                 queued=False, ended=time.time())
+
+            # a queued run is over before it started
+            entry = self._get_entry(run_id)
+            if entry is not None:
+                self._queue.remove(entry)
+                self._queue_changed()
+                self.broker._publisher.send(json.dumps(
+                    {'data_type': 'run-finished', 'run_id': run_id}))
             return []
 
         # now we have a list of agents to stop
@@ -370,10 +795,138 @@ class BrokerController(object):
 
         return agents
 
+    def _signal_run(self, command, run_id, message=None, **metadata):
+        agents = self._get_run_agents(run_id)
+        if len(agents) == 0:
+            return []
+
+        self.update_metadata(run_id, **metadata)
+        message = dict(message or {})
+        message['command'] = command
+        msg = json.dumps(message)
+        for agent_id in agents:
+            self.send_to_agent(agent_id, msg)
+        return agents
+
+    def pause_run(self, msg, data):
+        """Pauses a run: its users stop starting tests, but keep their
+        connections."""
+        return self._signal_run('PAUSE', data['run_id'], paused=True)
+
+    def resume_run(self, msg, data):
+        return self._signal_run('RESUME', data['run_id'], paused=False)
+
+    def abort_run(self, msg, data):
+        """Aborts a run: unlike stop_run, the runners end their tests and
+        flush their results before leaving."""
+        return self._signal_run('ABORT', data['run_id'], paused=False,
+                                aborted=True)
+
+    def set_load(self, msg, data):
+        """Changes the users, or the arrival rate, of every agent of a
+        run -- or the ceiling of the requests per second of the run, shared
+        by its agents."""
+        run_id = data['run_id']
+        load = dict([(key, data[key]) for key in ('users', 'rate')
+                     if data.get(key) is not None])
+        if data.get('max_rps') is not None:
+            if run_id in self._run_data:
+                self._run_data[run_id][0]['args']['max_rps'] = data['max_rps']
+            load['max_rps'] = data['max_rps']
+            load['rps'] = _get_rps_share(
+                data, len(self._get_run_agents(run_id)))
+        if not load:
+            raise ValueError('The users, the rate or the max rps are needed')
+        return self._signal_run('SET_LOAD', run_id, load, load=load)
+
     #
     # Observers
     #
+    def _get_observers(self, run_id, args):
+        """Returns the observers of a run: its own ones and the ones of the
+        broker, with their options."""
+        args = dict(args, run_id=run_id)
+        names = list(args.get('observer') or [])
+        names.extend([name for name in self.observers if name not in names])
+
+        result = []
+        for observer in _compute_observers(names):
+            options = dict(self.observers.get(observer.name) or {})
+            # the defaults of the run don't replace the options of the broker
+            defaults = dict([(option['name'], option.get('default'))
+                             for option in getattr(observer, 'options', ())])
+            prefix = 'observer_%s_' % observer.name
+            for name, value in args.items():
+                if not name.startswith(prefix) or value is None:
+                    continue
+                name = name[len(prefix):]
+                if name in options and value == defaults.get(name):
+                    continue
+                options[name] = value
+            try:
+                result.append(observer(args=args, **options))
+            except Exception:
+                logger.error('%r failed' % observer)
+        for plugin in self.plugins:
+            result.append(PluginObserver(plugin, args=args))
+        return result
+
+    def _notify(self, observers, event, *params):
+        for observer in observers:
+            callback = getattr(observer, event, None)
+            if callback is None:
+                continue
+            try:
+                callback(*params)
+            except Exception:
+                # the observer code failed. We want to log it
+                logger.error('%r failed' % observer)
+
+    def _watch(self, run_id, data_type, data):
+        """Keeps the totals of the runs whose thresholds are watched."""
+        watched = self._watched.get(run_id)
+        if watched is None:
+            return
+        totals = watched['totals']
+        if data_type == 'add_hit':
+            failed, elapsed = _get_hit(data)
+            totals['hits'] += 1
+            if failed:
+                totals['errors'] += 1
+            totals['histogram'].record_value(elapsed)
+        elif data_type == 'stopTest':
+            totals['tests'] += 1
+        elif data_type in ('addFailure', 'addError'):
+            totals['failures'] += 1
+        else:
+            return
+        now = time.time()
+        if totals['started'] is None:
+            totals['started'] = now
+        totals['updated'] = now
+
+    def _check_thresholds(self):
+        """Tells the observers about the thresholds the active runs have
+        breached, once per threshold."""
+        for run_id, watched in self._watched.items():
+            for threshold in watched['thresholds']:
+                if str(threshold) in watched['breached']:
+                    continue
+                value, passed = threshold.evaluate_live(watched['totals'])
+                if value is None or passed:
+                    continue
+                watched['breached'].append(str(threshold))
+                self.update_metadata(run_id, breached=watched['breached'])
+                self._notify(watched['observers'], 'threshold_breached',
+                             run_id, str(threshold), threshold.format(value))
+
     def test_ended(self, run_id):
+        self._run_data.pop(run_id, None)
+        self._checkpoints.pop(run_id, None)
+        self._watched.pop(run_id, None)
+        self._sequences.pop(run_id, None)
+        self._partitions.pop(run_id, None)
+
         # first of all, we want to mark it done in the DB
         self.update_metadata(run_id, stopped=True, active=False,
                              ended=time.time())
@@ -382,7 +935,13 @@ class BrokerController(object):
         # for a given test.
         # get the list of observers
         args = self._db.get_metadata(run_id)
-        observers = _compute_observers(args.get('observer'))
+
+        # the runs of a series keep their summary, for their trends
+        if args.get('series') and not self._db.is_summarized(run_id):
+            args['summary'] = summarize_run(self._db.get_data(run_id))
+            self.update_metadata(run_id, summary=args['summary'])
+
+        observers = self._get_observers(run_id, args)
 
         if observers == []:
             self._db.summarize_run(run_id)
@@ -403,17 +962,14 @@ class BrokerController(object):
 
             test_result.set_counts(self._db.get_counts(run_id))
 
-        # for each observer we call it with the test results
+        # for each observer we call it with the test results -- and with
+        # the detailed ones when it wants them
+        summarized = self._db.is_summarized(run_id)
         for observer in observers:
-            options = {}
-            prefix = 'observer_%s_' % observer.name
-            for name, value in args.items():
-                if name.startswith(prefix):
-                    options[name[len(prefix):]] = value
-
-            # get the options
             try:
-                observer(args=args, **options)(test_result)
+                if hasattr(observer, 'set_records') and not summarized:
+                    observer.set_records(self._db.get_data(run_id))
+                observer(test_result)
             except Exception:
                 # the observer code failed. We want to log it
                 logger.error('%r failed' % observer)
@@ -429,9 +985,28 @@ class BrokerController(object):
         # create a unique id for this run
         run_id = str(uuid4())
 
+        # with the API key of a project, the run is one of its runs
+        if data.get('scope') is not None:
+            data['args']['project_name'] = data['scope']
+        data.pop('scope', None)
+
+        if data['args'].get('queue'):
+            self._queue_run(target, run_id, data)
+            return
+
+        project = _get_project(data['args'])
+        if not self._can_run(project):
+            self.broker.send_json(target, {'error': 'The project %s has too '
+                                                    'many runs' % project})
+            return
+
         # get some agents
         try:
-            agents = self.reserve_agents(data['agents'], run_id)
+            agents = self.reserve_agents(data['agents'], run_id, project,
+                                         get_requirements(data['args']))
+        except UnsupportedFeaturesError, e:
+            self.broker.send_json(target, {'error': str(e)})
+            return
         except NotEnoughWorkersError:
             self.broker.send_json(target, {'error': 'Not enough agents'})
             return
@@ -439,6 +1014,24 @@ class BrokerController(object):
         # make sure the DB is prepared
         self._db.prepare_run()
 
+        # notice when the test was started, and save the tests metadata in
+        # the db
+        metadata = dict(data['args'])
+        metadata['started'] = time.time()
+        metadata['active'] = True
+        self.save_metadata(run_id, metadata)
+        self.flush_db()
+
+        self._start_run(run_id, data, agents, metadata['started'])
+
+        # tell the client which agents where selected.
+        res = {'result': {'agents': agents, 'run_id': run_id}}
+        self.broker.send_json(target, res)
+
+    def _start_run(self, run_id, data, agents, started, checkpoints=None):
+        if checkpoints is None:
+            checkpoints = {}
+
         # send to every agent with the run_id and the receiver endpoint
         data['run_id'] = run_id
         data['args']['zmq_receiver'] = self.broker.endpoints['receiver']
@@ -446,20 +1039,408 @@ class BrokerController(object):
         # replace CTRL_RUN by RUN
         data['command'] = 'RUN'
 
-        # rebuild the ZMQ message to pass to agents
-        msg = json.dumps(data)
-
-        # notice when the test was started
-        data['args']['started'] = time.time()
+        # rebuild the ZMQ messages to pass to agents. Every agent gets its
+        # index in the run, so it can pick its own share of the test data,
+        # and its last checkpoint when the run is resumed.
+        msgs = []
+        share = _get_rps_share(data['args'], len(agents))
+        if share is not None:
+            data['args']['agent_max_rps'] = share
+        # every agent gets its partition of the --feeder file
+        partitions = None
+        if data.get('feeder_data'):
+            partitions = split_feeder(data.pop('feeder_data'),
+                                      data['args']['feeder'], len(agents))
+            self._partitions[run_id] = partitions
+        for index in range(len(agents)):
+            data['args']['agent_index'] = index
+            if str(index) in checkpoints:
+                data['args']['checkpoint'] = checkpoints[str(index)]
+            if partitions is not None:
+                data['feeder_partition'] = partitions[index]
+            msgs.append(json.dumps(data))
+            data['args'].pop('checkpoint', None)
+        del data['args']['agent_index']
+        data.pop('feeder_partition', None)
+        data['args'].pop('agent_max_rps', None)
+
+        data['args']['started'] = started
         data['args']['active'] = True
 
-        # save the tests metadata in the db
-        self.save_metadata(run_id, data['args'])
+        for agent_id, msg in zip(agents, msgs):
+            self.send_to_agent(agent_id, msg)
+
+        self._run_data[run_id] = (data, dict([(agent_id, index)
+                                              for index, agent_id
+                                              in enumerate(agents)]))
+
+        observers = self._get_observers(run_id, data['args'])
+        self._notify(observers, 'run_started', run_id)
+
+        # the thresholds are watched during the run when an observer is
+        # told about their breaches
+        thresholds = parse_thresholds(data['args'].get('threshold'))
+        observers = [observer for observer in observers
+                     if hasattr(observer, 'threshold_breached')]
+        if thresholds and observers:
+            self._watched[run_id] = {'thresholds': thresholds,
+                                     'observers': observers,
+                                     'totals': _new_totals(),
+                                     'breached': []}
+
+    def continue_run(self, msg, data):
+        """Starts again an interrupted run -- like when the broker was
+        restarted -- under the same id. Every agent goes on from the last
+        checkpoint of the agent it replaces."""
+        target = msg[0]
+        run_id = data['run_id']
+
+        running = [run_id_ for run_id_, when in self._runs.values()]
+        metadata = self._db.get_metadata(run_id)
+        if run_id in running:
+            error = 'The run %s is running' % run_id
+        elif self._get_entry(run_id) is not None:
+            error = 'The run %s is queued' % run_id
+        elif not metadata:
+            error = 'Unknown run %s' % run_id
+        elif not metadata.get('active'):
+            error = 'The run %s is over' % run_id
+        else:
+            error = None
+
+        if error is not None:
+            self.broker.send_json(target, {'error': error})
+            return
+
+        args = dict([(key, value) for key, value in metadata.items()
+                     if key not in _RUN_STATE])
+        try:
+            agents = self.reserve_agents(args.get('agents') or 1, run_id,
+                                         _get_project(args),
+                                         get_requirements(args))
+        except UnsupportedFeaturesError, e:
+            self.broker.send_json(target, {'error': str(e)})
+            return
+        except NotEnoughWorkersError:
+            self.broker.send_json(target, {'error': 'Not enough agents'})
+            return
+
+        # the run goes on from the most advanced checkpoint, so the time
+        # computations -- like for the lost agents -- still hold
+        checkpoints = metadata.get('checkpoints') or {}
+        self._checkpoints[run_id] = checkpoints
+        elapsed = _get_elapsed(checkpoints)
+        logger.info('Resuming run %s after %ds' % (run_id, elapsed))
+        started = time.time() - elapsed
+        self.update_metadata(run_id, started=started,
+                             resumed=metadata.get('resumed', 0) + 1)
         self.flush_db()
 
-        for agent_id in agents:
-            self.send_to_agent(agent_id, msg)
+        run = {'args': args, 'agents': len(agents),
+               'filedata': data.get('filedata')}
+        self._start_run(run_id, run, agents, started, checkpoints)
+        self.broker.send_json(target, {'result': {'agents': agents,
+                                                  'run_id': run_id}})
 
-        # tell the client which agents where selected.
-        res = {'result': {'agents': agents, 'run_id': run_id}}
-        self.broker.send_json(target, res)
+    def _save_checkpoint(self, run_id, agent_id, checkpoint):
+        if run_id not in self._run_data:
+            return
+
+        if run_id not in self._checkpoints:
+            # like after a failover
+            metadata = self._db.get_metadata(run_id)
+            self._checkpoints[run_id] = metadata.get('checkpoints') or {}
+
+        index = self._run_data[run_id][1].get(agent_id, 0)
+        checkpoints = self._checkpoints[run_id]
+        checkpoints[str(index)] = dict([(key, checkpoint.get(key))
+                                        for key in _CHECKPOINT])
+        self.update_metadata(run_id, checkpoints=checkpoints)
+
+    #
+    # The queue
+    #
+    def get_queue(self, msg, data):
+        scope = (data or {}).get('scope')
+        return [entry for entry in self.queue
+                if scope is None or entry['project'] == scope]
+
+    def _get_queue_order(self):
+        # the highest priorities first, then the oldest runs
+        return sorted(self._queue, key=lambda entry: (
+            -_get_priority(entry['data']['args']), entry['queued']))
+
+    def _get_entry(self, run_id):
+        for entry in self._queue:
+            if entry['run_id'] == run_id:
+                return entry
+        return None
+
+    def _queue_changed(self):
+        queue = self.queue
+        if self.broker.live_stats is not None:
+            self.broker.live_stats.set_queue(queue)
+        self.broker._publisher.send(json.dumps({'data_type': 'run-queue',
+                                                'queue': queue}))
+
+    def _can_run(self, project):
+        """Tells if a project has less runs than its limit."""
+        limit = self.project_limits.get(project,
+                                        self.project_limits.get('*'))
+        if limit is None:
+            return True
+        runs = [run_id for run_id, (data, indexes) in self._run_data.items()
+                if _get_project(data['args']) == project]
+        return len(runs) < limit
+
+    def _queue_run(self, target, run_id, data):
+        """Queues a new run, and tells the client its position -- or its
+        agents, when it could start at once."""
+        self._add_to_queue(run_id, data)
+        res = {'agents': self._get_run_agents(run_id), 'run_id': run_id}
+        for entry in self.queue:
+            if entry['run_id'] == run_id:
+                res['queued'] = entry['position']
+        self.broker.send_json(target, {'result': res})
+
+    def _add_to_queue(self, run_id, data, **state):
+        self._db.prepare_run()
+        metadata = dict(data['args'])
+        metadata.update(state)
+        metadata['queued'] = time.time()
+        metadata['active'] = True
+        self.save_metadata(run_id, metadata)
+        self.flush_db()
+
+        self._enqueue(run_id, data, metadata['queued'])
+        self._process_queue()
+
+    def _enqueue(self, run_id, data, queued, checkpoints=None,
+                 preempted=False):
+        self._queue.append({'run_id': run_id, 'data': data,
+                            'queued': queued,
+                            'checkpoints': checkpoints or {},
+                            'preempted': preempted})
+        self._queue_changed()
+
+    def _process_queue(self):
+        """Starts the queued runs that can get their agents, in order.
+
+        A run that lacks agents preempts the runs of lower priorities, and
+        the runs after it wait until it got its agents -- unless even the
+        preemptions would not give it enough agents.
+        """
+        if not self._queue:
+            return
+
+        for entry in self._get_queue_order():
+            project = _get_project(entry['data']['args'])
+            if not self._can_run(project):
+                continue
+            needed = entry['data']['agents']
+            requirements = get_requirements(entry['data']['args'])
+            free = len(self._get_free_agents(project, requirements))
+            if needed <= free:
+                self._start_queued(entry)
+                continue
+
+            stopping = len([agent_id for agent_id, (run_id, when)
+                            in self._runs.items()
+                            if run_id in self._preempted and
+                            self.in_pool(agent_id, project) and
+                            self.supports(agent_id, requirements)])
+            missing = needed - free - stopping
+            if missing > 0:
+                priority = _get_priority(entry['data']['args'])
+                victims = self._get_victims(priority, project, missing,
+                                            requirements)
+                if not victims:
+                    continue
+                for run_id in victims:
+                    self._preempt(run_id)
+
+            # the free agents, and the stopping ones, are for that run
+            break
+
+    def _get_victims(self, priority, project, missing, requirements=None):
+        """Returns the runs of lower priorities to preempt to free
+        *missing* agents of the pool of a project -- the lowest priorities
+        and the latest runs first -- or nothing when it is not possible.
+        Only the agents supporting *requirements* count."""
+        runs = []
+        for run_id, (data, indexes) in self._run_data.items():
+            run_priority = _get_priority(data['args'])
+            if run_id in self._preempted or run_priority >= priority:
+                continue
+            runs.append((run_priority, -data['args'].get('started', 0),
+                         run_id))
+        runs.sort()
+
+        victims, freed = [], 0
+        for run_priority, started, run_id in runs:
+            if freed >= missing:
+                break
+            victims.append(run_id)
+            freed += len([agent_id for agent_id
+                          in self._get_run_agents(run_id)
+                          if self.in_pool(agent_id, project) and
+                          self.supports(agent_id, requirements)])
+
+        if freed < missing:
+            return []
+        return victims
+
+    def _preempt(self, run_id):
+        logger.info('Preempting run %s' % run_id)
+        self._preempted.add(run_id)
+        metadata = self._db.get_metadata(run_id)
+        self.broker._publisher.send(json.dumps({'data_type': 'run-preempted',
+                                                'run_id': run_id}))
+        self._signal_run('ABORT', run_id,
+                         preempted=metadata.get('preempted', 0) + 1)
+
+    def _requeue(self, run_id):
+        """Puts a preempted run back in the queue, to go on from its last
+        checkpoints -- at its place among the runs of its priority."""
+        self._preempted.discard(run_id)
+        data, indexes = self._run_data.pop(run_id)
+        checkpoints = self._checkpoints.pop(run_id, None)
+        if checkpoints is None:
+            checkpoints = self._db.get_metadata(run_id).get('checkpoints', {})
+
+        queued = data['args'].get('started', time.time())
+        data = dict(data)
+        data['args'] = dict(data['args'])
+        for key in ('started', 'active'):
+            data['args'].pop(key, None)
+
+        logger.info('Run %s queued again' % run_id)
+        self.update_metadata(run_id, queued=time.time())
+        self._enqueue(run_id, data, queued, checkpoints, preempted=True)
+
+    def _start_queued(self, entry):
+        run_id = entry['run_id']
+        try:
+            args = entry['data']['args']
+            agents = self.reserve_agents(entry['data']['agents'], run_id,
+                                         _get_project(args),
+                                         get_requirements(args))
+        except NotEnoughWorkersError:
+            return False
+
+        self._queue.remove(entry)
+        checkpoints = entry['checkpoints']
+        self._checkpoints[run_id] = checkpoints
+        started = time.time() - _get_elapsed(checkpoints)
+        logger.info('Starting queued run %s' % run_id)
+        self.update_metadata(run_id, started=started, queued=False)
+        self.flush_db()
+
+        self._start_run(run_id, entry['data'], agents, started, checkpoints)
+        self.broker._publisher.send(json.dumps({'data_type': 'run-started',
+                                                'run_id': run_id,
+                                                'agents': agents}))
+        self._queue_changed()
+        return True
+
+    #
+    # The schedules
+    #
+    def schedule_run(self, msg, data):
+        """Schedules a run at the *at* timestamp of its arguments, or on
+        their *cron* expression -- see :mod:`loads.schedule`. Returns the id
+        of the schedule and the time of its next run."""
+        # with the API key of a project, the run is one of its runs
+        if data.get('scope') is not None:
+            data['args']['project_name'] = data['scope']
+        data.pop('scope', None)
+
+        args = data['args']
+        if args.get('cron'):
+            cron = Cron(args['cron']).expression
+            next_time = Cron(cron).next()
+        elif args.get('at') is not None:
+            cron, next_time = None, float(args['at'])
+        else:
+            raise ValueError('A schedule needs a time or a cron expression')
+
+        entry = {'schedule_id': str(uuid4()), 'data': data, 'cron': cron,
+                 'next': next_time, 'runs': 0, 'last_run': None}
+        self._schedules.append(entry)
+        logger.info('Scheduled %s at %s' % (entry['schedule_id'],
+                                            time.ctime(next_time)))
+        return {'schedule_id': entry['schedule_id'], 'next': next_time}
+
+    def _describe_schedule(self, entry):
+        args = entry['data']['args']
+        return {'schedule_id': entry['schedule_id'],
+                'fqn': args.get('fqn'),
+                'project': _get_project(args),
+                'series': args.get('series'),
+                'cron': entry['cron'],
+                'next': entry['next'],
+                'runs': entry['runs'],
+                'last_run': entry['last_run']}
+
+    def list_schedules(self, msg, data):
+        scope = (data or {}).get('scope')
+        schedules = [self._describe_schedule(entry)
+                     for entry in self._schedules]
+        schedules.sort(key=lambda schedule: schedule['next'])
+        return [schedule for schedule in schedules
+                if scope is None or schedule['project'] == scope]
+
+    def cancel_schedule(self, msg, data):
+        """Removes a schedule -- its runs already started go on."""
+        for entry in self._schedules:
+            schedule = self._describe_schedule(entry)
+            if (schedule['schedule_id'] == data['schedule_id'] and
+                    data.get('scope') in (None, schedule['project'])):
+                self._schedules.remove(entry)
+                return schedule
+        raise ValueError('Unknown schedule %s' % data['schedule_id'])
+
+    def _check_schedules(self, now=None):
+        """Queues the runs of the schedules that are due."""
+        if now is None:
+            now = time.time()
+
+        for entry in list(self._schedules):
+            if entry['next'] > now:
+                continue
+
+            run_id = str(uuid4())
+            data = dict(entry['data'])
+            data['args'] = dict(data['args'])
+            for key in ('at', 'cron'):
+                data['args'].pop(key, None)
+            data['args']['schedule_id'] = entry['schedule_id']
+            logger.info('Queuing run %s of schedule %s' %
+                        (run_id, entry['schedule_id']))
+            entry['runs'] += 1
+            entry['last_run'] = run_id
+            self._add_to_queue(run_id, data, scheduled=entry['next'])
+
+            if entry['cron'] is None:
+                self._schedules.remove(entry)
+            else:
+                entry['next'] = Cron(entry['cron']).next(now)
+
+    def get_series(self, msg, data):
+        """Returns the runs of a series, the oldest first, with their
+        summary once they are over."""
+        scope = data.get('scope')
+        runs = []
+        for run_id in self._db.get_runs():
+            metadata = self._db.get_metadata(run_id)
+            if metadata.get('series') != data['series']:
+                continue
+            if scope is not None and _get_project(metadata) != scope:
+                continue
+            runs.append({'run_id': run_id,
+                         'started': metadata.get('started'),
+                         'ended': metadata.get('ended'),
+                         'active': bool(metadata.get('active')),
+                         'summary': metadata.get('summary')})
+        runs.sort(key=lambda run: run['started'] or 0)
+        return runs
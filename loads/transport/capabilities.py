@@ -0,0 +1,103 @@
+""" The capabilities of the agents.
+
+The broker asks every agent it does not know what it supports, with the
+*CAPABILITIES* command of the control protocol, and only sends a run to the
+agents supporting the features and the engines it needs::
+
+    >>> get_capabilities()
+    {'version': '0.3', 'protocol': 2, 'features': ['arrival-rate', ...],
+     'engines': ['dns', 'evloop', 'grpc', ...]}
+
+The agents older than the handshake reply with an error: they get the
+:data:`LEGACY` capabilities, so they only run the tests that need no
+feature.
+"""
+import os
+
+import loads
+
+
+# the version of the control protocol -- 1 is the one before the
+# capability handshake
+PROTOCOL = 2
+
+# the options of a run the agents need a feature for, when set
+_OPTION_FEATURES = (('stages', 'stages'),
+                    ('arrival_rate', 'arrival-rate'),
+                    ('max_rps', 'max-rps'),
+                    ('think_time', 'think-time'),
+                    ('pacing', 'pacing'),
+                    ('feeder', 'feeders'),
+                    ('templates', 'templates'),
+                    ('seed', 'seed'),
+                    ('hooks', 'hooks'),
+                    ('network', 'network'),
+                    ('latency', 'network'),
+                    ('proxies', 'proxies'),
+                    ('source_ips', 'source-ips'),
+                    ('client_cert', 'tls'),
+                    ('tls_min_version', 'tls'),
+                    ('hop_limit', 'ip-options'),
+                    ('traffic_class', 'ip-options'),
+                    ('http_engine', 'http-engine'),
+                    ('http_cache', 'http-cache'),
+                    ('plugin', 'plugins'))
+
+FEATURES = tuple(sorted(set([feature for option, feature
+                             in _OPTION_FEATURES])))
+
+# what the agents of protocol 1 get
+LEGACY = {'version': None, 'protocol': 1, 'features': [], 'engines': []}
+
+
+def get_engines():
+    """Returns the engines of :mod:`loads.engines` this process can import
+    -- the other ones lack their dependencies."""
+    location = os.path.dirname(loads.engines.__file__)
+    names = set([os.path.splitext(name)[0] for name in os.listdir(location)
+                 if os.path.splitext(name)[1] in ('.py', '.pyc')])
+    names.discard('__init__')
+
+    engines = []
+    for name in sorted(names):
+        try:
+            __import__('loads.engines.%s' % name)
+        except ImportError:
+            continue
+        engines.append(name)
+    return engines
+
+
+def get_capabilities():
+    """Returns the capabilities of this agent."""
+    return {'version': loads.__version__, 'protocol': PROTOCOL,
+            'features': list(FEATURES), 'engines': get_engines()}
+
+
+def get_requirements(args):
+    """Returns the features and the engines the agents of a run need: the
+    ones of its options, and the ones of its *requires* argument."""
+    requirements = set([feature for option, feature in _OPTION_FEATURES
+                        if args.get(option)])
+    requirements.update(args.get('requires') or [])
+    return sorted(requirements)
+
+
+def get_missing(capabilities, requirements):
+    """Returns the requirements an agent does not support -- all of them
+    when its capabilities are not known yet."""
+    if capabilities is None:
+        return list(requirements)
+    supported = set(capabilities.get('features', []) +
+                    capabilities.get('engines', []))
+    return [requirement for requirement in requirements
+            if requirement not in supported]
+
+
+def describe(capabilities):
+    """Returns the version of an agent, for humans."""
+    if capabilities is None:
+        return 'unknown version'
+    if capabilities.get('version') is None:
+        return 'protocol %d' % capabilities.get('protocol', 1)
+    return 'loads %s' % capabilities['version']
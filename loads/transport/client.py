@@ -7,13 +7,15 @@ import functools
 import zmq
 
 from loads.util import json
-from loads.transport.exc import TimeoutError, ExecutionError
+from loads.transport.exc import (TimeoutError, ExecutionError,
+                                 AuthenticationError)
 from loads.transport.message import Message
+from loads.feeders import pack_feeder
 from loads.util import logger, pack_include_files
 from loads.transport.util import (send, recv, DEFAULT_FRONTEND,
                                   timed, DEFAULT_TIMEOUT,
                                   DEFAULT_TIMEOUT_MOVF,
-                                  DEFAULT_TIMEOUT_OVF)
+                                  DEFAULT_TIMEOUT_OVF, connect)
 
 
 class Client(object):
@@ -33,12 +35,14 @@ class Client(object):
       **timeout_overflows**, the usual TimeoutError is raised.
       When a agent returns on time, the counter is reset.
     - **ssh** ssh tunnel server.
+    - **api_key**: the API key of the project, when the broker needs one.
     """
     def __init__(self, frontend=DEFAULT_FRONTEND, timeout=DEFAULT_TIMEOUT,
                  timeout_max_overflow=DEFAULT_TIMEOUT_MOVF,
                  timeout_overflows=DEFAULT_TIMEOUT_OVF,
-                 debug=False, ctx=None, ssh=None):
+                 debug=False, ctx=None, ssh=None, api_key=None):
         self.ssh = ssh
+        self.api_key = api_key
         self.kill_ctx = ctx is None
         self.ctx = ctx or zmq.Context()
         self.frontend = frontend
@@ -47,7 +51,7 @@ class Client(object):
             from zmq import ssh
             ssh.tunnel_connection(self.master, frontend, self.ssh)
         else:
-            self.master.connect(frontend)
+            connect(self.master, frontend)
 
         self.poller = zmq.Poller()
         self.poller.register(self.master, zmq.POLLIN)
@@ -86,6 +90,8 @@ class Client(object):
             raise
 
         if 'error' in res:
+            if res.get('unauthorized'):
+                raise AuthenticationError(res['error'])
             raise ValueError(res['error'])
 
         return res['result']
@@ -100,6 +106,8 @@ class Client(object):
     def _execute(self, job, timeout=None):
 
         if not isinstance(job, Message):
+            if self.api_key is not None:
+                job = dict(job, api_key=self.api_key)
             job = Message(**job)
 
         if timeout is None:
@@ -126,10 +134,10 @@ class Client(object):
         # let's ask the broker how many agents it has
         res = self.execute({'command': 'LIST'})
 
-        # do we have enough ?
+        # do we have enough ? a queued run waits for them
         agents = len(res)
         agents_needed = args.get('agents', 1)
-        if len(res) < agents_needed:
+        if len(res) < agents_needed and not args.get('queue'):
             msg = 'Not enough agents running on that broker. '
             msg += 'Asked: %d, Got: %d' % (agents_needed, agents)
 
@@ -138,17 +146,37 @@ class Client(object):
         # let's copy over some files if we need
         includes = args.get('include_file', [])
 
+        # the key is not a part of the run
+        args = dict([(key, value) for key, value in args.items()
+                     if key != 'api_key'])
         cmd = {'command': 'CTRL_RUN',
                'async': async,
                'agents': agents_needed,
                'args': args}
 
         cmd['filedata'] = pack_include_files(includes)
+        if args.get('feeder_partition') and args.get('feeder'):
+            # split by the broker, see loads.feeders
+            cmd['feeder_data'] = pack_feeder(args['feeder'])
         res = self.execute(cmd)
         logger.debug('Run on its way')
         logger.debug(res)
         return res
 
+    def schedule_run(self, args):
+        """Schedules a run at the *at* timestamp of its arguments, or on
+        their *cron* expression."""
+        includes = args.get('include_file', [])
+        args = dict([(key, value) for key, value in args.items()
+                     if key != 'api_key'])
+        cmd = {'command': 'CTRL_SCHEDULE_RUN',
+               'agents': args.get('agents', 1),
+               'args': args,
+               'filedata': pack_include_files(includes)}
+        if args.get('feeder_partition') and args.get('feeder'):
+            cmd['feeder_data'] = pack_feeder(args['feeder'])
+        return self.execute(cmd)
+
     def ping(self, timeout=None, log_exceptions=True):
         return self.execute({'command': 'PING'}, timeout=timeout,
                             log_exceptions=log_exceptions)
@@ -168,6 +196,39 @@ class Client(object):
     def stop_run(self, run_id):
         return self.execute({'command': 'CTRL_STOP_RUN', 'run_id': run_id})
 
+    def pause_run(self, run_id):
+        return self.execute({'command': 'CTRL_PAUSE_RUN', 'run_id': run_id})
+
+    def resume_run(self, run_id):
+        return self.execute({'command': 'CTRL_RESUME_RUN', 'run_id': run_id})
+
+    def continue_run(self, run_id, includes=None):
+        """Resumes an interrupted run from its last checkpoints. The
+        included files have to be given again."""
+        return self.execute({'command': 'CTRL_CONTINUE_RUN', 'run_id': run_id,
+                             'filedata': pack_include_files(includes or [])})
+
+    def abort_run(self, run_id):
+        return self.execute({'command': 'CTRL_ABORT_RUN', 'run_id': run_id})
+
+    def set_load(self, run_id, users=None, rate=None, max_rps=None):
+        return self.execute({'command': 'CTRL_SET_LOAD', 'run_id': run_id,
+                             'users': users, 'rate': rate,
+                             'max_rps': max_rps})
+
+    def get_queue(self):
+        return self.execute({'command': 'CTRL_GET_QUEUE'})
+
+    def list_schedules(self):
+        return self.execute({'command': 'CTRL_LIST_SCHEDULES'})
+
+    def cancel_schedule(self, schedule_id):
+        return self.execute({'command': 'CTRL_CANCEL_SCHEDULE',
+                             'schedule_id': schedule_id})
+
+    def get_series(self, series):
+        return self.execute({'command': 'CTRL_GET_SERIES', 'series': series})
+
     def get_counts(self, run_id):
         res = self.execute({'command': 'CTRL_GET_COUNTS', 'run_id': run_id})
         # XXX why ?
@@ -175,6 +236,26 @@ class Client(object):
             return res.items()
         return res
 
+    def get_live_stats(self, run_id):
+        return self.execute({'command': 'CTRL_GET_LIVE_STATS',
+                             'run_id': run_id})
+
+    def reserve_ids(self, run_id, name='default', size=None):
+        return self.execute({'command': 'CTRL_RESERVE_IDS', 'run_id': run_id,
+                             'name': name, 'size': size})
+
+    def set_fixtures(self, run_id, fixtures=None, error=None):
+        return self.execute({'command': 'CTRL_SET_FIXTURES',
+                             'run_id': run_id, 'fixtures': fixtures,
+                             'error': error})
+
+    def get_fixtures(self, run_id):
+        return self.execute({'command': 'CTRL_GET_FIXTURES', 'run_id': run_id})
+
+    def release_fixtures(self, run_id, agent_index=0):
+        return self.execute({'command': 'CTRL_RELEASE_FIXTURES',
+                             'run_id': run_id, 'agent_index': agent_index})
+
     def get_metadata(self, run_id):
         return self.execute({'command': 'CTRL_GET_METADATA', 'run_id': run_id})
 
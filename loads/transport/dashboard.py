@@ -0,0 +1,474 @@
+""" A live dashboard of the running load tests.
+
+The broker feeds the results it receives to a :class:`LiveStats` instance,
+and serves the dashboard on http://address/. The page is fed every second
+through a WebSocket, on */ws*, with the rolling stats of every agent and
+scenario, and with the runs queued on the broker. The stats are also
+served as JSON on */stats*.
+
+With API keys, the pages need the key of a project -- in a *key* parameter
+or an *Authorization: Bearer* header -- and only show its runs.
+"""
+import base64
+import hashlib
+import struct
+import threading
+import time
+import urlparse
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+from SocketServer import ThreadingMixIn
+from datetime import timedelta
+
+from loads.histogram import Histogram
+from loads.transport.metrics import parse_address
+from loads.util import json, logger, total_seconds, unbatch
+
+
+# the stats are computed on the last WINDOW seconds
+WINDOW = 10
+
+_WS_GUID = '258EAFA5-E914-47DA-95CA-C5AB0DC85B11'
+
+
+def _get_scenario(data):
+    if data.get('scenario'):
+        return data['scenario']
+    if data.get('test'):
+        return str(data['test']).split()[0]
+    return '?'
+
+
+def _get_hit(data):
+    """Returns whether a hit failed, and its elapsed time in
+    microseconds."""
+    status = data.get('status')
+    if isinstance(status, basestring):
+        failed = status != 'OK'
+    else:
+        failed = not 200 <= status < 400
+
+    elapsed = data.get('elapsed', 0)
+    if isinstance(elapsed, timedelta):
+        elapsed = total_seconds(elapsed)
+    return failed, int(round(elapsed * 10 ** 6))
+
+
+def _summarize(buckets, users, window):
+    hits = errors = 0
+    histogram = Histogram()
+    for bucket_hits, bucket_errors, bucket_histogram in buckets:
+        hits += bucket_hits
+        errors += bucket_errors
+        histogram.add(bucket_histogram)
+
+    stats = {'users': users, 'rps': float(hits) / window,
+             'error_rate': hits and errors * 100. / hits or 0.}
+    for percentile in (50, 95, 99):
+        value = histogram.get_value_at_percentile(percentile)
+        if value is not None:
+            value = value / 1000.
+        stats['p%d' % percentile] = value
+    return stats
+
+
+class LiveStats(object):
+    """The rolling stats of the running tests, per agent and scenario.
+
+    :param window: the number of seconds the stats are computed on.
+    :param clock: returns the current time.
+    """
+    # whether other processes see the stats
+    shared = False
+
+    def __init__(self, window=WINDOW, clock=time.time):
+        self.window = window
+        self.clock = clock
+        self.lock = threading.Lock()
+        # {(agent, scenario): {second: [hits, errors, histogram]}}
+        self._buckets = {}
+        # {(agent, scenario): tests started and not stopped}
+        self._users = {}
+        self._queue = []
+        # {agent: the project of its run}
+        self._projects = {}
+
+    def add(self, data, project=None):
+        """Adds a message sent by the runners -- batched or not -- for a
+        run of *project*."""
+        if data.get('data_type') == 'batch':
+            messages = list(unbatch(data))
+        else:
+            messages = [(data.get('data_type'), data)]
+
+        with self.lock:
+            if project is not None:
+                self._projects[str(data.get('agent_id'))] = project
+            for data_type, message in messages:
+                self._add(data_type, message)
+
+    def _add(self, data_type, data):
+        key = str(data.get('agent_id')), _get_scenario(data)
+        if data_type == 'add_hit':
+            second = int(self.clock())
+            buckets = self._buckets.setdefault(key, {})
+            if second not in buckets:
+                buckets[second] = [0, 0, Histogram()]
+            bucket = buckets[second]
+
+            failed, elapsed = _get_hit(data)
+            bucket[0] += 1
+            if failed:
+                bucket[1] += 1
+            bucket[2].record_value(elapsed)
+        elif data_type == 'startTest':
+            self._users[key] = self._users.get(key, 0) + 1
+        elif data_type == 'stopTest':
+            self._users[key] = max(self._users.get(key, 0) - 1, 0)
+
+    def set_queue(self, queue):
+        """Sets the runs queued on the broker."""
+        with self.lock:
+            self._queue = list(queue)
+
+    def _prune(self, now):
+        oldest = int(now) - self.window
+        for key, buckets in self._buckets.items():
+            for second in list(buckets):
+                if second <= oldest:
+                    del buckets[second]
+            if not buckets:
+                del self._buckets[key]
+
+    def snapshot(self, project=None, agents=None):
+        """Returns the stats of the whole run, and of every agent and
+        scenario -- the times are in milliseconds, the error rates in
+        percents. With a *project*, only its runs are counted, and with
+        *agents* only theirs."""
+        now = self.clock()
+        with self.lock:
+            self._prune(now)
+            buckets = dict([(key, list(seconds.values())) for key, seconds
+                            in self._buckets.items()])
+            users = dict(self._users)
+            queue = list(self._queue)
+            projects = dict(self._projects)
+
+        keys = set(buckets) | set([key for key, count in users.items()
+                                   if count])
+        if project is not None:
+            keys = set([key for key in keys
+                        if projects.get(key[0]) == project])
+            queue = [entry for entry in queue
+                     if entry.get('project') == project]
+        if agents is not None:
+            keys = set([key for key in keys if key[0] in agents])
+
+        return _snapshot(now, self.window, keys, buckets, users, queue)
+
+
+def _snapshot(now, window, keys, buckets, users, queue):
+    """Returns the stats of the (agent, scenario) *keys*, given their
+    *buckets* and *users*."""
+    def summarize(agent=None, scenario=None):
+        selected = [key for key in keys
+                    if (agent is None or key[0] == agent) and
+                    (scenario is None or key[1] == scenario)]
+        return _summarize(
+            [bucket for key in selected for bucket in buckets.get(key, [])],
+            sum([users.get(key, 0) for key in selected]), window)
+
+    agents = {}
+    for agent in set([agent for agent, scenario in keys]):
+        agents[agent] = {
+            'total': summarize(agent=agent),
+            'scenarios': dict([(scenario, summarize(agent, scenario))
+                               for _agent, scenario in keys
+                               if _agent == agent])}
+
+    scenarios = dict([(scenario, summarize(scenario=scenario))
+                      for scenario in set([s for a, s in keys])])
+    return {'time': now, 'window': window, 'total': summarize(),
+            'agents': agents, 'scenarios': scenarios, 'queue': queue}
+
+
+def websocket_accept(key):
+    """Returns the Sec-WebSocket-Accept header of a handshake."""
+    return base64.b64encode(hashlib.sha1(key + _WS_GUID).digest())
+
+
+def websocket_frame(message):
+    """Returns a text frame -- the servers don't mask them."""
+    length = len(message)
+    if length < 126:
+        header = struct.pack('!BB', 0x81, length)
+    elif length < 2 ** 16:
+        header = struct.pack('!BBH', 0x81, 126, length)
+    else:
+        header = struct.pack('!BBQ', 0x81, 127, length)
+    return header + message
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        url = urlparse.urlparse(self.path)
+        path = url.path
+        if path not in ('/', '/stats', '/ws'):
+            self.send_error(404)
+            return
+
+        # the project of the key, None being every project
+        project = None
+        keys = self.server.keys
+        if keys is not None:
+            key = dict(urlparse.parse_qsl(url.query)).get('key')
+            authorization = self.headers.get('Authorization', '')
+            if authorization.startswith('Bearer '):
+                key = authorization[len('Bearer '):]
+            project = keys.get(key)
+            if project is None:
+                self.send_error(401)
+                return
+            if project == '*':
+                project = None
+
+        if path == '/':
+            self._send(_PAGE, 'text/html; charset=utf-8')
+        elif path == '/stats':
+            self._send(json.dumps(self.server.stats.snapshot(project)),
+                       'application/json')
+        else:
+            self._stream(project)
+
+    def _send(self, body, content_type):
+        self.send_response(200)
+        self.send_header('Content-Type', content_type)
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def _stream(self, project):
+        key = self.headers.get('Sec-WebSocket-Key')
+        if key is None or \
+                self.headers.get('Upgrade', '').lower() != 'websocket':
+            self.send_error(400)
+            return
+
+        self.send_response(101, 'Switching Protocols')
+        self.send_header('Upgrade', 'websocket')
+        self.send_header('Connection', 'Upgrade')
+        self.send_header('Sec-WebSocket-Accept', websocket_accept(key))
+        self.end_headers()
+
+        stopped = self.server.stopped
+        while not stopped.is_set():
+            message = json.dumps(self.server.stats.snapshot(project))
+            try:
+                self.wfile.write(websocket_frame(message))
+                self.wfile.flush()
+            except Exception:
+                # the browser went away
+                break
+            stopped.wait(self.server.interval)
+        self.close_connection = 1
+
+    def log_message(self, *args):
+        pass
+
+
+class _Server(ThreadingMixIn, HTTPServer):
+    daemon_threads = True
+
+
+class DashboardServer(object):
+    """Serves the dashboard on http://address/, in a thread.
+
+    :param stats: the :class:`LiveStats` to display.
+    :param interval: the seconds between two updates of the page.
+    :param keys: when set, the API keys the pages need, and the project
+                 each one gives access to -- "*" giving access to every
+                 project.
+    """
+    def __init__(self, stats, address, interval=1., keys=None):
+        self.stats = stats
+        self.server = _Server(parse_address(address), _Handler)
+        self.server.stats = stats
+        self.server.interval = interval
+        self.server.keys = keys
+        self.server.stopped = threading.Event()
+        self.address = '%s:%d' % self.server.server_address
+        self._thread = None
+
+    def start(self):
+        logger.info('Serving the dashboard at http://%s/' % self.address)
+        self._thread = threading.Thread(target=self.server.serve_forever)
+        self._thread.daemon = True
+        self._thread.start()
+
+    def stop(self):
+        self.server.stopped.set()
+        if self._thread is not None:
+            self.server.shutdown()
+            self._thread = None
+        self.server.server_close()
+
+
+_PAGE = """<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Loads</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: .3em .6em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+th { background: #eee; }
+a { cursor: pointer; color: #1f77b4; }
+#status { color: #888; }
+</style></head>
+<body>
+<h1>Loads</h1>
+<p id="status">Connecting...</p>
+<p>Agent: <select id="agent"></select>
+   Scenario: <select id="scenario"></select></p>
+<canvas id="chart" width="800" height="160"></canvas>
+<div id="tables"></div>
+<div id="queue"></div>
+<script>
+var ws = new WebSocket('ws://' + location.host + '/ws' + location.search);
+var points = [];
+var agent = document.getElementById('agent');
+var scenario = document.getElementById('scenario');
+
+function fmt(value, digits) {
+  return value === null ? '-' : value.toFixed(digits);
+}
+
+function options(select, values) {
+  var current = select.value || '';
+  select.innerHTML = '<option value="">all</option>';
+  values.sort().forEach(function (value) {
+    var option = document.createElement('option');
+    option.value = option.text = value;
+    select.appendChild(option);
+  });
+  select.value = values.indexOf(current) >= 0 ? current : '';
+}
+
+function table(title, rows) {
+  var html = '<h2>' + title + '</h2><table><tr><th></th><th>Users</th>' +
+    '<th>Requests/s</th><th>Errors (%)</th><th>p50 (ms)</th>' +
+    '<th>p95 (ms)</th><th>p99 (ms)</th></tr>';
+  rows.forEach(function (row) {
+    var stats = row[1];
+    html += '<tr><td><a data-kind="' + row[2] + '">' + row[0] +
+      '</a></td><td>' + stats.users + '</td><td>' + fmt(stats.rps, 1) +
+      '</td><td>' + fmt(stats.error_rate, 1) + '</td><td>' +
+      fmt(stats.p50, 1) + '</td><td>' + fmt(stats.p95, 1) + '</td><td>' +
+      fmt(stats.p99, 1) + '</td></tr>';
+  });
+  return html + '</table>';
+}
+
+function queue(runs) {
+  if (!runs.length) { return ''; }
+  var html = '<h2>Queue</h2><table><tr><th>Position</th><th>Run</th>' +
+    '<th>Project</th><th>Priority</th><th>Agents</th><th>Waiting (s)</th>' +
+    '</tr>';
+  runs.forEach(function (run) {
+    html += '<tr><td>' + run.position + '</td><td>' + run.run_id +
+      (run.preempted ? ' (preempted)' : '') + '</td><td>' + run.project +
+      '</td><td>' + run.priority + '</td><td>' + run.agents + '</td><td>' +
+      fmt(Date.now() / 1000 - run.queued, 0) + '</td></tr>';
+  });
+  return html + '</table>';
+}
+
+function selected(data) {
+  if (agent.value && scenario.value) {
+    var scenarios = data.agents[agent.value].scenarios;
+    return scenarios[scenario.value] || null;
+  }
+  if (agent.value) { return data.agents[agent.value].total; }
+  if (scenario.value) { return data.scenarios[scenario.value]; }
+  return data.total;
+}
+
+function draw() {
+  var canvas = document.getElementById('chart');
+  var ctx = canvas.getContext('2d');
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  [['rps', '#1f77b4'], ['p95', '#d62728']].forEach(function (line) {
+    var values = points.map(function (stats) {
+      return stats ? stats[line[0]] || 0 : 0;
+    });
+    var max = Math.max.apply(null, values.concat([1]));
+    ctx.strokeStyle = ctx.fillStyle = line[1];
+    ctx.beginPath();
+    values.forEach(function (value, index) {
+      var x = index * canvas.width / 60;
+      var y = canvas.height - 15 - value / max * (canvas.height - 30);
+      if (index) { ctx.lineTo(x, y); } else { ctx.moveTo(x, y); }
+    });
+    ctx.stroke();
+    ctx.fillText(line[0] + ' (max ' + max.toFixed(1) + ')',
+                 line[0] === 'rps' ? 5 : 150, 10);
+  });
+}
+
+function render(data) {
+  var agents = Object.keys(data.agents);
+  var scenarios = Object.keys(data.scenarios);
+  options(agent, agents);
+  options(scenario, scenarios);
+  points.push(selected(data));
+  if (points.length > 60) { points.shift(); }
+  draw();
+
+  var rows = [];
+  var html = '';
+  if (agent.value) {
+    var stats = data.agents[agent.value];
+    Object.keys(stats.scenarios).sort().forEach(function (name) {
+      if (!scenario.value || name === scenario.value) {
+        rows.push([name, stats.scenarios[name], 'scenario']);
+      }
+    });
+    html = table('Agent ' + agent.value, rows);
+  } else if (scenario.value) {
+    agents.sort().forEach(function (name) {
+      var stats = data.agents[name].scenarios[scenario.value];
+      if (stats) { rows.push([name, stats, 'agent']); }
+    });
+    html = table('Scenario ' + scenario.value, rows);
+  } else {
+    html = table('Total', [['all', data.total, '']]);
+    agents.sort().forEach(function (name) {
+      rows.push([name, data.agents[name].total, 'agent']);
+    });
+    html += table('Agents', rows);
+    rows = [];
+    scenarios.sort().forEach(function (name) {
+      rows.push([name, data.scenarios[name], 'scenario']);
+    });
+    html += table('Scenarios', rows);
+  }
+  document.getElementById('tables').innerHTML = html;
+  document.getElementById('queue').innerHTML = queue(data.queue);
+  document.getElementById('status').textContent =
+    'Last ' + data.window + ' seconds, updated ' +
+    new Date(data.time * 1000).toLocaleTimeString();
+}
+
+document.getElementById('tables').onclick = function (event) {
+  var kind = event.target.getAttribute('data-kind');
+  if (kind === 'agent') { agent.value = event.target.textContent; }
+  if (kind === 'scenario') { scenario.value = event.target.textContent; }
+  points = [];
+};
+agent.onchange = scenario.onchange = function () { points = []; };
+ws.onmessage = function (event) { render(JSON.parse(event.data)); };
+ws.onclose = function () {
+  document.getElementById('status').textContent = 'Disconnected.';
+};
+</script>
+</body></html>
+"""
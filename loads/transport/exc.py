@@ -8,6 +8,10 @@ class ExecutionError(Exception):
     pass
 
 
+class AuthenticationError(ValueError):
+    pass
+
+
 class DuplicateBrokerError(Exception):
     pass
 
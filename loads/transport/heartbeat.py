@@ -4,7 +4,7 @@ import zmq.green as zmq
 from zmq.green.eventloop import ioloop, zmqstream
 
 from loads.util import logger
-from loads.transport.util import DEFAULT_HEARTBEAT
+from loads.transport.util import DEFAULT_HEARTBEAT, connect, disconnect
 
 
 class Stethoscope(object):
@@ -57,7 +57,7 @@ class Stethoscope(object):
             self._endpoint.linger = 0
             self._stream = zmqstream.ZMQStream(self._endpoint, self.loop)
 
-        self._endpoint.connect(self.endpoint)
+        connect(self._endpoint, self.endpoint)
         self._stream.on_recv(self._handle_recv)
         self._timer = ioloop.PeriodicCallback(self._delayed,
                                               self.delay * 1000,
@@ -99,7 +99,7 @@ class Stethoscope(object):
         self.tries = 0
         self._stream.stop_on_recv()
         self._timer.stop()
-        self._endpoint.disconnect(self.endpoint)
+        disconnect(self._endpoint, self.endpoint)
 
 
 class Heartbeat(object):
@@ -0,0 +1,209 @@
+""" Prometheus metrics of the running load tests.
+
+The broker and the agents feed the results they see to a :class:`Metrics`
+instance, and serve them on */metrics* in the Prometheus text format.
+"""
+import threading
+from BaseHTTPServer import BaseHTTPRequestHandler, HTTPServer
+from datetime import timedelta
+
+from loads.util import logger, total_seconds, unbatch
+
+
+DURATION_BUCKETS = (.005, .01, .025, .05, .1, .25, .5, 1., 2.5, 5., 10.)
+
+_HELP = {
+    'loads_hits_total': ('counter', 'Requests sent by the tests.'),
+    'loads_tests_total': ('counter', 'Tests run, by result.'),
+    'loads_tests_in_flight': ('gauge', 'Tests started and not stopped.'),
+    'loads_request_duration_seconds': ('histogram', 'Request times.'),
+    'loads_socket_messages_bytes_total': ('counter',
+                                          'Bytes received by the sockets.'),
+    'loads_custom_gauge': ('gauge', 'Last values of the custom gauges.'),
+    'loads_custom_trend_sum': ('counter', 'Sums of the custom trends.'),
+    'loads_custom_trend_count': ('counter', 'Values of the custom trends.'),
+}
+
+_RESULTS = {'addSuccess': 'success', 'addFailure': 'failure',
+            'addError': 'error'}
+
+
+def parse_address(address):
+    """Returns the (host, port) of an address like "0.0.0.0:9111"."""
+    host, port = address.rsplit(':', 1)
+    return host, int(port)
+
+
+def _escape(value):
+    return str(value).replace('\\', '\\\\').replace('"', '\\"')
+
+
+def _format_labels(labels):
+    if not labels:
+        return ''
+    labels = ['%s="%s"' % (name, _escape(value)) for name, value in labels]
+    return '{%s}' % ','.join(labels)
+
+
+def _format_bucket(bucket):
+    if bucket == float('inf'):
+        return '+Inf'
+    return repr(bucket)
+
+
+class Metrics(object):
+    """The counters, gauges and histograms of the results.
+
+    :param extra: a callable returning extra gauges, as a mapping of
+                  (name, help) to their values.
+    :param agent_label: when True, the metrics have an *agent* label.
+    """
+    def __init__(self, extra=None, agent_label=True):
+        self.extra = extra
+        self.agent_label = agent_label
+        self.lock = threading.Lock()
+        self._values = {}
+        self._histograms = {}
+
+    def _labels(self, data, **labels):
+        if self.agent_label and data.get('agent_id') is not None:
+            labels['agent'] = data['agent_id']
+        return tuple(sorted(labels.items()))
+
+    def _incr(self, name, labels, value=1):
+        key = name, labels
+        self._values[key] = self._values.get(key, 0) + value
+
+    def _observe(self, labels, value):
+        buckets = self._histograms.get(labels)
+        if buckets is None:
+            buckets = self._histograms[labels] = {
+                'buckets': [0] * (len(DURATION_BUCKETS) + 1),
+                'sum': 0., 'count': 0}
+
+        for index, bucket in enumerate(DURATION_BUCKETS):
+            if value <= bucket:
+                buckets['buckets'][index] += 1
+        buckets['buckets'][-1] += 1
+        buckets['sum'] += value
+        buckets['count'] += 1
+
+    def add(self, data):
+        """Adds a message sent by the runners -- batched or not."""
+        if data.get('data_type') == 'batch':
+            messages = list(unbatch(data))
+        else:
+            messages = [(data.get('data_type'), data)]
+
+        with self.lock:
+            for data_type, message in messages:
+                self._add(data_type, message)
+
+    def _add(self, data_type, data):
+        if data_type == 'add_hit':
+            labels = self._labels(data, status=data.get('status'))
+            self._incr('loads_hits_total', labels)
+            elapsed = data.get('elapsed', 0)
+            if isinstance(elapsed, timedelta):
+                elapsed = total_seconds(elapsed)
+            self._observe(self._labels(data), elapsed)
+        elif data_type in _RESULTS:
+            labels = self._labels(data, result=_RESULTS[data_type])
+            self._incr('loads_tests_total', labels)
+        elif data_type == 'startTest':
+            self._incr('loads_tests_in_flight', self._labels(data))
+        elif data_type == 'stopTest':
+            self._incr('loads_tests_in_flight', self._labels(data), -1)
+        elif data_type == 'socket_message':
+            self._incr('loads_socket_messages_bytes_total',
+                       self._labels(data), data.get('size', 0))
+        elif data_type == 'set_gauge':
+            labels = self._labels(data, metric=data.get('name'))
+            self._values['loads_custom_gauge', labels] = data.get('value')
+        elif data_type == 'add_trend':
+            labels = self._labels(data, metric=data.get('name'))
+            self._incr('loads_custom_trend_sum', labels, data.get('value', 0))
+            self._incr('loads_custom_trend_count', labels)
+
+    def render(self):
+        """Returns the metrics in the Prometheus text format."""
+        lines = []
+        with self.lock:
+            values = sorted(self._values.items())
+            histograms = sorted(self._histograms.items())
+
+        names = sorted(set([name for (name, labels), value in values]))
+        for name in names:
+            type_, help_ = _HELP[name]
+            lines.append('# HELP %s %s' % (name, help_))
+            lines.append('# TYPE %s %s' % (name, type_))
+            for (_name, labels), value in values:
+                if _name == name:
+                    lines.append('%s%s %s' % (name, _format_labels(labels),
+                                              value))
+
+        if histograms:
+            name = 'loads_request_duration_seconds'
+            type_, help_ = _HELP[name]
+            lines.append('# HELP %s %s' % (name, help_))
+            lines.append('# TYPE %s %s' % (name, type_))
+            for labels, histogram in histograms:
+                buckets = DURATION_BUCKETS + (float('inf'),)
+                for bucket, count in zip(buckets, histogram['buckets']):
+                    bucket_labels = labels + (('le', _format_bucket(bucket)),)
+                    lines.append('%s_bucket%s %d' % (
+                        name, _format_labels(bucket_labels), count))
+                lines.append('%s_sum%s %s' % (name, _format_labels(labels),
+                                              repr(histogram['sum'])))
+                lines.append('%s_count%s %d' % (name, _format_labels(labels),
+                                                histogram['count']))
+
+        if self.extra is not None:
+            for (name, help_), value in sorted(self.extra().items()):
+                lines.append('# HELP %s %s' % (name, help_))
+                lines.append('# TYPE %s gauge' % name)
+                lines.append('%s %s' % (name, value))
+
+        return '\n'.join(lines) + '\n'
+
+
+class _Handler(BaseHTTPRequestHandler):
+
+    def do_GET(self):
+        if self.path.split('?')[0] != '/metrics':
+            self.send_error(404)
+            return
+
+        body = self.server.metrics.render()
+        self.send_response(200)
+        self.send_header('Content-Type', 'text/plain; version=0.0.4')
+        self.send_header('Content-Length', str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+class MetricsServer(object):
+    """Serves the metrics on http://address/metrics, in a thread."""
+
+    def __init__(self, metrics, address):
+        self.metrics = metrics
+        self.server = HTTPServer(parse_address(address), _Handler)
+        self.server.metrics = metrics
+        self.address = '%s:%d' % self.server.server_address
+        self._thread = None
+
+    def start(self):
+        logger.info('Serving the metrics at http://%s/metrics' %
+                    self.address)
+        self._thread = threading.Thread(target=self.server.serve_forever)
+        self._thread.daemon = True
+        self._thread.start()
+
+    def stop(self):
+        if self._thread is not None:
+            self.server.shutdown()
+            self._thread = None
+        self.server.server_close()
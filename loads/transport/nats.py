@@ -0,0 +1,306 @@
+""" A small NATS client, enough to carry the results of the runners to the
+broker through a JetStream stream.
+
+The runners publish every batch of results, and wait until JetStream has
+stored it -- publishing it again if needed, with the same message id so the
+stream drops the copies. The broker pulls the results from a durable
+consumer and acknowledges them once they are saved, so what is not
+acknowledged yet is delivered again -- to the same broker once it is back,
+or to its standby once it took over.
+"""
+import itertools
+from uuid import uuid4
+
+import gevent
+from gevent import socket
+
+from loads.util import logger, json
+from loads.transport.util import split_endpoints
+
+
+DEFAULT_NATS_PORT = 4222
+DEFAULT_NATS_TIMEOUT = 5.
+DEFAULT_NATS_STREAM = 'LOADS'
+DEFAULT_NATS_SUBJECT = 'loads.results'
+DEFAULT_NATS_BATCH = 100
+_CONSUMER = 'loads-broker'
+_STREAM_IN_USE = 10058
+_CRLF = '\r\n'
+
+
+class NATSError(Exception):
+    pass
+
+
+class NATSTimeout(NATSError):
+    pass
+
+
+class JetStreamError(NATSError):
+    def __init__(self, message, code=None):
+        super(JetStreamError, self).__init__(message)
+        self.code = code
+
+
+def _get_address(server):
+    address = server.split('://', 1)[-1]
+    if ':' in address:
+        host, port = address.rsplit(':', 1)
+        return host, int(port)
+    return address, DEFAULT_NATS_PORT
+
+
+def _get_status(headers):
+    """Returns the status code of the headers of a message, if any."""
+    line = headers.split(_CRLF, 1)[0].split()
+    if len(line) > 1:
+        return line[1]
+    return None
+
+
+class NATSClient(object):
+    """Talks to one of the NATS servers of a comma-separated list, like
+    "nats://10.0.0.1:4222,nats://10.0.0.2:4222"."""
+
+    def __init__(self, servers, timeout=DEFAULT_NATS_TIMEOUT):
+        self.servers = split_endpoints(servers)
+        self.timeout = timeout
+        self._sock = None
+        self._buffer = ''
+        self._sids = itertools.count(1)
+
+    def connect(self):
+        errors = []
+        for server in self.servers:
+            try:
+                self._sock = socket.create_connection(_get_address(server),
+                                                      self.timeout)
+                break
+            except socket.error, e:
+                errors.append('%s: %s' % (server, e))
+        else:
+            raise NATSError('Could not connect to NATS (%s)' %
+                            ', '.join(errors))
+
+        self._buffer = ''
+        line = self._readline()
+        if not line.startswith('INFO'):
+            raise NATSError('Unexpected greeting %r' % line)
+
+        options = {'verbose': False, 'pedantic': False, 'headers': True,
+                   'name': 'loads'}
+        self._send('CONNECT %s%sPING%s' % (json.dumps(options), _CRLF, _CRLF))
+        while self._readline() != 'PONG':
+            pass
+
+    def close(self):
+        if self._sock is not None:
+            self._sock.close()
+            self._sock = None
+
+    def _send(self, data):
+        try:
+            self._sock.sendall(data)
+        except socket.error, e:
+            raise NATSError(str(e))
+
+    def _recv(self):
+        try:
+            data = self._sock.recv(65536)
+        except socket.timeout:
+            raise NATSTimeout('NATS did not answer in time')
+        except socket.error, e:
+            raise NATSError(str(e))
+        if not data:
+            raise NATSError('NATS closed the connection')
+        self._buffer += data
+
+    def _readline(self):
+        while _CRLF not in self._buffer:
+            self._recv()
+        line, self._buffer = self._buffer.split(_CRLF, 1)
+
+        if line.startswith('-ERR'):
+            raise NATSError(line[4:].strip(" '"))
+        return line
+
+    def _read(self, size):
+        while len(self._buffer) < size + len(_CRLF):
+            self._recv()
+        data = self._buffer[:size]
+        self._buffer = self._buffer[size + len(_CRLF):]
+        return data
+
+    def _next_message(self):
+        """Returns the sid, the reply subject, the headers and the payload of
+        the next message."""
+        while True:
+            line = self._readline()
+            if line.startswith('MSG '):
+                args = line.split()[1:]
+                reply = len(args) == 4 and args[2] or None
+                return args[1], reply, '', self._read(int(args[-1]))
+            elif line.startswith('HMSG '):
+                args = line.split()[1:]
+                reply = len(args) == 5 and args[2] or None
+                data = self._read(int(args[-1]))
+                size = int(args[-2])
+                return args[1], reply, data[:size], data[size:]
+            elif line == 'PING':
+                self._send('PONG' + _CRLF)
+            # +OK, PONG and INFO need nothing
+
+    def publish(self, subject, payload, reply=None, msg_id=None):
+        reply = reply and ' ' + reply or ''
+        if msg_id is None:
+            self._send('PUB %s%s %d%s%s%s' % (subject, reply, len(payload),
+                                              _CRLF, payload, _CRLF))
+            return
+
+        headers = 'NATS/1.0%sNats-Msg-Id: %s%s%s' % (_CRLF, msg_id, _CRLF,
+                                                     _CRLF)
+        self._send('HPUB %s%s %d %d%s%s%s%s' % (
+            subject, reply, len(headers), len(headers) + len(payload), _CRLF,
+            headers, payload, _CRLF))
+
+    def subscribe(self, subject):
+        sid = str(self._sids.next())
+        self._send('SUB %s %s%s' % (subject, sid, _CRLF))
+        return sid
+
+    def unsubscribe(self, sid):
+        self._send('UNSUB %s%s' % (sid, _CRLF))
+
+    def _messages(self, subject, payload, msg_id=None):
+        # the messages sent back to a new inbox
+        inbox = '_INBOX.%s' % uuid4().hex
+        sid = self.subscribe(inbox)
+        try:
+            self.publish(subject, payload, reply=inbox, msg_id=msg_id)
+            while True:
+                msg_sid, reply, headers, data = self._next_message()
+                if msg_sid == sid:
+                    yield reply, headers, data
+        finally:
+            if self._sock is not None:
+                self.unsubscribe(sid)
+
+    def request(self, subject, payload, msg_id=None):
+        messages = self._messages(subject, payload, msg_id)
+        try:
+            return messages.next()[2]
+        finally:
+            messages.close()
+
+    def _check(self, answer):
+        res = json.loads(answer)
+        if 'error' in res:
+            raise JetStreamError(res['error'].get('description'),
+                                 res['error'].get('err_code'))
+        return res
+
+    def jetstream(self, api, **options):
+        """Calls the JetStream API, and returns its answer."""
+        return self._check(self.request('$JS.API.' + api,
+                                        json.dumps(options)))
+
+    def store(self, subject, payload, msg_id=None):
+        """Publishes to the subject of a stream, and returns once JetStream
+        stored the message."""
+        return self._check(self.request(subject, payload, msg_id=msg_id))
+
+    def fetch(self, stream, consumer, batch=DEFAULT_NATS_BATCH, expires=1.):
+        """Pulls up to *batch* messages of a consumer, waiting *expires*
+        seconds at most, and returns their payloads and ack subjects."""
+        subject = '$JS.API.CONSUMER.MSG.NEXT.%s.%s' % (stream, consumer)
+        pull = json.dumps({'batch': batch, 'expires': int(expires * 1e9)})
+        messages, pulled = [], self._messages(subject, pull)
+        try:
+            for reply, headers, data in pulled:
+                # a status, like "404 No Messages" or "408 Request Timeout"
+                if headers and _get_status(headers) is not None:
+                    break
+                messages.append((data, reply))
+                if len(messages) == batch:
+                    break
+        finally:
+            pulled.close()
+        return messages
+
+    def ack(self, reply):
+        self.publish(reply, '+ACK')
+
+
+class ResultsConsumer(object):
+    """Pulls the results of the runners from JetStream, and gives their
+    payloads one by one to *callback*.
+
+    The stream and the durable consumer are created if needed.
+    """
+    def __init__(self, servers, callback, stream=DEFAULT_NATS_STREAM,
+                 subject=DEFAULT_NATS_SUBJECT, batch=DEFAULT_NATS_BATCH,
+                 retry_delay=1.):
+        self.servers = servers
+        self.callback = callback
+        self.stream = stream
+        self.subject = subject
+        self.batch = batch
+        self.retry_delay = retry_delay
+        self._greenlet = None
+
+    def _setup(self):
+        client = NATSClient(self.servers)
+        client.connect()
+        try:
+            client.jetstream('STREAM.CREATE.' + self.stream, name=self.stream,
+                             subjects=[self.subject + '.>'],
+                             retention='workqueue', storage='file')
+        except JetStreamError, e:
+            if e.code != _STREAM_IN_USE:
+                client.close()
+                raise
+        client.jetstream('CONSUMER.DURABLE.CREATE.%s.%s' % (self.stream,
+                                                            _CONSUMER),
+                         stream_name=self.stream,
+                         config={'durable_name': _CONSUMER,
+                                 'ack_policy': 'explicit',
+                                 'deliver_policy': 'all'})
+        return client
+
+    def consume(self, client):
+        for payload, reply in client.fetch(self.stream, _CONSUMER,
+                                           self.batch):
+            try:
+                self.callback(payload)
+            except Exception:
+                # delivering it again would not help
+                logger.exception('Could not handle a result from NATS')
+            client.ack(reply)
+
+    def _run(self):
+        client = None
+        try:
+            while True:
+                try:
+                    if client is None:
+                        client = self._setup()
+                    self.consume(client)
+                except NATSError, e:
+                    logger.error('Could not get the results from NATS: %s' %
+                                 e)
+                    if client is not None:
+                        client.close()
+                        client = None
+                    gevent.sleep(self.retry_delay)
+        finally:
+            if client is not None:
+                client.close()
+
+    def start(self):
+        logger.info('Pulling the results from NATS at %r' % self.servers)
+        self._greenlet = gevent.spawn(self._run)
+
+    def stop(self):
+        if self._greenlet is not None:
+            self._greenlet.kill()
+            self._greenlet = None
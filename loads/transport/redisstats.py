@@ -0,0 +1,262 @@
+""" The live stats kept in Redis, so the brokers -- the active one and its
+standby -- their dashboards and the runners share them, without a database.
+
+For every run, Redis has:
+
+- **<prefix>:<run>:<second>**: the hits, the errors and the histogram of
+  every agent and scenario during that second, until they are out of the
+  window.
+- **<prefix>:<run>:users**: the tests running on every agent, per scenario.
+- **<prefix>:<run>:totals**: the counters and the histogram of every
+  scenario since the start of the run, that the thresholds are evaluated
+  on.
+
+and **<prefix>:runs** has the project of every run, and when it was last
+updated.
+"""
+import time
+
+from loads.histogram import Histogram
+from loads.transport.dashboard import (LiveStats, WINDOW, _get_scenario,
+                                       _get_hit, _snapshot)
+from loads.transport.metrics import parse_address
+from loads.util import json, try_import, unbatch
+
+
+DEFAULT_REDIS = 'localhost:6379'
+DEFAULT_PREFIX = 'loads:live'
+# the totals of a run are kept for a day after its last result
+TOTALS_TTL = 24 * 3600
+_SEP = '\t'
+
+
+def _new_totals():
+    return {'hits': 0, 'errors': 0, 'tests': 0, 'failures': 0,
+            'started': None, 'updated': None, 'histogram': Histogram()}
+
+
+def _dump_totals(totals):
+    dumped = dict(totals)
+    dumped['histogram'] = totals['histogram'].to_dict()
+    return json.dumps(dumped)
+
+
+def _load_totals(dumped):
+    totals = json.loads(dumped)
+    totals['histogram'] = Histogram.from_dict(totals['histogram'])
+    return totals
+
+
+def _merge_totals(totals, other):
+    for counter in ('hits', 'errors', 'tests', 'failures'):
+        totals[counter] += other[counter]
+    if other['started'] is not None:
+        totals['started'] = min(totals['started'] or other['started'],
+                                other['started'])
+    totals['updated'] = max(totals['updated'], other['updated'])
+    totals['histogram'].add(other['histogram'])
+
+
+class RedisLiveStats(LiveStats):
+    """The rolling stats of the running tests, and the totals of the runs,
+    kept in Redis.
+
+    :param address: the host:port of Redis.
+    :param window: the number of seconds the stats are computed on.
+    :param clock: returns the current time.
+    :param prefix: the prefix of the keys.
+    """
+    shared = True
+
+    def __init__(self, address=DEFAULT_REDIS, window=WINDOW, clock=time.time,
+                 prefix=DEFAULT_PREFIX):
+        super(RedisLiveStats, self).__init__(window, clock)
+        try_import('redis')
+        import redis
+
+        host, port = parse_address(address)
+        self._redis = redis.StrictRedis(host=host, port=port)
+        self.prefix = prefix
+        # {run: {second: {(agent, scenario): [hits, errors, histogram]}}}
+        self._seconds = {}
+        # {run: {(agent, scenario): tests started and not stopped}}
+        self._run_users = {}
+        # {run: {scenario: totals}}
+        self._totals = {}
+
+    def _key(self, *parts):
+        return ':'.join([self.prefix] + [str(part) for part in parts])
+
+    def add(self, data, project=None):
+        """Adds a message sent by the runners -- batched or not -- for a
+        run of *project*, and writes what changed to Redis."""
+        if data.get('data_type') == 'batch':
+            messages = list(unbatch(data))
+        else:
+            messages = [(data.get('data_type'), data)]
+
+        run_id = data.get('run_id')
+        if run_id is None:
+            return
+
+        now = self.clock()
+        with self.lock:
+            changed = [self._add_message(run_id, data_type, message, now)
+                       for data_type, message in messages]
+            if any(changed):
+                self._write(run_id, project, now)
+            self._prune_runs(now)
+
+    def _add_message(self, run_id, data_type, data, now):
+        key = str(data.get('agent_id')), _get_scenario(data)
+
+        if data_type == 'add_hit':
+            failed, elapsed = _get_hit(data)
+            seconds = self._seconds.setdefault(run_id, {})
+            buckets = seconds.setdefault(int(now), {})
+            if key not in buckets:
+                buckets[key] = [0, 0, Histogram()]
+            bucket = buckets[key]
+            bucket[0] += 1
+            if failed:
+                bucket[1] += 1
+            bucket[2].record_value(elapsed)
+
+            totals = self._get_totals(run_id, key[1], now)
+            totals['hits'] += 1
+            if failed:
+                totals['errors'] += 1
+            totals['histogram'].record_value(elapsed)
+        elif data_type == 'startTest':
+            users = self._run_users.setdefault(run_id, {})
+            users[key] = users.get(key, 0) + 1
+        elif data_type == 'stopTest':
+            users = self._run_users.setdefault(run_id, {})
+            users[key] = max(users.get(key, 0) - 1, 0)
+            self._get_totals(run_id, key[1], now)['tests'] += 1
+        elif data_type in ('addFailure', 'addError'):
+            self._get_totals(run_id, key[1], now)['failures'] += 1
+        else:
+            return False
+        return True
+
+    def _get_totals(self, run_id, scenario, now):
+        if run_id not in self._totals:
+            # another broker may have started the run
+            self._totals[run_id] = self._read_totals(run_id)
+
+        totals = self._totals[run_id].setdefault(scenario, _new_totals())
+        if totals['started'] is None:
+            totals['started'] = now
+        totals['updated'] = now
+        return totals
+
+    def _read_totals(self, run_id):
+        dumped = self._redis.hgetall(self._key(run_id, 'totals'))
+        return dict([(scenario, _load_totals(totals))
+                     for scenario, totals in dumped.items()])
+
+    def _write(self, run_id, project, now):
+        pipeline = self._redis.pipeline()
+
+        second = int(now)
+        buckets = self._seconds.get(run_id, {}).get(second, {})
+        if buckets:
+            key = self._key(run_id, second)
+            for (agent, scenario), (hits, errors, histogram) in \
+                    buckets.items():
+                pipeline.hset(key, agent + _SEP + scenario,
+                              json.dumps([hits, errors, histogram.to_dict()]))
+            pipeline.expire(key, self.window + 1)
+
+        key = self._key(run_id, 'users')
+        for (agent, scenario), count in \
+                self._run_users.get(run_id, {}).items():
+            pipeline.hset(key, agent + _SEP + scenario, count)
+        pipeline.expire(key, TOTALS_TTL)
+
+        key = self._key(run_id, 'totals')
+        for scenario, totals in self._totals.get(run_id, {}).items():
+            pipeline.hset(key, scenario, _dump_totals(totals))
+        pipeline.expire(key, TOTALS_TTL)
+
+        pipeline.hset(self._key('runs'), run_id,
+                      json.dumps({'project': project, 'updated': now}))
+        pipeline.execute()
+
+    def _prune_runs(self, now):
+        oldest = int(now) - self.window
+        for run_id, seconds in self._seconds.items():
+            for second in list(seconds):
+                if second <= oldest:
+                    del seconds[second]
+            if not seconds:
+                del self._seconds[run_id]
+
+        # what is written again is read back from Redis first
+        for run_id, scenarios in self._totals.items():
+            updated = max([totals['updated']
+                           for totals in scenarios.values()] or [0])
+            if updated <= oldest:
+                del self._totals[run_id]
+
+        for run_id, users in self._run_users.items():
+            if not any(users.values()):
+                del self._run_users[run_id]
+
+    def _get_runs(self, now):
+        """Returns the runs updated in the window, and their project."""
+        runs = {}
+        for run_id, info in self._redis.hgetall(self._key('runs')).items():
+            info = json.loads(info)
+            if info['updated'] < now - TOTALS_TTL:
+                self._redis.hdel(self._key('runs'), run_id)
+            elif info['updated'] > int(now) - self.window:
+                runs[run_id] = info.get('project')
+        return runs
+
+    def snapshot(self, project=None, agents=None):
+        """Returns the stats of the running tests of every broker writing
+        to Redis -- see :meth:`LiveStats.snapshot`."""
+        now = self.clock()
+        with self.lock:
+            queue = list(self._queue)
+
+        buckets, users = {}, {}
+        for run_id, run_project in self._get_runs(now).items():
+            if project is not None and run_project != project:
+                continue
+
+            for second in range(int(now) - self.window + 1, int(now) + 1):
+                for field, dumped in self._redis.hgetall(
+                        self._key(run_id, second)).items():
+                    key = tuple(field.split(_SEP, 1))
+                    hits, errors, histogram = json.loads(dumped)
+                    buckets.setdefault(key, []).append(
+                        (hits, errors, Histogram.from_dict(histogram)))
+
+            for field, count in self._redis.hgetall(
+                    self._key(run_id, 'users')).items():
+                key = tuple(field.split(_SEP, 1))
+                users[key] = users.get(key, 0) + int(count)
+
+        if project is not None:
+            queue = [entry for entry in queue
+                     if entry.get('project') == project]
+
+        keys = set(buckets) | set([key for key, count in users.items()
+                                   if count])
+        if agents is not None:
+            keys = set([key for key in keys if key[0] in agents])
+        return _snapshot(now, self.window, keys, buckets, users, queue)
+
+    def get_totals(self, run_id):
+        """Returns the totals of a run, and of every scenario: the hits,
+        the errors among them, the finished tests, the failures among them,
+        the histogram of the hits and when the first and the last results
+        came."""
+        scenarios = self._read_totals(run_id)
+        total = _new_totals()
+        for totals in scenarios.values():
+            _merge_totals(total, totals)
+        return {'total': total, 'scenarios': scenarios}
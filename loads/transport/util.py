@@ -5,12 +5,13 @@ import threading
 import atexit
 import time
 import os
+import signal
 import socket
 
 import zmq.green as zmq
 
 from loads.transport.exc import TimeoutError
-from loads.util import logger
+from loads.util import logger, split_endpoint
 
 DEFAULT_FRONTEND = "ipc:///tmp/loads-front.ipc"
 DEFAULT_SSH_FRONTEND = "tcp://127.0.0.1:7780"
@@ -23,11 +24,26 @@ DEFAULT_BROKER_RECEIVER = "ipc:///tmp/loads-broker-receiver.ipc"
 
 
 DEFAULT_AGENT_TIMEOUT = 60.
+DEFAULT_CHECK_INTERVAL = 2.5
 DEFAULT_TIMEOUT = 5.
 DEFAULT_TIMEOUT_MOVF = 20.
 DEFAULT_TIMEOUT_OVF = 1
 DEFAULT_MAX_AGE = -1
 DEFAULT_MAX_AGE_DELTA = 0
+
+# the signals the agents send to the runners, to pause, resume or abort
+# their runs, or to make them read the new load in LOAD_FILE
+PAUSE_SIGNAL = signal.SIGUSR1
+RESUME_SIGNAL = signal.SIGUSR2
+ABORT_SIGNAL = signal.SIGINT
+LOAD_SIGNAL = signal.SIGHUP
+LOAD_FILE = 'loads-load.json'
+
+# how often a broker sends its state to its standby, and how long the
+# standby waits without news before taking over
+DEFAULT_STATE_INTERVAL = 1.
+DEFAULT_FAILOVER_TIMEOUT = 10.
+
 _IPC_FILES = []
 PARAMS = {}
 
@@ -44,6 +60,54 @@ def register_ipc_file(file):
     _IPC_FILES.append(file)
 
 
+def split_endpoints(endpoints):
+    """Returns the list of endpoints of a comma-separated string -- the ones
+    of a broker and of its standby."""
+    return [endpoint.strip() for endpoint in endpoints.split(',')
+            if endpoint.strip()]
+
+
+def join_endpoints(*endpoints):
+    """Merges lists of endpoints in a comma-separated string."""
+    merged = []
+    for endpoint in endpoints:
+        if not endpoint:
+            continue
+        for item in split_endpoints(endpoint):
+            if item not in merged:
+                merged.append(item)
+    return ','.join(merged)
+
+
+def resolve_endpoint(endpoint, broker):
+    """Replaces the 0.0.0.0 of a TCP endpoint by the ip of the broker."""
+    if endpoint.startswith('tcp'):
+        splitted = split_endpoint(endpoint)
+        if splitted['ip'] == '0.0.0.0':
+            broker_ip = split_endpoint(split_endpoints(broker)[0])['ip']
+            return 'tcp://%s:%d' % (broker_ip, splitted['port'])
+    return endpoint
+
+
+def connect(socket, endpoints):
+    """Connects the socket to a comma-separated list of endpoints.
+
+    With several endpoints, the messages only go to the peers that are
+    really there, so the standby of a broker gets nothing until it takes
+    over.
+    """
+    endpoints = split_endpoints(endpoints)
+    if len(endpoints) > 1:
+        socket.setsockopt(zmq.IMMEDIATE, 1)
+    for endpoint in endpoints:
+        socket.connect(endpoint)
+
+
+def disconnect(socket, endpoints):
+    for endpoint in split_endpoints(endpoints):
+        socket.disconnect(endpoint)
+
+
 def send(socket, msg, max_retries=3, retry_sleep=0.1):
     retries = 0
     while retries < max_retries:
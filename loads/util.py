@@ -1,15 +1,18 @@
+import calendar
 import datetime
 import ujson as json    # NOQA
 import json as _json
 import logging
 import logging.handlers
 import os
+import re
 import sys
 import urlparse
 import math
 import fnmatch
 import random
 import zipfile
+import zlib
 from cStringIO import StringIO
 import hashlib
 
@@ -86,7 +89,7 @@ def split_endpoint(endpoint):
 _DNS_CACHE = {}
 
 
-def dns_resolve(url):
+def dns_resolve(url, family=None):
     """Resolve hostname in the given url, using cached results where possible.
 
     Given a url, this function does DNS resolution on the contained hostname
@@ -96,8 +99,14 @@ def dns_resolve(url):
     The results of DNS resolution are cached to make sure this doesn't become
     a bottleneck for the loadtest.  If the hostname resolves to multiple
     addresses then a random address is chosen.
+
+    With :param family: -- "ipv4" or "ipv6" -- the addresses are the ones
+    of this family, see loads.dualstack.
     """
     parts = urlparse.urlparse(url)
+    if family is not None:
+        return _resolve_family(parts, family)
+
     netloc = parts.netloc.rsplit(':')
     if len(netloc) == 1:
         netloc.append('80')
@@ -121,6 +130,25 @@ def dns_resolve(url):
     return urlparse.urlunparse(parts), original, resolved
 
 
+def _resolve_family(parts, family):
+    from loads.dualstack import get_addresses
+
+    original = parts.hostname
+    addrs = _DNS_CACHE.get((original, family))
+    if addrs is None:
+        addrs = [sockaddr[0] for _, sockaddr in get_addresses(original, None,
+                                                              family)]
+        _DNS_CACHE[(original, family)] = addrs
+
+    resolved = random.choice(addrs)
+    if ':' in original:
+        original = '[%s]' % original
+    host = ':' in resolved and '[%s]' % resolved or resolved
+    netloc = '%s:%s' % (host, parts.port or 80)
+    parts = (parts.scheme, netloc) + parts[2:]
+    return urlparse.urlunparse(parts), original, resolved
+
+
 # taken from distutils2
 def resolve_name(name):
     """Resolve a name like ``module.object`` to an object and return it.
@@ -366,10 +394,161 @@ def seconds_to_time(seconds, loose=False):
     return _join()
 
 
+_DURATION_UNITS = {'s': 1, 'm': 60, 'h': 3600, 'd': 86400}
+
+
+def parse_duration(value):
+    """Converts a duration like "90", "30s", "2m", "1h" or "7d" in
+    seconds."""
+    value = str(value).strip()
+    if value == '':
+        raise ValueError('Empty duration')
+
+    unit = value[-1].lower()
+    if unit in _DURATION_UNITS:
+        value = value[:-1]
+    else:
+        unit = 's'
+
+    return float(value) * _DURATION_UNITS[unit]
+
+
+def parse_stages(value):
+    """Parses a load shape, given as a comma-separated list of stages.
+
+    Each stage is written *duration:users*, where *users* is the number of
+    users to reach at the end of the stage. For example, "2m:100,10m:100,2m:0"
+    ramps up to 100 users in 2 minutes, holds 100 users during 10 minutes then
+    ramps down to 0 in 2 minutes.
+
+    Returns a list of (duration in seconds, users) tuples.
+    """
+    stages = []
+    for stage in value.split(','):
+        stage = stage.strip()
+        if stage == '':
+            continue
+        try:
+            duration, users = stage.split(':')
+            stages.append((parse_duration(duration), int(users)))
+        except ValueError:
+            raise ValueError('Invalid stage %r' % stage)
+
+    if len(stages) == 0:
+        raise ValueError('No stages found in %r' % value)
+
+    return stages
+
+
+_ISO_DATE = re.compile(r'^(\d{4})-(\d\d)-(\d\d)[T ](\d\d):(\d\d):(\d\d)'
+                       r'(\.\d+)?(Z|[+-]\d\d:?\d\d)?$')
+_LOG_DATE = re.compile(r'^(\d\d)/(\w{3})/(\d{4}):(\d\d):(\d\d):(\d\d)()'
+                       r'(?: ([+-]\d{4}))?$')
+_MONTHS = dict([(month, index + 1) for index, month in enumerate(
+    ('Jan', 'Feb', 'Mar', 'Apr', 'May', 'Jun', 'Jul', 'Aug', 'Sep', 'Oct',
+     'Nov', 'Dec'))])
+
+
+def parse_timestamp(value):
+    """Returns the timestamp of a date written in ISO 8601, like
+    "2013-05-14T00:51:08.5+02:00", or like in the access logs, like
+    "14/May/2013:00:51:08 +0200". The dates without a timezone are UTC."""
+    value = value.strip()
+    match = _ISO_DATE.match(value)
+    if match is not None:
+        year, month, day, hour, minute, second, fraction, zone = \
+            match.groups()
+    else:
+        match = _LOG_DATE.match(value)
+        if match is None or match.group(2) not in _MONTHS:
+            raise ValueError('Invalid date %r' % value)
+        day, month, year, hour, minute, second, fraction, zone = \
+            match.groups()
+        month = _MONTHS[month]
+
+    timestamp = calendar.timegm((int(year), int(month), int(day), int(hour),
+                                 int(minute), int(second)))
+    if fraction:
+        timestamp += float(fraction)
+    if zone and zone != 'Z':
+        offset = zone.replace(':', '')
+        minutes = int(offset[1:3]) * 60 + int(offset[3:5])
+        if offset[0] == '+':
+            timestamp -= minutes * 60
+        else:
+            timestamp += minutes * 60
+    return timestamp
+
+
+_ID_SEGMENTS = ((re.compile(r'^\d+$'), '{id}'),
+                (re.compile(r'^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-'
+                            r'[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$'), '{uuid}'),
+                (re.compile(r'^[0-9a-fA-F]{16,}$'), '{hash}'))
+
+
+def get_url_pattern(url):
+    """Returns the URL without its query string, and with the ids of its
+    path replaced by placeholders -- e.g. "http://api/users/{id}" for
+    "http://api/users/42?full=1" -- so the requests to the same resource
+    can be grouped.
+    """
+    parts = urlparse.urlsplit(url)
+    segments = []
+    for segment in parts.path.split('/'):
+        for pattern, placeholder in _ID_SEGMENTS:
+            if pattern.match(segment):
+                segment = placeholder
+                break
+        segments.append(segment)
+    return urlparse.urlunsplit((parts.scheme, parts.netloc,
+                                '/'.join(segments), '', ''))
+
+
+def parse_tags(value):
+    """Returns the dict of tags given like "region=eu-west,az=eu-west-1a"."""
+    tags = {}
+    for tag in (value or '').split(','):
+        if '=' in tag:
+            name, value_ = tag.split('=', 1)
+            tags[name.strip()] = value_.strip()
+    return tags
+
+
 def unbatch(data):
     for field, messages in data['counts'].items():
         for message in messages:
             message['agent_id'] = data['agent_id']
             if 'run_id' in data:
                 message['run_id'] = data['run_id']
-            yield field, message
+
+            if field == 'add_hits':
+                # the hits summarized by a relay the broker did not keep up
+                # with, see ZMQSummarizedTestResult
+                for hit in _expand_hits(message):
+                    yield 'add_hit', hit
+            else:
+                yield field, message
+
+
+# the fields of the summarized hits that are lists, one item per hit
+SUMMARIZED_FIELDS = ('elapsed', 'body_size', 'wire_size')
+
+
+def _expand_hits(summary):
+    hit = dict([(key, value) for key, value in summary.items()
+                if key not in SUMMARIZED_FIELDS])
+    for index, elapsed in enumerate(summary['elapsed']):
+        hit['elapsed'] = elapsed
+        for field in SUMMARIZED_FIELDS[1:]:
+            if field in summary:
+                hit[field] = summary[field][index]
+        yield dict(hit)
+
+
+def unpack_message(payload):
+    """Returns the JSON of a message sent by a relay, which may have
+    compressed it."""
+    # a JSON mapping starts with "{", a zlib stream never does
+    if payload[:1] != '{':
+        return zlib.decompress(payload)
+    return payload
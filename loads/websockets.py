@@ -1,3 +1,5 @@
+import time
+
 import gevent
 from collections import defaultdict
 from socket import error
@@ -15,6 +17,28 @@ class WebSocketClient(_WS):
         self.callback = callback
         self._test_result = test_result
         self.test_case = test_case
+        self._connect_started = None
+        self._closing = False
+
+    def connect(self):
+        self._connect_started = time.time()
+        super(WebSocketClient, self).connect()
+
+    def send_receive(self, payload, binary=False, timeout=None):
+        """Sends a message and waits for the next message received.
+
+        The round-trip time is reported to the test result. If no
+        message is received after :param timeout: seconds, a
+        :class:`gevent.Timeout` is raised.
+        """
+        started = time.time()
+        self.send(payload, binary)
+        with gevent.Timeout(timeout):
+            message = self.receive()
+
+        if self._test_result is not None:
+            self._test_result.socket_rtt(time.time() - started)
+        return message
 
     def received_message(self, m):
         if self.callback is not None:
@@ -25,16 +49,28 @@ class WebSocketClient(_WS):
 
     def opened(self):
         if self._test_result is not None:
-            self._test_result.socket_open()
+            elapsed = None
+            if self._connect_started is not None:
+                elapsed = time.time() - self._connect_started
+            self._test_result.socket_open(elapsed)
         super(WebSocketClient, self).opened()
 
     def close(self, code=1000, reason=''):
         if self.client_terminated:
             return
+        self._closing = True
         if self._test_result is not None:
             self._test_result.socket_close()
         super(WebSocketClient, self).close(code, reason)
 
+    def closed(self, code, reason=None):
+        # the socket was closed without us asking for it.
+        if not self._closing:
+            self._closing = True
+            if self._test_result is not None:
+                self._test_result.socket_disconnect(code)
+        super(WebSocketClient, self).closed(code, reason)
+
 
 def cleanup(greenlet):
     for sock in _SOCKETS[id(greenlet)]:
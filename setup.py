@@ -40,4 +40,12 @@ setup(name='loads',
       loads-broker = loads.transport.broker:main
       loads-agent  = loads.transport.agent:main
       loads-runner  = loads.main:main
+      loads-report  = loads.report:main
+      loads-compare  = loads.compare:main
+      loads-launch  = loads.launch:main
+      loads-import  = loads.importer:main
+      loads-record  = loads.record:main
+      loads-top  = loads.top:main
+      loads-check  = loads.check:main
+      loads-prune  = loads.db.retention:main
       """)